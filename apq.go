@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// The .apq format is ap-query's aggregated model of a profile: the stacks
+// of every event with their line numbers, threads and weights, plus the
+// recording metadata commands report (span, cpu interval, profiler
+// settings, truncated weight). It reads back far faster than the JFR it
+// came from and is a fraction of its size, so a baseline can be archived
+// or shipped between machines without the raw recording. Every command
+// reads it (recognized by its magic line, whatever the file name);
+// collapse --apq writes it. Per-sample timestamps are not kept, so
+// --from/--to and timeline need the original JFR.
+//
+// Layout: the line "APQ1\n", then gzip-compressed JSON (apqFile). Frames
+// and threads are stored once in tables and referenced by index.
+
+const (
+	apqMagic   = "APQ1\n"
+	apqVersion = 1
+)
+
+type apqFile struct {
+	Version       int                 `json:"version"`
+	Source        string              `json:"source,omitempty"`
+	OriginNanos   int64               `json:"originNanos,omitempty"`
+	SpanNanos     int64               `json:"spanNanos,omitempty"`
+	CPUInterval   int64               `json:"cpuInterval,omitempty"`
+	ExecEventName string              `json:"execEventName,omitempty"`
+	Settings      map[string]string   `json:"settings,omitempty"`
+	Frames        []string            `json:"frames"`
+	Threads       []string            `json:"threads,omitempty"`
+	Events        map[string]apqEvent `json:"events"`
+}
+
+type apqEvent struct {
+	Samples   int        `json:"samples"` // event weight before filtering, as eventCounts
+	Truncated int        `json:"truncated,omitempty"`
+	Stacks    []apqStack `json:"stacks"`
+}
+
+type apqStack struct {
+	Frames []int32  `json:"f"`           // indices into apqFile.Frames, root first
+	Lines  []uint32 `json:"l,omitempty"` // parallel to Frames; omitted when unknown
+	Thread int32    `json:"t,omitempty"` // 1-based index into apqFile.Threads; 0 = none
+	TID    string   `json:"tid,omitempty"`
+	Count  int      `json:"n"`
+}
+
+// isAPQ reports whether data starts like an .apq file.
+func isAPQ(head []byte) bool {
+	return bytes.HasPrefix(head, []byte(apqMagic))
+}
+
+// writeAPQ writes byEvent and the metadata of parsed (nil for collapsed
+// input) as an .apq file. source names the original input.
+func writeAPQ(w io.Writer, source string, parsed *parsedProfile, byEvent map[string]*stackFile) error {
+	f := apqFile{Version: apqVersion, Source: source, Events: make(map[string]apqEvent, len(byEvent))}
+	if parsed != nil {
+		f.OriginNanos, f.SpanNanos = parsed.originNanos, parsed.spanNanos
+		f.CPUInterval, f.ExecEventName = parsed.cpuInterval, parsed.execEventName
+		f.Settings = parsed.settings
+	}
+	frameIdx := make(map[string]int32)
+	threadIdx := make(map[string]int32)
+	events := make([]string, 0, len(byEvent))
+	for event, sf := range byEvent {
+		if len(sf.stacks) > 0 || (parsed != nil && parsed.eventCounts[event] > 0) {
+			events = append(events, event)
+		}
+	}
+	// Tables in a fixed order, so the same profile always writes the same bytes.
+	sort.Strings(events)
+	for _, event := range events {
+		sf := byEvent[event]
+		ev := apqEvent{Samples: sf.totalSamples, Stacks: make([]apqStack, len(sf.stacks))}
+		if parsed != nil {
+			if n, ok := parsed.eventCounts[event]; ok {
+				ev.Samples = n
+			}
+			ev.Truncated = parsed.truncated[event]
+		}
+		for i := range sf.stacks {
+			st := &sf.stacks[i]
+			out := apqStack{Frames: make([]int32, len(st.frames)), TID: st.tid, Count: st.count}
+			for j, fr := range st.frames {
+				idx, ok := frameIdx[fr]
+				if !ok {
+					idx = int32(len(f.Frames))
+					frameIdx[fr] = idx
+					f.Frames = append(f.Frames, fr)
+				}
+				out.Frames[j] = idx
+			}
+			for _, line := range st.lines {
+				if line != 0 {
+					out.Lines = st.lines
+					break
+				}
+			}
+			if st.thread != "" {
+				idx, ok := threadIdx[st.thread]
+				if !ok {
+					f.Threads = append(f.Threads, st.thread)
+					idx = int32(len(f.Threads))
+					threadIdx[st.thread] = idx
+				}
+				out.Thread = idx
+			}
+			ev.Stacks[i] = out
+		}
+		f.Events[event] = ev
+	}
+
+	if _, err := io.WriteString(w, apqMagic); err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(&f); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// readAPQ reads an .apq file into a parsed profile, checking every index
+// against its table.
+func readAPQ(r io.Reader) (*parsedProfile, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(apqMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !isAPQ(magic) {
+		return nil, parseErrorf("not an .apq file")
+	}
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, readError(fmt.Errorf("apq: %w", err))
+	}
+	var f apqFile
+	if err := json.NewDecoder(gz).Decode(&f); err != nil {
+		return nil, readError(fmt.Errorf("apq: %w", err))
+	}
+	if f.Version != apqVersion {
+		return nil, parseErrorf("apq: unsupported version %d (this ap-query reads version %d)", f.Version, apqVersion)
+	}
+	for i, fr := range f.Frames {
+		f.Frames[i] = limitSymbol(fr)
+	}
+
+	parsed := &parsedProfile{
+		eventCounts:   make(map[string]int, len(f.Events)),
+		stacksByEvent: make(map[string]*stackFile, len(f.Events)),
+		originNanos:   f.OriginNanos,
+		spanNanos:     f.SpanNanos,
+		cpuInterval:   f.CPUInterval,
+		execEventName: f.ExecEventName,
+		settings:      f.Settings,
+	}
+	for event, ev := range f.Events {
+		sf := &stackFile{stacks: make([]stack, 0, len(ev.Stacks))}
+		for i, in := range ev.Stacks {
+			if in.Count <= 0 || len(in.Frames) == 0 {
+				return nil, parseErrorf("apq: %s stack %d: no frames or a count that is not positive", event, i)
+			}
+			if in.Lines != nil && len(in.Lines) != len(in.Frames) {
+				return nil, parseErrorf("apq: %s stack %d: %d lines for %d frames", event, i, len(in.Lines), len(in.Frames))
+			}
+			if in.Thread < 0 || int(in.Thread) > len(f.Threads) {
+				return nil, parseErrorf("apq: %s stack %d: thread index %d out of range", event, i, in.Thread)
+			}
+			st := stack{frames: make([]string, len(in.Frames)), lines: in.Lines, tid: in.TID, count: in.Count}
+			for j, idx := range in.Frames {
+				if idx < 0 || int(idx) >= len(f.Frames) {
+					return nil, parseErrorf("apq: %s stack %d: frame index %d out of range", event, i, idx)
+				}
+				st.frames[j] = f.Frames[idx]
+			}
+			if st.lines == nil {
+				st.lines = make([]uint32, len(st.frames))
+			}
+			st.frames, _ = limitFrames(st.frames)
+			st.lines, _ = limitFrames(st.lines)
+			if in.Thread > 0 {
+				st.thread = f.Threads[in.Thread-1]
+			}
+			sf.stacks = append(sf.stacks, st)
+			sf.totalSamples += st.count
+		}
+		parsed.stacksByEvent[event] = sf
+		parsed.eventCounts[event] = max(ev.Samples, sf.totalSamples)
+		if ev.Truncated > 0 {
+			if parsed.truncated == nil {
+				parsed.truncated = make(map[string]int)
+			}
+			parsed.truncated[event] = ev.Truncated
+		}
+	}
+	return parsed, nil
+}
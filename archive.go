@@ -0,0 +1,428 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// The archive keeps a collapsed-text summary of every added profile (all
+// events, each line labeled [event=NAME]) plus an index of labels, so past
+// recordings can be compared by label after the original files are gone.
+
+func newArchiveCmd() *cobra.Command {
+	var dir string
+	cmd := &cobra.Command{
+		Use:   "archive",
+		Short: "Keep labeled profile summaries in a local store and compare them by label",
+		Long: `Keep labeled profile summaries in a local store (default ~/.ap-query/archive)
+and compare them by label.
+
+archive add stores the stacks of every event as gzipped collapsed text, so
+the summary is small and readable by every command. A label or an archive
+id names an entry in archive diff and archive path. Notes (git SHA, load
+test id, anything free-form) are shown by archive list and in the archive
+diff header, so it is always clear what was compared.`,
+		Example: strings.Join([]string{
+			`  ap-query archive add profile.jfr --label "release-1.42 cpu" --note git=3f9c2e1 --note "load test LT-42"`,
+			`  ap-query archive note "release-1.42 cpu" "GC tuned: -XX:MaxGCPauseMillis=50"`,
+			"  ap-query archive list",
+			`  ap-query archive diff "release-1.41 cpu" "release-1.42 cpu" --min-delta 1`,
+			`  ap-query hot "$(ap-query archive path 3)" --event wall`,
+		}, "\n"),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.Help()
+			return fmt.Errorf("no archive subcommand specified")
+		},
+	}
+	cmd.PersistentFlags().StringVar(&dir, "dir", "", "Archive directory (default ~/.ap-query/archive)")
+
+	var label string
+	var notes []string
+	add := &cobra.Command{
+		Use:   "add <file>",
+		Short: "Store a profile summary under a label",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := openArchive(dir)
+			if err != nil {
+				return err
+			}
+			e, err := a.add(args[0], label, notes)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Archived %s as #%s %q (%s)\n", args[0], e.ID, e.Label, formatArchiveEvents(e.Events))
+			return nil
+		},
+	}
+	add.Flags().StringVar(&label, "label", "", "Label to find the profile by (required)")
+	add.MarkFlagRequired("label")
+	add.Flags().StringArrayVar(&notes, "note", nil, "Free-text note, e.g. git=SHA or a load test id; repeatable")
+
+	note := &cobra.Command{
+		Use:   "note <label|id> <note>...",
+		Short: "Add notes to an archived profile",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := openArchive(dir)
+			if err != nil {
+				return err
+			}
+			e, err := a.addNotes(args[0], args[1:])
+			if err != nil {
+				return err
+			}
+			fmt.Printf("#%s %s: %s\n", e.ID, e.Label, strings.Join(e.Notes, "; "))
+			return nil
+		},
+	}
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List archived profiles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := openArchive(dir)
+			if err != nil {
+				return err
+			}
+			cmdArchiveList(a)
+			return nil
+		},
+	}
+
+	path := &cobra.Command{
+		Use:   "path <label|id>",
+		Short: "Print the summary file of an archived profile, for use with any command",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := openArchive(dir)
+			if err != nil {
+				return err
+			}
+			e, err := a.find(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Println(a.summaryPath(e))
+			return nil
+		},
+	}
+
+	var event, thread string
+	var minDelta float64
+	var top int
+	var fqn bool
+	diff := &cobra.Command{
+		Use:   "diff <before> <after>",
+		Short: "Compare two archived profiles by label or id (see diff)",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			a, err := openArchive(dir)
+			if err != nil {
+				return err
+			}
+			diffArgs := make([]string, 0, 10)
+			for i, ref := range args {
+				e, err := a.find(ref)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%-7s #%s %s (added %s, from %s)\n", []string{"Before:", "After:"}[i],
+					e.ID, e.Label, e.Added.Local().Format("2006-01-02 15:04"), e.Source)
+				for _, n := range e.Notes {
+					fmt.Printf("        %s\n", n)
+				}
+				diffArgs = append(diffArgs, a.summaryPath(e))
+			}
+			fmt.Println()
+			if event != "" {
+				diffArgs = append(diffArgs, "--event", event)
+			}
+			if thread != "" {
+				diffArgs = append(diffArgs, "--thread", thread)
+			}
+			if fqn {
+				diffArgs = append(diffArgs, "--fqn")
+			}
+			diffArgs = append(diffArgs, "--min-delta", strconv.FormatFloat(minDelta, 'g', -1, 64), "--top", strconv.Itoa(top))
+			d := newDiffCmd()
+			d.SetArgs(diffArgs)
+			d.SilenceUsage = true
+			d.SilenceErrors = true
+			return d.Execute()
+		},
+	}
+	diff.Flags().StringVarP(&event, "event", "e", "", "Event type (default: cpu)")
+	diff.Flags().StringVarP(&thread, "thread", "t", "", "Filter to threads matching substring")
+	diff.Flags().Float64Var(&minDelta, "min-delta", 0.5, "Hide entries below this % change")
+	diff.Flags().IntVar(&top, "top", 0, "Limit output rows (default: unlimited)")
+	diff.Flags().BoolVar(&fqn, "fqn", false, "Show fully-qualified names")
+	registerSample(diff)
+
+	cmd.AddCommand(add, note, list, path, diff)
+	return cmd
+}
+
+// archiveEntry is one archived profile in the index.
+type archiveEntry struct {
+	ID     string         `json:"id"`
+	Label  string         `json:"label"`
+	Source string         `json:"source"` // absolute path of the added file
+	Added  time.Time      `json:"added"`
+	Events map[string]int `json:"events"` // samples per event; "" for unlabeled collapsed input
+	Notes  []string       `json:"notes,omitempty"`
+}
+
+type archive struct {
+	dir     string
+	entries []archiveEntry
+}
+
+const archiveIndex = "index.json"
+
+// openArchive loads the index in dir (default ~/.ap-query/archive). A
+// missing index is an empty archive.
+func openArchive(dir string) (*archive, error) {
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, ioErrorf("cannot determine home directory: %v", err)
+		}
+		dir = filepath.Join(home, ".ap-query", "archive")
+	}
+	a := &archive{dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, archiveIndex))
+	if errors.Is(err, fs.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &a.entries); err != nil {
+		return nil, parseErrorf("archive index %s: %v", filepath.Join(dir, archiveIndex), err)
+	}
+	return a, nil
+}
+
+func (a *archive) summaryPath(e archiveEntry) string {
+	return filepath.Join(a.dir, e.ID+".collapsed.gz")
+}
+
+// add parses path with every event, writes its summary and appends it to
+// the index under the next free id.
+func (a *archive) add(path, label string, notes []string) (archiveEntry, error) {
+	if strings.TrimSpace(label) == "" {
+		return archiveEntry{}, fmt.Errorf("--label must not be empty")
+	}
+	if path == "-" {
+		return archiveEntry{}, fmt.Errorf("archive add needs a file, not stdin")
+	}
+	var byEvent map[string]*stackFile
+	parsed, err := parseStructuredProfile(path, allEventTypes())
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	if parsed == nil {
+		res, err := parseCollapsedFile(path)
+		if err != nil {
+			return archiveEntry{}, err
+		}
+		parsed = res.parsed
+		if parsed == nil {
+			byEvent = map[string]*stackFile{"": res.sf}
+		}
+	}
+	if parsed != nil {
+		byEvent = parsed.stacksByEvent
+	}
+
+	source, err := filepath.Abs(path)
+	if err != nil {
+		return archiveEntry{}, ioErrorf("%v", err)
+	}
+	notes, err = cleanNotes(notes)
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	e := archiveEntry{ID: a.nextID(), Label: label, Source: source, Added: time.Now().UTC(), Events: make(map[string]int), Notes: notes}
+	for event, sf := range byEvent {
+		if sf.totalSamples > 0 {
+			e.Events[event] = sf.totalSamples
+		}
+	}
+	if len(e.Events) == 0 {
+		return archiveEntry{}, fmt.Errorf("%s has no samples to archive", path)
+	}
+
+	if err := os.MkdirAll(a.dir, 0o755); err != nil {
+		return archiveEntry{}, err
+	}
+	if err := writeArchiveSummary(a.summaryPath(e), byEvent); err != nil {
+		return archiveEntry{}, err
+	}
+	a.entries = append(a.entries, e)
+	if err := a.save(); err != nil {
+		os.Remove(a.summaryPath(e))
+		return archiveEntry{}, err
+	}
+	return e, nil
+}
+
+// addNotes appends notes to the entry ref names and saves the index.
+func (a *archive) addNotes(ref string, notes []string) (archiveEntry, error) {
+	e, err := a.find(ref)
+	if err != nil {
+		return archiveEntry{}, err
+	}
+	if notes, err = cleanNotes(notes); err != nil {
+		return archiveEntry{}, err
+	}
+	for i := range a.entries {
+		if a.entries[i].ID == e.ID {
+			a.entries[i].Notes = append(a.entries[i].Notes, notes...)
+			e = a.entries[i]
+		}
+	}
+	return e, a.save()
+}
+
+// cleanNotes trims notes and rejects empty or multi-line ones, which would
+// break the one-note-per-line headers.
+func cleanNotes(notes []string) ([]string, error) {
+	var out []string
+	for _, n := range notes {
+		n = strings.TrimSpace(n)
+		if n == "" || strings.ContainsAny(n, "\r\n") {
+			return nil, fmt.Errorf("notes must be a single non-empty line, got %q", n)
+		}
+		out = append(out, n)
+	}
+	return out, nil
+}
+
+func writeArchiveSummary(path string, byEvent map[string]*stackFile) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(f)
+	if sf, ok := byEvent[""]; ok {
+		for _, c := range computeCollapsed(sf) {
+			fmt.Fprintf(gz, "%s %d\n", c.key, c.count)
+		}
+	} else {
+		writeCollapsedAllEvents(gz, byEvent)
+	}
+	err = gz.Close()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (a *archive) save() error {
+	data, err := json.MarshalIndent(a.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(a.dir, archiveIndex+".tmp")
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(a.dir, archiveIndex))
+}
+
+func (a *archive) nextID() string {
+	next := 1
+	for _, e := range a.entries {
+		if n, err := strconv.Atoi(e.ID); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+	return strconv.Itoa(next)
+}
+
+// find resolves an id (optionally written #N) or an exact label. A label
+// shared by several entries is ambiguous and must be given as an id.
+func (a *archive) find(ref string) (archiveEntry, error) {
+	id := strings.TrimPrefix(ref, "#")
+	for _, e := range a.entries {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+	var matches []archiveEntry
+	for _, e := range a.entries {
+		if e.Label == ref {
+			matches = append(matches, e)
+		}
+	}
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		return archiveEntry{}, fmt.Errorf("no archived profile with label or id %q (see archive list)", ref)
+	}
+	ids := make([]string, len(matches))
+	for i, e := range matches {
+		ids[i] = "#" + e.ID
+	}
+	return archiveEntry{}, fmt.Errorf("label %q matches %s; use an id", ref, strings.Join(ids, ", "))
+}
+
+func cmdArchiveList(a *archive) {
+	if len(a.entries) == 0 {
+		fmt.Printf("archive %s is empty (add profiles with archive add)\n", a.dir)
+		return
+	}
+	labelW := len("LABEL")
+	for _, e := range a.entries {
+		labelW = max(labelW, len(e.Label))
+	}
+	fmt.Printf("%-4s  %-16s  %-*s  %s\n", "ID", "ADDED", labelW, "LABEL", "EVENTS")
+	for _, e := range a.entries {
+		fmt.Printf("%-4s  %-16s  %-*s  %s\n", "#"+e.ID, e.Added.Local().Format("2006-01-02 15:04"),
+			labelW, e.Label, formatArchiveEvents(e.Events))
+		for _, n := range e.Notes {
+			fmt.Printf("%24s%s\n", "", n)
+		}
+	}
+}
+
+// formatArchiveEvents lists sample counts per event in the usual event
+// order, e.g. "cpu 1553, wall 889".
+func formatArchiveEvents(events map[string]int) string {
+	names := make([]string, 0, len(events))
+	for event := range events {
+		names = append(names, event)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ri, rj := eventRank(names[i]), eventRank(names[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, event := range names {
+		if event == "" {
+			parts[i] = fmt.Sprintf("%d samples", events[event])
+		} else {
+			parts[i] = fmt.Sprintf("%s %d", event, events[event])
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+func newAssertCmd() *cobra.Command {
+	var shared sharedFlags
+	var exprArgs []string
+	var strict bool
+	cmd := &cobra.Command{
+		Use:   "assert <file>",
+		Short: "Exit 1 unless every --expr over the profile's metrics holds (for CI gates)",
+		Long: `Assert evaluates boolean expressions over metrics of the profile and exits 1
+when any is false, so a CI gate is one invocation however many conditions
+it checks. Expressions are Starlark (and, or, not, comparisons, arithmetic);
+&&, || and ! are accepted as well. Metrics:
+
+  self_pct("M")     % of samples whose leaf frame matches method M (as -m)
+  total_pct("M")    % of samples with a frame matching M anywhere
+  thread_pct("G")   % of samples in threads whose name matches glob G
+  top_self_pct()    self % of the hottest method (hot --assert-below)
+  samples()         samples after filtering
+
+Each expression is printed with PASS or FAIL and the metric values it read.
+A self_pct or total_pct pattern that matches no frame reads 0 and is noted
+in the report, so absence gates like total_pct("LegacyCodec") == 0 work;
+--strict fails such expressions instead, so a misspelled method cannot pass
+a gate unnoticed.`,
+		Example: strings.Join([]string{
+			`  ap-query assert profile.jfr --expr 'self_pct("HashMap.resize") < 2 && thread_pct("GC*") < 5'`,
+			`  ap-query assert profile.jfr --event alloc --expr 'total_pct("Codec.encode") < 10' --expr 'samples() > 1000'`,
+			`  ap-query assert profile.jfr --expr @gates.txt`,
+			`  ap-query assert profile.jfr --strict --expr 'self_pct("HashMap.resize") < 2'`,
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			exprs, err := parseAssertExprs(exprArgs)
+			if err != nil {
+				return err
+			}
+			pctx, err := preprocessProfile(shared.toOpts(args[0], "assert"))
+			if err != nil {
+				return err
+			}
+			return cmdAssert(pctx.sf, exprs, strict)
+		},
+	}
+	shared.register(cmd)
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	cmd.Flags().StringArrayVar(&exprArgs, "expr", nil, "Boolean expression that must hold; repeatable, @FILE reads one per line")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Fail expressions whose self_pct or total_pct pattern matches no frame (catches misspelled methods)")
+	return cmd
+}
+
+// parseAssertExprs reads the --expr values (or @FILE lists of them) and
+// checks their syntax before the profile is parsed.
+func parseAssertExprs(args []string) ([]string, error) {
+	var res []string
+	for _, arg := range args {
+		exprs := []string{arg}
+		if file, ok := strings.CutPrefix(arg, "@"); ok {
+			var err error
+			if exprs, err = readPatternFile(file); err != nil {
+				return nil, err
+			}
+		}
+		for _, e := range exprs {
+			if _, err := syntax.ParseExpr("expr", assertStarlark(e), 0); err != nil {
+				return nil, fmt.Errorf("--expr %q: %v", e, err)
+			}
+			res = append(res, e)
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("--expr required")
+	}
+	return res, nil
+}
+
+// assertStarlark rewrites the C-style &&, || and ! outside string literals
+// to Starlark's and, or and not.
+func assertStarlark(expr string) string {
+	var b strings.Builder
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case quote != 0:
+			b.WriteByte(c)
+			if c == '\\' && i+1 < len(expr) {
+				i++
+				b.WriteByte(expr[i])
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			b.WriteByte(c)
+		case strings.HasPrefix(expr[i:], "&&"):
+			b.WriteString(" and ")
+			i++
+		case strings.HasPrefix(expr[i:], "||"):
+			b.WriteString(" or ")
+			i++
+		case c == '!' && !strings.HasPrefix(expr[i:], "!="):
+			b.WriteString(" not ")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// assertMetrics are the functions expressions call, with a log of the
+// values they returned for the report.
+type assertMetrics struct {
+	sf        *stackFile
+	read      []string // "self_pct("X") = 1.2", in call order
+	unmatched []string // calls whose pattern matched no frame
+}
+
+func (am *assertMetrics) builtins() starlark.StringDict {
+	return starlark.StringDict{
+		"self_pct":     am.pctBuiltin("self_pct", am.selfPct),
+		"total_pct":    am.pctBuiltin("total_pct", am.totalPct),
+		"thread_pct":   am.pctBuiltin("thread_pct", am.threadPct),
+		"top_self_pct": starlark.NewBuiltin("top_self_pct", am.topSelfPct),
+		"samples": starlark.NewBuiltin("samples", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+				return nil, err
+			}
+			am.read = append(am.read, fmt.Sprintf("samples() = %d", am.sf.totalSamples))
+			return starlark.MakeInt(am.sf.totalSamples), nil
+		}),
+	}
+}
+
+// pctBuiltin wraps a metric of one string argument. fn reports whether the
+// argument matched anything in the profile.
+func (am *assertMetrics) pctBuiltin(name string, fn func(string) (float64, bool)) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var arg string
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &arg); err != nil {
+			return nil, err
+		}
+		pct, matched := fn(arg)
+		call := fmt.Sprintf("%s(%s)", name, strconv.Quote(arg))
+		if !matched {
+			am.unmatched = append(am.unmatched, call)
+			am.read = append(am.read, fmt.Sprintf("%s = %.*f (matched no frames)", call, pctDigits, pct))
+		} else {
+			am.read = append(am.read, fmt.Sprintf("%s = %.*f", call, pctDigits, pct))
+		}
+		return starlark.Float(pct), nil
+	})
+}
+
+func (am *assertMetrics) selfPct(method string) (float64, bool) {
+	fm := am.sf.match(substringMatcher(method))
+	samples := 0
+	for _, i := range fm.stacks {
+		st := &am.sf.stacks[i]
+		if fm.frames[st.frames[len(st.frames)-1]] {
+			samples += st.count
+		}
+	}
+	return pctOf(samples, am.sf.totalSamples), len(fm.frames) > 0
+}
+
+func (am *assertMetrics) totalPct(method string) (float64, bool) {
+	fm := am.sf.match(substringMatcher(method))
+	samples := 0
+	for _, i := range fm.stacks {
+		samples += am.sf.stacks[i].count
+	}
+	return pctOf(samples, am.sf.totalSamples), len(fm.frames) > 0
+}
+
+// threadPct always counts as matched: a thread group absent from the
+// profile is a legitimate 0%.
+func (am *assertMetrics) threadPct(glob string) (float64, bool) {
+	re := globRegexp(glob)
+	samples := 0
+	for i := range am.sf.stacks {
+		if re.MatchString(am.sf.stacks[i].thread) {
+			samples += am.sf.stacks[i].count
+		}
+	}
+	return pctOf(samples, am.sf.totalSamples), true
+}
+
+func (am *assertMetrics) topSelfPct(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	pct, name := 0.0, ""
+	if ranked := computeHot(am.sf, false); len(ranked) > 0 {
+		pct, name = pctOf(ranked[0].selfCount, am.sf.totalSamples), ranked[0].name
+	}
+	am.read = append(am.read, fmt.Sprintf("top_self_pct() = %.*f (%s)", pctDigits, pct, name))
+	return starlark.Float(pct), nil
+}
+
+// cmdAssert evaluates every expression, reports each and fails with the
+// ones that are false or, when strict, read a method pattern that matched
+// no frame. An expression that does not evaluate to a boolean is a usage
+// error.
+func cmdAssert(sf *stackFile, exprs []string, strict bool) error {
+	am := &assertMetrics{sf: sf}
+	env := am.builtins()
+	var failed []string
+	for _, e := range exprs {
+		am.read, am.unmatched = nil, nil
+		v, err := starlark.EvalOptions(&syntax.FileOptions{}, &starlark.Thread{Name: "assert"}, "expr", assertStarlark(e), env)
+		if err != nil {
+			return fmt.Errorf("--expr %q: %v", e, err)
+		}
+		ok, isBool := v.(starlark.Bool)
+		if !isBool {
+			return fmt.Errorf("--expr %q: got %s, want a boolean", e, v.Type())
+		}
+		status := "PASS"
+		if strict && len(am.unmatched) > 0 {
+			status = "FAIL"
+			failed = append(failed, fmt.Sprintf("ASSERT FAILED: %s (%s matched no frames)", e, strings.Join(am.unmatched, ", ")))
+		} else if !ok {
+			status = "FAIL"
+			failed = append(failed, "ASSERT FAILED: "+e)
+		}
+		fmt.Printf("%s  %s\n", status, e)
+		for _, r := range am.read {
+			fmt.Printf("      %s\n", r)
+		}
+	}
+	if len(failed) > 0 {
+		return assertionErrorf("%s", strings.Join(failed, "\n"))
+	}
+	return nil
+}
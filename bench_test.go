@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// computeHotMaps is the original map-based computeHot, kept as a reference
+// for equivalence testing and as the benchmark baseline.
+func computeHotMaps(sf *stackFile, fqn bool) []hotEntry {
+	if sf.totalSamples == 0 {
+		return nil
+	}
+
+	sc := selfCounts(sf, fqn)
+	totalCounts := make(map[string]int)
+
+	for i := range sf.stacks {
+		st := &sf.stacks[i]
+		seen := make(map[string]bool)
+		for _, fr := range st.frames {
+			key := displayName(fr, fqn)
+			if !seen[key] {
+				totalCounts[key] += st.count
+				seen[key] = true
+			}
+		}
+	}
+
+	var ranked []hotEntry
+	for name, s := range sc {
+		ranked = append(ranked, hotEntry{name, s, totalCounts[name]})
+	}
+	for name, tc := range totalCounts {
+		if _, hasSelf := sc[name]; !hasSelf {
+			ranked = append(ranked, hotEntry{name, 0, tc})
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool { return ranked[i].selfCount > ranked[j].selfCount })
+	return ranked
+}
+
+// syntheticStackFile builds n stacks over a fixed pool of Java-style frames.
+// Frame slices are shared between stacks to keep memory modest at n=1M;
+// recursion is included so per-stack de-duplication is exercised.
+func syntheticStackFile(n int, seed int64) *stackFile {
+	rng := rand.New(rand.NewSource(seed))
+	frames := make([]string, 5000)
+	for i := range frames {
+		frames[i] = fmt.Sprintf("com/example/pkg%d/Class%d.method%d", i%50, i%700, i)
+	}
+	paths := make([][]string, 20000)
+	pathLines := make([][]uint32, len(paths))
+	for i := range paths {
+		depth := 8 + rng.Intn(24)
+		p := make([]string, depth)
+		pathLines[i] = make([]uint32, depth)
+		for j := range p {
+			pathLines[i][j] = uint32(10 + rng.Intn(5))
+			if j > 2 && rng.Intn(10) == 0 {
+				p[j] = p[j-2] // recursion
+				continue
+			}
+			p[j] = frames[rng.Intn(len(frames))]
+		}
+		paths[i] = p
+	}
+	sf := &stackFile{stacks: make([]stack, 0, n)}
+	for i := 0; i < n; i++ {
+		count := 1 + rng.Intn(5)
+		p := rng.Intn(len(paths))
+		sf.stacks = append(sf.stacks, stack{
+			frames: paths[p],
+			lines:  pathLines[p],
+			count:  count,
+			thread: fmt.Sprintf("worker-%d", i%16),
+		})
+		sf.totalSamples += count
+	}
+	return sf
+}
+
+func TestComputeHotMatchesMapImplementation(t *testing.T) {
+	for _, fqn := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fqn=%v", fqn), func(t *testing.T) {
+			sf := syntheticStackFile(5000, 1)
+			want := make(map[string]hotEntry)
+			for _, e := range computeHotMaps(sf, fqn) {
+				want[e.name] = e
+			}
+			got := computeHot(sf, fqn)
+			if len(got) != len(want) {
+				t.Fatalf("got %d entries, want %d", len(got), len(want))
+			}
+			for i, e := range got {
+				if want[e.name] != e {
+					t.Errorf("entry %q = %+v, want %+v", e.name, e, want[e.name])
+				}
+				if i > 0 && got[i-1].selfCount < e.selfCount {
+					t.Errorf("not sorted by self at %d", i)
+				}
+			}
+			// A second call reuses the cached namer and must agree.
+			again := computeHot(sf, fqn)
+			for i := range got {
+				if again[i] != got[i] {
+					t.Fatalf("second call differs at %d: %+v vs %+v", i, again[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func benchmarkHot(b *testing.B, hot func(*stackFile, bool) []hotEntry) {
+	sf := syntheticStackFile(1_000_000, 42)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hot(sf, false)
+	}
+}
+
+func BenchmarkComputeHot1M(b *testing.B) {
+	benchmarkHot(b, computeHot)
+}
+
+func BenchmarkComputeHotMaps1M(b *testing.B) {
+	benchmarkHot(b, computeHotMaps)
+}
+
+// BenchmarkComputeHotColdNamer1M measures the first call on a stack file,
+// including building the display-name cache.
+func BenchmarkComputeHotColdNamer1M(b *testing.B) {
+	sf := syntheticStackFile(1_000_000, 42)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sf.namers = [2]*frameNamer{}
+		computeHot(sf, false)
+	}
+}
+
+// BenchmarkDrillDown1M runs the per-method queries of info --expand 3
+// (tree, callers, lines) against one 1M-stack file. The first iteration
+// builds the stack index; later ones reuse it.
+func BenchmarkDrillDown1M(b *testing.B) {
+	sf := syntheticStackFile(1_000_000, 42)
+	hot := computeHot(sf, false)[:3]
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, h := range hot {
+			m := substringMatcher(h.name)
+			buildTreePT(sf, m)
+			buildCallersPT(sf, m)
+			computeLines(sf, m, 5, false)
+		}
+	}
+}
+
+// syntheticCollapsed renders sf as collapsed text with thread frames.
+func syntheticCollapsed(sf *stackFile) string {
+	var b strings.Builder
+	for _, st := range sf.stacks {
+		fmt.Fprintf(&b, "[%s];%s %d\n", st.thread, strings.Join(st.frames, ";"), st.count)
+	}
+	return b.String()
+}
+
+func TestParseCollapsedLongLine(t *testing.T) {
+	// 2 MB of frames, beyond the former 1 MB line limit.
+	frames := make([]string, 20000)
+	for i := range frames {
+		frames[i] = fmt.Sprintf("com/example/very/deep/pkg/Recursive%05d.descendIntoTheNextLevelOfTheTree", i)
+	}
+	sf, err := parseCollapsed(strings.NewReader("A;B 1\n" + strings.Join(frames, ";") + " 2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sf.totalSamples != 3 || len(sf.stacks) != 2 || len(sf.stacks[1].frames) != len(frames) {
+		t.Errorf("got %d samples in %d stacks", sf.totalSamples, len(sf.stacks))
+	}
+}
+
+func BenchmarkParseCollapsed1M(b *testing.B) {
+	text := syntheticCollapsed(syntheticStackFile(1_000_000, 42))
+	b.SetBytes(int64(len(text)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseCollapsed(strings.NewReader(text)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"regexp"
@@ -10,18 +11,42 @@ import (
 
 func newCallersCmd() *cobra.Command {
 	var shared sharedFlags
-	var method string
+	var mf methodFlags
 	var depth int
 	var minPct float64
 	var hide string
+	var highlight bool
+	var maxNodes int
+	var relative bool
+	var mergeRecursive bool
+	var showSelf bool
+	var format string
 	cmd := &cobra.Command{
 		Use:   "callers <file>",
 		Short: "Callers ascending to a method (-m required)",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if method == "" {
+			if mf.method == "" {
 				return fmt.Errorf("-m/--method required")
 			}
+			if err := mf.validate(); err != nil {
+				return err
+			}
+			if maxNodes < 0 {
+				return fmt.Errorf("--max-nodes must be non-negative (got %d)", maxNodes)
+			}
+			switch format {
+			case "text":
+				if shared.repoURL != "" {
+					return fmt.Errorf("--repo-url applies to --format json only")
+				}
+			case "json":
+				if highlight || showSelf || shared.explain {
+					return fmt.Errorf("--highlight, --show-self and --explain apply to --format text only")
+				}
+			default:
+				return fmt.Errorf("invalid --format %q (want text or json)", format)
+			}
 			pctx, err := preprocessProfile(shared.toOpts(args[0], "callers"))
 			if err != nil {
 				return err
@@ -34,23 +59,84 @@ func newCallersCmd() *cobra.Command {
 				}
 				sf = sf.hideFrames(re)
 			}
-			cmdCallers(sf, method, depth, minPct)
+			if mergeRecursive {
+				sf = sf.mergeRecursive()
+			}
+			m, err := mf.resolve(sf, args[0])
+			if err != nil {
+				return err
+			}
+			if relative {
+				if rel := sf.relativeTo(m); rel != sf {
+					sf = rel
+					if format != "json" {
+						fmt.Printf("# percentages of the %d samples matching '%s' (--relative)\n", sf.totalSamples, m.pattern)
+					}
+				}
+			}
+			if format == "json" {
+				return cmdCallersJSON(sf, m, depth, minPct, maxNodes, pctx.links)
+			}
+			cmdCallers(sf, m, depth, minPct, highlight, maxNodes, showSelf)
 			return nil
 		},
 	}
 	shared.register(cmd)
-	cmd.Flags().StringVarP(&method, "method", "m", "", "Substring match on method name (required)")
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	shared.registerGroupBy(cmd)
+	shared.registerRepoURL(cmd)
+	mf.register(cmd, "Substring match on method name (required)")
 	cmd.Flags().IntVar(&depth, "depth", 4, "Max depth")
 	cmd.Flags().Float64Var(&minPct, "min-pct", 1.0, "Hide nodes below this %")
 	cmd.Flags().StringVar(&hide, "hide", "", "Remove matching frames before analysis (regex)")
+	cmd.Flags().IntVar(&maxNodes, "max-nodes", 0, "Print at most N nodes, expanding the heaviest first and summarizing the rest (default: unlimited)")
+	cmd.Flags().BoolVar(&relative, "relative", false, "Show percentages of the samples matching -m instead of all samples (--min-pct too)")
+	cmd.Flags().BoolVar(&mergeRecursive, "merge-recursive", false, "Collapse consecutive identical frames (direct recursion) so external callers stay visible")
+	cmd.Flags().BoolVar(&showSelf, "show-self", false, "Annotate each caller path with the samples where the matched method itself is running (its self time), and total each root")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format: text, or json (an inverted flame graph rooted at the method, as read by d3-flamegraph and speedscope)")
+	cmd.Flags().BoolVar(&highlight, "highlight", false, "Mark frames matched by -m with \""+highlightMarker+"\"")
 	return cmd
 }
 
-func cmdCallers(sf *stackFile, method string, maxDepth int, minPct float64) {
+// cmdCallers prints the callers tree of m. With showSelf, each node's self
+// annotation is the matched method's own time reached through that path,
+// rather than the samples whose stack ends there (the thread root).
+func cmdCallers(sf *stackFile, m methodMatcher, maxDepth int, minPct float64, highlight bool, maxNodes int, showSelf bool) {
 	if sf.totalSamples == 0 {
 		fmt.Fprintln(os.Stdout, "no samples (empty profile or all filtered out)")
 		return
 	}
-	pt := buildCallersPT(sf, method)
-	pt.fprintTree(os.Stdout, sf, method, maxDepth, minPct, false)
+	pt := buildCallersPT(sf, m)
+	if highlight {
+		pt.markMatches(sf, m, shortName)
+	}
+	pt.maxNodes = maxNodes
+	pt.totalsHeader = !sf.relative
+	if showSelf {
+		pt.selfSamples = pt.matchSelf
+		pt.rootTotals = true
+	}
+	pt.fprintTree(os.Stdout, sf, m.pattern, maxDepth, minPct, showSelf)
+}
+
+// cmdCallersJSON writes the callers tree as flamegraph JSON under an "all"
+// root: the matched methods at the top, their callers below, as in an
+// inverted (icicle) flame graph. With links, nodes carry the URL of their
+// source.
+func cmdCallersJSON(sf *stackFile, m methodMatcher, maxDepth int, minPct float64, maxNodes int, links *repoLinker) error {
+	pt := buildCallersPT(sf, m)
+	if sf.totalSamples > 0 && len(pt.samples) == 0 {
+		noMatchMessage(os.Stderr, sf, m.pattern)
+		return nil
+	}
+	pt.maxNodes = maxNodes
+	root := pt.flameTree("all", maxDepth, minPct)
+	if links != nil {
+		root.link(links.urlsByName(sf, false))
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(root); err != nil {
+		return ioErrorf("%v", err)
+	}
+	return nil
 }
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/grafana/jfr-parser/parser"
+	"github.com/grafana/jfr-parser/parser/types"
+	"github.com/spf13/cobra"
+)
+
+func newClassesCmd() *cobra.Command {
+	var from, to string
+	var top int
+	cmd := &cobra.Command{
+		Use:   "classes <file>",
+		Short: "Class loading and metaspace: classes loaded, loading stacks, metaspace growth (JFR only)",
+		Long: `Classes summarizes class loading during the recording: how many classes
+were loaded and unloaded, the class loaders that own them, the stacks that
+triggered the loads, and how metaspace grew. Use it for slow startup (too
+many classes loaded on the request path) and classloader leaks (a loader's
+class count and metaspace that only grow).
+
+It reads the JDK events jdk.ClassLoad, jdk.ClassUnload,
+jdk.ClassLoadingStatistics, jdk.ClassLoaderStatistics and
+jdk.MetaspaceSummary: record with asprof --jfrsync. jdk.ClassLoad (needed for
+the loading stacks) is off in the default JFR settings; enable it in a
+custom .jfc. Sections without events are omitted.`,
+		Example: strings.Join([]string{
+			"  ap-query classes profile.jfr",
+			"  ap-query classes profile.jfr --from 0s --to 30s --top 20",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if detectFormat(args[0]) != formatJFR {
+				return fmt.Errorf("classes requires a JFR file")
+			}
+			window, err := parseDurationWindow("--from", from, "--to", to)
+			if err != nil {
+				return err
+			}
+			rep, err := parseClasses(args[0], window.fromNanos, window.toNanos)
+			if err != nil {
+				return err
+			}
+			cmdClasses(rep, top)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&from, "from", "", "Start of time window")
+	cmd.Flags().StringVar(&to, "to", "", "End of time window")
+	cmd.Flags().IntVar(&top, "top", 10, "Limit class loader and stack rows")
+	return cmd
+}
+
+// classLoaderEntry is one class loader's activity. loaded and unloaded
+// count jdk.ClassLoad/ClassUnload events; classes and metaspace come from
+// its last jdk.ClassLoaderStatistics snapshot (-1 = none).
+type classLoaderEntry struct {
+	name      string
+	loaded    int
+	unloaded  int
+	classes   int64
+	metaspace int64
+}
+
+// metaspaceSample is one jdk.MetaspaceSummary reading, in bytes.
+type metaspaceSample struct {
+	offsetNanos int64
+	used        int64
+	committed   int64
+}
+
+type classesReport struct {
+	loaded, unloaded int // jdk.ClassLoad/ClassUnload events
+	// JVM-wide class counts from the first and last
+	// jdk.ClassLoadingStatistics; hasStats is false without any.
+	hasStats                bool
+	firstLoaded, lastLoaded int64
+	firstUnload, lastUnload int64
+	loaders                 map[string]*classLoaderEntry
+	stacks                  *stackFile // one count per jdk.ClassLoad with a stack
+	metaspace               []metaspaceSample
+}
+
+var classEventNames = []string{
+	"jdk.ClassLoad",
+	"jdk.ClassUnload",
+	"jdk.ClassLoadingStatistics",
+	"jdk.ClassLoaderStatistics",
+	"jdk.MetaspaceSummary",
+}
+
+// bootstrapLoader names classes defined by the bootstrap class loader,
+// which JFR records as a null loader.
+const bootstrapLoader = "bootstrap"
+
+// parseClasses reads the class-loading and metaspace events of a JFR
+// recording. fromNanos/toNanos bound event start times (-1 = open).
+func parseClasses(path string, fromNanos, toNanos int64) (*classesReport, error) {
+	buf, err := readJFRBytes(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := &classesReport{loaders: make(map[string]*classLoaderEntry)}
+	loader := func(ev *jdkEvent, field string) *classLoaderEntry {
+		name := ev.strs[field]
+		if name == "" {
+			name = bootstrapLoader
+		}
+		e := rep.loaders[name]
+		if e == nil {
+			e = &classLoaderEntry{name: name, classes: -1, metaspace: -1}
+			rep.loaders[name] = e
+		}
+		return e
+	}
+	stacks := make(map[string]*stack)
+	var stackCache map[types.StackTraceRef]*cachedStackTrace
+	var lastParser *parser.Parser
+	err = scanJDKEvents(buf, classEventNames, func(p *parser.Parser, ev *jdkEvent) {
+		if (fromNanos >= 0 && ev.startNanos < fromNanos) || (toNanos >= 0 && ev.startNanos >= toNanos) {
+			return
+		}
+		switch ev.name {
+		case "jdk.ClassLoad":
+			rep.loaded++
+			loader(ev, "definingClassLoader").loaded++
+			if p != lastParser { // stack trace ids are per chunk
+				stackCache = make(map[types.StackTraceRef]*cachedStackTrace)
+				lastParser = p
+			}
+			cached := resolveStackTraceCached(p, stackCache, nil, types.StackTraceRef(ev.nums["stackTrace"]))
+			if len(cached.frames) == 0 {
+				return
+			}
+			key := strings.Join(cached.frames, ";")
+			st := stacks[key]
+			if st == nil {
+				st = &stack{frames: cached.frames, lines: make([]uint32, len(cached.frames))}
+				stacks[key] = st
+			}
+			st.count++
+		case "jdk.ClassUnload":
+			rep.unloaded++
+			loader(ev, "definingClassLoader").unloaded++
+		case "jdk.ClassLoadingStatistics":
+			loaded, unloaded := int64(ev.nums["loadedClassCount"]), int64(ev.nums["unloadedClassCount"])
+			if !rep.hasStats {
+				rep.hasStats = true
+				rep.firstLoaded, rep.firstUnload = loaded, unloaded
+			}
+			rep.lastLoaded, rep.lastUnload = loaded, unloaded
+		case "jdk.ClassLoaderStatistics":
+			e := loader(ev, "classLoader")
+			e.classes = int64(ev.nums["classCount"])
+			e.metaspace = int64(ev.nums["chunkSize"])
+		case "jdk.MetaspaceSummary":
+			rep.metaspace = append(rep.metaspace, metaspaceSample{
+				offsetNanos: ev.startNanos,
+				used:        int64(ev.nums["metaspace.used"]),
+				committed:   int64(ev.nums["metaspace.committed"]),
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rep.stacks = &stackFile{}
+	for _, st := range stacks {
+		rep.stacks.stacks = append(rep.stacks.stacks, *st)
+		rep.stacks.totalSamples += st.count
+	}
+	sort.Slice(rep.stacks.stacks, func(i, j int) bool {
+		a, b := &rep.stacks.stacks[i], &rep.stacks.stacks[j]
+		if a.count != b.count {
+			return a.count > b.count
+		}
+		return strings.Join(a.frames, ";") < strings.Join(b.frames, ";")
+	})
+	sort.SliceStable(rep.metaspace, func(i, j int) bool { return rep.metaspace[i].offsetNanos < rep.metaspace[j].offsetNanos })
+	return rep, nil
+}
+
+// sortedClassLoaders orders loaders by classes loaded during the recording,
+// then by class count, then name.
+func sortedClassLoaders(loaders map[string]*classLoaderEntry) []classLoaderEntry {
+	out := make([]classLoaderEntry, 0, len(loaders))
+	for _, e := range loaders {
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].loaded != out[j].loaded {
+			return out[i].loaded > out[j].loaded
+		}
+		if out[i].classes != out[j].classes {
+			return out[i].classes > out[j].classes
+		}
+		return out[i].name < out[j].name
+	})
+	return out
+}
+
+// classStackFrames is the number of leaf-most frames shown per loading stack.
+const classStackFrames = 5
+
+func cmdClasses(rep *classesReport, top int) {
+	if rep.loaded == 0 && rep.unloaded == 0 && !rep.hasStats && len(rep.loaders) == 0 && len(rep.metaspace) == 0 {
+		fmt.Fprintln(os.Stdout, "no class-loading or metaspace events (record with asprof --jfrsync profile)")
+		return
+	}
+
+	if rep.loaded > 0 || rep.unloaded > 0 {
+		fmt.Printf("Classes loaded: %d  unloaded: %d  (jdk.ClassLoad/ClassUnload events)\n", rep.loaded, rep.unloaded)
+	}
+	if rep.hasStats {
+		fmt.Printf("JVM classes:    %d → %d loaded (%+d), %d unloaded (%+d)\n",
+			rep.firstLoaded, rep.lastLoaded, rep.lastLoaded-rep.firstLoaded,
+			rep.lastUnload, rep.lastUnload-rep.firstUnload)
+	}
+	if len(rep.metaspace) > 0 {
+		first, last := rep.metaspace[0], rep.metaspace[len(rep.metaspace)-1]
+		peak := first.used
+		for _, m := range rep.metaspace {
+			peak = max(peak, m.used)
+		}
+		growth := "+" + formatBytes(last.used-first.used)
+		if last.used < first.used {
+			growth = "-" + formatBytes(first.used-last.used)
+		}
+		fmt.Printf("Metaspace used: %s → %s (%s, peak %s), committed %s\n",
+			formatBytes(first.used), formatBytes(last.used), growth, formatBytes(peak), formatBytes(last.committed))
+	}
+
+	if len(rep.loaders) > 0 {
+		loaders := sortedClassLoaders(rep.loaders)
+		shown := loaders[:truncate(len(loaders), top)]
+		fmt.Println()
+		fmt.Printf("%-50s %8s %8s %9s %10s\n", "CLASS LOADER", "LOADED", "UNLOADED", "CLASSES", "METASPACE")
+		for _, e := range shown {
+			classes, meta := "-", "-"
+			if e.classes >= 0 {
+				classes = fmt.Sprint(e.classes)
+			}
+			if e.metaspace >= 0 {
+				meta = formatBytes(e.metaspace)
+			}
+			fmt.Printf("%-50s %8d %8d %9s %10s\n", e.name, e.loaded, e.unloaded, classes, meta)
+		}
+		if len(shown) < len(loaders) {
+			fmt.Printf("(%d of %d class loaders shown)\n", len(shown), len(loaders))
+		}
+	}
+
+	if rep.stacks.totalSamples > 0 {
+		shown := rep.stacks.stacks[:truncate(len(rep.stacks.stacks), top)]
+		fmt.Println()
+		fmt.Println("Top loading stacks:")
+		for _, st := range shown {
+			fmt.Printf("  %d loaded (%.*f%%)\n", st.count, pctDigits, pctOf(st.count, rep.stacks.totalSamples))
+			n := len(st.frames)
+			for i := n - 1; i >= 0 && i >= n-classStackFrames; i-- {
+				fmt.Printf("    %s\n", shortName(st.frames[i]))
+			}
+			if n > classStackFrames {
+				fmt.Printf("    … %d more frames\n", n-classStackFrames)
+			}
+		}
+		if len(shown) < len(rep.stacks.stacks) {
+			fmt.Printf("(%d of %d stacks shown)\n", len(shown), len(rep.stacks.stacks))
+		}
+	}
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sort"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -9,27 +15,231 @@ import (
 
 func newCollapseCmd() *cobra.Command {
 	var shared sharedFlags
+	var timestamps bool
+	var redact []string
+	var apqOut string
 	cmd := &cobra.Command{
 		Use:   "collapse <file>",
 		Short: "Emit collapsed-stack text (useful for piping JFR output)",
-		Args:  cobra.ExactArgs(1),
+		Example: strings.Join([]string{
+			"  ap-query collapse profile.jfr --event wall",
+			"  ap-query collapse profile.jfr --event lock --timestamps --from 10s --to 20s",
+			"  ap-query collapse profile.jfr --redact 'com.mycorp.*' > shareable.collapsed",
+			"  ap-query collapse profile.jfr --event all > all.collapsed && ap-query hot all.collapsed --event wall",
+			"  ap-query collapse profile.jfr --apq profile.apq && ap-query hot profile.apq --event alloc",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			pctx, err := preprocessProfile(shared.toOpts(args[0], "collapse"))
+			globs, err := compileGlobArgs("--redact", redact)
 			if err != nil {
 				return err
 			}
-			cmdCollapse(pctx.sf)
+			if apqOut != "" {
+				if timestamps {
+					return fmt.Errorf("--apq cannot be combined with --timestamps (the model keeps no per-sample times)")
+				}
+				if shared.event == "" {
+					shared.event = "all"
+				}
+			}
+			allEvents := shared.event == "all"
+			if allEvents && timestamps {
+				return fmt.Errorf("--event all cannot be combined with --timestamps")
+			}
+			opts := shared.toOpts(args[0], "collapse")
+			opts.timestamps = timestamps
+			if allEvents {
+				opts.eventFlag = ""
+			}
+			pctx, err := preprocessProfile(opts)
+			if err != nil {
+				return err
+			}
+			if allEvents && pctx.parsed == nil && apqOut != "" {
+				// Unlabeled text is one event, written as the event it was read as.
+				allEvents = false
+			}
+			if allEvents {
+				if pctx.parsed == nil {
+					return fmt.Errorf("--event all requires a JFR, pprof or event-labeled collapsed input")
+				}
+				byEvent := make(map[string]*stackFile, len(pctx.parsed.stacksByEvent))
+				for event, sf := range pctx.parsed.stacksByEvent {
+					sf = sf.filterByThread(shared.thread)
+					if shared.noIdle {
+						sf = sf.filterIdle()
+					}
+					byEvent[event] = sf
+				}
+				if len(globs) > 0 {
+					r := newProfileRedactor(globs, slices.Collect(maps.Values(byEvent)), nil)
+					fmt.Fprintln(os.Stderr, r.summary())
+					for event, sf := range byEvent {
+						byEvent[event] = r.stackFile(sf)
+					}
+				}
+				if apqOut != "" {
+					return saveAPQ(apqOut, args[0], pctx.parsed, byEvent)
+				}
+				writeCollapsedAllEvents(os.Stdout, byEvent)
+				return nil
+			}
+			sf := pctx.sf
+			var events []timedEvent
+			if timestamps {
+				events = pctx.parsed.timedEvents[pctx.eventType]
+			}
+			if len(globs) > 0 {
+				r := newProfileRedactor(globs, []*stackFile{sf}, events)
+				fmt.Fprintln(os.Stderr, r.summary())
+				sf, events = r.stackFile(sf), r.events(events)
+			}
+			if apqOut != "" {
+				return saveAPQ(apqOut, args[0], pctx.parsed, map[string]*stackFile{pctx.eventType: sf})
+			}
+			if timestamps {
+				cmdCollapseTimed(events, shared.thread, shared.noIdle)
+				return nil
+			}
+			cmdCollapse(sf)
 			return nil
 		},
 	}
 	shared.register(cmd)
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	cmd.Flags().StringArrayVar(&redact, "redact", nil, redactUsage)
+	cmd.Flags().Lookup("event").Usage += "; \"all\" emits every event, each line labeled [event=NAME]"
+	cmd.Flags().StringVar(&apqOut, "apq", "", "Write the aggregated model (stacks with lines, threads and metadata of every event, or of --event) to this .apq file instead, for fast re-analysis by any command")
+	cmd.Flags().BoolVar(&timestamps, "timestamps", false, "Emit one line per sample prefixed with its start offset and duration in ns (JFR only)")
 	return cmd
 }
 
+// saveAPQ writes the .apq model of byEvent to path.
+func saveAPQ(path, source string, parsed *parsedProfile, byEvent map[string]*stackFile) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return ioErrorf("%v", err)
+	}
+	bw := bufio.NewWriter(f)
+	err = writeAPQ(bw, source, parsed, byEvent)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return ioErrorf("writing %s: %v", path, err)
+	}
+	stacks, samples := 0, 0
+	for _, sf := range byEvent {
+		stacks += len(sf.stacks)
+		samples += sf.totalSamples
+	}
+	fmt.Fprintf(os.Stderr, "Saved %d stacks (%d samples) to %s\n", stacks, samples, path)
+	return nil
+}
+
 func cmdCollapse(sf *stackFile) {
+	for _, c := range computeCollapsed(sf) {
+		fmt.Printf("%s %d\n", c.key, c.count)
+	}
+}
+
+// writeCollapsedAllEvents writes the stacks of every event, each line
+// starting with an "[event=NAME]" frame that --event honors when the output
+// is read back. Events are written in the usual event order.
+func writeCollapsedAllEvents(w io.Writer, byEvent map[string]*stackFile) {
+	events := make([]string, 0, len(byEvent))
+	for event := range byEvent {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		ri, rj := eventRank(events[i]), eventRank(events[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return events[i] < events[j]
+	})
+	for _, event := range events {
+		for _, c := range computeCollapsed(byEvent[event]) {
+			fmt.Fprintf(w, "%s%s];%s %d\n", eventLabelPrefix, event, c.key, c.count)
+		}
+	}
+}
+
+type collapsedEntry struct {
+	key   string // thread prefix + ";"-joined frames
+	count int
+}
+
+// computeCollapsed merges stacks that render to the same collapsed line
+// (e.g. differing only in line numbers, which collapsed text discards) and
+// sorts them by count descending, then by line text, so identical inputs
+// always produce identical output.
+func computeCollapsed(sf *stackFile) []collapsedEntry {
+	counts := make(map[string]int, len(sf.stacks))
 	for i := range sf.stacks {
 		st := &sf.stacks[i]
-		tp := threadPrefix(st.thread)
-		fmt.Printf("%s%s %d\n", tp, strings.Join(st.frames, ";"), st.count)
+		counts[threadMarkerPrefix(st.thread, st.tid)+strings.Join(st.frames, ";")] += st.count
+	}
+	entries := make([]collapsedEntry, 0, len(counts))
+	for key, count := range counts {
+		entries = append(entries, collapsedEntry{key: key, count: count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].count != entries[j].count {
+			return entries[i].count > entries[j].count
+		}
+		return entries[i].key < entries[j].key
+	})
+	return entries
+}
+
+// cmdCollapseTimed prints one "START DURATION STACK WEIGHT" line per event in
+// time order. START is nanoseconds since recording start and DURATION is the
+// event duration in nanoseconds (0 for sampled events), so external tools can
+// build their own time series without re-parsing the JFR. The stack keeps the
+// collapsed format, so a line splits on its first two and last spaces.
+func cmdCollapseTimed(events []timedEvent, thread string, noIdle bool) {
+	for _, l := range computeCollapsedTimed(events, thread, noIdle) {
+		fmt.Printf("%d %d %s %d\n", l.startNanos, l.durNanos, l.key, l.weight)
+	}
+}
+
+type timedCollapsedLine struct {
+	startNanos int64
+	durNanos   int64
+	key        string // thread prefix + ";"-joined frames
+	weight     int
+}
+
+// computeCollapsedTimed applies the thread and idle filters to timed events
+// (preprocessProfile only filters the aggregated stacks) and sorts them by
+// start time, then by stack text.
+func computeCollapsedTimed(events []timedEvent, thread string, noIdle bool) []timedCollapsedLine {
+	if noIdle {
+		events = filterIdleEvents(events)
+	}
+	lines := make([]timedCollapsedLine, 0, len(events))
+	for i := range events {
+		e := &events[i]
+		if thread != "" && !matchesThread(e.thread, e.tid, thread) {
+			continue
+		}
+		lines = append(lines, timedCollapsedLine{
+			startNanos: e.offsetNanos,
+			durNanos:   e.durNanos,
+			key:        threadMarkerPrefix(e.thread, e.tid) + strings.Join(e.frames, ";"),
+			weight:     e.weight,
+		})
 	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].startNanos != lines[j].startNanos {
+			return lines[i].startNanos < lines[j].startNanos
+		}
+		return lines[i].key < lines[j].key
+	})
+	return lines
 }
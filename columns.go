@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+const columnUsage = "Add a computed column NAME = EXPR (Starlark arithmetic over samples, pct, total, interval, seconds; $VAR reads a numeric environment variable); repeatable, @FILE reads one per line"
+
+// customColumn is one --column: a named expression evaluated per table row.
+type customColumn struct {
+	name string
+	expr string // with $VARs replaced by their values
+}
+
+// customColumns are the --column definitions of one table with the values
+// that are the same on every row. A nil *customColumns adds nothing.
+type customColumns struct {
+	cols  []customColumn
+	total int
+	env   starlark.StringDict // total, interval, seconds
+	width []int
+}
+
+var (
+	columnNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	columnVarRe  = regexp.MustCompile(`\$([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// parseColumnArgs reads the --column values: "NAME = EXPR" definitions, or
+// @FILE for a file with one per line, so a team can keep its conventions
+// in one place.
+func parseColumnArgs(args []string) ([]customColumn, error) {
+	var res []customColumn
+	for _, arg := range args {
+		defs := []string{arg}
+		if file, ok := strings.CutPrefix(arg, "@"); ok {
+			var err error
+			if defs, err = readPatternFile(file); err != nil {
+				return nil, err
+			}
+		}
+		for _, def := range defs {
+			c, err := parseColumn(def)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, c)
+		}
+	}
+	return res, nil
+}
+
+func parseColumn(def string) (customColumn, error) {
+	name, expr, ok := strings.Cut(def, "=")
+	name, expr = strings.TrimSpace(name), strings.TrimSpace(expr)
+	if !ok || !columnNameRe.MatchString(name) || expr == "" {
+		return customColumn{}, fmt.Errorf("invalid --column %q (want NAME = EXPR)", def)
+	}
+	var varErr error
+	expr = columnVarRe.ReplaceAllStringFunc(expr, func(v string) string {
+		val, set := os.LookupEnv(v[1:])
+		if !set {
+			varErr = fmt.Errorf("--column %s: %s is not set", name, v)
+			return v
+		}
+		if _, err := strconv.ParseFloat(val, 64); err != nil {
+			varErr = fmt.Errorf("--column %s: %s=%q is not a number", name, v, val)
+			return v
+		}
+		return val
+	})
+	if varErr != nil {
+		return customColumn{}, varErr
+	}
+	if _, err := syntax.ParseExpr(name, expr, 0); err != nil {
+		return customColumn{}, fmt.Errorf("--column %s: %v", name, err)
+	}
+	return customColumn{name: name, expr: expr}, nil
+}
+
+// newCustomColumns binds cols to a profile. interval (ms of CPU time per
+// sample) is defined for cpu profiles that record it, seconds (the span of
+// the recording or --from/--to window) when the duration is known. Each
+// expression is compiled here so a typo fails before any output; errors
+// that depend on the row, such as a division by zero, only blank its cells.
+func newCustomColumns(cols []customColumn, pctx *profileContext) (*customColumns, error) {
+	if len(cols) == 0 {
+		return nil, nil
+	}
+	total := pctx.sf.totalSamples
+	cc := &customColumns{cols: cols, total: total, env: starlark.StringDict{"total": starlark.MakeInt(total)}}
+	if pctx.eventType == "cpu" && pctx.cpuInterval > 0 {
+		cc.env["interval"] = starlark.Float(float64(pctx.cpuInterval) / 1e6)
+	}
+	if r, err := newHotRate(pctx); err == nil {
+		cc.env["seconds"] = starlark.Float(float64(r.spanNanos) / 1e9)
+	}
+	for _, c := range cols {
+		if err := cc.check(c); err != nil {
+			return nil, fmt.Errorf("--column %s: %v", c.name, err)
+		}
+		cc.width = append(cc.width, max(len(c.name), 10))
+	}
+	return cc, nil
+}
+
+// check compiles c against the names a row defines, naming the first one
+// the expression reads that this profile does not.
+func (cc *customColumns) check(c customColumn) error {
+	expr, err := syntax.ParseExpr(c.name, c.expr, 0)
+	if err != nil {
+		return err
+	}
+	var missing string
+	isPredeclared := func(name string) bool {
+		if name == "samples" || name == "pct" || cc.env.Has(name) {
+			return true
+		}
+		if missing == "" && !starlark.Universe.Has(name) {
+			missing = name
+		}
+		return false
+	}
+	_, err = resolve.Expr(expr, isPredeclared, starlark.Universe.Has)
+	switch missing {
+	case "":
+		return err
+	case "interval":
+		return fmt.Errorf("interval needs a cpu JFR recording with a sampling interval")
+	case "seconds":
+		return fmt.Errorf("seconds needs a recording with a known duration")
+	}
+	return fmt.Errorf("undefined: %s (want samples, pct, total, interval or seconds)", missing)
+}
+
+func (cc *customColumns) eval(c customColumn, samples int) (string, error) {
+	env := starlark.StringDict{
+		"samples": starlark.MakeInt(samples),
+		"pct":     starlark.Float(pctOf(samples, cc.total)),
+	}
+	for k, v := range cc.env {
+		env[k] = v
+	}
+	v, err := starlark.EvalOptions(&syntax.FileOptions{}, &starlark.Thread{Name: "column"}, c.name, c.expr, env)
+	if err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case starlark.Int:
+		return v.String(), nil
+	case starlark.Float:
+		return strconv.FormatFloat(float64(v), 'f', 2, 64), nil
+	}
+	return "", fmt.Errorf("got %s, want a number", v.Type())
+}
+
+// header returns the column titles to append to a table header.
+func (cc *customColumns) header() string {
+	if cc == nil {
+		return ""
+	}
+	var b strings.Builder
+	for i, c := range cc.cols {
+		fmt.Fprintf(&b, " %*s", cc.width[i], c.name)
+	}
+	return b.String()
+}
+
+// values returns the cells of a row with the given samples; a row the
+// expression fails on (e.g. a division by zero) shows "-".
+func (cc *customColumns) values(samples int) string {
+	if cc == nil {
+		return ""
+	}
+	var b strings.Builder
+	for i, c := range cc.cols {
+		v, err := cc.eval(c, samples)
+		if err != nil {
+			v = "-"
+		}
+		fmt.Fprintf(&b, " %*s", cc.width[i], v)
+	}
+	return b.String()
+}
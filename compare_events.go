@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newCompareEventsCmd() *cobra.Command {
+	var shared sharedFlags
+	var top int
+	var fqn bool
+	var sortBy string
+	cmd := &cobra.Command{
+		Use:   "compare-events <file>",
+		Short: "Per-method TOTAL% side by side for every event in one recording",
+		Long: `Compare-events prints one row per method with its TOTAL% in each event of
+the profile, so divergences stand out: a method high in wall but low in cpu
+is blocked or waiting; high in alloc but low in cpu is a GC pressure source.
+The NOTE column flags such methods when the gap is at least 10 points.`,
+		Example: strings.Join([]string{
+			"  ap-query compare-events profile.jfr",
+			"  ap-query compare-events profile.jfr --sort wall --thread http-nio --top 30",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if shared.event != "" {
+				return fmt.Errorf("compare-events shows every event; --event does not apply (use --sort EVENT to rank by one)")
+			}
+			pctx, err := preprocessProfile(shared.toOpts(args[0], "compare-events"))
+			if err != nil {
+				return err
+			}
+			if pctx.parsed == nil {
+				return fmt.Errorf("compare-events requires a JFR, pprof or event-labeled collapsed input")
+			}
+			byEvent := make(map[string]*stackFile, len(pctx.parsed.stacksByEvent))
+			for event, sf := range pctx.parsed.stacksByEvent {
+				sf = sf.filterByThread(shared.thread)
+				if shared.noIdle {
+					sf = sf.filterIdle()
+				}
+				if sf.totalSamples > 0 {
+					byEvent[event] = sf
+				}
+			}
+			if sortBy != "" && byEvent[sortBy] == nil {
+				return fmt.Errorf("--sort: event %q has no samples (available: %s)", sortBy, strings.Join(sortedEvents(byEvent), ", "))
+			}
+			cmdCompareEvents(byEvent, top, fqn, sortBy)
+			return nil
+		},
+	}
+	shared.register(cmd)
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	cmd.Flags().IntVar(&top, "top", 20, "Limit output rows")
+	cmd.Flags().BoolVar(&fqn, "fqn", false, "Show fully-qualified names")
+	cmd.Flags().StringVar(&sortBy, "sort", "", "Rank by one event's TOTAL% (default: highest TOTAL% in any event)")
+	return cmd
+}
+
+// divergenceGap is the TOTAL% gap, in points, at which compare-events
+// flags a method in the NOTE column.
+const divergenceGap = 10.0
+
+// compareRow is one method's TOTAL% per event, keyed by event name.
+type compareRow struct {
+	name string
+	pct  map[string]float64
+}
+
+// sortedEvents returns the events of byEvent in the usual event order.
+func sortedEvents(byEvent map[string]*stackFile) []string {
+	events := make([]string, 0, len(byEvent))
+	for e := range byEvent {
+		events = append(events, e)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		ri, rj := eventRank(events[i]), eventRank(events[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return events[i] < events[j]
+	})
+	return events
+}
+
+// computeCompareEvents returns a row per method present in any event,
+// ranked by the sortBy event's TOTAL% (or the highest in any event when
+// sortBy is empty), then name.
+func computeCompareEvents(byEvent map[string]*stackFile, fqn bool, sortBy string) []compareRow {
+	rows := make(map[string]*compareRow)
+	for event, sf := range byEvent {
+		for _, e := range computeHot(sf, fqn) {
+			r := rows[e.name]
+			if r == nil {
+				r = &compareRow{name: e.name, pct: make(map[string]float64)}
+				rows[e.name] = r
+			}
+			r.pct[event] = pctOf(e.totalCount, sf.totalSamples)
+		}
+	}
+	key := func(r *compareRow) float64 {
+		if sortBy != "" {
+			return r.pct[sortBy]
+		}
+		best := 0.0
+		for _, p := range r.pct {
+			best = max(best, p)
+		}
+		return best
+	}
+
+	ranked := make([]compareRow, 0, len(rows))
+	for _, r := range rows {
+		ranked = append(ranked, *r)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		ki, kj := key(&ranked[i]), key(&ranked[j])
+		if ki != kj {
+			return ki > kj
+		}
+		return ranked[i].name < ranked[j].name
+	})
+	return ranked
+}
+
+// divergenceNote explains a row whose wall or alloc share exceeds its cpu
+// share by at least divergenceGap points. It needs a cpu column to compare to.
+func divergenceNote(r compareRow, byEvent map[string]*stackFile) string {
+	if byEvent["cpu"] == nil {
+		return ""
+	}
+	var notes []string
+	if byEvent["wall"] != nil && r.pct["wall"]-r.pct["cpu"] >= divergenceGap {
+		notes = append(notes, "wall>cpu: blocking")
+	}
+	if byEvent["alloc"] != nil && r.pct["alloc"]-r.pct["cpu"] >= divergenceGap {
+		notes = append(notes, "alloc>cpu: GC pressure")
+	}
+	return strings.Join(notes, ", ")
+}
+
+func cmdCompareEvents(byEvent map[string]*stackFile, top int, fqn bool, sortBy string) {
+	if len(byEvent) == 0 {
+		fmt.Println("no samples (empty profile or all filtered out)")
+		return
+	}
+	events := sortedEvents(byEvent)
+	ranked := computeCompareEvents(byEvent, fqn, sortBy)
+	shown := ranked[:truncate(len(ranked), top)]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-50s", "METHOD")
+	for _, e := range events {
+		fmt.Fprintf(&b, " %8s", strings.ToUpper(e))
+	}
+	fmt.Fprintf(&b, "  %s", "NOTE")
+	fmt.Println(b.String())
+	for _, r := range shown {
+		b.Reset()
+		fmt.Fprintf(&b, "%-50s", r.name)
+		for _, e := range events {
+			if p, ok := r.pct[e]; ok {
+				fmt.Fprintf(&b, " %7.*f%%", pctDigits, p)
+			} else {
+				fmt.Fprintf(&b, " %8s", "-")
+			}
+		}
+		fmt.Fprintf(&b, "  %s", divergenceNote(r, byEvent))
+		fmt.Println(strings.TrimRight(b.String(), " "))
+	}
+	if len(shown) < len(ranked) {
+		fmt.Printf("(%d of %d methods shown)\n", len(shown), len(ranked))
+	}
+}
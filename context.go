@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/grafana/jfr-parser/parser"
+	"github.com/grafana/jfr-parser/parser/types/def"
+)
+
+// eventContextID returns the context id the profiler tagged the current
+// event with (the contextId field async-profiler builds with tracing
+// context support write), or 0 when it has none.
+func eventContextID(p *parser.Parser, typ def.TypeID) uint64 {
+	switch typ {
+	case p.TypeMap.T_EXECUTION_SAMPLE:
+		return p.ExecutionSample.ContextId
+	case p.TypeMap.T_WALL_CLOCK_SAMPLE:
+		return p.WallClockSample.ContextId
+	case p.TypeMap.T_ALLOC_IN_NEW_TLAB:
+		return p.ObjectAllocationInNewTLAB.ContextId
+	case p.TypeMap.T_ALLOC_OUTSIDE_TLAB:
+		return p.ObjectAllocationOutsideTLAB.ContextId
+	case p.TypeMap.T_MONITOR_ENTER:
+		return p.JavaMonitorEnter.ContextId
+	}
+	return 0
+}
+
+// parseContextID parses a --context value: a context id, or "none" for
+// samples without one.
+func parseContextID(s string) (uint64, error) {
+	if s == "none" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid --context %q (want a context id, or none for untagged samples)", s)
+	}
+	return id, nil
+}
+
+// contextFrame is the root frame --group-by context puts above a stack.
+func contextFrame(id uint64) string {
+	if id == 0 {
+		return "[context=none]"
+	}
+	return "[context=" + strconv.FormatUint(id, 10) + "]"
+}
+
+type contextRootKey struct {
+	stack *cachedStackTrace
+	id    uint64
+}
+
+// contextRoots memoizes resolved stacks with their context root frame
+// added. A nil contextRoots leaves stacks as they are.
+type contextRoots map[contextRootKey]*cachedStackTrace
+
+func (c contextRoots) apply(cached *cachedStackTrace, id uint64) *cachedStackTrace {
+	if c == nil || len(cached.frames) == 0 {
+		return cached
+	}
+	key := contextRootKey{cached, id}
+	if out, ok := c[key]; ok {
+		return out
+	}
+	out := &cachedStackTrace{
+		frames:    append([]string{contextFrame(id)}, cached.frames...),
+		lines:     append([]uint32{0}, cached.lines...),
+		truncated: cached.truncated,
+	}
+	if cached.details != nil {
+		out.details = append([]frameDetail{{}}, cached.details...)
+	}
+	out.key = buildStackKeyWithLines(out.frames, out.lines)
+	if out.details != nil {
+		out.key += frameDetailSuffix(out.details)
+	}
+	c[key] = out
+	return out
+}
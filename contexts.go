@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newContextsCmd() *cobra.Command {
+	var shared sharedFlags
+	var mf methodFlags
+	var depth, top int
+	var fqn bool
+	cmd := &cobra.Command{
+		Use:   "contexts <file>",
+		Short: "Split a method's samples by caller path (-m required)",
+		Long: `Contexts partitions the inclusive samples of the -m method by the path
+that called it: the --depth frames above the method, root side first.
+Each row is one distinct caller path with its share of the method and of
+all samples, so one table answers whether the time comes from one call
+site or many. Use --depth 1 for immediate callers only, --depth 0 for the
+whole path from the thread root.`,
+		Example: strings.Join([]string{
+			"  ap-query contexts profile.jfr -m HashMap.resize",
+			"  ap-query contexts profile.jfr -m HashMap.resize --depth 1",
+			"  ap-query contexts profile.jfr -m Cache.get --depth 0 --top 5",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if mf.method == "" {
+				return fmt.Errorf("-m/--method required")
+			}
+			if err := mf.validate(); err != nil {
+				return err
+			}
+			if depth < 0 {
+				return fmt.Errorf("--depth must be non-negative (got %d)", depth)
+			}
+			pctx, err := preprocessProfile(shared.toOpts(args[0], "contexts"))
+			if err != nil {
+				return err
+			}
+			m, err := mf.resolve(pctx.sf, args[0])
+			if err != nil {
+				return err
+			}
+			cmdContexts(pctx.sf, m, depth, top, fqn)
+			return nil
+		},
+	}
+	shared.register(cmd)
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	shared.registerGroupBy(cmd)
+	mf.register(cmd, "Substring match on method name (required)")
+	cmd.Flags().IntVar(&depth, "depth", 4, "Caller frames per path (0 = up to the thread root)")
+	cmd.Flags().IntVar(&top, "top", 10, "Limit output rows")
+	cmd.Flags().BoolVar(&fqn, "fqn", false, "Show fully-qualified names")
+	return cmd
+}
+
+type callerContext struct {
+	path    string // caller frames, root side first, joined with " > "
+	samples int
+}
+
+// computeContexts returns the samples of the stacks m selects per caller
+// path, largest first, their total, and the methods that matched. The path
+// ends at the outermost matching frame, as in callers, so recursion does
+// not split contexts.
+func computeContexts(sf *stackFile, m methodMatcher, depth int, fqn bool) ([]callerContext, int, []string) {
+	counts := make(map[string]int)
+	methods := make(map[string]bool)
+	matched := 0
+	fm := sf.match(m)
+	for _, i := range fm.stacks {
+		st := &sf.stacks[i]
+		for j, fr := range st.frames {
+			if !fm.frames[fr] {
+				continue
+			}
+			methods[displayName(fr, fqn)] = true
+			start := 0
+			if depth > 0 && j > depth {
+				start = j - depth
+			}
+			names := make([]string, 0, j-start+1)
+			if start > 0 {
+				names = append(names, "...")
+			}
+			for _, caller := range st.frames[start:j] {
+				names = append(names, displayName(caller, fqn))
+			}
+			if len(names) == 0 {
+				names = append(names, "(thread root)")
+			}
+			counts[strings.Join(names, " > ")] += st.count
+			matched += st.count
+			break
+		}
+	}
+	ranked := make([]callerContext, 0, len(counts))
+	for path, n := range counts {
+		ranked = append(ranked, callerContext{path, n})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].samples != ranked[j].samples {
+			return ranked[i].samples > ranked[j].samples
+		}
+		return ranked[i].path < ranked[j].path
+	})
+	names := make([]string, 0, len(methods))
+	for n := range methods {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return ranked, matched, names
+}
+
+func cmdContexts(sf *stackFile, m methodMatcher, depth, top int, fqn bool) {
+	if sf.totalSamples == 0 {
+		fmt.Fprintln(os.Stdout, "no samples (empty profile or all filtered out)")
+		return
+	}
+	ranked, matched, methods := computeContexts(sf, m, depth, fqn)
+	if matched == 0 {
+		noMatchMessage(os.Stdout, sf, m.pattern)
+		return
+	}
+	if len(methods) > 1 {
+		fmt.Printf("# matched %d methods: %s\n", len(methods), strings.Join(methods, ", "))
+	}
+	fmt.Printf("# %d samples in '%s' (%.*f%% of total) from %d caller paths\n",
+		matched, m.pattern, pctDigits, pctOf(matched, sf.totalSamples), len(ranked))
+	shown := ranked[:truncate(len(ranked), top)]
+	fmt.Printf("%9s %8s %8s  %s\n", "SAMPLES", "METHOD%", "TOTAL%", "CALLER PATH")
+	for _, c := range shown {
+		fmt.Printf("%9d %7.*f%% %7.*f%%  %s\n", c.samples,
+			pctDigits, pctOf(c.samples, matched), pctDigits, pctOf(c.samples, sf.totalSamples), c.path)
+	}
+	if len(shown) < len(ranked) {
+		rest := 0
+		for _, c := range ranked[len(shown):] {
+			rest += c.samples
+		}
+		fmt.Printf("(%d more caller paths, %d samples, %.*f%% of the method)\n",
+			len(ranked)-len(shown), rest, pctDigits, pctOf(rest, matched))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/grafana/jfr-parser/parser"
+)
+
+// cpuLimit is the number of CPUs the profiled JVM could run on. Under a
+// cgroup quota a cpu profile's percentages are shares of what the JVM was
+// allowed, and time it spent throttled does not show up at all.
+type cpuLimit struct {
+	cpus   float64 // 0 = unknown
+	source string  // cpuLimitCgroup, cpuLimitFlag or cpuLimitHost
+}
+
+const (
+	cpuLimitCgroup = "cgroup" // jdk.ContainerConfiguration
+	cpuLimitFlag   = "--cpus"
+	cpuLimitHost   = "host" // jdk.CPUInformation: no container limit seen
+)
+
+// cpuLimitSlack is how far the cores implied by the cpu samples may exceed
+// the limit before ap-query warns, for sampling jitter.
+const cpuLimitSlack = 1.1
+
+var cpuLimitEventNames = []string{"jdk.ContainerConfiguration", "jdk.CPUInformation"}
+
+// readCPULimit reads the CPU limit from the JDK's container and CPU events
+// of a JFR recording; the zero cpuLimit when it has neither. A cgroup quota
+// (cpuQuota/cpuSlicePeriod) wins over a cpuset (effectiveCpuCount below the
+// hardware threads), which wins over the host's hardware threads.
+func readCPULimit(path string) (cpuLimit, error) {
+	buf, err := readJFRBytes(path)
+	if err != nil {
+		return cpuLimit{}, err
+	}
+	var quota, period, effective, hwThreads int64
+	err = scanJDKEvents(buf, cpuLimitEventNames, func(_ *parser.Parser, ev *jdkEvent) {
+		switch ev.name {
+		case "jdk.ContainerConfiguration":
+			quota = int64(ev.nums["cpuQuota"])
+			period = int64(ev.nums["cpuSlicePeriod"])
+			effective = int64(ev.nums["effectiveCpuCount"])
+		case "jdk.CPUInformation":
+			hwThreads = int64(ev.nums["hwThreads"])
+		}
+	})
+	if err != nil {
+		return cpuLimit{}, err
+	}
+	switch {
+	case quota > 0 && period > 0:
+		return cpuLimit{cpus: float64(quota) / float64(period), source: cpuLimitCgroup}, nil
+	case effective > 0 && (hwThreads == 0 || effective < hwThreads):
+		return cpuLimit{cpus: float64(effective), source: cpuLimitCgroup}, nil
+	case hwThreads > 0:
+		return cpuLimit{cpus: float64(hwThreads), source: cpuLimitHost}, nil
+	}
+	return cpuLimit{}, nil
+}
+
+// limited reports whether the JVM ran under a limit rather than on the
+// whole host.
+func (l cpuLimit) limited() bool {
+	return l.cpus > 0 && l.source != cpuLimitHost
+}
+
+func (l cpuLimit) describe() string {
+	n := strconv.FormatFloat(l.cpus, 'g', 3, 64)
+	switch l.source {
+	case cpuLimitCgroup:
+		return fmt.Sprintf("profile captured under a %s-CPU cgroup limit", n)
+	case cpuLimitFlag:
+		return fmt.Sprintf("profile captured under a %s-CPU limit (--cpus)", n)
+	}
+	return fmt.Sprintf("profile captured on a host with %s hardware threads", n)
+}
+
+// exceededBy returns a warning when the cpu samples imply more busy cores
+// than the limit allows, or "".
+func (l cpuLimit) exceededBy(cores float64) string {
+	if l.cpus <= 0 || cores <= l.cpus*cpuLimitSlack {
+		return ""
+	}
+	return fmt.Sprintf("cpu samples imply %.2f busy cores but only %s CPUs were available; check --cpus and the recording's interval",
+		cores, strconv.FormatFloat(l.cpus, 'g', 3, 64))
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/jfr-parser/parser"
+	"github.com/grafana/jfr-parser/parser/types"
+)
+
+// isCustomEventName reports whether an --event value names a JFR event
+// type (com.acme.Request, jdk.ThreadPark) rather than an ap-query event.
+// JFR type names are qualified; ap-query and hardware counter names are not.
+func isCustomEventName(name string) bool {
+	return strings.Contains(name, ".") && !isKnownEventType(name)
+}
+
+// aggregatorEvent is one custom event as an --aggregator command reads it,
+// one JSON object per line on its stdin.
+type aggregatorEvent struct {
+	Type       string         `json:"type"`
+	StartNanos int64          `json:"start_ns"`
+	DurNanos   int64          `json:"duration_ns"`
+	Thread     string         `json:"thread"`
+	Stack      []string       `json:"stack"` // root first
+	Fields     map[string]any `json:"fields"`
+}
+
+// customEventFields returns the event's own fields: the ones every event
+// has (time, duration, thread, stack) are reported separately.
+func customEventFields(ev *jdkEvent) map[string]any {
+	fields := make(map[string]any, len(ev.nums)+len(ev.strs)+len(ev.floats))
+	for k, v := range ev.nums {
+		fields[k] = v
+	}
+	for k, v := range ev.floats {
+		fields[k] = v
+	}
+	for k, v := range ev.strs { // resolved pool strings win over their ids
+		fields[k] = v
+	}
+	for _, k := range []string{"startTime", "duration", "eventThread", "stackTrace"} {
+		delete(fields, k)
+	}
+	return fields
+}
+
+// parseCustomEventProfile aggregates the JFR events of type name into a
+// profile with a single event of that name, so every command can rank,
+// nest and filter them like built-in events. Each event weighs 1 unless
+// aggregator, a command line, assigns weights: it reads the events as JSON
+// lines and answers each with one line "WEIGHT [KEY]", where weight 0
+// drops the event and a KEY becomes a "[KEY]" root frame. A nil profile
+// means the file has no events of that type and no aggregator was given.
+func parseCustomEventProfile(path, name, aggregator string, opts parseOpts) (*parsedProfile, error) {
+	buf, err := readJFRBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	originNanos, spanNanos, err := scanChunkHeaders(buf)
+	if err != nil {
+		return nil, parseErrorf("%v", err)
+	}
+
+	var events []timedEvent
+	var raw []aggregatorEvent // parallel to events when aggregating
+	var stackCache map[types.StackTraceRef]*cachedStackTrace
+	var lastParser *parser.Parser
+	err = scanJDKEvents(buf, []string{name}, func(p *parser.Parser, ev *jdkEvent) {
+		if opts.collectTimestamps &&
+			((opts.fromNanos >= 0 && ev.startNanos < opts.fromNanos) || (opts.toNanos >= 0 && ev.startNanos >= opts.toNanos)) {
+			return
+		}
+		if p != lastParser { // stack trace ids are per chunk
+			stackCache = make(map[types.StackTraceRef]*cachedStackTrace)
+			lastParser = p
+		}
+		cached := resolveStackTraceCached(p, stackCache, nil, types.StackTraceRef(ev.nums["stackTrace"]))
+		if len(cached.frames) == 0 && aggregator == "" {
+			return
+		}
+		thread := resolveThread(p, types.ThreadRef(ev.nums["eventThread"]))
+		events = append(events, timedEvent{
+			offsetNanos: ev.startNanos,
+			stackKey:    cached.key,
+			frames:      cached.frames,
+			lines:       cached.lines,
+			thread:      thread,
+			weight:      1,
+			durNanos:    ev.durNanos,
+		})
+		if aggregator != "" {
+			raw = append(raw, aggregatorEvent{
+				Type:       name,
+				StartNanos: ev.startNanos,
+				DurNanos:   ev.durNanos,
+				Thread:     thread,
+				Stack:      cached.frames,
+				Fields:     customEventFields(ev),
+			})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(events) == 0 && aggregator == "" {
+		return nil, nil
+	}
+	if aggregator != "" {
+		if events, err = runAggregator(aggregator, events, raw); err != nil {
+			return nil, err
+		}
+	}
+
+	count := 0
+	for _, e := range events {
+		count += e.weight
+	}
+	parsed := &parsedProfile{
+		eventCounts:   map[string]int{name: count},
+		stacksByEvent: map[string]*stackFile{name: buildStackFileFromTimed(events)},
+		originNanos:   originNanos,
+		spanNanos:     spanNanos,
+		execEventName: "cpu",
+	}
+	if opts.collectTimestamps {
+		parsed.timedEvents = map[string][]timedEvent{name: events}
+	}
+	return parsed, nil
+}
+
+// runAggregator streams raw to the aggregator command and applies its
+// answers to events, dropping the events it weighs 0 or without a stack.
+func runAggregator(command string, events []timedEvent, raw []aggregatorEvent) ([]timedEvent, error) {
+	argv, err := splitCommand(command)
+	if err != nil {
+		return nil, fmt.Errorf("--aggregator: %v", err)
+	}
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("--aggregator is empty")
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, ioErrorf("aggregator: %v", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, ioErrorf("aggregator: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, ioErrorf("aggregator %s: %v", argv[0], err)
+	}
+	go func() {
+		w := bufio.NewWriter(stdin)
+		enc := json.NewEncoder(w)
+		for i := range raw {
+			if enc.Encode(&raw[i]) != nil {
+				break // the command exited early; Wait reports it
+			}
+		}
+		w.Flush()
+		stdin.Close()
+	}()
+
+	kept := events[:0]
+	sc := bufio.NewScanner(stdout)
+	n := 0
+	for sc.Scan() {
+		if n == len(events) {
+			n++
+			break
+		}
+		weight, key, err := parseAggregatorLine(sc.Text())
+		if err != nil {
+			io.Copy(io.Discard, stdout)
+			cmd.Wait()
+			return nil, parseErrorf("aggregator %s, answer %d: %v", argv[0], n+1, err)
+		}
+		e := events[n]
+		n++
+		if weight == 0 || len(e.frames) == 0 {
+			continue
+		}
+		e.weight = weight
+		if key != "" {
+			e.frames = append([]string{"[" + key + "]"}, e.frames...)
+			e.lines = append([]uint32{0}, e.lines...)
+			e.stackKey = buildStackKeyWithLines(e.frames, e.lines)
+		}
+		kept = append(kept, e)
+	}
+	if err := sc.Err(); err != nil {
+		io.Copy(io.Discard, stdout)
+		cmd.Wait()
+		return nil, ioErrorf("aggregator %s, reading answer %d: %v", argv[0], n+1, err)
+	}
+	io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return nil, ioErrorf("aggregator %s: %v", argv[0], err)
+	}
+	if n != len(events) {
+		return nil, parseErrorf("aggregator %s answered %d lines for %d events (want one \"WEIGHT [KEY]\" line per event)", argv[0], n, len(events))
+	}
+	return kept, nil
+}
+
+// splitCommand splits command into arguments the way a POSIX shell does for
+// plain words: whitespace separates arguments, single quotes keep their
+// content literally, and double quotes and backslashes escape spaces and
+// quotes. No expansion happens.
+func splitCommand(command string) ([]string, error) {
+	var argv []string
+	var cur strings.Builder
+	inWord := false
+	var quote byte
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				cur.WriteByte(c)
+			}
+		case quote == '"':
+			switch {
+			case c == '"':
+				quote = 0
+			case c == '\\' && i+1 < len(command) && strings.IndexByte(`"\$`+"`", command[i+1]) >= 0:
+				i++
+				cur.WriteByte(command[i])
+			default:
+				cur.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == '\\':
+			if i+1 < len(command) {
+				i++
+				cur.WriteByte(command[i])
+			}
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				argv = append(argv, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, command)
+	}
+	if inWord {
+		argv = append(argv, cur.String())
+	}
+	return argv, nil
+}
+
+// parseAggregatorLine parses one "WEIGHT [KEY]" answer.
+func parseAggregatorLine(line string) (int, string, error) {
+	w, key, _ := strings.Cut(strings.TrimSpace(line), " ")
+	weight, err := strconv.Atoi(w)
+	if err != nil || weight < 0 {
+		return 0, "", fmt.Errorf("invalid weight %q (want a non-negative integer)", w)
+	}
+	return weight, strings.TrimSpace(key), nil
+}
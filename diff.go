@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"maps"
 	"math"
+	"os"
 	"sort"
 	"strings"
 
@@ -47,16 +49,153 @@ func newDiffCmd() *cobra.Command {
 	var toStr string
 	var vsFromStr string
 	var vsToStr string
+	var renameMapPath string
+	var ignoreMethods []string
+	var ignoreThreads []string
+	var flatThreads bool
+	var byThread bool
+	var threadNormalize []string
+	var foldCase bool
+	var canonical bool
+	var format string
+	var byPackage bool
+	var repoURL, sourceRoot string
 	cmd := &cobra.Command{
-		Use:   "diff <before> <after> | diff <file> --from DURATION [--to DURATION] --vs-from DURATION [--vs-to DURATION]",
+		Use:   "diff <before> <after> [<more>...] | diff <file> --from DURATION [--to DURATION] --vs-from DURATION [--vs-to DURATION]",
 		Short: "Compare two profiles: shows REGRESSION / IMPROVEMENT / NEW / GONE",
 		Example: strings.Join([]string{
 			"  ap-query diff before.jfr after.jfr --min-delta 0.5",
 			"  ap-query diff profile.jfr --from 55s --to 1m05s --vs-from 2m45s --vs-to 3m10s",
+			"  ap-query diff base.jfr candidateA.jfr candidateB.jfr",
+			"  ap-query diff before.jfr after.jfr --rename-map renames.txt --fqn",
+			"  ap-query diff before.jfr after.jfr --ignore 'GC*' --ignore-threads 'C2 Compiler*'",
+			"  ap-query diff before.jfr after.jfr --ignore @noisy-methods.txt",
+			"  ap-query diff before.jfr after.jfr --flat-threads",
+			"  ap-query diff before.jfr after.jfr --flat-threads --thread-normalize suffix",
+			"  ap-query diff before.jfr after.jfr --by-thread --thread-normalize forkjoin",
+			"  ap-query diff before.jfr after.jfr --format patch --by-package",
+			"  ap-query diff before.jfr after.jfr --format html > diff.html",
+			"  ap-query diff before.jfr after.jfr --format html --source-root . --repo-url 'https://github.com/org/app/blob/{sha}/{path}#L{line}'",
 		}, "\n"),
-		Args: cobra.RangeArgs(1, 2),
+		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			windowMode := fromStr != "" || toStr != "" || vsFromStr != "" || vsToStr != ""
+			if flatThreads && len(args) > 2 {
+				return fmt.Errorf("--flat-threads compares exactly two profiles or windows")
+			}
+			if byThread && len(args) > 2 {
+				return fmt.Errorf("--by-thread compares exactly two profiles or windows")
+			}
+			if byThread && flatThreads {
+				return fmt.Errorf("--by-thread cannot be combined with --flat-threads")
+			}
+			if byPackage && format != "patch" {
+				return fmt.Errorf("--by-package requires --format patch")
+			}
+			switch format {
+			case "text":
+			case "patch", "html":
+				if flatThreads {
+					return fmt.Errorf("--format %s compares methods; it cannot be combined with --flat-threads", format)
+				}
+				if byThread {
+					return fmt.Errorf("--format %s cannot be combined with --by-thread", format)
+				}
+				if len(args) > 2 {
+					return fmt.Errorf("--format %s compares exactly two profiles or windows", format)
+				}
+			default:
+				return fmt.Errorf("invalid --format %q (want text, patch or html)", format)
+			}
+			if repoURL != "" && format != "html" {
+				return fmt.Errorf("--repo-url requires --format html")
+			}
+			if sourceRoot != "" && repoURL == "" {
+				return fmt.Errorf("--source-root requires --repo-url")
+			}
+			var links *repoLinker
+			if repoURL != "" {
+				var owners *ownerIndex
+				var err error
+				if sourceRoot != "" {
+					if owners, err = loadOwnerIndex(sourceRoot); err != nil {
+						return err
+					}
+				}
+				if links, err = newRepoLinker(repoURL, sourceRoot, owners); err != nil {
+					return err
+				}
+			}
+			report := cmdDiff
+			if format != "text" {
+				beforeLabel, afterLabel := args[0], ""
+				if len(args) == 1 {
+					beforeLabel = diffWindowLabel(args[0], fromStr, toStr)
+					afterLabel = diffWindowLabel(args[0], vsFromStr, vsToStr)
+				} else {
+					afterLabel = args[1]
+				}
+				report = func(before, after *stackFile, minDelta float64, top int, fqn bool, ignore *diffIgnore) {
+					if format == "html" {
+						var urls map[string]string
+						if links != nil {
+							// Gone methods are only in before; after wins for the rest.
+							urls = links.urlsByName(before, fqn)
+							maps.Copy(urls, links.urlsByName(after, fqn))
+						}
+						cmdDiffHTML(os.Stdout, beforeLabel, afterLabel, before, after, minDelta, top, fqn, ignore, urls)
+						return
+					}
+					cmdDiffPatch(beforeLabel, afterLabel, before, after, minDelta, top, fqn, byPackage, ignore)
+				}
+			}
+			if flatThreads {
+				report = func(before, after *stackFile, minDelta float64, top int, _ bool, _ *diffIgnore) {
+					cmdDiffThreads(before, after, minDelta, top)
+				}
+			}
+			if byThread {
+				report = cmdDiffByThread
+			}
+			ignore, err := newDiffIgnore(ignoreMethods, ignoreThreads)
+			if err != nil {
+				return err
+			}
+			normalizer, err := newThreadNormalizer(threadNormalize)
+			if err != nil {
+				return err
+			}
+			var rewrites []func(string) string
+			if foldCase {
+				rewrites = append(rewrites, foldNativeCase)
+			}
+			if canonical {
+				rewrites = append(rewrites, canonicalSyntheticName)
+			}
+			rewrite := func(sf *stackFile) *stackFile {
+				for _, fn := range rewrites {
+					sf, _ = sf.mapFrames(fn)
+				}
+				return sf
+			}
+			// prepare runs before --rename-map, so a rename map sees (and
+			// can target) canonical frame and normalized thread names.
+			prepare := func(sf *stackFile) *stackFile {
+				sf = rewrite(sf)
+				if normalizer != nil {
+					sf = normalizer.apply(sf)
+				}
+				return sf
+			}
+			var renames *renameMap
+			if renameMapPath != "" {
+				if len(args) == 1 {
+					return fmt.Errorf("--rename-map requires two or more files")
+				}
+				if renames, err = loadRenameMap(renameMapPath); err != nil {
+					return err
+				}
+			}
 			if len(args) == 1 {
 				path := args[0]
 				if !windowMode {
@@ -79,11 +218,17 @@ func newDiffCmd() *cobra.Command {
 				if !afterWindow.specified {
 					return fmt.Errorf("single-file diff requires at least one of --vs-from or --vs-to")
 				}
-				return runSingleFileWindowDiff(path, beforeWindow, afterWindow, event, thread, minDelta, top, fqn)
+				windowReport := func(before, after *stackFile, minDelta float64, top int, fqn bool, ignore *diffIgnore) {
+					report(prepare(before), prepare(after), minDelta, top, fqn, ignore)
+				}
+				return runSingleFileWindowDiff(path, beforeWindow, afterWindow, event, thread, minDelta, top, fqn, ignore, windowReport)
 			}
 			if windowMode {
 				return fmt.Errorf("--from/--to/--vs-from/--vs-to can only be used with single-file diff mode")
 			}
+			if len(args) > 2 {
+				return runMultiDiff(args, event, thread, minDelta, top, fqn, rewrite, renames, ignore)
+			}
 
 			beforePath := args[0]
 			afterPath := args[1]
@@ -109,39 +254,11 @@ func newDiffCmd() *cobra.Command {
 				eventsToParse = singleEventType(eventType)
 			}
 
-			// Parse each side for metadata. Structured formats (JFR/pprof)
-			// yield a parsedProfile with eventCounts for event resolution.
-			// Stdin is parsed eagerly (can only be read once).
-			type diffSide struct {
-				parsed      *parsedProfile // non-nil for JFR/pprof
-				collapsedSF *stackFile     // non-nil for collapsed stdin
-			}
-			parseSide := func(path string) (diffSide, error) {
-				if path == "-" {
-					res, err := parseStdin(eventsToParse)
-					if err != nil {
-						return diffSide{}, err
-					}
-					if res.parsed != nil {
-						return diffSide{parsed: res.parsed}, nil
-					}
-					return diffSide{collapsedSF: res.sf}, nil
-				}
-				p, err := parseStructuredProfile(path, eventsToParse)
-				if err != nil {
-					return diffSide{}, err
-				}
-				if p != nil {
-					return diffSide{parsed: p}, nil
-				}
-				return diffSide{}, nil
-			}
-
-			beforeSide, err := parseSide(beforePath)
+			beforeSide, err := parseDiffSide(beforePath, eventsToParse)
 			if err != nil {
 				return err
 			}
-			afterSide, err := parseSide(afterPath)
+			afterSide, err := parseDiffSide(afterPath, eventsToParse)
 			if err != nil {
 				return err
 			}
@@ -181,51 +298,44 @@ func newDiffCmd() *cobra.Command {
 				}
 			}
 
-			var before *stackFile
-			switch {
-			case beforeSide.parsed != nil:
-				before = beforeSide.parsed.stacksByEvent[eventType]
-				if before == nil {
-					before = &stackFile{}
-				}
-			case beforeSide.collapsedSF != nil:
-				before = beforeSide.collapsedSF
-			default:
-				before, _, err = openInput(beforePath, eventType)
-				if err != nil {
-					return err
-				}
+			before, err := beforeSide.stackFile(beforePath, eventType)
+			if err != nil {
+				return err
 			}
-
-			var after *stackFile
-			switch {
-			case afterSide.parsed != nil:
-				after = afterSide.parsed.stacksByEvent[eventType]
-				if after == nil {
-					after = &stackFile{}
-				}
-			case afterSide.collapsedSF != nil:
-				after = afterSide.collapsedSF
-			default:
-				after, _, err = openInput(afterPath, eventType)
-				if err != nil {
-					return err
-				}
+			after, err := afterSide.stackFile(afterPath, eventType)
+			if err != nil {
+				return err
 			}
 			if thread != "" {
 				before = before.filterByThread(thread)
 				after = after.filterByThread(thread)
 			}
+			before = renames.apply(prepare(ignore.filterThreads(before)))
+			after = renames.apply(prepare(ignore.filterThreads(after)))
+			renames.warnUnused()
 			printEventSelectionForDiff(eventType, eventReason, beforeEventCounts, afterEventCounts)
-			cmdDiff(before, after, minDelta, top, fqn)
+			report(before, after, minDelta, top, fqn, ignore)
 			return nil
 		},
 	}
-	cmd.Flags().StringVarP(&event, "event", "e", "", "Event type: cpu, wall, alloc, lock, or hardware counter name (default: cpu)")
+	cmd.Flags().StringVarP(&event, "event", "e", "", "Event type: cpu, wall, alloc, lock, live, or hardware counter name (default: cpu)")
 	cmd.Flags().StringVarP(&thread, "thread", "t", "", "Filter to threads matching substring")
 	cmd.Flags().Float64Var(&minDelta, "min-delta", 0.5, "Hide entries below this % change")
 	cmd.Flags().IntVar(&top, "top", 0, "Limit output rows (default: unlimited)")
 	cmd.Flags().BoolVar(&fqn, "fqn", false, "Show fully-qualified names")
+	registerSample(cmd)
+	cmd.Flags().StringVar(&renameMapPath, "rename-map", "", "File of old=new lines (method, class or package) applied to all inputs so renamed methods line up, after --canonical-synthetic, --fold-native-case and --thread-normalize rewrite names")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format: text, patch (a unified-diff layout of self% per method, for review tools and PR comments), or html (a standalone page with sortable tables and a differential flame graph, for CI artifacts)")
+	cmd.Flags().BoolVar(&byPackage, "by-package", false, "With --format patch, put each package's methods in its own hunk")
+	cmd.Flags().StringVar(&repoURL, "repo-url", "", repoURLUsage+" (--format html)")
+	cmd.Flags().StringVar(&sourceRoot, "source-root", "", "Source checkout that --repo-url resolves file paths and {sha} in")
+	cmd.Flags().BoolVar(&flatThreads, "flat-threads", false, "Compare the share of samples per thread group instead of per method")
+	cmd.Flags().BoolVar(&byThread, "by-thread", false, "Compare methods per thread group, matching pools across the profiles by normalized name")
+	cmd.Flags().StringArrayVar(&threadNormalize, "thread-normalize", nil, threadNormalizeUsage)
+	cmd.Flags().BoolVar(&foldCase, "fold-native-case", false, foldNativeCaseUsage)
+	cmd.Flags().BoolVar(&canonical, "canonical-synthetic", false, "Strip lambda indices, hidden class addresses and accessor/proxy counters (Foo$$Lambda$87 → Foo$$Lambda) so generated classes line up across runs")
+	cmd.Flags().StringArrayVar(&ignoreMethods, "ignore", nil, "Hide methods matching this glob (* and ?) from the report; repeatable, @FILE reads one glob per line")
+	cmd.Flags().StringArrayVar(&ignoreThreads, "ignore-threads", nil, "Drop samples from threads matching this glob before comparing; repeatable, @FILE reads one glob per line")
 	cmd.Flags().Var(&singleAssignStringValue{name: "--from", value: &fromStr}, "from", "Start of first time window (single-file JFR diff only)")
 	cmd.Flags().Var(&singleAssignStringValue{name: "--to", value: &toStr}, "to", "End of first time window (single-file JFR diff only)")
 	cmd.Flags().Var(&singleAssignStringValue{name: "--vs-from", value: &vsFromStr}, "vs-from", "Start of second time window (single-file JFR diff only)")
@@ -233,7 +343,62 @@ func newDiffCmd() *cobra.Command {
 	return cmd
 }
 
-func runSingleFileWindowDiff(path string, beforeWindow, afterWindow durationWindow, event, thread string, minDelta float64, top int, fqn bool) error {
+// diffSide holds one parsed diff input. Structured formats (JFR/pprof)
+// yield a parsedProfile with eventCounts for event resolution. Stdin is
+// parsed eagerly (can only be read once).
+type diffSide struct {
+	parsed      *parsedProfile // non-nil for JFR/pprof
+	collapsedSF *stackFile     // non-nil for collapsed stdin
+}
+
+func parseDiffSide(path string, eventsToParse map[string]struct{}) (diffSide, error) {
+	if path == "-" {
+		res, err := parseStdin(eventsToParse)
+		if err != nil {
+			return diffSide{}, err
+		}
+		if res.parsed != nil {
+			return diffSide{parsed: res.parsed}, nil
+		}
+		return diffSide{collapsedSF: res.sf}, nil
+	}
+	p, err := parseStructuredProfile(path, eventsToParse)
+	if err != nil {
+		return diffSide{}, err
+	}
+	if p != nil {
+		warnSampleIgnored(path)
+		return diffSide{parsed: p}, nil
+	}
+	return diffSide{}, nil
+}
+
+// eventCounts returns the side's event metadata, or nil for collapsed text.
+func (s diffSide) eventCounts() map[string]int {
+	if s.parsed == nil {
+		return nil
+	}
+	return s.parsed.eventCounts
+}
+
+// stackFile returns the stacks for eventType. Collapsed files are read
+// here, after the event has been resolved from the structured inputs.
+func (s diffSide) stackFile(path, eventType string) (*stackFile, error) {
+	switch {
+	case s.parsed != nil:
+		if sf := s.parsed.stacksByEvent[eventType]; sf != nil {
+			return sf, nil
+		}
+		return &stackFile{}, nil
+	case s.collapsedSF != nil:
+		return s.collapsedSF, nil
+	default:
+		sf, _, err := openInput(path, eventType)
+		return sf, err
+	}
+}
+
+func runSingleFileWindowDiff(path string, beforeWindow, afterWindow durationWindow, event, thread string, minDelta float64, top int, fqn bool, ignore *diffIgnore, report diffReport) error {
 	eventExplicit := event != ""
 	eventType := event
 	if eventType == "" {
@@ -305,8 +470,11 @@ func runSingleFileWindowDiff(path string, beforeWindow, afterWindow durationWind
 		after = after.filterByThread(thread)
 	}
 
+	before = ignore.filterThreads(before)
+	after = ignore.filterThreads(after)
+
 	printEventSelectionForDiff(eventType, eventReason, beforeEventCounts, afterEventCounts)
-	cmdDiff(before, after, minDelta, top, fqn)
+	report(before, after, minDelta, top, fqn, ignore)
 	return nil
 }
 
@@ -335,23 +503,43 @@ func selfPcts(sf *stackFile, fqn bool) map[string]float64 {
 	return pcts
 }
 
-func cmdDiff(before, after *stackFile, minDelta float64, top int, fqn bool) {
+// diffReport prints the comparison of two stack files; cmdDiff by default,
+// cmdDiffThreads with --flat-threads, cmdDiffByThread with --by-thread.
+type diffReport func(before, after *stackFile, minDelta float64, top int, fqn bool, ignore *diffIgnore)
+
+// diffEntry is one method whose self% changed between two profiles.
+type diffEntry struct {
+	name   string
+	before float64
+	after  float64
+	delta  float64
+}
+
+// diffChanges are the methods of a two-profile diff by kind, each kind
+// sorted by the size of the change and cut to top.
+type diffChanges struct {
+	regressions, improvements, newMethods, goneMethods []diffEntry
+}
+
+func cmdDiff(before, after *stackFile, minDelta float64, top int, fqn bool, ignore *diffIgnore) {
+	printDiffChanges(computeDiff(before, after, minDelta, top, fqn, ignore))
+}
+
+func computeDiff(before, after *stackFile, minDelta float64, top int, fqn bool, ignore *diffIgnore) diffChanges {
 	beforePct := selfPcts(before, fqn)
 	afterPct := selfPcts(after, fqn)
+	hidden := ignore.hiddenMethods(fqn, before, after)
 
 	allMethods := make(map[string]bool)
 	for m := range beforePct {
-		allMethods[m] = true
+		if !hidden[m] {
+			allMethods[m] = true
+		}
 	}
 	for m := range afterPct {
-		allMethods[m] = true
-	}
-
-	type diffEntry struct {
-		name   string
-		before float64
-		after  float64
-		delta  float64
+		if !hidden[m] {
+			allMethods[m] = true
+		}
 	}
 
 	var regressions, improvements, newMethods, goneMethods []diffEntry
@@ -395,34 +583,41 @@ func cmdDiff(before, after *stackFile, minDelta float64, top int, fqn bool) {
 	improvements = improvements[:truncate(len(improvements), top)]
 	newMethods = newMethods[:truncate(len(newMethods), top)]
 	goneMethods = goneMethods[:truncate(len(goneMethods), top)]
+	return diffChanges{regressions, improvements, newMethods, goneMethods}
+}
+
+func (c diffChanges) empty() bool {
+	return len(c.regressions)+len(c.improvements)+len(c.newMethods)+len(c.goneMethods) == 0
+}
 
+func printDiffChanges(c diffChanges) {
 	anyOutput := false
 
-	if len(regressions) > 0 {
+	if len(c.regressions) > 0 {
 		fmt.Println("REGRESSION")
-		for _, e := range regressions {
-			fmt.Printf("  %-50s %5.1f%% -> %5.1f%%  (+%.1f%%)\n", e.name, e.before, e.after, e.delta)
+		for _, e := range c.regressions {
+			fmt.Printf("  %-50s %5.*f%% -> %5.*f%%  (+%.*f%%)\n", e.name, pctDigits, e.before, pctDigits, e.after, pctDigits, e.delta)
 		}
 		anyOutput = true
 	}
-	if len(improvements) > 0 {
+	if len(c.improvements) > 0 {
 		fmt.Println("IMPROVEMENT")
-		for _, e := range improvements {
-			fmt.Printf("  %-50s %5.1f%% -> %5.1f%%  (%.1f%%)\n", e.name, e.before, e.after, e.delta)
+		for _, e := range c.improvements {
+			fmt.Printf("  %-50s %5.*f%% -> %5.*f%%  (%.*f%%)\n", e.name, pctDigits, e.before, pctDigits, e.after, pctDigits, e.delta)
 		}
 		anyOutput = true
 	}
-	if len(newMethods) > 0 {
+	if len(c.newMethods) > 0 {
 		fmt.Println("NEW")
-		for _, e := range newMethods {
-			fmt.Printf("  %-50s %.1f%%\n", e.name, e.after)
+		for _, e := range c.newMethods {
+			fmt.Printf("  %-50s %.*f%%\n", e.name, pctDigits, e.after)
 		}
 		anyOutput = true
 	}
-	if len(goneMethods) > 0 {
+	if len(c.goneMethods) > 0 {
 		fmt.Println("GONE")
-		for _, e := range goneMethods {
-			fmt.Printf("  %-50s %.1f%%\n", e.name, e.before)
+		for _, e := range c.goneMethods {
+			fmt.Printf("  %-50s %.*f%%\n", e.name, pctDigits, e.before)
 		}
 		anyOutput = true
 	}
@@ -431,3 +626,283 @@ func cmdDiff(before, after *stackFile, minDelta float64, top int, fqn bool) {
 		fmt.Println("no significant changes")
 	}
 }
+
+// diffWindowLabel names one side of a single-file window diff for the
+// patch header, e.g. "profile.jfr@55s..1m05s".
+func diffWindowLabel(path, from, to string) string {
+	if from == "" {
+		from = "start"
+	}
+	if to == "" {
+		to = "end"
+	}
+	return path + "@" + from + ".." + to
+}
+
+// patchHunk is the changed methods of one package (or of the whole diff
+// without --by-package), largest change first.
+type patchHunk struct {
+	label   string
+	entries []diffEntry
+	before  float64 // self% of the entries in the before profile
+	after   float64
+	churn   float64 // sum of |delta|, for ordering hunks
+}
+
+// cmdDiffPatch prints the changes of computeDiff as a unified diff: a
+// removed line with the before self% and an added line with the after
+// self% per method, so new methods only add and gone methods only remove.
+// With byPackage, each package is a hunk, most changed first.
+func cmdDiffPatch(beforeLabel, afterLabel string, before, after *stackFile, minDelta float64, top int, fqn, byPackage bool, ignore *diffIgnore) {
+	// Packages need the full names; they are shortened again for display.
+	c := computeDiff(before, after, minDelta, top, fqn || byPackage, ignore)
+	var entries []diffEntry
+	for _, kind := range [][]diffEntry{c.regressions, c.improvements, c.newMethods, c.goneMethods} {
+		entries = append(entries, kind...)
+	}
+	if len(entries) == 0 {
+		fmt.Println("no significant changes")
+		return
+	}
+
+	hunks := make(map[string]*patchHunk)
+	var order []*patchHunk
+	for _, e := range entries {
+		label := ""
+		if byPackage {
+			label = patchPackage(e.name)
+			if !fqn {
+				e.name = shortName(e.name)
+			}
+		}
+		h := hunks[label]
+		if h == nil {
+			h = &patchHunk{label: label}
+			hunks[label] = h
+			order = append(order, h)
+		}
+		h.entries = append(h.entries, e)
+		h.before += e.before
+		h.after += e.after
+		h.churn += math.Abs(e.delta)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if order[i].churn != order[j].churn {
+			return order[i].churn > order[j].churn
+		}
+		return order[i].label < order[j].label
+	})
+
+	fmt.Printf("--- %s\n", beforeLabel)
+	fmt.Printf("+++ %s\n", afterLabel)
+	for _, h := range order {
+		sort.SliceStable(h.entries, func(i, j int) bool {
+			di, dj := math.Abs(h.entries[i].delta), math.Abs(h.entries[j].delta)
+			if di != dj {
+				return di > dj
+			}
+			return h.entries[i].name < h.entries[j].name
+		})
+		header := fmt.Sprintf("@@ -%.*f%% +%.*f%% @@", pctDigits, h.before, pctDigits, h.after)
+		if h.label != "" {
+			header += " " + h.label
+		}
+		fmt.Println(header)
+		for _, e := range h.entries {
+			switch {
+			case e.before == 0:
+				fmt.Printf("+%6.*f%%  %s  (new)\n", pctDigits, e.after, e.name)
+			case e.after == 0:
+				fmt.Printf("-%6.*f%%  %s  (gone)\n", pctDigits, e.before, e.name)
+			default:
+				fmt.Printf("-%6.*f%%  %s\n", pctDigits, e.before, e.name)
+				fmt.Printf("+%6.*f%%  %s  (%+.*f%%)\n", pctDigits, e.after, e.name, pctDigits, e.delta)
+			}
+		}
+	}
+}
+
+// patchPackage returns the package of a dotted method name, "(native)" for
+// native frames and "(default)" for classes without a package.
+func patchPackage(name string) string {
+	key := groupFrame(groupByPackage, name, 0, frameDetail{})
+	pkg, ok := strings.CutSuffix(key, packageKeySuffix)
+	if !ok {
+		return "(native)"
+	}
+	return pkg
+}
+
+// runMultiDiff compares three or more inputs against the first one (the
+// base) and prints a single self% matrix instead of pairwise diffs.
+func runMultiDiff(paths []string, event, thread string, minDelta float64, top int, fqn bool, rewrite func(*stackFile) *stackFile, renames *renameMap, ignore *diffIgnore) error {
+	sfs, err := loadProfileSeries(paths, event, thread)
+	if err != nil {
+		return err
+	}
+	for i := range sfs {
+		sfs[i] = renames.apply(ignore.filterThreads(rewrite(sfs[i])))
+	}
+	renames.warnUnused()
+	cmdDiffMatrix(paths, sfs, minDelta, top, fqn, ignore)
+	return nil
+}
+
+// loadProfileSeries parses several inputs for side-by-side comparison,
+// resolves one event across all of them and returns their stack files in
+// input order, thread-filtered.
+func loadProfileSeries(paths []string, event, thread string) ([]*stackFile, error) {
+	stdinInputs := 0
+	for _, p := range paths {
+		if p == "-" {
+			stdinInputs++
+		}
+	}
+	if stdinInputs > 1 {
+		return nil, fmt.Errorf("stdin (-) can be used for at most one input")
+	}
+
+	eventExplicit := event != ""
+	eventType := event
+	if eventType == "" {
+		eventType = "cpu"
+	}
+	eventsToParse := allEventTypes()
+	if eventExplicit {
+		eventsToParse = singleEventType(eventType)
+	}
+
+	sides := make([]diffSide, len(paths))
+	counts := make([]map[string]int, len(paths))
+	for i, p := range paths {
+		side, err := parseDiffSide(p, eventsToParse)
+		if err != nil {
+			return nil, err
+		}
+		sides[i] = side
+		counts[i] = side.eventCounts()
+	}
+
+	eventType, eventReason := resolveEventTypeForMultiDiff(eventType, eventExplicit, counts)
+	if eventExplicit && !isKnownEventType(eventType) {
+		available := make(map[string]struct{})
+		found := false
+		for _, c := range counts {
+			if c[eventType] > 0 {
+				found = true
+			}
+			for e := range c {
+				available[e] = struct{}{}
+			}
+		}
+		if !found {
+			if len(available) == 0 {
+				return nil, fmt.Errorf("event %q not found (no events in files)", eventType)
+			}
+			names := make([]string, 0, len(available))
+			for e := range available {
+				names = append(names, e)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("event %q not found (available: %s)", eventType, strings.Join(names, ", "))
+		}
+	}
+
+	sfs := make([]*stackFile, len(paths))
+	for i, side := range sides {
+		sf, err := side.stackFile(paths[i], eventType)
+		if err != nil {
+			return nil, err
+		}
+		sfs[i] = sf.filterByThread(thread)
+	}
+
+	printEventSelectionForMultiDiff(eventType, eventReason, counts)
+	return sfs, nil
+}
+
+// cmdDiffMatrix prints self% per method for each input, with the delta
+// against sfs[0] next to every other column. A method is shown when any
+// input differs from the base by at least minDelta; missing methods show
+// "-" and count as 0%.
+func cmdDiffMatrix(paths []string, sfs []*stackFile, minDelta float64, top int, fqn bool, ignore *diffIgnore) {
+	pcts := make([]map[string]float64, len(sfs))
+	hidden := ignore.hiddenMethods(fqn, sfs...)
+	allMethods := make(map[string]bool)
+	for i, sf := range sfs {
+		pcts[i] = selfPcts(sf, fqn)
+		for m := range pcts[i] {
+			if !hidden[m] {
+				allMethods[m] = true
+			}
+		}
+	}
+
+	type matrixRow struct {
+		name     string
+		maxDelta float64
+	}
+	var rows []matrixRow
+	for m := range allMethods {
+		base := pcts[0][m]
+		maxDelta := 0.0
+		for _, p := range pcts[1:] {
+			if d := math.Abs(p[m] - base); d > maxDelta {
+				maxDelta = d
+			}
+		}
+		if maxDelta >= minDelta {
+			rows = append(rows, matrixRow{m, maxDelta})
+		}
+	}
+	if len(rows) == 0 {
+		fmt.Println("no significant changes")
+		return
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].maxDelta != rows[j].maxDelta {
+			return rows[i].maxDelta > rows[j].maxDelta
+		}
+		return rows[i].name < rows[j].name
+	})
+	shown := rows[:truncate(len(rows), top)]
+
+	labels := make([]string, len(paths))
+	labels[0] = "BASE"
+	for i := 1; i < len(paths); i++ {
+		labels[i] = fmt.Sprintf("#%d", i)
+	}
+	for i, p := range paths {
+		fmt.Printf("%-5s %s\n", labels[i], p)
+	}
+	fmt.Println()
+
+	fmt.Printf("%-50s %8s", "METHOD", labels[0])
+	for _, l := range labels[1:] {
+		fmt.Printf(" %15s", l)
+	}
+	fmt.Println()
+	for _, r := range shown {
+		base, inBase := pcts[0][r.name]
+		fmt.Printf("%-50s %8s", r.name, matrixCell(base, inBase))
+		for _, p := range pcts[1:] {
+			v, ok := p[r.name]
+			cell := matrixCell(v, ok)
+			if ok || inBase {
+				cell += fmt.Sprintf(" (%+.1f)", v-base)
+			}
+			fmt.Printf(" %15s", cell)
+		}
+		fmt.Println()
+	}
+	if len(shown) < len(rows) {
+		fmt.Printf("(%d of %d methods shown)\n", len(shown), len(rows))
+	}
+}
+
+func matrixCell(pct float64, present bool) string {
+	if !present {
+		return "-"
+	}
+	return fmt.Sprintf("%.*f%%", pctDigits, pct)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"sort"
+)
+
+// Layout of the differential flame graph in diff --format html.
+const (
+	diffFlameWidth = 1200
+	diffFlameRow   = 16
+)
+
+// diffFlameNode is one call path in both profiles of a diff.
+type diffFlameNode struct {
+	name          string
+	before, after int // samples through this path
+	children      []*diffFlameNode
+	byName        map[string]*diffFlameNode
+}
+
+func (n *diffFlameNode) child(name string) *diffFlameNode {
+	c := n.byName[name]
+	if c == nil {
+		c = &diffFlameNode{name: name, byName: make(map[string]*diffFlameNode)}
+		n.byName[name] = c
+		n.children = append(n.children, c)
+	}
+	return c
+}
+
+// buildDiffFlame merges the call paths of before and after (root first,
+// threads ignored) into one tree, children heaviest-after first.
+func buildDiffFlame(before, after *stackFile, fqn bool) *diffFlameNode {
+	root := &diffFlameNode{name: "all", byName: make(map[string]*diffFlameNode)}
+	add := func(sf *stackFile, isAfter bool) {
+		for i := range sf.stacks {
+			st := &sf.stacks[i]
+			n := root
+			for _, fr := range st.frames {
+				n = n.child(displayName(fr, fqn))
+				if isAfter {
+					n.after += st.count
+				} else {
+					n.before += st.count
+				}
+			}
+		}
+	}
+	add(before, false)
+	add(after, true)
+	root.before, root.after = before.totalSamples, after.totalSamples
+	var sortTree func(n *diffFlameNode)
+	sortTree = func(n *diffFlameNode) {
+		sort.Slice(n.children, func(i, j int) bool {
+			a, b := n.children[i], n.children[j]
+			if a.after != b.after {
+				return a.after > b.after
+			}
+			if a.before != b.before {
+				return a.before > b.before
+			}
+			return a.name < b.name
+		})
+		for _, c := range n.children {
+			sortTree(c)
+		}
+	}
+	sortTree(root)
+	return root
+}
+
+// cmdDiffHTML writes the changes of computeDiff as a standalone page: a
+// sortable table per kind of change, each row with a before/after bar for
+// scale, then a differential flame graph. The graph is sized by the after
+// profile and colored by the change of each path's share of samples, red
+// for growth and blue for shrinkage, as in Brendan Gregg's red/blue
+// differential flame graphs; paths gone in the after profile have no width
+// and appear in the GONE table instead. Methods with an entry in links
+// (--repo-url) link to their source.
+func cmdDiffHTML(w io.Writer, beforeLabel, afterLabel string, before, after *stackFile, minDelta float64, top int, fqn bool, ignore *diffIgnore, links map[string]string) {
+	c := computeDiff(before, after, minDelta, top, fqn, ignore)
+	title := fmt.Sprintf("diff %s → %s", beforeLabel, afterLabel)
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>%s</title>
+<style>
+body { font: 13px sans-serif; margin: 16px; }
+table { border-collapse: collapse; margin-bottom: 16px; }
+th, td { padding: 2px 8px; text-align: right; }
+th { cursor: pointer; background: #eee; user-select: none; }
+th:first-child, td:first-child { text-align: left; font-family: monospace; }
+tr:nth-child(even) td { background: #f7f7f7; }
+svg.flame text { font: 11px monospace; pointer-events: none; }
+</style></head><body>
+<h2>%s</h2>
+<p>before: %d samples, after: %d samples; self%% changes of at least %.*f%%. Click a column to sort.</p>
+`, html.EscapeString(title), html.EscapeString(title), before.totalSamples, after.totalSamples, pctDigits, minDelta)
+
+	if c.empty() {
+		fmt.Fprintln(w, "<p>no significant changes</p>")
+	}
+	scale := 0.0
+	for _, kind := range [][]diffEntry{c.regressions, c.improvements, c.newMethods, c.goneMethods} {
+		for _, e := range kind {
+			scale = max(scale, e.before, e.after)
+		}
+	}
+	writeDiffTable(w, "REGRESSION", c.regressions, scale, links)
+	writeDiffTable(w, "IMPROVEMENT", c.improvements, scale, links)
+	writeDiffTable(w, "NEW", c.newMethods, scale, links)
+	writeDiffTable(w, "GONE", c.goneMethods, scale, links)
+
+	if before.totalSamples > 0 && after.totalSamples > 0 {
+		writeDiffFlame(w, buildDiffFlame(before, after, fqn), links)
+	}
+	fmt.Fprint(w, `<script>
+document.querySelectorAll("th").forEach(function (th) {
+  th.addEventListener("click", function () {
+    var body = th.closest("table").tBodies[0], i = th.cellIndex;
+    var asc = th.dataset.dir !== "asc";
+    th.dataset.dir = asc ? "asc" : "desc";
+    var rows = Array.from(body.rows);
+    rows.sort(function (a, b) {
+      var x = a.cells[i], y = b.cells[i];
+      var d = x.dataset.v !== undefined ? x.dataset.v - y.dataset.v : x.textContent.localeCompare(y.textContent);
+      return asc ? d : -d;
+    });
+    rows.forEach(function (r) { body.appendChild(r); });
+  });
+});
+</script>
+</body></html>
+`)
+}
+
+// writeDiffTable writes one kind of change as a table. Bars are scaled to
+// scale, the largest self% in the report.
+func writeDiffTable(w io.Writer, kind string, entries []diffEntry, scale float64, links map[string]string) {
+	if len(entries) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "<h3>%s (%d)</h3>\n<table>\n<thead><tr><th>method</th><th>before</th><th>after</th><th>delta</th><th>before / after</th></tr></thead>\n<tbody>\n", kind, len(entries))
+	for _, e := range entries {
+		bw, aw := 0.0, 0.0
+		if scale > 0 {
+			bw, aw = 120*e.before/scale, 120*e.after/scale
+		}
+		name := html.EscapeString(e.name)
+		if href := links[e.name]; href != "" {
+			name = fmt.Sprintf(`<a href="%s" target="_blank">%s</a>`, html.EscapeString(href), name)
+		}
+		fmt.Fprintf(w, `<tr><td>%s</td><td data-v="%g">%.*f%%</td><td data-v="%g">%.*f%%</td><td data-v="%g">%+.*f%%</td>`,
+			name, e.before, pctDigits, e.before, e.after, pctDigits, e.after, e.delta, pctDigits, e.delta)
+		fmt.Fprintf(w, `<td data-v="%g"><svg width="120" height="12"><rect width="%.1f" height="5" fill="#999"/><rect y="7" width="%.1f" height="5" fill="%s"/></svg></td></tr>
+`, e.after, bw, aw, diffColor(e.delta, scale))
+	}
+	fmt.Fprintln(w, "</tbody>\n</table>")
+}
+
+// diffColor is red for growth and blue for shrinkage, stronger the larger
+// delta is relative to scale.
+func diffColor(delta, scale float64) string {
+	if delta == 0 || scale <= 0 {
+		return "hsl(0,0%,80%)"
+	}
+	hue := 0
+	if delta < 0 {
+		hue = 220
+	}
+	light := 85 - int(40*math.Min(math.Abs(delta)/scale, 1))
+	return fmt.Sprintf("hsl(%d,80%%,%d%%)", hue, light)
+}
+
+// writeDiffFlame draws root as an icicle graph, root at the top. Paths
+// narrower than half a pixel are left out with their callees; frames with
+// an entry in links link to their source.
+func writeDiffFlame(w io.Writer, root *diffFlameNode, links map[string]string) {
+	maxDelta := 0.0
+	depth := 0
+	var measure func(n *diffFlameNode, d int)
+	measure = func(n *diffFlameNode, d int) {
+		if float64(n.after)/float64(root.after)*diffFlameWidth < 0.5 {
+			return
+		}
+		depth = max(depth, d)
+		maxDelta = math.Max(maxDelta, math.Abs(pctOf(n.after, root.after)-pctOf(n.before, root.before)))
+		for _, c := range n.children {
+			measure(c, d+1)
+		}
+	}
+	measure(root, 1)
+
+	fmt.Fprintf(w, "<h3>Differential flame graph</h3>\n<p>Width: share of after samples. Color: change of the share, red grew, blue shrank. Hover for values.</p>\n")
+	fmt.Fprintf(w, "<svg class=\"flame\" xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\">\n", diffFlameWidth, depth*diffFlameRow)
+	var draw func(n *diffFlameNode, x float64, d int)
+	draw = func(n *diffFlameNode, x float64, d int) {
+		width := float64(n.after) / float64(root.after) * diffFlameWidth
+		if width < 0.5 {
+			return
+		}
+		b, a := pctOf(n.before, root.before), pctOf(n.after, root.after)
+		href := links[n.name]
+		if href != "" {
+			fmt.Fprintf(w, "<a href=\"%s\" target=\"_blank\">", html.EscapeString(href))
+		}
+		fmt.Fprintf(w, `<g><title>%s
+%.*f%% → %.*f%% (%+.*f%%)</title><rect x="%.1f" y="%d" width="%.1f" height="%d" fill="%s" stroke="#fff" stroke-width="0.5"/>`,
+			html.EscapeString(n.name), pctDigits, b, pctDigits, a, pctDigits, a-b, x, (d-1)*diffFlameRow, width, diffFlameRow-1, diffColor(a-b, maxDelta))
+		if label := treemapFit(n.name, width); label != "" {
+			fmt.Fprintf(w, `<text x="%.1f" y="%d">%s</text>`, x+3, d*diffFlameRow-4, html.EscapeString(label))
+		}
+		fmt.Fprint(w, "</g>")
+		if href != "" {
+			fmt.Fprint(w, "</a>")
+		}
+		fmt.Fprintln(w)
+		for _, c := range n.children {
+			draw(c, x, d+1)
+			x += float64(c.after) / float64(root.after) * diffFlameWidth
+		}
+	}
+	draw(root, 0, 1)
+	fmt.Fprintln(w, "</svg>")
+}
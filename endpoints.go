@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// endpointPattern recognizes a framework's dispatcher frame: the method
+// through which the framework calls application code (a controller, a
+// listener, a job). The first application frame below it is the endpoint.
+type endpointPattern struct {
+	framework string
+	dispatch  *regexp.Regexp
+}
+
+// builtinEndpoints are the dispatchers of common frameworks, as
+// "FRAMEWORK GLOB" lines like those of an --endpoints file.
+var builtinEndpoints = []string{
+	"spring-mvc org.springframework.web.method.support.InvocableHandlerMethod.doInvoke",
+	"spring-webflux org.springframework.web.reactive.result.method.InvocableHandlerMethod.*",
+	"jax-rs org.glassfish.jersey.server.model.internal.AbstractJavaResourceMethodDispatcher*",
+	"jax-rs org.jboss.resteasy.core.MethodInjectorImpl.invoke",
+	"jax-rs org.jboss.resteasy.reactive.server.handlers.InvocationHandler.handle",
+	"jax-rs org.apache.cxf.service.invoker.AbstractInvoker.performInvocation",
+	"grpc io.grpc.stub.ServerCalls$*",
+	"kafka org.springframework.kafka.listener.adapter.*.invokeHandler",
+	"kafka-streams org.apache.kafka.streams.processor.internals.ProcessorNode.process",
+	"jms org.springframework.jms.listener.adapter.*.invokeListenerMethod",
+	"rabbitmq org.springframework.amqp.rabbit.listener.adapter.*.invokeHandler",
+	"micronaut io.micronaut.context.AbstractExecutableMethodsDefinition$DispatchedExecutableMethod.invoke",
+	"scheduled org.springframework.scheduling.support.ScheduledMethodRunnable.run",
+	"quartz org.quartz.core.JobRunShell.run",
+	"servlet javax.servlet.http.HttpServlet.service",
+	"servlet jakarta.servlet.http.HttpServlet.service",
+}
+
+// loadEndpointPatterns returns the patterns of the given files followed by
+// the built-in ones. Each line of a file is "FRAMEWORK GLOB"; blank lines
+// and lines starting with # are skipped.
+func loadEndpointPatterns(files []string) ([]endpointPattern, error) {
+	var res []endpointPattern
+	for _, file := range files {
+		lines, err := readPatternFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			p, err := parseEndpointPattern(line)
+			if err != nil {
+				return nil, fmt.Errorf("--endpoints %s: %v", file, err)
+			}
+			res = append(res, p)
+		}
+	}
+	for _, line := range builtinEndpoints {
+		p, err := parseEndpointPattern(line)
+		if err != nil {
+			panic(err)
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+func parseEndpointPattern(line string) (endpointPattern, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return endpointPattern{}, fmt.Errorf("invalid line %q (want \"FRAMEWORK GLOB\")", line)
+	}
+	return endpointPattern{framework: fields[0], dispatch: globRegexp(fields[1])}, nil
+}
+
+// matchEndpoint returns the framework whose dispatcher pattern matches
+// frame, or "" if none does.
+func matchEndpoint(patterns []endpointPattern, frame string) string {
+	short, full := shortName(frame), displayName(frame, true)
+	for _, p := range patterns {
+		if p.dispatch.MatchString(full) || p.dispatch.MatchString(short) {
+			return p.framework
+		}
+	}
+	return ""
+}
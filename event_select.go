@@ -7,7 +7,7 @@ import (
 	"strings"
 )
 
-var validEventTypes = []string{"cpu", "wall", "alloc", "lock"}
+var validEventTypes = []string{"cpu", "wall", "alloc", "lock", "live"}
 
 var eventOrder map[string]int
 
@@ -65,27 +65,41 @@ func resolveEventType(requested string, explicit bool, counts map[string]int) (s
 }
 
 func resolveEventTypeForDiff(requested string, explicit bool, beforeCounts, afterCounts map[string]int) (selected string, reason eventSelectionReason) {
+	return resolveEventTypeForMultiDiff(requested, explicit, []map[string]int{beforeCounts, afterCounts})
+}
+
+// resolveEventTypeForMultiDiff picks the diff event across any number of
+// inputs. counts has one entry per input; nil means no event metadata
+// (collapsed text).
+func resolveEventTypeForMultiDiff(requested string, explicit bool, counts []map[string]int) (selected string, reason eventSelectionReason) {
 	if explicit {
 		return requested, eventReasonExplicit
 	}
-	beforeKnown := beforeCounts != nil
-	afterKnown := afterCounts != nil
-	if beforeKnown && afterKnown {
-		common := commonEventCounts(beforeCounts, afterCounts)
-		if len(common) > 0 {
-			selected, _ := resolveEventType(requested, false, common)
-			return selected, eventReasonDiffCommon
+	var known []map[string]int
+	for _, c := range counts {
+		if c != nil {
+			known = append(known, c)
 		}
-		combined := combineEventCounts(beforeCounts, afterCounts)
-		selected, _ := resolveEventType(requested, false, combined)
-		return selected, eventReasonDiffNoCommonFallback
 	}
-	if beforeKnown || afterKnown {
+	if len(known) == 0 {
+		return requested, eventReasonUnknown
+	}
+	if len(known) < len(counts) {
 		// Mixed-format diff (JFR + collapsed): keep requested/default event.
 		// We cannot verify event compatibility from collapsed input metadata.
 		return requested, eventReasonDiffOneSidedMetadata
 	}
-	return requested, eventReasonUnknown
+	common, combined := known[0], known[0]
+	for _, c := range known[1:] {
+		common = commonEventCounts(common, c)
+		combined = combineEventCounts(combined, c)
+	}
+	if len(common) > 0 {
+		selected, _ := resolveEventType(requested, false, common)
+		return selected, eventReasonDiffCommon
+	}
+	selected, _ = resolveEventType(requested, false, combined)
+	return selected, eventReasonDiffNoCommonFallback
 }
 
 func printEventSelectionForSingle(eventType string, reason eventSelectionReason, counts map[string]int) {
@@ -132,6 +146,25 @@ func printEventSelectionForDiff(eventType string, reason eventSelectionReason, b
 	}
 }
 
+func printEventSelectionForMultiDiff(eventType string, reason eventSelectionReason, counts []map[string]int) {
+	show := reason == eventReasonDiffOneSidedMetadata || reason == eventReasonDiffNoCommonFallback
+	for _, c := range counts {
+		if len(c) > 1 {
+			show = true
+		}
+	}
+	if !show {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Event: %s (%s)\n", eventType, selectionModeLabel(reason))
+	if reason == eventReasonDiffOneSidedMetadata {
+		fmt.Fprintf(os.Stderr, "Warning: some inputs are collapsed text without event metadata; compatibility with event %q could not be verified.\n", eventType)
+	}
+	if reason == eventReasonDiffNoCommonFallback {
+		fmt.Fprintln(os.Stderr, "Warning: no common event type across all recordings; selected event may be missing in some files.")
+	}
+}
+
 func selectionModeLabel(reason eventSelectionReason) string {
 	switch reason {
 	case eventReasonExplicit:
@@ -186,7 +186,7 @@ func TestValidEventTypesMatchEventOrder(t *testing.T) {
 
 func TestValidEventTypesString(t *testing.T) {
 	got := validEventTypesString()
-	want := "cpu, wall, alloc, lock"
+	want := "cpu, wall, alloc, lock, live"
 	if got != want {
 		t.Errorf("validEventTypesString() = %q, want %q", got, want)
 	}
@@ -208,7 +208,7 @@ func TestJFRTraceAutoSelectSingleEvent(t *testing.T) {
 		t.Fatalf("openInput: %v", err)
 	}
 	out := captureOutput(func() {
-		cmdTrace(sf, "Workload.cpuWork", 0.5, false)
+		cmdTrace(sf, substringMatcher("Workload.cpuWork"), 0.5, false, false)
 	})
 	if !strings.Contains(out, "Hottest leaf:") {
 		t.Fatalf("expected trace output for wall.jfr auto-select, got:\n%s", out)
@@ -10,38 +10,38 @@ import (
 func newEventsCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "events <file>",
-		Short: "List event types in a JFR or pprof file",
+		Short: "List event types in a JFR or pprof file (collapsed text: its labels or stack counts)",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			path := args[0]
-			if path != "-" && detectFormat(path) == formatCollapsed {
-				return fmt.Errorf("events command requires a JFR or pprof file")
-			}
-			return cmdEvents(path)
+			return cmdEvents(args[0])
 		},
 	}
 }
 
+// cmdEvents lists the event types of a profile. Collapsed text has none
+// unless its lines carry [event=...] labels, so for unlabeled text (and
+// other tools' exports) it says what the input is and how much it holds.
 func cmdEvents(path string) error {
-	var parsed *parsedProfile
+	var res stdinResult
 	var err error
-	if path == "-" {
-		res, err := parseStdin(nil)
-		if err != nil {
-			return err
-		}
-		if res.parsed == nil {
-			return fmt.Errorf("events command requires a JFR or pprof file (stdin appears to be collapsed text)")
-		}
-		parsed = res.parsed
-	} else {
-		parsed, err = parseStructuredProfile(path, nil)
-		if err != nil {
-			return err
-		}
+	switch {
+	case path == "-":
+		res, err = parseStdin(nil)
+	case detectFormat(path) == formatCollapsed:
+		res, err = parseCollapsedFile(path)
+	default:
+		res.parsed, err = parseStructuredProfile(path, nil)
+	}
+	if err != nil {
+		return err
+	}
+	if res.parsed == nil {
+		printTextInputSummary(res.source, res.sf)
+		return nil
 	}
-	if parsed == nil {
-		return fmt.Errorf("events command requires a JFR or pprof file")
+	parsed := res.parsed
+	if res.source != "" {
+		fmt.Printf("Input: %s\n\n", res.source)
 	}
 	counts := parsed.eventCounts
 	if len(counts) == 0 {
@@ -68,3 +68,20 @@ func cmdEvents(path string) error {
 	fmt.Printf("%-10s %9d\n", "total", total)
 	return nil
 }
+
+// printTextInputSummary describes input without event types: every
+// command reads it as one event.
+func printTextInputSummary(source string, sf *stackFile) {
+	threads := make(map[string]bool)
+	for i := range sf.stacks {
+		if t := sf.stacks[i].thread; t != "" {
+			threads[t] = true
+		}
+	}
+	fmt.Printf("Input: %s (no event types; analyzed as a single event)\n", source)
+	line := fmt.Sprintf("Stacks: %d  Samples: %d", len(sf.stacks), sf.totalSamples)
+	if len(threads) > 0 {
+		line += fmt.Sprintf("  Threads: %d", len(threads))
+	}
+	fmt.Println(line)
+}
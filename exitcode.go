@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Exit codes. Every command returns its error to main, which maps it to one
+// of these, so scripts and CI can tell a failed gate from a bad invocation
+// or an unreadable profile.
+const (
+	exitOK        = 0
+	exitAssertion = 1 // assert, hot --assert-below or threads --assert tripped, or fail() in a script
+	exitUsage     = 2 // bad flags/arguments, or a script error
+	exitParse     = 3 // input is not a valid profile
+	exitIO        = 4 // input or output could not be read/written
+)
+
+// exitError attaches an exit code to an error. A nil err means the message
+// has already been printed and main should exit silently.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit code %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error { return e.err }
+
+func assertionErrorf(format string, a ...any) error {
+	return &exitError{code: exitAssertion, err: fmt.Errorf(format, a...)}
+}
+
+func usageErrorf(format string, a ...any) error {
+	return &exitError{code: exitUsage, err: fmt.Errorf(format, a...)}
+}
+
+func parseErrorf(format string, a ...any) error {
+	return &exitError{code: exitParse, err: fmt.Errorf(format, a...)}
+}
+
+func ioErrorf(format string, a ...any) error {
+	return &exitError{code: exitIO, err: fmt.Errorf(format, a...)}
+}
+
+// readError tags an error from reading or decoding an input: filesystem
+// failures stay I/O errors, anything else means the data is not valid.
+func readError(err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return err
+	}
+	return &exitError{code: exitParse, err: err}
+}
+
+// exitCodeFor maps an error returned from a command to the process exit
+// code. Untagged filesystem errors count as I/O; anything else untagged is a
+// flag or argument problem reported by a command or by cobra itself.
+func exitCodeFor(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return exitIO
+	}
+	return exitUsage
+}
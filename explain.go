@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// explainComputations describes what each command computes from the
+// preprocessed stacks, for --explain.
+var explainComputations = map[string]string{
+	"hot":            "methods ranked by self samples (method is the leaf frame) and total samples (method anywhere on the stack, counted once per stack)",
+	"tree":           "call tree descending from the root, or from the frames matched by -m, with each node's share of all samples",
+	"trace":          "hottest path from the frames matched by -m to a leaf, following the heaviest callee at each step",
+	"callers":        "call tree ascending from the frames matched by -m to their callers, with each node's share of all samples",
+	"threads":        "samples per thread (or thread group), as a share of all samples",
+	"filter":         "stacks passing through the frames matched by -m, as collapsed text",
+	"collapse":       "every stack as collapsed text, identical stacks merged",
+	"lines":          "samples per source line inside the frames matched by -m",
+	"files":          "self and total samples per source file, derived from the class of each frame",
+	"methods":        "distinct fully-qualified methods with self and total samples",
+	"info":           "triage summary: threads, hot methods and a drill-down into the top methods",
+	"timeline":       "samples per time bucket over the recording",
+	"compare-events": "total share of every method in each event, side by side",
+	"treemap":        "self samples grouped by package, class and method, written as HTML",
+	"metrics":        "self and total share of the top methods and share per thread, as Prometheus gauges",
+}
+
+// explanation collects the steps preprocessProfile applied, for --explain.
+// A nil *explanation is valid and records nothing.
+type explanation struct {
+	lines []string
+}
+
+func (e *explanation) addf(format string, args ...any) {
+	if e == nil {
+		return
+	}
+	e.lines = append(e.lines, fmt.Sprintf(format, args...))
+}
+
+// print writes the explanation as "# " comment lines. No line ends in a
+// number, so collapsed-text readers skip them and collapse/filter output
+// stays valid input.
+func (e *explanation) print(w io.Writer) {
+	if e == nil {
+		return
+	}
+	for _, line := range e.lines {
+		fmt.Fprintf(w, "# %s\n", line)
+	}
+	fmt.Fprintln(w)
+}
+
+// explainFormat names an input format for --explain.
+func explainFormat(path string) string {
+	switch detectFormat(path) {
+	case formatJFR:
+		return "JFR"
+	case formatPprof:
+		return "pprof"
+	}
+	if path == "-" {
+		return "stdin"
+	}
+	if strings.HasSuffix(strings.ToLower(path), ".apq") {
+		return "ap-query model (.apq)"
+	}
+	return "collapsed text"
+}
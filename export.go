@@ -0,0 +1,391 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/grafana/jfr-parser/parser"
+	"github.com/spf13/cobra"
+)
+
+// jfrCheckpointEventType is the event type ID JFR reserves for checkpoint
+// (constant pool) events.
+const jfrCheckpointEventType = 1
+
+func newExportCmd() *cobra.Command {
+	var event, thread, from, to, jfrOut string
+	var redact []string
+	cmd := &cobra.Command{
+		Use:   "export <file>",
+		Short: "Write a trimmed JFR with only the selected event, window and threads (JFR only)",
+		Example: strings.Join([]string{
+			"  ap-query export profile.jfr --jfr trimmed.jfr --event cpu --from 10s --to 20s",
+			"  ap-query export profile.jfr --jfr worker.jfr.gz --thread worker",
+			"  ap-query export profile.jfr --jfr shareable.jfr --redact 'com.mycorp.*'",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if jfrOut == "" {
+				return fmt.Errorf("--jfr OUTPUT required")
+			}
+			if detectFormat(args[0]) != formatJFR {
+				return fmt.Errorf("export requires a JFR file")
+			}
+			if detectFormat(jfrOut) != formatJFR {
+				return fmt.Errorf("--jfr output must end in .jfr or .jfr.gz (got %q)", jfrOut)
+			}
+			window, err := parseDurationWindow("--from", from, "--to", to)
+			if err != nil {
+				return err
+			}
+			globs, err := compileGlobArgs("--redact", redact)
+			if err != nil {
+				return err
+			}
+			return cmdExportJFR(args[0], jfrOut, jfrExportFilter{
+				eventType: event,
+				thread:    thread,
+				fromNanos: window.fromNanos,
+				toNanos:   window.toNanos,
+			}, globs)
+		},
+	}
+	cmd.Flags().StringVar(&jfrOut, "jfr", "", "Output JFR file (.jfr or .jfr.gz)")
+	cmd.Flags().StringVarP(&event, "event", "e", "", "Keep only samples of this event type (default: all)")
+	cmd.Flags().StringVarP(&thread, "thread", "t", "", "Keep only samples from threads matching substring")
+	cmd.Flags().StringVar(&from, "from", "", "Drop samples before this offset")
+	cmd.Flags().StringVar(&to, "to", "", "Drop samples at or after this offset")
+	cmd.Flags().StringArrayVar(&redact, "redact", nil, redactUsage+" (strings in events, such as JVM arguments, are kept)")
+	return cmd
+}
+
+// jfrExportFilter selects the sample events kept by export. All other
+// events (metadata, constant pools, settings, ...) are always kept.
+type jfrExportFilter struct {
+	eventType string // "" = all
+	thread    string // substring; "" = all
+	fromNanos int64  // -1 = no filter
+	toNanos   int64  // -1 = no filter
+}
+
+func cmdExportJFR(inPath, outPath string, f jfrExportFilter, redact []*regexp.Regexp) error {
+	buf, err := readJFRBytes(inPath)
+	if err != nil {
+		return err
+	}
+	var rewrite map[int][]byte
+	if len(redact) > 0 {
+		var r *redactor
+		if r, rewrite, err = redactJFR(buf, redact); err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, r.summary())
+	}
+	out, kept, total, err := filterJFR(buf, f, rewrite)
+	if err != nil {
+		return err
+	}
+	if f.eventType != "" && kept == 0 && total > 0 {
+		fmt.Fprintf(os.Stderr, "warning: no %s samples selected\n", f.eventType)
+	}
+	if err := writeJFRFile(outPath, out); err != nil {
+		return ioErrorf("writing %s: %v", outPath, err)
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d/%d samples to %s (%s, was %s)\n",
+		kept, total, outPath, formatBytes(int64(len(out))), formatBytes(int64(len(buf))))
+	return nil
+}
+
+func writeJFRFile(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	_, err = w.Write(data)
+	if gz != nil {
+		if cerr := gz.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// rawJFREvent is the byte range of one event within a chunk.
+type rawJFREvent struct {
+	start, end int // absolute offsets in the recording
+	typ        uint64
+}
+
+// filterJFR returns a copy of the recording without the sample events
+// rejected by f, and the kept and total sample weights. Events are copied
+// byte for byte, or taken from rewrite (keyed by their offset) if there;
+// only chunk sizes, the header offsets of the metadata and constant pool
+// events, and the checkpoint chain deltas are rewritten.
+//
+// The parser decides which events are samples and decodes their fields but
+// does not expose event positions, so the raw events of each chunk are
+// walked in parallel: every event the parser returns is the next raw event
+// of the same type, and raw events it skips are never samples.
+func filterJFR(buf []byte, f jfrExportFilter, rewrite map[int][]byte) ([]byte, int, int, error) {
+	originNanos, _, err := scanChunkHeaders(buf)
+	if err != nil {
+		return nil, 0, 0, parseErrorf("%v", err)
+	}
+	chunks, chunkStarts, err := splitJFRChunks(buf)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	drop := make(map[int]bool) // start offsets of rejected sample events
+	p, err := newJFRParser(buf)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	execEventName := "cpu"
+	chunk, next := 0, 0
+	kept, total := 0, 0
+	for {
+		typ, err := p.ParseEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, 0, parseErrorf("parse event: %w", err)
+		}
+		// Advance to the raw event the parser just returned.
+		hdr := p.ChunkHeader()
+		for chunk < len(chunks) && !isChunkHeader(buf[chunkStarts[chunk]:], hdr) {
+			chunk, next = chunk+1, 0
+		}
+		for chunk < len(chunks) && next < len(chunks[chunk]) && chunks[chunk][next].typ != uint64(typ) {
+			next++
+		}
+		if chunk == len(chunks) || next == len(chunks[chunk]) {
+			return nil, 0, 0, parseErrorf("cannot locate event of type %d in chunk %d", typ, chunk)
+		}
+		ev := chunks[chunk][next]
+		next++
+
+		if typ == p.TypeMap.T_ACTIVE_SETTING {
+			if p.ActiveSetting.Name == "event" {
+				execEventName = normalizeExecEvent(p.ActiveSetting.Value)
+			}
+			continue
+		}
+		info, ok := classifyEvent(p, typ, execEventName)
+		if !ok {
+			continue
+		}
+		total += info.weight
+		if !f.keeps(p, info, hdr, originNanos) {
+			drop[ev.start] = true
+			continue
+		}
+		kept += info.weight
+	}
+
+	out := make([]byte, 0, len(buf))
+	for i, events := range chunks {
+		out, err = appendFilteredChunk(out, buf, chunkStarts[i], events, drop, rewrite)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	return out, kept, total, nil
+}
+
+// splitJFRChunks returns the raw events of each chunk and the chunk offsets.
+func splitJFRChunks(buf []byte) ([][]rawJFREvent, []int, error) {
+	var chunks [][]rawJFREvent
+	var starts []int
+	for pos := 0; pos < len(buf); {
+		if pos+jfrChunkHeaderSize > len(buf) || binary.BigEndian.Uint32(buf[pos:]) != jfrChunkMagic {
+			return nil, nil, parseErrorf("invalid JFR chunk header at offset %d", pos)
+		}
+		size := int(binary.BigEndian.Uint64(buf[pos+8:]))
+		if size <= jfrChunkHeaderSize || size > len(buf)-pos {
+			return nil, nil, parseErrorf("invalid JFR chunk size %d at offset %d", size, pos)
+		}
+		events, err := rawJFREvents(buf, pos+jfrChunkHeaderSize, pos+size)
+		if err != nil {
+			return nil, nil, err
+		}
+		chunks = append(chunks, events)
+		starts = append(starts, pos)
+		pos += size
+	}
+	return chunks, starts, nil
+}
+
+func (f jfrExportFilter) keeps(p *parser.Parser, info jfrEventInfo, hdr parser.ChunkHeader, originNanos int64) bool {
+	if f.eventType != "" && info.eventType != f.eventType {
+		return false
+	}
+	if f.fromNanos >= 0 || f.toNanos >= 0 {
+		offset := ticksToNanos(info.startTicks, hdr.StartTicks, hdr.StartNanos, uint64(originNanos), hdr.TicksPerSecond)
+		if (f.fromNanos >= 0 && offset < f.fromNanos) || (f.toNanos >= 0 && offset >= f.toNanos) {
+			return false
+		}
+	}
+	return f.thread == "" || strings.Contains(resolveThread(p, info.thRef), f.thread)
+}
+
+// isChunkHeader reports whether the chunk header at the start of buf is the
+// one the parser is currently reading.
+func isChunkHeader(buf []byte, hdr parser.ChunkHeader) bool {
+	return int(binary.BigEndian.Uint64(buf[8:])) == hdr.Size &&
+		int(binary.BigEndian.Uint64(buf[16:])) == hdr.OffsetConstantPool &&
+		int(binary.BigEndian.Uint64(buf[24:])) == hdr.OffsetMeta &&
+		binary.BigEndian.Uint64(buf[32:]) == hdr.StartNanos &&
+		binary.BigEndian.Uint64(buf[48:]) == hdr.StartTicks
+}
+
+// rawJFREvents splits the event area [pos, end) of a chunk into events.
+func rawJFREvents(buf []byte, pos, end int) ([]rawJFREvent, error) {
+	var events []rawJFREvent
+	for pos < end {
+		size, n := readVarLong(buf[pos:end])
+		if n == 0 || size == 0 || size > uint64(end-pos) {
+			return nil, parseErrorf("invalid JFR event size at offset %d", pos)
+		}
+		typ, m := readVarLong(buf[pos+n : end])
+		if m == 0 {
+			return nil, parseErrorf("invalid JFR event type at offset %d", pos)
+		}
+		events = append(events, rawJFREvent{start: pos, end: pos + int(size), typ: typ})
+		pos += int(size)
+	}
+	return events, nil
+}
+
+// appendFilteredChunk appends the chunk at start to out without the events
+// in drop and with those in rewrite replaced, fixing up the header and the
+// checkpoint chain for the new layout.
+func appendFilteredChunk(out, buf []byte, start int, events []rawJFREvent, drop map[int]bool, rewrite map[int][]byte) ([]byte, error) {
+	base := len(out)
+	out = append(out, buf[start:start+jfrChunkHeaderSize]...)
+	newPos := make(map[int]int, len(events)) // old chunk-relative offset → new
+	var checkpoints []int                    // new chunk-relative offsets
+	for _, ev := range events {
+		if drop[ev.start] {
+			continue
+		}
+		newPos[ev.start-start] = len(out) - base
+		if ev.typ == jfrCheckpointEventType {
+			checkpoints = append(checkpoints, len(out)-base)
+		}
+		if b, ok := rewrite[ev.start]; ok {
+			out = append(out, b...)
+		} else {
+			out = append(out, buf[ev.start:ev.end]...)
+		}
+	}
+	chunk := out[base:]
+
+	relocate := func(field int) error {
+		old := int(binary.BigEndian.Uint64(chunk[field:]))
+		p, ok := newPos[old]
+		if !ok {
+			return parseErrorf("JFR chunk header offset %d does not point at an event", old)
+		}
+		binary.BigEndian.PutUint64(chunk[field:], uint64(p))
+		return nil
+	}
+	binary.BigEndian.PutUint64(chunk[8:], uint64(len(chunk)))
+	if err := relocate(16); err != nil { // constant pool
+		return nil, err
+	}
+	if err := relocate(24); err != nil { // metadata
+		return nil, err
+	}
+
+	// Each checkpoint stores the relative offset of the previous one; the
+	// delta keeps its encoded width so no event size changes.
+	oldPos := make(map[int]int, len(newPos))
+	for o, n := range newPos {
+		oldPos[n] = o
+	}
+	for _, cp := range checkpoints {
+		field := cp
+		for i := 0; i < 4; i++ { // size, type, start time, duration
+			_, n := readVarLong(chunk[field:])
+			field += n
+		}
+		delta, width := readVarLong(chunk[field:])
+		if width == 0 {
+			return nil, parseErrorf("invalid JFR checkpoint at offset %d", start+oldPos[cp])
+		}
+		if delta == 0 {
+			continue
+		}
+		target, ok := newPos[oldPos[cp]+int(int64(delta))]
+		if !ok {
+			return nil, parseErrorf("JFR checkpoint at offset %d points at no event", start+oldPos[cp])
+		}
+		if !putVarLongWidth(chunk[field:field+width], uint64(int64(target-cp))) {
+			return nil, parseErrorf("JFR checkpoint delta at offset %d cannot be rewritten", start+oldPos[cp])
+		}
+	}
+	return out, nil
+}
+
+// readVarLong decodes a JFR compressed long and returns it with its encoded
+// length, or length 0 if buf ends first.
+func readVarLong(buf []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(buf) {
+			return 0, 0
+		}
+		b := buf[i]
+		if i == 8 {
+			return v | uint64(b)<<56, 9
+		}
+		v |= uint64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			return v, i + 1
+		}
+	}
+	return v, 9
+}
+
+// appendVarLong appends v as a JFR compressed long of the fewest bytes.
+func appendVarLong(b []byte, v uint64) []byte {
+	for i := 0; i < 8 && v >= 0x80; i++ {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// putVarLongWidth encodes v as a JFR compressed long padded to exactly
+// len(dst) bytes, reporting whether v fits.
+func putVarLongWidth(dst []byte, v uint64) bool {
+	n := len(dst)
+	for i := 0; i < n; i++ {
+		if i == 8 {
+			dst[i] = byte(v)
+			return true
+		}
+		dst[i] = byte(v & 0x7f)
+		v >>= 7
+		if i < n-1 {
+			dst[i] |= 0x80
+		}
+	}
+	return v == 0
+}
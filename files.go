@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newFilesCmd() *cobra.Command {
+	var shared sharedFlags
+	var top int
+	var fqn bool
+	cmd := &cobra.Command{
+		Use:   "files <file>",
+		Short: "Rank source files by self-time and total-time",
+		Long: `Files derives each frame's source file from its class name: nested,
+anonymous and lambda classes roll up into their top-level class, so
+com.example.UserService$Cache.get counts towards UserService.java. Frames
+without a Java class (native, kernel, JVM internals) are grouped as (native).`,
+		Example: strings.Join([]string{
+			"  ap-query files profile.jfr",
+			"  ap-query files profile.jfr --fqn --top 30",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pctx, err := preprocessProfile(shared.toOpts(args[0], "files"))
+			if err != nil {
+				return err
+			}
+			cmdFiles(pctx.sf, top, fqn)
+			return nil
+		},
+	}
+	shared.register(cmd)
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	cmd.Flags().IntVar(&top, "top", 20, "Limit output rows")
+	cmd.Flags().BoolVar(&fqn, "fqn", false, "Show the package path (com/example/UserService.java)")
+	return cmd
+}
+
+// nativeFile groups frames that have no Java source file.
+const nativeFile = "(native)"
+
+// sourceFileOf returns the source file of a Java frame, derived from the
+// top-level class name, or nativeFile for frames without a class.
+func sourceFileOf(frame string, fqn bool) string {
+	if strings.Contains(frame, "::") || strings.Contains(frame, ".so.") || strings.HasSuffix(frame, ".so") || strings.HasPrefix(frame, "[") {
+		return nativeFile
+	}
+	base := strings.ReplaceAll(frame, "/", ".")
+	dot := strings.LastIndexByte(base, '.')
+	if dot <= 0 {
+		return nativeFile
+	}
+	class := base[:dot]
+	if i := strings.IndexByte(class, '$'); i > 0 {
+		class = class[:i]
+	}
+	pkg, simple := "", class
+	if i := strings.LastIndexByte(class, '.'); i >= 0 {
+		pkg, simple = class[:i], class[i+1:]
+	}
+	if simple == "" {
+		return nativeFile
+	}
+	if fqn && pkg != "" {
+		return strings.ReplaceAll(pkg, ".", "/") + "/" + simple + ".java"
+	}
+	return simple + ".java"
+}
+
+// computeFiles returns per-source-file self and total samples, sorted by
+// total descending with ties broken by self, then name. Total counts each
+// stack once per file, however many of its frames are in the file.
+func computeFiles(sf *stackFile, fqn bool) []hotEntry {
+	self := make(map[string]int)
+	total := make(map[string]int)
+	fileOf := make(map[string]string)
+	for i := range sf.stacks {
+		st := &sf.stacks[i]
+		seen := make(map[string]bool)
+		for j, fr := range st.frames {
+			file, ok := fileOf[fr]
+			if !ok {
+				file = sourceFileOf(fr, fqn)
+				fileOf[fr] = file
+			}
+			if !seen[file] {
+				total[file] += st.count
+				seen[file] = true
+			}
+			if j == len(st.frames)-1 {
+				self[file] += st.count
+			}
+		}
+	}
+
+	ranked := make([]hotEntry, 0, len(total))
+	for file, tc := range total {
+		ranked = append(ranked, hotEntry{file, self[file], tc})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].totalCount != ranked[j].totalCount {
+			return ranked[i].totalCount > ranked[j].totalCount
+		}
+		if ranked[i].selfCount != ranked[j].selfCount {
+			return ranked[i].selfCount > ranked[j].selfCount
+		}
+		return ranked[i].name < ranked[j].name
+	})
+	return ranked
+}
+
+func cmdFiles(sf *stackFile, top int, fqn bool) {
+	if sf.totalSamples == 0 {
+		fmt.Fprintln(os.Stdout, "no samples (empty profile or all filtered out)")
+		return
+	}
+	ranked := computeFiles(sf, fqn)
+	shown := ranked[:truncate(len(ranked), top)]
+	fmt.Printf("%-50s %7s %7s %9s %9s\n", "FILE", "SELF%", "TOTAL%", "SELF", "TOTAL")
+	for _, e := range shown {
+		sp := pctOf(e.selfCount, sf.totalSamples)
+		tp := pctOf(e.totalCount, sf.totalSamples)
+		fmt.Printf("%-50s %6.*f%% %6.*f%% %9d %9d\n", e.name, pctDigits, sp, pctDigits, tp, e.selfCount, e.totalCount)
+	}
+	if len(shown) < len(ranked) {
+		fmt.Printf("(%d of %d files shown)\n", len(shown), len(ranked))
+	}
+}
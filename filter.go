@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -10,54 +11,173 @@ import (
 
 func newFilterCmd() *cobra.Command {
 	var shared sharedFlags
-	var method string
+	var mf methodFlags
 	var inclCallers bool
+	var threadSplit, mergeThreads bool
 	cmd := &cobra.Command{
 		Use:   "filter <file>",
 		Short: "Output stacks passing through a method (-m required)",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if method == "" {
+			if mf.method == "" {
 				return fmt.Errorf("-m/--method required")
 			}
+			if err := mf.validate(); err != nil {
+				return err
+			}
+			if threadSplit && mergeThreads {
+				return fmt.Errorf("--thread-split and --merge-threads are mutually exclusive")
+			}
+			threads := filterThreadsAsIs
+			if threadSplit {
+				threads = filterThreadsSplit
+			} else if mergeThreads {
+				threads = filterThreadsMerge
+			}
 			pctx, err := preprocessProfile(shared.toOpts(args[0], "filter"))
 			if err != nil {
 				return err
 			}
-			cmdFilter(pctx.sf, method, inclCallers)
+			m, err := mf.resolve(pctx.sf, args[0])
+			if err != nil {
+				return err
+			}
+			cmdFilter(pctx.sf, m, inclCallers, threads)
 			return nil
 		},
 	}
 	shared.register(cmd)
-	cmd.Flags().StringVarP(&method, "method", "m", "", "Substring match on method name (required)")
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	mf.register(cmd, "Substring match on method name (required)")
 	cmd.Flags().BoolVar(&inclCallers, "include-callers", false, "Include caller frames in output")
+	cmd.Flags().BoolVar(&threadSplit, "thread-split", false, "Group output by thread, heaviest first, under \"# THREAD (N samples)\" header lines")
+	cmd.Flags().BoolVar(&mergeThreads, "merge-threads", false, "Drop thread markers and sum identical stacks across threads")
 	return cmd
 }
 
-func cmdFilter(sf *stackFile, method string, includeCallers bool) {
+// filterThreads selects how filter arranges stacks from different threads.
+type filterThreads int
+
+const (
+	filterThreadsAsIs  filterThreads = iota // profile order, thread marker per line
+	filterThreadsSplit                      // grouped by thread under header lines
+	filterThreadsMerge                      // no markers, identical stacks summed
+)
+
+// filterLine is one output stack: frames joined by ";" and its thread.
+type filterLine struct {
+	thread, tid string
+	frames      string
+	count       int
+}
+
+func cmdFilter(sf *stackFile, m methodMatcher, includeCallers bool, threads filterThreads) {
 	if sf.totalSamples == 0 {
 		fmt.Fprintln(os.Stdout, "no samples (empty profile or all filtered out)")
 		return
 	}
-	matched := 0
-	for i := range sf.stacks {
+	var out []filterLine
+	fm := sf.match(m)
+	for _, i := range fm.stacks {
 		st := &sf.stacks[i]
 		for j, fr := range st.frames {
-			if matchesMethod(fr, method) {
+			if fm.frames[fr] {
 				var outFrames []string
 				if includeCallers {
 					outFrames = st.frames
 				} else {
 					outFrames = st.frames[j:]
 				}
-				tp := threadPrefix(st.thread)
-				fmt.Printf("%s%s %d\n", tp, strings.Join(outFrames, ";"), st.count)
-				matched++
+				out = append(out, filterLine{st.thread, st.tid, strings.Join(outFrames, ";"), st.count})
 				break
 			}
 		}
 	}
-	if matched == 0 {
-		noMatchMessage(os.Stdout, sf, method)
+	if len(out) == 0 {
+		noMatchMessage(os.Stdout, sf, m.pattern)
+		return
 	}
+
+	switch threads {
+	case filterThreadsSplit:
+		printFilterThreadSplit(out)
+	case filterThreadsMerge:
+		merged := make(map[string]int)
+		for _, l := range out {
+			merged[l.frames] += l.count
+		}
+		lines := make([]filterLine, 0, len(merged))
+		for frames, count := range merged {
+			lines = append(lines, filterLine{frames: frames, count: count})
+		}
+		sortFilterLines(lines)
+		for _, l := range lines {
+			fmt.Printf("%s %d\n", l.frames, l.count)
+		}
+	default:
+		for _, l := range out {
+			fmt.Printf("%s%s %d\n", threadMarkerPrefix(l.thread, l.tid), l.frames, l.count)
+		}
+	}
+}
+
+// printFilterThreadSplit prints lines grouped by thread, heaviest thread
+// first. Header lines have no trailing count, so collapsed-text readers
+// skip them and the output stays valid input.
+func printFilterThreadSplit(out []filterLine) {
+	type group struct {
+		thread, tid string
+		samples     int
+		lines       []filterLine
+	}
+	byThread := make(map[string]*group)
+	var groups []*group
+	for _, l := range out {
+		key := l.thread + "\x00" + l.tid
+		g := byThread[key]
+		if g == nil {
+			g = &group{thread: l.thread, tid: l.tid}
+			byThread[key] = g
+			groups = append(groups, g)
+		}
+		g.samples += l.count
+		g.lines = append(g.lines, l)
+	}
+	total := 0
+	for _, g := range groups {
+		total += g.samples
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].samples != groups[j].samples {
+			return groups[i].samples > groups[j].samples
+		}
+		return groups[i].thread < groups[j].thread
+	})
+	for i, g := range groups {
+		if i > 0 {
+			fmt.Println()
+		}
+		name := g.thread
+		if name == "" {
+			name = "(no thread)"
+		} else if g.tid != "" && g.thread != "tid="+g.tid {
+			name += " tid=" + g.tid
+		}
+		fmt.Printf("# %s (%d samples, %.*f%%)\n", name, g.samples, pctDigits, pctOf(g.samples, total))
+		sortFilterLines(g.lines)
+		for _, l := range g.lines {
+			fmt.Printf("%s%s %d\n", threadMarkerPrefix(l.thread, l.tid), l.frames, l.count)
+		}
+	}
+}
+
+// sortFilterLines orders lines by count descending, then by frames.
+func sortFilterLines(lines []filterLine) {
+	sort.SliceStable(lines, func(i, j int) bool {
+		if lines[i].count != lines[j].count {
+			return lines[i].count > lines[j].count
+		}
+		return lines[i].frames < lines[j].frames
+	})
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Flame graph JSON from other pipelines is read back into stacks: the
+// nested {name, value, children} objects of d3-flamegraph (as written by
+// tree --format json) and speedscope files. Both describe weights rather
+// than samples, so a stack's count is its weight: the value for d3, and
+// for speedscope the sample weight, in microseconds for time units.
+
+// flameJSON holds the fields of both formats; which ones are set decides
+// the format.
+type flameJSON struct {
+	Schema   string              `json:"$schema"`
+	Shared   *speedscopeShared   `json:"shared"`
+	Profiles []speedscopeProfile `json:"profiles"`
+
+	Name     *string          `json:"name"`
+	Value    float64          `json:"value"`
+	Children []*flameJSONNode `json:"children"`
+}
+
+type flameJSONNode struct {
+	Name     string           `json:"name"`
+	Value    float64          `json:"value"`
+	Children []*flameJSONNode `json:"children"`
+}
+
+type speedscopeShared struct {
+	Frames []struct {
+		Name string `json:"name"`
+		Line int    `json:"line"`
+	} `json:"frames"`
+}
+
+type speedscopeProfile struct {
+	Type    string    `json:"type"`
+	Name    string    `json:"name"`
+	Unit    string    `json:"unit"`
+	Samples [][]int   `json:"samples"`
+	Weights []float64 `json:"weights"`
+	Events  []struct {
+		Type  string  `json:"type"`
+		Frame int     `json:"frame"`
+		At    float64 `json:"at"`
+	} `json:"events"`
+}
+
+func parseFlameJSON(r io.Reader) (*stackFile, error) {
+	var doc flameJSON
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, parseErrorf("flame graph JSON: %v", err)
+	}
+	switch {
+	case doc.Profiles != nil || strings.Contains(doc.Schema, "speedscope"):
+		return parseSpeedscope(&doc)
+	case doc.Name != nil:
+		return parseD3Flame(&doc)
+	}
+	return nil, parseErrorf("JSON input is neither d3-flamegraph ({name, value, children}) nor speedscope")
+}
+
+// parseD3Flame flattens a d3-flamegraph tree. The root is the graph's
+// synthetic "all"/"root" node and is not a frame; a top-level "[name]"
+// node (tree --by-thread) becomes the thread. Negative values and children
+// outweighing their parent are rejected rather than clamped, as either
+// means the counts below them cannot be trusted.
+func parseD3Flame(doc *flameJSON) (*stackFile, error) {
+	sf := &stackFile{}
+	var walk func(n *flameJSONNode, thread string, frames []string) error
+	walk = func(n *flameJSONNode, thread string, frames []string) error {
+		if len(frames) == 0 {
+			if t, _ := parseThreadFrame(n.Name); t != "" {
+				thread = t
+			} else {
+				frames = append(frames, n.Name)
+			}
+		} else {
+			frames = append(frames, n.Name)
+		}
+		if n.Value < 0 {
+			return parseErrorf("flame graph JSON: node %q has negative value %g", n.Name, n.Value)
+		}
+		self, err := d3SelfValue(n.Name, n.Value, n.Children)
+		if err != nil {
+			return err
+		}
+		if count := int(math.Round(self)); count > 0 && len(frames) > 0 {
+			sf.addStack(thread, frames, nil, count)
+		}
+		for _, c := range n.Children {
+			if err := walk(c, thread, frames); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// A root without a value (other producers omit it) is not checked
+	// against its children.
+	if doc.Value != 0 {
+		if _, err := d3SelfValue(*doc.Name, doc.Value, doc.Children); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range doc.Children {
+		if err := walk(c, "", nil); err != nil {
+			return nil, err
+		}
+	}
+	return sf, nil
+}
+
+// d3SelfValue is a node's value minus its children's; it fails when the
+// children sum to more than the node. Sums may be off by float rounding.
+func d3SelfValue(name string, value float64, children []*flameJSONNode) (float64, error) {
+	sum := 0.0
+	for _, c := range children {
+		sum += c.Value
+	}
+	if sum-value > 1e-9*max(1, value) {
+		return 0, parseErrorf("flame graph JSON: children of %q sum to %g, more than its value %g", name, sum, value)
+	}
+	return value - sum, nil
+}
+
+// speedscopeScale converts a weight to a count: microseconds for time
+// units, the weight itself otherwise (samples, bytes).
+func speedscopeScale(unit string) float64 {
+	switch unit {
+	case "nanoseconds":
+		return 1e-3
+	case "milliseconds":
+		return 1e3
+	case "seconds":
+		return 1e6
+	}
+	return 1
+}
+
+// parseSpeedscope reads sampled and evented profiles; each profile is a
+// thread named after it.
+func parseSpeedscope(doc *flameJSON) (*stackFile, error) {
+	if doc.Shared == nil {
+		return nil, parseErrorf("speedscope: missing shared.frames")
+	}
+	frames := doc.Shared.Frames
+	frame := func(i int) (string, uint32, error) {
+		if i < 0 || i >= len(frames) {
+			return "", 0, parseErrorf("speedscope: frame index %d out of range (%d frames)", i, len(frames))
+		}
+		return frames[i].Name, uint32(max(frames[i].Line, 0)), nil
+	}
+	// Weights are summed per stack before rounding, so many small
+	// samples do not round away.
+	type acc struct {
+		thread string
+		frames []string
+		lines  []uint32
+		weight float64
+	}
+	byKey := make(map[string]*acc)
+	var order []string
+	add := func(thread string, ids []int, weight float64) error {
+		if len(ids) == 0 || weight <= 0 {
+			return nil
+		}
+		a := &acc{thread: thread}
+		for _, id := range ids {
+			name, line, err := frame(id)
+			if err != nil {
+				return err
+			}
+			a.frames = append(a.frames, name)
+			a.lines = append(a.lines, line)
+		}
+		key := threadPrefix(thread) + buildStackKeyWithLines(a.frames, a.lines)
+		if prev := byKey[key]; prev != nil {
+			prev.weight += weight
+			return nil
+		}
+		a.weight = weight
+		byKey[key] = a
+		order = append(order, key)
+		return nil
+	}
+
+	for _, p := range doc.Profiles {
+		scale := speedscopeScale(p.Unit)
+		switch p.Type {
+		case "sampled":
+			for i, ids := range p.Samples {
+				w := 1.0
+				if i < len(p.Weights) {
+					w = p.Weights[i]
+				}
+				if err := add(p.Name, ids, w*scale); err != nil {
+					return nil, err
+				}
+			}
+		case "evented":
+			var open []int
+			last := 0.0
+			for _, e := range p.Events {
+				if err := add(p.Name, open, (e.At-last)*scale); err != nil {
+					return nil, err
+				}
+				last = e.At
+				switch e.Type {
+				case "O":
+					open = append(open, e.Frame)
+				case "C":
+					if len(open) == 0 || open[len(open)-1] != e.Frame {
+						return nil, parseErrorf("speedscope: profile %q closes frame %d that is not open", p.Name, e.Frame)
+					}
+					open = open[:len(open)-1]
+				}
+			}
+		default:
+			return nil, parseErrorf("speedscope: profile %q has unknown type %q", p.Name, p.Type)
+		}
+	}
+
+	sf := &stackFile{}
+	sort.SliceStable(order, func(i, j int) bool { return byKey[order[i]].weight > byKey[order[j]].weight })
+	for _, key := range order {
+		a := byKey[key]
+		if count := int(math.Round(a.weight)); count > 0 {
+			sf.addStack(a.thread, a.frames, a.lines, count)
+		}
+	}
+	return sf, nil
+}
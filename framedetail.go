@@ -0,0 +1,399 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/grafana/jfr-parser/parser"
+	"github.com/grafana/jfr-parser/parser/types"
+	"github.com/grafana/jfr-parser/parser/types/def"
+)
+
+// frameDetail is the JIT context of one frame, which the JFR parser drops:
+// the bytecode index and the frame type ("Interpreted", "JIT compiled",
+// "Inlined", ...). Line numbers inside heavily inlined code are easier to
+// trust when the frame type is known.
+type frameDetail struct {
+	bci  int32
+	kind string
+}
+
+const frameKindInlined = "Inlined"
+
+// frameDetailSuffix formats details as a stack-key suffix so stacks that
+// differ only in BCI or frame type aggregate separately.
+func frameDetailSuffix(details []frameDetail) string {
+	if details == nil {
+		return ""
+	}
+	b := make([]byte, 0, 8*len(details))
+	b = append(b, '|')
+	for i, d := range details {
+		if i > 0 {
+			b = append(b, ';')
+		}
+		b = fmt.Appendf(b, "%d/%s", d.bci, d.kind)
+	}
+	return string(b)
+}
+
+// inlinedMark suffixes the frames --mark-inlined finds inlined by the JIT.
+const inlinedMark = " ~inlined"
+
+// markInlined appends inlinedMark to every frame whose detail kind is
+// Inlined and returns the number of frames marked. An inlined callee is its
+// own frame with its own line already; the mark keeps it from being read
+// as a call the caller really made.
+func (sf *stackFile) markInlined() (*stackFile, int) {
+	out := &stackFile{totalSamples: sf.totalSamples, stacks: make([]stack, len(sf.stacks))}
+	marked := 0
+	for i, st := range sf.stacks {
+		var frames []string
+		for j, d := range st.details {
+			if d.kind != frameKindInlined {
+				continue
+			}
+			if frames == nil {
+				frames = append([]string(nil), st.frames...)
+			}
+			frames[j] += inlinedMark
+			marked++
+		}
+		if frames != nil {
+			st.frames = frames
+		}
+		out.stacks[i] = st
+	}
+	return out, marked
+}
+
+// frameDetailDecoder collects per-frame details from the stack trace
+// constant pools of each chunk as the parser enters it.
+type frameDetailDecoder struct {
+	buf     []byte
+	offsets map[uint64]int64 // chunk StartNanos → file offset
+	chunk   uint64           // StartNanos of the last decoded chunk
+	decoded bool
+	byStack map[types.StackTraceRef][]frameDetail // leaf-first, like the parser
+}
+
+func newFrameDetailDecoder(buf []byte) *frameDetailDecoder {
+	return &frameDetailDecoder{
+		buf:     buf,
+		offsets: chunkStartOffsets(buf),
+		byStack: make(map[types.StackTraceRef][]frameDetail),
+	}
+}
+
+// update decodes the current chunk's stack traces if it has not been seen.
+func (d *frameDetailDecoder) update(p *parser.Parser) error {
+	hdr := p.ChunkHeader()
+	if d.decoded && d.chunk == hdr.StartNanos {
+		return nil
+	}
+	d.chunk, d.decoded = hdr.StartNanos, true
+	start, ok := d.offsets[hdr.StartNanos]
+	if !ok {
+		return parseErrorf("frame details: chunk starting at %d not found", hdr.StartNanos)
+	}
+	kinds := make(map[uint64]string, len(p.FrameTypes.IDMap))
+	for ref, i := range p.FrameTypes.IDMap {
+		kinds[uint64(ref)] = p.FrameTypes.FrameType[i].Description
+	}
+	r := &jfrPoolReader{buf: d.buf, tm: &p.TypeMap}
+	pos := int(start) + hdr.OffsetConstantPool
+	for {
+		delta, err := r.readCheckpoint(pos, func(c *def.Class) error {
+			if c.Name != "jdk.types.StackTrace" {
+				return r.skipPool(c)
+			}
+			return r.readStackTraces(c, kinds, d.byStack)
+		})
+		if err != nil {
+			return parseErrorf("frame details: %w", err)
+		}
+		if delta == 0 {
+			return nil
+		}
+		pos += delta
+		if pos <= int(start) {
+			return nil
+		}
+	}
+}
+
+// lookup returns the details of stRef in root → leaf order, or nil.
+func (d *frameDetailDecoder) lookup(stRef types.StackTraceRef) []frameDetail {
+	leafFirst, ok := d.byStack[stRef]
+	if !ok {
+		return nil
+	}
+	n := len(leafFirst)
+	out := make([]frameDetail, n)
+	for i, fd := range leafFirst {
+		out[n-1-i] = fd
+	}
+	return out
+}
+
+// jfrPoolReader decodes constant pool values generically from the chunk
+// metadata. Counts are checked against the data left and nesting against
+// maxValueDepth, so corrupt input fails instead of allocating or recursing
+// without bound.
+type jfrPoolReader struct {
+	buf   []byte
+	pos   int
+	tm    *def.TypeMap
+	depth int // nesting of inline composite values
+}
+
+// maxValueDepth bounds the nesting of inline composite values; real
+// recordings nest a few levels (a stack trace's frames), while a type
+// containing itself would recurse forever.
+const maxValueDepth = 32
+
+func (r *jfrPoolReader) varLong() (uint64, error) {
+	v, n := readVarLong(r.buf[r.pos:])
+	if n == 0 {
+		return 0, fmt.Errorf("unexpected end of data at offset %d", r.pos)
+	}
+	r.pos += n
+	return v, nil
+}
+
+// count reads a number of elements. Each takes at least a byte, so a
+// count beyond the data left is corrupt.
+func (r *jfrPoolReader) count() (uint64, error) {
+	n, err := r.varLong()
+	if err == nil && n > uint64(len(r.buf)-r.pos) {
+		return 0, fmt.Errorf("count %d exceeds the %d bytes left at offset %d", n, len(r.buf)-r.pos, r.pos)
+	}
+	return n, err
+}
+
+func (r *jfrPoolReader) skip(n int) error {
+	if n < 0 || n > len(r.buf)-r.pos {
+		return fmt.Errorf("unexpected end of data at offset %d", r.pos)
+	}
+	r.pos += n
+	return nil
+}
+
+// readCheckpoint reads the checkpoint event at pos, calling pool for each
+// constant pool in it, and returns the offset of the previous checkpoint.
+func (r *jfrPoolReader) readCheckpoint(pos int, pool func(*def.Class) error) (int, error) {
+	r.pos = pos
+	var hdr [7]uint64 // size, type, start, duration, delta, type mask, pool count
+	for i := range hdr {
+		read := r.varLong
+		if i == len(hdr)-1 {
+			read = r.count
+		}
+		v, err := read()
+		if err != nil {
+			return 0, err
+		}
+		hdr[i] = v
+	}
+	if hdr[1] != jfrCheckpointEventType {
+		return 0, fmt.Errorf("expected checkpoint event at offset %d", pos)
+	}
+	for i := uint64(0); i < hdr[6]; i++ {
+		id, err := r.varLong()
+		if err != nil {
+			return 0, err
+		}
+		c := r.tm.IDMap[def.TypeID(id)]
+		if c == nil {
+			return 0, fmt.Errorf("unknown type %d", id)
+		}
+		if c.Name == "jdk.types.ChunkHeader" {
+			if err := r.skip(jfrChunkHeaderSize); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err := pool(c); err != nil {
+			return 0, err
+		}
+	}
+	return int(int64(hdr[4])), nil
+}
+
+func (r *jfrPoolReader) skipPool(c *def.Class) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		if _, err := r.varLong(); err != nil { // constant id
+			return err
+		}
+		if err := r.skipClassValue(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *jfrPoolReader) readStackTraces(c *def.Class, kinds map[uint64]string, out map[types.StackTraceRef][]frameDetail) error {
+	n, err := r.count()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		id, err := r.varLong()
+		if err != nil {
+			return err
+		}
+		var details []frameDetail
+		for fi := range c.Fields {
+			f := &c.Fields[fi]
+			frameClass := r.tm.IDMap[f.Type]
+			if f.Name != "frames" || !f.Array || f.ConstantPool || frameClass == nil {
+				if err := r.skipField(f); err != nil {
+					return err
+				}
+				continue
+			}
+			count, err := r.count()
+			if err != nil {
+				return err
+			}
+			details = make([]frameDetail, 0, count)
+			for j := uint64(0); j < count; j++ {
+				fd, err := r.readFrame(frameClass, kinds)
+				if err != nil {
+					return err
+				}
+				details = append(details, fd)
+			}
+		}
+		out[types.StackTraceRef(id)] = details
+	}
+	return nil
+}
+
+func (r *jfrPoolReader) readFrame(c *def.Class, kinds map[uint64]string) (frameDetail, error) {
+	var fd frameDetail
+	for fi := range c.Fields {
+		f := &c.Fields[fi]
+		switch {
+		case f.Name == "bytecodeIndex" && !f.Array && !f.ConstantPool:
+			v, err := r.varLong()
+			if err != nil {
+				return fd, err
+			}
+			fd.bci = int32(v)
+		case f.Name == "type" && !f.Array && f.ConstantPool:
+			v, err := r.varLong()
+			if err != nil {
+				return fd, err
+			}
+			fd.kind = kinds[v]
+		default:
+			if err := r.skipField(f); err != nil {
+				return fd, err
+			}
+		}
+	}
+	return fd, nil
+}
+
+func (r *jfrPoolReader) skipFields(c *def.Class) error {
+	for fi := range c.Fields {
+		if err := r.skipField(&c.Fields[fi]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *jfrPoolReader) skipField(f *def.Field) error {
+	n := uint64(1)
+	if f.Array {
+		var err error
+		if n, err = r.count(); err != nil {
+			return err
+		}
+	}
+	for i := uint64(0); i < n; i++ {
+		start := r.pos
+		if err := r.skipValue(f); err != nil {
+			return err
+		}
+		// An element of no bytes would let nested counts multiply.
+		if f.Array && r.pos == start {
+			return fmt.Errorf("array %s of empty values at offset %d", f.Name, r.pos)
+		}
+	}
+	return nil
+}
+
+func (r *jfrPoolReader) skipValue(f *def.Field) error {
+	if f.ConstantPool {
+		_, err := r.varLong()
+		return err
+	}
+	c := r.tm.IDMap[f.Type]
+	if c == nil {
+		return fmt.Errorf("unknown type %d", f.Type)
+	}
+	return r.skipClassValue(c)
+}
+
+// skipClassValue skips one inline value of class c: a primitive, a string
+// or the fields of a composite type.
+func (r *jfrPoolReader) skipClassValue(c *def.Class) error {
+	switch c.Name {
+	case "boolean", "byte":
+		return r.skip(1)
+	case "float":
+		return r.skip(4)
+	case "double":
+		return r.skip(8)
+	case "short", "char", "int", "long":
+		_, err := r.varLong()
+		return err
+	case "java.lang.String":
+		return r.skipString()
+	}
+	if r.depth == maxValueDepth {
+		return fmt.Errorf("values of %s nested more than %d deep at offset %d", c.Name, maxValueDepth, r.pos)
+	}
+	r.depth++
+	defer func() { r.depth-- }()
+	return r.skipFields(c)
+}
+
+func (r *jfrPoolReader) skipString() error {
+	if r.pos >= len(r.buf) {
+		return fmt.Errorf("unexpected end of data at offset %d", r.pos)
+	}
+	enc := r.buf[r.pos]
+	r.pos++
+	switch enc {
+	case 0, 1: // null, empty
+		return nil
+	case 2: // constant pool reference
+		_, err := r.varLong()
+		return err
+	case 3, 5: // UTF-8, Latin-1
+		n, err := r.count()
+		if err != nil {
+			return err
+		}
+		return r.skip(int(n))
+	case 4: // char array
+		n, err := r.count()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := r.varLong(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown string encoding %d at offset %d", enc, r.pos-1)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// FuzzParseJFR feeds mutated recordings to the JFR parser. Any input must
+// fail with an error or parse within the resource limits; a panic escaping
+// parseJFRBuffer, a hang or an unbounded allocation is a bug.
+func FuzzParseJFR(f *testing.F) {
+	for _, name := range []string{"testdata/cpu.jfr", "testdata/alloc.jfr", "testdata/multichunk.jfr"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parsed, err := parseJFRBuffer(data, allEventTypes(), parseOpts{fromNanos: -1, toNanos: -1})
+		if err != nil {
+			return
+		}
+		checkParsedLimits(t, parsed.stacksByEvent)
+	})
+}
+
+// FuzzParseCollapsed feeds arbitrary text to the collapsed parser.
+func FuzzParseCollapsed(f *testing.F) {
+	f.Add([]byte("[main];Main.run;HashMap.resize 2\n[main];Main.run 1\n"))
+	f.Add([]byte("[event=cpu];[worker tid=7];A.a:12;B.b_[j] 3\r\n"))
+	f.Add([]byte("A;B;C 9223372036854775807\n;; 1\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sf, byEvent, _, err := parseCollapsedByEvent(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		checkParsedLimits(t, map[string]*stackFile{"": sf})
+		checkParsedLimits(t, byEvent)
+	})
+}
+
+func checkParsedLimits(t *testing.T, byEvent map[string]*stackFile) {
+	t.Helper()
+	for event, sf := range byEvent {
+		for _, st := range sf.stacks {
+			if len(st.frames) > maxStackFrames {
+				t.Fatalf("%s: stack of %d frames exceeds --max-frames %d", event, len(st.frames), maxStackFrames)
+			}
+			for _, fr := range st.frames {
+				if len(fr) > maxSymbolBytes+len(symbolEllipsis) {
+					t.Fatalf("%s: frame of %d bytes exceeds --max-symbol-bytes %d", event, len(fr), maxSymbolBytes)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newGenCmd() *cobra.Command {
+	var opts genOpts
+	cmd := &cobra.Command{
+		Use:   "gen",
+		Short: "Generate a synthetic collapsed-stack profile",
+		Long: `Gen writes a synthetic profile as collapsed text: hot methods follow a Zipf
+distribution, call paths share framework prefixes, and threads are named
+like real pools (http-nio-8080-exec-N, pool-1-thread-N, ...). The same
+--seed always produces the same output, so a scaling bug or a demo can be
+reproduced without sharing a real recording.`,
+		Example: strings.Join([]string{
+			`  ap-query gen --stacks 100000 --depth 40 --threads 16 -o synth.collapsed`,
+			`  ap-query gen --seed 7 | ap-query hot -`,
+		}, "\n"),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdGen(opts)
+		},
+	}
+	cmd.Flags().IntVar(&opts.stacks, "stacks", 10000, "Number of stack lines to generate")
+	cmd.Flags().IntVar(&opts.depth, "depth", 32, "Maximum frames per stack")
+	cmd.Flags().IntVar(&opts.threads, "threads", 8, "Number of threads")
+	cmd.Flags().Int64Var(&opts.seed, "seed", 1, "Random seed; the same seed gives the same profile")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "", "Output file (default stdout)")
+	return cmd
+}
+
+type genOpts struct {
+	stacks  int
+	depth   int
+	threads int
+	seed    int64
+	output  string
+}
+
+// genPool is a thread pool: its thread name pattern and the frames every
+// stack of its threads starts with.
+type genPool struct {
+	name  string // fmt pattern taking the thread number
+	roots []string
+}
+
+var genPools = []genPool{
+	{"http-nio-8080-exec-%d", []string{
+		"java/lang/Thread.run",
+		"org/apache/tomcat/util/threads/TaskThread$WrappingRunnable.run",
+		"org/apache/catalina/core/StandardWrapperValve.invoke",
+	}},
+	{"pool-1-thread-%d", []string{
+		"java/lang/Thread.run",
+		"java/util/concurrent/ThreadPoolExecutor$Worker.run",
+		"java/util/concurrent/ThreadPoolExecutor.runWorker",
+	}},
+	{"ForkJoinPool.commonPool-worker-%d", []string{
+		"java/util/concurrent/ForkJoinWorkerThread.run",
+		"java/util/concurrent/ForkJoinPool.runWorker",
+	}},
+	{"scheduler-%d", []string{
+		"java/lang/Thread.run",
+		"java/util/concurrent/ScheduledThreadPoolExecutor$ScheduledFutureTask.run",
+	}},
+}
+
+// genJDKLeaves are JDK methods mixed into the method pool so that the hot
+// list looks like a real one.
+var genJDKLeaves = []string{
+	"java/util/HashMap.resize",
+	"java/util/HashMap.getNode",
+	"java/lang/String.hashCode",
+	"java/lang/String.equals",
+	"java/util/ArrayList.grow",
+	"java/lang/StringBuilder.append",
+	"java/util/regex/Pattern$CharProperty.match",
+	"java/io/FileOutputStream.writeBytes",
+	"sun/nio/ch/SocketDispatcher.read0",
+	"jdk/internal/misc/Unsafe.park",
+}
+
+var (
+	genPackages = []string{"api", "service", "repository", "codec", "cache", "scheduler", "model", "util"}
+	genNouns    = []string{"Order", "User", "Payment", "Inventory", "Session", "Report", "Event", "Account", "Invoice", "Catalog"}
+	genSuffixes = []string{"Service", "Handler", "Repository", "Codec", "Cache", "Mapper", "Validator", "Client"}
+	genVerbs    = []string{"process", "load", "save", "encode", "decode", "validate", "compute", "lookup", "render", "merge", "apply", "flush"}
+)
+
+const (
+	genMethods = 2000 // distinct methods in the pool
+	genCallees = 3    // callees per method on generated call paths
+	genZipfS   = 1.2  // Zipf exponent; larger concentrates samples more
+)
+
+func cmdGen(opts genOpts) error {
+	if opts.stacks <= 0 {
+		return fmt.Errorf("--stacks must be positive")
+	}
+	if opts.depth < 2 {
+		return fmt.Errorf("--depth must be at least 2")
+	}
+	if opts.threads <= 0 {
+		return fmt.Errorf("--threads must be positive")
+	}
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if opts.output != "" && opts.output != "-" {
+		var err error
+		if f, err = os.Create(opts.output); err != nil {
+			return ioErrorf("%v", err)
+		}
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	samples := generateProfile(bw, opts)
+	err := bw.Flush()
+	if f != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "Generated %d stacks (%d samples, %d threads) to %s\n",
+				opts.stacks, samples, opts.threads, opts.output)
+		}
+	}
+	if err != nil {
+		return ioErrorf("writing profile: %v", err)
+	}
+	return nil
+}
+
+// generateProfile writes opts.stacks collapsed lines and returns the total
+// samples. Leaves are drawn from a Zipf distribution over the method pool;
+// each stack walks from its pool's root frames through a fixed call graph
+// (each method has genCallees callees, themselves Zipf-drawn), so stacks
+// share prefixes the way real ones do.
+func generateProfile(w io.Writer, opts genOpts) int {
+	rng := rand.New(rand.NewSource(opts.seed))
+	methods := genMethodPool(rng)
+	zipf := rand.NewZipf(rng, genZipfS, 1, uint64(len(methods)-1))
+	callees := make([][genCallees]int, len(methods))
+	for i := range callees {
+		for j := range callees[i] {
+			callees[i][j] = int(zipf.Uint64())
+		}
+	}
+	threads := make([]string, opts.threads)
+	for i := range threads {
+		threads[i] = fmt.Sprintf(genPools[i%len(genPools)].name, i/len(genPools)+1)
+	}
+
+	total := 0
+	frames := make([]string, 0, opts.depth)
+	for n := 0; n < opts.stacks; n++ {
+		t := rng.Intn(opts.threads)
+		roots := genPools[t%len(genPools)].roots
+		frames = frames[:0]
+		if opts.depth <= len(roots) {
+			frames = append(frames, roots[:opts.depth-1]...)
+		} else {
+			frames = append(frames, roots...)
+			// Middle frames between the roots and the leaf; a triangular
+			// draw keeps stacks of the full --depth rare, as in profiles
+			// that were not truncated.
+			span := opts.depth - len(roots)
+			mid := (rng.Intn(span) + rng.Intn(span)) / 2
+			m := int(zipf.Uint64())
+			for i := 0; i < mid; i++ {
+				frames = append(frames, methods[m])
+				m = callees[m][rng.Intn(genCallees)]
+			}
+		}
+		frames = append(frames, methods[zipf.Uint64()])
+		count := 1 + rng.Intn(5)
+		total += count
+		fmt.Fprintf(w, "%s%s %d\n", threadPrefix(threads[t]), strings.Join(frames, ";"), count)
+	}
+	return total
+}
+
+// genMethodPool returns genMethods distinct Java-style method names in a
+// seed-dependent order, so the hottest methods differ between seeds.
+func genMethodPool(rng *rand.Rand) []string {
+	seen := make(map[string]bool, genMethods)
+	methods := make([]string, 0, genMethods)
+	for _, m := range genJDKLeaves {
+		seen[m] = true
+		methods = append(methods, m)
+	}
+	for len(methods) < genMethods {
+		name := fmt.Sprintf("com/example/%s/%s%s.%s",
+			genPackages[rng.Intn(len(genPackages))],
+			genNouns[rng.Intn(len(genNouns))],
+			genSuffixes[rng.Intn(len(genSuffixes))],
+			genVerbs[rng.Intn(len(genVerbs))])
+		if seen[name] {
+			name = fmt.Sprintf("%s%d", name, len(methods))
+		}
+		seen[name] = true
+		methods = append(methods, name)
+	}
+	rng.Shuffle(len(methods), func(i, j int) { methods[i], methods[j] = methods[j], methods[i] })
+	return methods
+}
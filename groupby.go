@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// --group-by keys. Each one rewrites frames before aggregation, so every
+// command that ranks or nests frames rolls up the same way. The context key
+// instead adds a root frame per sample context id while parsing.
+const (
+	groupByMethod    = "method"
+	groupByLine      = "line"
+	groupByClass     = "class"
+	groupByPackage   = "package"
+	groupByFrameType = "frame-type"
+	groupByContext   = "context"
+)
+
+// groupByOwner (owners.go) needs a source checkout, so it is applied by
+// preprocessProfile rather than groupBy.
+
+const groupByUsage = "Aggregate frames by method (default), line, class, package, frame-type (JFR only), context (JFR only), or owner (needs --source-root)"
+
+func validateGroupBy(key string) error {
+	switch key {
+	case "", groupByMethod, groupByLine, groupByClass, groupByPackage, groupByFrameType, groupByContext, groupByOwner:
+		return nil
+	}
+	return fmt.Errorf("invalid --group-by %q (want method, line, class, package, frame-type, context, or owner)", key)
+}
+
+// packageKeySuffix ends --group-by package keys ("java/util/*"). Name
+// shortening keeps such a key whole, so java.util and com.acme.util stay
+// apart instead of both showing as util.*.
+const packageKeySuffix = "/*"
+
+// groupFrame returns the aggregation key of one frame. Class keys end in
+// ".*" so name shortening still shows the class, package keys in
+// packageKeySuffix; native frames have neither and are kept as they are.
+func groupFrame(key, frame string, line uint32, d frameDetail) string {
+	switch key {
+	case groupByLine:
+		if line > 0 {
+			return frame + ":" + strconv.FormatUint(uint64(line), 10)
+		}
+	case groupByClass, groupByPackage:
+		dot := strings.LastIndexByte(frame, '.')
+		if dot <= 0 || sourceFileOf(frame, false) == nativeFile {
+			return frame
+		}
+		class := frame[:dot]
+		// Hidden classes (lambdas) carry an address: Foo$$Lambda$5.0x0000...
+		if i := strings.LastIndexAny(class, "./"); i > 0 && strings.HasPrefix(class[i+1:], "0x") {
+			class = class[:i]
+		}
+		if key == groupByClass {
+			return class + ".*"
+		}
+		if i := strings.LastIndexAny(class, "./"); i > 0 {
+			return class[:i] + packageKeySuffix
+		}
+		return "(default)" + packageKeySuffix
+	case groupByFrameType:
+		if d.kind != "" {
+			return frame + " [" + d.kind + "]"
+		}
+	}
+	return frame
+}
+
+// groupBy rewrites every frame to its key and, for class and package keys,
+// merges the consecutive frames that collapse into one, so a class calling
+// itself counts once per stack like a recursive method.
+func (sf *stackFile) groupBy(key string) *stackFile {
+	if key == "" || key == groupByMethod || key == groupByContext {
+		return sf
+	}
+	out := &stackFile{totalSamples: sf.totalSamples, stacks: make([]stack, len(sf.stacks))}
+	for i := range sf.stacks {
+		st := sf.stacks[i]
+		frames := make([]string, len(st.frames))
+		for j, fr := range st.frames {
+			var d frameDetail
+			if st.details != nil {
+				d = st.details[j]
+			}
+			frames[j] = groupFrame(key, fr, st.lines[j], d)
+		}
+		st.frames = frames
+		out.stacks[i] = st
+	}
+	if key == groupByClass || key == groupByPackage {
+		return out.mergeRecursive()
+	}
+	return out
+}
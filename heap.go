@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/grafana/jfr-parser/parser"
+	"github.com/spf13/cobra"
+)
+
+func newHeapCmd() *cobra.Command {
+	var from, to string
+	var width int
+	cmd := &cobra.Command{
+		Use:   "heap <file>",
+		Short: "Heap used before and after GC over the recording, plus configured sizes (JFR only)",
+		Long: `Heap plots the Java heap over the recording from jdk.GCHeapSummary: used
+before each GC (how fast the heap fills) and after it (what survives), as
+sparklines on one scale, with the configured initial and maximum sizes from
+jdk.GCHeapConfiguration and the collectors from jdk.GCConfiguration. Use it
+to tie an allocation profile to the heap behavior it caused: a rising
+after-GC line means retained data is growing.
+
+These are JDK events: record with asprof --jfrsync. Without it, async-profiler
+writes a single heap summary at the end of the recording.`,
+		Example: strings.Join([]string{
+			"  ap-query heap profile.jfr",
+			"  ap-query heap profile.jfr --from 30s --width 40",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if detectFormat(args[0]) != formatJFR {
+				return fmt.Errorf("heap requires a JFR file")
+			}
+			if width < 1 {
+				return fmt.Errorf("--width must be at least 1")
+			}
+			window, err := parseDurationWindow("--from", from, "--to", to)
+			if err != nil {
+				return err
+			}
+			rep, err := parseHeap(args[0], window.fromNanos, window.toNanos)
+			if err != nil {
+				return err
+			}
+			cmdHeap(rep, width)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&from, "from", "", "Start of time window")
+	cmd.Flags().StringVar(&to, "to", "", "End of time window")
+	cmd.Flags().IntVar(&width, "width", 60, "Sparkline width in columns")
+	return cmd
+}
+
+// heapSample is one jdk.GCHeapSummary reading, in bytes.
+type heapSample struct {
+	offsetNanos int64
+	gcID        uint64
+	used        int64
+	committed   int64
+}
+
+type heapReport struct {
+	startNanos, endNanos int64 // plotted range
+	before, after        []heapSample
+	// From jdk.GCHeapConfiguration and jdk.GCConfiguration; zero or empty
+	// when not recorded.
+	initialSize, minSize, maxSize int64
+	youngGC, oldGC                string
+}
+
+var heapEventNames = []string{
+	"jdk.GCHeapSummary",
+	"jdk.GCHeapConfiguration",
+	"jdk.GCConfiguration",
+}
+
+// parseHeap reads the heap summaries and configuration of a JFR recording.
+// fromNanos/toNanos bound summary start times (-1 = open).
+func parseHeap(path string, fromNanos, toNanos int64) (*heapReport, error) {
+	buf, err := readJFRBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	_, spanNanos, err := scanChunkHeaders(buf)
+	if err != nil {
+		return nil, parseErrorf("%v", err)
+	}
+
+	rep := &heapReport{startNanos: max(fromNanos, 0), endNanos: spanNanos}
+	if toNanos >= 0 {
+		rep.endNanos = toNanos
+	}
+	err = scanJDKEvents(buf, heapEventNames, func(_ *parser.Parser, ev *jdkEvent) {
+		switch ev.name {
+		case "jdk.GCHeapConfiguration":
+			rep.initialSize = int64(ev.nums["initialSize"])
+			rep.minSize = int64(ev.nums["minSize"])
+			rep.maxSize = int64(ev.nums["maxSize"])
+		case "jdk.GCConfiguration":
+			rep.youngGC, rep.oldGC = ev.strs["youngCollector"], ev.strs["oldCollector"]
+		case "jdk.GCHeapSummary":
+			if (fromNanos >= 0 && ev.startNanos < fromNanos) || (toNanos >= 0 && ev.startNanos >= toNanos) {
+				return
+			}
+			s := heapSample{
+				offsetNanos: ev.startNanos,
+				gcID:        ev.nums["gcId"],
+				used:        int64(ev.nums["heapUsed"]),
+				committed:   int64(ev.nums["heapSpace.committedSize"]),
+			}
+			switch ev.strs["when"] {
+			case "Before GC":
+				rep.before = append(rep.before, s)
+			case "After GC":
+				rep.after = append(rep.after, s)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// heapSeries buckets samples over [start, end) into width columns, taking
+// each column's highest reading. Columns without a GC repeat the previous
+// value, and those before the first GC take its value, so the line does not
+// drop to zero where no collection was seen.
+func heapSeries(samples []heapSample, start, end int64, width int) []float64 {
+	series := make([]float64, width)
+	seen := make([]bool, width)
+	span := max(end-start, 1)
+	for _, s := range samples {
+		i := int((s.offsetNanos - start) * int64(width) / span)
+		i = min(max(i, 0), width-1)
+		series[i] = max(series[i], float64(s.used))
+		seen[i] = true
+	}
+	first := slices.Index(seen, true)
+	if first < 0 {
+		return series
+	}
+	for i := range width {
+		switch {
+		case i < first:
+			series[i] = series[first]
+		case !seen[i]:
+			series[i] = series[i-1]
+		}
+	}
+	return series
+}
+
+// heapRange summarizes samples as "first → last (±delta), min/peak".
+func heapRange(samples []heapSample, peakLabel string) string {
+	first, last := samples[0].used, samples[len(samples)-1].used
+	lo, hi := first, first
+	for _, s := range samples {
+		lo, hi = min(lo, s.used), max(hi, s.used)
+	}
+	delta := "+" + formatBytes(last-first)
+	if last < first {
+		delta = "-" + formatBytes(first-last)
+	}
+	extreme := formatBytes(hi)
+	if peakLabel == "min" {
+		extreme = formatBytes(lo)
+	}
+	return fmt.Sprintf("%s → %s (%s), %s %s", formatBytes(first), formatBytes(last), delta, peakLabel, extreme)
+}
+
+func cmdHeap(rep *heapReport, width int) {
+	if len(rep.before) == 0 && len(rep.after) == 0 && rep.maxSize == 0 {
+		fmt.Fprintln(os.Stdout, "no heap summary events (record with asprof --jfrsync profile)")
+		return
+	}
+
+	if rep.maxSize > 0 {
+		line := fmt.Sprintf("Heap: initial %s  min %s  max %s", formatBytes(rep.initialSize), formatBytes(rep.minSize), formatBytes(rep.maxSize))
+		if rep.youngGC != "" || rep.oldGC != "" {
+			line += fmt.Sprintf("  (%s / %s)", rep.youngGC, rep.oldGC)
+		}
+		fmt.Println(line)
+	}
+	if len(rep.before) == 0 && len(rep.after) == 0 {
+		fmt.Println("no GCs in range")
+		return
+	}
+
+	gcs := make(map[uint64]bool)
+	var committed int64
+	for _, samples := range [][]heapSample{rep.before, rep.after} {
+		for _, s := range samples {
+			gcs[s.gcID] = true
+		}
+		if len(samples) > 0 {
+			committed = max(committed, samples[len(samples)-1].committed)
+		}
+	}
+	fmt.Printf("GCs: %d  committed: %s\n", len(gcs), formatBytes(committed))
+	if len(rep.before) > 0 {
+		fmt.Printf("Used before GC: %s\n", heapRange(rep.before, "peak"))
+	}
+	if len(rep.after) > 0 {
+		fmt.Printf("Used after GC:  %s\n", heapRange(rep.after, "min"))
+	}
+
+	before := heapSeries(rep.before, rep.startNanos, rep.endNanos, width)
+	after := heapSeries(rep.after, rep.startNanos, rep.endNanos, width)
+	peak := 0.0
+	for i := range before {
+		peak = max(peak, before[i], after[i])
+	}
+	fmt.Println()
+	if len(rep.before) > 0 {
+		fmt.Printf("%-10s %s\n", "before GC", sparklineScaled(before, peak))
+	}
+	if len(rep.after) > 0 {
+		fmt.Printf("%-10s %s\n", "after GC", sparklineScaled(after, peak))
+	}
+	startLabel, endLabel := formatDuration(rep.startNanos), formatDuration(rep.endNanos)
+	pad := max(width-len(startLabel)-len(endLabel), 1)
+	fmt.Printf("%-10s %s%s%s  (scale 0 to %s)\n", "", startLabel, strings.Repeat(" ", pad), endLabel, formatBytes(int64(peak)))
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/jfr-parser/parser"
+	"github.com/grafana/jfr-parser/parser/types"
+	"github.com/spf13/cobra"
+)
+
+func newHistCmd() *cobra.Command {
+	var mf methodFlags
+	var event, thread, from, to string
+	cmd := &cobra.Command{
+		Use:   "hist <file>",
+		Short: "Log-scale duration histogram of lock, park and I/O events (JFR only)",
+		Long: `Hist prints how long threads blocked: a log-scale histogram of event
+durations with p50/p90/p99/max, per kind of blocking event. The total time
+blocked can come from a few long stalls or from many short waits; the
+distribution tells them apart.
+
+Kinds and the JFR events they read:
+  lock  jdk.JavaMonitorEnter (asprof -e lock)
+  park  jdk.ThreadPark (asprof -e lock, java.util.concurrent locks)
+  io    jdk.SocketRead, jdk.SocketWrite, jdk.FileRead, jdk.FileWrite (JFR
+        profile settings or asprof --jfrsync)
+
+-m keeps the events whose stack contains a matching method.`,
+		Example: strings.Join([]string{
+			"  ap-query hist profile.jfr",
+			"  ap-query hist profile.jfr --event lock -m ConnectionPool.borrow",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if detectFormat(args[0]) != formatJFR {
+				return fmt.Errorf("hist requires a JFR file")
+			}
+			kinds := histKinds
+			if event != "" {
+				kinds = nil
+				for _, k := range histKinds {
+					if k.name == event {
+						kinds = []histKind{k}
+					}
+				}
+				if kinds == nil {
+					return fmt.Errorf("invalid --event %q (want lock, park, or io)", event)
+				}
+			}
+			window, err := parseDurationWindow("--from", from, "--to", to)
+			if err != nil {
+				return err
+			}
+			durs, err := parseBlockingDurations(args[0], kinds, mf.matcher(), thread, window.fromNanos, window.toNanos)
+			if err != nil {
+				return err
+			}
+			cmdHist(kinds, durs, mf.method != "" || thread != "" || window.specified)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&mf.method, "method", "m", "", "Keep events whose stack contains a matching method")
+	mf.registerModes(cmd)
+	cmd.Flags().StringVarP(&event, "event", "e", "", "Kind: lock, park, or io (default: every kind recorded)")
+	cmd.Flags().StringVarP(&thread, "thread", "t", "", "Filter to threads matching substring")
+	cmd.Flags().StringVar(&from, "from", "", "Start of time window")
+	cmd.Flags().StringVar(&to, "to", "", "End of time window")
+	return cmd
+}
+
+// histKind is a kind of blocking event and the JFR events it covers.
+type histKind struct {
+	name   string
+	events []string
+}
+
+var histKinds = []histKind{
+	{"lock", []string{"jdk.JavaMonitorEnter"}},
+	{"park", []string{"jdk.ThreadPark"}},
+	{"io", []string{"jdk.SocketRead", "jdk.SocketWrite", "jdk.FileRead", "jdk.FileWrite"}},
+}
+
+// parseBlockingDurations returns the durations of the events of each kind,
+// by kind name, for events starting inside [fromNanos, toNanos) (-1 =
+// open) on threads matching thread and, if m has a pattern, with a matching
+// frame on the stack.
+func parseBlockingDurations(path string, kinds []histKind, m methodMatcher, thread string, fromNanos, toNanos int64) (map[string][]int64, error) {
+	buf, err := readJFRBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	kindOf := make(map[string]string)
+	var names []string
+	for _, k := range kinds {
+		for _, e := range k.events {
+			kindOf[e] = k.name
+			names = append(names, e)
+		}
+	}
+
+	durs := make(map[string][]int64)
+	var stackCache map[types.StackTraceRef]*cachedStackTrace
+	var lastParser *parser.Parser
+	err = scanJDKEvents(buf, names, func(p *parser.Parser, ev *jdkEvent) {
+		if (fromNanos >= 0 && ev.startNanos < fromNanos) || (toNanos >= 0 && ev.startNanos >= toNanos) {
+			return
+		}
+		if thread != "" && !strings.Contains(resolveThread(p, types.ThreadRef(ev.nums["eventThread"])), thread) {
+			return
+		}
+		if m.pattern != "" {
+			if p != lastParser { // stack trace ids are per chunk
+				stackCache = make(map[types.StackTraceRef]*cachedStackTrace)
+				lastParser = p
+			}
+			cached := resolveStackTraceCached(p, stackCache, nil, types.StackTraceRef(ev.nums["stackTrace"]))
+			if !sliceMatches(cached.frames, m) {
+				return
+			}
+		}
+		kind := kindOf[ev.name]
+		durs[kind] = append(durs[kind], ev.durNanos)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return durs, nil
+}
+
+func sliceMatches(frames []string, m methodMatcher) bool {
+	for _, fr := range frames {
+		if m.matches(fr) {
+			return true
+		}
+	}
+	return false
+}
+
+// histBarWidth is the length of the longest histogram bar.
+const histBarWidth = 40
+
+// cmdHist prints one histogram per kind with events. filtered tells the
+// empty-result message whether -m, -t or a window removed events.
+func cmdHist(kinds []histKind, durs map[string][]int64, filtered bool) {
+	printed := false
+	for _, k := range kinds {
+		d := durs[k.name]
+		if len(d) == 0 {
+			continue
+		}
+		if printed {
+			fmt.Println()
+		}
+		printed = true
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		var total int64
+		for _, v := range d {
+			total += v
+		}
+		fmt.Printf("=== %s (%s): %d events, %s blocked ===\n", k.name, strings.Join(k.events, ", "), len(d), formatPause(total))
+		fmt.Printf("p50 %s  p90 %s  p99 %s  max %s\n\n",
+			formatPause(percentile(d, 50)), formatPause(percentile(d, 90)), formatPause(percentile(d, 99)), formatPause(d[len(d)-1]))
+
+		buckets := histBuckets(d)
+		peak := 0
+		for _, b := range buckets {
+			peak = max(peak, b.count)
+		}
+		fmt.Printf("%-15s %9s %7s\n", "DURATION", "COUNT", "%")
+		for _, b := range buckets {
+			bar := strings.Repeat("█", (b.count*histBarWidth+peak-1)/peak)
+			fmt.Printf("%-15s %9d %6.*f%%  %s\n", b.label, b.count, pctDigits, pctOf(b.count, len(d)), bar)
+		}
+	}
+	if printed {
+		return
+	}
+	names := make([]string, len(kinds))
+	for i, k := range kinds {
+		names[i] = k.name
+	}
+	if filtered {
+		fmt.Fprintf(os.Stdout, "no %s events match -m/-t/--from/--to\n", strings.Join(names, "/"))
+		return
+	}
+	fmt.Fprintf(os.Stdout, "no %s events (record with asprof -e lock, or JFR's profile settings for I/O)\n", strings.Join(names, "/"))
+}
+
+// percentile returns the nearest-rank p-th percentile of sorted.
+func percentile(sorted []int64, p float64) int64 {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+type histBucket struct {
+	label string
+	count int
+}
+
+// histBuckets counts sorted durations into 1-2-5 log-scale buckets from
+// 1us up, covering the smallest through the largest value. Durations under
+// 1us share the first bucket.
+func histBuckets(sorted []int64) []histBucket {
+	var edges []int64 // lower bounds
+	for base := int64(time.Microsecond); ; base *= 10 {
+		for _, mult := range []int64{1, 2, 5} {
+			edges = append(edges, base*mult)
+		}
+		if base*10 > sorted[len(sorted)-1] {
+			break
+		}
+	}
+	counts := make([]int, len(edges))
+	for _, v := range sorted {
+		i := sort.Search(len(edges), func(i int) bool { return edges[i] > v }) - 1
+		counts[max(i, 0)]++
+	}
+	first, last := 0, len(counts)-1
+	for counts[first] == 0 {
+		first++
+	}
+	for counts[last] == 0 {
+		last--
+	}
+	out := make([]histBucket, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		upper := edges[i] * 2 // the last bound is a 5, whose next is 10
+		if i+1 < len(edges) {
+			upper = edges[i+1]
+		}
+		label := formatEdge(edges[i]) + "-" + formatEdge(upper)
+		if i == 0 {
+			label = "<" + formatEdge(upper)
+		}
+		out = append(out, histBucket{label, counts[i]})
+	}
+	return out
+}
+
+// formatEdge formats a 1-2-5 bucket bound, which is a whole number of us,
+// ms or s.
+func formatEdge(nanos int64) string {
+	switch {
+	case nanos >= int64(time.Second) && nanos%int64(time.Second) == 0:
+		return fmt.Sprintf("%ds", nanos/int64(time.Second))
+	case nanos >= int64(time.Millisecond) && nanos%int64(time.Millisecond) == 0:
+		return fmt.Sprintf("%dms", nanos/int64(time.Millisecond))
+	}
+	return fmt.Sprintf("%dus", nanos/int64(time.Microsecond))
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -11,26 +12,88 @@ func newHotCmd() *cobra.Command {
 	var shared sharedFlags
 	var top int
 	var fqn bool
+	var ids bool
 	var assertBelow float64
+	var rate bool
+	var columnArgs []string
+	var callersN int
+	var includeVM bool
 	cmd := &cobra.Command{
 		Use:   "hot <file>",
 		Short: "Rank methods by self-time and total-time",
-		Args:  cobra.ExactArgs(1),
+		Example: strings.Join([]string{
+			"  ap-query hot profile.jfr",
+			"  ap-query hot profile.jfr --rate --from 10s --to 20s",
+			"  ap-query hot profile.jfr --ids --top 50",
+			"  ap-query hot profile.jfr --column 'estimated_ms = samples * interval'",
+			"  ap-query hot profile.jfr --source-root ~/src/app --group-by owner",
+			"  ap-query hot profile.jfr --callers 3",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if assertBelow < 0 {
 				return fmt.Errorf("--assert-below must not be negative (got %g)", assertBelow)
 			}
-			pctx, err := preprocessProfile(shared.toOpts(args[0], "hot"))
+			if callersN < 0 {
+				return fmt.Errorf("--callers must not be negative (got %d)", callersN)
+			}
+			if shared.repoURL != "" && shared.groupBy != groupByMethod {
+				return fmt.Errorf("--repo-url links methods; it cannot be combined with --group-by %s", shared.groupBy)
+			}
+			columns, err := parseColumnArgs(columnArgs)
+			if err != nil {
+				return err
+			}
+			opts := shared.toOpts(args[0], "hot")
+			opts.excludeIdle = !includeVM
+			pctx, err := preprocessProfile(opts)
 			if err != nil {
 				return err
 			}
-			return cmdHot(pctx.sf, top, fqn, assertBelow)
+			cols, err := newCustomColumns(columns, pctx)
+			if err != nil {
+				return err
+			}
+			if ids {
+				fqn = true
+			}
+			var r *hotRate
+			if rate {
+				if r, err = newHotRate(pctx); err != nil {
+					return err
+				}
+			}
+			var owners map[string]string
+			if pctx.owners != nil && shared.groupBy != groupByOwner {
+				owners = pctx.owners.ownersByName(pctx.sf, fqn)
+			}
+			var links map[string]string
+			if pctx.links != nil {
+				links = pctx.links.urlsByName(pctx.sf, fqn)
+			}
+			var callers *hotCallers
+			if callersN > 0 {
+				callers = computeHotCallers(pctx.sf, fqn, callersN)
+			}
+			err = cmdHotWith(pctx.sf, top, fqn, ids, assertBelow, r, cols, owners, links, callers)
+			printIdleExcluded(pctx)
+			return err
 		},
 	}
 	shared.register(cmd)
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	shared.registerGroupBy(cmd)
+	shared.registerCPUs(cmd)
+	shared.registerRepoURL(cmd)
 	cmd.Flags().IntVar(&top, "top", 10, "Limit output rows")
 	cmd.Flags().BoolVar(&fqn, "fqn", false, "Show fully-qualified names")
+	cmd.Flags().BoolVar(&ids, "ids", false, "Prefix rows with a stable method ID (hash of the fully-qualified name; implies --fqn)")
 	cmd.Flags().Float64Var(&assertBelow, "assert-below", 0, "Exit 1 if top method self% >= F (for CI gates)")
+	cmd.Flags().BoolVar(&rate, "rate", false, "Add samples/second and, for cpu, estimated CPU cores (needs the recording duration)")
+	cmd.Flags().StringArrayVar(&columnArgs, "column", nil, columnUsage)
+	cmd.Flags().BoolVar(&includeVM, "include-vm", false, "Rank idle wall samples too (parked, waiting, sleeping, blocked in epoll/futex), which hot leaves out by default")
+	cmd.Flags().IntVar(&callersN, "callers", 0, "Under each row, list the method's top N immediate callers with their share of its samples")
 	return cmd
 }
 
@@ -56,31 +119,47 @@ func computeHot(sf *stackFile, fqn bool) []hotEntry {
 		return nil
 	}
 
-	sc := selfCounts(sf, fqn)
-	totalCounts := make(map[string]int)
+	n := sf.namer(fqn)
+	self := make([]int, n.len())
+	total := make([]int, n.len())
+	// lastSeen[id] is 1 + the index of the last stack that counted id towards
+	// total, so recursion is counted once per stack without a per-stack set.
+	lastSeen := make([]int, n.len())
 
 	for i := range sf.stacks {
 		st := &sf.stacks[i]
-		seen := make(map[string]bool)
-		for _, fr := range st.frames {
-			key := displayName(fr, fqn)
-			if !seen[key] {
-				totalCounts[key] += st.count
-				seen[key] = true
+		stamp := i + 1
+		for j, fr := range st.frames {
+			id := n.id(fr)
+			for int(id) >= len(total) {
+				self = append(self, 0)
+				total = append(total, 0)
+				lastSeen = append(lastSeen, 0)
+			}
+			if lastSeen[id] != stamp {
+				total[id] += st.count
+				lastSeen[id] = stamp
+			}
+			if j == len(st.frames)-1 {
+				self[id] += st.count
 			}
 		}
 	}
 
 	var ranked []hotEntry
-	for name, s := range sc {
-		ranked = append(ranked, hotEntry{name, s, totalCounts[name]})
-	}
-	for name, tc := range totalCounts {
-		if _, hasSelf := sc[name]; !hasSelf {
-			ranked = append(ranked, hotEntry{name, 0, tc})
+	for id, tc := range total {
+		// The namer is shared with other analyses of sf, so it may know
+		// names that this pass did not see.
+		if tc > 0 {
+			ranked = append(ranked, hotEntry{n.name(int32(id)), self[id], tc})
 		}
 	}
-	sort.Slice(ranked, func(i, j int) bool { return ranked[i].selfCount > ranked[j].selfCount })
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].selfCount != ranked[j].selfCount {
+			return ranked[i].selfCount > ranked[j].selfCount
+		}
+		return ranked[i].name < ranked[j].name
+	})
 	return ranked
 }
 
@@ -100,7 +179,99 @@ func computeHotSorted(sf *stackFile, fqn bool, sortBy string) ([]hotEntry, error
 	return ranked, nil
 }
 
+// hotRate converts sample counts to absolute rates over a wall-clock span.
+type hotRate struct {
+	spanNanos   int64
+	cpuInterval int64   // ns of CPU time per sample; 0 = no cores column
+	cpuLimit    float64 // CPUs the JVM was limited to; 0 = none known
+}
+
+// newHotRate derives the rate span from the recording duration, narrowed to
+// the --from/--to window.
+func newHotRate(pctx *profileContext) (*hotRate, error) {
+	end := pctx.spanNanos
+	if pctx.toNanos >= 0 && (end == 0 || pctx.toNanos < end) {
+		end = pctx.toNanos
+	}
+	start := max(pctx.fromNanos, 0)
+	if end <= start {
+		return nil, fmt.Errorf("--rate requires the recording duration (JFR, or pprof with a duration)")
+	}
+	r := &hotRate{spanNanos: end - start}
+	if pctx.eventType == "cpu" {
+		r.cpuInterval = pctx.cpuInterval
+		if pctx.cpuLimit.limited() {
+			r.cpuLimit = pctx.cpuLimit.cpus
+		}
+	}
+	return r, nil
+}
+
+func (r *hotRate) perSecond(count int) float64 {
+	return float64(count) * 1e9 / float64(r.spanNanos)
+}
+
+func (r *hotRate) cores(count int) float64 {
+	return float64(count) * float64(r.cpuInterval) / float64(r.spanNanos)
+}
+
+func (r *hotRate) header() string {
+	if r.cpuInterval > 0 {
+		return fmt.Sprintf(" %10s %6s", "SAMPLES/S", "CORES")
+	}
+	return fmt.Sprintf(" %10s", "SAMPLES/S")
+}
+
+func (r *hotRate) columns(count int) string {
+	if r.cpuInterval > 0 {
+		return fmt.Sprintf(" %10.1f %6.2f", r.perSecond(count), r.cores(count))
+	}
+	return fmt.Sprintf(" %10.1f", r.perSecond(count))
+}
+
 func printHotTables(ranked []hotEntry, top, totalSamples int, showTopN bool) {
+	printHotRateTables(ranked, top, totalSamples, showTopN, nil, false, nil, nil, nil, nil)
+}
+
+// printHotRateTables prints the self and total rankings, with rate columns
+// when rate is non-nil, a leading method ID column with ids, the --column
+// values, and last the source file owners when owners is non-nil. With
+// callers, each row is followed by its top immediate callers.
+func printHotRateTables(ranked []hotEntry, top, totalSamples int, showTopN bool, rate *hotRate, ids bool, cols *customColumns, owners, links map[string]string, callers *hotCallers) {
+	header := fmt.Sprintf("%-50s %7s %7s %9s", "METHOD", "SELF%", "TOTAL%", "SAMPLES")
+	if ids {
+		header = fmt.Sprintf("%-12s ", "ID") + header
+	}
+	row := func(e hotEntry, count int) {
+		sp := pctOf(e.selfCount, totalSamples)
+		tp := pctOf(e.totalCount, totalSamples)
+		line := fmt.Sprintf("%-50s %6.*f%% %6.*f%% %9d", e.name, pctDigits, sp, pctDigits, tp, count)
+		if ids {
+			line = methodID(e.name) + " " + line
+		}
+		if rate != nil {
+			line += rate.columns(count)
+		}
+		line += cols.values(count)
+		if owners != nil {
+			line += "  " + ownerColumn(owners[e.name])
+		}
+		if links != nil {
+			line += "  " + urlColumn(links[e.name])
+		}
+		fmt.Println(line)
+	}
+	if rate != nil {
+		header += rate.header()
+	}
+	header += cols.header()
+	if owners != nil {
+		header += "  OWNER"
+	}
+	if links != nil {
+		header += "  URL"
+	}
+
 	selfRanked := ranked[:truncate(len(ranked), top)]
 
 	if showTopN {
@@ -108,11 +279,12 @@ func printHotTables(ranked []hotEntry, top, totalSamples int, showTopN bool) {
 	} else {
 		fmt.Println("=== RANK BY SELF TIME ===")
 	}
-	fmt.Printf("%-50s %7s %7s %9s\n", "METHOD", "SELF%", "TOTAL%", "SAMPLES")
+	fmt.Println(header)
 	for _, e := range selfRanked {
-		sp := pctOf(e.selfCount, totalSamples)
-		tp := pctOf(e.totalCount, totalSamples)
-		fmt.Printf("%-50s %6.1f%% %6.1f%% %9d\n", e.name, sp, tp, e.selfCount)
+		row(e, e.selfCount)
+		if callers != nil {
+			callers.print(callers.self[e.name], e.selfCount)
+		}
 	}
 
 	totalRanked := make([]hotEntry, len(ranked))
@@ -126,28 +298,132 @@ func printHotTables(ranked []hotEntry, top, totalSamples int, showTopN bool) {
 	} else {
 		fmt.Println("=== RANK BY TOTAL TIME ===")
 	}
-	fmt.Printf("%-50s %7s %7s %9s\n", "METHOD", "SELF%", "TOTAL%", "SAMPLES")
+	fmt.Println(header)
 	for _, e := range totalRanked {
-		sp := pctOf(e.selfCount, totalSamples)
-		tp := pctOf(e.totalCount, totalSamples)
-		fmt.Printf("%-50s %6.1f%% %6.1f%% %9d\n", e.name, sp, tp, e.totalCount)
+		row(e, e.totalCount)
+		if callers != nil {
+			callers.print(callers.total[e.name], e.totalCount)
+		}
 	}
 }
 
-func cmdHot(sf *stackFile, top int, fqn bool, assertBelow float64) error {
+func cmdHot(sf *stackFile, top int, fqn, ids bool, assertBelow float64) error {
+	return cmdHotWith(sf, top, fqn, ids, assertBelow, nil, nil, nil, nil, nil)
+}
+
+// cmdHotRate is cmdHot with samples/second (and CPU cores) columns, after a
+// summary line of the whole profile's rate.
+func cmdHotRate(sf *stackFile, top int, fqn, ids bool, assertBelow float64, rate *hotRate) error {
+	return cmdHotWith(sf, top, fqn, ids, assertBelow, rate, nil, nil, nil, nil)
+}
+
+// cmdHotWith is cmdHot with optional rate columns (rate non-nil),
+// --column values (cols non-nil), an OWNER column (owners non-nil), a URL
+// column (links non-nil) and inline callers (callers non-nil).
+func cmdHotWith(sf *stackFile, top int, fqn, ids bool, assertBelow float64, rate *hotRate, cols *customColumns, owners, links map[string]string, callers *hotCallers) error {
 	ranked := computeHot(sf, fqn)
 	if len(ranked) == 0 {
 		return nil
 	}
 
-	printHotTables(ranked, top, sf.totalSamples, false)
+	if rate != nil {
+		summary := fmt.Sprintf("Duration: %s  Rate: %.1f samples/s", formatDuration(rate.spanNanos), rate.perSecond(sf.totalSamples))
+		if rate.cpuInterval > 0 {
+			summary += fmt.Sprintf("  CPU: %.2f cores", rate.cores(sf.totalSamples))
+			if rate.cpuLimit > 0 {
+				summary += fmt.Sprintf(" of %g", rate.cpuLimit)
+			}
+		}
+		fmt.Println(summary)
+		fmt.Println()
+	}
+	printHotRateTables(ranked, top, sf.totalSamples, false, rate, ids, cols, owners, links, callers)
+	return checkHotAssert(ranked, sf.totalSamples, assertBelow)
+}
 
+// printIdleExcluded notes the idle wall samples left out of the ranking,
+// so percentages are read as shares of the active samples.
+func printIdleExcluded(pctx *profileContext) {
+	if pctx.idleExcluded == 0 {
+		return
+	}
+	fmt.Printf("\nExcluded %d idle wall samples (%.*f%% of %d: parked, waiting, sleeping, blocked in epoll/futex); percentages are of active samples; --include-vm ranks them\n",
+		pctx.idleExcluded, pctDigits, pctOf(pctx.idleExcluded, pctx.idleTotal), pctx.idleTotal)
+}
+
+func checkHotAssert(ranked []hotEntry, totalSamples int, assertBelow float64) error {
 	// assert-below stays on self-time section only
 	if assertBelow > 0 && len(ranked) > 0 {
-		selfPct := pctOf(ranked[0].selfCount, sf.totalSamples)
+		selfPct := pctOf(ranked[0].selfCount, totalSamples)
 		if selfPct >= assertBelow {
-			return fmt.Errorf("ASSERT FAILED: %s self=%.1f%% >= threshold %.1f%%", ranked[0].name, selfPct, assertBelow)
+			return assertionErrorf("ASSERT FAILED: %s self=%.*f%% >= threshold %.*f%%", ranked[0].name, pctDigits, selfPct, pctDigits, assertBelow)
 		}
 	}
 	return nil
 }
+
+// hotCallers holds the immediate callers of every method for hot
+// --callers: of its leaf frames for the self ranking and of all its frames
+// for the total ranking. A stack counts once per caller, and a method
+// calling itself is not its own caller.
+type hotCallers struct {
+	n     int                       // callers listed per row
+	self  map[string]map[string]int // method → caller → samples
+	total map[string]map[string]int
+}
+
+// noCaller stands for the missing caller of a stack's root frame.
+const noCaller = "(root)"
+
+func computeHotCallers(sf *stackFile, fqn bool, n int) *hotCallers {
+	hc := &hotCallers{n: n, self: make(map[string]map[string]int), total: make(map[string]map[string]int)}
+	add := func(m map[string]map[string]int, name, caller string, count int) {
+		if m[name] == nil {
+			m[name] = make(map[string]int)
+		}
+		m[name][caller] += count
+	}
+	type edge struct{ name, caller string }
+	for i := range sf.stacks {
+		st := &sf.stacks[i]
+		seen := make(map[edge]bool)
+		caller := noCaller // of the current run of frames of one method
+		for j, fr := range st.frames {
+			name := displayName(fr, fqn)
+			if j > 0 {
+				if prev := displayName(st.frames[j-1], fqn); prev != name {
+					caller = prev
+				}
+			}
+			if e := (edge{name, caller}); !seen[e] {
+				seen[e] = true
+				add(hc.total, name, caller, st.count)
+			}
+			if j == len(st.frames)-1 {
+				add(hc.self, name, caller, st.count)
+			}
+		}
+	}
+	return hc
+}
+
+// print writes the top callers of a row with count samples, as shares of
+// them.
+func (hc *hotCallers) print(callers map[string]int, count int) {
+	if count == 0 {
+		return
+	}
+	names := make([]string, 0, len(callers))
+	for c := range callers {
+		names = append(names, c)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if callers[names[i]] != callers[names[j]] {
+			return callers[names[i]] > callers[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for _, c := range names[:truncate(len(names), hc.n)] {
+		fmt.Printf("    ← %5.*f%% %s\n", pctDigits, pctOf(callers[c], count), c)
+	}
+}
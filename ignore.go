@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// diffIgnore holds --ignore / --ignore-threads globs. Ignored methods are
+// hidden from the diff report (percentages are unchanged); ignored threads
+// are removed from both sides before comparing. A nil *diffIgnore ignores
+// nothing.
+type diffIgnore struct {
+	methods []*regexp.Regexp
+	threads []*regexp.Regexp
+}
+
+// newDiffIgnore compiles method and thread globs. An argument of the form
+// @FILE is replaced by the patterns in FILE, one per line (blank lines and
+// # comments are skipped). It returns nil when there are no patterns.
+func newDiffIgnore(methodArgs, threadArgs []string) (*diffIgnore, error) {
+	methods, err := compileGlobArgs("--ignore", methodArgs)
+	if err != nil {
+		return nil, err
+	}
+	threads, err := compileGlobArgs("--ignore-threads", threadArgs)
+	if err != nil {
+		return nil, err
+	}
+	if len(methods) == 0 && len(threads) == 0 {
+		return nil, nil
+	}
+	return &diffIgnore{methods: methods, threads: threads}, nil
+}
+
+func compileGlobArgs(flag string, args []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, arg := range args {
+		patterns := []string{arg}
+		if file, ok := strings.CutPrefix(arg, "@"); ok {
+			var err error
+			if patterns, err = readPatternFile(file); err != nil {
+				return nil, err
+			}
+		}
+		for _, p := range patterns {
+			if p == "" {
+				return nil, fmt.Errorf("%s: empty pattern", flag)
+			}
+			res = append(res, globRegexp(p))
+		}
+	}
+	return res, nil
+}
+
+func readPatternFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+// globRegexp turns a glob (* = any run of characters, ? = one character)
+// into an anchored regexp; everything else matches literally.
+func globRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func matchesAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterThreads drops stacks whose thread matches an --ignore-threads glob.
+func (ig *diffIgnore) filterThreads(sf *stackFile) *stackFile {
+	if ig == nil || len(ig.threads) == 0 {
+		return sf
+	}
+	out := &stackFile{}
+	for i := range sf.stacks {
+		if !matchesAny(ig.threads, sf.stacks[i].thread) {
+			out.stacks = append(out.stacks, sf.stacks[i])
+			out.totalSamples += sf.stacks[i].count
+		}
+	}
+	return out
+}
+
+// hiddenMethods returns the display names (per fqn) of every frame in sfs
+// matching an --ignore glob. A glob is tried against both the short name
+// (HashMap.resize) and the dotted fully-qualified name, so "GC*" and
+// "jdk.internal.*" both work regardless of --fqn.
+func (ig *diffIgnore) hiddenMethods(fqn bool, sfs ...*stackFile) map[string]bool {
+	if ig == nil || len(ig.methods) == 0 {
+		return nil
+	}
+	hidden := make(map[string]bool)
+	for _, sf := range sfs {
+		for _, fr := range sf.index().frames {
+			if matchesAny(ig.methods, shortName(fr)) || matchesAny(ig.methods, displayName(fr, true)) {
+				hidden[displayName(fr, fqn)] = true
+			}
+		}
+	}
+	return hidden
+}
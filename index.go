@@ -0,0 +1,78 @@
+package main
+
+import "sort"
+
+// stackIndex is an inverted index from raw frame to the stacks containing
+// it. It is built once per stackFile on first use and shared by every later
+// query against that file, so e.g. each method that info --expand drills
+// into tests the distinct frames against the pattern instead of every frame
+// of every stack. A stackFile must not be modified after it is indexed.
+type stackIndex struct {
+	frames   []string           // distinct raw frames, in first-seen order
+	stacksOf map[string][]int32 // raw frame → ascending stack indices, each at most once
+}
+
+func buildStackIndex(sf *stackFile) *stackIndex {
+	idx := &stackIndex{stacksOf: make(map[string][]int32)}
+	for i := range sf.stacks {
+		for _, fr := range sf.stacks[i].frames {
+			list, ok := idx.stacksOf[fr]
+			if !ok {
+				idx.frames = append(idx.frames, fr)
+			}
+			// Recursive frames repeat within a stack; record the stack once.
+			if n := len(list); n == 0 || list[n-1] != int32(i) {
+				idx.stacksOf[fr] = append(list, int32(i))
+			}
+		}
+	}
+	return idx
+}
+
+func (sf *stackFile) index() *stackIndex {
+	if sf.idx == nil {
+		sf.idx = buildStackIndex(sf)
+	}
+	return sf.idx
+}
+
+// frameMatch is the result of looking up a method pattern in the index.
+type frameMatch struct {
+	stacks []int           // ascending indices of stacks with a matched frame
+	frames map[string]bool // matched raw frames
+}
+
+// match returns the stacks and raw frames selected by m. Callers walk only
+// match.stacks and test frames with match.frames instead of m.matches.
+func (sf *stackFile) match(m methodMatcher) frameMatch {
+	idx := sf.index()
+	fm := frameMatch{frames: make(map[string]bool)}
+	var lists [][]int32
+	for _, fr := range idx.frames {
+		if m.matches(fr) {
+			fm.frames[fr] = true
+			lists = append(lists, idx.stacksOf[fr])
+		}
+	}
+
+	switch len(lists) {
+	case 0:
+	case 1:
+		fm.stacks = make([]int, len(lists[0]))
+		for i, s := range lists[0] {
+			fm.stacks[i] = int(s)
+		}
+	default:
+		seen := make(map[int32]bool)
+		for _, list := range lists {
+			for _, s := range list {
+				if !seen[s] {
+					seen[s] = true
+					fm.stacks = append(fm.stacks, int(s))
+				}
+			}
+		}
+		sort.Ints(fm.stacks)
+	}
+	return fm
+}
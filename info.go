@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -10,6 +15,9 @@ import (
 func newInfoCmd() *cobra.Command {
 	var shared sharedFlags
 	var expand int
+	var expandDepth int
+	var expandMinPct float64
+	var expandMaxLines int
 	var topThreads int
 	var topMethods int
 	cmd := &cobra.Command{
@@ -17,25 +25,52 @@ func newInfoCmd() *cobra.Command {
 		Short: "One-shot triage: events, threads, hot methods, and drill-down",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if expandDepth < 1 {
+				return fmt.Errorf("--expand-depth must be at least 1 (got %d)", expandDepth)
+			}
+			if expandMaxLines < 0 {
+				return fmt.Errorf("--expand-max-lines must be non-negative (got %d)", expandMaxLines)
+			}
 			pctx, err := preprocessProfile(shared.toOpts(args[0], "info"))
 			if err != nil {
 				return err
 			}
+			var settings map[string]string
+			var originNanos int64
+			if pctx.parsed != nil {
+				settings = pctx.parsed.settings
+				originNanos = pctx.parsed.originNanos
+			}
 			cmdInfo(pctx.sf, infoOpts{
 				eventType:     pctx.eventType,
 				hasMetadata:   pctx.hasMetadata,
 				eventCounts:   pctx.eventCounts,
 				expand:        expand,
+				expandDepth:   expandDepth,
+				expandMinPct:  expandMinPct,
+				expandLines:   expandMaxLines,
 				topThreads:    topThreads,
 				topMethods:    topMethods,
 				spanNanos:     pctx.spanNanos,
+				originNanos:   originNanos,
 				stacksByEvent: pctx.stacksByEvent,
+				settings:      settings,
+				truncation:    pctx.truncation,
+				cpuLimit:      pctx.cpuLimit,
+				cpuWarning:    pctx.cpuLimitWarning(),
 			})
 			return nil
 		},
 	}
 	shared.register(cmd)
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	shared.registerThreadNormalize(cmd)
+	shared.registerCPUs(cmd)
 	cmd.Flags().IntVar(&expand, "expand", 3, "Auto-expand top N hot methods (0=off)")
+	cmd.Flags().IntVar(&expandDepth, "expand-depth", 3, "Tree and callers depth in drill-downs")
+	cmd.Flags().Float64Var(&expandMinPct, "expand-min-pct", 1.0, "Hide drill-down nodes below this %")
+	cmd.Flags().IntVar(&expandMaxLines, "expand-max-lines", 150, "Stop drill-down output after N lines in total (0=unlimited)")
 	cmd.Flags().IntVar(&topThreads, "top-threads", 10, "Threads shown (0=all)")
 	cmd.Flags().IntVar(&topMethods, "top-methods", 20, "Hot methods shown (0=all)")
 	return cmd
@@ -46,18 +81,55 @@ type infoOpts struct {
 	hasMetadata   bool
 	eventCounts   map[string]int
 	expand        int
+	expandDepth   int
+	expandMinPct  float64
+	expandLines   int // total drill-down lines; 0 = unlimited
 	topThreads    int
 	topMethods    int
 	spanNanos     int64
+	originNanos   int64 // recording start (epoch ns); 0 = unknown
 	stacksByEvent map[string]*stackFile
+	settings      map[string]string // async-profiler settings; nil for non-JFR input
+	truncation    stackTruncation
+	cpuLimit      cpuLimit // zero when unknown
+	cpuWarning    string   // cpu samples exceed cpuLimit; "" = none
 }
 
 func cmdInfo(sf *stackFile, opts infoOpts) {
 	// === Header ===
+	header := true
 	if opts.spanNanos > 0 {
-		fmt.Printf("Duration: %s  Samples: %d (%s)\n\n", formatDuration(opts.spanNanos), sf.totalSamples, opts.eventType)
+		fmt.Printf("Duration: %s  Samples: %d (%s)\n", formatDuration(opts.spanNanos), sf.totalSamples, opts.eventType)
+		if opts.originNanos > 0 {
+			fmt.Printf("Recorded: %s\n", formatRecordingRange(opts.originNanos, opts.spanNanos))
+		}
 	} else if opts.hasMetadata && len(opts.eventCounts) > 0 {
-		fmt.Printf("Event: %s\n\n", opts.eventType)
+		fmt.Printf("Event: %s\n", opts.eventType)
+	} else {
+		header = false
+	}
+	if lines := formatProfilerSettings(opts.settings); len(lines) > 0 {
+		fmt.Println(strings.Join(lines, "\n"))
+		header = true
+	}
+	if opts.eventType == "cpu" && opts.cpuLimit.cpus > 0 {
+		fmt.Printf("CPUs: %s\n", opts.cpuLimit.describe())
+		header = true
+	}
+	if opts.eventType == "cpu" && opts.cpuWarning != "" {
+		fmt.Printf("Warning: %s\n", opts.cpuWarning)
+		header = true
+	}
+	if opts.truncation.samples > 0 {
+		event := ""
+		if opts.hasMetadata {
+			event = opts.eventType
+		}
+		fmt.Printf("Warning: %s; %s\n", opts.truncation.describe(event), truncationAdvice(opts.settings["jstackdepth"]))
+		header = true
+	}
+	if header {
+		fmt.Println()
 	}
 
 	// === CPU vs WALL ===
@@ -70,7 +142,7 @@ func cmdInfo(sf *stackFile, opts infoOpts) {
 		fmt.Printf("=== THREADS (top %d) ===\n", len(shown))
 		for _, e := range shown {
 			pct := pctOf(e.samples, sf.totalSamples)
-			fmt.Printf("%-30s %9d %6.1f%%\n", e.name, e.samples, pct)
+			fmt.Printf("%-30s %9d %6.*f%%\n", e.name, e.samples, pctDigits, pct)
 		}
 		fmt.Println()
 	}
@@ -85,25 +157,34 @@ func cmdInfo(sf *stackFile, opts infoOpts) {
 
 	// === DRILL-DOWN ===
 	if opts.expand > 0 && len(hot) > 0 {
+		w := &lineLimitWriter{w: os.Stdout, left: opts.expandLines}
+		if opts.expandLines == 0 {
+			w.left = -1
+		}
 		drillDown := hot[:truncate(len(hot), opts.expand)]
 		for _, h := range drillDown {
+			m := substringMatcher(h.name)
 			sp := pctOf(h.selfCount, sf.totalSamples)
-			fmt.Printf("\n=== DRILL-DOWN: %s (self=%.1f%%) ===\n", h.name, sp)
+			fmt.Fprintf(w, "\n=== DRILL-DOWN: %s (self=%.*f%%) ===\n", h.name, pctDigits, sp)
 
-			fmt.Println("--- tree (callees) ---")
-			cmdTree(sf, h.name, 3, 1.0)
+			fmt.Fprintln(w, "--- tree (callees) ---")
+			buildTreePT(sf, m).fprintTree(w, sf, treeDisplayMethod(m.pattern), opts.expandDepth, opts.expandMinPct, true)
 
-			fmt.Println("--- callers ---")
-			cmdCallers(sf, h.name, 3, 1.0)
+			fmt.Fprintln(w, "--- callers ---")
+			buildCallersPT(sf, m).fprintTree(w, sf, m.pattern, opts.expandDepth, opts.expandMinPct, false)
 
-			lines, _ := computeLines(sf, h.name, 5, false)
+			lines, _ := computeLines(sf, m, 5, false)
 			if len(lines) > 0 {
-				fmt.Println("--- lines ---")
+				fmt.Fprintln(w, "--- lines ---")
 				for _, le := range lines {
 					pct := pctOf(le.samples, sf.totalSamples)
-					fmt.Printf("%s:%-8d %8d %6.1f%%\n", le.name, le.line, le.samples, pct)
+					fmt.Fprintf(w, "%s:%-8d %8d %6.*f%%\n", le.name, le.line, le.samples, pctDigits, pct)
 				}
 			}
+			if w.cut {
+				fmt.Printf("... drill-down stopped after %d lines (--expand-max-lines; 0 shows all)\n", opts.expandLines)
+				break
+			}
 		}
 	}
 
@@ -114,6 +195,143 @@ func cmdInfo(sf *stackFile, opts infoOpts) {
 			fmt.Printf("\nAlso available: %s\n", strings.Join(others, ", "))
 		}
 	}
+
+	// === VERDICT ===
+	if verdict := infoVerdict(sf, opts, hot); len(verdict) > 0 {
+		fmt.Println("\n=== VERDICT ===")
+		for _, v := range verdict {
+			fmt.Printf("- %s\n", v)
+		}
+	}
+}
+
+// lineLimitWriter passes through the first left lines (all when left is
+// negative) and drops the rest, recording that it did.
+type lineLimitWriter struct {
+	w    io.Writer
+	left int
+	cut  bool
+}
+
+func (l *lineLimitWriter) Write(p []byte) (int, error) {
+	if l.left < 0 {
+		return l.w.Write(p)
+	}
+	n := 0
+	for n < len(p) && l.left > 0 {
+		i := bytes.IndexByte(p[n:], '\n')
+		if i < 0 {
+			n = len(p)
+			break
+		}
+		n += i + 1
+		l.left--
+	}
+	if n < len(p) {
+		l.cut = true
+	}
+	if _, err := l.w.Write(p[:n]); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Defaults async-profiler applies when a setting is recorded as 0.
+const (
+	defaultWallInterval  = 50_000_000 // 50ms
+	defaultAllocInterval = 512 << 10  // bytes
+	defaultStackDepth    = 2048
+)
+
+// formatProfilerSettings describes the recorded async-profiler configuration:
+// version and engine, sampling intervals of the enabled events, and the
+// stack depth limit, with a note when the limit truncates stacks early.
+func formatProfilerSettings(settings map[string]string) []string {
+	if len(settings) == 0 {
+		return nil
+	}
+	var lines []string
+	if v := settings["version"]; v != "" {
+		line := "Profiler: async-profiler " + v
+		var opts []string
+		if e := settings["engine"]; e != "" {
+			opts = append(opts, "engine "+e)
+		}
+		if c := settings["cstack"]; c != "" {
+			opts = append(opts, "cstack "+c)
+		}
+		if len(opts) > 0 {
+			line += " (" + strings.Join(opts, ", ") + ")"
+		}
+		lines = append(lines, line)
+	}
+
+	var parts []string
+	event := normalizeExecEvent(settings["event"])
+	if v, ok := settings["interval"]; ok && event != "" {
+		parts = append(parts, event+" interval "+formatSamplingInterval(event, v))
+	}
+	if v, ok := settings["wall"]; ok && event != "wall" {
+		parts = append(parts, "wall interval "+formatSamplingInterval("wall", v))
+	}
+	if v, ok := settings["alloc"]; ok {
+		part := "alloc interval "
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n <= 0 {
+			part += formatBytes(defaultAllocInterval) + " (default)"
+		} else if err == nil {
+			part += formatBytes(n)
+		} else {
+			part += v
+		}
+		if settings["live"] == "true" {
+			part += " live"
+		}
+		parts = append(parts, part)
+	}
+	if v, ok := settings["lock"]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			v = time.Duration(n).String()
+		}
+		parts = append(parts, "lock threshold "+v)
+	}
+	depth := -1
+	if v, ok := settings["jstackdepth"]; ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			depth = n
+		}
+		parts = append(parts, "stack depth "+v)
+	}
+	if len(parts) > 0 {
+		lines = append(lines, "Settings: "+strings.Join(parts, ", "))
+	}
+	if depth > 0 && depth < defaultStackDepth {
+		lines = append(lines, fmt.Sprintf("Note: stack depth limit %d is below the default %d; deeper stacks are cut off at the root (asprof -j to raise it)", depth, defaultStackDepth))
+	}
+	return lines
+}
+
+// formatSamplingInterval renders a raw interval setting: nanoseconds for
+// time-based events, an event count for hardware counters. 0 means the
+// engine's default.
+func formatSamplingInterval(event, raw string) string {
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return raw
+	}
+	timed := event == "cpu" || event == "wall" || event == "itimer" || event == "ctimer"
+	if n <= 0 {
+		switch event {
+		case "cpu", "itimer", "ctimer":
+			return time.Duration(defaultCPUInterval).String() + " (default)"
+		case "wall":
+			return time.Duration(defaultWallInterval).String() + " (default)"
+		}
+		return "default"
+	}
+	if timed {
+		return time.Duration(n).String()
+	}
+	return fmt.Sprintf("%d events", n)
 }
 
 func printCrossEventSummary(stacksByEvent map[string]*stackFile, topGroups int) {
@@ -184,7 +402,7 @@ func printCrossEventSummary(stacksByEvent map[string]*stackFile, topGroups int)
 	fmt.Printf("%-30s %7s %7s\n", "GROUP (threads)", "CPU%", "WALL%")
 	for _, r := range shown {
 		label := fmt.Sprintf("%s (%d)", r.name, r.threads)
-		fmt.Printf("%-30s %6.1f%% %6.1f%%\n", label, r.cpuPct, r.wallPct)
+		fmt.Printf("%-30s %6.*f%% %6.*f%%\n", label, pctDigits, r.cpuPct, pctDigits, r.wallPct)
 	}
 	fmt.Println()
 }
@@ -28,8 +28,8 @@ func newInitCmd() *cobra.Command {
 		Use:   "init",
 		Short: "Install agent skill for JFR profiling analysis",
 		Args:  cobra.NoArgs,
-		Run: func(cmd *cobra.Command, args []string) {
-			cmdInit(opts)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdInit(opts)
 		},
 	}
 	cmd.Flags().StringVar(&opts.asprof, "asprof", "", "Path to asprof binary")
@@ -73,17 +73,15 @@ type initOpts struct {
 	stdout  bool
 }
 
-func cmdInit(opts initOpts) {
+func cmdInit(opts initOpts) error {
 	// Resolve ap-query's own path
 	exe, err := os.Executable()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: cannot determine ap-query path: %v\n", err)
-		os.Exit(1)
+		return ioErrorf("cannot determine ap-query path: %v", err)
 	}
 	apQueryPath, err := filepath.EvalSymlinks(exe)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: cannot resolve ap-query path: %v\n", err)
-		os.Exit(1)
+		return ioErrorf("cannot resolve ap-query path: %v", err)
 	}
 
 	// Find asprof: explicit flag > PATH/common dirs > ask user (path or download)
@@ -91,15 +89,17 @@ func cmdInit(opts initOpts) {
 	if asprofPath != "" {
 		asprofPath = expandPath(asprofPath)
 		if _, err := os.Stat(asprofPath); err != nil {
-			fmt.Fprintf(os.Stderr, "error: asprof not found at %s\n", asprofPath)
-			os.Exit(1)
+			return usageErrorf("asprof not found at %s", asprofPath)
 		}
 	} else {
 		asprofPath = findAsprof()
 		if asprofPath != "" {
 			fmt.Fprintf(os.Stderr, "Found asprof: %s\n", asprofPath)
 		} else {
-			asprofPath = promptOrDownloadAsprof(opts.stdout)
+			asprofPath, err = promptOrDownloadAsprof(opts.stdout)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -110,7 +110,7 @@ func cmdInit(opts initOpts) {
 	// --stdout: dump and exit
 	if opts.stdout {
 		fmt.Print(content)
-		return
+		return nil
 	}
 
 	// Determine base directory
@@ -118,36 +118,35 @@ func cmdInit(opts initOpts) {
 	if opts.project {
 		baseDir, err = os.Getwd()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: cannot determine working directory: %v\n", err)
-			os.Exit(1)
+			return ioErrorf("cannot determine working directory: %v", err)
 		}
 	} else {
 		baseDir, err = os.UserHomeDir()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: cannot determine home directory: %v\n", err)
-			os.Exit(1)
+			return ioErrorf("cannot determine home directory: %v", err)
 		}
 	}
 
 	// Determine which agents to target
 	targets := resolveTargets(baseDir, opts.claude, opts.codex, opts.project)
 	if len(targets) == 0 {
+		dirs := ".claude nor .codex"
 		if opts.project {
-			fmt.Fprintln(os.Stderr, "error: no agent configuration found (neither .claude nor .agents exists)")
-		} else {
-			fmt.Fprintln(os.Stderr, "error: no agent configuration found (neither .claude nor .codex exists)")
+			dirs = ".claude nor .agents"
 		}
-		fmt.Fprintln(os.Stderr, "  use --claude or --codex to create one explicitly")
-		os.Exit(1)
+		return usageErrorf("no agent configuration found (neither %s exists)\n  use --claude or --codex to create one explicitly", dirs)
 	}
 
 	// Write skill file for each target
 	for _, t := range targets {
-		writeSkill(baseDir, t, content, opts.force, opts.project)
+		if err := writeSkill(baseDir, t, content, opts.force, opts.project); err != nil {
+			return err
+		}
 	}
 
 	fmt.Fprintf(os.Stderr, "  ap-query: %s\n", apQueryPath)
 	fmt.Fprintf(os.Stderr, "  asprof:   %s\n", asprofPath)
+	return nil
 }
 
 // resolveTargets decides which agent directories to install to.
@@ -177,22 +176,20 @@ func resolveTargets(baseDir string, claude, codex, project bool) []string {
 	return targets
 }
 
-func writeSkill(baseDir, agent, content string, force, project bool) {
+func writeSkill(baseDir, agent, content string, force, project bool) error {
 	dir := skillDir(agent, baseDir, project)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "error: cannot create directory %s: %v\n", dir, err)
-		os.Exit(1)
+		return ioErrorf("cannot create directory %s: %v", dir, err)
 	}
 	skillPath := filepath.Join(dir, "SKILL.md")
 	if _, err := os.Stat(skillPath); err == nil && !force {
-		fmt.Fprintf(os.Stderr, "error: %s already exists (use --force to overwrite)\n", skillPath)
-		os.Exit(1)
+		return usageErrorf("%s already exists (use --force to overwrite)", skillPath)
 	}
 	if err := os.WriteFile(skillPath, []byte(content), 0644); err != nil {
-		fmt.Fprintf(os.Stderr, "error: cannot write %s: %v\n", skillPath, err)
-		os.Exit(1)
+		return ioErrorf("cannot write %s: %v", skillPath, err)
 	}
 	fmt.Fprintf(os.Stderr, "Skill installed: %s\n", skillPath)
+	return nil
 }
 
 func findAsprof() string {
@@ -236,18 +233,16 @@ func asprofSearchDirs() []string {
 
 // promptOrDownloadAsprof asks the user to provide a path or download automatically.
 // In non-interactive mode (stdout), it downloads directly.
-func promptOrDownloadAsprof(nonInteractive bool) string {
+func promptOrDownloadAsprof(nonInteractive bool) (string, error) {
 	if nonInteractive {
 		// --stdout mode: no prompt, just download
 		fmt.Fprintln(os.Stderr, "asprof not found, downloading async-profiler...")
 		p, err := downloadAsprof()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: %v\n", err)
-			fmt.Fprintln(os.Stderr, "  install async-profiler manually and use --asprof PATH")
-			os.Exit(1)
+			return "", ioErrorf("%v\n  install async-profiler manually and use --asprof PATH", err)
 		}
 		fmt.Fprintf(os.Stderr, "Installed asprof: %s\n", p)
-		return p
+		return p, nil
 	}
 
 	fmt.Fprintln(os.Stderr, "asprof not found.")
@@ -258,10 +253,9 @@ func promptOrDownloadAsprof(nonInteractive bool) string {
 		if p != "" {
 			p = expandPath(p)
 			if _, err := os.Stat(p); err != nil {
-				fmt.Fprintf(os.Stderr, "error: asprof not found at %s\n", p)
-				os.Exit(1)
+				return "", usageErrorf("asprof not found at %s", p)
 			}
-			return p
+			return p, nil
 		}
 	}
 
@@ -269,12 +263,10 @@ func promptOrDownloadAsprof(nonInteractive bool) string {
 	fmt.Fprintln(os.Stderr, "Downloading async-profiler...")
 	result, err := downloadAsprof()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		fmt.Fprintln(os.Stderr, "  install async-profiler manually and use --asprof PATH")
-		os.Exit(1)
+		return "", ioErrorf("%v\n  install async-profiler manually and use --asprof PATH", err)
 	}
 	fmt.Fprintf(os.Stderr, "Installed asprof: %s\n", result)
-	return result
+	return result, nil
 }
 
 // downloadAsprof fetches the latest async-profiler release and extracts it to ~/.ap-query/.
@@ -295,19 +287,9 @@ func downloadAsprof() (string, error) {
 	// Build download URL
 	url, isTarGz := asprofDownloadURL(tag, ver)
 
-	// Download
-	client := &http.Client{Timeout: 60 * time.Second}
-	resp, err := client.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("downloading %s: %v", url, err)
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("downloading %s: HTTP %d", url, resp.StatusCode)
-	}
-	data, err := io.ReadAll(resp.Body)
+	data, err := downloadRelease(url)
 	if err != nil {
-		return "", fmt.Errorf("reading download: %v", err)
+		return "", err
 	}
 
 	// Extract to ~/.ap-query/
@@ -328,39 +310,91 @@ func downloadAsprof() (string, error) {
 	return asprofPath, nil
 }
 
+// downloadRelease fetches a release archive.
+func downloadRelease(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("downloading %s: HTTP %d", url, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading download: %v", err)
+	}
+	return data, nil
+}
+
 func asprofLatestTag() (string, error) {
+	release, err := asprofLatestRelease()
+	if err != nil {
+		return "", err
+	}
+	return release.TagName, nil
+}
+
+// asprofRelease is the part of a GitHub release that ap-query reads.
+type asprofRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name   string `json:"name"`
+		Digest string `json:"digest"` // "sha256:<hex>"
+	} `json:"assets"`
+}
+
+// sha256 returns the published SHA-256 of the named asset, or "" when the
+// release lists none.
+func (r *asprofRelease) sha256(name string) string {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			if hash, ok := strings.CutPrefix(a.Digest, "sha256:"); ok {
+				return hash
+			}
+		}
+	}
+	return ""
+}
+
+func asprofLatestRelease() (*asprofRelease, error) {
 	client := &http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Get("https://api.github.com/repos/async-profiler/async-profiler/releases/latest")
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("GitHub API returned HTTP %d", resp.StatusCode)
-	}
-	var release struct {
-		TagName string `json:"tag_name"`
+		return nil, fmt.Errorf("GitHub API returned HTTP %d", resp.StatusCode)
 	}
+	var release asprofRelease
 	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
-		return "", err
+		return nil, err
 	}
 	if release.TagName == "" {
-		return "", fmt.Errorf("empty tag in GitHub response")
+		return nil, fmt.Errorf("empty tag in GitHub response")
 	}
-	return release.TagName, nil
+	return &release, nil
 }
 
 // asprofDownloadURL returns the download URL and whether it's a tar.gz (vs zip).
 func asprofDownloadURL(tag, ver string) (string, bool) {
-	base := "https://github.com/async-profiler/async-profiler/releases/download/" + tag + "/"
 	if runtime.GOOS == "darwin" {
-		return base + "async-profiler-" + ver + "-macos.zip", false
+		return "https://github.com/async-profiler/async-profiler/releases/download/" + tag +
+			"/async-profiler-" + ver + "-macos.zip", false
 	}
 	arch := "x64"
 	if runtime.GOARCH == "arm64" {
 		arch = "arm64"
 	}
-	return base + "async-profiler-" + ver + "-linux-" + arch + ".tar.gz", true
+	return asprofLinuxURL(tag, ver, arch), true
+}
+
+// asprofLinuxURL returns the Linux tar.gz URL for arch (x64 or arm64).
+func asprofLinuxURL(tag, ver, arch string) string {
+	return "https://github.com/async-profiler/async-profiler/releases/download/" + tag +
+		"/async-profiler-" + ver + "-linux-" + arch + ".tar.gz"
 }
 
 // extractTarGz extracts a tar.gz archive into destDir, flattening the top-level directory.
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/grafana/jfr-parser/parser/types"
+	"github.com/spf13/cobra"
+)
+
+func newInspectCmd() *cobra.Command {
+	var shared sharedFlags
+	var mf methodFlags
+	cmd := &cobra.Command{
+		Use:   "inspect <file>",
+		Short: "One method across all events: cpu, wall, allocated bytes, lock time (-m required)",
+		Long: `Inspect reports a method's presence in every event of a JFR recording in
+one table: self and total share of cpu, wall, alloc and lock samples, plus
+the bytes allocated and the lock wait time in stacks through the method.
+Use it instead of running hot or tree once per --event.`,
+		Example: strings.Join([]string{
+			"  ap-query inspect profile.jfr -m HashMap.resize",
+			"  ap-query inspect profile.jfr -m OrderService.place --thread http-nio --from 10s",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if mf.method == "" {
+				return fmt.Errorf("-m/--method required")
+			}
+			if shared.event != "" {
+				return fmt.Errorf("inspect reports every event; --event does not apply")
+			}
+			if detectFormat(args[0]) != formatJFR {
+				return fmt.Errorf("inspect requires a JFR file (allocation sizes and lock durations)")
+			}
+			window, err := parseDurationWindow("--from", shared.from, "--to", shared.to)
+			if err != nil {
+				return err
+			}
+			rep, err := parseInspect(args[0], mf.matcher(), shared.thread, shared.noIdle, window.fromNanos, window.toNanos)
+			if err != nil {
+				return err
+			}
+			cmdInspect(rep)
+			return nil
+		},
+	}
+	shared.register(cmd)
+	cmd.Flags().StringVarP(&mf.method, "method", "m", "", "Substring match on method name (required)")
+	mf.registerModes(cmd)
+	return cmd
+}
+
+// inspectEvent is one event's totals and the share attributed to the method.
+type inspectEvent struct {
+	samples int // all samples of the event
+	self    int // samples with the method at the leaf
+	total   int // samples with the method anywhere on the stack
+	// amount is allocated bytes (alloc), retained bytes (live) or wait
+	// nanoseconds (lock); the
+	// *Amount fields are the parts attributed to the method.
+	amount      int64
+	totalAmount int64
+}
+
+type inspectReport struct {
+	method  string
+	matched map[string]int // matched frame → total samples over all events
+	events  map[string]*inspectEvent
+	frames  *stackFile // one stack per distinct trace, for suggestions
+}
+
+// parseInspect scans a JFR recording once, attributing every event to the
+// methods matched by m. thread is a substring filter; fromNanos/toNanos
+// bound event start times (-1 = open).
+func parseInspect(path string, m methodMatcher, thread string, noIdle bool, fromNanos, toNanos int64) (*inspectReport, error) {
+	buf, err := readJFRBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	originNanos, _, err := scanChunkHeaders(buf)
+	if err != nil {
+		return nil, parseErrorf("%v", err)
+	}
+
+	rep := &inspectReport{
+		method:  m.pattern,
+		matched: make(map[string]int),
+		events:  make(map[string]*inspectEvent),
+	}
+	stackCache := make(map[types.StackTraceRef]*cachedStackTrace)
+	matchCache := make(map[string]bool)
+	execEventName := "cpu"
+	p, err := newJFRParser(buf)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		typ, err := p.ParseEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, parseErrorf("parse event: %w", err)
+		}
+		if typ == p.TypeMap.T_ACTIVE_SETTING {
+			if p.ActiveSetting.Name == "event" {
+				execEventName = normalizeExecEvent(p.ActiveSetting.Value)
+			}
+			continue
+		}
+		info, ok := classifyEvent(p, typ, execEventName)
+		if !ok {
+			continue
+		}
+		hdr := p.ChunkHeader()
+		if fromNanos >= 0 || toNanos >= 0 {
+			offset := ticksToNanos(info.startTicks, hdr.StartTicks, hdr.StartNanos, uint64(originNanos), hdr.TicksPerSecond)
+			if (fromNanos >= 0 && offset < fromNanos) || (toNanos >= 0 && offset >= toNanos) {
+				continue
+			}
+		}
+		if thread != "" && !strings.Contains(resolveThread(p, info.thRef), thread) {
+			continue
+		}
+		cached := resolveStackTraceCached(p, stackCache, nil, info.stRef)
+		if len(cached.frames) == 0 || (noIdle && isIdleStack(cached.frames)) {
+			continue
+		}
+
+		var amount int64
+		switch typ {
+		case p.TypeMap.T_ALLOC_IN_NEW_TLAB:
+			amount = int64(p.ObjectAllocationInNewTLAB.TlabSize)
+		case p.TypeMap.T_ALLOC_OUTSIDE_TLAB:
+			amount = int64(p.ObjectAllocationOutsideTLAB.AllocationSize)
+		case p.TypeMap.T_ALLOC_SAMPLE:
+			amount = int64(p.ObjectAllocationSample.Weight)
+		case p.TypeMap.T_LIVE_OBJECT:
+			amount = int64(p.LiveObject.AllocationSize)
+		case p.TypeMap.T_MONITOR_ENTER:
+			amount = ticksDurationNanos(info.durTicks, hdr.TicksPerSecond)
+		}
+
+		ev := rep.events[info.eventType]
+		if ev == nil {
+			ev = &inspectEvent{}
+			rep.events[info.eventType] = ev
+		}
+		ev.samples += info.weight
+		ev.totalAmount += amount
+		onStack := false
+		for i, fr := range cached.frames {
+			hit, ok := matchCache[fr]
+			if !ok {
+				hit = m.matches(fr)
+				matchCache[fr] = hit
+			}
+			if !hit {
+				continue
+			}
+			rep.matched[fr] += info.weight
+			if !onStack {
+				onStack = true
+				ev.total += info.weight
+				ev.amount += amount
+			}
+			if i == len(cached.frames)-1 {
+				ev.self += info.weight
+			}
+		}
+	}
+
+	rep.frames = &stackFile{}
+	for _, c := range stackCache {
+		rep.frames.stacks = append(rep.frames.stacks, stack{frames: c.frames, count: 1})
+	}
+	return rep, nil
+}
+
+func cmdInspect(rep *inspectReport) {
+	if len(rep.matched) == 0 {
+		noMatchMessage(os.Stdout, rep.frames, rep.method)
+		return
+	}
+
+	names := make([]string, 0, len(rep.matched))
+	for fr := range rep.matched {
+		names = append(names, fr)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if rep.matched[names[i]] != rep.matched[names[j]] {
+			return rep.matched[names[i]] > rep.matched[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) == 1 {
+		fmt.Printf("Method: %s\n", names[0])
+	} else {
+		fmt.Printf("Methods matching '%s' (%d):\n", rep.method, len(names))
+		for _, n := range names[:truncate(len(names), 5)] {
+			fmt.Printf("  %s\n", n)
+		}
+		if len(names) > 5 {
+			fmt.Printf("  … %d more (use --exact or a longer pattern)\n", len(names)-5)
+		}
+	}
+	fmt.Println()
+
+	events := make([]string, 0, len(rep.events))
+	for e := range rep.events {
+		events = append(events, e)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		ri, rj := eventRank(events[i]), eventRank(events[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return events[i] < events[j]
+	})
+	fmt.Printf("%-14s %7s %7s %9s %9s  %s\n", "EVENT", "SELF%", "TOTAL%", "SELF", "TOTAL", "BELOW")
+	for _, e := range events {
+		ev := rep.events[e]
+		below := ""
+		switch e {
+		case "alloc":
+			below = fmt.Sprintf("%s allocated (%.*f%% of %s)", formatBytes(ev.amount), pctDigits, pctOf(int(ev.amount), int(ev.totalAmount)), formatBytes(ev.totalAmount))
+		case "live":
+			below = fmt.Sprintf("%s live (%.*f%% of %s)", formatBytes(ev.amount), pctDigits, pctOf(int(ev.amount), int(ev.totalAmount)), formatBytes(ev.totalAmount))
+		case "lock":
+			below = fmt.Sprintf("%s waited (%.*f%% of %s)", formatDuration(ev.amount), pctDigits, pctOf(int(ev.amount), int(ev.totalAmount)), formatDuration(ev.totalAmount))
+		}
+		line := fmt.Sprintf("%-14s %6.*f%% %6.*f%% %9d %9d  %s", e,
+			pctDigits, pctOf(ev.self, ev.samples), pctDigits, pctOf(ev.total, ev.samples), ev.self, ev.total, below)
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+}
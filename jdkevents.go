@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/grafana/jfr-parser/parser"
+	"github.com/grafana/jfr-parser/parser/types"
+	"github.com/grafana/jfr-parser/parser/types/def"
+)
+
+// jdkEvent is one JFR event decoded generically from the chunk metadata,
+// for the JDK events the parser does not know (safepoints, class loading,
+// GC summaries, ...).
+type jdkEvent struct {
+	name       string
+	startNanos int64 // offset from the recording start
+	durNanos   int64
+	// nums holds integer and boolean fields and constant pool ids; nested
+	// fields are named "parent.child". strs holds inline strings and
+	// constant pool references that resolve to a single string.
+	nums   map[string]uint64
+	strs   map[string]string
+	floats map[string]float64
+}
+
+// scanJDKEvents calls fn for every event whose type is in names, in file
+// order. The chunk's parser is passed along so pool references the parser
+// does resolve (threads, classes, stack traces) can be looked up.
+func scanJDKEvents(buf []byte, names []string, fn func(p *parser.Parser, ev *jdkEvent)) error {
+	originNanos, _, err := scanChunkHeaders(buf)
+	if err != nil {
+		return parseErrorf("%v", err)
+	}
+	wantNames := make(map[string]bool, len(names))
+	for _, n := range names {
+		wantNames[n] = true
+	}
+
+	for pos := 0; pos < len(buf); {
+		if pos+jfrChunkHeaderSize > len(buf) || binary.BigEndian.Uint32(buf[pos:]) != jfrChunkMagic {
+			return parseErrorf("invalid JFR chunk header at offset %d", pos)
+		}
+		size := int(binary.BigEndian.Uint64(buf[pos+8:]))
+		if size <= jfrChunkHeaderSize || size > len(buf)-pos {
+			return parseErrorf("invalid JFR chunk size %d at offset %d", size, pos)
+		}
+		chunk := buf[pos : pos+size]
+		pos += size
+
+		// The first ParseEvent loads the chunk's metadata and constant pools.
+		p, err := newJFRParser(chunk)
+		if err != nil {
+			return err
+		}
+		if _, err := p.ParseEvent(); err != nil && err != io.EOF {
+			return parseErrorf("parse event: %v", err)
+		}
+		wanted := make(map[uint64]*def.Class)
+		for id, c := range p.TypeMap.IDMap {
+			if wantNames[c.Name] {
+				wanted[uint64(id)] = c
+			}
+		}
+		if len(wanted) == 0 {
+			continue
+		}
+
+		hdr := p.ChunkHeader()
+		r := &jfrPoolReader{buf: chunk, tm: &p.TypeMap}
+		pools, err := r.readStringPools(hdr.OffsetConstantPool, p)
+		if err != nil {
+			return parseErrorf("constant pools: %v", err)
+		}
+		events, err := rawJFREvents(chunk, jfrChunkHeaderSize, size)
+		if err != nil {
+			return err
+		}
+		for _, raw := range events {
+			c := wanted[raw.typ]
+			if c == nil {
+				continue
+			}
+			r.pos = raw.start
+			if _, err := r.varLong(); err != nil { // size
+				return parseErrorf("%s: %v", c.Name, err)
+			}
+			if _, err := r.varLong(); err != nil { // type
+				return parseErrorf("%s: %v", c.Name, err)
+			}
+			ev := &jdkEvent{
+				name:   c.Name,
+				nums:   make(map[string]uint64),
+				strs:   make(map[string]string),
+				floats: make(map[string]float64),
+			}
+			if err := r.readRecord(c, "", pools, ev); err != nil {
+				return parseErrorf("%s: %v", c.Name, err)
+			}
+			ev.startNanos = ticksToNanos(ev.nums["startTime"], hdr.StartTicks, hdr.StartNanos, uint64(originNanos), hdr.TicksPerSecond)
+			ev.durNanos = ticksDurationNanos(ev.nums["duration"], hdr.TicksPerSecond)
+			fn(p, ev)
+		}
+	}
+	return nil
+}
+
+// stringPools maps a constant pool class and id to its string value, for
+// pools of strings, of types with a single string field (for example
+// jdk.types.VMOperationType or jdk.types.GCName) and of class loaders
+// (their name, or their class when unnamed).
+type stringPools map[def.TypeID]map[uint64]string
+
+// readStringPools walks the checkpoint chain starting at the chunk offset
+// pos and collects the string-valued constant pools. p resolves the
+// symbols and classes class loaders refer to.
+func (r *jfrPoolReader) readStringPools(pos int, p *parser.Parser) (stringPools, error) {
+	pools := make(stringPools)
+	for {
+		delta, err := r.readCheckpoint(pos, func(c *def.Class) error {
+			if c.Name == "jdk.types.ClassLoader" {
+				return r.readClassLoaders(c, p, pools)
+			}
+			if c.Name != "java.lang.String" && !isStringWrapper(r.tm, c) {
+				return r.skipPool(c)
+			}
+			n, err := r.varLong()
+			if err != nil {
+				return err
+			}
+			pool := pools[c.ID]
+			if pool == nil {
+				pool = make(map[uint64]string, n)
+				pools[c.ID] = pool
+			}
+			for i := uint64(0); i < n; i++ {
+				id, err := r.varLong()
+				if err != nil {
+					return err
+				}
+				s, err := r.readString()
+				if err != nil {
+					return err
+				}
+				pool[id] = s
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if delta == 0 {
+			return pools, nil
+		}
+		pos += delta
+		if pos <= 0 {
+			return pools, nil
+		}
+	}
+}
+
+func (r *jfrPoolReader) readClassLoaders(c *def.Class, p *parser.Parser, pools stringPools) error {
+	n, err := r.varLong()
+	if err != nil {
+		return err
+	}
+	pool := pools[c.ID]
+	if pool == nil {
+		pool = make(map[uint64]string, n)
+		pools[c.ID] = pool
+	}
+	for i := uint64(0); i < n; i++ {
+		id, err := r.varLong()
+		if err != nil {
+			return err
+		}
+		rec := &jdkEvent{nums: make(map[string]uint64), strs: make(map[string]string), floats: make(map[string]float64)}
+		if err := r.readRecord(c, "", nil, rec); err != nil {
+			return err
+		}
+		name := p.GetSymbolString(types.SymbolRef(rec.nums["name"]))
+		if name == "" {
+			name = jfrClassName(p, rec.nums["type"])
+		}
+		pool[id] = name
+	}
+	return nil
+}
+
+// jfrClassName returns the dotted name of the class constant ref, or "".
+func jfrClassName(p *parser.Parser, ref uint64) string {
+	class := p.GetClass(types.ClassRef(ref))
+	if class == nil {
+		return ""
+	}
+	return strings.ReplaceAll(p.GetSymbolString(class.Name), "/", ".")
+}
+
+// isStringWrapper reports whether c has exactly one field, an inline string.
+func isStringWrapper(tm *def.TypeMap, c *def.Class) bool {
+	if len(c.Fields) != 1 {
+		return false
+	}
+	f := c.Fields[0]
+	fc := tm.IDMap[f.Type]
+	return !f.Array && !f.ConstantPool && fc != nil && fc.Name == "java.lang.String"
+}
+
+// readRecord decodes the fields of c into ev, prefixing names with prefix.
+// Arrays are skipped.
+func (r *jfrPoolReader) readRecord(c *def.Class, prefix string, pools stringPools, ev *jdkEvent) error {
+	for fi := range c.Fields {
+		f := &c.Fields[fi]
+		name := prefix + f.Name
+		if f.Array {
+			if err := r.skipField(f); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.ConstantPool {
+			id, err := r.varLong()
+			if err != nil {
+				return err
+			}
+			ev.nums[name] = id
+			if s, ok := pools[f.Type][id]; ok {
+				ev.strs[name] = s
+			}
+			continue
+		}
+		fc := r.tm.IDMap[f.Type]
+		if fc == nil {
+			return fmt.Errorf("unknown type %d", f.Type)
+		}
+		switch fc.Name {
+		case "boolean", "byte":
+			if r.pos >= len(r.buf) {
+				return io.ErrUnexpectedEOF
+			}
+			ev.nums[name] = uint64(r.buf[r.pos])
+			r.pos++
+		case "short", "char", "int", "long":
+			v, err := r.varLong()
+			if err != nil {
+				return err
+			}
+			ev.nums[name] = v
+		case "float":
+			if r.pos+4 > len(r.buf) {
+				return io.ErrUnexpectedEOF
+			}
+			ev.floats[name] = float64(math.Float32frombits(binary.BigEndian.Uint32(r.buf[r.pos:])))
+			r.pos += 4
+		case "double":
+			if r.pos+8 > len(r.buf) {
+				return io.ErrUnexpectedEOF
+			}
+			ev.floats[name] = math.Float64frombits(binary.BigEndian.Uint64(r.buf[r.pos:]))
+			r.pos += 8
+		case "java.lang.String":
+			s, err := r.readString()
+			if err != nil {
+				return err
+			}
+			ev.strs[name] = s
+		default:
+			if err := r.readRecord(fc, name+".", pools, ev); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readString reads an inline string. Constant pool references inside
+// string pools do not occur in practice and read as "".
+func (r *jfrPoolReader) readString() (string, error) {
+	if r.pos >= len(r.buf) {
+		return "", io.ErrUnexpectedEOF
+	}
+	enc := r.buf[r.pos]
+	r.pos++
+	switch enc {
+	case 0, 1: // null, empty
+		return "", nil
+	case 2: // constant pool reference
+		_, err := r.varLong()
+		return "", err
+	case 3: // UTF-8
+		n, err := r.varLong()
+		if err != nil {
+			return "", err
+		}
+		start := r.pos
+		if err := r.skip(int(n)); err != nil {
+			return "", err
+		}
+		return string(r.buf[start:r.pos]), nil
+	case 4: // char array
+		n, err := r.varLong()
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		for i := uint64(0); i < n; i++ {
+			v, err := r.varLong()
+			if err != nil {
+				return "", err
+			}
+			b.WriteRune(rune(v))
+		}
+		return b.String(), nil
+	case 5: // Latin-1
+		n, err := r.varLong()
+		if err != nil {
+			return "", err
+		}
+		start := r.pos
+		if err := r.skip(int(n)); err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		for _, c := range r.buf[start:r.pos] {
+			b.WriteRune(rune(c))
+		}
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("unknown string encoding %d at offset %d", enc, r.pos-1)
+}
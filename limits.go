@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/grafana/jfr-parser/parser"
+	"github.com/grafana/jfr-parser/parser/types/def"
+)
+
+// Bounds on what a profile may make ap-query hold, so that a malformed or
+// adversarial file fails or degrades instead of exhausting memory or
+// hanging (--max-frames, --max-symbol-bytes, --max-events).
+var (
+	maxStackFrames = defaultMaxStackFrames
+	maxSymbolBytes = defaultMaxSymbolBytes
+	maxEvents      = defaultMaxEvents
+)
+
+const (
+	// Well past async-profiler's default jstackdepth of 2048 and deep
+	// recursion; deeper stacks keep their leaf-most frames, as a profiler
+	// truncates them.
+	defaultMaxStackFrames = 65536
+	// A JVM symbol is at most 65535 bytes of modified UTF-8.
+	defaultMaxSymbolBytes = 64 << 10
+	// Large recordings hold hundreds of millions of events.
+	defaultMaxEvents = 2_000_000_000
+)
+
+// symbolEllipsis marks a frame name cut at maxSymbolBytes.
+const symbolEllipsis = "…"
+
+// limitFrames returns the leaf-most maxStackFrames of root-first frames,
+// and whether any were dropped.
+func limitFrames[T any](frames []T) ([]T, bool) {
+	if len(frames) <= maxStackFrames {
+		return frames, false
+	}
+	return frames[len(frames)-maxStackFrames:], true
+}
+
+// limitSymbol cuts a frame name longer than maxSymbolBytes at a rune
+// boundary and marks the cut.
+func limitSymbol(name string) string {
+	if len(name) <= maxSymbolBytes {
+		return name
+	}
+	cut := maxSymbolBytes
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+	return name[:cut] + symbolEllipsis
+}
+
+// checkJFRFraming validates a recording before it reaches the JFR parser,
+// which trusts what it reads: an event size or checkpoint delta pointing
+// the wrong way makes it loop forever, and a count larger than the data
+// makes it allocate for elements that cannot exist. Chunk headers, event
+// sizes and the checkpoint chain are checked, and the metadata, constant
+// pools and events are walked with counts bounded by the bytes left.
+func checkJFRFraming(buf []byte) error {
+	events := 0
+	for pos := 0; pos < len(buf); {
+		if pos+jfrChunkHeaderSize > len(buf) || binary.BigEndian.Uint32(buf[pos:]) != jfrChunkMagic {
+			return parseErrorf("invalid JFR chunk header at offset %d", pos)
+		}
+		size := binary.BigEndian.Uint64(buf[pos+8:])
+		if size <= jfrChunkHeaderSize || size > uint64(len(buf)-pos) {
+			return parseErrorf("invalid JFR chunk size %d at offset %d", size, pos)
+		}
+		chunk := buf[pos : pos+int(size)]
+		n, err := checkJFRChunk(chunk, maxEvents-events)
+		if err != nil {
+			return parseErrorf("JFR chunk at offset %d: %v", pos, err)
+		}
+		events += n
+		pos += len(chunk)
+	}
+	return nil
+}
+
+// checkJFRChunk walks the metadata, the checkpoint chain and the events of
+// one chunk as the parser will read them, and returns the number of
+// events. Offsets are chunk-relative.
+func checkJFRChunk(chunk []byte, maxEvents int) (int, error) {
+	// event returns the bounds of the event of type typ at off.
+	event := func(off uint64, typ uint64) (start, end int, err error) {
+		if off < jfrChunkHeaderSize || off >= uint64(len(chunk)) {
+			return 0, 0, fmt.Errorf("offset %d outside the chunk", off)
+		}
+		size, n := readVarLong(chunk[off:])
+		t, m := readVarLong(chunk[int(off)+n:])
+		if n == 0 || m == 0 || t != typ || size == 0 || size > uint64(len(chunk))-off {
+			return 0, 0, fmt.Errorf("no event of type %d at offset %d", typ, off)
+		}
+		return int(off), int(off + size), nil
+	}
+
+	start, end, err := event(binary.BigEndian.Uint64(chunk[24:]), jfrMetadataEventType)
+	if err != nil {
+		return 0, fmt.Errorf("metadata: %v", err)
+	}
+	tm, err := readJFRMetadata(chunk[:end], start)
+	if err != nil {
+		return 0, fmt.Errorf("metadata: %v", err)
+	}
+
+	// The chain runs backwards from the header's constant pool offset to
+	// a zero delta.
+	r := &jfrPoolReader{tm: tm}
+	for cp := binary.BigEndian.Uint64(chunk[16:]); ; {
+		start, end, err := event(cp, jfrCheckpointEventType)
+		if err != nil {
+			return 0, fmt.Errorf("constant pools: %v", err)
+		}
+		r.buf = chunk[:end]
+		delta, err := r.readCheckpoint(start, r.skipPool)
+		if err != nil {
+			return 0, fmt.Errorf("checkpoint at offset %d: %v", cp, err)
+		}
+		if delta == 0 {
+			break
+		}
+		if delta > 0 || uint64(-delta) > cp {
+			return 0, fmt.Errorf("invalid checkpoint delta %d at offset %d", delta, cp)
+		}
+		cp -= uint64(-delta)
+	}
+
+	events := 0
+	flat := make(map[def.TypeID]bool)
+	for pos := jfrChunkHeaderSize; pos < len(chunk); events++ {
+		if events == maxEvents {
+			return 0, fmt.Errorf("more than %d events; raise --max-events to parse this recording", maxEvents)
+		}
+		size, n := readVarLong(chunk[pos:])
+		if n == 0 || size == 0 || size > uint64(len(chunk)-pos) {
+			return 0, fmt.Errorf("invalid event size at offset %d", pos)
+		}
+		typ, m := readVarLong(chunk[pos+n : pos+int(size)])
+		if m == 0 {
+			return 0, fmt.Errorf("invalid event type at offset %d", pos)
+		}
+		start := pos
+		pos += int(size)
+		c := tm.IDMap[def.TypeID(typ)]
+		if typ == jfrMetadataEventType || typ == jfrCheckpointEventType || c == nil || isFlatClass(tm, c, flat) {
+			continue
+		}
+		r := jfrPoolReader{buf: chunk[start:pos], pos: n + m, tm: tm}
+		if err := r.skipFields(c); err != nil {
+			return 0, fmt.Errorf("%s event at offset %d: %v", c.Name, start, err)
+		}
+	}
+	return events, nil
+}
+
+const jfrMetadataEventType = 0
+
+// isFlatClass reports whether values of c hold only primitives and
+// constant pool references, which the parser reads without allocating, so
+// events of c need no walk. memo holds the classes decided so far; a class
+// reached again while being decided (a recursive type) is not flat.
+func isFlatClass(tm *def.TypeMap, c *def.Class, memo map[def.TypeID]bool) bool {
+	if flat, ok := memo[c.ID]; ok {
+		return flat
+	}
+	switch c.Name {
+	case "boolean", "byte", "char", "short", "int", "long", "float", "double":
+		memo[c.ID] = true
+		return true
+	case "java.lang.String":
+		memo[c.ID] = false
+		return false
+	}
+	memo[c.ID] = false
+	for i := range c.Fields {
+		f := &c.Fields[i]
+		if f.Array {
+			return false
+		}
+		if f.ConstantPool {
+			continue
+		}
+		if fc := tm.IDMap[f.Type]; fc == nil || !isFlatClass(tm, fc, memo) {
+			return false
+		}
+	}
+	memo[c.ID] = true
+	return true
+}
+
+// readJFRMetadata reads the type definitions of the metadata event at pos
+// the way the JFR parser does (a string table, then a root element of
+// metadata and region elements, classes within metadata, fields within
+// classes), checking each count against the bytes left.
+func readJFRMetadata(chunk []byte, pos int) (*def.TypeMap, error) {
+	r := &jfrPoolReader{buf: chunk, pos: pos}
+	for i := 0; i < 5; i++ { // size, type, start, duration, metadata id
+		if _, err := r.varLong(); err != nil {
+			return nil, err
+		}
+	}
+	n, err := r.count()
+	if err != nil {
+		return nil, err
+	}
+	strs := make([]string, n)
+	for i := range strs {
+		if strs[i], err = r.metadataString(); err != nil {
+			return nil, err
+		}
+	}
+	element := func(attrs bool) (jfrMetadataElement, error) {
+		return r.metadataElement(strs, attrs)
+	}
+
+	tm := &def.TypeMap{IDMap: make(map[def.TypeID]*def.Class)}
+	root, err := element(false)
+	if err != nil {
+		return nil, err
+	}
+	if root.name != "root" {
+		return nil, fmt.Errorf("expected root element, got %q", root.name)
+	}
+	for range root.children {
+		e, err := element(false)
+		if err != nil {
+			return nil, err
+		}
+		switch e.name {
+		case "region":
+			continue
+		case "metadata":
+		default:
+			return nil, fmt.Errorf("unexpected element %q", e.name)
+		}
+		for range e.children {
+			ce, err := element(true)
+			if err != nil {
+				return nil, err
+			}
+			c, err := def.NewClass(ce.attrs, ce.children)
+			if err != nil {
+				return nil, err
+			}
+			for range ce.children {
+				fe, err := element(true)
+				if err != nil {
+					return nil, err
+				}
+				if fe.name == "field" {
+					f, err := def.NewField(fe.attrs)
+					if err != nil {
+						return nil, err
+					}
+					c.Fields = append(c.Fields, f)
+				}
+				for range fe.children {
+					if _, err := element(false); err != nil {
+						return nil, err
+					}
+				}
+			}
+			tm.IDMap[c.ID] = c
+		}
+	}
+	return tm, nil
+}
+
+type jfrMetadataElement struct {
+	name     string
+	attrs    map[string]string // nil unless requested
+	children int
+}
+
+func (r *jfrPoolReader) metadataElement(strs []string, attrs bool) (jfrMetadataElement, error) {
+	str := func() (string, error) {
+		i, err := r.varLong()
+		if err == nil && i >= uint64(len(strs)) {
+			err = fmt.Errorf("string index %d out of range at offset %d", i, r.pos)
+		}
+		if err != nil {
+			return "", err
+		}
+		return strs[i], nil
+	}
+	var e jfrMetadataElement
+	var err error
+	if e.name, err = str(); err != nil {
+		return e, err
+	}
+	n, err := r.count()
+	if err != nil {
+		return e, err
+	}
+	if attrs {
+		e.attrs = make(map[string]string, n)
+	}
+	for range n {
+		k, err := str()
+		if err != nil {
+			return e, err
+		}
+		v, err := str()
+		if err != nil {
+			return e, err
+		}
+		if attrs {
+			e.attrs[k] = v
+		}
+	}
+	children, err := r.count()
+	e.children = int(children)
+	return e, err
+}
+
+// metadataString reads a string of the metadata string table, which the
+// JFR parser accepts as null, empty, UTF-8 or a char array.
+func (r *jfrPoolReader) metadataString() (string, error) {
+	if r.pos >= len(r.buf) {
+		return "", fmt.Errorf("unexpected end of data at offset %d", r.pos)
+	}
+	enc := r.buf[r.pos]
+	r.pos++
+	switch enc {
+	case 0, 1: // null, empty
+		return "", nil
+	case 3: // UTF-8
+		n, err := r.count()
+		if err != nil {
+			return "", err
+		}
+		s := string(r.buf[r.pos : r.pos+int(n)])
+		r.pos += int(n)
+		return s, nil
+	case 4: // char array
+		n, err := r.count()
+		if err != nil {
+			return "", err
+		}
+		runes := make([]rune, n)
+		for i := range runes {
+			c, err := r.varLong()
+			if err != nil {
+				return "", err
+			}
+			runes[i] = rune(c)
+		}
+		return string(runes), nil
+	}
+	return "", fmt.Errorf("unknown string encoding %d at offset %d", enc, r.pos-1)
+}
+
+// newJFRParser returns a parser for buf once its framing checks out.
+func newJFRParser(buf []byte) (*parser.Parser, error) {
+	if err := checkJFRFraming(buf); err != nil {
+		return nil, err
+	}
+	return parser.NewParser(buf, parser.Options{}), nil
+}
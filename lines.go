@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"slices"
 	"sort"
 
 	"github.com/spf13/cobra"
@@ -10,28 +11,61 @@ import (
 
 func newLinesCmd() *cobra.Command {
 	var shared sharedFlags
-	var method string
+	var mf methodFlags
 	var top int
 	var fqn bool
+	var bci bool
+	var ranges bool
 	cmd := &cobra.Command{
 		Use:   "lines <file>",
 		Short: "Source-line breakdown inside a method (-m required)",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if method == "" {
+			if mf.method == "" {
 				return fmt.Errorf("-m/--method required")
 			}
-			pctx, err := preprocessProfile(shared.toOpts(args[0], "lines"))
+			if err := mf.validate(); err != nil {
+				return err
+			}
+			if ranges && bci {
+				return fmt.Errorf("--ranges cannot be combined with --bci")
+			}
+			opts := shared.toOpts(args[0], "lines")
+			if bci {
+				opts.frameDetails = "--bci"
+			}
+			pctx, err := preprocessProfile(opts)
+			if err != nil {
+				return err
+			}
+			m, err := mf.resolve(pctx.sf, args[0])
 			if err != nil {
 				return err
 			}
-			return cmdLines(pctx.sf, method, top, fqn)
+			var link func(string, uint32) string
+			if pctx.links != nil {
+				link = pctx.links.lineURLs(pctx.sf, fqn)
+			}
+			if ranges {
+				return cmdLineRanges(pctx.sf, m, top, fqn, link)
+			}
+			var owners map[string]string
+			if pctx.owners != nil {
+				owners = pctx.owners.ownersByName(pctx.sf, fqn)
+			}
+			return cmdLinesOwned(pctx.sf, m, top, fqn, owners, link)
 		},
 	}
 	shared.register(cmd)
-	cmd.Flags().StringVarP(&method, "method", "m", "", "Substring match on method name (required)")
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	shared.registerSourceRoot(cmd)
+	shared.registerRepoURL(cmd)
+	mf.register(cmd, "Substring match on method name (required)")
 	cmd.Flags().IntVar(&top, "top", 0, "Limit output rows (default: unlimited)")
 	cmd.Flags().BoolVar(&fqn, "fqn", false, "Show fully-qualified names")
+	cmd.Flags().BoolVar(&ranges, "ranges", false, "Group consecutive hot lines of a method (a loop body) into one range with their combined samples")
+	cmd.Flags().BoolVar(&bci, "bci", false, "Split lines by bytecode index and show the frame type, e.g. Inlined (JFR only)")
 	return cmd
 }
 
@@ -39,28 +73,36 @@ type lineEntry struct {
 	name    string
 	line    uint32
 	samples int
+	detail  *frameDetail // bytecode index and frame type; nil without --bci
 }
 
-// computeLines returns the per-source-line sample counts for the given method.
-// hasMethod is true when frames match but no line info is available.
-func computeLines(sf *stackFile, method string, top int, fqn bool) (result []lineEntry, hasMethod bool) {
+// computeLines returns the per-source-line sample counts for the methods
+// selected by m. hasMethod is true when frames match but no line info is available.
+// Stacks carrying frame details are further split by bytecode index and
+// frame type, since one line can be both inlined and compiled standalone.
+func computeLines(sf *stackFile, m methodMatcher, top int, fqn bool) (result []lineEntry, hasMethod bool) {
 	if sf.totalSamples == 0 {
 		return nil, false
 	}
 
 	type lineKey struct {
-		name string
-		line uint32
+		name   string
+		line   uint32
+		detail frameDetail
 	}
 	lineCounts := make(map[lineKey]int)
 	foundAny := false
 
-	for i := range sf.stacks {
+	fm := sf.match(m)
+	for _, i := range fm.stacks {
 		st := &sf.stacks[i]
 		seen := make(map[lineKey]bool)
 		for j, fr := range st.frames {
-			if matchesMethod(fr, method) && st.lines[j] > 0 {
-				key := lineKey{displayName(fr, fqn), st.lines[j]}
+			if fm.frames[fr] && st.lines[j] > 0 {
+				key := lineKey{name: displayName(fr, fqn), line: st.lines[j]}
+				if st.details != nil {
+					key.detail = st.details[j]
+				}
 				if !seen[key] {
 					lineCounts[key] += st.count
 					seen[key] = true
@@ -71,44 +113,209 @@ func computeLines(sf *stackFile, method string, top int, fqn bool) (result []lin
 	}
 
 	if !foundAny {
-		for i := range sf.stacks {
-			for _, fr := range sf.stacks[i].frames {
-				if matchesMethod(fr, method) {
-					return nil, true
-				}
-			}
-		}
-		return nil, false
+		return nil, len(fm.stacks) > 0
 	}
 
+	withDetails := false
+	for _, i := range fm.stacks {
+		if sf.stacks[i].details != nil {
+			withDetails = true
+			break
+		}
+	}
 	var ranked []lineEntry
 	for k, c := range lineCounts {
-		ranked = append(ranked, lineEntry{k.name, k.line, c})
+		e := lineEntry{name: k.name, line: k.line, samples: c}
+		if withDetails {
+			d := k.detail
+			e.detail = &d
+		}
+		ranked = append(ranked, e)
 	}
-	sort.Slice(ranked, func(i, j int) bool { return ranked[i].samples > ranked[j].samples })
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].samples != ranked[j].samples {
+			return ranked[i].samples > ranked[j].samples
+		}
+		if ranked[i].name != ranked[j].name {
+			return ranked[i].name < ranked[j].name
+		}
+		if ranked[i].line != ranked[j].line {
+			return ranked[i].line < ranked[j].line
+		}
+		if ranked[i].detail != nil && ranked[i].detail.bci != ranked[j].detail.bci {
+			return ranked[i].detail.bci < ranked[j].detail.bci
+		}
+		return ranked[i].detail != nil && ranked[i].detail.kind < ranked[j].detail.kind
+	})
 	ranked = ranked[:truncate(len(ranked), top)]
 	return ranked, true
 }
 
-func cmdLines(sf *stackFile, method string, top int, fqn bool) error {
+func cmdLines(sf *stackFile, m methodMatcher, top int, fqn bool) error {
+	return cmdLinesOwned(sf, m, top, fqn, nil, nil)
+}
+
+// cmdLinesOwned is cmdLines with an OWNER column when owners is non-nil and
+// a URL column when link is non-nil.
+func cmdLinesOwned(sf *stackFile, m methodMatcher, top int, fqn bool, owners map[string]string, link func(string, uint32) string) error {
 	if sf.totalSamples == 0 {
 		fmt.Fprintln(os.Stdout, "no samples (empty profile or all filtered out)")
 		return nil
 	}
-	ranked, hasMethod := computeLines(sf, method, top, fqn)
+	ranked, hasMethod := computeLines(sf, m, top, fqn)
 	if ranked == nil {
 		if hasMethod {
-			return fmt.Errorf("no line info for frames matching '%s'", method)
+			return fmt.Errorf("no line info for frames matching '%s'", m.pattern)
+		}
+		noMatchMessage(os.Stdout, sf, m.pattern)
+		return nil
+	}
+
+	extraHeader := func() string {
+		h := ""
+		if owners != nil {
+			h += "  OWNER"
+		}
+		if link != nil {
+			h += "  URL"
+		}
+		return h
+	}
+	extraCells := func(e lineEntry) string {
+		c := ""
+		if owners != nil {
+			c += "  " + ownerColumn(owners[e.name])
+		}
+		if link != nil {
+			c += "  " + urlColumn(link(e.name, e.line))
+		}
+		return c
+	}
+
+	if ranked[0].detail != nil {
+		header := fmt.Sprintf("%-40s %5s %9s %7s  %s", "SOURCE:LINE", "BCI", "SAMPLES", "PCT", "TYPE")
+		if owners != nil || link != nil {
+			header = fmt.Sprintf("%-40s %5s %9s %7s  %-12s%s", "SOURCE:LINE", "BCI", "SAMPLES", "PCT", "TYPE", extraHeader())
+		}
+		fmt.Println(header)
+		for _, e := range ranked {
+			pct := pctOf(e.samples, sf.totalSamples)
+			loc := fmt.Sprintf("%s:%d", e.name, e.line)
+			row := fmt.Sprintf("%-40s %5d %9d %6.*f%%  %s", loc, e.detail.bci, e.samples, pctDigits, pct, e.detail.kind)
+			if owners != nil || link != nil {
+				row = fmt.Sprintf("%-40s %5d %9d %6.*f%%  %-12s%s", loc, e.detail.bci, e.samples, pctDigits, pct, e.detail.kind, extraCells(e))
+			}
+			fmt.Println(row)
 		}
-		noMatchMessage(os.Stdout, sf, method)
 		return nil
 	}
 
-	fmt.Printf("%-40s %9s %7s\n", "SOURCE:LINE", "SAMPLES", "PCT")
+	fmt.Printf("%-40s %9s %7s%s\n", "SOURCE:LINE", "SAMPLES", "PCT", extraHeader())
 	for _, e := range ranked {
 		pct := pctOf(e.samples, sf.totalSamples)
 		loc := fmt.Sprintf("%s:%d", e.name, e.line)
-		fmt.Printf("%-40s %9d %6.1f%%\n", loc, e.samples, pct)
+		fmt.Printf("%-40s %9d %6.*f%%%s\n", loc, e.samples, pctDigits, pct, extraCells(e))
+	}
+	return nil
+}
+
+// lineRange is a run of consecutive sampled lines of one method.
+type lineRange struct {
+	name       string
+	start, end uint32
+	lines      int // sampled lines in the range
+	samples    int
+}
+
+// computeLineRanges groups the lines of computeLines into runs of
+// consecutive line numbers per method. A range counts each stack once, so a
+// stack on two of its lines (recursion) is not added twice.
+func computeLineRanges(sf *stackFile, m methodMatcher, top int, fqn bool) (result []lineRange, hasMethod bool) {
+	entries, hasMethod := computeLines(sf, m, 0, fqn)
+	if entries == nil {
+		return nil, hasMethod
+	}
+	byName := make(map[string][]uint32)
+	for _, e := range entries {
+		byName[e.name] = append(byName[e.name], e.line)
+	}
+	type lineKey struct {
+		name string
+		line uint32
+	}
+	rangeOf := make(map[lineKey]int)
+	var ranges []lineRange
+	for name, lines := range byName {
+		slices.Sort(lines)
+		for i, l := range lines {
+			if i == 0 || l != lines[i-1]+1 {
+				ranges = append(ranges, lineRange{name: name, start: l})
+			}
+			r := &ranges[len(ranges)-1]
+			r.end = l
+			r.lines++
+			rangeOf[lineKey{name, l}] = len(ranges) - 1
+		}
+	}
+
+	fm := sf.match(m)
+	for _, i := range fm.stacks {
+		st := &sf.stacks[i]
+		seen := make(map[int]bool)
+		for j, fr := range st.frames {
+			if !fm.frames[fr] || st.lines[j] == 0 {
+				continue
+			}
+			r, ok := rangeOf[lineKey{displayName(fr, fqn), st.lines[j]}]
+			if ok && !seen[r] {
+				ranges[r].samples += st.count
+				seen[r] = true
+			}
+		}
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		if ranges[i].samples != ranges[j].samples {
+			return ranges[i].samples > ranges[j].samples
+		}
+		if ranges[i].name != ranges[j].name {
+			return ranges[i].name < ranges[j].name
+		}
+		return ranges[i].start < ranges[j].start
+	})
+	return ranges[:truncate(len(ranges), top)], true
+}
+
+// cmdLineRanges prints the ranges of computeLineRanges, with a URL column
+// linking each range's first line when link is non-nil.
+func cmdLineRanges(sf *stackFile, m methodMatcher, top int, fqn bool, link func(string, uint32) string) error {
+	if sf.totalSamples == 0 {
+		fmt.Fprintln(os.Stdout, "no samples (empty profile or all filtered out)")
+		return nil
+	}
+	ranges, hasMethod := computeLineRanges(sf, m, top, fqn)
+	if ranges == nil {
+		if hasMethod {
+			return fmt.Errorf("no line info for frames matching '%s'", m.pattern)
+		}
+		noMatchMessage(os.Stdout, sf, m.pattern)
+		return nil
+	}
+
+	header := fmt.Sprintf("%-40s %9s %7s %6s", "SOURCE:LINES", "SAMPLES", "PCT", "LINES")
+	if link != nil {
+		header += "  URL"
+	}
+	fmt.Println(header)
+	for _, r := range ranges {
+		loc := fmt.Sprintf("%s:%d", r.name, r.start)
+		if r.end != r.start {
+			loc += fmt.Sprintf("-%d", r.end)
+		}
+		row := fmt.Sprintf("%-40s %9d %6.*f%% %6d", loc, r.samples, pctDigits, pctOf(r.samples, sf.totalSamples), r.lines)
+		if link != nil {
+			row += "  " + urlColumn(link(r.name, r.start))
+		}
+		fmt.Println(row)
 	}
 	return nil
 }
@@ -7,7 +7,7 @@
 // Input: .jfr/.jfr.gz → JFR binary; .pb.gz/.pprof → pprof protobuf;
 // all other files → collapsed text; stdin (-) → auto-detect (binary = pprof, text = collapsed).
 //
-// Commands: hot, tree, trace, callers, threads, filter, events, collapse, diff, lines, info, timeline, script
+// Run ap-query --help for the list of commands.
 package main
 
 import (
@@ -17,20 +17,28 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 )
 
-var version = "dev"
+// Set at release build time with -ldflags "-X main.version=... -X main.commit=... -X main.date=...".
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
 
 // ---------------------------------------------------------------------------
 // Shared preprocessing
@@ -47,7 +55,14 @@ type profileContext struct {
 	fromNanos     int64
 	toNanos       int64
 	spanNanos     int64
+	cpuInterval   int64                 // ns of CPU time per cpu sample; 0 = unknown
 	stacksByEvent map[string]*stackFile // for info cross-event summary
+	truncation    stackTruncation       // samples of eventType cut off at the stack depth limit
+	owners        *ownerIndex           // --source-root; nil when not given
+	links         *repoLinker           // --repo-url; nil when not given
+	cpuLimit      cpuLimit              // --cpus or the recording's container limit; zero when unknown
+	idleExcluded  int                   // idle wall samples dropped by excludeIdle
+	idleTotal     int                   // samples before they were dropped; 0 when nothing was
 }
 
 type preprocessOpts struct {
@@ -55,23 +70,82 @@ type preprocessOpts struct {
 	thread    string
 	fromStr   string
 	toStr     string
+	minDur    string
 	noIdle    bool
+	maxStacks int
+	quiet     bool
 	path      string
 	command   string
+
+	timestamps   bool   // keep per-sample timed events (collapse --timestamps)
+	excludeIdle  bool   // drop idle wall samples unless --no-idle did (hot without --include-vm)
+	frameDetails string // flag that needs per-frame BCI and frame type (e.g. "--bci"); "" = off
+
+	// aggregator is a command weighing the events of a custom JFR event
+	// type (--aggregator); "" = each event weighs 1.
+	aggregator     string
+	context        string // --context id or "none"; "" = every sample
+	foldNativeCase bool   // lower-case Windows native module names (--fold-native-case)
+
+	threadNormalize []string // --thread-normalize rules
+	explain         bool     // print the applied steps before the results
+	groupBy         string   // --group-by key; "" = method
+	markInlined     bool     // suffix JIT-inlined frames with inlinedMark (--mark-inlined)
+	sourceRoot      string   // checkout for source file owners (--source-root); "" = none
+	repoURL         string   // source link template (--repo-url); "" = none
+	cpus            float64  // CPUs available to the JVM (--cpus); 0 = from the recording
+	detectCPUs      bool     // look up the CPU limit of cpu recordings
 }
 
 func preprocessProfile(opts preprocessOpts) (*profileContext, error) {
+	if opts.maxStacks < 0 {
+		return nil, fmt.Errorf("--max-stacks must be non-negative (got %d)", opts.maxStacks)
+	}
+	normalizer, err := newThreadNormalizer(opts.threadNormalize)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateGroupBy(opts.groupBy); err != nil {
+		return nil, err
+	}
+	if opts.cpus < 0 {
+		return nil, fmt.Errorf("--cpus must not be negative (got %g)", opts.cpus)
+	}
+	if opts.groupBy == groupByOwner && opts.sourceRoot == "" {
+		return nil, fmt.Errorf("--group-by owner requires --source-root")
+	}
+	var owners *ownerIndex
+	if opts.sourceRoot != "" {
+		if owners, err = loadOwnerIndex(opts.sourceRoot); err != nil {
+			return nil, err
+		}
+	}
+	var links *repoLinker
+	if opts.repoURL != "" {
+		if links, err = newRepoLinker(opts.repoURL, opts.sourceRoot, owners); err != nil {
+			return nil, err
+		}
+	}
+	if opts.groupBy == groupByFrameType && opts.frameDetails == "" {
+		opts.frameDetails = "--group-by frame-type"
+	}
+	// Grouping by class, package, frame type or owner merges frames, and
+	// the merged frame takes one member's detail, so the mark would split
+	// a group by whichever member happened to come first.
+	switch opts.groupBy {
+	case groupByClass, groupByPackage, groupByFrameType, groupByOwner:
+		if opts.markInlined {
+			return nil, fmt.Errorf("--mark-inlined cannot be combined with --group-by %s; use method or line", opts.groupBy)
+		}
+	}
+	if opts.markInlined && opts.frameDetails == "" {
+		opts.frameDetails = "--mark-inlined"
+	}
 	eventExplicit := opts.eventFlag != ""
 	eventType := opts.eventFlag
 	if eventType == "" {
 		eventType = "cpu"
 	}
-	if !isKnownEventType(eventType) {
-		format := detectFormat(opts.path)
-		if format == formatCollapsed && opts.path != "-" {
-			return nil, fmt.Errorf("unknown event type %q (valid: %s)", eventType, validEventTypesString())
-		}
-	}
 
 	// Parse time range.
 	window, err := parseDurationWindow("--from", opts.fromStr, "--to", opts.toStr)
@@ -82,6 +156,15 @@ func preprocessProfile(opts preprocessOpts) (*profileContext, error) {
 	toNanos := window.toNanos
 	needTimed := window.specified
 
+	var minDurNanos int64
+	if opts.minDur != "" {
+		d, err := time.ParseDuration(opts.minDur)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid --min-duration %q (want a positive duration such as 500us or 1ms)", opts.minDur)
+		}
+		minDurNanos = d.Nanoseconds()
+	}
+
 	path := opts.path
 	cmd := opts.command
 
@@ -89,6 +172,45 @@ func preprocessProfile(opts preprocessOpts) (*profileContext, error) {
 		return nil, fmt.Errorf("timeline requires a JFR file (pprof and collapsed text lack per-sample timestamps)")
 	}
 
+	if opts.timestamps && detectFormat(path) != formatJFR {
+		return nil, fmt.Errorf("--timestamps requires a JFR file (pprof and collapsed text lack per-sample timestamps)")
+	}
+
+	if opts.frameDetails != "" && detectFormat(path) != formatJFR {
+		return nil, fmt.Errorf("%s requires a JFR file (pprof and collapsed text lack bytecode indices and frame types)", opts.frameDetails)
+	}
+
+	customEvent := eventExplicit && isCustomEventName(eventType)
+	if opts.aggregator != "" && (!customEvent || detectFormat(path) != formatJFR) {
+		return nil, fmt.Errorf("--aggregator applies to custom JFR event types; name one with --event, e.g. --event com.acme.Request")
+	}
+	if customEvent && opts.frameDetails != "" && detectFormat(path) == formatJFR {
+		return nil, fmt.Errorf("%s is not supported for custom event %s", opts.frameDetails, eventType)
+	}
+
+	var contextID uint64
+	if opts.context != "" {
+		if contextID, err = parseContextID(opts.context); err != nil {
+			return nil, err
+		}
+	}
+	if opts.context != "" || opts.groupBy == groupByContext {
+		flag := "--context"
+		if opts.context == "" {
+			flag = "--group-by context"
+		}
+		if detectFormat(path) != formatJFR {
+			return nil, fmt.Errorf("%s requires a JFR file (pprof and collapsed text lack sample context ids)", flag)
+		}
+		if customEvent {
+			return nil, fmt.Errorf("%s is not supported for custom event %s", flag, eventType)
+		}
+	}
+
+	if minDurNanos > 0 && detectFormat(path) != formatJFR {
+		return nil, fmt.Errorf("--min-duration requires a JFR file (pprof and collapsed text lack event durations)")
+	}
+
 	if needTimed && detectFormat(path) != formatJFR {
 		fmt.Fprintln(os.Stderr, "warning: --from/--to ignored for non-JFR input (no timestamps)")
 		needTimed = false
@@ -96,10 +218,17 @@ func preprocessProfile(opts preprocessOpts) (*profileContext, error) {
 		toNanos = -1
 	}
 
-	if cmd == "timeline" {
+	if cmd == "timeline" || opts.timestamps {
 		needTimed = true
 	}
 
+	if opts.maxStacks > 0 && detectFormat(path) != formatJFR {
+		fmt.Fprintln(os.Stderr, "warning: --max-stacks ignored for non-JFR input")
+	}
+	if detectFormat(path) != formatCollapsed {
+		warnSampleIgnored(path)
+	}
+
 	var sf *stackFile
 	var parsed *parsedProfile
 	hasMetadata := false
@@ -114,16 +243,33 @@ func preprocessProfile(opts preprocessOpts) (*profileContext, error) {
 		if eventExplicit {
 			eventsToParse = singleEventType(eventType)
 		}
-		po := parseOpts{warnLargeCount: true}
+		po := parseOpts{warnLargeCount: true, progress: !opts.quiet, frameDetails: opts.frameDetails != "", minDurNanos: minDurNanos}
+		po.contextFilter, po.contextID = opts.context != "", contextID
+		po.contextFrames = opts.groupBy == groupByContext
 		if needTimed {
 			po.collectTimestamps = true
 			po.fromNanos = fromNanos
 			po.toNanos = toNanos
+			if opts.maxStacks > 0 {
+				fmt.Fprintln(os.Stderr, "warning: --max-stacks ignored with --from/--to and timeline (per-sample events are kept)")
+			}
+		} else {
+			po.maxStacks = opts.maxStacks
 		}
 		var err error
-		parsed, err = parseJFRData(path, eventsToParse, po)
-		if err != nil {
-			return nil, err
+		if customEvent {
+			// Events the application defines are decoded from the chunk
+			// metadata; nil means the file has none of that type.
+			parsed, err = parseCustomEventProfile(path, eventType, opts.aggregator, po)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if parsed == nil {
+			parsed, err = parseJFRData(path, eventsToParse, po)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		if fromNanos >= 0 && parsed.spanNanos > 0 && fromNanos >= parsed.spanNanos {
@@ -147,10 +293,20 @@ func preprocessProfile(opts preprocessOpts) (*profileContext, error) {
 			eventCounts = parsed.eventCounts
 		}
 		eventType, eventReason = resolveEventType(eventType, eventExplicit, eventCounts)
+		if minDurNanos > 0 && eventType != "lock" {
+			return nil, fmt.Errorf("--min-duration applies to lock events, the ones with durations (event is %s; add --event lock)", eventType)
+		}
 		sf = parsed.stacksByEvent[eventType]
 		if sf == nil {
 			sf = &stackFile{}
 		}
+		if (po.contextFilter || po.contextFrames) && parsed.contextTagged == 0 && opts.context != "none" {
+			fmt.Fprintln(os.Stderr, "warning: no samples carry a context id (recorded by async-profiler builds with tracing context support, e.g. Pyroscope's)")
+		}
+		if eventType == "wall" && parsed.approxWallPeriod > 0 {
+			fmt.Fprintf(os.Stderr, "warning: %s has no wall-clock samples; wall is approximated from jdk.ExecutionSample plus jdk.JavaMonitorEnter/jdk.ThreadPark time per %s sampling period (sleeping, waiting and native threads are missing)\n",
+				path, time.Duration(parsed.approxWallPeriod))
+		}
 	case formatPprof:
 		hasMetadata = true
 		eventsToParse := allEventTypes()
@@ -169,33 +325,32 @@ func preprocessProfile(opts preprocessOpts) (*profileContext, error) {
 			sf = &stackFile{}
 		}
 	default:
+		var res stdinResult
+		var err error
 		if path == "-" {
 			eventsToParse := allEventTypes()
 			if eventExplicit {
 				eventsToParse = singleEventType(eventType)
 			}
-			res, err := parseStdin(eventsToParse)
-			if err != nil {
-				return nil, err
-			}
-			if res.parsed != nil {
-				hasMetadata = true
-				parsed = res.parsed
-				eventCounts = parsed.eventCounts
-				eventType, eventReason = resolveEventType(eventType, eventExplicit, eventCounts)
-				sf = parsed.stacksByEvent[eventType]
-				if sf == nil {
-					sf = &stackFile{}
-				}
-			} else {
-				sf = res.sf
-			}
+			res, err = parseStdin(eventsToParse)
 		} else {
-			var err error
-			sf, hasMetadata, err = openInput(path, eventType)
-			if err != nil {
-				return nil, err
+			res, err = parseCollapsedFile(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if res.parsed != nil {
+			// pprof on stdin, or collapsed text with event labels.
+			hasMetadata = true
+			parsed = res.parsed
+			eventCounts = parsed.eventCounts
+			eventType, eventReason = resolveEventType(eventType, eventExplicit, eventCounts)
+			sf = parsed.stacksByEvent[eventType]
+			if sf == nil {
+				sf = &stackFile{}
 			}
+		} else {
+			sf = res.sf
 		}
 	}
 
@@ -226,13 +381,53 @@ func preprocessProfile(opts preprocessOpts) (*profileContext, error) {
 		}
 	}
 
+	var ex *explanation
+	if opts.explain {
+		ex = &explanation{}
+		if what := explainComputations[cmd]; what != "" {
+			ex.addf("%s: %s", cmd, what)
+		}
+		ex.addf("input: %s (%s)", path, explainFormat(path))
+		if hasMetadata {
+			ex.addf("event: %s (%s), %d samples", eventType, selectionModeLabel(eventReason), sf.totalSamples)
+			if eventType == "wall" && parsed.approxWallPeriod > 0 {
+				ex.addf("wall: approximate, 1 sample per jdk.ExecutionSample plus 1 per %s blocked in jdk.JavaMonitorEnter/jdk.ThreadPark", time.Duration(parsed.approxWallPeriod))
+			}
+		} else {
+			ex.addf("event: untyped (collapsed text has no event types), %d samples", sf.totalSamples)
+		}
+		if needTimed && cmd != "timeline" && (fromNanos >= 0 || toNanos >= 0) {
+			from, to := "start", "end"
+			if fromNanos >= 0 {
+				from = formatDuration(fromNanos)
+			}
+			if toNanos >= 0 {
+				to = formatDuration(toNanos)
+			}
+			ex.addf("window: %s to %s (samples outside dropped)", from, to)
+		}
+		if minDurNanos > 0 {
+			ex.addf("lock events shorter than %s dropped before aggregation (--min-duration)", opts.minDur)
+		}
+		if opts.context != "" {
+			ex.addf("only samples with context %s kept (--context)", opts.context)
+		}
+		if opts.maxStacks > 0 && !needTimed {
+			ex.addf("stacks capped at %d distinct while parsing (--max-stacks), counts approximate", opts.maxStacks)
+		}
+	}
+
 	// Thread filter (skipped for timeline — it does its own).
+	if opts.thread != "" && cmd == "timeline" {
+		ex.addf("thread filter: %q", opts.thread)
+	}
 	if opts.thread != "" && cmd != "timeline" {
 		totalBefore := sf.totalSamples
 		sf = sf.filterByThread(opts.thread)
+		ex.addf("thread filter: %q keeps %d/%d samples", opts.thread, sf.totalSamples, totalBefore)
 		if totalBefore > 0 {
-			fmt.Fprintf(os.Stderr, "Thread filter: %s — %d/%d samples (%.1f%%)\n",
-				opts.thread, sf.totalSamples, totalBefore, pctOf(sf.totalSamples, totalBefore))
+			fmt.Fprintf(os.Stderr, "Thread filter: %s — %d/%d samples (%.*f%%)\n",
+				opts.thread, sf.totalSamples, totalBefore, pctDigits, pctOf(sf.totalSamples, totalBefore))
 		}
 	}
 
@@ -240,17 +435,61 @@ func preprocessProfile(opts preprocessOpts) (*profileContext, error) {
 	if opts.noIdle && cmd != "timeline" {
 		totalBefore := sf.totalSamples
 		sf = sf.filterIdle()
+		ex.addf("idle leaf frames removed (--no-idle): %d/%d samples remain", sf.totalSamples, totalBefore)
 		if totalBefore > 0 {
-			fmt.Fprintf(os.Stderr, "Idle filter: %d/%d samples remain (%.1f%% idle removed)\n",
-				sf.totalSamples, totalBefore, pctOf(totalBefore-sf.totalSamples, totalBefore))
+			fmt.Fprintf(os.Stderr, "Idle filter: %d/%d samples remain (%.*f%% idle removed)\n",
+				sf.totalSamples, totalBefore, pctDigits, pctOf(totalBefore-sf.totalSamples, totalBefore))
 		}
 	}
 
-	// Event selection info (skipped for info, timeline).
-	if hasMetadata && cmd != "info" && cmd != "timeline" {
+	if opts.foldNativeCase {
+		var folded int
+		sf, folded = sf.mapFrames(foldNativeCase)
+		if parsed != nil {
+			for _, events := range parsed.timedEvents {
+				foldTimedNativeCase(events)
+			}
+		}
+		ex.addf("%d Windows native frames folded to lower-case module names (--fold-native-case)", folded)
+	}
+	sf = normalizer.apply(sf)
+	if normalizer != nil {
+		ex.addf("thread names normalized (--thread-normalize): %s", strings.Join(opts.threadNormalize, ", "))
+	}
+	if opts.groupBy == groupByOwner {
+		sf = sf.groupByOwner(owners)
+		ex.addf("frames grouped by owner of their source file under %s (--group-by)", opts.sourceRoot)
+	} else if opts.groupBy != "" && opts.groupBy != groupByMethod {
+		sf = sf.groupBy(opts.groupBy)
+		ex.addf("frames grouped by %s (--group-by)", opts.groupBy)
+	}
+	if opts.markInlined {
+		var marked int
+		sf, marked = sf.markInlined()
+		ex.addf("%d inlined frames marked %q (--mark-inlined)", marked, inlinedMark)
+	}
+	ex.addf("result: %d samples in %d distinct stacks", sf.totalSamples, len(sf.stacks))
+	ex.print(os.Stdout)
+
+	// Event selection info (skipped for info, timeline, compare-events).
+	if hasMetadata && cmd != "info" && cmd != "timeline" && cmd != "compare-events" {
 		printEventSelectionForSingle(eventType, eventReason, eventCounts)
 	}
 
+	// Truncated stacks warning (info reports them in its header).
+	truncationEvent := ""
+	if hasMetadata {
+		truncationEvent = eventType
+	}
+	truncation := detectTruncation(parsed, eventType, eventCounts, sf)
+	if cmd != "info" && truncation.samples > 0 && truncation.pct() >= truncationWarnPct {
+		var stackDepth string
+		if parsed != nil {
+			stackDepth = parsed.settings["jstackdepth"]
+		}
+		fmt.Fprintf(os.Stderr, "warning: %s; %s\n", truncation.describe(truncationEvent), truncationAdvice(stackDepth))
+	}
+
 	// Time window echo (skipped for timeline).
 	if needTimed && cmd != "timeline" {
 		if fromNanos >= 0 && toNanos >= 0 {
@@ -262,12 +501,22 @@ func preprocessProfile(opts preprocessOpts) (*profileContext, error) {
 		}
 	}
 
+	// Idle wall samples mask the running code in rankings; commands that
+	// rank drop them unless asked not to and report how many they dropped.
+	var idleExcluded, idleTotal int
+	if opts.excludeIdle && eventType == "wall" && !opts.noIdle {
+		idleTotal = sf.totalSamples
+		sf = sf.filterIdle()
+		idleExcluded = idleTotal - sf.totalSamples
+		ex.addf("idle wall samples excluded (--include-vm keeps them): %d/%d samples remain", sf.totalSamples, idleTotal)
+	}
+
 	// Idle hint for wall profiles.
-	if eventType == "wall" && !opts.noIdle {
+	if eventType == "wall" && !opts.noIdle && !opts.excludeIdle {
 		idleCount := 0
 		for i := range sf.stacks {
 			st := &sf.stacks[i]
-			if len(st.frames) > 0 && isIdleLeaf(st.frames[len(st.frames)-1]) {
+			if isIdleStack(st.frames) {
 				idleCount += st.count
 			}
 		}
@@ -290,15 +539,31 @@ func preprocessProfile(opts preprocessOpts) (*profileContext, error) {
 				}
 				stacksByEvent = filtered
 			}
+			if normalizer != nil && stacksByEvent != nil {
+				normalized := make(map[string]*stackFile, len(stacksByEvent))
+				for k, v := range stacksByEvent {
+					normalized[k] = normalizer.apply(v)
+				}
+				stacksByEvent = normalized
+			}
 		}
 	}
 
-	var spanNanos int64
+	var spanNanos, cpuInterval int64
 	if parsed != nil {
 		spanNanos = parsed.spanNanos
+		cpuInterval = parsed.cpuInterval
 	}
 
-	return &profileContext{
+	var limit cpuLimit
+	if opts.cpus > 0 {
+		limit = cpuLimit{cpus: opts.cpus, source: cpuLimitFlag}
+	} else if opts.detectCPUs && format == formatJFR && eventType == "cpu" {
+		// Best effort: a recording without the JDK events has no limit.
+		limit, _ = readCPULimit(path)
+	}
+
+	pctx := &profileContext{
 		sf:            sf,
 		parsed:        parsed,
 		hasMetadata:   hasMetadata,
@@ -309,8 +574,36 @@ func preprocessProfile(opts preprocessOpts) (*profileContext, error) {
 		fromNanos:     fromNanos,
 		toNanos:       toNanos,
 		spanNanos:     spanNanos,
+		cpuInterval:   cpuInterval,
 		stacksByEvent: stacksByEvent,
-	}, nil
+		truncation:    truncation,
+		owners:        owners,
+		links:         links,
+		cpuLimit:      limit,
+		idleExcluded:  idleExcluded,
+		idleTotal:     idleTotal,
+	}
+
+	// CPU limit context for cpu profiles (info reports it in its header).
+	if cmd != "info" && eventType == "cpu" {
+		if limit.limited() {
+			fmt.Fprintf(os.Stderr, "CPU limit: %s\n", limit.describe())
+		}
+		if w := pctx.cpuLimitWarning(); w != "" {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+		}
+	}
+	return pctx, nil
+}
+
+// cpuLimitWarning is cpuLimit.exceededBy for the cores the profile's cpu
+// samples imply over the recording or --from/--to window.
+func (pctx *profileContext) cpuLimitWarning() string {
+	r, err := newHotRate(pctx)
+	if err != nil || r.cpuInterval == 0 {
+		return ""
+	}
+	return pctx.cpuLimit.exceededBy(r.cores(pctx.sf.totalSamples))
 }
 
 // ---------------------------------------------------------------------------
@@ -318,30 +611,106 @@ func preprocessProfile(opts preprocessOpts) (*profileContext, error) {
 // ---------------------------------------------------------------------------
 
 type sharedFlags struct {
-	event  string
-	thread string
-	from   string
-	to     string
-	noIdle bool
+	event      string
+	thread     string
+	from       string
+	to         string
+	minDur     string
+	noIdle     bool
+	maxStacks  int
+	quiet      bool
+	aggregator string
+	context    string
+	foldCase   bool
+
+	threadNormalize []string // only on commands that call registerThreadNormalize
+	explain         bool     // only on commands that call registerExplain
+	groupBy         string   // only on commands that call registerGroupBy
+	markInlined     bool     // set by registerGroupBy
+	sourceRoot      string   // only on commands that call registerSourceRoot
+	repoURL         string   // only on commands that call registerRepoURL
+	cpus            float64  // only on commands that call registerCPUs
+	detectCPUs      bool     // set by registerCPUs
 }
 
 func (s *sharedFlags) register(cmd *cobra.Command) {
-	cmd.Flags().StringVarP(&s.event, "event", "e", "", "Event type: cpu, wall, alloc, lock, or hardware counter name (default: cpu)")
+	cmd.Flags().StringVarP(&s.event, "event", "e", "", "Event type: cpu, wall, alloc, lock, live, or hardware counter name (default: cpu)")
 	cmd.Flags().StringVarP(&s.thread, "thread", "t", "", "Filter to threads matching substring")
 	cmd.Flags().StringVar(&s.from, "from", "", "Start of time window (JFR only)")
 	cmd.Flags().StringVar(&s.to, "to", "", "End of time window (JFR only)")
+	cmd.Flags().StringVar(&s.minDur, "min-duration", "", "Drop lock events shorter than this, e.g. 1ms, before aggregation (JFR only)")
 	cmd.Flags().BoolVar(&s.noIdle, "no-idle", false, "Remove idle leaf frames")
+	cmd.Flags().IntVar(&s.maxStacks, "max-stacks", 0, "Keep at most N distinct stacks while parsing, approximating the rest (JFR only; default: unlimited)")
+	cmd.Flags().BoolVarP(&s.quiet, "quiet", "q", false, "Suppress the parse progress line shown for large JFR files on a terminal")
+	cmd.Flags().BoolVar(&s.foldCase, "fold-native-case", false, foldNativeCaseUsage)
+	cmd.Flags().StringVar(&s.context, "context", "", "Keep samples tagged with this context id, or none for untagged ones (JFR only)")
+	cmd.Flags().StringVar(&s.aggregator, "aggregator", "", "Command that weighs the events of a custom JFR event type (--event com.acme.Request): reads JSON lines, answers \"WEIGHT [KEY]\" per event; quote arguments as in a shell")
+}
+
+// registerThreadNormalize adds --thread-normalize to commands that report
+// per-thread results.
+func (s *sharedFlags) registerThreadNormalize(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&s.threadNormalize, "thread-normalize", nil, threadNormalizeUsage)
+}
+
+// registerExplain adds --explain to commands that run preprocessProfile.
+func (s *sharedFlags) registerExplain(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&s.explain, "explain", false, "Print what is computed (command, input, event, filters, totals) as # lines before the results")
+}
+
+// registerGroupBy adds --group-by to commands that rank or nest frames.
+func (s *sharedFlags) registerGroupBy(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&s.groupBy, "group-by", groupByMethod, groupByUsage)
+	cmd.Flags().BoolVar(&s.markInlined, "mark-inlined", false, "Suffix frames the JIT inlined into their caller with \""+inlinedMark+"\" so tiny helpers are told apart (JFR only; method or line grouping)")
+	s.registerSourceRoot(cmd)
 }
 
+// registerSourceRoot adds --source-root, the checkout whose CODEOWNERS or
+// git history names the owners of source files.
+func (s *sharedFlags) registerSourceRoot(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&s.sourceRoot, "source-root", "", "Source checkout for file owners (CODEOWNERS, else the top git author per file); enables --group-by owner")
+}
+
+// registerCPUs adds --cpus to commands that analyze cpu time, which then
+// report the CPU limit the profile was captured under.
+func (s *sharedFlags) registerCPUs(cmd *cobra.Command) {
+	cmd.Flags().Float64Var(&s.cpus, "cpus", 0, "CPUs available to the profiled JVM, e.g. its cgroup limit (default: from jdk.ContainerConfiguration/jdk.CPUInformation when recorded)")
+	s.detectCPUs = true
+}
+
+// registerRepoURL adds --repo-url to commands that link methods or lines to
+// their source.
+func (s *sharedFlags) registerRepoURL(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&s.repoURL, "repo-url", "", repoURLUsage)
+}
+
+const foldNativeCaseUsage = "Ignore case in Windows native module names (ntdll.dll vs NTDLL.DLL) so their stacks aggregate"
+
+const threadNormalizeUsage = "Rewrite thread names so pool members aggregate: digits, suffix, forkjoin, or REGEX=REPLACEMENT; repeatable, applied in order"
+
 func (s *sharedFlags) toOpts(path, command string) preprocessOpts {
 	return preprocessOpts{
-		eventFlag: s.event,
-		thread:    s.thread,
-		fromStr:   s.from,
-		toStr:     s.to,
-		noIdle:    s.noIdle,
-		path:      path,
-		command:   command,
+		eventFlag:       s.event,
+		thread:          s.thread,
+		fromStr:         s.from,
+		toStr:           s.to,
+		minDur:          s.minDur,
+		aggregator:      s.aggregator,
+		context:         s.context,
+		foldNativeCase:  s.foldCase,
+		noIdle:          s.noIdle,
+		maxStacks:       s.maxStacks,
+		quiet:           s.quiet,
+		path:            path,
+		command:         command,
+		threadNormalize: s.threadNormalize,
+		explain:         s.explain,
+		groupBy:         s.groupBy,
+		markInlined:     s.markInlined,
+		sourceRoot:      s.sourceRoot,
+		repoURL:         s.repoURL,
+		cpus:            s.cpus,
+		detectCPUs:      s.detectCPUs,
 	}
 }
 
@@ -369,11 +738,40 @@ Examples:
   ap-query timeline profile.jfr
   ap-query timeline profile.jfr --compare cpu,wall --thread worker
   ap-query hot profile.jfr --from 5s --to 10s
+  ap-query tree app.jfr --event com.acme.RequestEvent --aggregator ./by-endpoint.py
+  ap-query methods profile.jfr HashMap
+  ap-query files profile.jfr
+  ap-query top-level profile.jfr --entry '*Controller.*'
+  ap-query inspect profile.jfr -m HashMap.resize
+  ap-query compare-events profile.jfr
+  ap-query safepoints profile.jfr
+  ap-query hist profile.jfr --event lock
+  ap-query classes profile.jfr
+  ap-query heap profile.jfr
   ap-query tree profile.jfr -m HashMap.resize --depth 6
+  ap-query contexts profile.jfr -m HashMap.resize --depth 2
   ap-query diff before.jfr after.pb.gz --min-delta 0.5
   ap-query diff profile.jfr --from 55s --to 1m05s --vs-from 2m45s --vs-to 3m10s
+  ap-query diff base.jfr candidateA.jfr candidateB.jfr
+  ap-query watch /var/profiles --log regressions.log
+  ap-query trend nightly-01.jfr nightly-02.jfr nightly-03.jfr
+  ap-query where -m HashMap.resize nightly-*.jfr
+  ap-query archive add profile.jfr --label "release-1.42 cpu"
   ap-query collapse profile.jfr --event wall | ap-query hot -
+  ap-query export profile.jfr --jfr trimmed.jfr --event cpu --from 10s --to 20s
+  ap-query treemap profile.jfr --html treemap.html
+  ap-query metrics profile.jfr --label service=checkout > checkout.prom
+  ap-query record -- java -jar app.jar
   echo "A;B;C 10" | ap-query hot -
+  ap-query version --json
+  ap-query selftest
+
+Exit codes:
+  0  success
+  1  assertion failed (--assert-below, threads --assert, assert, fail() in scripts)
+  2  usage error (bad flags or arguments, script errors)
+  3  input is not a valid profile
+  4  I/O error (missing or unreadable file, network)
 
 Run 'ap-query <command> --help' for command-specific help.`,
 		SilenceUsage:  true,
@@ -383,31 +781,80 @@ Run 'ap-query <command> --help' for command-specific help.`,
 			return fmt.Errorf("no command specified")
 		},
 	}
+	root.PersistentFlags().IntVar(&pctDigits, "precision", 1, "Decimals in printed percentages (0-6); the decimal separator is always '.'")
+	root.PersistentFlags().BoolVar(&utcTimes, "utc", false, "Print recording start and end times in UTC only, without the local-time form")
+	root.PersistentFlags().IntVar(&maxLineBytes, "max-line-bytes", defaultMaxLineBytes, "Longest collapsed-text line kept; longer lines are skipped with a warning")
+	root.PersistentFlags().IntVar(&maxStackFrames, "max-frames", defaultMaxStackFrames, "Deepest stack kept; deeper stacks keep their leaf-most frames")
+	root.PersistentFlags().IntVar(&maxSymbolBytes, "max-symbol-bytes", defaultMaxSymbolBytes, "Longest frame name kept; longer names are cut and end in \"…\"")
+	root.PersistentFlags().IntVar(&maxEvents, "max-events", defaultMaxEvents, "Most events read from a JFR recording; more fail the parse")
+	root.PersistentFlags().BoolVar(&fullStacks, "full-stacks", false, fmt.Sprintf("Print call chains deeper than %d frames in full in tree, callers and trace instead of eliding their middle", elideAbove))
+	root.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if pctDigits < 0 || pctDigits > 6 {
+			return fmt.Errorf("--precision must be between 0 and 6 (got %d)", pctDigits)
+		}
+		if maxLineBytes <= 0 {
+			return fmt.Errorf("--max-line-bytes must be positive (got %d)", maxLineBytes)
+		}
+		if maxStackFrames <= 0 || maxSymbolBytes <= 0 || maxEvents <= 0 {
+			return fmt.Errorf("--max-frames, --max-symbol-bytes and --max-events must be positive")
+		}
+		if samplePct != "" {
+			var err error
+			if sampleFraction, err = parseSamplePct(samplePct); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 	root.AddCommand(
 		newHotCmd(),
 		newTreeCmd(),
 		newTraceCmd(),
 		newCallersCmd(),
+		newContextsCmd(),
 		newThreadsCmd(),
 		newFilterCmd(),
 		newCollapseCmd(),
+		newExportCmd(),
+		newTreemapCmd(),
+		newMetricsCmd(),
 		newLinesCmd(),
+		newFilesCmd(),
+		newTopLevelCmd(),
+		newInspectCmd(),
+		newCompareEventsCmd(),
+		newSafepointsCmd(),
+		newHistCmd(),
+		newClassesCmd(),
+		newHeapCmd(),
 		newTimelineCmd(),
 		newInfoCmd(),
+		newRecordCmd(),
 		newDiffCmd(),
+		newWatchCmd(),
+		newTrendCmd(),
+		newAssertCmd(),
+		newGenCmd(),
+		newWhereCmd(),
+		newArchiveCmd(),
 		newEventsCmd(),
+		newMethodsCmd(),
 		newScriptCmd(),
 		newInitCmd(),
 		newUpdateCmd(),
 		newVersionCmd(),
+		newSelftestCmd(),
 	)
 	return root
 }
 
 func main() {
 	if err := newRootCmd().Execute(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		var ee *exitError
+		if !errors.As(err, &ee) || ee.err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+		os.Exit(exitCodeFor(err))
 	}
 }
 
@@ -416,14 +863,40 @@ func main() {
 // ---------------------------------------------------------------------------
 
 func newVersionCmd() *cobra.Command {
-	return &cobra.Command{
+	var asJSON, checkUpdate bool
+	cmd := &cobra.Command{
 		Use:   "version",
-		Short: "Print version and check for updates",
-		Args:  cobra.NoArgs,
-		Run: func(cmd *cobra.Command, args []string) {
-			printVersion(os.Stdout)
+		Short: "Print version and build metadata, and check for updates",
+		Long: `Version prints the version, commit, build date and Go toolchain of this
+binary, then checks GitHub for a newer release. --check-update also says
+when this build is current or the lookup failed.
+
+--json prints the same as one JSON object for scripts that verify an
+installation. It does not go to the network unless --check-update is
+given, which adds the latest release and whether it is newer.`,
+		Example: strings.Join([]string{
+			"  ap-query version",
+			"  ap-query version --check-update",
+			"  ap-query version --json --check-update",
+		}, "\n"),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !asJSON {
+				printVersion(os.Stdout, checkLatestVersion(), checkUpdate)
+				return nil
+			}
+			info := currentBuildInfo()
+			if checkUpdate {
+				info.withLatest(checkLatestVersion())
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(info)
 		},
 	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print version and build metadata as JSON")
+	cmd.Flags().BoolVar(&checkUpdate, "check-update", false, "Report the latest release even when this build is current (network; --json otherwise stays offline)")
+	return cmd
 }
 
 func newUpdateCmd() *cobra.Command {
@@ -432,8 +905,8 @@ func newUpdateCmd() *cobra.Command {
 		Use:   "update",
 		Short: "Download and install the latest release",
 		Args:  cobra.NoArgs,
-		Run: func(cmd *cobra.Command, args []string) {
-			cmdUpdate(force)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdUpdate(force)
 		},
 	}
 	cmd.Flags().BoolVar(&force, "force", false, "Force update even for dev/go-install builds")
@@ -444,12 +917,115 @@ func newUpdateCmd() *cobra.Command {
 // version
 // ---------------------------------------------------------------------------
 
-func printVersion(w *os.File) {
-	fmt.Fprintf(w, "ap-query version %s\n", version)
-	latest := checkLatestVersion()
-	if latest != "" && latest != version && latest != "v"+version {
+// buildInfo is the version --json payload.
+type buildInfo struct {
+	Version         string `json:"version"`
+	Commit          string `json:"commit"`
+	BuildDate       string `json:"build_date"`
+	GoVersion       string `json:"go_version"`
+	Platform        string `json:"platform"`
+	Latest          string `json:"latest,omitempty"`
+	UpdateAvailable *bool  `json:"update_available,omitempty"`
+}
+
+// currentBuildInfo returns the metadata of this binary. Without release
+// ldflags (go install, go build) the commit comes from the VCS stamp Go
+// embeds, its commit time stands in for the build date, and the version is
+// the module version if any.
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: date,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch {
+		case s.Key == "vcs.revision" && info.Commit == "":
+			info.Commit = s.Value
+		case s.Key == "vcs.time" && info.BuildDate == "":
+			info.BuildDate = s.Value
+		case s.Key == "vcs.modified" && s.Value == "true" && info.Commit != "" && commit == "":
+			info.Commit += "-dirty"
+		}
+	}
+	return info
+}
+
+// withLatest records the latest release; "" (lookup failed) leaves the
+// update fields out.
+func (b *buildInfo) withLatest(latest string) {
+	if latest == "" {
+		return
+	}
+	newer := versionNewer(latest, b.Version)
+	b.Latest, b.UpdateAvailable = latest, &newer
+}
+
+// versionNewer reports whether release latest is newer than current,
+// comparing x.y.z numerically; a pre-release is older than its release.
+// A current version that is not x.y.z (a dev build) counts as older.
+func versionNewer(latest, current string) bool {
+	l, lpre, ok := parseReleaseVersion(latest)
+	if !ok {
+		return false
+	}
+	c, cpre, ok := parseReleaseVersion(current)
+	if !ok {
+		return true
+	}
+	if d := compareVersions(l, c); d != 0 {
+		return d > 0
+	}
+	return cpre != "" && lpre == ""
+}
+
+// parseReleaseVersion splits "v1.2.3-rc1+meta" into [1 2 3] and "rc1".
+func parseReleaseVersion(v string) ([]int, string, bool) {
+	v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "+")
+	v, pre, _ := strings.Cut(v, "-")
+	var parts []int
+	for _, f := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, "", false
+		}
+		parts = append(parts, n)
+	}
+	return parts, pre, true
+}
+
+// printVersion prints the build metadata and, when latest is a newer
+// release, where to get it. With checked (--check-update) it also says when
+// this build is current or the lookup failed ("" latest).
+func printVersion(w io.Writer, latest string, checked bool) {
+	info := currentBuildInfo()
+	fmt.Fprintf(w, "ap-query version %s\n", info.Version)
+	if info.Commit != "" {
+		fmt.Fprintf(w, "  commit %s", info.Commit)
+		if info.BuildDate != "" {
+			fmt.Fprintf(w, ", built %s", info.BuildDate)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "  %s %s\n", info.GoVersion, info.Platform)
+	switch {
+	case latest != "" && versionNewer(latest, info.Version):
 		fmt.Fprintf(w, "A newer version is available: %s\n", latest)
 		fmt.Fprintf(w, "  https://github.com/jerrinot/ap-query/releases/latest\n")
+	case !checked:
+	case latest == "":
+		fmt.Fprintln(w, "Could not check for a newer release (network error?)")
+	default:
+		fmt.Fprintf(w, "Up to date (latest release %s)\n", latest)
 	}
 }
 
@@ -476,40 +1052,34 @@ func checkLatestVersion() string {
 // update
 // ---------------------------------------------------------------------------
 
-func cmdUpdate(force bool) {
+func cmdUpdate(force bool) error {
 	if version == "dev" && !force {
-		fmt.Fprintln(os.Stderr, "error: cannot self-update a dev build; use 'go install' or download a release binary")
-		os.Exit(1)
+		return usageErrorf("cannot self-update a dev build; use 'go install' or download a release binary")
 	}
 
 	execPath, err := os.Executable()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: cannot determine executable path: %v\n", err)
-		os.Exit(1)
+		return ioErrorf("cannot determine executable path: %v", err)
 	}
 	execPath, err = filepath.EvalSymlinks(execPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: cannot resolve executable path: %v\n", err)
-		os.Exit(1)
+		return ioErrorf("cannot resolve executable path: %v", err)
 	}
 
 	if isGoInstall(execPath) && !force {
 		fmt.Fprintln(os.Stderr, "It looks like ap-query was installed via 'go install'.")
 		fmt.Fprintln(os.Stderr, "Please update with:  go install github.com/jerrinot/ap-query@latest")
-		return
+		return nil
 	}
 
 	latest := checkLatestVersion()
 	if latest == "" {
-		fmt.Fprintln(os.Stderr, "error: could not check latest version (network error?)")
-		os.Exit(1)
+		return ioErrorf("could not check latest version (network error?)")
 	}
 
-	currentNorm := strings.TrimPrefix(version, "v")
-	latestNorm := strings.TrimPrefix(latest, "v")
-	if currentNorm == latestNorm {
+	if !versionNewer(latest, version) {
 		fmt.Printf("ap-query %s is already the latest version.\n", version)
-		return
+		return nil
 	}
 
 	fmt.Printf("Updating ap-query %s → %s ...\n", version, latest)
@@ -520,51 +1090,45 @@ func cmdUpdate(force bool) {
 	checksumsURL := downloadURL(latest, "checksums.txt")
 	resp, err := client.Get(checksumsURL)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: downloading checksums: %v\n", err)
-		os.Exit(1)
+		return ioErrorf("downloading checksums: %v", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		fmt.Fprintf(os.Stderr, "error: downloading checksums: HTTP %d\n", resp.StatusCode)
-		os.Exit(1)
+		return ioErrorf("downloading checksums: HTTP %d", resp.StatusCode)
 	}
 	checksums, err := parseChecksums(resp.Body)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: parsing checksums: %v\n", err)
-		os.Exit(1)
+		return ioErrorf("parsing checksums: %v", err)
 	}
 
 	archive := archiveName()
 	expectedHash, ok := checksums[archive]
 	if !ok {
-		fmt.Fprintf(os.Stderr, "error: no checksum found for %s\n", archive)
-		os.Exit(1)
+		return ioErrorf("no checksum found for %s", archive)
 	}
 
 	// Download and verify archive
 	archiveURL := downloadURL(latest, archive)
 	archiveData, err := downloadAndVerify(archiveURL, expectedHash, client)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		return ioErrorf("%w", err)
 	}
 
 	// Extract binary
 	binaryData, err := extractBinary(archiveData)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		return ioErrorf("%w", err)
 	}
 
 	// Replace current binary
 	if err := replaceBinary(execPath, binaryData); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		return ioErrorf("%w", err)
 	}
 
 	fmt.Printf("Successfully updated to ap-query %s\n", latest)
 
 	updateInstalledSkills(execPath)
+	return nil
 }
 
 func isGoInstall(execPath string) bool {
@@ -3,9 +3,11 @@ package main
 import (
 	"archive/tar"
 	"archive/zip"
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"math"
@@ -14,10 +16,15 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/grafana/jfr-parser/parser"
+	"github.com/grafana/jfr-parser/parser/types"
 )
 
 // ---------------------------------------------------------------------------
@@ -103,7 +110,7 @@ func TestHelperProcessMain(t *testing.T) {
 
 func TestVersionOutput(t *testing.T) {
 	out := captureOutput(func() {
-		printVersion(os.Stdout)
+		printVersion(os.Stdout, "", false)
 	})
 	if !strings.Contains(out, "ap-query version") {
 		t.Errorf("expected 'ap-query version' in output, got %q", out)
@@ -111,6 +118,48 @@ func TestVersionOutput(t *testing.T) {
 	if !strings.Contains(out, version) {
 		t.Errorf("expected version %q in output, got %q", version, out)
 	}
+
+	// A dev build is older than any release; only --check-update reports
+	// a failed lookup or an up-to-date build.
+	for _, tt := range []struct {
+		latest  string
+		checked bool
+		want    string
+	}{
+		{"v99.0.0", false, "A newer version is available: v99.0.0\n"},
+		{"", false, ""},
+		{"", true, "Could not check for a newer release"},
+		{"not-a-version", true, "Up to date (latest release not-a-version)\n"},
+	} {
+		var b bytes.Buffer
+		printVersion(&b, tt.latest, tt.checked)
+		_, tail, _ := strings.Cut(b.String(), runtime.GOARCH+"\n")
+		if tt.want == "" && tail != "" || !strings.Contains(tail, tt.want) {
+			t.Errorf("printVersion(%q, %v) ends with %q, want %q", tt.latest, tt.checked, tail, tt.want)
+		}
+	}
+}
+
+func TestVersionNewer(t *testing.T) {
+	for _, tt := range []struct {
+		latest, current string
+		want            bool
+	}{
+		{"v0.13", "v0.12", true},
+		{"v0.13", "0.13", false},
+		{"v0.12", "v0.13", false},
+		{"v0.9.0", "v0.10.0", false},
+		{"v0.10.0", "v0.9.9", true},
+		{"v1.0", "v1.0.0", false},
+		{"v1.0.0", "v1.0.0-rc1", true},
+		{"v1.0.0-rc1", "v1.0.0", false},
+		{"v1.0.0", "dev", true},
+		{"garbage", "v1.0.0", false},
+	} {
+		if got := versionNewer(tt.latest, tt.current); got != tt.want {
+			t.Errorf("versionNewer(%q, %q) = %v, want %v", tt.latest, tt.current, got, tt.want)
+		}
+	}
 }
 
 // ---------------------------------------------------------------------------
@@ -225,7 +274,7 @@ func TestCmdHotDualOutput(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdHot(sf, 0, false, 0)
+		cmdHot(sf, 0, false, false, 0)
 	})
 
 	if !strings.Contains(out, "=== RANK BY SELF TIME ===") {
@@ -376,7 +425,7 @@ func TestCmdDiff(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdDiff(before, after, 0.5, 0, false)
+		cmdDiff(before, after, 0.5, 0, false, nil)
 	})
 
 	if !strings.Contains(out, "REGRESSION") {
@@ -411,7 +460,7 @@ func TestCmdDiffNoChanges(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdDiff(sf, sf, 0.5, 0, false)
+		cmdDiff(sf, sf, 0.5, 0, false, nil)
 	})
 
 	if !strings.Contains(out, "no significant changes") {
@@ -431,7 +480,7 @@ func TestCmdLines(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdLines(sf, "B.process", 0, false)
+		cmdLines(sf, substringMatcher("B.process"), 0, false)
 	})
 
 	if !strings.Contains(out, "SOURCE:LINE") {
@@ -461,7 +510,7 @@ func TestCmdLinesNoMatch(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdLines(sf, "Nonexistent", 0, false)
+		cmdLines(sf, substringMatcher("Nonexistent"), 0, false)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -1291,7 +1340,7 @@ func TestComputeLines(t *testing.T) {
 		{frames: []string{"A.a", "com.example.B.process"}, lines: []uint32{10, 42}, count: 2, thread: "worker"},
 	})
 
-	result, hasMethod := computeLines(sf, "B.process", 0, false)
+	result, hasMethod := computeLines(sf, substringMatcher("B.process"), 0, false)
 	if !hasMethod {
 		t.Fatal("expected hasMethod=true")
 	}
@@ -1312,7 +1361,7 @@ func TestComputeLinesNoLineInfo(t *testing.T) {
 		{frames: []string{"A.a", "B.b"}, lines: []uint32{0, 0}, count: 10, thread: "main"},
 	})
 
-	result, hasMethod := computeLines(sf, "B.b", 0, false)
+	result, hasMethod := computeLines(sf, substringMatcher("B.b"), 0, false)
 	if result != nil {
 		t.Errorf("expected nil result, got %v", result)
 	}
@@ -1326,7 +1375,7 @@ func TestComputeLinesNoMatch(t *testing.T) {
 		{frames: []string{"A.a", "B.b"}, lines: []uint32{10, 20}, count: 5, thread: "main"},
 	})
 
-	result, hasMethod := computeLines(sf, "Nonexistent", 0, false)
+	result, hasMethod := computeLines(sf, substringMatcher("Nonexistent"), 0, false)
 	if result != nil {
 		t.Errorf("expected nil result, got %v", result)
 	}
@@ -2091,7 +2140,7 @@ func TestCmdFilter(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdFilter(sf, "B.b", false)
+		cmdFilter(sf, substringMatcher("B.b"), false, filterThreadsAsIs)
 	})
 
 	lines := strings.Split(strings.TrimSpace(out), "\n")
@@ -2116,7 +2165,7 @@ func TestCmdFilterIncludeCallers(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdFilter(sf, "B.b", true)
+		cmdFilter(sf, substringMatcher("B.b"), true, filterThreadsAsIs)
 	})
 
 	if !strings.Contains(out, "A.a;B.b;C.c") {
@@ -2134,7 +2183,7 @@ func TestCmdFilterNoMatch(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdFilter(sf, "Nonexistent", false)
+		cmdFilter(sf, substringMatcher("Nonexistent"), false, filterThreadsAsIs)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -2228,7 +2277,7 @@ func TestCmdHotAssertBelowFails(t *testing.T) {
 
 	// A.a is 90%, threshold 50% → should fail
 	captureOutput(func() {
-		err := cmdHot(sf, 0, false, 50.0)
+		err := cmdHot(sf, 0, false, false, 50.0)
 		if err == nil {
 			t.Error("expected assert-below error")
 		} else if !strings.Contains(err.Error(), "ASSERT FAILED") {
@@ -2245,7 +2294,7 @@ func TestCmdHotAssertBelowPasses(t *testing.T) {
 
 	// Each is 50%, threshold 90% → should pass
 	captureOutput(func() {
-		err := cmdHot(sf, 0, false, 90.0)
+		err := cmdHot(sf, 0, false, false, 90.0)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -2254,7 +2303,7 @@ func TestCmdHotAssertBelowPasses(t *testing.T) {
 
 func TestCmdHotEmpty(t *testing.T) {
 	sf := makeStackFile(nil)
-	err := cmdHot(sf, 0, false, 0)
+	err := cmdHot(sf, 0, false, false, 0)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -2484,7 +2533,9 @@ func TestExtractZipTopLevelOnly(t *testing.T) {
 
 func TestWriteSkillNew(t *testing.T) {
 	dir := t.TempDir()
-	writeSkill(dir, "claude", "test content", false, false)
+	if err := writeSkill(dir, "claude", "test content", false, false); err != nil {
+		t.Fatal(err)
+	}
 
 	path := filepath.Join(dir, ".claude", "skills", "jfr", "SKILL.md")
 	data, err := os.ReadFile(path)
@@ -2498,8 +2549,12 @@ func TestWriteSkillNew(t *testing.T) {
 
 func TestWriteSkillForce(t *testing.T) {
 	dir := t.TempDir()
-	writeSkill(dir, "claude", "original", false, false)
-	writeSkill(dir, "claude", "updated", true, false)
+	if err := writeSkill(dir, "claude", "original", false, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSkill(dir, "claude", "updated", true, false); err != nil {
+		t.Fatal(err)
+	}
 
 	path := filepath.Join(dir, ".claude", "skills", "jfr", "SKILL.md")
 	data, err := os.ReadFile(path)
@@ -2513,7 +2568,9 @@ func TestWriteSkillForce(t *testing.T) {
 
 func TestWriteSkillCodexGlobal(t *testing.T) {
 	dir := t.TempDir()
-	writeSkill(dir, "codex", "global codex", false, false)
+	if err := writeSkill(dir, "codex", "global codex", false, false); err != nil {
+		t.Fatal(err)
+	}
 
 	path := filepath.Join(dir, ".codex", "skills", "jfr", "SKILL.md")
 	data, err := os.ReadFile(path)
@@ -2527,7 +2584,9 @@ func TestWriteSkillCodexGlobal(t *testing.T) {
 
 func TestWriteSkillCodexProject(t *testing.T) {
 	dir := t.TempDir()
-	writeSkill(dir, "codex", "project codex", false, true)
+	if err := writeSkill(dir, "codex", "project codex", false, true); err != nil {
+		t.Fatal(err)
+	}
 
 	path := filepath.Join(dir, ".agents", "skills", "jfr", "SKILL.md")
 	data, err := os.ReadFile(path)
@@ -2544,7 +2603,9 @@ func TestWriteSkillCodexHome(t *testing.T) {
 	t.Setenv("CODEX_HOME", codexHome)
 
 	baseDir := t.TempDir() // home dir, should be ignored for codex global
-	writeSkill(baseDir, "codex", "codex home", false, false)
+	if err := writeSkill(baseDir, "codex", "codex home", false, false); err != nil {
+		t.Fatal(err)
+	}
 
 	path := filepath.Join(codexHome, "skills", "jfr", "SKILL.md")
 	data, err := os.ReadFile(path)
@@ -2566,7 +2627,7 @@ func TestCmdTreeNoMatch(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTree(sf, "Nonexistent", 4, 1.0)
+		cmdTree(sf, substringMatcher("Nonexistent"), 4, 1.0, false, 0)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -2580,7 +2641,7 @@ func TestCmdCallersNoMatch(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdCallers(sf, "Nonexistent", 4, 1.0)
+		cmdCallers(sf, substringMatcher("Nonexistent"), 4, 1.0, false, 0, false)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -2592,7 +2653,7 @@ func TestCmdTreeEmpty(t *testing.T) {
 	sf := makeStackFile(nil)
 
 	out := captureOutput(func() {
-		cmdTree(sf, "A.a", 4, 1.0)
+		cmdTree(sf, substringMatcher("A.a"), 4, 1.0, false, 0)
 	})
 
 	if !strings.Contains(out, "no samples") {
@@ -2604,7 +2665,7 @@ func TestCmdCallersEmpty(t *testing.T) {
 	sf := makeStackFile(nil)
 
 	out := captureOutput(func() {
-		cmdCallers(sf, "A.a", 4, 1.0)
+		cmdCallers(sf, substringMatcher("A.a"), 4, 1.0, false, 0, false)
 	})
 
 	if !strings.Contains(out, "no samples") {
@@ -2619,7 +2680,7 @@ func TestPrintTreeMultipleMatches(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTree(sf, "run", 4, 0.0)
+		cmdTree(sf, substringMatcher("run"), 4, 0.0, false, 0)
 	})
 
 	if !strings.Contains(out, "matched 2 methods") {
@@ -2633,7 +2694,7 @@ func TestPrintTreeMaxDepth(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTree(sf, "A.a", 2, 0.0)
+		cmdTree(sf, substringMatcher("A.a"), 2, 0.0, false, 0)
 	})
 
 	if !strings.Contains(out, "A.a") {
@@ -2654,7 +2715,7 @@ func TestPrintTreeMinPct(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTree(sf, "A.a", 4, 5.0) // A.a is 1% of 100, below 5% threshold
+		cmdTree(sf, substringMatcher("A.a"), 4, 5.0, false, 0) // A.a is 1% of 100, below 5% threshold
 	})
 
 	if !strings.Contains(out, "no stacks matching") || strings.Contains(out, "B.b") {
@@ -2680,7 +2741,7 @@ func TestCmdDiffWithTop(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdDiff(before, after, 0.1, 1, false)
+		cmdDiff(before, after, 0.1, 1, false, nil)
 	})
 
 	if !strings.Contains(out, "REGRESSION") {
@@ -2716,7 +2777,7 @@ func TestCmdDiffFQN(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdDiff(before, after, 0.1, 0, true)
+		cmdDiff(before, after, 0.1, 0, true, nil)
 	})
 
 	if !strings.Contains(out, "com.example.A.doWork") {
@@ -2733,7 +2794,7 @@ func TestCmdLinesErrorNoLineInfo(t *testing.T) {
 		{frames: []string{"A.a", "B.b"}, lines: []uint32{0, 0}, count: 10, thread: "main"},
 	})
 
-	err := cmdLines(sf, "B.b", 0, false)
+	err := cmdLines(sf, substringMatcher("B.b"), 0, false)
 	if err == nil {
 		t.Error("expected error for method with no line info")
 	} else if !strings.Contains(err.Error(), "no line info") {
@@ -2749,7 +2810,7 @@ func TestCmdLinesWithTop(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdLines(sf, "A.a", 2, false)
+		cmdLines(sf, substringMatcher("A.a"), 2, false)
 	})
 
 	lines := strings.Split(strings.TrimSpace(out), "\n")
@@ -2764,7 +2825,7 @@ func TestComputeLinesWithFQN(t *testing.T) {
 		{frames: []string{"com/example/A.run"}, lines: []uint32{42}, count: 10, thread: "main"},
 	})
 
-	result, hasMethod := computeLines(sf, "A.run", 0, true)
+	result, hasMethod := computeLines(sf, substringMatcher("A.run"), 0, true)
 	if !hasMethod {
 		t.Fatal("expected hasMethod=true")
 	}
@@ -2994,7 +3055,7 @@ func TestCmdHotWithTop(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdHot(sf, 2, false, 0)
+		cmdHot(sf, 2, false, false, 0)
 	})
 
 	// Self-time section should have at most 2 entries
@@ -3070,7 +3131,7 @@ func TestCmdDiffFiltersSmallNewGone(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdDiff(before, after, 5.0, 0, false) // minDelta=5%: both new/gone are <5%, filtered
+		cmdDiff(before, after, 5.0, 0, false, nil) // minDelta=5%: both new/gone are <5%, filtered
 	})
 
 	if !strings.Contains(out, "no significant changes") {
@@ -3098,7 +3159,7 @@ func TestComputeLinesDedupWithinStack(t *testing.T) {
 		{frames: []string{"A.recurse", "A.recurse"}, lines: []uint32{42, 42}, count: 10, thread: "main"},
 	})
 
-	result, hasMethod := computeLines(sf, "A.recurse", 0, false)
+	result, hasMethod := computeLines(sf, substringMatcher("A.recurse"), 0, false)
 	if !hasMethod {
 		t.Fatal("expected hasMethod=true")
 	}
@@ -3158,7 +3219,7 @@ func TestCmdDiffTopAllCategories(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdDiff(before, after, 0.1, 1, false) // top=1: only 1 per category
+		cmdDiff(before, after, 0.1, 1, false, nil) // top=1: only 1 per category
 	})
 
 	if !strings.Contains(out, "REGRESSION") {
@@ -3187,7 +3248,7 @@ func TestPrintTreeSelfBelowMinPct(t *testing.T) {
 
 	out := captureOutput(func() {
 		// B.b self=1% is below minPct=5%, so self annotation should not show
-		cmdTree(sf, "A.a", 4, 0.1)
+		cmdTree(sf, substringMatcher("A.a"), 4, 0.1, false, 0)
 	})
 
 	if !strings.Contains(out, "A.a") {
@@ -3425,7 +3486,7 @@ func TestJFRHotCommand(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdHot(sf, 10, false, 0)
+		cmdHot(sf, 10, false, false, 0)
 	})
 	if !strings.Contains(out, "SELF") {
 		t.Errorf("expected 'SELF' in hot output, got:\n%s", out)
@@ -3442,7 +3503,7 @@ func TestJFRTreeCommand(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdTree(sf, "Workload", 4, 1.0)
+		cmdTree(sf, substringMatcher("Workload"), 4, 1.0, false, 0)
 	})
 	if !strings.Contains(out, "Workload") {
 		t.Errorf("expected 'Workload' in tree output, got:\n%s", out)
@@ -3456,7 +3517,7 @@ func TestJFRCallersCommand(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdCallers(sf, "computeStep", 4, 1.0)
+		cmdCallers(sf, substringMatcher("computeStep"), 4, 1.0, false, 0, false)
 	})
 	if !strings.Contains(out, "computeStep") {
 		t.Errorf("expected 'computeStep' in callers output, got:\n%s", out)
@@ -3486,7 +3547,7 @@ func TestJFRLinesCommand(t *testing.T) {
 	}
 
 	// Should not crash; may or may not find line info depending on profiler config
-	err = cmdLines(sf, "computeStep", 0, false)
+	err = cmdLines(sf, substringMatcher("computeStep"), 0, false)
 	// err is acceptable (no line info) — we just verify it doesn't panic
 	_ = err
 }
@@ -3544,7 +3605,7 @@ func TestCmdTreeNoMethod(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTree(sf, "", 4, 1.0) // empty method means show all from root
+		cmdTree(sf, substringMatcher(""), 4, 1.0, false, 0) // empty method means show all from root
 	})
 
 	// Should show tree starting from root
@@ -3578,7 +3639,7 @@ func TestCmdTreeNoMethodWithThreadFilter(t *testing.T) {
 	filtered := sf.filterByThread("worker-1")
 
 	out := captureOutput(func() {
-		cmdTree(filtered, "", 5, 1.0)
+		cmdTree(filtered, substringMatcher(""), 5, 1.0, false, 0)
 	})
 
 	// Should show tree for worker-1 thread only
@@ -3602,7 +3663,7 @@ func TestCmdTreeNoMethodEmpty(t *testing.T) {
 	sf := makeStackFile(nil)
 
 	out := captureOutput(func() {
-		cmdTree(sf, "", 4, 1.0)
+		cmdTree(sf, substringMatcher(""), 4, 1.0, false, 0)
 	})
 
 	if !strings.Contains(out, "no samples") {
@@ -3617,7 +3678,7 @@ func TestCmdTreeNoMethodMinPct(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTree(sf, "", 4, 10.0) // 10% threshold
+		cmdTree(sf, substringMatcher(""), 4, 10.0, false, 0) // 10% threshold
 	})
 
 	// A.main is 100%, B.hot is 95% - should show both
@@ -3640,7 +3701,7 @@ func TestCmdTreeNoMethodMaxDepth(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTree(sf, "", 3, 0.0) // max depth 3
+		cmdTree(sf, substringMatcher(""), 3, 0.0, false, 0) // max depth 3
 	})
 
 	// Should show up to depth 3
@@ -3674,7 +3735,7 @@ func TestJFRTreeNoMethod(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdTree(sf, "", 4, 5.0)
+		cmdTree(sf, substringMatcher(""), 4, 5.0, false, 0)
 	})
 
 	// Should show root-level methods (Thread.run is the common root)
@@ -3698,7 +3759,7 @@ func TestJFRTreeNoMethodWithThreadFilter(t *testing.T) {
 	filtered := sf.filterByThread("cpu-worker")
 
 	out := captureOutput(func() {
-		cmdTree(filtered, "", 5, 1.0)
+		cmdTree(filtered, substringMatcher(""), 5, 1.0, false, 0)
 	})
 
 	// Should show thread-specific call tree
@@ -3723,7 +3784,7 @@ func TestCmdTraceBasicHotPath(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	if !strings.Contains(out, "A.a") {
@@ -3753,7 +3814,7 @@ func TestCmdTraceBranching(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	if !strings.Contains(out, "A.a") {
@@ -3782,7 +3843,7 @@ func TestCmdTraceBranchingMultipleSiblings(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	if !strings.Contains(out, "+2 siblings") {
@@ -3802,7 +3863,7 @@ func TestCmdTraceDeepChain(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	for _, f := range frames {
@@ -3823,7 +3884,7 @@ func TestCmdTraceSelfTimeAnnotation(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	if !strings.Contains(out, "← self=") {
@@ -3842,7 +3903,7 @@ func TestCmdTraceMinPct(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 5.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 5.0, false, false)
 	})
 
 	if !strings.Contains(out, "A.a") {
@@ -3867,7 +3928,7 @@ func TestCmdTraceNoMatch(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "Nonexistent", 0.0, false)
+		cmdTrace(sf, substringMatcher("Nonexistent"), 0.0, false, false)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -3879,7 +3940,7 @@ func TestCmdTraceEmpty(t *testing.T) {
 	sf := makeStackFile(nil)
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	if !strings.Contains(out, "no samples") {
@@ -3895,7 +3956,7 @@ func TestCmdTraceMultipleMatches(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "run", 0.0, false)
+		cmdTrace(sf, substringMatcher("run"), 0.0, false, false)
 	})
 
 	if !strings.Contains(out, "matched 2 methods") {
@@ -3922,7 +3983,7 @@ func TestCmdTraceSingleFrameMethod(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	lines := strings.Split(strings.TrimSpace(out), "\n")
@@ -3946,7 +4007,7 @@ func TestCmdTraceEqualChildren(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	// B.b < Z.z lexicographically, so B.b should be chosen.
@@ -3970,7 +4031,7 @@ func TestCmdTraceMinPctZero(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	// Even though A.a is 0.1%, min-pct=0 should show everything.
@@ -3992,7 +4053,7 @@ func TestCmdTraceMinPctHighCutsEarly(t *testing.T) {
 
 	// A.a=50%, B.b=50%, C.c=10%. With min-pct=20%, C.c is filtered.
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 20.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 20.0, false, false)
 	})
 
 	if !strings.Contains(out, "A.a") {
@@ -4012,7 +4073,7 @@ func TestCmdTraceFQN(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "App.process", 0.0, true)
+		cmdTrace(sf, substringMatcher("App.process"), 0.0, true, false)
 	})
 
 	if !strings.Contains(out, "com.example.App.process") {
@@ -4033,7 +4094,7 @@ func TestCmdTracePercentageCorrectness(t *testing.T) {
 	// totalSamples=100, A.a=50%, B.b=50%, C.c=30%
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	if !strings.Contains(out, "[50.0%] A.a") {
@@ -4054,7 +4115,7 @@ func TestCmdTraceSelfTimeOnlyOnLeaf(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	lines := strings.Split(strings.TrimSpace(out), "\n")
@@ -4085,7 +4146,7 @@ func TestCmdTraceSelfTimeBelowMinPctHidden(t *testing.T) {
 	// B.b self=1%, A.a self=99%.
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	// B.b is 1% self. With min-pct=0 it still appears in the trace,
@@ -4097,7 +4158,7 @@ func TestCmdTraceSelfTimeBelowMinPctHidden(t *testing.T) {
 	// Now with high min-pct: trace A.a with min-pct=5. B.b is 1% so it's
 	// filtered out as a child. A.a itself is the leaf.
 	out2 := captureOutput(func() {
-		cmdTrace(sf, "A.a", 5.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 5.0, false, false)
 	})
 
 	// A.a should be the leaf with self=99%.
@@ -4116,7 +4177,7 @@ func TestCmdTraceNoSiblingAnnotationWhenSingle(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	if strings.Contains(out, "sibling") {
@@ -4135,7 +4196,7 @@ func TestCmdTraceSiblingsBelowMinPctNotCounted(t *testing.T) {
 	// With min-pct=5, C.c is below threshold → not counted as sibling.
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 5.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 5.0, false, false)
 	})
 
 	if strings.Contains(out, "sibling") {
@@ -4151,7 +4212,7 @@ func TestCmdTraceMatchAtLeaf(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "B.b", 0.0, false)
+		cmdTrace(sf, substringMatcher("B.b"), 0.0, false, false)
 	})
 
 	// B.b is the leaf in all stacks, so it should be a single-node trace.
@@ -4173,7 +4234,7 @@ func TestCmdTraceMultipleRootsWithDifferentDepths(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	if !strings.Contains(out, "A.a") {
@@ -4196,7 +4257,7 @@ func TestCmdTraceBranchingSiblingAnnotationFormat(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	// The B.b line should contain exactly: (+1 sibling, next: 30.0% C.c)
@@ -4213,7 +4274,7 @@ func TestCmdTraceLeafSummary(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	// C.c self=80/100=80.0%
@@ -4229,7 +4290,7 @@ func TestCmdTraceLeafSummaryMultipleRoots(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "run", 0.0, false)
+		cmdTrace(sf, substringMatcher("run"), 0.0, false, false)
 	})
 
 	if !strings.Contains(out, "Hottest leaf: X.x") {
@@ -4246,7 +4307,7 @@ func TestCmdTraceLeafSummaryMatchAtLeaf(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 0.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 0.0, false, false)
 	})
 
 	if !strings.Contains(out, "Hottest leaf: A.a (self=100.0%)") {
@@ -4265,7 +4326,7 @@ func TestCmdTraceLeafSummaryNoSelfTime(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "A.a", 6.0, false)
+		cmdTrace(sf, substringMatcher("A.a"), 6.0, false, false)
 	})
 
 	if !strings.Contains(out, "Hottest leaf: B.b (self=0.0%)") {
@@ -4284,7 +4345,7 @@ func TestJFRTraceCommand(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "Workload", 1.0, false)
+		cmdTrace(sf, substringMatcher("Workload"), 1.0, false, false)
 	})
 
 	// Should produce output with Workload methods.
@@ -4311,7 +4372,7 @@ func TestJFRTraceWithThreadFilter(t *testing.T) {
 	filtered := sf.filterByThread("cpu-worker")
 
 	out := captureOutput(func() {
-		cmdTrace(filtered, "Workload", 1.0, false)
+		cmdTrace(filtered, substringMatcher("Workload"), 1.0, false, false)
 	})
 
 	if !strings.Contains(out, "Workload") {
@@ -4333,7 +4394,7 @@ func TestJFRTraceWallEvent(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdTrace(sf, "Workload", 0.5, false)
+		cmdTrace(sf, substringMatcher("Workload"), 0.5, false, false)
 	})
 
 	// Wall event should have some Workload samples.
@@ -4358,10 +4419,10 @@ func TestJFRTraceMultiEventFile(t *testing.T) {
 	}
 
 	cpuOut := captureOutput(func() {
-		cmdTrace(cpuSF, "Workload", 0.5, false)
+		cmdTrace(cpuSF, substringMatcher("Workload"), 0.5, false, false)
 	})
 	wallOut := captureOutput(func() {
-		cmdTrace(wallSF, "Workload", 0.5, false)
+		cmdTrace(wallSF, substringMatcher("Workload"), 0.5, false, false)
 	})
 
 	// Both should have output.
@@ -4532,7 +4593,7 @@ func TestParsePerfCollapsed(t *testing.T) {
 
 	// Commands must work on perf data. Smoke-test hot and tree.
 	hotOut := captureOutput(func() {
-		cmdHot(sf, 5, false, 0)
+		cmdHot(sf, 5, false, false, 0)
 	})
 	if !strings.Contains(hotOut, "SELF%") {
 		t.Errorf("hot output missing header, got:\n%s", hotOut)
@@ -4542,7 +4603,7 @@ func TestParsePerfCollapsed(t *testing.T) {
 	}
 
 	treeOut := captureOutput(func() {
-		cmdTree(sf, "chacha_permute", 4, 1.0)
+		cmdTree(sf, substringMatcher("chacha_permute"), 4, 1.0, false, 0)
 	})
 	if !strings.Contains(treeOut, "chacha_permute") {
 		t.Errorf("tree output missing target method, got:\n%s", treeOut)
@@ -4726,7 +4787,7 @@ func TestCmdTreeHide(t *testing.T) {
 	hidden := sf.hideFrames(re)
 
 	out := captureOutput(func() {
-		cmdTree(hidden, "", 4, 0.0)
+		cmdTree(hidden, substringMatcher(""), 4, 0.0, false, 0)
 	})
 
 	// Framework.wrap should be gone
@@ -4749,7 +4810,7 @@ func TestCmdTreeHideWithMethod(t *testing.T) {
 	hidden := sf.hideFrames(re)
 
 	out := captureOutput(func() {
-		cmdTree(hidden, "B.process", 4, 0.0)
+		cmdTree(hidden, substringMatcher("B.process"), 4, 0.0, false, 0)
 	})
 
 	if strings.Contains(out, "Framework") {
@@ -4771,7 +4832,7 @@ func TestCmdTreeHideRemovesMethodTarget(t *testing.T) {
 	hidden := sf.hideFrames(re)
 
 	out := captureOutput(func() {
-		cmdTree(hidden, "Target.run", 4, 0.0)
+		cmdTree(hidden, substringMatcher("Target.run"), 4, 0.0, false, 0)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -4788,7 +4849,7 @@ func TestCmdTreeHideDepthBenefit(t *testing.T) {
 
 	// Without hide, depth=3 from root shows A.main→Framework.wrap→B.process but not C.work
 	outBefore := captureOutput(func() {
-		cmdTree(sf, "", 3, 0.0)
+		cmdTree(sf, substringMatcher(""), 3, 0.0, false, 0)
 	})
 	if strings.Contains(outBefore, "C.work") {
 		t.Skip("C.work visible at depth=3 without hide; depth accounting changed")
@@ -4798,7 +4859,7 @@ func TestCmdTreeHideDepthBenefit(t *testing.T) {
 	re := regexp.MustCompile("Framework")
 	hidden := sf.hideFrames(re)
 	outAfter := captureOutput(func() {
-		cmdTree(hidden, "", 3, 0.0)
+		cmdTree(hidden, substringMatcher(""), 3, 0.0, false, 0)
 	})
 	if !strings.Contains(outAfter, "C.work") {
 		t.Errorf("expected C.work reachable at depth=3 after hide, got:\n%s", outAfter)
@@ -4813,7 +4874,7 @@ func TestCmdTreeHideAllStacksFullyHidden(t *testing.T) {
 	hidden := sf.hideFrames(re)
 
 	out := captureOutput(func() {
-		cmdTree(hidden, "", 4, 0.0)
+		cmdTree(hidden, substringMatcher(""), 4, 0.0, false, 0)
 	})
 
 	// totalSamples > 0 but no stacks → "no stacks matching '(all)'"
@@ -4857,7 +4918,7 @@ func TestCmdTraceHide(t *testing.T) {
 	hidden := sf.hideFrames(re)
 
 	out := captureOutput(func() {
-		cmdTrace(hidden, "B.process", 0.0, false)
+		cmdTrace(hidden, substringMatcher("B.process"), 0.0, false, false)
 	})
 
 	if strings.Contains(out, "Wrap") {
@@ -4876,7 +4937,7 @@ func TestCmdCallersHide(t *testing.T) {
 	hidden := sf.hideFrames(re)
 
 	out := captureOutput(func() {
-		cmdCallers(hidden, "C.work", 4, 0.0)
+		cmdCallers(hidden, substringMatcher("C.work"), 4, 0.0, false, 0, false)
 	})
 
 	if strings.Contains(out, "Wrap") {
@@ -4895,7 +4956,7 @@ func TestCmdTraceHideRemovesTarget(t *testing.T) {
 	hidden := sf.hideFrames(re)
 
 	out := captureOutput(func() {
-		cmdTrace(hidden, "Target.run", 0.0, false)
+		cmdTrace(hidden, substringMatcher("Target.run"), 0.0, false, false)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -4914,7 +4975,7 @@ func TestNoMatchSuggestions(t *testing.T) {
 
 	// "Appp" (typo) fuzzy-matches "App" segment with edit distance 1.
 	out := captureOutput(func() {
-		cmdTree(sf, "Appp", 4, 0.0)
+		cmdTree(sf, substringMatcher("Appp"), 4, 0.0, false, 0)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -4935,7 +4996,7 @@ func TestNoMatchDollarHint(t *testing.T) {
 
 	// Pattern doesn't contain $, but profile has $ frames → hint about inner classes.
 	out := captureOutput(func() {
-		cmdTree(sf, "Nonexistent", 4, 0.0)
+		cmdTree(sf, substringMatcher("Nonexistent"), 4, 0.0, false, 0)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -4952,7 +5013,7 @@ func TestNoMatchDollarInPattern(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTree(sf, "Server$Handler", 4, 0.0)
+		cmdTree(sf, substringMatcher("Server$Handler"), 4, 0.0, false, 0)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -4969,7 +5030,7 @@ func TestFilterNoMatchMessage(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdFilter(sf, "Nonexistent", false)
+		cmdFilter(sf, substringMatcher("Nonexistent"), false, filterThreadsAsIs)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -4983,7 +5044,7 @@ func TestNoMatchNoSuggestions(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTree(sf, "Zzzzzzz", 4, 0.0)
+		cmdTree(sf, substringMatcher("Zzzzzzz"), 4, 0.0, false, 0)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -5090,7 +5151,7 @@ func TestSlashPatternTree(t *testing.T) {
 	})
 
 	out := captureOutput(func() {
-		cmdTree(sf, "com/example/App.process", 4, 0.0)
+		cmdTree(sf, substringMatcher("com/example/App.process"), 4, 0.0, false, 0)
 	})
 
 	if !strings.Contains(out, "App.process") {
@@ -5108,7 +5169,7 @@ func TestSlashPatternSuggestions(t *testing.T) {
 
 	// FQN pattern with typo should get suggestions via full-name comparison.
 	out := captureOutput(func() {
-		cmdTree(sf, "com/example/Appp.process", 4, 0.0)
+		cmdTree(sf, substringMatcher("com/example/Appp.process"), 4, 0.0, false, 0)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -5187,7 +5248,7 @@ func TestTimelineNoMatchMethod(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 5, "", "Nonexistent", true, false, nil, "", -1, -1, 0, false)
+		cmdTimeline(parsed, "cpu", 5, "", substringMatcher("Nonexistent"), true, false, nil, "", -1, -1, 0, false)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -5216,7 +5277,7 @@ func TestTimelineNoMatchAfterThreadFilter(t *testing.T) {
 
 	out := captureOutput(func() {
 		// Filter to "http" thread, search for "Worker" — should not suggest Worker.
-		cmdTimeline(parsed, "cpu", 5, "", "Worker", true, false, nil, "http", -1, -1, 0, false)
+		cmdTimeline(parsed, "cpu", 5, "", substringMatcher("Worker"), true, false, nil, "http", -1, -1, 0, false)
 	})
 
 	if !strings.Contains(out, "no stacks matching") {
@@ -5231,7 +5292,7 @@ func TestTimelineNoMatchAfterThreadFilter(t *testing.T) {
 	// Positive case: typo on a method that IS in the filtered view should suggest it.
 	out2 := captureOutput(func() {
 		// Filter to "http" thread, search for "Htpp" (typo) — should suggest Http methods.
-		cmdTimeline(parsed, "cpu", 5, "", "Htpp", true, false, nil, "http", -1, -1, 0, false)
+		cmdTimeline(parsed, "cpu", 5, "", substringMatcher("Htpp"), true, false, nil, "http", -1, -1, 0, false)
 	})
 	if !strings.Contains(out2, "similar:") {
 		t.Errorf("expected suggestions from filtered events for typo 'Htpp', got:\n%s", out2)
@@ -5246,7 +5307,7 @@ func TestCmdLinesEmpty(t *testing.T) {
 
 	var err error
 	out := captureOutput(func() {
-		err = cmdLines(sf, "A.a", 0, false)
+		err = cmdLines(sf, substringMatcher("A.a"), 0, false)
 	})
 
 	if err != nil {
@@ -5261,7 +5322,7 @@ func TestCmdFilterEmpty(t *testing.T) {
 	sf := makeStackFile(nil)
 
 	out := captureOutput(func() {
-		cmdFilter(sf, "A.a", false)
+		cmdFilter(sf, substringMatcher("A.a"), false, filterThreadsAsIs)
 	})
 
 	if !strings.Contains(out, "no samples") {
@@ -5632,7 +5693,7 @@ func TestCmdTimeline(t *testing.T) {
 		t.Fatalf("parseJFRData: %v", err)
 	}
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 5, "", "", false, false, nil, "", -1, -1, 0, false)
+		cmdTimeline(parsed, "cpu", 5, "", substringMatcher(""), false, false, nil, "", -1, -1, 0, false)
 	})
 	if !strings.Contains(out, "Duration:") {
 		t.Error("expected Duration in header")
@@ -5670,7 +5731,7 @@ func TestCmdTimelineMethod(t *testing.T) {
 		t.Fatalf("parseJFRData: %v", err)
 	}
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 5, "", "Workload", false, false, nil, "", -1, -1, 0, false)
+		cmdTimeline(parsed, "cpu", 5, "", substringMatcher("Workload"), false, false, nil, "", -1, -1, 0, false)
 	})
 	if !strings.Contains(out, "Matched:") {
 		t.Errorf("expected 'Matched:' in header with --method, got %q", out)
@@ -5687,7 +5748,7 @@ func TestCmdTimelineTopMethod(t *testing.T) {
 		t.Fatalf("parseJFRData: %v", err)
 	}
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 5, "", "", true, false, nil, "", -1, -1, 0, false)
+		cmdTimeline(parsed, "cpu", 5, "", substringMatcher(""), true, false, nil, "", -1, -1, 0, false)
 	})
 	if !strings.Contains(out, "Hot Method (self)") {
 		t.Error("expected 'Hot Method (self)' column header")
@@ -5716,7 +5777,7 @@ func TestCmdTimelineTopMethodAggregatesLeafSelfCounts(t *testing.T) {
 		spanNanos: 1_000_000_000,
 	}
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 1, "", "", true, false, nil, "", -1, -1, 0, false)
+		cmdTimeline(parsed, "cpu", 1, "", substringMatcher(""), true, false, nil, "", -1, -1, 0, false)
 	})
 
 	// X=6, Y=8, total=14 => Y is top at 57%.
@@ -5742,7 +5803,7 @@ func TestCmdTimelineHide(t *testing.T) {
 	}
 	hide := regexp.MustCompile("^X$")
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 1, "", "", true, false, hide, "", -1, -1, 0, false)
+		cmdTimeline(parsed, "cpu", 1, "", substringMatcher(""), true, false, hide, "", -1, -1, 0, false)
 	})
 
 	// X must not appear as hot method.
@@ -5764,7 +5825,7 @@ func TestCmdTimelineZeroSpan(t *testing.T) {
 		spanNanos: 0,
 	}
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 0, "", "", false, false, nil, "", -1, -1, 0, false)
+		cmdTimeline(parsed, "cpu", 0, "", substringMatcher(""), false, false, nil, "", -1, -1, 0, false)
 	})
 	if !strings.Contains(out, "Buckets: 1") {
 		t.Errorf("expected 1 bucket for zero-span, got %q", out)
@@ -5797,7 +5858,7 @@ func TestCmdTimelineResolution(t *testing.T) {
 		t.Fatalf("parseJFRData: %v", err)
 	}
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 0, "1s", "", false, false, nil, "", -1, -1, 0, false)
+		cmdTimeline(parsed, "cpu", 0, "1s", substringMatcher(""), false, false, nil, "", -1, -1, 0, false)
 	})
 	if !strings.Contains(out, "1.0s each") {
 		t.Errorf("expected '1.0s each' in header, got %q", out)
@@ -5814,7 +5875,7 @@ func TestCmdTimelineFromTo(t *testing.T) {
 		t.Fatalf("parseJFRData: %v", err)
 	}
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 5, "", "", false, false, nil, "",
+		cmdTimeline(parsed, "cpu", 5, "", substringMatcher(""), false, false, nil, "",
 			1_000_000_000, 3_000_000_000, 0, false)
 	})
 	// Duration header should show the window span (2s), not full recording.
@@ -5840,7 +5901,7 @@ func TestCmdTimelineFromOnly(t *testing.T) {
 		t.Fatalf("parseJFRData: %v", err)
 	}
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 5, "", "", false, false, nil, "",
+		cmdTimeline(parsed, "cpu", 5, "", substringMatcher(""), false, false, nil, "",
 			1_000_000_000, -1, 0, false)
 	})
 	// Bucket origin should start at 1s.
@@ -6163,7 +6224,7 @@ func TestTimelineFromBeyondSpanClampsToZero(t *testing.T) {
 		spanNanos: 5_000_000_000,
 	}
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 0, "", "", false, false, nil, "",
+		cmdTimeline(parsed, "cpu", 0, "", substringMatcher(""), false, false, nil, "",
 			100_000_000_000, -1, 0, false)
 	})
 	// Should produce a single bucket (zero span), not negative span confusion.
@@ -6192,7 +6253,7 @@ func TestTimelineFromToBothBeyondSpan(t *testing.T) {
 		toNanos = parsed.spanNanos
 	}
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 0, "", "", false, false, nil, "",
+		cmdTimeline(parsed, "cpu", 0, "", substringMatcher(""), false, false, nil, "",
 			fromNanos, toNanos, 0, false)
 	})
 	if !strings.Contains(out, "Buckets: 1") {
@@ -6217,7 +6278,7 @@ func TestCmdTimelineSubSecondResolutionLabels(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 0, "1ms", "", false, false, nil, "",
+		cmdTimeline(parsed, "cpu", 0, "1ms", substringMatcher(""), false, false, nil, "",
 			284_000_000_000, 284_003_000_000, 0, false)
 	})
 
@@ -6268,6 +6329,42 @@ func TestFormatBucketWidth(t *testing.T) {
 	}
 }
 
+func TestFormatTimeRange(t *testing.T) {
+	start := time.Date(2026, 3, 1, 22, 59, 30, 0, time.UTC)
+	prague, err := time.LoadLocation("Europe/Prague")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	tests := []struct {
+		span time.Duration
+		loc  *time.Location
+		want string
+	}{
+		{20 * time.Second, nil, "2026-03-01T22:59:30Z to 2026-03-01T22:59:50Z"},
+		{20 * time.Second, time.UTC, "2026-03-01T22:59:30Z to 2026-03-01T22:59:50Z"},
+		{20 * time.Second, prague, "2026-03-01T22:59:30Z to 2026-03-01T22:59:50Z (local: Sun 1 Mar 2026 23:59:30 to 23:59:50 CET)"},
+		// The local end repeats the date once it falls on the next day.
+		{time.Minute, prague, "2026-03-01T22:59:30Z to 2026-03-01T23:00:30Z (local: Sun 1 Mar 2026 23:59:30 to Mon 2 Mar 2026 00:00:30 CET)"},
+	}
+	for _, tt := range tests {
+		if got := formatTimeRange(start, tt.span, tt.loc); got != tt.want {
+			t.Errorf("formatTimeRange(%v, %v) = %q, want %q", tt.span, tt.loc, got, tt.want)
+		}
+	}
+}
+
+func TestRecordingTimesCLI(t *testing.T) {
+	t.Setenv("TZ", "America/New_York")
+	_, stdout, _ := runCLIForTest(t, []string{"info", jfrFixture("cpu.jfr")}, nil)
+	if !strings.Contains(stdout, "Recorded: 2026-02-14T00:41:47Z to 2026-02-14T00:41:52Z (local: Fri 13 Feb 2026 19:41:47 to 19:41:52 EST)\n") {
+		t.Errorf("info: missing UTC and local recording times:\n%s", stdout)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"timeline", jfrFixture("cpu.jfr"), "--from", "1s", "--to", "2s", "--utc"}, nil)
+	if !strings.Contains(stdout, "Span: 2026-02-14T00:41:48Z to 2026-02-14T00:41:49Z\n") {
+		t.Errorf("timeline --utc: missing UTC window:\n%s", stdout)
+	}
+}
+
 func TestTimeWindowEcho(t *testing.T) {
 	// --from/--to on non-timeline JFR command should echo window to stderr.
 	tests := []struct {
@@ -6507,7 +6604,7 @@ func TestCmdThreadsGroupFalse(t *testing.T) {
 // ---------------------------------------------------------------------------
 
 func TestPerCommandHelp(t *testing.T) {
-	commands := []string{"hot", "tree", "trace", "callers", "threads", "filter", "events", "collapse", "diff", "lines", "info", "timeline"}
+	commands := []string{"hot", "tree", "trace", "callers", "threads", "filter", "events", "collapse", "diff", "trend", "lines", "info", "timeline", "methods"}
 	for _, cmd := range commands {
 		t.Run(cmd, func(t *testing.T) {
 			code, stdout, _ := runCLIForTest(t, []string{cmd, "--help"}, nil)
@@ -6543,8 +6640,8 @@ func TestScriptHelpStillWorks(t *testing.T) {
 
 func TestUnknownCommandHelp(t *testing.T) {
 	code, _, stderr := runCLIForTest(t, []string{"nonexistent", "--help"}, nil)
-	if code != 1 {
-		t.Errorf("nonexistent --help exit code = %d, want 1", code)
+	if code != exitUsage {
+		t.Errorf("nonexistent --help exit code = %d, want %d", code, exitUsage)
 	}
 	if !strings.Contains(stderr, "unknown command") {
 		t.Errorf("nonexistent --help should mention unknown command, got:\n%s", stderr)
@@ -6606,7 +6703,7 @@ func TestToNegativeDuration(t *testing.T) {
 func TestNoIdleHintCollapsed(t *testing.T) {
 	// Construct collapsed input with >50% idle leaf frames (wall event type).
 	input := strings.NewReader("[main];A.main;java.lang.Thread.sleep 60\n[main];A.main;B.work 40\n")
-	_, _, stderr := runCLIForTest(t, []string{"hot", "--event", "wall", "-"}, input)
+	_, _, stderr := runCLIForTest(t, []string{"hot", "--event", "wall", "--include-vm", "-"}, input)
 	if !strings.Contains(stderr, "Hint:") {
 		t.Errorf("expected idle hint for wall profile with >50%% idle, got stderr:\n%s", stderr)
 	}
@@ -6615,6 +6712,54 @@ func TestNoIdleHintCollapsed(t *testing.T) {
 	}
 }
 
+func TestHotExcludesIdleWall(t *testing.T) {
+	input := "[event=wall];[main];A.main;java.lang.Thread.sleep 60\n" +
+		"[event=wall];[main];A.main;libc.so.6.__futex_abstimed_wait_cancelable64;libc.so.6.__internal_syscall_cancel;libc.so.6.__syscall_cancel_arch_end 20\n" +
+		"[event=wall];[main];A.main;B.work 20\n"
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", "-", "--event", "wall"}, strings.NewReader(input))
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if strings.Contains(stdout, "Thread.sleep") || strings.Contains(stdout, "__syscall_cancel") || !strings.Contains(stdout, "B.work") {
+		t.Errorf("idle frames ranked:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, "Excluded 80 idle wall samples (80.0% of 100") || strings.Contains(stderr, "Hint:") {
+		t.Errorf("missing footer or stray hint:\nstdout:\n%s\nstderr:\n%s", stdout, stderr)
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"hot", "-", "--event", "wall", "--include-vm"}, strings.NewReader(input))
+	if !strings.Contains(stdout, "Thread.sleep") || strings.Contains(stdout, "Excluded") {
+		t.Errorf("--include-vm: unexpected output:\n%s", stdout)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"hot", "-", "--event", "wall", "--no-idle"}, strings.NewReader(input))
+	if strings.Contains(stdout, "Thread.sleep") || strings.Contains(stdout, "Excluded") {
+		t.Errorf("--no-idle: unexpected output:\n%s", stdout)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"hot", "-", "--event", "cpu"}, strings.NewReader(strings.ReplaceAll(input, "=wall]", "=cpu]")))
+	if !strings.Contains(stdout, "Thread.sleep") {
+		t.Errorf("cpu samples should not be filtered:\n%s", stdout)
+	}
+}
+
+func TestIsIdleStack(t *testing.T) {
+	tests := []struct {
+		frames []string
+		want   bool
+	}{
+		{[]string{"A.a", "java/lang/Object.wait0"}, true},
+		{[]string{"A.a", "libc.so.6.__futex_abstimed_wait_cancelable64", "libc.so.6.__internal_syscall_cancel", "libc.so.6.__syscall_cancel_arch_start"}, true},
+		{[]string{"A.a", "B.read", "libc.so.6.__syscall_cancel_arch_end"}, false},
+		{[]string{"libc.so.6.__syscall_cancel_arch_end"}, false},
+		{[]string{"A.a", "clock_nanosleep"}, true},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := isIdleStack(tt.frames); got != tt.want {
+			t.Errorf("isIdleStack(%v) = %v, want %v", tt.frames, got, tt.want)
+		}
+	}
+}
+
 func TestNoIdleHintCollapsedWithNoIdle(t *testing.T) {
 	input := strings.NewReader("[main];A.main;java.lang.Thread.sleep 60\n[main];A.main;B.work 40\n")
 	_, _, stderr := runCLIForTest(t, []string{"hot", "--event", "wall", "--no-idle", "-"}, input)
@@ -6656,7 +6801,7 @@ func TestCmdTimelineTop(t *testing.T) {
 		t.Fatalf("parseJFRData: %v", err)
 	}
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 10, "", "", false, false, nil, "", -1, -1, 3, false)
+		cmdTimeline(parsed, "cpu", 10, "", substringMatcher(""), false, false, nil, "", -1, -1, 3, false)
 	})
 	if !strings.Contains(out, "Top: 3") {
 		t.Errorf("expected 'Top: 3' in header, got:\n%s", out)
@@ -6698,7 +6843,7 @@ func TestCmdTimelineTopExceedsBuckets(t *testing.T) {
 	}
 	// --top 100 with only 5 buckets: should show all non-empty buckets.
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 5, "", "", false, false, nil, "", -1, -1, 100, false)
+		cmdTimeline(parsed, "cpu", 5, "", substringMatcher(""), false, false, nil, "", -1, -1, 100, false)
 	})
 	lines := strings.Split(strings.TrimSpace(out), "\n")
 	dataLines := 0
@@ -6726,7 +6871,7 @@ func TestCmdTimelinePct(t *testing.T) {
 		t.Fatalf("parseJFRData: %v", err)
 	}
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 5, "", "Workload", false, false, nil, "", -1, -1, 0, true)
+		cmdTimeline(parsed, "cpu", 5, "", substringMatcher("Workload"), false, false, nil, "", -1, -1, 0, true)
 	})
 	if !strings.Contains(out, "Pct") {
 		t.Errorf("expected 'Pct' column header, got:\n%s", out)
@@ -6759,7 +6904,7 @@ func TestCmdTimelinePctEmptyBucket(t *testing.T) {
 	}
 	// Use a method that won't match in all buckets + many buckets to ensure some are empty.
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 40, "", "Workload", false, false, nil, "", -1, -1, 0, true)
+		cmdTimeline(parsed, "cpu", 40, "", substringMatcher("Workload"), false, false, nil, "", -1, -1, 0, true)
 	})
 	// Should not panic or produce NaN/Inf. All percentage values should be valid.
 	if strings.Contains(out, "NaN") || strings.Contains(out, "Inf") {
@@ -6805,7 +6950,7 @@ func TestCmdTimelineTopWithPct(t *testing.T) {
 		t.Fatalf("parseJFRData: %v", err)
 	}
 	out := captureOutput(func() {
-		cmdTimeline(parsed, "cpu", 10, "", "Workload", false, false, nil, "", -1, -1, 3, true)
+		cmdTimeline(parsed, "cpu", 10, "", substringMatcher("Workload"), false, false, nil, "", -1, -1, 3, true)
 	})
 	if !strings.Contains(out, "Top: 3") {
 		t.Errorf("expected 'Top: 3' in header, got:\n%s", out)
@@ -7480,3 +7625,6046 @@ func TestDiffCpuVsBranchMissesExplicitBranchMisses(t *testing.T) {
 		t.Errorf("expected NEW entries (no branch-misses in before), got:\n%s", stdout)
 	}
 }
+
+// ---------------------------------------------------------------------------
+// methods command
+// ---------------------------------------------------------------------------
+
+func TestComputeMethods(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"com/a/App.main", "com/a/Worker.process", "java/util/HashMap.resize"}, lines: []uint32{0, 0, 0}, count: 6},
+		{frames: []string{"com/a/App.main", "com/b/Parser.process"}, lines: []uint32{0, 0}, count: 3},
+		{frames: []string{"com/a/App.main", "com/a/Worker.idle"}, lines: []uint32{0, 0}, count: 1},
+	})
+
+	tests := []struct {
+		pattern string
+		want    []hotEntry
+	}{
+		{"process", []hotEntry{
+			{"com.a.Worker.process", 0, 6},
+			{"com.b.Parser.process", 3, 3},
+		}},
+		{"Worker", []hotEntry{
+			{"com.a.Worker.process", 0, 6},
+			{"com.a.Worker.idle", 1, 1},
+		}},
+		{"java/util", []hotEntry{
+			{"java.util.HashMap.resize", 6, 6},
+		}},
+		{"", []hotEntry{
+			{"com.a.App.main", 0, 10},
+			{"com.a.Worker.process", 0, 6},
+			{"java.util.HashMap.resize", 6, 6},
+			{"com.b.Parser.process", 3, 3},
+			{"com.a.Worker.idle", 1, 1},
+		}},
+		{"Nonexistent", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got := computeMethods(sf, substringMatcher(tt.pattern))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d entries %v, want %d %v", len(got), got, len(tt.want), tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("entry %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCmdMethods(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"com/a/Worker.process", "java/util/HashMap.resize"}, lines: []uint32{0, 0}, count: 6},
+		{frames: []string{"com/b/Parser.process"}, lines: []uint32{0}, count: 4},
+	})
+
+	out := captureOutput(func() { cmdMethods(sf, substringMatcher("process"), 0, false) })
+	if !strings.Contains(out, "METHOD") || !strings.Contains(out, "TOTAL%") {
+		t.Errorf("expected header, got:\n%s", out)
+	}
+	if !strings.Contains(out, "com.a.Worker.process") || !strings.Contains(out, "com.b.Parser.process") {
+		t.Errorf("expected both FQN methods, got:\n%s", out)
+	}
+	if strings.Contains(out, "HashMap") {
+		t.Errorf("HashMap.resize should not match 'process', got:\n%s", out)
+	}
+
+	out = captureOutput(func() { cmdMethods(sf, substringMatcher("process"), 1, false) })
+	if !strings.Contains(out, "(1 of 2 methods shown)") {
+		t.Errorf("expected truncation footer, got:\n%s", out)
+	}
+
+	out = captureOutput(func() { cmdMethods(sf, substringMatcher("proces"), 0, false) })
+	if !strings.Contains(out, "com.a.Worker.process") {
+		t.Errorf("expected substring match, got:\n%s", out)
+	}
+
+	out = captureOutput(func() { cmdMethods(sf, substringMatcher("Nonexistent"), 0, false) })
+	if !strings.Contains(out, "no stacks matching 'Nonexistent'") {
+		t.Errorf("expected no-match message, got:\n%s", out)
+	}
+
+	out = captureOutput(func() { cmdMethods(makeStackFile(nil), substringMatcher("process"), 0, false) })
+	if !strings.Contains(out, "no samples") {
+		t.Errorf("expected 'no samples' message, got:\n%s", out)
+	}
+}
+
+func TestMethodsCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"methods", jfrFixture("cpu.jfr"), "Workload.lock"}, nil)
+	if code != 0 {
+		t.Fatalf("expected exit 0, got %d; stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "Workload.lockWork") || !strings.Contains(stdout, "Workload.lockStep") {
+		t.Errorf("expected lockWork and lockStep, got:\n%s", stdout)
+	}
+
+	code, stdout, _ = runCLIForTest(t, []string{"methods", "-"}, strings.NewReader("A.a;B.b 3\nA.a;C.c 2\n"))
+	if code != 0 {
+		t.Fatalf("expected exit 0 for stdin without pattern, got %d", code)
+	}
+	for _, m := range []string{"A.a", "B.b", "C.c"} {
+		if !strings.Contains(stdout, m) {
+			t.Errorf("expected %s when no pattern given, got:\n%s", m, stdout)
+		}
+	}
+
+	code, _, _ = runCLIForTest(t, []string{"methods"}, nil)
+	if code == 0 {
+		t.Error("expected non-zero exit without file argument")
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Method disambiguation (--index / --pick)
+// ---------------------------------------------------------------------------
+
+func TestMethodMatcherExact(t *testing.T) {
+	tests := []struct {
+		frame   string
+		pattern string
+		exact   bool
+		want    bool
+	}{
+		{"com/a/Foo.run", "Foo.run", false, true},
+		{"com/a/Foo.runAll", "Foo.run", false, true},
+		{"com/a/Foo.run", "com.a.Foo.run", true, true},
+		{"com/a/Foo.run", "com/a/Foo.run", true, true},
+		{"com/a/Foo.run", "Foo.run", true, true},
+		{"com/a/Foo.runAll", "com.a.Foo.run", true, false},
+		{"com/a/Foo.runAll", "Foo.run", true, false},
+		{"com/b/Foo.run", "com.a.Foo.run", true, false},
+	}
+	for _, tt := range tests {
+		m := methodMatcher{pattern: tt.pattern, exact: tt.exact}
+		if got := m.matches(tt.frame); got != tt.want {
+			t.Errorf("%+v.matches(%q) = %v, want %v", m, tt.frame, got, tt.want)
+		}
+	}
+}
+
+func TestMethodFlagsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   methodFlags
+		wantErr string
+	}{
+		{"plain", methodFlags{method: "run"}, ""},
+		{"index", methodFlags{method: "run", index: 2}, ""},
+		{"pick", methodFlags{method: "run", pick: true}, ""},
+		{"negative index", methodFlags{method: "run", index: -1}, "--index must be positive"},
+		{"both", methodFlags{method: "run", index: 1, pick: true}, "mutually exclusive"},
+		{"index without method", methodFlags{index: 1}, "--index requires -m"},
+		{"pick without method", methodFlags{pick: true}, "--pick requires -m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.flags.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func disambiguationStackFile() *stackFile {
+	return makeStackFile([]stack{
+		{frames: []string{"com/a/Foo.run", "A.leaf"}, lines: []uint32{0, 0}, count: 6},
+		{frames: []string{"com/a/Foo.runAll", "B.leaf"}, lines: []uint32{0, 0}, count: 3},
+		{frames: []string{"com/b/Bar.run", "C.leaf"}, lines: []uint32{0, 0}, count: 1},
+	})
+}
+
+func TestMethodFlagsResolveIndex(t *testing.T) {
+	sf := disambiguationStackFile()
+
+	m, err := (&methodFlags{method: "run"}).resolve(sf, "x.jfr")
+	if err != nil || m.exact || m.pattern != "run" {
+		t.Fatalf("without --index: got %+v, %v; want substring matcher", m, err)
+	}
+
+	var m2 methodMatcher
+	stderr := captureStream(&os.Stderr, func() {
+		m2, err = (&methodFlags{method: "run", index: 2}).resolve(sf, "x.jfr")
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !m2.exact || m2.pattern != "com.a.Foo.runAll" {
+		t.Errorf("--index 2: got %+v, want exact com.a.Foo.runAll", m2)
+	}
+	if !strings.Contains(stderr, "Method: com.a.Foo.runAll (2 of 3 matching 'run')") {
+		t.Errorf("expected selection echo on stderr, got %q", stderr)
+	}
+
+	out := captureOutput(func() { cmdTree(sf, m2, 4, 0, false, 0) })
+	if !strings.Contains(out, "Foo.runAll") || strings.Contains(out, "A.leaf") || strings.Contains(out, "C.leaf") {
+		t.Errorf("tree should only contain the selected method, got:\n%s", out)
+	}
+
+	_, err = (&methodFlags{method: "run", index: 4}).resolve(sf, "x.jfr")
+	if err == nil || !strings.Contains(err.Error(), "--index 4 out of range") || !strings.Contains(err.Error(), "com.b.Bar.run") {
+		t.Errorf("expected out-of-range error listing candidates, got %v", err)
+	}
+
+	m, err = (&methodFlags{method: "Nonexistent", index: 1}).resolve(sf, "x.jfr")
+	if err != nil || m.exact {
+		t.Errorf("no candidates should fall back to substring matcher, got %+v, %v", m, err)
+	}
+}
+
+func TestMethodFlagsResolvePickSingleCandidate(t *testing.T) {
+	sf := disambiguationStackFile()
+	m, err := (&methodFlags{method: "Bar.run", pick: true}).resolve(sf, "-")
+	if err != nil {
+		t.Fatalf("single candidate should not prompt: %v", err)
+	}
+	if !m.exact || m.pattern != "com.b.Bar.run" {
+		t.Errorf("got %+v, want exact com.b.Bar.run", m)
+	}
+}
+
+func TestMethodFlagsResolvePickFromStdin(t *testing.T) {
+	sf := disambiguationStackFile()
+	_, err := (&methodFlags{method: "run", pick: true}).resolve(sf, "-")
+	if err == nil || !strings.Contains(err.Error(), "use --index N") {
+		t.Errorf("expected stdin profile error, got %v", err)
+	}
+}
+
+func TestPromptMethodPick(t *testing.T) {
+	candidates := computeMethods(disambiguationStackFile(), substringMatcher("run"))
+
+	var w bytes.Buffer
+	n, err := promptMethodPick(strings.NewReader("3\n"), &w, "run", candidates, 10)
+	if err != nil || n != 3 {
+		t.Fatalf("got %d, %v; want 3", n, err)
+	}
+	prompt := w.String()
+	for _, want := range []string{"'run' matches 3 methods", "1) com.a.Foo.run", "3) com.b.Bar.run", "Select [1-3]"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt missing %q:\n%s", want, prompt)
+		}
+	}
+
+	for _, input := range []string{"0\n", "4\n", "abc\n", ""} {
+		if _, err := promptMethodPick(strings.NewReader(input), io.Discard, "run", candidates, 10); err == nil {
+			t.Errorf("input %q: expected error", input)
+		}
+	}
+}
+
+func TestMethodIndexCLI(t *testing.T) {
+	input := "com/a/Foo.run;A.leaf 6\ncom/a/Foo.runAll;B.leaf 3\ncom/b/Bar.run;C.leaf 1\n"
+	for _, cmd := range []string{"tree", "trace", "callers", "filter"} {
+		t.Run(cmd, func(t *testing.T) {
+			code, stdout, stderr := runCLIForTest(t, []string{cmd, "-", "-m", "run", "--index", "3"}, strings.NewReader(input))
+			if code != 0 {
+				t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+			}
+			if !strings.Contains(stdout, "Bar.run") {
+				t.Errorf("expected selected Bar.run, got:\n%s", stdout)
+			}
+			if strings.Contains(stdout, "Foo.run") {
+				t.Errorf("other matches must not be merged, got:\n%s", stdout)
+			}
+		})
+	}
+
+	code, _, stderr := runCLIForTest(t, []string{"tree", "-", "-m", "run", "--pick"}, strings.NewReader(input))
+	if code == 0 || !strings.Contains(stderr, "--index N") {
+		t.Errorf("--pick with stdin profile should fail, got exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestMethodMatcherIgnoreCase(t *testing.T) {
+	tests := []struct {
+		frame   string
+		pattern string
+		exact   bool
+		want    bool
+	}{
+		{"com/a/HashMap.resize", "hashmap.RESIZE", false, true},
+		{"com/a/HashMap.resize", "HASHMAP", false, true},
+		{"com/a/HashMap.resize", "com/A/hashmap.resize", false, true},
+		{"com/a/HashMap.resize", "hashmap.resize", true, true},
+		{"com/a/HashMap.resize", "COM.A.HASHMAP.RESIZE", true, true},
+		{"com/a/HashMap.resizeTable", "hashmap.resize", true, false},
+		{"com/a/TreeMap.put", "hashmap", false, false},
+	}
+	for _, tt := range tests {
+		m := methodMatcher{pattern: tt.pattern, exact: tt.exact, ignoreCase: true}
+		if got := m.matches(tt.frame); got != tt.want {
+			t.Errorf("%+v.matches(%q) = %v, want %v", m, tt.frame, got, tt.want)
+		}
+	}
+	if (methodMatcher{pattern: "hashmap"}).matches("com/a/HashMap.resize") {
+		t.Error("default matcher must stay case-sensitive")
+	}
+}
+
+func TestMatchModesCLI(t *testing.T) {
+	input := "App.main;Worker.process 6\nApp.main;Worker.processAll 3\nApp.main;Parser.PROCESS 1\n"
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		notWant []string
+	}{
+		{"substring", []string{"filter", "-", "-m", "process"},
+			[]string{"Worker.process 6", "Worker.processAll 3"}, []string{"PROCESS"}},
+		{"exact", []string{"filter", "-", "-m", "Worker.process", "--exact"},
+			[]string{"Worker.process 6"}, []string{"processAll", "PROCESS"}},
+		{"ignore-case", []string{"filter", "-", "-m", "process", "--ignore-case"},
+			[]string{"Worker.process 6", "Worker.processAll 3", "Parser.PROCESS 1"}, nil},
+		{"exact ignore-case", []string{"filter", "-", "-m", "parser.process", "--exact", "--ignore-case"},
+			[]string{"Parser.PROCESS 1"}, []string{"Worker"}},
+		{"tree exact", []string{"tree", "-", "-m", "Worker.process", "--exact", "--min-pct", "0"},
+			[]string{"Worker.process"}, []string{"processAll"}},
+		{"methods ignore-case", []string{"methods", "-", "PROCESS", "--ignore-case"},
+			[]string{"Worker.process", "Worker.processAll", "Parser.PROCESS"}, nil},
+		{"methods exact", []string{"methods", "-", "Worker.process", "--exact"},
+			[]string{"Worker.process"}, []string{"processAll"}},
+		{"index ignore-case", []string{"filter", "-", "-m", "PROCESS", "--ignore-case", "--index", "3"},
+			[]string{"Parser.PROCESS 1"}, []string{"Worker"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLIForTest(t, tt.args, strings.NewReader(input))
+			if code != 0 {
+				t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(stdout, w) {
+					t.Errorf("expected %q in output:\n%s", w, stdout)
+				}
+			}
+			for _, nw := range tt.notWant {
+				if strings.Contains(stdout, nw) {
+					t.Errorf("unexpected %q in output:\n%s", nw, stdout)
+				}
+			}
+		})
+	}
+}
+
+func TestTimelineMethodExact(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"timeline", jfrFixture("cpu.jfr"), "-m", "workload.cpuwork", "--ignore-case", "--exact"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "Matched:") {
+		t.Errorf("expected Matched header, got:\n%s", stdout)
+	}
+	if strings.Contains(stdout, "Matched: 0/") {
+		t.Errorf("expected case-insensitive exact match to find samples, got:\n%s", stdout)
+	}
+}
+
+func TestBuildTreePTByThread(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"A.run", "B.work", "C.leaf"}, count: 6, thread: "pool-1-thread-1"},
+		{frames: []string{"A.run", "B.work", "C.leaf"}, count: 2, thread: "pool-1-thread-2"},
+		{frames: []string{"A.run", "B.work"}, count: 3, thread: "main"},
+		{frames: []string{"A.run", "D.other"}, count: 1},
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		want   map[string]int
+		self   map[string]int
+	}{
+		{"from root", "", map[string]int{
+			"[pool-thread]":                     8,
+			"[pool-thread];A.run;B.work;C.leaf": 8,
+			"[main]":                            3,
+			"[main];A.run;B.work":               3,
+			"[(no thread info)]":                1,
+			"[(no thread info)];A.run;D.other":  1,
+		}, map[string]int{
+			"[main];A.run;B.work":              3,
+			"[(no thread info)];A.run;D.other": 1,
+		}},
+		{"with method", "B.work", map[string]int{
+			"[pool-thread]":               8,
+			"[pool-thread];B.work":        8,
+			"[pool-thread];B.work;C.leaf": 8,
+			"[main]":                      3,
+			"[main];B.work":               3,
+		}, map[string]int{
+			"[main];B.work": 3,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pt := buildTreePTByThread(sf, substringMatcher(tt.method))
+			for k, v := range tt.want {
+				if pt.samples[k] != v {
+					t.Errorf("samples[%q] = %d, want %d", k, pt.samples[k], v)
+				}
+			}
+			for k, v := range tt.self {
+				if pt.selfSamples[k] != v {
+					t.Errorf("selfSamples[%q] = %d, want %d", k, pt.selfSamples[k], v)
+				}
+			}
+			if tt.method != "" {
+				if _, ok := pt.samples["[(no thread info)]"]; ok {
+					t.Error("group without matching stacks should not appear")
+				}
+			}
+			if pt.totalSamples != sf.totalSamples {
+				t.Errorf("totalSamples = %d, want %d", pt.totalSamples, sf.totalSamples)
+			}
+		})
+	}
+}
+
+func TestTreeByThreadCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"tree", jfrFixture("cpu.jfr"), "--by-thread", "-m", "lockStep", "--depth", "1"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "] [lock-worker]") {
+		t.Errorf("expected thread-group root, got:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, "Workload.lockStep") {
+		t.Errorf("expected method below group root despite --depth 1, got:\n%s", stdout)
+	}
+	if strings.Contains(stdout, "complete_monitor_locking") {
+		t.Errorf("--depth 1 should stop at the method, got:\n%s", stdout)
+	}
+}
+
+func TestComputeCollapsed(t *testing.T) {
+	tests := []struct {
+		name   string
+		stacks []stack
+		want   []string
+	}{
+		{
+			name: "sorted by count then text",
+			stacks: []stack{
+				{frames: []string{"B.b"}, count: 2},
+				{frames: []string{"A.a", "C.c"}, count: 5, thread: "main"},
+				{frames: []string{"A.a"}, count: 2},
+			},
+			want: []string{"[main];A.a;C.c 5", "A.a 2", "B.b 2"},
+		},
+		{
+			name: "line-number-only differences merged",
+			stacks: []stack{
+				{frames: []string{"A.a", "B.b"}, lines: []uint32{10, 20}, count: 3},
+				{frames: []string{"A.a", "B.b"}, lines: []uint32{11, 20}, count: 4},
+				{frames: []string{"A.a", "B.b"}, lines: []uint32{10, 20}, count: 1, thread: "t1"},
+			},
+			want: []string{"A.a;B.b 7", "[t1];A.a;B.b 1"},
+		},
+		{
+			name: "empty",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range computeCollapsed(makeStackFile(tt.stacks)) {
+				got = append(got, fmt.Sprintf("%s %d", e.key, e.count))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCollapseCLIDeterministic(t *testing.T) {
+	var first string
+	for i := 0; i < 3; i++ {
+		code, stdout, stderr := runCLIForTest(t, []string{"collapse", jfrFixture("cpu.jfr")}, nil)
+		if code != 0 {
+			t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+		}
+		if i == 0 {
+			first = stdout
+			continue
+		}
+		if stdout != first {
+			t.Fatal("collapse output differs between runs")
+		}
+	}
+}
+
+func TestStackAgg(t *testing.T) {
+	type sample struct {
+		key    string
+		weight int
+	}
+	tests := []struct {
+		name      string
+		maxStacks int
+		samples   []sample
+		want      map[string]int
+		approx    bool
+		maxError  int
+	}{
+		{
+			name:      "unbounded",
+			maxStacks: 0,
+			samples:   []sample{{"a", 1}, {"b", 2}, {"a", 3}, {"c", 1}},
+			want:      map[string]int{"a": 4, "b": 2, "c": 1},
+		},
+		{
+			name:      "bounded without overflow",
+			maxStacks: 3,
+			samples:   []sample{{"a", 1}, {"b", 2}, {"a", 3}, {"c", 1}},
+			want:      map[string]int{"a": 4, "b": 2, "c": 1},
+		},
+		{
+			name:      "evicts lightest",
+			maxStacks: 2,
+			samples:   []sample{{"a", 5}, {"b", 1}, {"c", 2}},
+			want:      map[string]int{"a": 5, "c": 3},
+			approx:    true,
+			maxError:  1,
+		},
+		{
+			name:      "heavy hitter survives churn",
+			maxStacks: 2,
+			samples:   []sample{{"hot", 10}, {"x", 1}, {"y", 1}, {"z", 1}, {"hot", 10}},
+			want:      map[string]int{"hot": 20, "z": 3},
+			approx:    true,
+			maxError:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agg := newStackAgg(tt.maxStacks)
+			total := 0
+			for _, s := range tt.samples {
+				agg.add(stackKey{frames: s.key}, []string{s.key}, []uint32{0}, nil, s.weight)
+				total += s.weight
+			}
+			sf := agg.stackFile()
+			if sf.totalSamples != total {
+				t.Errorf("totalSamples = %d, want %d", sf.totalSamples, total)
+			}
+			got := make(map[string]int)
+			for _, st := range sf.stacks {
+				got[st.frames[0]] = st.count
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("count[%q] = %d, want %d", k, got[k], v)
+				}
+			}
+			if agg.approximate() != tt.approx {
+				t.Errorf("approximate() = %v, want %v", agg.approximate(), tt.approx)
+			}
+			if agg.maxError != tt.maxError {
+				t.Errorf("maxError = %d, want %d", agg.maxError, tt.maxError)
+			}
+		})
+	}
+}
+
+func TestStackCachePruner(t *testing.T) {
+	if newStackCachePruner(0) != nil {
+		t.Fatal("expected no pruner for unbounded --max-stacks")
+	}
+	const maxStacks = 3
+	pr := newStackCachePruner(maxStacks)
+	agg := newStackAgg(maxStacks)
+	aggs := map[string]*stackAgg{"cpu": agg}
+	cache := make(map[types.StackTraceRef]*cachedStackTrace)
+	roots := make(contextRoots)
+	add := func(ref types.StackTraceRef, name string, weight int) {
+		cached, ok := cache[ref]
+		if !ok {
+			cached = &cachedStackTrace{frames: []string{name}, lines: []uint32{0}, key: name}
+			cache[ref] = cached
+		}
+		agg.add(stackKey{frames: cached.key}, cached.frames, cached.lines, nil, weight)
+		pr.maybePrune(cache, roots, aggs)
+	}
+
+	add(0, "hot", 1000)
+	for i := 1; i <= 1000; i++ {
+		add(types.StackTraceRef(i), fmt.Sprintf("cold%d", i), 1)
+		if len(cache) > 2*maxStacks {
+			t.Fatalf("after %d distinct stacks the cache holds %d, want at most %d", i+1, len(cache), 2*maxStacks)
+		}
+	}
+	if _, ok := cache[0]; !ok {
+		t.Error("the heavy stack held by the sketch was pruned from the cache")
+	}
+
+	// A held context-rooted stack keeps its unrooted source cached: the
+	// sketch only knows the rooted key.
+	src := &cachedStackTrace{frames: []string{"ctx"}, lines: []uint32{0}, key: "ctx"}
+	cache[5000] = src
+	rooted := roots.apply(src, 7)
+	agg.add(stackKey{frames: rooted.key}, rooted.frames, rooted.lines, nil, 5000)
+	for i := 1001; i <= 1100; i++ {
+		add(types.StackTraceRef(i), fmt.Sprintf("cold%d", i), 1)
+	}
+	if cache[5000] != src {
+		t.Error("source of a held context-rooted stack was pruned")
+	}
+}
+
+func TestMaxStacksCLI(t *testing.T) {
+	collapsed := "A.a;B.b 5\nA.a;C.c 3\n"
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		wantCode   int
+		wantStderr string
+	}{
+		{"jfr approximated", []string{"hot", jfrFixture("cpu.jfr"), "--max-stacks", "5"}, "", 0, "kept the heaviest 5"},
+		{"jfr under limit", []string{"hot", jfrFixture("cpu.jfr"), "--max-stacks", "100000"}, "", 0, ""},
+		{"timed ignored", []string{"hot", jfrFixture("cpu.jfr"), "--max-stacks", "5", "--from", "0s"}, "", 0, "--max-stacks ignored with --from/--to"},
+		{"non-jfr ignored", []string{"hot", "-", "--max-stacks", "1"}, collapsed, 0, "--max-stacks ignored for non-JFR input"},
+		{"negative", []string{"hot", jfrFixture("cpu.jfr"), "--max-stacks", "-1"}, "", exitUsage, "--max-stacks must be non-negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, stderr := runCLIForTest(t, tt.args, strings.NewReader(tt.stdin))
+			if code != tt.wantCode {
+				t.Fatalf("exit %d, want %d, stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			if tt.wantStderr == "" {
+				if strings.Contains(stderr, "max-stacks") || strings.Contains(stderr, "kept the heaviest") {
+					t.Errorf("unexpected warning:\n%s", stderr)
+				}
+			} else if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("expected %q in stderr:\n%s", tt.wantStderr, stderr)
+			}
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KB"},
+		{300 << 20, "300.0 MB"},
+		{3 << 30, "3.0 GB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestParseProgress(t *testing.T) {
+	if p := newParseProgress(false, 10<<30); p != nil {
+		t.Error("expected nil reporter when disabled")
+	}
+	if p := newParseProgress(true, progressMinBytes-1); p != nil {
+		t.Error("expected nil reporter below size threshold")
+	}
+
+	// A nil reporter must be safe to use.
+	var nilProg *parseProgress
+	nilProg.update(1, 1)
+	nilProg.done()
+
+	var buf strings.Builder
+	p := &parseProgress{w: &buf, totalBytes: 4 << 30, start: time.Now()}
+	p.update(1<<30, 131072)
+	out := buf.String()
+	for _, want := range []string{"parsing: 1.0 GB / 4.0 GB (25%)", "131072 events"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in %q", want, out)
+		}
+	}
+	p.done()
+	if !strings.HasSuffix(buf.String(), "\r\x1b[K") {
+		t.Errorf("expected done to clear the line, got %q", buf.String())
+	}
+
+	// Within the interval, updates are throttled.
+	buf.Reset()
+	p.interval = time.Hour
+	p.update(2<<30, 196608)
+	if buf.Len() != 0 {
+		t.Errorf("expected throttled update, got %q", buf.String())
+	}
+
+	// A file holding one large chunk advances while that chunk is parsed.
+	hdr := parser.ChunkHeader{Size: 1 << 30, StartTicks: 1000, DurationNanos: 10e9, TicksPerSecond: 1e9}
+	for _, tt := range []struct {
+		ticks uint64
+		want  int64
+	}{
+		{500, 0},
+		{1000, 0},
+		{1000 + 5e9, 1 << 29},
+		{1000 + 20e9, 1 << 30},
+	} {
+		if got := chunkBytesDone(0, hdr, tt.ticks); got != tt.want {
+			t.Errorf("chunkBytesDone(ticks=%d) = %d, want %d", tt.ticks, got, tt.want)
+		}
+	}
+	buf.Reset()
+	p = &parseProgress{w: &buf, totalBytes: 1 << 30, start: time.Now()}
+	p.update(chunkBytesDone(0, hdr, 1000+5e9), 65536)
+	if !strings.Contains(buf.String(), "parsing: 512.0 MB / 1.0 GB (50%)") {
+		t.Errorf("expected mid-chunk progress, got %q", buf.String())
+	}
+}
+
+func TestQuietFlagCLI(t *testing.T) {
+	code, _, stderr := runCLIForTest(t, []string{"hot", jfrFixture("cpu.jfr"), "--quiet"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if strings.Contains(stderr, "parsing:") {
+		t.Errorf("unexpected progress output:\n%s", stderr)
+	}
+}
+
+func TestExitCodeFor(t *testing.T) {
+	_, statErr := os.Stat(filepath.Join(t.TempDir(), "missing"))
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, exitOK},
+		{"untagged", fmt.Errorf("bad flag"), exitUsage},
+		{"assertion", assertionErrorf("ASSERT FAILED"), exitAssertion},
+		{"usage", usageErrorf("bad"), exitUsage},
+		{"parse", parseErrorf("bad data"), exitParse},
+		{"io", ioErrorf("disk"), exitIO},
+		{"path error", statErr, exitIO},
+		{"wrapped path error", fmt.Errorf("open: %w", statErr), exitIO},
+		{"wrapped tagged", fmt.Errorf("ctx: %w", parseErrorf("x")), exitParse},
+		{"read error on path error", readError(statErr), exitIO},
+		{"read error on corrupt data", readError(fmt.Errorf("gzip: invalid header")), exitParse},
+		{"silent", &exitError{code: exitAssertion}, exitAssertion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCodeFor(tt.err); got != tt.want {
+				t.Errorf("exitCodeFor(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExitCodeContractCLI(t *testing.T) {
+	dir := t.TempDir()
+	corrupt := filepath.Join(dir, "corrupt.jfr")
+	if err := os.WriteFile(corrupt, []byte("FLR\x00not really a recording"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	corruptGz := filepath.Join(dir, "corrupt.jfr.gz")
+	if err := os.WriteFile(corruptGz, []byte("not gzip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		args       []string
+		want       int
+		wantStderr string
+	}{
+		{"ok", []string{"hot", jfrFixture("cpu.jfr")}, exitOK, ""},
+		{"assertion", []string{"hot", jfrFixture("cpu.jfr"), "--assert-below", "1"}, exitAssertion, "ASSERT FAILED"},
+		{"unknown flag", []string{"hot", jfrFixture("cpu.jfr"), "--bogus"}, exitUsage, "unknown flag"},
+		{"missing arg", []string{"hot"}, exitUsage, "error:"},
+		{"invalid flag value", []string{"tree", jfrFixture("cpu.jfr"), "--index", "-1", "-m", "x"}, exitUsage, "--index"},
+		{"missing file", []string{"hot", filepath.Join(dir, "missing.jfr")}, exitIO, "error:"},
+		{"corrupt jfr", []string{"hot", corrupt}, exitParse, "error:"},
+		{"corrupt gzip", []string{"hot", corruptGz}, exitParse, "gzip"},
+		{"script usage", []string{"script"}, exitUsage, "requires -c"},
+		{"script fail", []string{"script", "-c", "fail('boom')"}, exitAssertion, "boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, stderr := runCLIForTest(t, tt.args, nil)
+			if code != tt.want {
+				t.Fatalf("exit %d, want %d, stderr:\n%s", code, tt.want, stderr)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("expected %q in stderr:\n%s", tt.wantStderr, stderr)
+			}
+			if strings.Contains(stderr, "exit code") {
+				t.Errorf("silent exit leaked a message:\n%s", stderr)
+			}
+		})
+	}
+}
+
+func TestStackFileMatch(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"A.main", "B.run", "C.work"}, count: 1},
+		{frames: []string{"A.main", "B.run", "B.run", "D.idle"}, count: 2},
+		{frames: []string{"A.main", "E.runAll"}, count: 3},
+		{frames: []string{"A.main", "F.other"}, count: 4},
+	})
+	tests := []struct {
+		name       string
+		m          methodMatcher
+		wantStacks []int
+		wantFrames []string
+	}{
+		{"single frame", substringMatcher("C.work"), []int{0}, []string{"C.work"}},
+		{"recursive frame counted once", substringMatcher("B.run"), []int{0, 1}, []string{"B.run"}},
+		{"several frames merged in order", substringMatcher("run"), []int{0, 1, 2}, []string{"B.run", "E.runAll"}},
+		{"exact", methodMatcher{pattern: "B.run", exact: true}, []int{0, 1}, []string{"B.run"}},
+		{"every stack", substringMatcher("A.main"), []int{0, 1, 2, 3}, []string{"A.main"}},
+		{"no match", substringMatcher("Nope"), nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fm := sf.match(tt.m)
+			if fmt.Sprint(fm.stacks) != fmt.Sprint(tt.wantStacks) {
+				t.Errorf("stacks = %v, want %v", fm.stacks, tt.wantStacks)
+			}
+			if len(fm.frames) != len(tt.wantFrames) {
+				t.Errorf("frames = %v, want %v", fm.frames, tt.wantFrames)
+			}
+			for _, fr := range tt.wantFrames {
+				if !fm.frames[fr] {
+					t.Errorf("expected %q in matched frames %v", fr, fm.frames)
+				}
+			}
+		})
+	}
+	if sf.idx == nil {
+		t.Fatal("expected the index to be cached on the stack file")
+	}
+	idx := sf.idx
+	sf.match(substringMatcher("A"))
+	if sf.idx != idx {
+		t.Error("index was rebuilt on a later query")
+	}
+}
+
+func TestCmdDiffMatrix(t *testing.T) {
+	base := makeStackFile([]stack{
+		{frames: []string{"A.a", "B.hot"}, count: 50},
+		{frames: []string{"A.a", "C.gone"}, count: 30},
+		{frames: []string{"A.a", "D.same"}, count: 20},
+	})
+	candA := makeStackFile([]stack{
+		{frames: []string{"A.a", "B.hot"}, count: 70},
+		{frames: []string{"A.a", "D.same"}, count: 20},
+		{frames: []string{"A.a", "E.new"}, count: 10},
+	})
+	candB := makeStackFile([]stack{
+		{frames: []string{"A.a", "B.hot"}, count: 40},
+		{frames: []string{"A.a", "C.gone"}, count: 40},
+		{frames: []string{"A.a", "D.same"}, count: 20},
+	})
+	paths := []string{"base.jfr", "a.jfr", "b.jfr"}
+
+	tests := []struct {
+		name    string
+		top     int
+		want    []string
+		notWant []string
+	}{
+		{"all", 0, []string{
+			"BASE  base.jfr", "#1    a.jfr", "#2    b.jfr",
+			"B.hot", "50.0%", "70.0% (+20.0)", "40.0% (-10.0)",
+			"C.gone", "- (-30.0)", "40.0% (+10.0)",
+			"E.new", "10.0% (+10.0)",
+		}, []string{"D.same", "methods shown"}},
+		{"top", 1, []string{"C.gone", "(1 of 3 methods shown)"}, []string{"B.hot", "E.new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := captureOutput(func() { cmdDiffMatrix(paths, []*stackFile{base, candA, candB}, 0.5, tt.top, false, nil) })
+			for _, w := range tt.want {
+				if !strings.Contains(out, w) {
+					t.Errorf("expected %q in output:\n%s", w, out)
+				}
+			}
+			for _, nw := range tt.notWant {
+				if strings.Contains(out, nw) {
+					t.Errorf("unexpected %q in output:\n%s", nw, out)
+				}
+			}
+		})
+	}
+
+	out := captureOutput(func() { cmdDiffMatrix(paths, []*stackFile{base, base, base}, 0.5, 0, false, nil) })
+	if strings.TrimSpace(out) != "no significant changes" {
+		t.Errorf("identical inputs: got %q", out)
+	}
+}
+
+func TestResolveEventTypeForMultiDiff(t *testing.T) {
+	tests := []struct {
+		name       string
+		requested  string
+		explicit   bool
+		counts     []map[string]int
+		wantEvent  string
+		wantReason eventSelectionReason
+	}{
+		{"explicit", "wall", true, []map[string]int{{"cpu": 1}, {"cpu": 1}, {"cpu": 1}}, "wall", eventReasonExplicit},
+		{"common across all", "cpu", false, []map[string]int{{"cpu": 5, "wall": 9}, {"wall": 3}, {"wall": 1, "alloc": 4}}, "wall", eventReasonDiffCommon},
+		{"no common", "cpu", false, []map[string]int{{"cpu": 5}, {"wall": 3}, {"wall": 1}}, "cpu", eventReasonDiffNoCommonFallback},
+		{"no common without default", "cpu", false, []map[string]int{{"alloc": 3}, {"wall": 3}, {"wall": 1}}, "wall", eventReasonDiffNoCommonFallback},
+		{"mixed metadata", "cpu", false, []map[string]int{{"wall": 5}, nil, {"wall": 1}}, "cpu", eventReasonDiffOneSidedMetadata},
+		{"no metadata", "cpu", false, []map[string]int{nil, nil, nil}, "cpu", eventReasonUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := resolveEventTypeForMultiDiff(tt.requested, tt.explicit, tt.counts)
+			if got != tt.wantEvent || reason != tt.wantReason {
+				t.Errorf("got (%q, %q), want (%q, %q)", got, reason, tt.wantEvent, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestMultiDiffCLI(t *testing.T) {
+	dir := t.TempDir()
+	writeCollapsed := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	base := writeCollapsed("base.txt", "A.a;B.b 80\nA.a;C.c 20\n")
+	a := writeCollapsed("a.txt", "A.a;B.b 60\nA.a;C.c 40\n")
+	b := writeCollapsed("b.txt", "A.a;B.b 90\nA.a;C.c 10\n")
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		wantCode int
+		want     string
+	}{
+		{"three files", []string{"diff", base, a, b}, "", 0, "60.0% (-20.0)   90.0% (+10.0)"},
+		{"stdin as one input", []string{"diff", base, "-", b}, "A.a;B.b 60\nA.a;C.c 40\n", 0, "40.0% (+20.0)"},
+		{"thread filter", []string{"diff", base, a, b, "-t", "nomatch"}, "", 0, "no significant changes"},
+		{"stdin twice", []string{"diff", base, "-", "-"}, "", exitUsage, "at most one"},
+		{"window flags rejected", []string{"diff", base, a, b, "--from", "1s"}, "", exitUsage, "single-file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLIForTest(t, tt.args, strings.NewReader(tt.stdin))
+			if code != tt.wantCode {
+				t.Fatalf("exit %d, want %d, stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			if !strings.Contains(stdout+stderr, tt.want) {
+				t.Errorf("expected %q in output:\nstdout:\n%s\nstderr:\n%s", tt.want, stdout, stderr)
+			}
+		})
+	}
+}
+
+func TestComputeTrend(t *testing.T) {
+	series := []*stackFile{
+		makeStackFile([]stack{{frames: []string{"A.a", "B.grow"}, count: 10}, {frames: []string{"A.a", "C.flat"}, count: 40}, {frames: []string{"A.a", "D.noisy"}, count: 50}}),
+		makeStackFile([]stack{{frames: []string{"A.a", "B.grow"}, count: 20}, {frames: []string{"A.a", "C.flat"}, count: 40}, {frames: []string{"A.a", "D.noisy"}, count: 40}}),
+		makeStackFile([]stack{{frames: []string{"A.a", "B.grow"}, count: 20}, {frames: []string{"A.a", "C.flat"}, count: 40}, {frames: []string{"A.a", "D.noisy"}, count: 40}}),
+		makeStackFile([]stack{{frames: []string{"A.a", "B.grow"}, count: 30}, {frames: []string{"A.a", "C.flat"}, count: 40}, {frames: []string{"A.a", "D.noisy"}, count: 30}}),
+	}
+	rows := computeTrend(series, 1.0, 0.5, false)
+	var names []string
+	for _, r := range rows {
+		names = append(names, r.name)
+	}
+	if got, want := strings.Join(names, ","), "B.grow,C.flat,D.noisy"; got != want {
+		t.Fatalf("rows = %s, want %s", got, want)
+	}
+	if !rows[0].growing || rows[1].growing || rows[2].growing {
+		t.Errorf("only B.grow should be growing: %+v", rows)
+	}
+	if rows[0].change != 20 {
+		t.Errorf("B.grow change = %.1f, want 20", rows[0].change)
+	}
+
+	if rows := computeTrend(series, 1.0, 25, false); rows[0].growing {
+		t.Errorf("growth below --min-growth should not be flagged")
+	}
+
+	tests := []struct {
+		values []float64
+		want   string
+	}{
+		{[]float64{1, 2, 3, 4}, "▃▅▆█"},
+		{[]float64{5, 5, 5}, "███"},
+		{[]float64{0, 0}, "▁▁"},
+	}
+	for _, tt := range tests {
+		if got := sparkline(tt.values); got != tt.want {
+			t.Errorf("sparkline(%v) = %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
+
+func TestTrendCLI(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i, grow := range []int{10, 20, 30} {
+		p := filepath.Join(dir, fmt.Sprintf("run%d.txt", i+1))
+		content := fmt.Sprintf("A.a;B.grow %d\nA.a;C.flat 50\nA.a;D.shrink %d\n", grow, 50-grow)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		want     []string
+		notWant  []string
+	}{
+		{"series", append([]string{"trend"}, paths...), 0,
+			[]string{"#1", "B.grow", "GROWING", "10.0 20.0 30.0", "D.shrink", "40.0 30.0 20.0"}, nil},
+		{"growing only", append([]string{"trend", "--growing"}, paths...), 0,
+			[]string{"B.grow"}, []string{"C.flat", "D.shrink"}},
+		{"min growth too high", append([]string{"trend", "--growing", "--min-growth", "50"}, paths...), 0,
+			[]string{"no growing methods"}, nil},
+		{"top", append([]string{"trend", "--top", "1"}, paths...), 0,
+			[]string{"(1 of 3 methods shown)"}, nil},
+		{"single file", []string{"trend", paths[0]}, exitUsage, []string{"requires at least 2 arg"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLIForTest(t, tt.args, nil)
+			if code != tt.wantCode {
+				t.Fatalf("exit %d, want %d, stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(stdout+stderr, w) {
+					t.Errorf("expected %q in output:\n%s%s", w, stdout, stderr)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(stdout, w) {
+					t.Errorf("unexpected %q in output:\n%s", w, stdout)
+				}
+			}
+		})
+	}
+}
+
+func TestRenameMap(t *testing.T) {
+	rm, err := parseRenameMap(strings.NewReader(`
+# moved during refactor
+com.a.Old.run = com.a.New.run
+com.a.legacy=com.b.modern
+com/a/Cache=com/a/LruCache
+`), "renames.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		frame string
+		want  string
+	}{
+		{"com/a/Old.run", "com.a.New.run"},
+		{"com/a/Old.runAll", "com/a/Old.runAll"},
+		{"com/a/legacy/Parser.parse", "com.b.modern.Parser.parse"},
+		{"com/a/legacyx/Parser.parse", "com/a/legacyx/Parser.parse"},
+		{"com/a/Cache.get", "com.a.LruCache.get"},
+		{"Unrelated.method", "Unrelated.method"},
+	}
+	for _, tt := range tests {
+		if got := rm.rename(tt.frame); got != tt.want {
+			t.Errorf("rename(%q) = %q, want %q", tt.frame, got, tt.want)
+		}
+	}
+
+	sf := makeStackFile([]stack{{frames: []string{"com/a/Old.run", "com/a/Cache.get"}, count: 3}})
+	out := rm.apply(sf)
+	if got := strings.Join(out.stacks[0].frames, ";"); got != "com.a.New.run;com.a.LruCache.get" {
+		t.Errorf("apply frames = %s", got)
+	}
+	if sf.stacks[0].frames[0] != "com/a/Old.run" {
+		t.Errorf("apply must not modify the input stack file")
+	}
+	if out.totalSamples != 3 {
+		t.Errorf("totalSamples = %d, want 3", out.totalSamples)
+	}
+
+	for _, bad := range []string{"no-equals", "=new", "old="} {
+		if _, err := parseRenameMap(strings.NewReader(bad), "m.txt"); err == nil || exitCodeFor(err) != exitUsage {
+			t.Errorf("parseRenameMap(%q) err = %v, want usage error", bad, err)
+		}
+	}
+}
+
+func TestDiffRenameMapCLI(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	before := write("before.txt", "com.a.Main.main;com.a.Old.work 50\ncom.a.Main.main;com.a.Other.idle 50\n")
+	after := write("after.txt", "com.a.Main.main;com.b.New.work 50\ncom.a.Main.main;com.a.Other.idle 50\n")
+	renames := write("renames.txt", "com.a.Old=com.b.New\n")
+	unused := write("unused.txt", "com.a.Old=com.b.New\ncom.typo.Foo=com.x.Bar\n")
+	malformed := write("bad.txt", "com.a.Old\n")
+	// Renames see names after --canonical-synthetic strips lambda indices.
+	lambdaBefore := write("lambda-before.txt", "com.a.Main.main;com.a.OldSvc$$Lambda$87.run 50\ncom.a.Main.main;com.a.Other.idle 50\n")
+	lambdaAfter := write("lambda-after.txt", "com.a.Main.main;com.b.NewSvc$$Lambda$12.run 50\ncom.a.Main.main;com.a.Other.idle 50\n")
+	lambdaRenames := write("lambda-renames.txt", "com.a.OldSvc$$Lambda=com.b.NewSvc$$Lambda\n")
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		want     string
+		notWant  string
+	}{
+		{"without map", []string{"diff", before, after}, 0, "GONE", ""},
+		{"with map", []string{"diff", before, after, "--rename-map", renames}, 0, "no significant changes", "GONE"},
+		{"multi-file", []string{"diff", before, after, after, "--rename-map", renames}, 0, "no significant changes", ""},
+		{"unused entry warning", []string{"diff", before, after, "--rename-map", unused}, 0, "com.typo.Foo=com.x.Bar matched no frames", ""},
+		{"malformed", []string{"diff", before, after, "--rename-map", malformed}, exitUsage, "expected old=new", ""},
+		{"missing file", []string{"diff", before, after, "--rename-map", filepath.Join(dir, "nope.txt")}, exitIO, "nope.txt", ""},
+		{"window mode", []string{"diff", before, "--from", "1s", "--vs-from", "2s", "--rename-map", renames}, exitUsage, "two or more files", ""},
+		{"canonical names", []string{"diff", lambdaBefore, lambdaAfter, "--canonical-synthetic", "--rename-map", lambdaRenames}, 0, "no significant changes", "GONE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLIForTest(t, tt.args, nil)
+			if code != tt.wantCode {
+				t.Fatalf("exit %d, want %d, stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			if !strings.Contains(stdout+stderr, tt.want) {
+				t.Errorf("expected %q in output:\nstdout:\n%s\nstderr:\n%s", tt.want, stdout, stderr)
+			}
+			if tt.notWant != "" && strings.Contains(stdout, tt.notWant) {
+				t.Errorf("unexpected %q in output:\n%s", tt.notWant, stdout)
+			}
+		})
+	}
+}
+
+func TestDiffIgnore(t *testing.T) {
+	before := makeStackFile([]stack{
+		{frames: []string{"App.main", "jdk/internal/misc/Unsafe.park"}, count: 20, thread: "main"},
+		{frames: []string{"App.main", "App.work"}, count: 40, thread: "main"},
+		{frames: []string{"GCTaskThread.run"}, count: 40, thread: "GC Thread#0"},
+	})
+	ig, err := newDiffIgnore([]string{"Unsafe.*", "jdk.internal.*", "GC*"}, []string{"GC Thread*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filtered := ig.filterThreads(before)
+	if filtered.totalSamples != 60 || len(filtered.stacks) != 2 {
+		t.Errorf("filterThreads kept %d stacks / %d samples, want 2 / 60", len(filtered.stacks), filtered.totalSamples)
+	}
+
+	for _, fqn := range []bool{false, true} {
+		hidden := ig.hiddenMethods(fqn, before)
+		if !hidden[displayName("jdk/internal/misc/Unsafe.park", fqn)] || !hidden["GCTaskThread.run"] {
+			t.Errorf("fqn=%v: expected Unsafe.park and GCTaskThread.run hidden, got %v", fqn, hidden)
+		}
+		if hidden[displayName("App.work", fqn)] {
+			t.Errorf("fqn=%v: App.work should not be hidden", fqn)
+		}
+	}
+
+	tests := []struct {
+		glob  string
+		input string
+		want  bool
+	}{
+		{"C2 Compiler*", "C2 CompilerThread0", true},
+		{"C2 Compiler*", "C1 CompilerThread0", false},
+		{"G1 ?ain", "G1 Main", true},
+		{"a.b", "axb", false},
+		{"*resize", "HashMap.resize", true},
+	}
+	for _, tt := range tests {
+		if got := globRegexp(tt.glob).MatchString(tt.input); got != tt.want {
+			t.Errorf("glob %q on %q = %v, want %v", tt.glob, tt.input, got, tt.want)
+		}
+	}
+
+	if ig, err := newDiffIgnore(nil, nil); ig != nil || err != nil {
+		t.Errorf("no patterns: got %v, %v; want nil, nil", ig, err)
+	}
+}
+
+func TestDiffIgnoreCLI(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	before := write("before.txt", "[main tid=1];App.main;App.work 50\n[main tid=1];App.main;GCWork.run 10\n[C2 CompilerThread0 tid=2];Compile.run 40\n")
+	after := write("after.txt", "[main tid=1];App.main;App.work 50\n[main tid=1];App.main;GCWork.run 40\n[C2 CompilerThread0 tid=2];Compile.run 10\n")
+	patterns := write("noise.txt", "# known noise\nGC*\n\nCompile.*\n")
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		want     []string
+		notWant  []string
+	}{
+		{"no ignore", []string{"diff", before, after}, 0, []string{"GCWork.run", "Compile.run"}, nil},
+		{"ignore method", []string{"diff", before, after, "--ignore", "GC*"}, 0, []string{"Compile.run"}, []string{"GCWork.run"}},
+		{"repeatable", []string{"diff", before, after, "--ignore", "GC*", "--ignore", "Compile.*"}, 0, []string{"no significant changes"}, []string{"GCWork.run", "Compile.run"}},
+		{"from file", []string{"diff", before, after, "--ignore", "@" + patterns}, 0, []string{"no significant changes"}, []string{"GCWork.run", "Compile.run"}},
+		{"ignore threads", []string{"diff", before, after, "--ignore-threads", "C2 Compiler*"}, 0, []string{"GCWork.run"}, []string{"Compile.run"}},
+		{"multi-file", []string{"diff", before, after, after, "--ignore", "GC*"}, 0, []string{"Compile.run"}, []string{"GCWork.run"}},
+		{"missing file", []string{"diff", before, after, "--ignore", "@" + filepath.Join(dir, "nope.txt")}, exitIO, []string{"nope.txt"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLIForTest(t, tt.args, nil)
+			if code != tt.wantCode {
+				t.Fatalf("exit %d, want %d, stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(stdout+stderr, w) {
+					t.Errorf("expected %q in output:\n%s%s", w, stdout, stderr)
+				}
+			}
+			for _, w := range tt.notWant {
+				if strings.Contains(stdout, w) {
+					t.Errorf("unexpected %q in output:\n%s", w, stdout)
+				}
+			}
+		})
+	}
+}
+
+func TestComputeThreadShift(t *testing.T) {
+	before := makeStackFile([]stack{
+		{frames: []string{"A.a"}, count: 60, thread: "async-exec-1"},
+		{frames: []string{"A.a"}, count: 20, thread: "async-exec-2"},
+		{frames: []string{"A.a"}, count: 20, thread: "http-nio-8080-exec-1"},
+	})
+	after := makeStackFile([]stack{
+		{frames: []string{"A.a"}, count: 20, thread: "async-exec-3"},
+		{frames: []string{"A.a"}, count: 70, thread: "http-nio-8080-exec-1"},
+		{frames: []string{"A.a"}, count: 10, thread: "http-nio-8080-exec-2"},
+	})
+	shifts, hasThread := computeThreadShift(before, after)
+	if !hasThread {
+		t.Fatal("expected thread info")
+	}
+	want := []threadShift{
+		{name: "http-nio-exec", threads: 2, before: 20, after: 80, delta: 60},
+		{name: "async-exec", threads: 3, before: 80, after: 20, delta: -60},
+	}
+	if len(shifts) != len(want) {
+		t.Fatalf("got %d shifts, want %d: %+v", len(shifts), len(want), shifts)
+	}
+	for i := range want {
+		if shifts[i] != want[i] {
+			t.Errorf("shift[%d] = %+v, want %+v", i, shifts[i], want[i])
+		}
+	}
+
+	if _, hasThread := computeThreadShift(makeStackFile([]stack{{frames: []string{"A.a"}, count: 1}}), makeStackFile(nil)); hasThread {
+		t.Error("expected no thread info")
+	}
+}
+
+func TestDiffFlatThreadsCLI(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	// Same methods, same self% — only the threads doing the work moved.
+	before := write("before.txt", "[async-1 tid=1];App.work 40\n[async-2 tid=2];App.work 40\n[http-1 tid=3];App.work 20\n")
+	after := write("after.txt", "[async-1 tid=1];App.work 20\n[http-1 tid=3];App.work 40\n[http-2 tid=4];App.work 40\n")
+	noThreads := write("plain.txt", "App.work 10\n")
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		want     []string
+	}{
+		{"method diff is flat", []string{"diff", before, after}, 0, []string{"no significant changes"}},
+		{"thread shift", []string{"diff", before, after, "--flat-threads"}, 0,
+			[]string{"THREAD GROUP", "http (2 threads)", "+60.0%", "async (2 threads)", "-60.0%"}},
+		{"top", []string{"diff", before, after, "--flat-threads", "--top", "1"}, 0, []string{"(1 of 2 thread groups shown)"}},
+		{"min delta", []string{"diff", before, after, "--flat-threads", "--min-delta", "90"}, 0, []string{"no significant changes"}},
+		{"ignore threads", []string{"diff", before, after, "--flat-threads", "--ignore-threads", "async*"}, 0, []string{"no significant changes"}},
+		{"no thread info", []string{"diff", noThreads, noThreads, "--flat-threads"}, 0, []string{"no thread info in these files"}},
+		{"multi-file rejected", []string{"diff", before, after, after, "--flat-threads"}, exitUsage, []string{"exactly two"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLIForTest(t, tt.args, nil)
+			if code != tt.wantCode {
+				t.Fatalf("exit %d, want %d, stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(stdout+stderr, w) {
+					t.Errorf("expected %q in output:\n%s%s", w, stdout, stderr)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffByThreadCLI(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	// Two JVM instances: the pools are numbered differently, and only the
+	// work inside the pool changed.
+	before := write("before.txt", "[pool-1-thread-1];App.encode 50\n[pool-1-thread-2];App.decode 50\n[main];App.main 100\n")
+	after := write("after.txt", "[pool-7-thread-9];App.encode 90\n[pool-7-thread-3];App.decode 10\n[main];App.main 100\n")
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		want     []string
+	}{
+		{"groups match", []string{"diff", before, after, "--by-thread"}, 0,
+			[]string{"=== pool-thread (4 threads): 50.0% -> 50.0% of samples ===", "App.encode", "+40.0%", "(1 thread groups without significant changes)"}},
+		{"normalized", []string{"diff", before, after, "--by-thread", "--thread-normalize", `pool-\d+-thread-\d+=workers`}, 0,
+			[]string{"=== workers: 50.0% -> 50.0% of samples ===", "App.decode"}},
+		{"min delta", []string{"diff", before, after, "--by-thread", "--min-delta", "50"}, 0, []string{"no significant changes"}},
+		{"with flat threads", []string{"diff", before, after, "--by-thread", "--flat-threads"}, exitUsage, []string{"cannot be combined"}},
+		{"multi-file rejected", []string{"diff", before, after, after, "--by-thread"}, exitUsage, []string{"exactly two"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLIForTest(t, tt.args, nil)
+			if code != tt.wantCode {
+				t.Fatalf("exit %d, want %d, stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(stdout+stderr, w) {
+					t.Errorf("expected %q in output:\n%s%s", w, stdout, stderr)
+				}
+			}
+		})
+	}
+}
+
+func TestThreadAsserts(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"A.a"}, count: 60, thread: "pool-1-thread-1"},
+		{frames: []string{"A.a"}, count: 20, thread: "pool-1-thread-2"},
+		{frames: []string{"A.a"}, count: 7, thread: "GC Thread#0"},
+		{frames: []string{"A.a"}, count: 3, thread: "GC Thread#1"},
+		{frames: []string{"A.a"}, count: 10},
+	})
+	tests := []struct {
+		rule    string
+		wantErr string // "" = passes
+	}{
+		{"GC Thread*<15", ""},
+		{"GC Thread*<10", "2 threads at 10.0% >= threshold 10.0%"},
+		{"pool-1-thread-*>50", ""},
+		{"pool-1-thread-*<40%", "80.0% >= threshold 40.0%"},
+		{"pool-1-thread-?>70", ""},
+		{"nomatch*>0", "0 threads at 0.0% <= threshold 0.0%"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			r, err := parseThreadAssert(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = checkThreadAsserts(sf, []threadAssert{r})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected failure: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || exitCodeFor(err) != exitAssertion {
+				t.Errorf("err = %v, want assertion containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	for _, bad := range []string{"GC*", "<5", "GC*<abc", "GC*<101", "GC*>-1"} {
+		if _, err := parseThreadAssert(bad); err == nil {
+			t.Errorf("parseThreadAssert(%q) should fail", bad)
+		}
+	}
+}
+
+func TestThreadsAssertCLI(t *testing.T) {
+	input := "[pool-1-thread-1 tid=1];A.a 60\n[pool-1-thread-2 tid=2];A.a 30\n[GC Thread#0 tid=3];A.a 10\n"
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+		want     []string
+	}{
+		{"passes", []string{"threads", "-", "--assert", "GC Thread*<15", "--assert", "pool-*>50"}, 0, []string{"pool-1-thread-1"}},
+		{"fails", []string{"threads", "-", "--assert", "GC Thread*<5", "--assert", "pool-*<50"}, exitAssertion,
+			[]string{"GC Thread*<5 — 1 threads at 10.0%", "pool-*<50 — 2 threads at 90.0%"}},
+		{"bad rule", []string{"threads", "-", "--assert", "GC"}, exitUsage, []string{"expected GLOB<PCT"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCLIForTest(t, tt.args, strings.NewReader(input))
+			if code != tt.wantCode {
+				t.Fatalf("exit %d, want %d, stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(stdout+stderr, w) {
+					t.Errorf("expected %q in output:\n%s%s", w, stdout, stderr)
+				}
+			}
+		})
+	}
+}
+
+func TestComputeCollapsedTimed(t *testing.T) {
+	events := []timedEvent{
+		{offsetNanos: 300, frames: []string{"A.a", "B.b"}, thread: "main", weight: 1},
+		{offsetNanos: 100, frames: []string{"A.a", "Lock.park"}, thread: "worker", weight: 2, durNanos: 5000},
+		{offsetNanos: 100, frames: []string{"A.a"}, thread: "main", weight: 1},
+		{offsetNanos: 200, frames: []string{"A.a", "Thread.sleep"}, thread: "main", weight: 1},
+		{offsetNanos: 400, frames: []string{"C.c"}, thread: "io", tid: "4242", weight: 3},
+	}
+	tests := []struct {
+		name   string
+		thread string
+		noIdle bool
+		want   []string
+	}{
+		{"time order", "", false, []string{
+			"100 0 [main];A.a 1", "100 5000 [worker];A.a;Lock.park 2", "200 0 [main];A.a;Thread.sleep 1", "300 0 [main];A.a;B.b 1", "400 0 [io tid=4242];C.c 3"}},
+		{"thread filter", "work", false, []string{"100 5000 [worker];A.a;Lock.park 2"}},
+		{"tid filter", "4242", false, []string{"400 0 [io tid=4242];C.c 3"}},
+		{"tid= filter", "tid=4242", false, []string{"400 0 [io tid=4242];C.c 3"}},
+		{"no idle", "main", true, []string{"100 0 [main];A.a 1", "300 0 [main];A.a;B.b 1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, l := range computeCollapsedTimed(events, tt.thread, tt.noIdle) {
+				got = append(got, fmt.Sprintf("%d %d %s %d", l.startNanos, l.durNanos, l.key, l.weight))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestTicksDurationNanos(t *testing.T) {
+	if got := ticksDurationNanos(2_500_000, 1_000_000); got != 2_500_000_000 {
+		t.Errorf("got %d, want 2500000000", got)
+	}
+	if got := ticksDurationNanos(123, 0); got != 0 {
+		t.Errorf("zero tps: got %d, want 0", got)
+	}
+}
+
+func TestCollapseTimestampsCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"collapse", jfrFixture("lock.jfr"), "--event", "lock", "--timestamps"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	var prev int64 = -1
+	sawDuration := false
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			t.Fatalf("malformed line %q", line)
+		}
+		start, err1 := strconv.ParseInt(fields[0], 10, 64)
+		dur, err2 := strconv.ParseInt(fields[1], 10, 64)
+		if err1 != nil || err2 != nil {
+			t.Fatalf("non-numeric start/duration in %q", line)
+		}
+		if start < prev {
+			t.Fatalf("lines not in time order at %q", line)
+		}
+		prev = start
+		if dur > 0 {
+			sawDuration = true
+		}
+	}
+	if !sawDuration {
+		t.Error("expected lock events to carry a duration")
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"collapse", "-", "--timestamps"}, strings.NewReader("A;B 1\n"))
+	if code != exitUsage || !strings.Contains(stderr, "--timestamps requires a JFR file") {
+		t.Errorf("collapsed input: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestHighlightMatchedFrames(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"A.a", "B.b", "A.a", "C.c"}, count: 5},
+		{frames: []string{"X.x", "A.a", "D.d"}, count: 3},
+	})
+	m := substringMatcher("A.a")
+
+	tree := captureOutput(func() { cmdTree(sf, m, 4, 0, true, 0) })
+	for _, want := range []string{"[100.0%] » A.a", "    [62.5%] » A.a", "[62.5%] B.b"} {
+		if !strings.Contains(tree, want) {
+			t.Errorf("tree: expected %q in:\n%s", want, tree)
+		}
+	}
+	if strings.Contains(tree, "» B.b") || strings.Contains(tree, "» C.c") {
+		t.Errorf("tree: only matched frames should be marked:\n%s", tree)
+	}
+
+	callers := captureOutput(func() { cmdCallers(sf, substringMatcher("C.c"), 4, 0, true, 0, false) })
+	if !strings.Contains(callers, "[62.5%] » C.c") || strings.Contains(callers, "» A.a") {
+		t.Errorf("callers: unexpected marking:\n%s", callers)
+	}
+
+	trace := captureOutput(func() { cmdTrace(sf, m, 0, false, true) })
+	if !strings.Contains(trace, "    [62.5%] » A.a") || !strings.Contains(trace, "Hottest leaf: C.c") {
+		t.Errorf("trace: unexpected output:\n%s", trace)
+	}
+
+	plain := captureOutput(func() { cmdTree(sf, m, 4, 0, false, 0) })
+	if strings.Contains(plain, highlightMarker) {
+		t.Errorf("tree without highlight should not mark frames:\n%s", plain)
+	}
+}
+
+func TestHighlightRequiresMethodCLI(t *testing.T) {
+	code, _, stderr := runCLIForTest(t, []string{"tree", "-", "--highlight"}, strings.NewReader("A.a;B.b 1\n"))
+	if code != exitUsage || !strings.Contains(stderr, "--highlight requires -m/--method") {
+		t.Errorf("exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestTreeMaxNodes(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"R.r", "A.a", "A1.x"}, count: 40},
+		{frames: []string{"R.r", "A.a", "A2.x"}, count: 20},
+		{frames: []string{"R.r", "B.b", "B1.x"}, count: 25},
+		{frames: []string{"R.r", "C.c"}, count: 10},
+		{frames: []string{"S.s"}, count: 5},
+	})
+	tests := []struct {
+		name     string
+		maxNodes int
+		want     []string
+	}{
+		{"unlimited", 0, []string{
+			"[95.0%] R.r", "  [60.0%] A.a", "    [40.0%] A1.x  ← self=40.0%", "    [20.0%] A2.x  ← self=20.0%",
+			"  [25.0%] B.b", "    [25.0%] B1.x  ← self=25.0%", "  [10.0%] C.c  ← self=10.0%", "[5.0%] S.s  ← self=5.0%"}},
+		{"heaviest first", 4, []string{
+			"[95.0%] R.r", "  [60.0%] A.a", "    [40.0%] A1.x  ← self=40.0%", "    … 1 more (20.0%)",
+			"  [25.0%] B.b", "    … 1 more (25.0%)", "  … 1 more (10.0%)", "(4 of 8 nodes shown; raise --max-nodes for more)"}},
+		{"cap above node count", 100, []string{
+			"[95.0%] R.r", "  [60.0%] A.a", "    [40.0%] A1.x  ← self=40.0%", "    [20.0%] A2.x  ← self=20.0%",
+			"  [25.0%] B.b", "    [25.0%] B1.x  ← self=25.0%", "  [10.0%] C.c  ← self=10.0%", "[5.0%] S.s  ← self=5.0%"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := captureOutput(func() { cmdTree(sf, substringMatcher(""), 4, 0, false, tt.maxNodes) })
+			want := strings.Join(tt.want, "\n") + "\n"
+			if out != want {
+				t.Errorf("got:\n%s\nwant:\n%s", out, want)
+			}
+		})
+	}
+}
+
+func TestMaxNodesCLI(t *testing.T) {
+	input := "R.r;A.a 6\nR.r;B.b 3\nR.r;C.c 1\n"
+	code, stdout, stderr := runCLIForTest(t, []string{"callers", "-", "-m", "B.b", "--max-nodes", "1"}, strings.NewReader(input))
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "[30.0%] B.b\n  … 1 more (30.0%)\n(1 of 2 nodes shown") {
+		t.Errorf("unexpected callers output:\n%s", stdout)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"tree", "-", "--max-nodes", "-1"}, strings.NewReader(input))
+	if code != exitUsage || !strings.Contains(stderr, "--max-nodes must be non-negative") {
+		t.Errorf("negative --max-nodes: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestDeepStackElisionCLI(t *testing.T) {
+	// 302 frames: Main.main, 300 recursive calls, Leaf.work.
+	input := "Main.main;" + strings.Repeat("R.rec;", 300) + "Leaf.work 5\n"
+	for _, args := range [][]string{
+		{"tree", "-", "--depth", "400"},
+		{"trace", "-", "-m", "Main.main"},
+	} {
+		code, stdout, stderr := runCLIForTest(t, args, strings.NewReader(input))
+		if code != 0 {
+			t.Fatalf("%v: exit %d, stderr:\n%s", args, code, stderr)
+		}
+		if !strings.Contains(stdout, "… 222 frames elided (--full-stacks shows all)") || !strings.Contains(stdout, "] Leaf.work") {
+			t.Errorf("%v: expected head, elision marker and leaf:\n%s", args, stdout)
+		}
+		if n := strings.Count(stdout, "R.rec"); n != 78 {
+			t.Errorf("%v: %d R.rec lines, want 78", args, n)
+		}
+
+		code, stdout, _ = runCLIForTest(t, append(args, "--full-stacks"), strings.NewReader(input))
+		if code != 0 || strings.Contains(stdout, "elided") || strings.Count(stdout, "R.rec") != 300 {
+			t.Errorf("%v --full-stacks: exit %d, expected every frame:\n%.300s", args, code, stdout)
+		}
+	}
+}
+
+func TestFilterJFRRoundTrip(t *testing.T) {
+	tests := []struct {
+		fixture string
+		filter  jfrExportFilter
+	}{
+		{"cpu.jfr", jfrExportFilter{eventType: "cpu", fromNanos: -1, toNanos: 1_000_000_000}},
+		{"multi.jfr", jfrExportFilter{eventType: "wall", thread: "a", fromNanos: 1_000_000_000, toNanos: 3_000_000_000}},
+		{"multichunk.jfr", jfrExportFilter{fromNanos: 500_000_000, toNanos: -1}},
+		{"lock.jfr", jfrExportFilter{eventType: "lock", thread: "lock-worker-1", fromNanos: -1, toNanos: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			buf, err := readJFRBytes(jfrFixture(tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, kept, total, err := filterJFR(buf, tt.filter, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept == 0 || kept >= total || len(out) >= len(buf) {
+				t.Fatalf("expected a strict, non-empty subset: kept %d/%d, %d/%d bytes", kept, total, len(out), len(buf))
+			}
+
+			path := filepath.Join(t.TempDir(), "out.jfr")
+			if err := os.WriteFile(path, out, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			exported, err := parseJFRData(path, allEventTypes(), parseOpts{})
+			if err != nil {
+				t.Fatalf("exported JFR does not parse: %v", err)
+			}
+			orig, err := parseJFRData(jfrFixture(tt.fixture), allEventTypes(),
+				parseOpts{collectTimestamps: true, fromNanos: tt.filter.fromNanos, toNanos: tt.filter.toNanos})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for et, sf := range orig.stacksByEvent {
+				if tt.filter.eventType != "" && et != tt.filter.eventType {
+					if n := exported.eventCounts[et]; n != 0 {
+						t.Errorf("%s: %d samples left after filtering to %s", et, n, tt.filter.eventType)
+					}
+					continue
+				}
+				want := computeCollapsed(sf.filterByThread(tt.filter.thread))
+				got := computeCollapsed(exported.stacksByEvent[et])
+				if fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("%s: exported stacks differ from filtered original", et)
+				}
+			}
+		})
+	}
+}
+
+func TestVarLongWidth(t *testing.T) {
+	for _, v := range []int64{1, 127, 128, 300, -1, -68, -1 << 40} {
+		width := 9
+		if v > 0 {
+			width = 3
+		}
+		buf := make([]byte, width)
+		if !putVarLongWidth(buf, uint64(v)) {
+			t.Fatalf("%d does not fit %d bytes", v, width)
+		}
+		got, n := readVarLong(buf)
+		if int64(got) != v || n != width {
+			t.Errorf("round trip %d: got %d in %d bytes", v, int64(got), n)
+		}
+	}
+	if putVarLongWidth(make([]byte, 1), 300) {
+		t.Error("300 should not fit one byte")
+	}
+}
+
+func TestExportCLI(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "trimmed.jfr.gz")
+	code, _, stderr := runCLIForTest(t, []string{"export", jfrFixture("multi.jfr"), "--jfr", out, "-e", "wall"}, nil)
+	if code != 0 || !strings.Contains(stderr, "Exported ") {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	code, stdout, stderr := runCLIForTest(t, []string{"info", out}, nil)
+	if code != 0 || !strings.Contains(stdout, "wall") || strings.Contains(stdout, "cpu=") {
+		t.Errorf("info on export: exit %d\n%s%s", code, stdout, stderr)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"export", jfrFixture("cpu.jfr")}, "--jfr OUTPUT required"},
+		{[]string{"export", "-", "--jfr", out}, "export requires a JFR file"},
+		{[]string{"export", jfrFixture("cpu.jfr"), "--jfr", "out.txt"}, "must end in .jfr"},
+		{[]string{"export", jfrFixture("cpu.jfr"), "--jfr", out, "--from", "2s", "--to", "1s"}, "--to must be >= --from"},
+	} {
+		code, _, stderr := runCLIForTest(t, tt.args, nil)
+		if code != exitUsage || !strings.Contains(stderr, tt.want) {
+			t.Errorf("%v: exit %d, stderr:\n%s", tt.args, code, stderr)
+		}
+	}
+}
+
+func TestRedactor(t *testing.T) {
+	frames := []string{
+		"com/mycorp/svc/UserService.login",
+		"com/mycorp/svc/UserService.logout",
+		"com/mycorp/db/Repo.login",
+		"com.mycorp.svc.Auth.check",
+		"java/util/HashMap.get",
+		"Plain.run",
+	}
+	threads := []string{"main", "customer-acme-worker", "", "main"}
+	r := newRedactor([]*regexp.Regexp{globRegexp("com.mycorp.*"), globRegexp("Plain*")}, frames, threads)
+	want := map[string]string{
+		"Plain.run":                         "ClassA.method1",
+		"com/mycorp/db/Repo.login":          "pkgA.ClassB.method2",
+		"com.mycorp.svc.Auth.check":         "pkgB.ClassC.method3",
+		"com/mycorp/svc/UserService.login":  "pkgB.ClassD.method4",
+		"com/mycorp/svc/UserService.logout": "pkgB.ClassD.method5",
+		"java/util/HashMap.get":             "java/util/HashMap.get",
+	}
+	for fr, w := range want {
+		if got := r.frame(fr); got != w {
+			t.Errorf("frame(%q) = %q, want %q", fr, got, w)
+		}
+	}
+	for name, w := range map[string]string{"customer-acme-worker": "thread1", "main": "thread2", "": ""} {
+		if got := r.thread(name); got != w {
+			t.Errorf("thread(%q) = %q, want %q", name, got, w)
+		}
+	}
+	if got, want := r.summary(), "Redacted 5 frames (2 packages, 4 classes, 5 methods) and 2 threads"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+
+	// JVM names of classes no frame names, arrays and descriptors.
+	for _, tc := range []struct{ got, want string }{
+		{r.jfrClass("com/mycorp/svc/UserService"), "pkgB/ClassD"},
+		{r.jfrClass("com/mycorp/Order"), "pkgC/ClassE"},
+		{r.jfrClass("[Lcom/mycorp/Order;"), "[LpkgC/ClassE;"},
+		{r.jfrClass("java/lang/String"), "java/lang/String"},
+		{r.jfrDescriptor("(Ljava/lang/String;Lcom/mycorp/Order;)V"), "(Ljava/lang/String;LpkgC/ClassE;)V"},
+		{r.jfrPackage("com/mycorp/svc"), "pkgB"},
+		{r.jfrPackage("java/util"), "java/util"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("got %q, want %q", tc.got, tc.want)
+		}
+	}
+
+	for n, w := range map[int]string{1: "A", 26: "Z", 27: "AA", 52: "AZ", 703: "AAA"} {
+		if got := aliasLetters(n); got != w {
+			t.Errorf("aliasLetters(%d) = %q, want %q", n, got, w)
+		}
+	}
+}
+
+func TestCollapseRedactCLI(t *testing.T) {
+	input := "[customer-acme-worker tid=7];com/mycorp/App.main;com/mycorp/svc/Svc.run;java/util/HashMap.get 5\n[main];com/mycorp/App.main;Other.x 2\n"
+	code, stdout, stderr := runCLIForTest(t, []string{"collapse", "-", "--redact", "com.mycorp.*"}, strings.NewReader(input))
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	want := "[thread1 tid=7];pkgA.ClassA.method1;pkgB.ClassB.method2;java/util/HashMap.get 5\n[thread2];pkgA.ClassA.method1;Other.x 2\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+	if strings.Contains(stdout, "mycorp") || !strings.Contains(stderr, "Redacted 2 frames (2 packages, 2 classes, 2 methods) and 2 threads") {
+		t.Errorf("unexpected redaction, stderr:\n%s", stderr)
+	}
+}
+
+func TestExportRedactCLI(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		fixture, glob string
+		secrets       []string // names that must not remain anywhere in the file
+	}{
+		{"cpu.jfr", "Workload*", []string{"cpuWork", "computeStep", "cpu-worker"}},
+		{"alloc.jfr", "Workload*", []string{"allocateObjects", "alloc-worker"}},
+		{"multichunk.jfr", "MultiChunk*", []string{"phaseA"}},
+	} {
+		out := filepath.Join(dir, tc.fixture)
+		code, _, stderr := runCLIForTest(t, []string{"export", jfrFixture(tc.fixture), "--jfr", out, "--redact", tc.glob}, nil)
+		if code != 0 || !strings.Contains(stderr, "Redacted ") {
+			t.Fatalf("%s: exit %d, stderr:\n%s", tc.fixture, code, stderr)
+		}
+		data, _ := os.ReadFile(out)
+		for _, secret := range tc.secrets {
+			if bytes.Contains(data, []byte(secret)) {
+				t.Errorf("%s: %q left in the export", tc.fixture, secret)
+			}
+		}
+		// It reads back with the aliases collapse --redact gives, with the
+		// JVM's slash after the package.
+		_, got, _ := runCLIForTest(t, []string{"collapse", out}, nil)
+		_, want, _ := runCLIForTest(t, []string{"collapse", jfrFixture(tc.fixture), "--redact", tc.glob}, nil)
+		got = regexp.MustCompile(`(pkg[A-Z]+)/`).ReplaceAllString(got, "$1.")
+		if got != want || want == "" {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", tc.fixture, got, want)
+		}
+	}
+}
+
+func TestFrameDetailsJFR(t *testing.T) {
+	parsed, err := parseJFRData(jfrFixture("cpu.jfr"), singleEventType("cpu"), parseOpts{frameDetails: true})
+	if err != nil {
+		t.Fatalf("parseJFRData: %v", err)
+	}
+	sf := parsed.stacksByEvent["cpu"]
+	kinds := make(map[string]bool)
+	for _, st := range sf.stacks {
+		if len(st.details) != len(st.frames) {
+			t.Fatalf("details not parallel to frames: %d vs %d", len(st.details), len(st.frames))
+		}
+		for _, d := range st.details {
+			kinds[d.kind] = true
+		}
+	}
+	for _, k := range []string{frameKindInlined, "JIT compiled", "Interpreted"} {
+		if !kinds[k] {
+			t.Errorf("expected frame type %q, got %v", k, kinds)
+		}
+	}
+
+	plain, err := parseJFRData(jfrFixture("cpu.jfr"), singleEventType("cpu"), parseOpts{})
+	if err != nil {
+		t.Fatalf("parseJFRData: %v", err)
+	}
+	if plain.stacksByEvent["cpu"].totalSamples != sf.totalSamples {
+		t.Errorf("total samples differ: %d vs %d", plain.stacksByEvent["cpu"].totalSamples, sf.totalSamples)
+	}
+	for _, st := range plain.stacksByEvent["cpu"].stacks {
+		if st.details != nil {
+			t.Fatal("details should only be decoded on request")
+		}
+	}
+}
+
+func TestLinesAndTreeFrameDetails(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"A.run", "B.work"}, lines: []uint32{10, 42}, count: 6,
+			details: []frameDetail{{3, "JIT compiled"}, {7, frameKindInlined}}},
+		{frames: []string{"A.run", "B.work"}, lines: []uint32{10, 42}, count: 2,
+			details: []frameDetail{{3, "JIT compiled"}, {7, "JIT compiled"}}},
+		{frames: []string{"A.run", "B.work"}, lines: []uint32{10, 43}, count: 2,
+			details: []frameDetail{{3, "JIT compiled"}, {9, "Interpreted"}}},
+	})
+
+	ranked, _ := computeLines(sf, substringMatcher("B.work"), 0, false)
+	if len(ranked) != 3 {
+		t.Fatalf("expected 3 rows split by frame type, got %+v", ranked)
+	}
+	if ranked[0].line != 42 || ranked[0].samples != 6 || ranked[0].detail.kind != frameKindInlined || ranked[0].detail.bci != 7 {
+		t.Errorf("unexpected first row %+v (%+v)", ranked[0], *ranked[0].detail)
+	}
+
+	out := captureOutput(func() { cmdLines(sf, substringMatcher("B.work"), 0, false) })
+	if !strings.Contains(out, "BCI") || !strings.Contains(out, "60.0%  Inlined") {
+		t.Errorf("lines: unexpected output:\n%s", out)
+	}
+
+	tree := captureOutput(func() { cmdTree(sf, methodMatcher{}, 4, 0, false, 0) })
+	if !strings.Contains(tree, "[100.0%] B.work [inlined 60%]") || strings.Contains(tree, "A.run [inlined") {
+		t.Errorf("tree: unexpected inlined annotation:\n%s", tree)
+	}
+}
+
+func TestFrameDetailsCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"lines", jfrFixture("cpu.jfr"), "-m", "lockStep", "--bci"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "BCI") || !strings.Contains(stdout, "Inlined") {
+		t.Errorf("lines --bci: unexpected output:\n%s", stdout)
+	}
+
+	code, stdout, stderr = runCLIForTest(t, []string{"tree", jfrFixture("cpu.jfr"), "--inlined", "--depth", "8"}, nil)
+	if code != 0 || !strings.Contains(stdout, "[inlined ") {
+		t.Errorf("tree --inlined: exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"lines", "-", "-m", "A", "--bci"}, strings.NewReader("A;B 1\n"))
+	if code != exitUsage || !strings.Contains(stderr, "--bci requires a JFR file") {
+		t.Errorf("collapsed input: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestMarkInlined(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"A.run", "B.work", "C.tiny"}, count: 3,
+			details: []frameDetail{{3, "JIT compiled"}, {7, frameKindInlined}, {1, frameKindInlined}}},
+		{frames: []string{"A.run", "C.tiny"}, count: 2},
+	})
+	marked, n := sf.markInlined()
+	if n != 2 {
+		t.Errorf("marked %d frames, want 2", n)
+	}
+	if got := strings.Join(marked.stacks[0].frames, ";"); got != "A.run;B.work ~inlined;C.tiny ~inlined" {
+		t.Errorf("unexpected frames %q", got)
+	}
+	if got := strings.Join(marked.stacks[1].frames, ";"); got != "A.run;C.tiny" {
+		t.Errorf("stack without details changed: %q", got)
+	}
+	if sf.stacks[0].frames[1] != "B.work" {
+		t.Error("markInlined modified its input")
+	}
+
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", jfrFixture("cpu.jfr"), "--mark-inlined", "--group-by", "line"}, nil)
+	if code != 0 || !strings.Contains(stdout, ":67 ~inlined") {
+		t.Errorf("hot --mark-inlined: exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	for _, key := range []string{"class", "package", "frame-type"} {
+		code, _, stderr = runCLIForTest(t, []string{"hot", jfrFixture("cpu.jfr"), "--mark-inlined", "--group-by", key}, nil)
+		if code != exitUsage || !strings.Contains(stderr, "--mark-inlined cannot be combined with --group-by "+key) {
+			t.Errorf("--group-by %s: exit %d, stderr:\n%s", key, code, stderr)
+		}
+	}
+	code, _, stderr = runCLIForTest(t, []string{"hot", "-", "--mark-inlined"}, strings.NewReader("A;B 1\n"))
+	if code != exitUsage || !strings.Contains(stderr, "--mark-inlined requires a JFR file") {
+		t.Errorf("collapsed input: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestSourceFileOf(t *testing.T) {
+	tests := []struct {
+		frame string
+		fqn   bool
+		want  string
+	}{
+		{"com/example/UserService.login", false, "UserService.java"},
+		{"com.example.UserService.login", true, "com/example/UserService.java"},
+		{"com.example.UserService$Cache.get", false, "UserService.java"},
+		{"Workload$$Lambda$4.0x0000742f1c001000.run", false, "Workload.java"},
+		{"Workload.run", true, "Workload.java"},
+		{"libjvm.so.JavaThread::run", false, nativeFile},
+		{"libc.so.6.start_thread", false, nativeFile},
+		{"os::javaTimeMillis", false, nativeFile},
+		{"do_syscall_64", false, nativeFile},
+		{"[vdso]", false, nativeFile},
+	}
+	for _, tt := range tests {
+		if got := sourceFileOf(tt.frame, tt.fqn); got != tt.want {
+			t.Errorf("sourceFileOf(%q, %v) = %q, want %q", tt.frame, tt.fqn, got, tt.want)
+		}
+	}
+}
+
+func TestComputeFiles(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"app.Main.main", "app.UserService.login", "app.UserService$Cache.get"}, count: 6},
+		{frames: []string{"app.Main.main", "app.Repo.find", "app.UserService.hash"}, count: 3},
+		{frames: []string{"app.Main.main", "libc.so.6.write"}, count: 1},
+	})
+	got := computeFiles(sf, false)
+	want := []hotEntry{
+		{"Main.java", 0, 10},
+		{"UserService.java", 9, 9},
+		{"Repo.java", 0, 3},
+		{nativeFile, 1, 1},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("computeFiles = %+v, want %+v", got, want)
+	}
+
+	out := captureOutput(func() { cmdFiles(sf, 2, false) })
+	if !strings.Contains(out, "UserService.java") || !strings.Contains(out, "(2 of 4 files shown)") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestFilesCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"files", "-"}, strings.NewReader("a.A.run;a.B.work 3\na.A.run 1\n"))
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "A.java") || !strings.Contains(stdout, "B.java") {
+		t.Errorf("unexpected output:\n%s", stdout)
+	}
+}
+
+func TestClassifyThreadState(t *testing.T) {
+	tests := []struct {
+		frames []string
+		state  string
+		want   int
+	}{
+		{[]string{"A.run", "B.compute"}, "STATE_RUNNABLE", stateRunning},
+		{[]string{"A.run", "B.compute"}, "STATE_SLEEPING", stateOther},
+		{[]string{"A.run", "java/util/concurrent/locks/LockSupport.park", "jdk/internal/misc/Unsafe.park", "libc.so.6.__futex_abstimed_wait_cancelable64"}, "STATE_SLEEPING", stateParked},
+		{[]string{"A.run", "B.sync", "_complete_monitor_locking_Java", "libjvm.so.ObjectMonitor::TrySpin"}, "STATE_RUNNABLE", stateLock},
+		{[]string{"A.run", "sun/nio/ch/NioSocketImpl.read", "sun/nio/ch/Net.poll", "libc.so.6.__poll"}, "STATE_SLEEPING", stateIO},
+		// The frame closest to the leaf decides: parking inside an I/O call.
+		{[]string{"A.run", "java/io/FileInputStream.read", "jdk/internal/misc/Unsafe.park"}, "STATE_SLEEPING", stateParked},
+	}
+	for _, tt := range tests {
+		if got := classifyThreadState(tt.frames, tt.state); got != tt.want {
+			t.Errorf("classifyThreadState(%v, %s) = %s, want %s", tt.frames, tt.state, threadStateNames[got], threadStateNames[tt.want])
+		}
+	}
+}
+
+func TestThreadStatesGroupAndOutput(t *testing.T) {
+	entries := []threadStateEntry{
+		{name: "pool-1-thread-1", samples: 10, states: [numThreadStates]int{stateRunning: 5, stateParked: 5}, parkNanos: 2e9},
+		{name: "pool-1-thread-2", samples: 10, states: [numThreadStates]int{stateLock: 10}, lockNanos: 1e9},
+		{name: "main", samples: 4, states: [numThreadStates]int{stateIO: 4}},
+	}
+	groups := groupThreadStates(entries)
+	if len(groups) != 2 || groups[0].name != "pool-thread (2 threads)" || groups[0].samples != 20 ||
+		groups[0].states[stateLock] != 10 || groups[0].lockNanos != 1e9 || groups[0].parkNanos != 2e9 {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+
+	out := captureOutput(func() { cmdThreadStates(entries, 0, false) })
+	for _, want := range []string{"RUNNING", "LOCK-TIME", "PARK-TIME", "pool-1-thread-1", "50.0%", "100.0%"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestThreadStatesCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"threads", jfrFixture("multi.jfr"), "--states", "--group"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "lock-worker (3 threads)") || !strings.Contains(stdout, "LOCK-TIME") {
+		t.Errorf("unexpected output:\n%s", stdout)
+	}
+
+	code, stdout, _ = runCLIForTest(t, []string{"threads", jfrFixture("lock.jfr"), "--states", "-t", "lock-worker-1"}, nil)
+	if code != 0 || !strings.Contains(stdout, "lock-worker-1") || strings.Contains(stdout, "lock-worker-2") {
+		t.Errorf("thread filter: exit %d, output:\n%s", code, stdout)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"threads", "-", "--states"}, strings.NewReader("A;B 1\n"))
+	if code != exitUsage || !strings.Contains(stderr, "--states requires a JFR file") {
+		t.Errorf("collapsed input: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestPoolSaturation(t *testing.T) {
+	samples := []wallStateSample{
+		// Bucket 0: both workers busy, one of them half running.
+		{offsetNanos: 0, thread: "pool-1-thread-1", state: stateRunning, weight: 2},
+		{offsetNanos: 1, thread: "pool-1-thread-2", state: stateLock, weight: 1},
+		{offsetNanos: 2, thread: "pool-1-thread-2", state: stateRunning, weight: 1},
+		// Bucket 1: one worker parked, the other half in I/O.
+		{offsetNanos: 10, thread: "pool-1-thread-1", state: stateParked, weight: 2},
+		{offsetNanos: 11, thread: "pool-1-thread-2", state: stateIO, weight: 1},
+		{offsetNanos: 12, thread: "pool-1-thread-2", state: stateOther, weight: 1},
+		{offsetNanos: 5, thread: "main", state: stateRunning, weight: 4},
+	}
+	pools := computePoolSaturation(samples, 0, 20, 2, func(s string) string { return s })
+	if len(pools) != 1 {
+		t.Fatalf("expected one pool (main is not one), got %+v", pools)
+	}
+	p := pools[0]
+	if p.name != "pool-thread" || p.threads != 2 || p.samples != 8 || p.busyN != 5 || p.running != 3 {
+		t.Fatalf("unexpected pool %+v", p)
+	}
+	if p.busy[0] != 2 || p.busy[1] != 0.5 || p.avgBusy() != 1.25 || p.peakBusy() != 2 {
+		t.Errorf("busy=%v avg=%v peak=%v", p.busy, p.avgBusy(), p.peakBusy())
+	}
+	if sat, sampled := p.saturatedBuckets(); sat != 1 || sampled != 2 {
+		t.Errorf("saturated %d of %d buckets, want 1 of 2", sat, sampled)
+	}
+
+	out := captureOutput(func() { cmdThreadSaturation(pools, 10, 0) })
+	for _, want := range []string{"POOL", "pool-thread", "62.5%", "60.0%", "1/2", "saturated in 1 of 2 buckets; busy time mostly runs"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestThreadSaturationCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"threads", jfrFixture("wall.jfr"), "--saturation"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "lock-worker ") || !strings.Contains(stdout, "more threads mostly add contention") {
+		t.Errorf("unexpected output:\n%s", stdout)
+	}
+
+	code, stdout, _ = runCLIForTest(t, []string{"threads", jfrFixture("cpu.jfr"), "--saturation"}, nil)
+	if code != 0 || !strings.Contains(stdout, "no wall samples") {
+		t.Errorf("cpu recording: exit %d, output:\n%s", code, stdout)
+	}
+
+	for _, args := range [][]string{
+		{"threads", "-", "--saturation"},
+		{"threads", jfrFixture("wall.jfr"), "--saturation", "--states"},
+		{"threads", jfrFixture("wall.jfr"), "--saturation", "--event", "cpu"},
+	} {
+		code, _, stderr = runCLIForTest(t, args, strings.NewReader("A;B 1\n"))
+		if code != exitUsage || !strings.Contains(stderr, "--saturation") {
+			t.Errorf("%v: exit %d, stderr:\n%s", args, code, stderr)
+		}
+	}
+}
+
+func TestParseCollapsedByEvent(t *testing.T) {
+	in := "[event=cpu];A;B 3\n[event=wall];[t1];A;C 5\n[event=cpu];A;B 2\nX;Y 4\n"
+	sf, byEvent, unlabeled, err := parseCollapsedByEvent(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unlabeled != 4 {
+		t.Errorf("unlabeled=%d, want 4", unlabeled)
+	}
+	if sf.totalSamples != 14 {
+		t.Errorf("totalSamples=%d, want 14", sf.totalSamples)
+	}
+	if len(byEvent) != 2 || byEvent["cpu"].totalSamples != 5 || byEvent["wall"].totalSamples != 5 {
+		t.Fatalf("byEvent=%v", byEvent)
+	}
+	wall := byEvent["wall"].stacks[0]
+	if wall.thread != "t1" || fmt.Sprint(wall.frames) != "[A C]" {
+		t.Errorf("wall stack: thread=%q frames=%v, want t1 [A C]", wall.thread, wall.frames)
+	}
+
+	// Unlabeled input parses as before, with no per-event split.
+	_, byEvent, _, err = parseCollapsedByEvent(strings.NewReader("A;B 1\n"))
+	if err != nil || byEvent != nil {
+		t.Errorf("unlabeled input: byEvent=%v err=%v", byEvent, err)
+	}
+}
+
+func TestParseCollapsedParallelMatchesSequential(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	// Lines repeat across batches, with and without event labels.
+	events := []string{"[event=cpu];", "[event=wall];", ""}
+	var lines []string
+	for i := range 5*collapsedBatchLines + 17 {
+		k := i * 7919 % 3001
+		lines = append(lines, fmt.Sprintf("%s[t%d];A.run;B.step%d;C.leaf%d %d", events[k%3], k%4, k%97, k, 1+i%5))
+	}
+	want := parseCollapsedLines(lines, 0) // one batch on one goroutine
+	if len(want.stacks) >= len(lines) {
+		t.Fatalf("fixture has no repeated lines: %d stacks from %d lines", len(want.stacks), len(lines))
+	}
+
+	sf, byEvent, unlabeled, err := parseCollapsedByEvent(strings.NewReader(strings.Join(lines, "\n") + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sf.stacks) != len(want.stacks) {
+		t.Fatalf("got %d stacks, want %d", len(sf.stacks), len(want.stacks))
+	}
+	wantTotal, wantByEvent, wantUnlabeled := 0, map[string]int{}, 0
+	for i, w := range want.stacks {
+		g := sf.stacks[i]
+		if g.count != w.count || g.thread != w.thread || !slices.Equal(g.frames, w.frames) {
+			t.Fatalf("stack %d = %v %s %d, want %v %s %d", i, g.frames, g.thread, g.count, w.frames, w.thread, w.count)
+		}
+		wantTotal += w.count
+		if want.labels[i] == "" {
+			wantUnlabeled += w.count
+		} else {
+			wantByEvent[want.labels[i]] += w.count
+		}
+	}
+	if sf.totalSamples != wantTotal || unlabeled != wantUnlabeled {
+		t.Errorf("total %d unlabeled %d, want %d and %d", sf.totalSamples, unlabeled, wantTotal, wantUnlabeled)
+	}
+	for event, n := range wantByEvent {
+		if byEvent[event] == nil || byEvent[event].totalSamples != n {
+			t.Errorf("event %s: got %v, want %d samples", event, byEvent[event], n)
+		}
+	}
+}
+
+func TestCollapseAllEventsCLI(t *testing.T) {
+	code, collapsed, stderr := runCLIForTest(t, []string{"collapse", jfrFixture("multi.jfr"), "--event", "all"}, nil)
+	if code != 0 {
+		t.Fatalf("collapse --event all: exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(collapsed), "\n") {
+		if !strings.HasPrefix(line, eventLabelPrefix) {
+			t.Fatalf("unlabeled line: %q", line)
+		}
+	}
+
+	for _, event := range []string{"cpu", "wall", "lock"} {
+		_, want, _ := runCLIForTest(t, []string{"collapse", jfrFixture("multi.jfr"), "--event", event}, nil)
+		var b strings.Builder
+		for _, line := range strings.Split(collapsed, "\n") {
+			if rest, ok := strings.CutPrefix(line, eventLabelPrefix+event+"];"); ok {
+				b.WriteString(rest + "\n")
+			}
+		}
+		if b.String() != want {
+			t.Errorf("%s lines differ from collapse --event %s", event, event)
+		}
+
+		_, fromJFR, _ := runCLIForTest(t, []string{"hot", jfrFixture("multi.jfr"), "--event", event}, nil)
+		code, fromText, stderr := runCLIForTest(t, []string{"hot", "-", "--event", event}, strings.NewReader(collapsed))
+		if code != 0 {
+			t.Fatalf("hot - --event %s: exit %d, stderr:\n%s", event, code, stderr)
+		}
+		if fromText != fromJFR {
+			t.Errorf("hot --event %s differs after round trip:\n%s\nvs JFR:\n%s", event, fromText, fromJFR)
+		}
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"hot", "-", "--event", "bogus"}, strings.NewReader(collapsed))
+	if code != exitUsage || !strings.Contains(stderr, `event "bogus" not found`) {
+		t.Errorf("unknown event: exit %d, stderr:\n%s", code, stderr)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"collapse", jfrFixture("multi.jfr"), "--event", "all", "--timestamps"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "cannot be combined with --timestamps") {
+		t.Errorf("--timestamps: exit %d, stderr:\n%s", code, stderr)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"collapse", "-", "--event", "all"}, strings.NewReader("A;B 1\n"))
+	if code != exitUsage || !strings.Contains(stderr, "--event all requires") {
+		t.Errorf("unlabeled input: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestHotRate(t *testing.T) {
+	tests := []struct {
+		name      string
+		pctx      profileContext
+		wantSpan  int64
+		wantCores bool
+		wantErr   bool
+	}{
+		{"whole recording", profileContext{eventType: "cpu", spanNanos: 4e9, fromNanos: -1, toNanos: -1, cpuInterval: 1e7}, 4e9, true, false},
+		{"window", profileContext{eventType: "cpu", spanNanos: 4e9, fromNanos: 1e9, toNanos: 3e9, cpuInterval: 1e7}, 2e9, true, false},
+		{"window past end", profileContext{eventType: "cpu", spanNanos: 4e9, fromNanos: 1e9, toNanos: 9e9, cpuInterval: 1e7}, 3e9, true, false},
+		{"wall has no cores", profileContext{eventType: "wall", spanNanos: 4e9, fromNanos: -1, toNanos: -1, cpuInterval: 1e7}, 4e9, false, false},
+		{"unknown interval", profileContext{eventType: "cpu", spanNanos: 4e9, fromNanos: -1, toNanos: -1}, 4e9, false, false},
+		{"unknown duration", profileContext{eventType: "cpu", fromNanos: -1, toNanos: -1}, 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newHotRate(&tt.pctx)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", r)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.spanNanos != tt.wantSpan || (r.cpuInterval > 0) != tt.wantCores {
+				t.Errorf("got span=%d interval=%d, want span=%d cores=%v", r.spanNanos, r.cpuInterval, tt.wantSpan, tt.wantCores)
+			}
+		})
+	}
+
+	// 200 samples at 10ms over 1s = 2 cores.
+	sf := makeStackFile([]stack{
+		{frames: []string{"a.A.run", "a.A.work"}, count: 150},
+		{frames: []string{"a.A.run", "a.A.idle"}, count: 50},
+	})
+	out := captureOutput(func() {
+		cmdHotRate(sf, 10, false, false, 0, &hotRate{spanNanos: 1e9, cpuInterval: 1e7})
+	})
+	for _, want := range []string{
+		"Duration: 1.0s  Rate: 200.0 samples/s  CPU: 2.00 cores",
+		"SAMPLES/S  CORES",
+		"     150      150.0   1.50",
+		"     200      200.0   2.00",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestHotRateCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", jfrFixture("cpu.jfr"), "--rate", "--top", "3"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	// cpu.jfr was recorded with the default 10ms interval.
+	if !strings.Contains(stdout, "samples/s  CPU: ") || !strings.Contains(stdout, "CORES") {
+		t.Errorf("missing rate summary or cores column:\n%s", stdout)
+	}
+
+	code, stdout, stderr = runCLIForTest(t, []string{"hot", jfrFixture("wall.jfr"), "--rate", "--from", "1s", "--to", "2s"}, nil)
+	if code != 0 {
+		t.Fatalf("wall: exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "Duration: 1.0s") || strings.Contains(stdout, "CORES") {
+		t.Errorf("wall window: want 1s span and no cores column:\n%s", stdout)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"hot", "-", "--rate"}, strings.NewReader("A;B 1\n"))
+	if code != exitUsage || !strings.Contains(stderr, "--rate requires the recording duration") {
+		t.Errorf("collapsed: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestInspectCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"inspect", jfrFixture("multi.jfr"), "-m", "Workload.allocateObjects"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"Method: Workload.allocateObjects\n",
+		"EVENT            SELF%  TOTAL%      SELF     TOTAL  BELOW\n",
+		"alloc           100.0%  100.0%       489       489  244.5 MB allocated (100.0% of 244.5 MB)\n",
+		"lock              0.0%    0.0%         0         0  0.0s waited (0.0% of 6.2s)\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q in:\n%s", want, stdout)
+		}
+	}
+	// cpu is listed before wall, alloc and lock.
+	if cpu, lock := strings.Index(stdout, "\ncpu "), strings.Index(stdout, "\nlock "); cpu < 0 || cpu > lock {
+		t.Errorf("events out of order:\n%s", stdout)
+	}
+
+	// The method's self and total shares match hot for the same event.
+	_, hot, _ := runCLIForTest(t, []string{"hot", jfrFixture("multi.jfr"), "--event", "cpu", "--top", "3"}, nil)
+	if !strings.Contains(hot, "Workload.allocateObjects                             23.9%   24.3%") ||
+		!strings.Contains(stdout, "cpu              23.9%   24.3%") {
+		t.Errorf("cpu shares differ from hot:\n%s\nhot:\n%s", stdout, hot)
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"inspect", jfrFixture("multi.jfr"), "-m", "Workload"}, nil)
+	if !strings.Contains(stdout, "Methods matching 'Workload' (") || !strings.Contains(stdout, "more (use --exact") {
+		t.Errorf("several matches: missing method list:\n%s", stdout)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"inspect", jfrFixture("multi.jfr"), "-m", "Workload.lockStp"}, nil)
+	if !strings.Contains(stdout, "no stacks matching 'Workload.lockStp'") || !strings.Contains(stdout, "similar: Workload.lockStep") {
+		t.Errorf("no match: missing suggestion:\n%s", stdout)
+	}
+
+	for _, tc := range []struct {
+		args  []string
+		stdin io.Reader
+		want  string
+	}{
+		{[]string{"inspect", jfrFixture("multi.jfr")}, nil, "-m/--method required"},
+		{[]string{"inspect", jfrFixture("multi.jfr"), "-m", "x", "--event", "cpu"}, nil, "--event does not apply"},
+		{[]string{"inspect", "-", "-m", "A"}, strings.NewReader("A;B 1\n"), "inspect requires a JFR file"},
+	} {
+		code, _, stderr := runCLIForTest(t, tc.args, tc.stdin)
+		if code != exitUsage || !strings.Contains(stderr, tc.want) {
+			t.Errorf("%v: exit %d, stderr:\n%s", tc.args, code, stderr)
+		}
+	}
+}
+
+func TestCompareEvents(t *testing.T) {
+	byEvent := map[string]*stackFile{
+		"cpu": makeStackFile([]stack{
+			{frames: []string{"a.A.run", "a.A.compute"}, count: 90},
+			{frames: []string{"a.A.run", "a.A.read"}, count: 10},
+		}),
+		"wall": makeStackFile([]stack{
+			{frames: []string{"a.A.run", "a.A.compute"}, count: 40},
+			{frames: []string{"a.A.run", "a.A.read"}, count: 60},
+		}),
+	}
+	ranked := computeCompareEvents(byEvent, false, "")
+	var got []string
+	for _, r := range ranked {
+		got = append(got, fmt.Sprintf("%s cpu=%.0f wall=%.0f note=%q", r.name, r.pct["cpu"], r.pct["wall"], divergenceNote(r, byEvent)))
+	}
+	want := []string{
+		`A.run cpu=100 wall=100 note=""`,
+		`A.compute cpu=90 wall=40 note=""`,
+		`A.read cpu=10 wall=60 note="wall>cpu: blocking"`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("default sort:\n got %v\nwant %v", got, want)
+	}
+
+	ranked = computeCompareEvents(byEvent, false, "wall")
+	if ranked[1].name != "A.read" {
+		t.Errorf("--sort wall: second row %q, want A.read", ranked[1].name)
+	}
+
+	// Without a cpu column there is nothing to diverge from.
+	delete(byEvent, "cpu")
+	for _, r := range computeCompareEvents(byEvent, false, "") {
+		if note := divergenceNote(r, byEvent); note != "" {
+			t.Errorf("%s: unexpected note %q without cpu", r.name, note)
+		}
+	}
+}
+
+func TestCompareEventsCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"compare-events", jfrFixture("multi.jfr"), "--top", "50"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "METHOD") || !strings.Contains(stdout, "     CPU     WALL    ALLOC     LOCK  NOTE\n") {
+		t.Errorf("unexpected header:\n%s", stdout)
+	}
+	for _, want := range []string{
+		"Workload.allocateObjects                              24.3%     2.4%   100.0%        -  alloc>cpu: GC pressure\n",
+		"Workload.lockStep                                     48.2%     7.6%        -   100.0%\n",
+		"(50 of ",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q in:\n%s", want, stdout)
+		}
+	}
+	if strings.Contains(stderr, "Event:") {
+		t.Errorf("event selection echoed for compare-events:\n%s", stderr)
+	}
+
+	for _, tc := range []struct {
+		args  []string
+		stdin io.Reader
+		want  string
+	}{
+		{[]string{"compare-events", jfrFixture("multi.jfr"), "--event", "cpu"}, nil, "--event does not apply"},
+		{[]string{"compare-events", jfrFixture("multi.jfr"), "--sort", "bogus"}, nil, `--sort: event "bogus" has no samples (available: cpu, wall, alloc, lock)`},
+		{[]string{"compare-events", "-"}, strings.NewReader("A;B 1\n"), "compare-events requires a JFR, pprof or event-labeled collapsed input"},
+	} {
+		code, _, stderr := runCLIForTest(t, tc.args, tc.stdin)
+		if code != exitUsage || !strings.Contains(stderr, tc.want) {
+			t.Errorf("%v: exit %d, stderr:\n%s", tc.args, code, stderr)
+		}
+	}
+}
+
+func TestParseThreadFrameTid(t *testing.T) {
+	tests := []struct {
+		frame, name, tid string
+	}{
+		{"[main]", "main", ""},
+		{"[main tid=1234]", "main", "1234"},
+		{"[GC Thread#0 tid=7]", "GC Thread#0", "7"},
+		{"[tid=42]", "tid=42", "42"},
+		{"[main tid=abc]", "main", ""},
+		{"[tid=]", "tid=", ""},
+		{"main", "", ""},
+	}
+	for _, tt := range tests {
+		name, tid := parseThreadFrame(tt.frame)
+		if name != tt.name || tid != tt.tid {
+			t.Errorf("parseThreadFrame(%q) = (%q, %q), want (%q, %q)", tt.frame, name, tid, tt.name, tt.tid)
+		}
+	}
+
+	for _, tt := range []struct {
+		name, tid, pattern string
+		want               bool
+	}{
+		{"worker", "7", "work", true},
+		{"worker", "7", "7", true},
+		{"worker", "7", "tid=7", true},
+		{"worker", "17", "7", false},
+		{"worker", "", "tid=7", false},
+	} {
+		if got := matchesThread(tt.name, tt.tid, tt.pattern); got != tt.want {
+			t.Errorf("matchesThread(%q, %q, %q) = %v, want %v", tt.name, tt.tid, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestAPQRoundTrip(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"A.run", "B.work"}, lines: []uint32{10, 42}, count: 6, thread: "pool-1-thread-1"},
+		{frames: []string{"A.run", "C.idle"}, lines: []uint32{0, 0}, count: 2, thread: "main", tid: "7"},
+		{frames: []string{"A.run"}, lines: []uint32{11}, count: 1},
+	})
+	parsed := &parsedProfile{eventCounts: map[string]int{"cpu": 12}, spanNanos: 5e9, cpuInterval: 1e7,
+		settings: map[string]string{"jstackdepth": "2048"}, truncated: map[string]int{"cpu": 1}}
+	var buf bytes.Buffer
+	if err := writeAPQ(&buf, "p.jfr", parsed, map[string]*stackFile{"cpu": sf, "live": {}}); err != nil {
+		t.Fatal(err)
+	}
+	if !isAPQ(buf.Bytes()) {
+		t.Fatalf("missing magic: %q", buf.Bytes()[:8])
+	}
+	got, err := readAPQ(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.eventCounts["cpu"] != 12 || got.spanNanos != 5e9 || got.cpuInterval != 1e7 ||
+		got.settings["jstackdepth"] != "2048" || got.truncated["cpu"] != 1 {
+		t.Errorf("metadata lost: %+v", got)
+	}
+	if _, ok := got.stacksByEvent["live"]; ok {
+		t.Error("empty event was written")
+	}
+	cpu := got.stacksByEvent["cpu"]
+	if cpu.totalSamples != 9 || len(cpu.stacks) != 3 {
+		t.Fatalf("unexpected stacks: %+v", cpu)
+	}
+	for i, want := range sf.stacks {
+		g := cpu.stacks[i]
+		if fmt.Sprint(g.frames, g.lines, g.thread, g.tid, g.count) != fmt.Sprint(want.frames, want.lines, want.thread, want.tid, want.count) {
+			t.Errorf("stack %d: got %+v, want %+v", i, g, want)
+		}
+	}
+
+	var again bytes.Buffer
+	writeAPQ(&again, "p.jfr", parsed, map[string]*stackFile{"cpu": sf})
+	if !bytes.Equal(buf.Bytes(), again.Bytes()) {
+		t.Error("writing the same profile twice gave different bytes")
+	}
+
+	if _, err := readAPQ(strings.NewReader("APQ1\nnot gzip")); err == nil {
+		t.Error("expected an error for a corrupt body")
+	}
+	var bad bytes.Buffer
+	bad.WriteString(apqMagic)
+	gz := gzip.NewWriter(&bad)
+	gz.Write([]byte(`{"version":1,"frames":["A"],"events":{"cpu":{"samples":1,"stacks":[{"f":[3],"n":1}]}}}`))
+	gz.Close()
+	if _, err := readAPQ(&bad); err == nil || !strings.Contains(err.Error(), "frame index 3 out of range") {
+		t.Errorf("bad frame index: %v", err)
+	}
+}
+
+func TestAPQCLI(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "multi.apq")
+	code, _, stderr := runCLIForTest(t, []string{"collapse", jfrFixture("multi.jfr"), "--apq", out}, nil)
+	if code != 0 || !strings.Contains(stderr, "Saved ") {
+		t.Fatalf("collapse --apq: exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, args := range [][]string{
+		{"hot", "--event", "wall"},
+		{"lines", "-m", "Workload", "--event", "cpu"},
+		{"threads", "--event", "lock"},
+	} {
+		_, fromJFR, _ := runCLIForTest(t, append([]string{args[0], jfrFixture("multi.jfr")}, args[1:]...), nil)
+		code, fromAPQ, stderr := runCLIForTest(t, append([]string{args[0], out}, args[1:]...), nil)
+		if code != 0 || fromAPQ != fromJFR {
+			t.Errorf("%v: exit %d, differs from JFR:\n%s\nvs\n%s\nstderr:\n%s", args, code, fromAPQ, fromJFR, stderr)
+		}
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, stdout, stderr := runCLIForTest(t, []string{"events", "-"}, bytes.NewReader(data))
+	if code != 0 || !strings.Contains(stdout, "Input: ap-query model (.apq)") || !strings.Contains(stdout, "wall") {
+		t.Errorf("events from stdin: exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	code, stdout, _ = runCLIForTest(t, []string{"info", out}, nil)
+	if code != 0 || !strings.Contains(stdout, "Duration: 5.0s") {
+		t.Errorf("info: exit %d, stdout:\n%s", code, stdout)
+	}
+
+	text := filepath.Join(t.TempDir(), "text.apq")
+	code, _, stderr = runCLIForTest(t, []string{"collapse", "-", "--apq", text}, strings.NewReader("[main];A;B 3\n"))
+	if code != 0 {
+		t.Fatalf("collapsed input: exit %d, stderr:\n%s", code, stderr)
+	}
+	if _, stdout, _ = runCLIForTest(t, []string{"collapse", text}, nil); stdout != "[main];A;B 3\n" {
+		t.Errorf("collapsed round trip: %q", stdout)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"collapse", jfrFixture("cpu.jfr"), "--apq", text, "--timestamps"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "--apq cannot be combined with --timestamps") {
+		t.Errorf("--timestamps: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestCollapsedTidsCLI(t *testing.T) {
+	input := "[main tid=11];A;B 5\n[tid=42];A;C 3\n[worker tid=7];A;D 2\n[worker tid=12];A;D 1\n[plain];X 1\n"
+
+	code, stdout, stderr := runCLIForTest(t, []string{"threads", "-"}, strings.NewReader(input))
+	if code != 0 {
+		t.Fatalf("threads: exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"THREAD                           SAMPLES     PCT  TID\n",
+		"main                                   5   41.7%  11\n",
+		"tid=42                                 3   25.0%  42\n",
+		"worker                                 3   25.0%  7,12\n",
+		"plain                                  1    8.3%\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("threads: missing %q in:\n%s", want, stdout)
+		}
+	}
+
+	// -t selects by tid as well as by name.
+	code, stdout, _ = runCLIForTest(t, []string{"collapse", "-", "-t", "12"}, strings.NewReader(input))
+	if code != 0 || stdout != "[worker tid=12];A;D 1\n" {
+		t.Errorf("-t 12: exit %d, got:\n%s", code, stdout)
+	}
+
+	// Markers, tids included, survive a collapse round trip.
+	_, stdout, _ = runCLIForTest(t, []string{"collapse", "-"}, strings.NewReader(input))
+	for _, line := range strings.Split(strings.TrimSpace(input), "\n") {
+		if !strings.Contains(stdout, line+"\n") {
+			t.Errorf("round trip lost %q:\n%s", line, stdout)
+		}
+	}
+
+	// Without tids the output keeps its usual columns.
+	_, stdout, _ = runCLIForTest(t, []string{"threads", "-"}, strings.NewReader("[main];A 1\n"))
+	if strings.Contains(stdout, "TID") {
+		t.Errorf("unexpected TID column:\n%s", stdout)
+	}
+}
+
+func TestThreadNormalizer(t *testing.T) {
+	tests := []struct {
+		rules []string
+		in    string
+		want  string
+	}{
+		{[]string{"digits"}, "CompilerThread0", "CompilerThread"},
+		{[]string{"digits"}, "GC Thread#54", "GC Thread"},
+		{[]string{"digits"}, "pool-1-thread-2", "pool-1-thread"},
+		{[]string{"suffix"}, "pool-1-thread-2", "pool-1-thread"},
+		{[]string{"suffix"}, "worker-3-7", "worker"},
+		{[]string{"suffix"}, "CompilerThread0", "CompilerThread0"},
+		{[]string{"forkjoin"}, "ForkJoinPool-1-worker-3", "ForkJoinPool-worker"},
+		{[]string{"forkjoin"}, "ForkJoinPool.commonPool-worker-7", "ForkJoinPool.commonPool-worker"},
+		{[]string{"forkjoin"}, "ForkJoinPool-1-worker-3-extra", "ForkJoinPool-1-worker-3-extra"},
+		{[]string{`grpc-(\w+)-executor-\d+=grpc-$1`}, "grpc-default-executor-12", "grpc-default"},
+		{[]string{`a=b=c`}, "a=b", "c"},
+		{[]string{"suffix", "digits"}, "http-nio-8080-exec10-3", "http-nio-8080-exec"},
+		{[]string{`.*=`}, "main", "(unnamed)"},
+		{[]string{"digits"}, "", ""},
+	}
+	for _, tt := range tests {
+		n, err := newThreadNormalizer(tt.rules)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.rules, err)
+		}
+		if got := n.name(tt.in); got != tt.want {
+			t.Errorf("%v: name(%q) = %q, want %q", tt.rules, tt.in, got, tt.want)
+		}
+	}
+
+	if n, err := newThreadNormalizer(nil); n != nil || err != nil {
+		t.Errorf("no rules: got %v, %v; want nil, nil", n, err)
+	}
+	for _, bad := range []string{"bogus", "=x", "a(=b"} {
+		if _, err := newThreadNormalizer([]string{bad}); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestThreadNormalizeCLI(t *testing.T) {
+	input := "[ForkJoinPool-1-worker-3];A;B 5\n[ForkJoinPool-1-worker-4];A;B 3\n[main];A;C 2\n"
+
+	code, stdout, stderr := runCLIForTest(t, []string{"threads", "-", "--thread-normalize", "forkjoin"}, strings.NewReader(input))
+	if code != 0 {
+		t.Fatalf("threads: exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "ForkJoinPool-worker                    8   80.0%\n") {
+		t.Errorf("threads: pool not merged:\n%s", stdout)
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"tree", "-", "--by-thread", "--thread-normalize", "forkjoin"}, strings.NewReader(input))
+	if !strings.Contains(stdout, "[ForkJoinPool-worker]") {
+		t.Errorf("tree --by-thread: pool root missing:\n%s", stdout)
+	}
+
+	dir := t.TempDir()
+	before := filepath.Join(dir, "before.txt")
+	after := filepath.Join(dir, "after.txt")
+	os.WriteFile(before, []byte("[ForkJoinPool-1-worker-1];A 80\n[main];A 20\n"), 0o644)
+	os.WriteFile(after, []byte("[ForkJoinPool-2-worker-9];A 50\n[main];A 50\n"), 0o644)
+	_, stdout, _ = runCLIForTest(t, []string{"diff", before, after, "--flat-threads", "--thread-normalize", "forkjoin"}, nil)
+	if !strings.Contains(stdout, "ForkJoinPool-worker") || strings.Contains(stdout, "ForkJoinPool-2-worker-9") {
+		t.Errorf("diff --flat-threads: pool not normalized:\n%s", stdout)
+	}
+
+	code, stdout, stderr = runCLIForTest(t, []string{"threads", jfrFixture("wall.jfr"), "--states", "--thread-normalize", "digits"}, nil)
+	if code != 0 {
+		t.Fatalf("threads --states: exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "\nGC Thread ") || strings.Contains(stdout, "GC Thread#0") {
+		t.Errorf("threads --states: GC threads not merged:\n%s", stdout)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"threads", "-", "--thread-normalize", "bogus"}, strings.NewReader(input))
+	if code != exitUsage || !strings.Contains(stderr, `unknown rule "bogus"`) {
+		t.Errorf("bad rule: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Synthetic JFR chunks for JDK events the fixtures lack
+// ---------------------------------------------------------------------------
+
+// testJFRClass is a metadata class for buildTestJFR. Fields are "name:ID",
+// with "name:@ID" for constant pool references and "name:ID[]" for arrays.
+// A class with the ID of a base class replaces it.
+type testJFRClass struct {
+	id     int
+	name   string
+	fields []string
+}
+
+// Type IDs of the classes every buildTestJFR chunk declares.
+const (
+	testJFRLong   = 1
+	testJFRInt    = 2
+	testJFRBool   = 5
+	testJFRString = 6
+	testJFRThread = 9
+)
+
+var testJFRBaseClasses = []testJFRClass{
+	{1, "long", nil}, {2, "int", nil}, {3, "short", nil}, {4, "float", nil},
+	{5, "boolean", nil}, {6, "java.lang.String", nil}, {7, "jdk.types.FrameType", nil},
+	{8, "jdk.types.ThreadState", nil}, {9, "java.lang.Thread", nil}, {10, "java.lang.Class", nil},
+	{11, "jdk.types.Method", nil}, {12, "jdk.types.Package", nil}, {13, "jdk.types.Symbol", nil},
+	{14, "jdk.types.StackTrace", nil}, {15, "jdk.types.ClassLoader", nil}, {16, "jdk.types.StackFrame", nil},
+}
+
+// testJFRStartTicks is the chunk's start tick; ticks are nanoseconds.
+const testJFRStartTicks = 1_000_000
+
+// testJFRValues encodes field values: integers as varints, strings as
+// UTF-8 strings and booleans as one byte.
+func testJFRValues(values ...any) []byte {
+	var b []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case int:
+			b = binary.AppendUvarint(b, uint64(v))
+		case string:
+			b = append(b, 3)
+			b = binary.AppendUvarint(b, uint64(len(v)))
+			b = append(b, v...)
+		case bool:
+			if v {
+				b = append(b, 1)
+			} else {
+				b = append(b, 0)
+			}
+		default:
+			panic(fmt.Sprintf("testJFRValues: unsupported %T", v))
+		}
+	}
+	return b
+}
+
+// testJFRRecord prefixes an event body with its size (padded to 4 bytes)
+// and type.
+func testJFRRecord(typ int, body []byte) []byte {
+	payload := binary.AppendUvarint(nil, uint64(typ))
+	payload = append(payload, body...)
+	size := uint64(len(payload) + 4)
+	b := []byte{byte(size) | 0x80, byte(size>>7) | 0x80, byte(size>>14) | 0x80, byte(size >> 21)}
+	return append(b, payload...)
+}
+
+// buildTestJFR returns a one-chunk, one-second recording with classes
+// (besides the base ones), one checkpoint holding pools (type ID → encoded
+// "id value" entries) and events (bodies built with testJFRValues, starting
+// with the type ID).
+func buildTestJFR(classes []testJFRClass, pools map[int][][]byte, events [][]byte) []byte {
+	chunk := make([]byte, jfrChunkHeaderSize)
+	for _, ev := range events {
+		typ, n := binary.Uvarint(ev)
+		chunk = append(chunk, testJFRRecord(int(typ), ev[n:])...)
+	}
+
+	cpOffset := len(chunk)
+	cp := testJFRValues(testJFRStartTicks, 0, 0, 1, len(pools))
+	poolTypes := make([]int, 0, len(pools))
+	for typ := range pools {
+		poolTypes = append(poolTypes, typ)
+	}
+	sort.Ints(poolTypes)
+	for _, typ := range poolTypes {
+		cp = append(cp, testJFRValues(typ, len(pools[typ]))...)
+		for _, entry := range pools[typ] {
+			cp = append(cp, entry...)
+		}
+	}
+	chunk = append(chunk, testJFRRecord(1, cp)...)
+
+	metaOffset := len(chunk)
+	var strs []string
+	index := make(map[string]int)
+	str := func(s string) int {
+		if i, ok := index[s]; ok {
+			return i
+		}
+		index[s] = len(strs)
+		strs = append(strs, s)
+		return len(strs) - 1
+	}
+	var tree []byte
+	element := func(name string, attrs [][2]string, children int) {
+		tree = append(tree, testJFRValues(str(name), len(attrs))...)
+		for _, a := range attrs {
+			tree = append(tree, testJFRValues(str(a[0]), str(a[1]))...)
+		}
+		tree = append(tree, testJFRValues(children)...)
+	}
+	var all []testJFRClass
+	for _, base := range testJFRBaseClasses {
+		if !slices.ContainsFunc(classes, func(c testJFRClass) bool { return c.id == base.id }) {
+			all = append(all, base)
+		}
+	}
+	all = append(all, classes...)
+	element("root", nil, 1)
+	element("metadata", nil, len(all))
+	for _, c := range all {
+		element("class", [][2]string{{"id", strconv.Itoa(c.id)}, {"name", c.name}}, len(c.fields))
+		for _, f := range c.fields {
+			name, typ, _ := strings.Cut(f, ":")
+			attrs := [][2]string{{"name", name}}
+			if strings.HasPrefix(typ, "@") {
+				typ = typ[1:]
+				attrs = append(attrs, [2]string{"constantPool", "true"})
+			}
+			if strings.HasSuffix(typ, "[]") {
+				typ = strings.TrimSuffix(typ, "[]")
+				attrs = append(attrs, [2]string{"dimension", "1"})
+			}
+			attrs = append(attrs, [2]string{"class", typ})
+			element("field", attrs, 0)
+		}
+	}
+	meta := testJFRValues(testJFRStartTicks, 0, 1, len(strs))
+	for _, s := range strs {
+		meta = append(meta, testJFRValues(s)...)
+	}
+	chunk = append(chunk, testJFRRecord(0, append(meta, tree...))...)
+
+	binary.BigEndian.PutUint32(chunk[0:], jfrChunkMagic)
+	binary.BigEndian.PutUint32(chunk[4:], 0x20000)
+	binary.BigEndian.PutUint64(chunk[8:], uint64(len(chunk)))
+	binary.BigEndian.PutUint64(chunk[16:], uint64(cpOffset))
+	binary.BigEndian.PutUint64(chunk[24:], uint64(metaOffset))
+	binary.BigEndian.PutUint64(chunk[32:], 1_700_000_000_000_000_000)
+	binary.BigEndian.PutUint64(chunk[40:], uint64(time.Second))
+	binary.BigEndian.PutUint64(chunk[48:], testJFRStartTicks)
+	binary.BigEndian.PutUint64(chunk[56:], uint64(time.Second))
+	binary.BigEndian.PutUint32(chunk[64:], 1)
+	return chunk
+}
+
+// writeTestJFR writes a buildTestJFR recording to a temporary file.
+func writeTestJFR(t *testing.T, classes []testJFRClass, pools map[int][][]byte, events [][]byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "synthetic.jfr")
+	if err := os.WriteFile(path, buildTestJFR(classes, pools, events), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testJFRStackClasses declares the classes, methods, symbols, stack traces
+// and class loaders the parser resolves, for the pools of testJFRStackPools.
+func testJFRStackClasses() []testJFRClass {
+	return []testJFRClass{
+		{10, "java.lang.Class", []string{"classLoader:@15", "name:@13", "package:@12", "modifiers:2"}},
+		{11, "jdk.types.Method", []string{"type:@10", "name:@13", "descriptor:@13", "modifiers:2", "hidden:5"}},
+		{13, "jdk.types.Symbol", []string{"string:6"}},
+		{14, "jdk.types.StackTrace", []string{"truncated:5", "frames:16[]"}},
+		{15, "jdk.types.ClassLoader", []string{"type:@10", "name:@13"}},
+		{16, "jdk.types.StackFrame", []string{"method:@11", "lineNumber:2", "bytecodeIndex:2", "type:@7"}},
+	}
+}
+
+// testJFRStackPools has stack trace 1 (App.main → Plugin.load →
+// ClassLoader.loadClass) and 2 (App.main), class loader 1 named "app" and
+// class loader 2, unnamed, of class com.example.Plugin.
+func testJFRStackPools() map[int][][]byte {
+	return map[int][][]byte{
+		13: {
+			testJFRValues(1, "com/example/App"), testJFRValues(2, "main"), testJFRValues(3, "com/example/Plugin"),
+			testJFRValues(4, "load"), testJFRValues(5, "app"), testJFRValues(6, "java/lang/ClassLoader"),
+			testJFRValues(7, "loadClass"), testJFRValues(8, "()V"),
+		},
+		10: {testJFRValues(1, 0, 1, 0, 0), testJFRValues(2, 0, 3, 0, 0), testJFRValues(3, 0, 6, 0, 0)},
+		11: {testJFRValues(1, 1, 2, 8, 0, false), testJFRValues(2, 2, 4, 8, 0, false), testJFRValues(3, 3, 7, 8, 0, false)},
+		14: {
+			testJFRValues(1, false, 3, 3, 10, 0, 0, 2, 20, 0, 0, 1, 5, 0, 0), // leaf first
+			testJFRValues(2, false, 1, 1, 6, 0, 0),
+		},
+		15: {testJFRValues(1, 3, 5), testJFRValues(2, 2, 0)},
+	}
+}
+
+// ---------------------------------------------------------------------------
+// safepoints
+// ---------------------------------------------------------------------------
+
+// safepointTestJFR has three safepoints: #1 and #3 for G1CollectForAllocation
+// (the second of them without a synchronization event), #2 for
+// RevokeBias, plus a VM operation that runs outside a safepoint.
+func safepointTestJFR(t *testing.T) string {
+	const (
+		begin = 100 + iota
+		sync
+		end
+		vmop
+		opType
+	)
+	classes := []testJFRClass{
+		{opType, "jdk.types.VMOperationType", []string{"type:6"}},
+		{begin, "jdk.SafepointBegin", []string{"startTime:1", "duration:1", "eventThread:@9", "safepointId:1", "totalThreadCount:2", "jniCriticalThreadCount:2"}},
+		{sync, "jdk.SafepointStateSynchronization", []string{"startTime:1", "duration:1", "eventThread:@9", "safepointId:1", "initialThreadCount:2", "runningThreadCount:2", "iterations:2"}},
+		{end, "jdk.SafepointEnd", []string{"startTime:1", "duration:1", "eventThread:@9", "safepointId:1"}},
+		{vmop, "jdk.ExecuteVMOperation", []string{"startTime:1", "duration:1", "eventThread:@9", "operation:@104", "safepoint:5", "blocking:5", "caller:@9", "safepointId:1"}},
+	}
+	pools := map[int][][]byte{
+		opType: {testJFRValues(1, "G1CollectForAllocation"), testJFRValues(2, "RevokeBias"), testJFRValues(3, "HandshakeAllThreads")},
+	}
+	ms := int(time.Millisecond)
+	at := func(offset int) int { return testJFRStartTicks + offset }
+	events := [][]byte{
+		// #1 at 100ms: 1ms to safepoint, 10ms pause.
+		testJFRValues(begin, at(100*ms), 2*ms, 0, 1, 8, 0),
+		testJFRValues(sync, at(100*ms), 1*ms, 0, 1, 8, 1, 3),
+		testJFRValues(vmop, at(102*ms), 7*ms, 0, 1, true, true, 0, 1),
+		testJFRValues(end, at(109*ms), 1*ms, 0, 1),
+		// #2 at 300ms: 3ms to safepoint, 4ms pause.
+		testJFRValues(begin, at(300*ms), 3*ms, 0, 2, 8, 0),
+		testJFRValues(sync, at(300*ms), 3*ms, 0, 2, 8, 2, 5),
+		testJFRValues(vmop, at(303*ms), ms/2, 0, 2, true, true, 0, 2),
+		testJFRValues(end, at(303*ms+ms/2), ms/2, 0, 2),
+		// Not a safepoint.
+		testJFRValues(vmop, at(400*ms), 5*ms, 0, 3, false, false, 0, 0),
+		// #3 at 600ms: operation event only.
+		testJFRValues(vmop, at(600*ms), 20*ms, 0, 1, true, true, 0, 3),
+	}
+	return writeTestJFR(t, classes, pools, events)
+}
+
+func TestParseSafepoints(t *testing.T) {
+	path := safepointTestJFR(t)
+	sps, err := parseSafepoints(path, -1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprint(sps)
+	want := "[{1 100000000 10000000 1000000 G1CollectForAllocation} {2 300000000 4000000 3000000 RevokeBias} {3 600000000 20000000 -1 G1CollectForAllocation}]"
+	if got != want {
+		t.Errorf("safepoints:\n got %s\nwant %s", got, want)
+	}
+
+	sps, err = parseSafepoints(path, int64(200*time.Millisecond), int64(500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sps) != 1 || sps[0].id != 2 {
+		t.Errorf("window 200ms-500ms: got %v", sps)
+	}
+
+	ops := safepointsByOperation([]safepoint{
+		{id: 1, pauseNanos: 10, ttspNanos: 1, operation: "A"},
+		{id: 2, pauseNanos: 30, ttspNanos: -1, operation: "B"},
+		{id: 3, pauseNanos: 15, ttspNanos: 4, operation: "A"},
+	})
+	if got := fmt.Sprint(ops); got != "[{B 1 30 30 0 0 0} {A 2 25 15 5 4 2}]" {
+		t.Errorf("by operation: got %s", got)
+	}
+}
+
+func TestSafepointsCLI(t *testing.T) {
+	path := safepointTestJFR(t)
+	code, stdout, stderr := runCLIForTest(t, []string{"safepoints", path}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"Safepoints: 3\n",
+		"Pause:              total 34.00ms  max 20.00ms  avg 11.33ms\n",
+		"Time to safepoint:  total 4.00ms  max 3.00ms  avg 2.00ms\n",
+		"G1CollectForAllocation                        2    30.00ms    20.00ms     1.00ms\n",
+		"RevokeBias                                    1     4.00ms     4.00ms     3.00ms\n",
+		"   20.00ms  at 0.6s  G1CollectForAllocation\n",
+		"   10.00ms  at 0.1s  G1CollectForAllocation (ttsp 1.00ms)\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q in:\n%s", want, stdout)
+		}
+	}
+	if strings.Contains(stdout, "HandshakeAllThreads") {
+		t.Errorf("non-safepoint operation reported:\n%s", stdout)
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"safepoints", jfrFixture("cpu.jfr")}, nil)
+	if !strings.Contains(stdout, "no safepoint events") {
+		t.Errorf("cpu.jfr: expected no-events message, got:\n%s", stdout)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"safepoints", "-"}, strings.NewReader("A;B 1\n"))
+	if code != exitUsage || !strings.Contains(stderr, "requires a JFR file") {
+		t.Errorf("collapsed input: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// classes
+// ---------------------------------------------------------------------------
+
+// classesTestJFR loads two classes through the "app" loader from
+// Plugin.load, one through an unnamed loader of class com.example.Plugin
+// and one in the bootstrap loader, and has statistics and metaspace
+// snapshots around them.
+func classesTestJFR(t *testing.T) string {
+	const (
+		classLoad = 120 + iota
+		classUnload
+		loadingStats
+		loaderStats
+		metaspaceSizes
+		metaspaceSummary
+		gcWhen
+	)
+	classes := append(testJFRStackClasses(), []testJFRClass{
+		{classLoad, "jdk.ClassLoad", []string{"startTime:1", "duration:1", "eventThread:@9", "stackTrace:@14", "loadedClass:@10", "definingClassLoader:@15", "initiatingClassLoader:@15"}},
+		{classUnload, "jdk.ClassUnload", []string{"startTime:1", "eventThread:@9", "unloadedClass:@10", "definingClassLoader:@15"}},
+		{loadingStats, "jdk.ClassLoadingStatistics", []string{"startTime:1", "loadedClassCount:1", "unloadedClassCount:1"}},
+		{loaderStats, "jdk.ClassLoaderStatistics", []string{"startTime:1", "classLoader:@15", "parentClassLoader:@15", "classLoaderData:1", "classCount:1", "chunkSize:1", "blockSize:1"}},
+		{metaspaceSizes, "jdk.types.MetaspaceSizes", []string{"committed:1", "used:1", "reserved:1"}},
+		{metaspaceSummary, "jdk.MetaspaceSummary", []string{"startTime:1", "gcId:2", "when:@126", "gcThreshold:1", "metaspace:124", "dataSpace:124", "classSpace:124"}},
+		{gcWhen, "jdk.types.GCWhen", []string{"when:6"}},
+	}...)
+	pools := testJFRStackPools()
+	pools[gcWhen] = [][]byte{testJFRValues(1, "Before GC"), testJFRValues(2, "After GC")}
+	ms := int(time.Millisecond)
+	at := func(offset int) int { return testJFRStartTicks + offset }
+	mb := 1 << 20
+	events := [][]byte{
+		testJFRValues(loadingStats, at(100*ms), 1000, 5),
+		testJFRValues(metaspaceSummary, at(200*ms), 1, 1, 0, 12*mb, 10*mb, 20*mb, 0, 0, 0, 0, 0, 0),
+		testJFRValues(classLoad, at(300*ms), 0, 0, 1, 2, 1, 1),
+		testJFRValues(classLoad, at(310*ms), 0, 0, 1, 2, 1, 1),
+		testJFRValues(classLoad, at(400*ms), 0, 0, 2, 2, 2, 2),
+		testJFRValues(classUnload, at(500*ms), 0, 2, 2),
+		testJFRValues(metaspaceSummary, at(800*ms), 2, 2, 0, 16*mb, 15*mb, 20*mb, 0, 0, 0, 0, 0, 0),
+		testJFRValues(classLoad, at(900*ms), 0, 0, 0, 3, 0, 0),
+		testJFRValues(loadingStats, at(900*ms), 1004, 6),
+		testJFRValues(loaderStats, at(950*ms), 1, 0, 0, 500, 2*mb, 0),
+		testJFRValues(loaderStats, at(950*ms), 2, 1, 0, 20, 64<<10, 0),
+	}
+	return writeTestJFR(t, classes, pools, events)
+}
+
+func TestParseClasses(t *testing.T) {
+	path := classesTestJFR(t)
+	rep, err := parseClasses(path, -1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.loaded != 4 || rep.unloaded != 1 {
+		t.Errorf("loaded/unloaded: got %d/%d, want 4/1", rep.loaded, rep.unloaded)
+	}
+	if got := fmt.Sprint(rep.hasStats, rep.firstLoaded, rep.lastLoaded, rep.firstUnload, rep.lastUnload); got != "true 1000 1004 5 6" {
+		t.Errorf("statistics: got %s", got)
+	}
+	if got := fmt.Sprint(sortedClassLoaders(rep.loaders)); got != "[{app 2 0 500 2097152} {com.example.Plugin 1 1 20 65536} {bootstrap 1 0 -1 -1}]" {
+		t.Errorf("loaders: got %s", got)
+	}
+	if got := fmt.Sprint(rep.metaspace); got != "[{200000000 10485760 12582912} {800000000 15728640 16777216}]" {
+		t.Errorf("metaspace: got %s", got)
+	}
+	if len(rep.stacks.stacks) != 2 || rep.stacks.stacks[0].count != 2 ||
+		strings.Join(rep.stacks.stacks[0].frames, ";") != "com/example/App.main;com/example/Plugin.load;java/lang/ClassLoader.loadClass" {
+		t.Errorf("stacks: got %v", rep.stacks.stacks)
+	}
+
+	rep, err = parseClasses(path, int64(250*time.Millisecond), int64(450*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.loaded != 3 || rep.hasStats || len(rep.metaspace) != 0 {
+		t.Errorf("window 250ms-450ms: loaded %d, stats %v, metaspace %v", rep.loaded, rep.hasStats, rep.metaspace)
+	}
+}
+
+func TestClassesCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"classes", classesTestJFR(t)}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"Classes loaded: 4  unloaded: 1",
+		"JVM classes:    1000 → 1004 loaded (+4), 6 unloaded (+1)\n",
+		"Metaspace used: 10.0 MB → 15.0 MB (+5.0 MB, peak 15.0 MB), committed 16.0 MB\n",
+		"app                                                       2        0       500     2.0 MB\n",
+		"com.example.Plugin                                        1        1        20    64.0 KB\n",
+		"  2 loaded (66.7%)\n    ClassLoader.loadClass\n    Plugin.load\n    App.main\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q in:\n%s", want, stdout)
+		}
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"classes", jfrFixture("cpu.jfr")}, nil)
+	if !strings.Contains(stdout, "no class-loading or metaspace events") {
+		t.Errorf("cpu.jfr: expected no-events message, got:\n%s", stdout)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// live object events
+// ---------------------------------------------------------------------------
+
+// liveTestJFR has three live objects allocated from Plugin.load (1 KB each)
+// and one from App.main (4 KB).
+func liveTestJFR(t *testing.T) string {
+	const liveObject = 130
+	classes := append(testJFRStackClasses(),
+		testJFRClass{liveObject, "profiler.LiveObject", []string{"startTime:1", "eventThread:@9", "stackTrace:@14", "objectClass:@10", "allocationSize:1", "allocationTime:1"}})
+	ms := int(time.Millisecond)
+	var events [][]byte
+	for i := 0; i < 3; i++ {
+		events = append(events, testJFRValues(liveObject, testJFRStartTicks+(100+i)*ms, 0, 1, 2, 1024, 0))
+	}
+	events = append(events, testJFRValues(liveObject, testJFRStartTicks+500*ms, 0, 2, 1, 4096, 0))
+	return writeTestJFR(t, classes, testJFRStackPools(), events)
+}
+
+func TestLiveEventCLI(t *testing.T) {
+	path := liveTestJFR(t)
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", path, "--event", "live"}, nil)
+	if code != 0 {
+		t.Fatalf("hot --event live: exit %d, stderr:\n%s", code, stderr)
+	}
+	if !regexp.MustCompile(`ClassLoader\.loadClass\s+75\.0%\s+75\.0%\s+3\n`).MatchString(stdout) {
+		t.Errorf("hot --event live: loadClass row missing:\n%s", stdout)
+	}
+
+	// The only event in the file is selected without --event.
+	code, stdout, _ = runCLIForTest(t, []string{"hot", path}, nil)
+	if code != 0 || !strings.Contains(stdout, "ClassLoader.loadClass") {
+		t.Errorf("hot: exit %d, output:\n%s", code, stdout)
+	}
+
+	code, stdout, stderr = runCLIForTest(t, []string{"inspect", path, "-m", "Plugin.load"}, nil)
+	if code != 0 {
+		t.Fatalf("inspect: exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "3.0 KB live (42.9% of 7.0 KB)") {
+		t.Errorf("inspect: live bytes missing:\n%s", stdout)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// heap
+// ---------------------------------------------------------------------------
+
+// heapTestJFR has three GCs, at 100ms, 500ms and 900ms, with the heap
+// growing from 100 MB to 160 MB before GC and from 20 MB to 60 MB after.
+func heapTestJFR(t *testing.T) string {
+	const (
+		virtualSpace = 140 + iota
+		heapSummary
+		heapConfig
+		gcWhen
+		oopMode
+		gcConfig
+		gcName
+	)
+	classes := []testJFRClass{
+		{virtualSpace, "jdk.types.VirtualSpace", []string{"start:1", "committedEnd:1", "committedSize:1", "reservedEnd:1", "reservedSize:1"}},
+		{heapSummary, "jdk.GCHeapSummary", []string{"startTime:1", "gcId:2", "when:@143", "heapSpace:140", "heapUsed:1"}},
+		{heapConfig, "jdk.GCHeapConfiguration", []string{"startTime:1", "minSize:1", "maxSize:1", "initialSize:1", "usesCompressedOops:5", "compressedOopsMode:@144", "objectAlignment:1", "heapAddressBits:2"}},
+		{gcWhen, "jdk.types.GCWhen", []string{"when:6"}},
+		{oopMode, "jdk.types.NarrowOopMode", []string{"mode:6"}},
+		{gcConfig, "jdk.GCConfiguration", []string{"startTime:1", "youngCollector:@146", "oldCollector:@146", "parallelGCThreads:2", "concurrentGCThreads:2", "usesDynamicGCThreads:5", "isExplicitGCConcurrent:5", "isExplicitGCDisabled:5", "pauseTarget:1", "gcTimeRatio:2"}},
+		{gcName, "jdk.types.GCName", []string{"name:6"}},
+	}
+	pools := map[int][][]byte{
+		gcWhen:  {testJFRValues(1, "Before GC"), testJFRValues(2, "After GC")},
+		oopMode: {testJFRValues(1, "Zero based")},
+		gcName:  {testJFRValues(1, "G1New"), testJFRValues(2, "G1Old")},
+	}
+	ms, mb := int(time.Millisecond), 1<<20
+	at := func(offset int) int { return testJFRStartTicks + offset }
+	summary := func(offset, gcID, when, used int) []byte {
+		return testJFRValues(heapSummary, at(offset), gcID, when, 0, 0, 256*mb, 0, 1024*mb, used)
+	}
+	events := [][]byte{
+		testJFRValues(heapConfig, at(0), 8*mb, 1024*mb, 256*mb, true, 1, 8, 32),
+		testJFRValues(gcConfig, at(0), 1, 2, 4, 1, true, false, false, 200, 12),
+		summary(100*ms, 1, 1, 100*mb), summary(100*ms, 1, 2, 20*mb),
+		summary(500*ms, 2, 1, 150*mb), summary(500*ms, 2, 2, 40*mb),
+		summary(900*ms, 3, 1, 160*mb), summary(900*ms, 3, 2, 60*mb),
+	}
+	return writeTestJFR(t, classes, pools, events)
+}
+
+func TestHeapSeries(t *testing.T) {
+	samples := []heapSample{{offsetNanos: 10, used: 5}, {offsetNanos: 12, used: 3}, {offsetNanos: 70, used: 9}}
+	if got := fmt.Sprint(heapSeries(samples, 0, 100, 5)); got != "[5 5 5 9 9]" {
+		t.Errorf("heapSeries: got %s", got)
+	}
+	// Columns before the first GC take its reading instead of 0.
+	late := []heapSample{{offsetNanos: 50, used: 7}, {offsetNanos: 90, used: 4}}
+	if got := fmt.Sprint(heapSeries(late, 0, 100, 5)); got != "[7 7 7 7 4]" {
+		t.Errorf("heapSeries before first GC: got %s", got)
+	}
+	if got := fmt.Sprint(heapSeries(nil, 0, 100, 3)); got != "[0 0 0]" {
+		t.Errorf("heapSeries without samples: got %s", got)
+	}
+}
+
+func TestHeapCLI(t *testing.T) {
+	path := heapTestJFR(t)
+	code, stdout, stderr := runCLIForTest(t, []string{"heap", path, "--width", "10"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"Heap: initial 256.0 MB  min 8.0 MB  max 1.0 GB  (G1New / G1Old)\n",
+		"GCs: 3  committed: 256.0 MB\n",
+		"Used before GC: 100.0 MB → 160.0 MB (+60.0 MB), peak 160.0 MB\n",
+		"Used after GC:  20.0 MB → 60.0 MB (+40.0 MB), min 20.0 MB\n",
+		"before GC  ▅▅▅▅▅█████\n",
+		"after GC   ▂▂▂▂▂▃▃▃▃▄\n",
+		"           0.0s  1.0s  (scale 0 to 160.0 MB)\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q in:\n%s", want, stdout)
+		}
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"heap", path, "--from", "400ms"}, nil)
+	if !strings.Contains(stdout, "GCs: 2 ") || !strings.Contains(stdout, "Used after GC:  40.0 MB → 60.0 MB") {
+		t.Errorf("--from 400ms: got:\n%s", stdout)
+	}
+
+	// async-profiler writes one heap summary at the end of a recording.
+	_, stdout, _ = runCLIForTest(t, []string{"heap", jfrFixture("cpu.jfr")}, nil)
+	if !strings.Contains(stdout, "GCs: 1  committed: 500.0 MB\n") {
+		t.Errorf("cpu.jfr: got:\n%s", stdout)
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"heap", safepointTestJFR(t)}, nil)
+	if !strings.Contains(stdout, "no heap summary events") {
+		t.Errorf("no heap events: got:\n%s", stdout)
+	}
+}
+
+func TestFormatProfilerSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]string
+		want     []string
+	}{
+		{"none", nil, nil},
+		{
+			"defaults",
+			map[string]string{"version": "4.3", "engine": "perf_events", "cstack": "vm", "event": "cpu", "interval": "0", "jstackdepth": "2048"},
+			[]string{
+				"Profiler: async-profiler 4.3 (engine perf_events, cstack vm)",
+				"Settings: cpu interval 10ms (default), stack depth 2048",
+			},
+		},
+		{
+			"explicit intervals",
+			map[string]string{"event": "cpu-clock", "interval": "1000000", "wall": "20000000", "alloc": "1048576", "live": "true", "lock": "0"},
+			[]string{"Settings: cpu interval 1ms, wall interval 20ms, alloc interval 1.0 MB live, lock threshold 0s"},
+		},
+		{
+			"hardware counter",
+			map[string]string{"event": "branch-misses", "interval": "1000"},
+			[]string{"Settings: branch-misses interval 1000 events"},
+		},
+		{
+			"shallow stack depth",
+			map[string]string{"jstackdepth": "64"},
+			[]string{
+				"Settings: stack depth 64",
+				"Note: stack depth limit 64 is below the default 2048; deeper stacks are cut off at the root (asprof -j to raise it)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatProfilerSettings(tt.settings)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfoProfilerSettingsCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"info", jfrFixture("multi.jfr"), "--expand", "0"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr: %s", code, stderr)
+	}
+	for _, want := range []string{
+		"Profiler: async-profiler 4.3 (engine perf_events, cstack vm)\n",
+		"Settings: cpu interval 10ms (default), wall interval 50ms (default), alloc interval 512.0 KB (default), lock threshold 10µs, stack depth 2048\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q in:\n%s", want, stdout)
+		}
+	}
+
+	code, stdout, stderr = runCLIForTest(t, []string{"info", "-"}, strings.NewReader("main;work 5\n"))
+	if code != 0 {
+		t.Fatalf("collapsed: exit %d, stderr: %s", code, stderr)
+	}
+	if strings.Contains(stdout, "Settings:") {
+		t.Errorf("collapsed input should have no settings, got:\n%s", stdout)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// truncated stacks
+// ---------------------------------------------------------------------------
+
+func TestInferTruncation(t *testing.T) {
+	deep := func(leaf string, n int) string {
+		frames := make([]string, n-1)
+		for i := range frames {
+			frames[i] = fmt.Sprintf("f%d", i)
+		}
+		return strings.Join(append(frames, leaf), ";")
+	}
+	tests := []struct {
+		name      string
+		collapsed string
+		want      string // samples/total@depth
+	}{
+		{"shallow", "a;b;c 5\na;b;d 5\na;b;e 5\n", "0/0@0"},
+		{
+			"three stacks at the limit",
+			deep("x", 20) + " 2\n" + deep("y", 20) + " 3\n" + deep("z", 20) + " 5\n" + "main;work 10\n",
+			"10/20@20",
+		},
+		{
+			"single deep recursion",
+			deep("x", 20) + " 8\n" + deep("y", 20) + " 2\n" + deep("z", 12) + " 10\n",
+			"0/0@0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sf, err := parseCollapsed(strings.NewReader(tt.collapsed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := inferTruncation(sf)
+			if got := fmt.Sprintf("%d/%d@%d", tr.samples, tr.total, tr.depth); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// truncatedTestJFR is liveTestJFR with stack trace 1 flagged as truncated:
+// three of its four samples are truncated.
+func truncatedTestJFR(t *testing.T) string {
+	const liveObject = 130
+	classes := append(testJFRStackClasses(),
+		testJFRClass{liveObject, "profiler.LiveObject", []string{"startTime:1", "eventThread:@9", "stackTrace:@14", "objectClass:@10", "allocationSize:1", "allocationTime:1"}})
+	pools := testJFRStackPools()
+	pools[14][0] = testJFRValues(1, true, 3, 3, 10, 0, 0, 2, 20, 0, 0, 1, 5, 0, 0)
+	ms := int(time.Millisecond)
+	var events [][]byte
+	for i := 0; i < 3; i++ {
+		events = append(events, testJFRValues(liveObject, testJFRStartTicks+(100+i)*ms, 0, 1, 2, 1024, 0))
+	}
+	events = append(events, testJFRValues(liveObject, testJFRStartTicks+500*ms, 0, 2, 1, 4096, 0))
+	return writeTestJFR(t, classes, pools, events)
+}
+
+func TestTruncatedStacksCLI(t *testing.T) {
+	path := truncatedTestJFR(t)
+	const want = "75.0% of live samples (3/4) have truncated stacks; callers and tree miss their root frames"
+
+	code, _, stderr := runCLIForTest(t, []string{"callers", path, "-m", "loadClass"}, nil)
+	if code != 0 {
+		t.Fatalf("callers: exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "warning: "+want+"\n") {
+		t.Errorf("callers: missing warning in stderr:\n%s", stderr)
+	}
+
+	// The JFR flag is counted within the --from/--to window.
+	_, _, stderr = runCLIForTest(t, []string{"hot", path, "--from", "400ms"}, nil)
+	if strings.Contains(stderr, "truncated") {
+		t.Errorf("hot --from 400ms: unexpected warning:\n%s", stderr)
+	}
+
+	code, stdout, stderr := runCLIForTest(t, []string{"info", path}, nil)
+	if code != 0 {
+		t.Fatalf("info: exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "Warning: "+want+"\n") || strings.Contains(stderr, "truncated") {
+		t.Errorf("info: want warning on stdout only, stdout:\n%s\nstderr:\n%s", stdout, stderr)
+	}
+
+	_, _, stderr = runCLIForTest(t, []string{"hot", liveTestJFR(t)}, nil)
+	if strings.Contains(stderr, "truncated") {
+		t.Errorf("untruncated: unexpected warning:\n%s", stderr)
+	}
+}
+
+func TestMergeRecursive(t *testing.T) {
+	sf := &stackFile{
+		stacks: []stack{
+			{frames: []string{"main", "walk", "walk", "walk", "leaf"}, lines: []uint32{1, 10, 11, 12, 20}, count: 3},
+			{frames: []string{"a", "b", "a", "a"}, lines: []uint32{1, 2, 3, 4}, count: 2},
+		},
+		totalSamples: 5,
+	}
+	got := sf.mergeRecursive()
+	want := []string{
+		"[main walk leaf] [1 12 20] 3",
+		"[a b a] [1 2 4] 2",
+	}
+	for i, st := range got.stacks {
+		if s := fmt.Sprint(st.frames, " ", st.lines, " ", st.count); s != want[i] {
+			t.Errorf("stack %d: got %q, want %q", i, s, want[i])
+		}
+	}
+	if got.totalSamples != 5 {
+		t.Errorf("totalSamples = %d, want 5", got.totalSamples)
+	}
+}
+
+func TestCallersMergeRecursiveCLI(t *testing.T) {
+	input := "main;handle;walk;walk;walk;walk;leaf 6\nmain;other;walk;walk;leaf 4\n"
+	code, stdout, stderr := runCLIForTest(t, []string{"callers", "-", "-m", "leaf", "--merge-recursive"}, strings.NewReader(input))
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	want := "# 10 samples in 'leaf' (100.0% of 10 total)\n[100.0%] leaf\n  [100.0%] walk\n    [60.0%] handle\n      [60.0%] main\n    [40.0%] other\n      [40.0%] main\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+}
+
+func TestMethodIDsCLI(t *testing.T) {
+	if got := methodID("com/example/Svc.work"); got != "aaf9657570a7" || methodID("com.example.Svc.work") != got {
+		t.Fatalf("methodID = %q, want aaf9657570a7 for both separators", got)
+	}
+
+	input := "com/example/App.main;com/example/Svc.work 7\ncom/example/App.main 3\n"
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", "-", "--ids"}, strings.NewReader(input))
+	if code != 0 {
+		t.Fatalf("hot: exit %d, stderr:\n%s", code, stderr)
+	}
+	if !regexp.MustCompile(`(?m)^ID\s+METHOD\s+SELF%`).MatchString(stdout) ||
+		!regexp.MustCompile(`(?m)^aaf9657570a7 com\.example\.Svc\.work\s+70\.0%`).MatchString(stdout) {
+		t.Errorf("hot --ids: got:\n%s", stdout)
+	}
+
+	code, stdout, stderr = runCLIForTest(t, []string{"methods", "-", "Svc", "--ids"}, strings.NewReader(input))
+	if code != 0 {
+		t.Fatalf("methods: exit %d, stderr:\n%s", code, stderr)
+	}
+	if !regexp.MustCompile(`(?m)^aaf9657570a7 com\.example\.Svc\.work\s+70\.0%`).MatchString(stdout) {
+		t.Errorf("methods --ids: got:\n%s", stdout)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// treemap
+// ---------------------------------------------------------------------------
+
+func TestTreemapPath(t *testing.T) {
+	tests := []struct {
+		frame string
+		want  string
+	}{
+		{"com/example/App.main", "[com.example App main]"},
+		{"Workload.compute", "[(default) Workload compute]"},
+		{"libc.so.6.__sched_yield", "[(native) __sched_yield]"},
+		{"[unknown]", "[(native) [unknown]]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(treemapPath(tt.frame)); got != tt.want {
+			t.Errorf("treemapPath(%q) = %s, want %s", tt.frame, got, tt.want)
+		}
+	}
+}
+
+func TestBuildTreemap(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"main", "com/a/X.f"}, count: 60},
+		{frames: []string{"main", "com/a/X.g"}, count: 30},
+		{frames: []string{"main", "com/a/X.h"}, count: 1},
+		{frames: []string{"main", "com/a/X.i"}, count: 1},
+		{frames: []string{"main", "com/b/Y.f"}, count: 8},
+	})
+	root := buildTreemap(sf, 5)
+	var describe func(n *treemapNode) string
+	describe = func(n *treemapNode) string {
+		s := fmt.Sprintf("%s=%d", n.name, n.value)
+		if len(n.children) > 0 {
+			var parts []string
+			for _, c := range n.children {
+				parts = append(parts, describe(c))
+			}
+			s += "{" + strings.Join(parts, " ") + "}"
+		}
+		return s
+	}
+	want := "all=100{com.a=92{X=92{f=60 g=30 (other)=2}} com.b=8{Y=8{f=8}}}"
+	if got := describe(root); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestSquarify(t *testing.T) {
+	values := []int{6, 6, 4, 3, 2, 2, 1}
+	r := treemapRect{0, 0, 600, 400}
+	rects := squarify(values, r)
+	if len(rects) != len(values) {
+		t.Fatalf("got %d rects, want %d", len(rects), len(values))
+	}
+	const eps = 1e-6
+	area := 0.0
+	for i, rc := range rects {
+		want := float64(values[i]) / 24 * 600 * 400
+		if math.Abs(rc.w*rc.h-want) > 1e-3 {
+			t.Errorf("rect %d: area %.2f, want %.2f", i, rc.w*rc.h, want)
+		}
+		if rc.x < -eps || rc.y < -eps || rc.x+rc.w > 600+eps || rc.y+rc.h > 400+eps {
+			t.Errorf("rect %d out of bounds: %+v", i, rc)
+		}
+		area += rc.w * rc.h
+	}
+	if math.Abs(area-600*400) > 1e-3 {
+		t.Errorf("total area %.2f, want %d", area, 600*400)
+	}
+}
+
+func TestTreemapCLI(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "map.html")
+	input := "main;com/example/Svc.work 7\nmain;com/example/Svc.<init> 3\n"
+	code, _, stderr := runCLIForTest(t, []string{"treemap", "-", "--html", out}, strings.NewReader(input))
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "Wrote treemap of 10 samples (1 packages) to "+out) {
+		t.Errorf("stderr: %s", stderr)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(data)
+	for _, want := range []string{
+		"<!DOCTYPE html>",
+		"<h3>-: 10 samples</h3>",
+		"<title>com.example.Svc.work\n7 samples (70.0%)</title>",
+		"<title>com.example.Svc.&lt;init&gt;\n3 samples (30.0%)</title>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("missing %q in:\n%s", want, page)
+		}
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"treemap", "-"}, strings.NewReader(input))
+	if code != exitUsage || !strings.Contains(stderr, "--html OUTPUT required") {
+		t.Errorf("without --html: exit %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestFilterThreadModesCLI(t *testing.T) {
+	input := "[w-1 tid=5];main;A.a;B.b 3\n[w-2];main;A.a;B.b 4\n[w-1 tid=5];main;A.a 2\nmain;A.a;C.c 1\n"
+	tests := []struct {
+		flag string
+		want string
+	}{
+		{"--thread-split", "# w-1 tid=5 (5 samples, 50.0%)\n[w-1 tid=5];A.a;B.b 3\n[w-1 tid=5];A.a 2\n\n" +
+			"# w-2 (4 samples, 40.0%)\n[w-2];A.a;B.b 4\n\n" +
+			"# (no thread) (1 samples, 10.0%)\nA.a;C.c 1\n"},
+		{"--merge-threads", "A.a;B.b 7\nA.a 2\nA.a;C.c 1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.flag, func(t *testing.T) {
+			code, stdout, stderr := runCLIForTest(t, []string{"filter", "-", "-m", "A.a", tt.flag}, strings.NewReader(input))
+			if code != 0 {
+				t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+			}
+			if stdout != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", stdout, tt.want)
+			}
+			// The output reads back as collapsed text with all samples.
+			code, stdout, _ = runCLIForTest(t, []string{"hot", "-"}, strings.NewReader(stdout))
+			if code != 0 || !regexp.MustCompile(`A\.a\s+20\.0%\s+100\.0%\s+10\n`).MatchString(stdout) {
+				t.Errorf("round trip: exit %d, output:\n%s", code, stdout)
+			}
+		})
+	}
+
+	code, _, stderr := runCLIForTest(t, []string{"filter", "-", "-m", "A.a", "--thread-split", "--merge-threads"}, strings.NewReader(input))
+	if code != exitUsage || !strings.Contains(stderr, "mutually exclusive") {
+		t.Errorf("both flags: exit %d, stderr: %s", code, stderr)
+	}
+}
+
+func TestTreemapHue(t *testing.T) {
+	tests := []struct {
+		palette, pkg, leaf string
+		hueLo, hueHi       int
+	}{
+		{"java", "com.example", "work", 100, 139},
+		{"java", nativeFile, "JavaThread::run", 45, 54},
+		{"java", nativeFile, "__sched_yield", 0, 14},
+		{"mem", "com.example", "", 170, 229},
+		{"package", "com.example", "", 0, 359},
+	}
+	for _, tt := range tests {
+		hue, _ := treemapHue(tt.palette, tt.pkg, tt.leaf)
+		if hue < tt.hueLo || hue > tt.hueHi {
+			t.Errorf("treemapHue(%q, %q, %q) = %d, want %d..%d", tt.palette, tt.pkg, tt.leaf, hue, tt.hueLo, tt.hueHi)
+		}
+	}
+}
+
+func TestTreemapPresentationCLI(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "map.html")
+	input := "main;com/example/Svc.work 7\nmain;com/example/Svc.run 3\n"
+	code, _, stderr := runCLIForTest(t, []string{"treemap", "-", "--html", out,
+		"--title", "Checkout <prod>", "--subtitle", "build 42", "--count-name", "bytes", "--width", "600", "--palette", "mem"},
+		strings.NewReader(input))
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(data)
+	for _, want := range []string{
+		"<title>Checkout &lt;prod&gt;</title>",
+		"<h3>Checkout &lt;prod&gt;: 10 bytes</h3>\n<p>build 42</p>\n",
+		`width="600" height="400"`,
+		"<title>com.example.Svc.work\n7 bytes (70.0%)</title>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("missing %q in:\n%s", want, page)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"--palette", "js"},
+		{"--width", "50"},
+	} {
+		code, _, stderr := runCLIForTest(t, append([]string{"treemap", "-", "--html", out}, args...), strings.NewReader(input))
+		if code != exitUsage {
+			t.Errorf("%v: exit %d, stderr: %s", args, code, stderr)
+		}
+	}
+}
+
+func TestExplainCLI(t *testing.T) {
+	input := "[main];A;B 5\n[main];A;Thread.sleep 3\n[worker];C 2\n"
+
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", "-", "--explain", "-t", "main", "--no-idle"}, strings.NewReader(input))
+	if code != 0 {
+		t.Fatalf("hot --explain: exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"# hot: methods ranked by self samples",
+		"# input: - (stdin)\n",
+		"# event: untyped (collapsed text has no event types), 10 samples\n",
+		"# thread filter: \"main\" keeps 8/10 samples\n",
+		"# idle leaf frames removed (--no-idle): 5/8 samples remain\n",
+		"# result: 5 samples in 1 distinct stacks\n\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q:\n%s", want, stdout)
+		}
+	}
+	if strings.Index(stdout, "# result:") > strings.Index(stdout, "B") {
+		t.Errorf("explanation should precede the results:\n%s", stdout)
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"hot", "-"}, strings.NewReader(input))
+	if strings.Contains(stdout, "# ") {
+		t.Errorf("explanation printed without --explain:\n%s", stdout)
+	}
+
+	// Explained collapsed output still reads back as collapsed text.
+	_, collapsed, _ := runCLIForTest(t, []string{"collapse", "-", "--explain"}, strings.NewReader(input))
+	code, stdout, stderr = runCLIForTest(t, []string{"threads", "-"}, strings.NewReader(collapsed))
+	if code != 0 || !strings.Contains(stdout, "main") || !strings.Contains(stdout, "worker") {
+		t.Errorf("collapse --explain output does not round-trip: exit %d\n%s%s", code, stdout, stderr)
+	}
+}
+
+func TestRecordAgentOptions(t *testing.T) {
+	got, err := agentOptions("/tmp/out.jfr", "wall", "5ms")
+	if err != nil || got != "start,event=wall,interval=5ms,file=/tmp/out.jfr" {
+		t.Errorf("agentOptions = %q, %v", got, err)
+	}
+	if _, err := agentOptions("/tmp/a,b.jfr", "cpu", ""); err == nil {
+		t.Error("comma in output path should be rejected")
+	}
+
+	agent := "-agentpath:/ap/lib/libasyncProfiler.so=start"
+	argv, env := launchWithAgent([]string{"/usr/bin/java", "-jar", "app.jar"}, agent, []string{"HOME=/h"})
+	if fmt.Sprint(argv) != "[/usr/bin/java "+agent+" -jar app.jar]" || fmt.Sprint(env) != "[HOME=/h]" {
+		t.Errorf("java launch: argv %v env %v", argv, env)
+	}
+	argv, env = launchWithAgent([]string{"./gradlew", "test"}, agent, []string{"JAVA_TOOL_OPTIONS=-Xmx1g", "HOME=/h"})
+	if fmt.Sprint(argv) != "[./gradlew test]" || fmt.Sprint(env) != "[HOME=/h JAVA_TOOL_OPTIONS=-Xmx1g "+agent+"]" {
+		t.Errorf("launcher: argv %v env %v", argv, env)
+	}
+}
+
+func TestRecordCLI(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "libasyncProfiler.so")
+	os.WriteFile(lib, nil, 0o644)
+	out := filepath.Join(dir, "out.jfr")
+	fixture, _ := filepath.Abs(jfrFixture("cpu.jfr"))
+
+	// The stand-in command checks that the agent was passed and writes the
+	// recording the agent would have written.
+	script := `case "$JAVA_TOOL_OPTIONS" in *"-agentpath:` + lib + `=start,event=cpu,file=` + out + `"*) cp ` + fixture + ` ` + out + `;; esac`
+	code, stdout, stderr := runCLIForTest(t, []string{"record", "--lib", lib, "-o", out, "--", "sh", "-c", script}, nil)
+	if code != 0 {
+		t.Fatalf("record: exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "Recording: "+out) || !strings.Contains(stdout, "=== THREADS") {
+		t.Errorf("record did not analyze the recording:\nstdout:\n%s\nstderr:\n%s", stdout, stderr)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"record", "--lib", lib, "-o", filepath.Join(dir, "none.jfr"), "--", "sh", "-c", "exit 1"}, nil)
+	if code != exitIO || !strings.Contains(stderr, "exited with status 1") || !strings.Contains(stderr, "no recording at") {
+		t.Errorf("missing recording: exit %d, stderr:\n%s", code, stderr)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"record", "--lib", lib, "sh"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "separate the command with --") {
+		t.Errorf("missing --: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestRecordK8sCLI(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "kubectl.log")
+	fixture, _ := filepath.Abs(jfrFixture("cpu.jfr"))
+	// Stand-in kubectl: logs its arguments and serves the fixture for cat.
+	fake := "#!/bin/sh\necho \"$@\" >> " + log + "\ncase \"$*\" in *' -- cat '*) cat " + fixture + ";; esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "kubectl"), []byte(fake), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	out := filepath.Join(dir, "pod.jfr")
+	code, stdout, stderr := runCLIForTest(t, []string{"record", "--k8s", "pod/my-app-7c9f", "-c", "app", "-n", "prod", "-d", "5", "-e", "wall", "-o", out}, nil)
+	if code != 0 {
+		t.Fatalf("record --k8s: exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "Recording wall for 5s in pod/my-app-7c9f (container app) in namespace prod") ||
+		!strings.Contains(stdout, "=== THREADS") {
+		t.Errorf("record --k8s did not analyze:\nstdout:\n%s\nstderr:\n%s", stdout, stderr)
+	}
+	data, _ := os.ReadFile(log)
+	calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(calls) != 3 {
+		t.Fatalf("want asprof, cat and rm calls, got:\n%s", data)
+	}
+	prefix := "exec -i -n prod pod/my-app-7c9f -c app -- "
+	if !strings.HasPrefix(calls[0], prefix+"asprof -d 5 -e wall -o jfr -f /tmp/ap-query-") || !strings.HasSuffix(calls[0], ".jfr 1") {
+		t.Errorf("asprof call: %s", calls[0])
+	}
+	if !strings.HasPrefix(calls[1], prefix+"cat /tmp/ap-query-") || !strings.HasPrefix(calls[2], prefix+"rm -f /tmp/ap-query-") {
+		t.Errorf("copy/cleanup calls:\n%s", data)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"record", "--k8s", "pod/x", "--", "java"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "take no command") {
+		t.Errorf("--k8s with command: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	got := shellJoin([]string{"sh", "-c", "mkdir -p /x && tar xzf -", "it's", "/tmp/a.jfr"})
+	want := `sh -c 'mkdir -p /x && tar xzf -' 'it'\''s' /tmp/a.jfr`
+	if got != want {
+		t.Errorf("shellJoin = %s, want %s", got, want)
+	}
+}
+
+func TestAsprofArch(t *testing.T) {
+	for uname, want := range map[string]string{"Linux x86_64\n": "x64", "Linux aarch64": "arm64"} {
+		if got, err := asprofArch(uname); err != nil || got != want {
+			t.Errorf("asprofArch(%q) = %q, %v; want %q", uname, got, err, want)
+		}
+	}
+	for _, uname := range []string{"Darwin arm64", "Linux s390x", ""} {
+		if _, err := asprofArch(uname); err == nil {
+			t.Errorf("asprofArch(%q) should fail", uname)
+		}
+	}
+}
+
+func TestNewestAsprofArchive(t *testing.T) {
+	tests := []struct {
+		paths []string
+		want  string
+	}{
+		{nil, ""},
+		{[]string{"/c/async-profiler-4.9-linux-x64.tar.gz", "/c/async-profiler-4.10-linux-x64.tar.gz"}, "/c/async-profiler-4.10-linux-x64.tar.gz"},
+		{[]string{"/c/async-profiler-4.10-linux-x64.tar.gz", "/c/async-profiler-4.9-linux-x64.tar.gz"}, "/c/async-profiler-4.10-linux-x64.tar.gz"},
+		{[]string{"/c/async-profiler-4.1.1-linux-x64.tar.gz", "/c/async-profiler-4.1-linux-x64.tar.gz"}, "/c/async-profiler-4.1.1-linux-x64.tar.gz"},
+		{[]string{"/c/async-profiler-3.0-linux-x64.tar.gz"}, "/c/async-profiler-3.0-linux-x64.tar.gz"},
+	}
+	for _, tt := range tests {
+		if got := newestAsprofArchive(tt.paths); got != tt.want {
+			t.Errorf("newestAsprofArchive(%v) = %q, want %q", tt.paths, got, tt.want)
+		}
+	}
+}
+
+func TestAsprofReleaseSHA256(t *testing.T) {
+	var r asprofRelease
+	if err := json.Unmarshal([]byte(`{"tag_name":"v4.3","assets":[
+		{"name":"async-profiler-4.3-linux-x64.tar.gz","digest":"sha256:abc123"},
+		{"name":"async-profiler-4.3-linux-arm64.tar.gz","digest":""}]}`), &r); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.sha256("async-profiler-4.3-linux-x64.tar.gz"); got != "abc123" {
+		t.Errorf("x64 digest = %q, want abc123", got)
+	}
+	for _, name := range []string{"async-profiler-4.3-linux-arm64.tar.gz", "missing.tar.gz"} {
+		if got := r.sha256(name); got != "" {
+			t.Errorf("sha256(%q) = %q, want none", name, got)
+		}
+	}
+}
+
+func TestRecordSSHCLI(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "ssh.log")
+	pushed := filepath.Join(dir, "pushed.tar.gz")
+	fixture, _ := filepath.Abs(jfrFixture("cpu.jfr"))
+	// Stand-in ssh: logs its arguments, answers uname, captures the pushed
+	// archive and serves the fixture for cat.
+	fake := "#!/bin/sh\necho \"$@\" >> " + log + "\ncase \"$*\" in\n" +
+		"*'uname -sm') echo 'Linux x86_64';;\n" +
+		"*' sh -c '*) cat > " + pushed + ";;\n" +
+		"*' cat /tmp/'*) cat " + fixture + ";;\nesac\n"
+	if err := os.WriteFile(filepath.Join(dir, "ssh"), []byte(fake), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	home := filepath.Join(dir, "home")
+	cache := filepath.Join(home, ".ap-query", "cache")
+	os.MkdirAll(cache, 0o755)
+	os.WriteFile(filepath.Join(cache, "async-profiler-4.3-linux-x64.tar.gz"), []byte("release"), 0o644)
+	t.Setenv("HOME", home)
+
+	out := filepath.Join(dir, "host.jfr")
+	code, stdout, stderr := runCLIForTest(t, []string{"record", "--ssh", "app-01", "--jump", "bastion", "--push", "--pid", "4242", "-d", "2", "-o", out}, nil)
+	if code != 0 {
+		t.Fatalf("record --ssh: exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "Pushing async-profiler (linux-x64) to app-01 via bastion") || !strings.Contains(stdout, "=== THREADS") {
+		t.Errorf("record --ssh did not push and analyze:\nstdout:\n%s\nstderr:\n%s", stdout, stderr)
+	}
+	if data, _ := os.ReadFile(pushed); string(data) != "release" {
+		t.Errorf("pushed archive = %q", data)
+	}
+	data, _ := os.ReadFile(log)
+	calls := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(calls) != 5 {
+		t.Fatalf("want uname, push, asprof, cat and rm calls, got:\n%s", data)
+	}
+	if !strings.HasPrefix(calls[2], "-J bastion app-01 /tmp/ap-query-async-profiler/bin/asprof -d 2 -e cpu -o jfr -f /tmp/ap-query-") ||
+		!strings.HasSuffix(calls[2], ".jfr 4242") {
+		t.Errorf("asprof call: %s", calls[2])
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"record", "--ssh", "-oProxyCommand=x"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "take a host") {
+		t.Errorf("option as host: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestArchiveCLI(t *testing.T) {
+	dir := t.TempDir()
+	collapsed := filepath.Join(dir, "plain.txt")
+	os.WriteFile(collapsed, []byte("A;B 6\nA;C 4\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"archive", "add", jfrFixture("multi.jfr"), "--label", "release-1.41 cpu", "--dir", dir}, nil)
+	if code != 0 || !strings.Contains(stdout, `as #1 "release-1.41 cpu" (cpu `) {
+		t.Fatalf("archive add: exit %d\n%s%s", code, stdout, stderr)
+	}
+	runCLIForTest(t, []string{"archive", "add", jfrFixture("cpu.jfr"), "--label", "release-1.42 cpu", "--dir", dir, "--note", "git=3f9c2e1"}, nil)
+	runCLIForTest(t, []string{"archive", "add", collapsed, "--label", "plain", "--dir", dir}, nil)
+	runCLIForTest(t, []string{"archive", "add", collapsed, "--label", "plain", "--dir", dir}, nil)
+
+	_, stdout, _ = runCLIForTest(t, []string{"archive", "list", "--dir", dir}, nil)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 6 || !strings.HasPrefix(lines[0], "ID") || !strings.HasPrefix(lines[2], "#2") ||
+		!strings.Contains(lines[1], "release-1.41 cpu") || !strings.Contains(lines[1], "wall ") ||
+		strings.TrimSpace(lines[3]) != "git=3f9c2e1" || !strings.HasSuffix(lines[4], "10 samples") {
+		t.Errorf("archive list:\n%s", stdout)
+	}
+
+	code, stdout, stderr = runCLIForTest(t, []string{"archive", "diff", "release-1.41 cpu", "#2", "--dir", dir, "--min-delta", "1"}, nil)
+	if code != 0 || !strings.HasPrefix(stdout, "Before: #1 release-1.41 cpu (added ") ||
+		!strings.Contains(stdout, "After:  #2 release-1.42 cpu") || !strings.Contains(stdout, "REGRESSION") {
+		t.Errorf("archive diff: exit %d\n%s%s", code, stdout, stderr)
+	}
+
+	// The stored summary keeps every event and is readable by any command.
+	_, path, _ := runCLIForTest(t, []string{"archive", "path", "1", "--dir", dir}, nil)
+	code, stdout, stderr = runCLIForTest(t, []string{"hot", strings.TrimSpace(path), "--event", "wall"}, nil)
+	if code != 0 || !strings.Contains(stderr, "Event: wall") || stdout == "" {
+		t.Errorf("hot on archived summary: exit %d\n%s%s", code, stdout, stderr)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"archive", "diff", "plain", "1", "--dir", dir}, nil)
+	if code != exitUsage || !strings.Contains(stderr, `label "plain" matches #3, #4; use an id`) {
+		t.Errorf("ambiguous label: exit %d, stderr:\n%s", code, stderr)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"archive", "path", "nope", "--dir", dir}, nil)
+	if code != exitUsage || !strings.Contains(stderr, `no archived profile with label or id "nope"`) {
+		t.Errorf("unknown label: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestArchiveNotesCLI(t *testing.T) {
+	dir := t.TempDir()
+	code, _, stderr := runCLIForTest(t, []string{"archive", "add", jfrFixture("cpu.jfr"), "--label", "base", "--note", "git=aaa111", "--dir", dir}, nil)
+	if code != 0 {
+		t.Fatalf("archive add --note: exit %d, stderr:\n%s", code, stderr)
+	}
+	runCLIForTest(t, []string{"archive", "add", jfrFixture("multi.jfr"), "--label", "candidate", "--dir", dir}, nil)
+	code, stdout, stderr := runCLIForTest(t, []string{"archive", "note", "candidate", "git=bbb222", " load test LT-42 ", "--dir", dir}, nil)
+	if code != 0 || stdout != "#2 candidate: git=bbb222; load test LT-42\n" {
+		t.Errorf("archive note: exit %d\n%s%s", code, stdout, stderr)
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"archive", "diff", "base", "candidate", "--dir", dir}, nil)
+	want := "        git=aaa111\nAfter:  #2 candidate"
+	if !strings.Contains(stdout, want) || !strings.Contains(stdout, "        git=bbb222\n        load test LT-42\n\n") {
+		t.Errorf("archive diff header lacks notes:\n%s", stdout)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"archive", "note", "base", "two\nlines", "--dir", dir}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "single non-empty line") {
+		t.Errorf("multi-line note: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestMetricsCLI(t *testing.T) {
+	input := "[main];a.A.run;a.B.work 6\n[pool-1-thread-1];a.A.run;a.C.io 3\n[pool-1-thread-2];a.A.run 1\n"
+	code, stdout, stderr := runCLIForTest(t, []string{"metrics", "-", "--top", "2", "--label", `service=check"out`}, strings.NewReader(input))
+	if code != 0 {
+		t.Fatalf("metrics: exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"# TYPE ap_query_samples gauge\nap_query_samples{service=\"check\\\"out\"} 10\n",
+		`ap_query_method_self_percent{service="check\"out",method="a.B.work",id="` + methodID("a.B.work") + `"} 60.00` + "\n",
+		`ap_query_method_total_percent{service="check\"out",method="a.C.io",id="` + methodID("a.C.io") + `"} 30.00` + "\n",
+		`ap_query_thread_percent{service="check\"out",thread="main"} 60.00` + "\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q:\n%s", want, stdout)
+		}
+	}
+	if strings.Count(stdout, "ap_query_method_self_percent{") != 2 {
+		t.Errorf("--top 2 not applied:\n%s", stdout)
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"metrics", "-", "--group"}, strings.NewReader(input))
+	if !strings.Contains(stdout, `ap_query_thread_percent{thread="pool-thread"} 40.00`) {
+		t.Errorf("--group did not merge the pool:\n%s", stdout)
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"metrics", jfrFixture("multi.jfr"), "--event", "wall", "--top", "1"}, nil)
+	if !strings.Contains(stdout, `ap_query_samples{event="wall"} `) {
+		t.Errorf("event label missing:\n%s", stdout)
+	}
+
+	for _, bad := range []string{"1x=y", "noequals", "thread=x"} {
+		if code, _, _ := runCLIForTest(t, []string{"metrics", "-", "--label", bad}, strings.NewReader(input)); code != exitUsage {
+			t.Errorf("--label %s: exit %d, want %d", bad, code, exitUsage)
+		}
+	}
+}
+
+func TestInfoExpandLimitsCLI(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&b, "[main];Root.run;Mid%d.call;Leaf.work %d\n", i, 10+i)
+	}
+	input := b.String()
+
+	_, full, _ := runCLIForTest(t, []string{"info", "-", "--expand", "1", "--expand-min-pct", "0", "--expand-max-lines", "0"}, strings.NewReader(input))
+	full = full[strings.Index(full, "=== DRILL-DOWN"):]
+	if strings.Count(full, "Root.run") != 40 || strings.Contains(full, "drill-down stopped") {
+		t.Errorf("unlimited drill-down should list every caller:\n%s", full)
+	}
+
+	_, stdout, _ := runCLIForTest(t, []string{"info", "-", "--expand", "1", "--expand-min-pct", "0", "--expand-max-lines", "10"}, strings.NewReader(input))
+	notice := "... drill-down stopped after 10 lines (--expand-max-lines; 0 shows all)\n"
+	drill := stdout[strings.Index(stdout, "=== DRILL-DOWN"):]
+	drill = drill[:strings.Index(drill, notice)+len(notice)]
+	// Ten lines counting the blank line before the header, then the notice.
+	if got := strings.Count(drill, "\n"); got != 10 {
+		t.Errorf("capped drill-down has %d lines:\n%s", got, drill)
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"info", "-", "--expand", "1", "--expand-depth", "1"}, strings.NewReader(input))
+	drill = stdout[strings.Index(stdout, "=== DRILL-DOWN"):]
+	if strings.Contains(drill, "Root.run") {
+		t.Errorf("--expand-depth 1 should stop at the method itself:\n%s", drill)
+	}
+
+	code, _, stderr := runCLIForTest(t, []string{"info", "-", "--expand-depth", "0"}, strings.NewReader(input))
+	if code != exitUsage || !strings.Contains(stderr, "--expand-depth must be at least 1") {
+		t.Errorf("--expand-depth 0: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestInfoVerdict(t *testing.T) {
+	verdict := func(collapsed string, opts infoOpts) string {
+		t.Helper()
+		sf, err := parseCollapsed(strings.NewReader(collapsed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(infoVerdict(sf, opts, computeHot(sf, false)), "\n")
+	}
+
+	got := verdict("[main];Main.run;Service.handle;Codec.encode 60\n[main];Main.run;Service.handle;Db.query 30\n[GC Thread#0];GC.work 10\n", infoOpts{})
+	for _, want := range []string{
+		"Only 100 samples: record longer",
+		"Top method Codec.encode is a leaf (60.0% self): optimize it directly; lines -m Codec.encode",
+		"Service.handle covers 90.0% in total but 0.0% self: the cost is in its callees, drill in with tree -m Service.handle.",
+		"GC threads take 10.0% of samples",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "Main.run covers") {
+		t.Errorf("thread root reported as entry point:\n%s", got)
+	}
+
+	var flat strings.Builder
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&flat, "Main.run;Task%d.run 30\n", i)
+	}
+	got = verdict(flat.String(), infoOpts{})
+	if !strings.Contains(got, "Flat profile (top self 2.0%)") || strings.Contains(got, "Only ") {
+		t.Errorf("flat profile verdict:\n%s", got)
+	}
+
+	got = verdict("Main.run;Object.wait 70\nMain.run;Work.step 30\n", infoOpts{eventType: "wall", hasMetadata: true})
+	if got != "Only 100 samples: record longer or sample more often before trusting shares below ~5%.\n"+
+		"70% of wall samples are idle (park, sleep, wait): re-run with --no-idle to see active work." {
+		t.Errorf("wall verdict:\n%s", got)
+	}
+
+	got = verdict("Main.run;Work.step 1000\n", infoOpts{eventType: "cpu", hasMetadata: true, eventCounts: map[string]int{"cpu": 1000, "wall": 9000, "lock": 5}})
+	if !strings.Contains(got, "Threads mostly wait (9000 wall vs 1000 cpu samples)") || !strings.Contains(got, "hot --event lock") {
+		t.Errorf("cpu with wall and lock verdict:\n%s", got)
+	}
+}
+
+func TestWhereCLI(t *testing.T) {
+	dir := t.TempDir()
+	v1 := filepath.Join(dir, "v1.txt")
+	v2 := filepath.Join(dir, "v2.txt")
+	v3 := filepath.Join(dir, "v3.txt")
+	os.WriteFile(v1, []byte("Main.run;Work.step 10\n"), 0o644)
+	os.WriteFile(v2, []byte("Main.run;Cache.resize;Map.copy 3\nMain.run;Cache.resize 2\nMain.run;Work.step 15\n"), 0o644)
+	os.WriteFile(v3, []byte("Main.run;Cache.resize 10\nMain.run;Work.step 10\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"where", "-m", "Cache.resize", v1, v2, v3}, nil)
+	if code != 0 {
+		t.Fatalf("where: exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"Method: Cache.resize (matches Cache.resize)\n",
+		v1 + "       -       -         0  absent\n",
+		v2 + "   10.0%   25.0%         5\n",
+		v3 + "   50.0%   50.0%        10\n",
+		"First seen in: " + v2 + " (#2 of 3)\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q:\n%s", want, stdout)
+		}
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"where", "-m", "Nope", v1, v2}, nil)
+	if stdout != "no stacks matching 'Nope' in any of 2 profiles\n" {
+		t.Errorf("no match: %q", stdout)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"where", v1}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "requires -m") {
+		t.Errorf("missing -m: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"com/app/Main.run", "java/util/HashMap.put", "java/util/HashMap.putVal"}, lines: []uint32{5, 0, 630}, count: 4,
+			details: []frameDetail{{1, "Interpreted"}, {2, "JIT compiled"}, {3, frameKindInlined}}},
+		{frames: []string{"com/app/Main.run", "Worker$$Lambda$5.0x0000700001.run", "libc.so.6.write"}, lines: []uint32{7, 0, 0}, count: 2,
+			details: []frameDetail{{1, "Interpreted"}, {0, "JIT compiled"}, {0, "Native"}}},
+	})
+	frames := func(key string) string {
+		var out []string
+		for _, st := range sf.groupBy(key).stacks {
+			out = append(out, strings.Join(st.frames, ";"))
+		}
+		return strings.Join(out, " | ")
+	}
+	for _, tc := range []struct{ key, want string }{
+		{"method", "com/app/Main.run;java/util/HashMap.put;java/util/HashMap.putVal | com/app/Main.run;Worker$$Lambda$5.0x0000700001.run;libc.so.6.write"},
+		{"line", "com/app/Main.run:5;java/util/HashMap.put;java/util/HashMap.putVal:630 | com/app/Main.run:7;Worker$$Lambda$5.0x0000700001.run;libc.so.6.write"},
+		{"class", "com/app/Main.*;java/util/HashMap.* | com/app/Main.*;Worker$$Lambda$5.*;libc.so.6.write"},
+		{"package", "com/app/*;java/util/* | com/app/*;(default)/*;libc.so.6.write"},
+		{"frame-type", "com/app/Main.run [Interpreted];java/util/HashMap.put [JIT compiled];java/util/HashMap.putVal [Inlined] | com/app/Main.run [Interpreted];Worker$$Lambda$5.0x0000700001.run [JIT compiled];libc.so.6.write [Native]"},
+	} {
+		if got := frames(tc.key); got != tc.want {
+			t.Errorf("--group-by %s:\n got %s\nwant %s", tc.key, got, tc.want)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "p.txt")
+	os.WriteFile(path, []byte("Main.run;Map.put;Map.hash 3\nMain.run;Map.get 1\nMain.run;List.add 2\n"), 0o644)
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", path, "--group-by", "class"}, nil)
+	if code != 0 || !strings.Contains(stdout, "Map.*                                                66.7%   66.7%         4") {
+		t.Errorf("hot --group-by class: exit %d\n%s%s", code, stdout, stderr)
+	}
+	// Packages sharing a last component stay apart and show in full.
+	pkgPath := filepath.Join(t.TempDir(), "pkg.txt")
+	os.WriteFile(pkgPath, []byte("java/lang/Thread.run;java/util/HashMap.put 3\njava/lang/Thread.run;com/acme/util/Foo.bar 2\n"), 0o644)
+	code, stdout, stderr = runCLIForTest(t, []string{"hot", pkgPath, "--group-by", "package"}, nil)
+	if code != 0 || !strings.Contains(stdout, "java.util.*                                          60.0%   60.0%         3") ||
+		!strings.Contains(stdout, "com.acme.util.*                                      40.0%   40.0%         2") ||
+		!strings.Contains(stdout, "java.lang.*") || strings.Contains(stdout, "\nutil.*") {
+		t.Errorf("hot --group-by package: exit %d\n%s%s", code, stdout, stderr)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"tree", pkgPath, "--group-by", "package"}, nil)
+	if !strings.Contains(stdout, "[100.0%] java.lang.*\n  [60.0%] java.util.*  ← self=60.0%\n  [40.0%] com.acme.util.*  ← self=40.0%\n") {
+		t.Errorf("tree --group-by package:\n%s", stdout)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"tree", path, "--group-by", "frame-type"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "--group-by frame-type requires a JFR file") {
+		t.Errorf("frame-type on text: exit %d, stderr:\n%s", code, stderr)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"methods", path, "--group-by", "module"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, `invalid --group-by "module"`) {
+		t.Errorf("bad key: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestTreeJSONCLI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p.txt")
+	os.WriteFile(path, []byte("Main.run;Map.put;Map.hash 3\nMain.run;Map.get 1\nMain.run 2\nGC.work 4\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"tree", path, "--format", "json", "--min-pct", "15"}, nil)
+	if code != 0 {
+		t.Fatalf("tree --format json: exit %d, stderr:\n%s", code, stderr)
+	}
+	var root flameNode
+	if err := json.Unmarshal([]byte(stdout), &root); err != nil {
+		t.Fatalf("invalid JSON %q: %v", stdout, err)
+	}
+	var render func(n *flameNode) string
+	render = func(n *flameNode) string {
+		s := fmt.Sprintf("%s=%d", n.Name, n.Value)
+		if len(n.Children) > 0 {
+			var parts []string
+			for _, c := range n.Children {
+				parts = append(parts, render(c))
+			}
+			s += "(" + strings.Join(parts, " ") + ")"
+		}
+		return s
+	}
+	// Map.get (10%) is below --min-pct but still counts in Main.run's value.
+	if got, want := render(&root), "all=10(GC.work=4 Main.run=6(Map.put=3(Map.hash=3)))"; got != want {
+		t.Errorf("tree JSON:\n got %s\nwant %s", got, want)
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"tree", path, "--format", "json", "-m", "Map.put"}, nil)
+	if stdout != `{"name":"all","value":3,"children":[{"name":"Map.put","value":3,"children":[{"name":"Map.hash","value":3,"children":[]}]}]}`+"\n" {
+		t.Errorf("tree -m JSON: %q", stdout)
+	}
+	code, stdout, stderr = runCLIForTest(t, []string{"tree", path, "--format", "json", "-m", "Nope"}, nil)
+	if code != 0 || stdout != "" || !strings.Contains(stderr, "no stacks matching 'Nope'") {
+		t.Errorf("no match: exit %d, stdout %q, stderr:\n%s", code, stdout, stderr)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"tree", path, "--format", "yaml"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, `invalid --format "yaml"`) {
+		t.Errorf("bad format: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestTreeCallersRelativeCLI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p.txt")
+	os.WriteFile(path, []byte("Main.run;Codec.encode;Buf.grow 3\nMain.run;Codec.encode 1\nJob.run;Codec.encode 1\nMain.run;Other.work 95\n"), 0o644)
+
+	_, stdout, _ := runCLIForTest(t, []string{"tree", path, "-m", "Codec.encode", "--relative", "--min-pct", "50"}, nil)
+	want := "# percentages of the 5 samples matching 'Codec.encode' (--relative)\n" +
+		"[100.0%] Codec.encode\n" +
+		"  [60.0%] Buf.grow  ← self=60.0%\n"
+	if stdout != want {
+		t.Errorf("tree --relative:\n%s\nwant:\n%s", stdout, want)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"callers", path, "-m", "Codec.encode", "--relative"}, nil)
+	for _, line := range []string{"[100.0%] Codec.encode\n", "  [80.0%] Main.run\n", "  [20.0%] Job.run\n"} {
+		if !strings.Contains(stdout, line) {
+			t.Errorf("callers --relative missing %q:\n%s", line, stdout)
+		}
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"tree", path, "-m", "Nope", "--relative"}, nil)
+	if !strings.HasPrefix(stdout, "no stacks matching 'Nope'") {
+		t.Errorf("no match with --relative:\n%s", stdout)
+	}
+}
+
+func TestPrecisionCLI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p.txt")
+	os.WriteFile(path, []byte("Main.run;A.a 1\nMain.run;B.b 2\n"), 0o644)
+	// The decimal separator must not follow a regional locale.
+	t.Setenv("LC_ALL", "de_DE.UTF-8")
+	t.Setenv("LANG", "de_DE.UTF-8")
+
+	_, stdout, _ := runCLIForTest(t, []string{"hot", path, "--precision", "3"}, nil)
+	if !strings.Contains(stdout, "B.b                                                66.667% 66.667%         2") {
+		t.Errorf("hot --precision 3:\n%s", stdout)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"tree", path, "--precision", "0"}, nil)
+	if !strings.Contains(stdout, "[100%] Main.run\n  [67%] B.b  ← self=67%\n  [33%] A.a  ← self=33%\n") {
+		t.Errorf("tree --precision 0:\n%s", stdout)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"hot", path}, nil)
+	if !strings.Contains(stdout, " 66.7%   66.7%") || strings.Contains(stdout, "66,7") {
+		t.Errorf("default precision:\n%s", stdout)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"info", jfrFixture("cpu.jfr"), "--precision", "3"}, nil)
+	if !strings.Contains(stdout, "is a leaf (25.101% self)") || !strings.Contains(stdout, "covers 49.596% in total but 0.101% self") {
+		t.Errorf("info --precision 3 verdict:\n%s", stdout)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"inspect", jfrFixture("multi.jfr"), "-m", "Workload.allocateObjects", "--precision", "3"}, nil)
+	if !strings.Contains(stdout, "244.5 MB allocated (100.000% of 244.5 MB)") || !strings.Contains(stdout, "0.0s waited (0.000% of 6.2s)") {
+		t.Errorf("inspect --precision 3 BELOW column:\n%s", stdout)
+	}
+	code, _, stderr := runCLIForTest(t, []string{"hot", path, "--precision", "-1"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "--precision must be between 0 and 6") {
+		t.Errorf("bad precision: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestDamagedJFRSalvage(t *testing.T) {
+	data, err := os.ReadFile(jfrFixture("multichunk.jfr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	truncated := filepath.Join(dir, "truncated.jfr")
+	os.WriteFile(truncated, data[:len(data)-2000], 0o644)
+	corrupt := append([]byte(nil), data...)
+	copy(corrupt[5000:5200], bytes.Repeat([]byte{0xff}, 200))
+	corruptPath := filepath.Join(dir, "corrupt.jfr")
+	os.WriteFile(corruptPath, corrupt, 0o644)
+
+	for _, tc := range []struct {
+		path, warning string
+	}{
+		{truncated, "is damaged (invalid JFR chunk size"},
+		{corruptPath, "is damaged (JFR chunk at offset 0: metadata:"},
+	} {
+		code, stdout, stderr := runCLIForTest(t, []string{"hot", tc.path}, nil)
+		if code != 0 {
+			t.Errorf("%s: exit %d, stderr:\n%s", tc.path, code, stderr)
+			continue
+		}
+		if !strings.Contains(stderr, tc.warning) || !strings.Contains(stderr, "skipped 1 of 4 chunks, analyzing the remaining") {
+			t.Errorf("%s: missing salvage warning:\n%s", tc.path, stderr)
+		}
+		if !strings.Contains(stdout, "MultiChunkWorkload.main") {
+			t.Errorf("%s: no results:\n%s", tc.path, stdout)
+		}
+		if n := strings.Count(stderr, "truncated chunk header scan"); n > 1 {
+			t.Errorf("%s: truncated scan warning printed %d times:\n%s", tc.path, n, stderr)
+		}
+	}
+	// The truncated file does warn, once.
+	_, _, stderr := runCLIForTest(t, []string{"hot", truncated}, nil)
+	if n := strings.Count(stderr, "warning: "+truncated+": truncated chunk header scan at offset"); n != 1 {
+		t.Errorf("truncated: want the scan warning once, got %d:\n%s", n, stderr)
+	}
+	// Each truncated input of a multi-file command warns on its own.
+	truncated2 := filepath.Join(dir, "truncated2.jfr")
+	os.WriteFile(truncated2, data[:len(data)-3000], 0o644)
+	_, _, stderr = runCLIForTest(t, []string{"diff", truncated, truncated2}, nil)
+	for _, path := range []string{truncated, truncated2} {
+		if n := strings.Count(stderr, "warning: "+path+": truncated chunk header scan at offset"); n != 1 {
+			t.Errorf("diff: want the scan warning once for %s, got %d:\n%s", path, n, stderr)
+		}
+	}
+
+	salvaged, sum := salvageJFRChunks(data)
+	if sum.total != 4 || sum.kept != 4 || !bytes.Equal(salvaged, data) {
+		t.Errorf("intact file: kept %d of %d chunks", sum.kept, sum.total)
+	}
+
+	// Nothing left to analyze: the parse error stands.
+	single, _ := os.ReadFile(jfrFixture("cpu.jfr"))
+	broken := filepath.Join(dir, "broken.jfr")
+	os.WriteFile(broken, single[:len(single)/2], 0o644)
+	code, _, stderr := runCLIForTest(t, []string{"hot", broken}, nil)
+	if code != exitParse || strings.Contains(stderr, "is damaged") {
+		t.Errorf("unrecoverable: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestMinDurationCLI(t *testing.T) {
+	lock := jfrFixture("lock.jfr")
+	samples := func(args ...string) int {
+		t.Helper()
+		code, stdout, stderr := runCLIForTest(t, append([]string{"hot", lock, "--event", "lock", "--explain"}, args...), nil)
+		if code != 0 {
+			t.Fatalf("hot %v: exit %d, stderr:\n%s", args, code, stderr)
+		}
+		var n int
+		for _, line := range strings.Split(stdout, "\n") {
+			if _, err := fmt.Sscanf(line, "# result: %d samples", &n); err == nil {
+				return n
+			}
+		}
+		t.Fatalf("no result line:\n%s", stdout)
+		return 0
+	}
+	all, long, longer := samples(), samples("--min-duration", "1ms"), samples("--min-duration", "10ms")
+	if !(all > long && long > longer && longer > 0) {
+		t.Errorf("samples all=%d >=1ms=%d >=10ms=%d, want strictly decreasing and non-zero", all, long, longer)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"hot", jfrFixture("cpu.jfr"), "--min-duration", "1ms"}, "--min-duration applies to lock events"},
+		{[]string{"hot", lock, "--min-duration", "soon"}, `invalid --min-duration "soon"`},
+		{[]string{"hot", lock, "--min-duration", "0s"}, `invalid --min-duration "0s"`},
+	} {
+		code, _, stderr := runCLIForTest(t, tc.args, nil)
+		if code != exitUsage || !strings.Contains(stderr, tc.want) {
+			t.Errorf("%v: exit %d, stderr:\n%s", tc.args, code, stderr)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "p.txt")
+	os.WriteFile(path, []byte("A.a 1\n"), 0o644)
+	code, _, stderr := runCLIForTest(t, []string{"tree", path, "--min-duration", "1ms"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "--min-duration requires a JFR file") {
+		t.Errorf("collapsed input: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestHistBuckets(t *testing.T) {
+	us, ms := int64(time.Microsecond), int64(time.Millisecond)
+	d := []int64{500, 3 * us, 4 * us, 40 * us, 40 * us, 40 * us, 7 * ms}
+	var got []string
+	for _, b := range histBuckets(d) {
+		got = append(got, fmt.Sprintf("%s=%d", b.label, b.count))
+	}
+	want := "<2us=1 2us-5us=2 5us-10us=0 10us-20us=0 20us-50us=3 50us-100us=0 100us-200us=0 200us-500us=0 500us-1ms=0 1ms-2ms=0 2ms-5ms=0 5ms-10ms=1"
+	if strings.Join(got, " ") != want {
+		t.Errorf("buckets:\n got %s\nwant %s", strings.Join(got, " "), want)
+	}
+	if p50, p99 := percentile(d, 50), percentile(d, 99); p50 != 40*us || p99 != 7*ms {
+		t.Errorf("p50=%d p99=%d", p50, p99)
+	}
+}
+
+func TestHistCLI(t *testing.T) {
+	lock := jfrFixture("lock.jfr")
+	code, stdout, stderr := runCLIForTest(t, []string{"hist", lock}, nil)
+	if code != 0 {
+		t.Fatalf("hist: exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"=== lock (jdk.JavaMonitorEnter): 19435 events, ",
+		"\np50 ",
+		"DURATION            COUNT       %\n",
+		"10us-20us ",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q:\n%s", want, stdout)
+		}
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"hist", lock, "-m", "lockStep", "--from", "1s", "--to", "2s"}, nil)
+	var n int
+	if _, err := fmt.Sscanf(stdout, "=== lock (jdk.JavaMonitorEnter): %d events", &n); err != nil || n == 0 || n >= 19435 {
+		t.Errorf("filtered hist: %d events:\n%s", n, stdout)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"hist", lock, "-t", "no-such-thread"}, nil)
+	if stdout != "no lock/park/io events match -m/-t/--from/--to\n" {
+		t.Errorf("thread filter: %q", stdout)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"hist", jfrFixture("cpu.jfr"), "--event", "io"}, nil)
+	if !strings.HasPrefix(stdout, "no io events (record with") {
+		t.Errorf("no io events: %q", stdout)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"hist", lock, "--event", "cpu"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, `invalid --event "cpu"`) {
+		t.Errorf("bad kind: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+// plainJDKCopy writes a copy of an async-profiler fixture whose profiler
+// setting names are renamed, so it reads like a recording made by the JDK's
+// own JFR.
+func plainJDKCopy(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(jfrFixture(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for setting := range profilerSettingNames {
+		old := append([]byte{3, byte(len(setting))}, setting...)
+		data = bytes.ReplaceAll(data, old, append([]byte{3, byte(len(setting))}, strings.ToUpper(setting)...))
+	}
+	path := filepath.Join(t.TempDir(), "plain-"+name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestApproxWallPlainJDK(t *testing.T) {
+	plain := plainJDKCopy(t, "cpu.jfr")
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", plain, "--event", "wall", "--explain"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "has no wall-clock samples; wall is approximated from jdk.ExecutionSample") {
+		t.Errorf("missing approximation warning:\n%s", stderr)
+	}
+	if !strings.Contains(stdout, "# wall: approximate") || !strings.Contains(stdout, "Workload.computeStep") {
+		t.Errorf("unexpected output:\n%s", stdout)
+	}
+
+	// Time windows collect the same approximation per event.
+	code, stdout, stderr = runCLIForTest(t, []string{"timeline", plain, "--event", "wall"}, nil)
+	if code != 0 || !strings.Contains(stderr, "wall is approximated") || stdout == "" {
+		t.Errorf("timeline: exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+
+	// Without --event wall the recording is analyzed as it is.
+	code, _, stderr = runCLIForTest(t, []string{"hot", plain}, nil)
+	if code != 0 || strings.Contains(stderr, "approximated") {
+		t.Errorf("default event: exit %d, stderr:\n%s", code, stderr)
+	}
+
+	// async-profiler recordings are never approximated.
+	for _, name := range []string{"cpu.jfr", "wall.jfr"} {
+		_, _, stderr := runCLIForTest(t, []string{"hot", jfrFixture(name), "--event", "wall"}, nil)
+		if strings.Contains(stderr, "approximated") {
+			t.Errorf("%s: unexpected approximation:\n%s", name, stderr)
+		}
+	}
+}
+
+func TestCustomEventCLI(t *testing.T) {
+	lock := jfrFixture("lock.jfr")
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", lock, "--event", "jdk.JavaMonitorEnter", "--top", "1"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "Workload.lockStep") || !strings.Contains(stdout, "19433") {
+		t.Errorf("custom event not aggregated:\n%s", stdout)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"hot", lock, "--event", "com.acme.Missing"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, `event "com.acme.Missing" not found (available: lock)`) {
+		t.Errorf("missing type: exit %d, stderr:\n%s", code, stderr)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"hot", lock, "--aggregator", "cat"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "--aggregator applies to custom JFR event types") {
+		t.Errorf("aggregator without custom event: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestCustomEventAggregator(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("aggregator scripts need a POSIX shell")
+	}
+	dir := t.TempDir()
+	seen := filepath.Join(dir, "seen.jsonl")
+	script := filepath.Join(dir, "agg.sh")
+	// Weighs every event 2 under one key and keeps what it was sent.
+	os.WriteFile(script, []byte("#!/bin/sh\nwhile read -r l; do echo \"$l\" >> "+seen+"; echo '2 contended'; done\n"), 0o755)
+	short := filepath.Join(dir, "short.sh")
+	os.WriteFile(short, []byte("#!/bin/sh\ncat > /dev/null; echo 1\n"), 0o755)
+
+	lock := jfrFixture("lock.jfr")
+	code, stdout, stderr := runCLIForTest(t, []string{"tree", lock, "--event", "jdk.JavaMonitorEnter", "--aggregator", script, "--depth", "2"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "[100.0%] [contended]\n") {
+		t.Errorf("aggregator key not the root frame:\n%s", stdout)
+	}
+	data, err := os.ReadFile(seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _, _ := strings.Cut(string(data), "\n")
+	var ev map[string]any
+	if err := json.Unmarshal([]byte(first), &ev); err != nil {
+		t.Fatalf("aggregator input is not JSON lines: %v\n%s", err, first)
+	}
+	fields, _ := ev["fields"].(map[string]any)
+	if ev["type"] != "jdk.JavaMonitorEnter" || ev["duration_ns"] == nil || ev["stack"] == nil || fields["address"] == nil {
+		t.Errorf("unexpected aggregator input: %s", first)
+	}
+	if _, ok := fields["stackTrace"]; ok {
+		t.Errorf("common fields repeated in fields: %s", first)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"hot", lock, "--event", "jdk.JavaMonitorEnter", "--aggregator", short}, nil)
+	if code != exitParse || !strings.Contains(stderr, "answered 1 lines for 19435 events") {
+		t.Errorf("short answer: exit %d, stderr:\n%s", code, stderr)
+	}
+
+	// Quoted arguments survive: the script path has a space and so does its
+	// key argument.
+	spaced := filepath.Join(dir, "by key.sh")
+	os.WriteFile(spaced, []byte("#!/bin/sh\nwhile read -r l; do echo \"1 $1\"; done\n"), 0o755)
+	code, stdout, stderr = runCLIForTest(t, []string{"tree", lock, "--event", "jdk.JavaMonitorEnter", "--aggregator", "'" + spaced + "' \"slow lock\"", "--depth", "1"}, nil)
+	if code != 0 || !strings.HasPrefix(stdout, "[100.0%] [slow lock]\n") {
+		t.Errorf("quoted aggregator: exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+
+	failing := filepath.Join(dir, "fail.sh")
+	os.WriteFile(failing, []byte("#!/bin/sh\ncat > /dev/null; exit 3\n"), 0o755)
+	code, _, stderr = runCLIForTest(t, []string{"hot", lock, "--event", "jdk.JavaMonitorEnter", "--aggregator", failing}, nil)
+	if code != exitIO || !strings.Contains(stderr, "exit status 3") {
+		t.Errorf("failing aggregator: exit %d, stderr:\n%s", code, stderr)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"hot", lock, "--event", "jdk.JavaMonitorEnter", "--aggregator", "'" + script}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "unterminated ' quote") {
+		t.Errorf("unterminated quote: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"  ./agg.py  --by endpoint ", []string{"./agg.py", "--by", "endpoint"}},
+		{`'/opt/my tools/agg' "a b" c\ d`, []string{"/opt/my tools/agg", "a b", "c d"}},
+		{`agg '' "x\"y" 'it'\''s'`, []string{"agg", "", `x"y`, "it's"}},
+		{`agg "a\b" 'c\d'`, []string{"agg", `a\b`, `c\d`}},
+	}
+	for _, tt := range tests {
+		got, err := splitCommand(tt.in)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("splitCommand(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := splitCommand(`agg "open`); err == nil {
+		t.Error("unterminated double quote: want error")
+	}
+}
+
+func TestContextCLI(t *testing.T) {
+	cpu := jfrFixture("cpu.jfr")
+	// The fixtures come from a profiler without context support: every
+	// sample is untagged.
+	code, stdout, stderr := runCLIForTest(t, []string{"tree", cpu, "--group-by", "context", "--depth", "2"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.HasPrefix(stdout, "[100.0%] [context=none]\n") {
+		t.Errorf("missing context root frame:\n%s", stdout)
+	}
+	if !strings.Contains(stderr, "warning: no samples carry a context id") {
+		t.Errorf("missing untagged warning:\n%s", stderr)
+	}
+
+	_, all, _ := runCLIForTest(t, []string{"hot", cpu, "--top", "3"}, nil)
+	code, untagged, stderr := runCLIForTest(t, []string{"hot", cpu, "--top", "3", "--context", "none"}, nil)
+	if code != 0 || untagged != all || strings.Contains(stderr, "warning:") {
+		t.Errorf("--context none: exit %d, stdout:\n%s\nstderr:\n%s", code, untagged, stderr)
+	}
+	code, stdout, _ = runCLIForTest(t, []string{"hot", cpu, "--context", "42"}, nil)
+	if code != 0 || strings.Contains(stdout, "Workload") {
+		t.Errorf("--context 42: exit %d, stdout:\n%s", code, stdout)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"hot", cpu, "--context", "abc"}, `invalid --context "abc"`},
+		{[]string{"hot", cpu, "--context", "0"}, `invalid --context "0"`},
+		{[]string{"hot", jfrFixture("cpu.pb.gz"), "--context", "1"}, "--context requires a JFR file"},
+		{[]string{"hot", jfrFixture("cpu.pb.gz"), "--group-by", "context"}, "--group-by context requires a JFR file"},
+		{[]string{"hot", jfrFixture("lock.jfr"), "-e", "jdk.JavaMonitorEnter", "--context", "1"}, "--context is not supported for custom event"},
+	} {
+		code, _, stderr := runCLIForTest(t, tc.args, nil)
+		if code != exitUsage || !strings.Contains(stderr, tc.want) {
+			t.Errorf("%v: exit %d, stderr:\n%s", tc.args, code, stderr)
+		}
+	}
+}
+
+func TestWatchOnceCLI(t *testing.T) {
+	dir := t.TempDir()
+	for i, name := range []string{"cpu.pb.gz", "cpu2.pb.gz", "cpu.pb.gz"} {
+		data, err := os.ReadFile(jfrFixture(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("profile-%d.pb.gz", i))
+		os.WriteFile(path, data, 0o644)
+		mtime := time.Date(2026, 1, 1, 10, i, 0, 0, time.UTC)
+		os.Chtimes(path, mtime, mtime)
+	}
+	os.WriteFile(filepath.Join(dir, "README.txt"), []byte("not a profile\n"), 0o644)
+	logPath := filepath.Join(t.TempDir(), "regressions.log")
+
+	code, stdout, stderr := runCLIForTest(t, []string{"watch", dir, "--once", "--log", logPath}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	first := strings.Index(stdout, "=== profile-0.pb.gz -> profile-1.pb.gz (")
+	second := strings.Index(stdout, "=== profile-1.pb.gz -> profile-2.pb.gz (")
+	if first < 0 || second < first || strings.Contains(stdout, "README") {
+		t.Fatalf("expected two consecutive diffs in order:\n%s", stdout)
+	}
+	if !strings.Contains(stdout[first:second], "REGRESSION\n  main.pprofBusyCompute") {
+		t.Errorf("first diff misses the regression:\n%s", stdout)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "profile-0.pb.gz -> profile-1.pb.gz: 1 regressions, 2 new; worst main.pprofBusyCompute +5.5%") ||
+		!strings.Contains(lines[1], "profile-1.pb.gz -> profile-2.pb.gz: 1 regressions, 0 new; worst main.pprofBusySort") {
+		t.Errorf("unexpected regression log:\n%s", data)
+	}
+
+	// A broken recording is skipped; the next one is diffed against the
+	// last good one.
+	broken := filepath.Join(dir, "profile-1.pb.gz")
+	os.WriteFile(broken, []byte("not gzip"), 0o644)
+	mtime := time.Date(2026, 1, 1, 10, 1, 0, 0, time.UTC)
+	os.Chtimes(broken, mtime, mtime)
+	code, stdout, stderr = runCLIForTest(t, []string{"watch", dir, "--once"}, nil)
+	if code != 0 || !strings.Contains(stderr, "warning: profile-1.pb.gz: ") ||
+		!strings.Contains(stdout, "=== profile-0.pb.gz -> profile-2.pb.gz (") || strings.Contains(stdout, "profile-1.pb.gz") {
+		t.Errorf("broken recording: exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+
+	code, stdout, _ = runCLIForTest(t, []string{"watch", t.TempDir(), "--once"}, nil)
+	if code != 0 || !strings.Contains(stdout, "fewer than two recordings") {
+		t.Errorf("empty dir: exit %d, stdout:\n%s", code, stdout)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"watch", jfrFixture("cpu.jfr")}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "watch requires a directory") {
+		t.Errorf("file argument: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestFoldNativeCase(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"KERNELBASE.dll!WaitForSingleObjectEx", "kernelbase.dll!WaitForSingleObjectEx"},
+		{`C:\Windows\SYSTEM32\ntdll.dll`, `c:\windows\system32\ntdll.dll`},
+		{"App.EXE+0x1a2b", "app.exe+0x1a2b"},
+		{"ntdll.dll!RtlUserThreadStart", "ntdll.dll!RtlUserThreadStart"},
+		{"com.Example.Main.run", "com.Example.Main.run"},
+		{"libC.so.6", "libC.so.6"},
+		{"[Unknown]", "[Unknown]"},
+	} {
+		if got := foldNativeCase(tc.in); got != tc.want {
+			t.Errorf("foldNativeCase(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestFoldNativeCaseCLI(t *testing.T) {
+	dir := t.TempDir()
+	before := filepath.Join(dir, "before.collapsed")
+	after := filepath.Join(dir, "after.collapsed")
+	os.WriteFile(before, []byte("ntdll.dll!RtlUserThreadStart;App.Main.run;KERNELBASE.dll!WaitForSingleObjectEx 6\n"+
+		"NTDLL.DLL!RtlUserThreadStart;App.Main.run;kernelbase.dll!WaitForSingleObjectEx 4\n"), 0o644)
+	os.WriteFile(after, []byte("ntdll.dll!RtlUserThreadStart;App.Main.run;KernelBase.dll!WaitForSingleObjectEx 10\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"collapse", before, "--fold-native-case"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if want := "ntdll.dll!RtlUserThreadStart;App.Main.run;kernelbase.dll!WaitForSingleObjectEx 10\n"; stdout != want {
+		t.Errorf("collapse --fold-native-case = %q, want %q", stdout, want)
+	}
+	code, stdout, _ = runCLIForTest(t, []string{"collapse", before}, nil)
+	if code != 0 || strings.Count(stdout, "\n") != 2 {
+		t.Errorf("without the flag the stacks must stay apart:\n%s", stdout)
+	}
+
+	code, stdout, stderr = runCLIForTest(t, []string{"diff", before, after, "--fold-native-case"}, nil)
+	if code != 0 || strings.TrimSpace(stdout) != "no significant changes" {
+		t.Errorf("diff --fold-native-case: exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+}
+
+func TestCanonicalSyntheticName(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"com.example.Foo$$Lambda$87.run", "com.example.Foo$$Lambda.run"},
+		{"com.example.Foo$$Lambda$87/0x0000000800c0b6e8.run", "com.example.Foo$$Lambda.run"},
+		{"com.example.Foo$$Lambda.0x00007f0a4c0b6e8.apply", "com.example.Foo$$Lambda.apply"},
+		{"com.example.Foo$$Lambda/0x000000080010a440.get", "com.example.Foo$$Lambda.get"},
+		{"jdk.internal.reflect.GeneratedMethodAccessor12.invoke", "jdk.internal.reflect.GeneratedMethodAccessor.invoke"},
+		{"jdk.proxy2.$Proxy7.handle", "jdk.proxy2.$Proxy.handle"},
+		{"com.example.Foo$Inner.run", "com.example.Foo$Inner.run"},
+		{"com.example.Foo.lambda$main$0", "com.example.Foo.lambda$main$0"},
+		{"0x00007b0f44001440.run", "0x00007b0f44001440.run"},
+	} {
+		if got := canonicalSyntheticName(tc.in); got != tc.want {
+			t.Errorf("canonicalSyntheticName(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestDiffCanonicalSyntheticCLI(t *testing.T) {
+	dir := t.TempDir()
+	before := filepath.Join(dir, "before.collapsed")
+	after := filepath.Join(dir, "after.collapsed")
+	third := filepath.Join(dir, "third.collapsed")
+	os.WriteFile(before, []byte("App.main;App$$Lambda$87.0x0000000800c0b6e8.run 10\n"), 0o644)
+	os.WriteFile(after, []byte("App.main;App$$Lambda$91.0x0000000800c0f120.run 10\n"), 0o644)
+	os.WriteFile(third, []byte("App.main;App$$Lambda$93.0x0000000800c0f800.run 10\n"), 0o644)
+
+	code, stdout, _ := runCLIForTest(t, []string{"diff", before, after}, nil)
+	if code != 0 || !strings.Contains(stdout, "NEW") {
+		t.Fatalf("without the flag the lambdas differ: exit %d\n%s", code, stdout)
+	}
+	code, stdout, stderr := runCLIForTest(t, []string{"diff", before, after, "--canonical-synthetic"}, nil)
+	if code != 0 || strings.TrimSpace(stdout) != "no significant changes" {
+		t.Errorf("--canonical-synthetic: exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	code, stdout, stderr = runCLIForTest(t, []string{"diff", before, after, third, "--canonical-synthetic"}, nil)
+	if code != 0 || strings.Contains(stdout, "$87") || strings.Contains(stdout, "$93") {
+		t.Errorf("multi diff --canonical-synthetic: exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+}
+
+func TestTopLevelCLI(t *testing.T) {
+	cpu := jfrFixture("cpu.jfr")
+	code, stdout, stderr := runCLIForTest(t, []string{"top-level", cpu}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	// Thread.run and the lambda wrapper are plumbing; the first Workload
+	// frame is the entry point.
+	lines := strings.Split(stdout, "\n")
+	if !strings.HasPrefix(lines[1], "Workload.lockWork ") || !strings.Contains(lines[1], "982   49.6%") ||
+		!strings.Contains(stdout, "Workload.cpuWork") || strings.Contains(stdout, "Thread.run") || strings.Contains(stdout, "0x") {
+		t.Errorf("unexpected entry points:\n%s", stdout)
+	}
+
+	dir := t.TempDir()
+	entries := filepath.Join(dir, "entries.txt")
+	os.WriteFile(entries, []byte("# the steps are the jobs\nWorkload.*Step\n"), 0o644)
+	code, stdout, _ = runCLIForTest(t, []string{"top-level", cpu, "--entry", "@" + entries, "--top", "1"}, nil)
+	if code != 0 || !strings.Contains(stdout, "Workload.lockStep") || !strings.Contains(stdout, "(1 of 6 entry points shown)") {
+		t.Errorf("--entry: exit %d\n%s", code, stdout)
+	}
+	code, stdout, _ = runCLIForTest(t, []string{"top-level", cpu, "--plumbing", "Workload.*Work", "--fqn"}, nil)
+	if code != 0 || strings.Contains(stdout, "Work ") || !strings.Contains(stdout, "Workload.allocateObjects") {
+		t.Errorf("--plumbing: exit %d\n%s", code, stdout)
+	}
+
+	collapsed := filepath.Join(dir, "plumbing.collapsed")
+	os.WriteFile(collapsed, []byte("java/lang/Thread.run;java/util/concurrent/ThreadPoolExecutor.runWorker 3\n"), 0o644)
+	_, stdout, _ = runCLIForTest(t, []string{"top-level", collapsed}, nil)
+	if !strings.Contains(stdout, "(no entry point)                                             -                      3  100.0%") {
+		t.Errorf("plumbing-only stack:\n%s", stdout)
+	}
+}
+
+func TestTopLevelEndpointsCLI(t *testing.T) {
+	dir := t.TempDir()
+	collapsed := filepath.Join(dir, "endpoints.collapsed")
+	os.WriteFile(collapsed, []byte(strings.Join([]string{
+		// A controller behind a servlet filter and a CGLIB proxy.
+		"java/lang/Thread.run;org/apache/catalina/core/ApplicationFilterChain.doFilter;com/acme/AuthFilter.doFilter;" +
+			"jakarta/servlet/http/HttpServlet.service;org/springframework/web/method/support/InvocableHandlerMethod.doInvoke;" +
+			"java/lang/reflect/Method.invoke;com/acme/UserController$$SpringCGLIB$$0.get;com/acme/UserController.get;com/acme/UserRepo.find 5",
+		"java/lang/Thread.run;org/apache/catalina/core/ApplicationFilterChain.doFilter;com/acme/AuthFilter.doFilter;com/acme/Jwt.verify 2",
+		"java/lang/Thread.run;io/grpc/stub/ServerCalls$UnaryServerCallHandler$UnaryServerCallListener.onHalfClose;" +
+			"com/acme/OrderServiceGrpc$MethodHandlers.invoke;com/acme/OrderService.place 3",
+		"java/lang/Thread.run;com/acme/jobs/Runner.dispatch;com/acme/jobs/Cleanup.run 1",
+	}, "\n")+"\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"top-level", collapsed}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"UserController.get                                           spring-mvc             5",
+		"OrderService.place                                           grpc                   3",
+		"AuthFilter.doFilter                                          -                      2",
+		"Runner.dispatch                                              -                      1",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q:\n%s", want, stdout)
+		}
+	}
+
+	patterns := filepath.Join(dir, "endpoints.txt")
+	os.WriteFile(patterns, []byte("# in-house job runner\njobs com.acme.jobs.Runner.dispatch\n"), 0o644)
+	code, stdout, _ = runCLIForTest(t, []string{"top-level", collapsed, "--endpoints", patterns}, nil)
+	if code != 0 || !strings.Contains(stdout, "Cleanup.run                                                  jobs                   1") {
+		t.Errorf("--endpoints: exit %d\n%s", code, stdout)
+	}
+
+	os.WriteFile(patterns, []byte("com.acme.jobs.Runner.dispatch\n"), 0o644)
+	code, _, stderr = runCLIForTest(t, []string{"top-level", collapsed, "--endpoints", patterns}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "want \"FRAMEWORK GLOB\"") {
+		t.Errorf("malformed --endpoints: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestContextsCLI(t *testing.T) {
+	dir := t.TempDir()
+	collapsed := filepath.Join(dir, "contexts.collapsed")
+	os.WriteFile(collapsed, []byte(strings.Join([]string{
+		"main;Api.get;Cache.load;Map.resize 6",
+		"main;Api.put;Cache.load;Map.resize 2",
+		"main;Batch.run;Map.resize;Map.resize 1",
+		"main;Api.get;Cache.load 1",
+	}, "\n")+"\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"contexts", collapsed, "-m", "Map.resize"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	want := `# 9 samples in 'Map.resize' (90.0% of total) from 3 caller paths
+  SAMPLES  METHOD%   TOTAL%  CALLER PATH
+        6    66.7%    60.0%  main > Api.get > Cache.load
+        2    22.2%    20.0%  main > Api.put > Cache.load
+        1    11.1%    10.0%  main > Batch.run
+`
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"contexts", collapsed, "-m", "Map.resize", "--depth", "1", "--top", "1"}, nil)
+	if !strings.Contains(stdout, "8    88.9%    80.0%  ... > Cache.load\n") ||
+		!strings.Contains(stdout, "(1 more caller paths, 1 samples, 11.1% of the method)") {
+		t.Errorf("--depth 1 --top 1:\n%s", stdout)
+	}
+
+	if code, _, _ := runCLIForTest(t, []string{"contexts", collapsed}, nil); code != exitUsage {
+		t.Errorf("missing -m: exit %d, want %d", code, exitUsage)
+	}
+}
+
+func TestActivitySparkline(t *testing.T) {
+	if got := activitySparkline([]int{0, 1, 4, 8}, 8); got != " ▂▅█" {
+		t.Errorf("activitySparkline = %q", got)
+	}
+	// A shared scale keeps a quiet row low.
+	if got := activitySparkline([]int{2, 2}, 16); got != "▂▂" {
+		t.Errorf("activitySparkline on a shared scale = %q", got)
+	}
+}
+
+func TestSparklineCLI(t *testing.T) {
+	cpu := jfrFixture("cpu.jfr")
+	code, stdout, stderr := runCLIForTest(t, []string{"threads", cpu, "--sparkline"}, nil)
+	if code != 0 {
+		t.Fatalf("threads: exit %d, stderr:\n%s", code, stderr)
+	}
+	lines := strings.Split(stdout, "\n")
+	if !strings.HasSuffix(lines[0], "PCT  ACTIVITY") || !strings.HasPrefix(lines[1], "alloc-worker") ||
+		!strings.HasSuffix(lines[1], "25.2%  █████") {
+		t.Errorf("threads --sparkline:\n%s", stdout)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"threads", cpu, "--sparkline", "--group"}, nil)
+	if !strings.Contains(stdout, "lock-worker (3 threads)              982   49.6%  █████\nalloc-worker                         499   25.2%  ▄▅▅▅▄") {
+		t.Errorf("threads --sparkline --group:\n%s", stdout)
+	}
+
+	code, stdout, stderr = runCLIForTest(t, []string{"timeline", cpu, "--sparkline", "--buckets", "10", "-t", "lock-worker"}, nil)
+	if code != 0 {
+		t.Fatalf("timeline: exit %d, stderr:\n%s", code, stderr)
+	}
+	_, section, ok := strings.Cut(stdout, "\n\nThread                           Samples  Activity\n")
+	if !ok || strings.Count(section, "\n") != 3 || !strings.HasPrefix(section, "lock-worker-2                        330  ") ||
+		len([]rune(strings.Split(section, "\n")[0])) != 30+1+9+2+10 {
+		t.Errorf("timeline --sparkline:\n%s", stdout)
+	}
+
+	// --thread-buckets prints the counts behind the sparklines.
+	_, stdout, _ = runCLIForTest(t, []string{"timeline", cpu, "--thread-buckets", "--buckets", "10", "-t", "lock-worker"}, nil)
+	if !strings.Contains(stdout, "\n\nThread                           Samples  Per bucket\n"+
+		"lock-worker-2                        330  32 37 36 33 29 24 37 34 30 38\n") {
+		t.Errorf("timeline --thread-buckets:\n%s", stdout)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"timeline", cpu, "--sparkline", "--thread-buckets", "--buckets", "10", "-t", "lock-worker"}, nil)
+	if !strings.Contains(stdout, "\n\nThread                           Samples  Activity    Per bucket\n") {
+		t.Errorf("timeline --sparkline --thread-buckets:\n%s", stdout)
+	}
+
+	for _, args := range [][]string{
+		{"threads", jfrFixture("cpu.pb.gz"), "--sparkline"},
+		{"threads", cpu, "--sparkline", "--states"},
+		{"timeline", cpu, "--sparkline", "--compare", "cpu,wall"},
+		{"timeline", cpu, "--thread-buckets", "--compare", "cpu,wall"},
+	} {
+		if code, _, _ := runCLIForTest(t, args, nil); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestReadCollapsedLine(t *testing.T) {
+	br := bufio.NewReaderSize(strings.NewReader("abcd\nabcde\r\nabcdefgh\nab\r\nlast"), 16)
+	type result struct {
+		line    string
+		tooLong bool
+	}
+	var got []result
+	for {
+		line, tooLong, err := readCollapsedLine(br, 5)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, result{line, tooLong})
+	}
+	want := []result{{"abcd", false}, {"abcde", false}, {"", true}, {"ab", false}, {"last", false}}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestMaxLineBytesCLI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "long.collapsed")
+	os.WriteFile(path, []byte("A;B 1\nA;"+strings.Repeat("B", 100)+" 5\nA;C 2\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", path, "--max-line-bytes", "50"}, nil)
+	if code != 0 || !strings.Contains(stderr, "warning: skipped 1 collapsed lines longer than 50 bytes (first at line 2)") ||
+		!strings.Contains(stdout, "C                                                    66.7%") {
+		t.Errorf("exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	// The default keeps the line.
+	_, stdout, stderr = runCLIForTest(t, []string{"hot", path}, nil)
+	if strings.Contains(stderr, "skipped") || !strings.Contains(stdout, "62.5%") {
+		t.Errorf("default limit: stdout:\n%s\nstderr:\n%s", stdout, stderr)
+	}
+	if code, _, _ := runCLIForTest(t, []string{"hot", path, "--max-line-bytes", "-1"}, nil); code != exitUsage {
+		t.Errorf("--max-line-bytes -1: exit %d, want %d", code, exitUsage)
+	}
+}
+
+func TestSampleCLI(t *testing.T) {
+	var b strings.Builder
+	for i := range 20000 {
+		if i%4 == 0 {
+			b.WriteString("Main.run;Cold.wait 1\n")
+		} else {
+			b.WriteString("Main.run;Hot.spin 1\n")
+		}
+	}
+	b.WriteString("Main.run;Big.job 10000\n")
+	path := filepath.Join(t.TempDir(), "big.collapsed")
+	os.WriteFile(path, []byte(b.String()), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", path, "--sample", "10%"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	var kept, total int
+	var margin float64
+	if _, err := fmt.Sscanf(stderr[strings.Index(stderr, "kept"):], "kept %d of %d samples; percentages are approximate, within ±%f", &kept, &total, &margin); err != nil {
+		t.Fatalf("no sampling note (%v):\n%s", err, stderr)
+	}
+	if total != 30000 || kept < 2700 || kept > 3300 || margin != 1.7 {
+		t.Errorf("kept %d of %d, margin %.1f; want about 3000 of 30000 within ±1.7:\n%s", kept, total, margin, stderr)
+	}
+	if !strings.Contains(stdout, "Hot.spin") || !strings.Contains(stdout, "Big.job") {
+		t.Errorf("unexpected output:\n%s", stdout)
+	}
+	// The same input samples the same way.
+	if _, again, _ := runCLIForTest(t, []string{"hot", path, "--sample", "10"}, nil); again != stdout {
+		t.Errorf("sampling not repeatable:\n%s\nvs\n%s", stdout, again)
+	}
+
+	for _, v := range []string{"0", "150%", "ten"} {
+		if code, _, _ := runCLIForTest(t, []string{"hot", path, "--sample", v}, nil); code != exitUsage {
+			t.Errorf("--sample %s: exit %d, want %d", v, code, exitUsage)
+		}
+	}
+	if _, _, stderr := runCLIForTest(t, []string{"hot", jfrFixture("cpu.jfr"), "--sample", "10%"}, nil); !strings.Contains(stderr, "--sample applies to collapsed text only") {
+		t.Errorf("JFR input: no warning:\n%s", stderr)
+	}
+	// Inputs recognized by content read as collapsed by name but are not
+	// thinned.
+	flame := filepath.Join(t.TempDir(), "flame.json")
+	os.WriteFile(flame, []byte(`{"name":"root","value":3,"children":[{"name":"A.a","value":3}]}`), 0o644)
+	for _, args := range [][]string{{"hot", flame}, {"diff", jfrFixture("cpu.jfr"), flame}} {
+		_, _, stderr := runCLIForTest(t, append(args, "--sample", "10%"), nil)
+		if !strings.Contains(stderr, "--sample applies to collapsed text only; reading all of the flame graph JSON") {
+			t.Errorf("%v: no warning:\n%s", args, stderr)
+		}
+	}
+	// Commands that read no profile do not take --sample.
+	for _, args := range [][]string{{"gen"}, {"version"}} {
+		if code, _, stderr := runCLIForTest(t, append(args, "--sample", "10%"), nil); code != exitUsage || !strings.Contains(stderr, "unknown flag: --sample") {
+			t.Errorf("%v: exit %d, stderr:\n%s", args, code, stderr)
+		}
+	}
+}
+
+func TestThinSamples(t *testing.T) {
+	for _, count := range []int{1, 10, 1000, 100000} {
+		kept := 0
+		for seed := range uint64(2000) {
+			n := thinSamples(count, 0.1, seed)
+			if n < 0 || n > count {
+				t.Fatalf("thinSamples(%d) = %d", count, n)
+			}
+			kept += n
+		}
+		mean := float64(kept) / 2000
+		if want := float64(count) * 0.1; math.Abs(mean-want) > 0.05*want+0.02 {
+			t.Errorf("count %d: mean kept %.3f, want about %.1f", count, mean, want)
+		}
+	}
+	// Few expected samples on either side of a large count: the draw must
+	// stay unbiased (the normal approximation averages 0.73 for 65 at 1%).
+	for _, tt := range []struct {
+		count int
+		p     float64
+	}{{65, 0.01}, {65, 0.99}, {500, 0.005}} {
+		kept := 0
+		for seed := range uint64(20000) {
+			kept += thinSamples(tt.count, tt.p, seed)
+		}
+		mean := float64(kept) / 20000
+		if want := float64(tt.count) * tt.p; math.Abs(mean-want) > 0.03 {
+			t.Errorf("count %d at p %g: mean kept %.3f, want about %.2f", tt.count, tt.p, mean, want)
+		}
+	}
+	if thinSamples(50, 1, 7) != 50 || thinSamples(1000, 1, 7) != 1000 {
+		t.Errorf("p=1 must keep every sample")
+	}
+}
+
+func TestVersionJSONCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"version", "--json"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	var info map[string]any
+	if err := json.Unmarshal([]byte(stdout), &info); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, stdout)
+	}
+	if info["go_version"] != runtime.Version() || info["platform"] != runtime.GOOS+"/"+runtime.GOARCH || info["version"] == "" {
+		t.Errorf("unexpected payload: %v", info)
+	}
+	// No update fields without --check-update: the lookup needs the network.
+	if _, ok := info["update_available"]; ok {
+		t.Errorf("update_available without --check-update: %v", info)
+	}
+}
+
+func TestBuildInfoWithLatest(t *testing.T) {
+	b := buildInfo{Version: "v0.12"}
+	b.withLatest("")
+	if b.Latest != "" || b.UpdateAvailable != nil {
+		t.Errorf("failed lookup recorded: %+v", b)
+	}
+	b.withLatest("0.12")
+	if b.UpdateAvailable == nil || *b.UpdateAvailable {
+		t.Errorf("same version reported as update: %+v", b)
+	}
+	b.withLatest("v0.13")
+	if b.Latest != "v0.13" || !*b.UpdateAvailable {
+		t.Errorf("newer release not reported: %+v", b)
+	}
+	b = buildInfo{Version: "v0.14.1"}
+	b.withLatest("v0.14")
+	if b.UpdateAvailable == nil || *b.UpdateAvailable {
+		t.Errorf("older release reported as update: %+v", b)
+	}
+}
+
+func TestSelftestCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"selftest"}, nil)
+	if code != 0 || strings.Contains(stdout, "FAIL") || !strings.HasSuffix(stdout, "selftest passed (7 checks)\n") {
+		t.Errorf("exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+}
+
+func TestSelftestCheckFailures(t *testing.T) {
+	if _, err := runSelftestCheck(selftestCheck{name: "panics", run: func() (string, error) {
+		var sf *stackFile
+		return fmt.Sprint(sf.totalSamples), nil
+	}}); err == nil || !strings.HasPrefix(err.Error(), "panic: ") {
+		t.Errorf("panic not turned into a failure: %v", err)
+	}
+}
+
+func TestCallersShowSelfCLI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "callers.collapsed")
+	os.WriteFile(path, []byte(strings.Join([]string{
+		"main;Api.get;Map.resize 6",
+		"main;Api.get;Map.resize;Arrays.copyOf 2",
+		"main;Batch.run;Map.resize;Arrays.copyOf 2",
+	}, "\n")+"\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"callers", path, "-m", "Map.resize", "--show-self"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	want := `# 10 samples in 'Map.resize' (100.0% of 10 total)
+[100.0%] Map.resize  ← self=60.0%
+  [80.0%] Api.get  ← self=60.0%
+    [80.0%] main  ← self=60.0%
+  [20.0%] Batch.run
+    [20.0%] main
+# Map.resize total: 10 samples (100.0%), self 6 (60.0%)
+`
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+	// Without the flag, callers stays unannotated.
+	_, stdout, _ = runCLIForTest(t, []string{"callers", path, "-m", "Map.resize"}, nil)
+	if strings.Contains(stdout, "self") || strings.Contains(stdout, " total:") {
+		t.Errorf("annotations without --show-self:\n%s", stdout)
+	}
+}
+
+func TestTreeCallersTotalsHeaderCLI(t *testing.T) {
+	input := "main;Api.get;Map.resize 3\nmain;Batch.run;Map.resize 1\nmain;Idle.park 6\n"
+	header := "# 4 samples in 'Map.resize' (40.0% of 10 total)\n"
+	for _, cmd := range []string{"tree", "callers"} {
+		code, stdout, stderr := runCLIForTest(t, []string{cmd, "-", "-m", "Map.resize"}, strings.NewReader(input))
+		if code != 0 {
+			t.Fatalf("%s: exit %d, stderr:\n%s", cmd, code, stderr)
+		}
+		if !strings.HasPrefix(stdout, header) {
+			t.Errorf("%s: want header %q, got:\n%s", cmd, header, stdout)
+		}
+		// --relative already states the matched samples.
+		_, stdout, _ = runCLIForTest(t, []string{cmd, "-", "-m", "Map.resize", "--relative"}, strings.NewReader(input))
+		if strings.Contains(stdout, " total)") {
+			t.Errorf("%s --relative: unexpected totals header:\n%s", cmd, stdout)
+		}
+	}
+	// Without -m the tree covers the whole profile and has no header.
+	_, stdout, _ := runCLIForTest(t, []string{"tree", "-"}, strings.NewReader(input))
+	if strings.HasPrefix(stdout, "#") {
+		t.Errorf("tree without -m: unexpected header:\n%s", stdout)
+	}
+}
+
+func TestDiffPatchCLI(t *testing.T) {
+	dir := t.TempDir()
+	before := filepath.Join(dir, "before.collapsed")
+	after := filepath.Join(dir, "after.collapsed")
+	os.WriteFile(before, []byte("main;com/acme/Cache.load;java/util/HashMap.resize 6\nmain;com/acme/Db.query 2\nmain;com/acme/Old.run 2\n"), 0o644)
+	os.WriteFile(after, []byte("main;com/acme/Cache.load;java/util/HashMap.resize 2\nmain;com/acme/Db.query 2\nmain;com/acme/Json.encode 6\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"diff", before, after, "--format", "patch"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	want := "--- " + before + "\n+++ " + after + "\n" +
+		"@@ -80.0% +80.0% @@\n" +
+		"+  60.0%  Json.encode  (new)\n" +
+		"-  60.0%  HashMap.resize\n" +
+		"+  20.0%  HashMap.resize  (-40.0%)\n" +
+		"-  20.0%  Old.run  (gone)\n"
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	code, stdout, stderr = runCLIForTest(t, []string{"diff", before, after, "--format", "patch", "--by-package"}, nil)
+	if code != 0 {
+		t.Fatalf("--by-package: exit %d, stderr:\n%s", code, stderr)
+	}
+	want = "--- " + before + "\n+++ " + after + "\n" +
+		"@@ -20.0% +60.0% @@ com.acme\n" +
+		"+  60.0%  Json.encode  (new)\n" +
+		"-  20.0%  Old.run  (gone)\n" +
+		"@@ -60.0% +20.0% @@ java.util\n" +
+		"-  60.0%  HashMap.resize\n" +
+		"+  20.0%  HashMap.resize  (-40.0%)\n"
+	if stdout != want {
+		t.Errorf("--by-package got:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	for _, args := range [][]string{
+		{"diff", before, after, "--by-package"},
+		{"diff", before, after, "--format", "patch", "--flat-threads"},
+		{"diff", before, after, after, "--format", "patch"},
+		{"diff", before, after, "--format", "unified"},
+	} {
+		if code, _, _ := runCLIForTest(t, args, nil); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestDiffHTMLCLI(t *testing.T) {
+	dir := t.TempDir()
+	before := filepath.Join(dir, "before.collapsed")
+	after := filepath.Join(dir, "after.collapsed")
+	os.WriteFile(before, []byte("main;com/acme/Cache.load;java/util/HashMap.resize 6\nmain;com/acme/Db.query 2\nmain;com/acme/Old.run 2\n"), 0o644)
+	os.WriteFile(after, []byte("main;com/acme/Cache.load;java/util/HashMap.resize 2\nmain;com/acme/Db.query 2\nmain;com/acme/Json.encode 6\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"diff", before, after, "--format", "html"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"<!DOCTYPE html>",
+		"<h3>IMPROVEMENT (1)</h3>",
+		`<tr><td>HashMap.resize</td><td data-v="60">60.0%</td><td data-v="20">20.0%</td><td data-v="-40">-40.0%</td>`,
+		"<h3>NEW (1)</h3>",
+		"<h3>GONE (1)</h3>",
+		"<title>Json.encode\n0.0% → 60.0% (+60.0%)</title>",
+		`<svg class="flame"`,
+		"<script>",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("missing %q in:\n%s", want, stdout)
+		}
+	}
+	// Gone paths have no width in the after-sized graph.
+	if strings.Contains(stdout, "<title>Old.run") {
+		t.Errorf("gone path drawn in the flame graph:\n%s", stdout)
+	}
+
+	for _, args := range [][]string{
+		{"diff", before, after, "--format", "html", "--by-thread"},
+		{"diff", before, after, after, "--format", "html"},
+		{"diff", before, after, "--format", "html", "--by-package"},
+	} {
+		if code, _, _ := runCLIForTest(t, args, nil); code != exitUsage {
+			t.Errorf("%v: exit %d, want %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestCallersJSONCLI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p.txt")
+	os.WriteFile(path, []byte("Main.run;Api.get;Map.put 3\nJob.run;Map.put 1\nMain.run;Other.work 6\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"callers", path, "--format", "json", "-m", "Map.put"}, nil)
+	if code != 0 {
+		t.Fatalf("callers --format json: exit %d, stderr:\n%s", code, stderr)
+	}
+	want := `{"name":"all","value":4,"children":[{"name":"Map.put","value":4,"children":[` +
+		`{"name":"Api.get","value":3,"children":[{"name":"Main.run","value":3,"children":[]}]},` +
+		`{"name":"Job.run","value":1,"children":[]}]}]}` + "\n"
+	if stdout != want {
+		t.Errorf("callers JSON:\n got %s\nwant %s", stdout, want)
+	}
+	code, stdout, stderr = runCLIForTest(t, []string{"callers", path, "--format", "json", "-m", "Nope"}, nil)
+	if code != 0 || stdout != "" || !strings.Contains(stderr, "no stacks matching 'Nope'") {
+		t.Errorf("no match: exit %d, stdout %q, stderr:\n%s", code, stdout, stderr)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"callers", path, "--format", "json", "-m", "Map.put", "--show-self"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "--format text only") {
+		t.Errorf("--show-self with json: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestCustomColumnsCLI(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "p.txt")
+	os.WriteFile(path, []byte("[worker-1 tid=1];Main.run;Codec.encode 6\n[worker-2 tid=2];Main.run;Db.query 4\n"), 0o644)
+	defs := filepath.Join(dir, "columns.txt")
+	os.WriteFile(defs, []byte("# team conventions\nper_request = samples / $TEST_REQUESTS\nshare = pct / 100\n"), 0o644)
+	t.Setenv("TEST_REQUESTS", "2")
+
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", path, "--top", "1", "--column", "@" + defs}, nil)
+	if code != 0 {
+		t.Fatalf("hot: exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		"SAMPLES per_request      share\n",
+		"60.0%   60.0%         6        3.00       0.60\n",
+		"0.0%  100.0%        10        5.00       1.00\n",
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("hot missing %q:\n%s", want, stdout)
+		}
+	}
+
+	code, stdout, stderr = runCLIForTest(t, []string{"threads", path, "--column", "twice = samples * 2"}, nil)
+	if code != 0 {
+		t.Fatalf("threads: exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout, "PCT      twice  TID\n") || !strings.Contains(stdout, "60.0%         12  1\n") {
+		t.Errorf("threads columns:\n%s", stdout)
+	}
+
+	// Starlark builtins are in scope.
+	code, stdout, stderr = runCLIForTest(t, []string{"hot", path, "--top", "1", "--column", "x = max(samples, 8)"}, nil)
+	if code != 0 || !strings.Contains(stdout, "60.0%   60.0%         6          8\n") {
+		t.Errorf("builtin: exit %d, stderr %q, stdout:\n%s", code, stderr, stdout)
+	}
+
+	// Errors that depend on the row value blank its cells instead of failing.
+	t.Setenv("TEST_ZERO", "0")
+	for _, col := range []string{"x = samples / $TEST_ZERO", "x = 'text'"} {
+		code, stdout, stderr = runCLIForTest(t, []string{"hot", path, "--top", "1", "--column", col}, nil)
+		if code != 0 || !strings.Contains(stdout, "60.0%   60.0%         6          -\n") {
+			t.Errorf("%s: exit %d, stderr %q, stdout:\n%s", col, code, stderr, stdout)
+		}
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"hot", path, "--column", "no equals"}, `invalid --column "no equals"`},
+		{[]string{"hot", path, "--column", "x = samples / $TEST_UNSET_VAR"}, "$TEST_UNSET_VAR is not set"},
+		{[]string{"hot", path, "--column", "x = samples * interval"}, "interval needs a cpu JFR recording"},
+		{[]string{"hot", path, "--column", "x = samples * seconds"}, "seconds needs a recording with a known duration"},
+		{[]string{"hot", path, "--column", "x = samples * nope"}, "undefined: nope"},
+		{[]string{"hot", path, "--column", "x = samples *"}, "--column x:"},
+		{[]string{"threads", path, "--states", "--column", "x = samples"}, "cannot be combined with --states"},
+	} {
+		code, _, stderr := runCLIForTest(t, tc.args, nil)
+		if code != exitUsage || !strings.Contains(stderr, tc.want) {
+			t.Errorf("%v: exit %d, stderr:\n%s\nwant %q", tc.args, code, stderr, tc.want)
+		}
+	}
+}
+
+func TestLinesRangesCLI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "p.txt")
+	os.WriteFile(path, []byte(strings.Join([]string{
+		"Main.run:5;Loop.body:10 3",
+		"Main.run:5;Loop.body:11 2",
+		"Main.run:5;Loop.body:12;Loop.body:10 1",
+		"Main.run:5;Loop.body:20 4",
+	}, "\n")+"\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"lines", path, "-m", "Loop.body", "--ranges"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	// The recursive stack hits lines 12 and 10 of one range but counts once.
+	want := fmt.Sprintf("%-40s %9s %7s %6s\n", "SOURCE:LINES", "SAMPLES", "PCT", "LINES") +
+		fmt.Sprintf("%-40s %9d %7s %6d\n", "Loop.body:10-12", 6, "60.0%", 3) +
+		fmt.Sprintf("%-40s %9d %7s %6d\n", "Loop.body:20", 4, "40.0%", 1)
+	if stdout != want {
+		t.Errorf("got:\n%s\nwant:\n%s", stdout, want)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"lines", path, "-m", "Loop.body", "--ranges", "--bci"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "--ranges cannot be combined with --bci") {
+		t.Errorf("--bci: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestVisualVMImport(t *testing.T) {
+	dir := t.TempDir()
+	xmlPath := filepath.Join(dir, "snapshot.xml")
+	os.WriteFile(xmlPath, []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<ExportedView Name="CPU snapshot" type="CCT">
+ <tree>
+ <Node>
+  <Name>main</Name>
+  <Time>1,000</Time>
+  <Node>
+   <Name>com.acme.App.main (String[])</Name>
+   <Time>1000</Time>
+   <Node>
+    <Name>com.acme.Codec.encode (byte[])<Name>
+    <Time_Relative>70%</Time_Relative>
+    <Time>700</Time>
+    <Node><Name>Self time</Name><Time>200</Time></Node>
+    <Node><Name>java.util.HashMap.resize ()</Name><Time>500</Time></Node>
+   </Node>
+  </Node>
+ </Node>
+ <Node>
+  <Name>worker-1</Name>
+  <Time>300</Time>
+  <Node><Name><![CDATA[com.acme.Job.run ()]]></Name><Time>300</Time></Node>
+ </Node>
+ </tree>
+</ExportedView>
+`), 0o644)
+	csvPath := filepath.Join(dir, "snapshot.csv")
+	os.WriteFile(csvPath, []byte("\"Name\";\"Time [%]\";\"Time\";\"Invocations\"\r\n"+
+		"\"main\";\"100.0\";\"1000\";\"1\"\r\n"+
+		"\" com.acme.App.main (String[])\";\"100.0\";\"1000\";\"1\"\r\n"+
+		"\"  com.acme.Codec.encode (byte[])\";\"70.0\";\"700\";\"3\"\r\n"+
+		"\"   java.util.HashMap.resize ()\";\"50.0\";\"500\";\"3\"\r\n"+
+		"\"worker-1\";\"0.0\";\"300\";\"1\"\r\n"+
+		"\" com.acme.Job.run ()\";\"0.0\";\"300\";\"1\"\r\n"), 0o644)
+
+	want := []string{
+		"[main];com.acme.App.main;com.acme.Codec.encode;java.util.HashMap.resize 500",
+		"[main];com.acme.App.main 300",
+		"[worker-1];com.acme.Job.run 300",
+		"[main];com.acme.App.main;com.acme.Codec.encode 200",
+	}
+	for _, path := range []string{xmlPath, csvPath} {
+		code, stdout, stderr := runCLIForTest(t, []string{"collapse", path}, nil)
+		if code != 0 {
+			t.Fatalf("%s: exit %d, stderr:\n%s", path, code, stderr)
+		}
+		if got := strings.Split(strings.TrimSpace(stdout), "\n"); !slices.Equal(got, want) {
+			t.Errorf("%s:\n got %q\nwant %q", filepath.Base(path), got, want)
+		}
+	}
+
+	code, _, stderr := runCLIForTest(t, []string{"hot", filepath.Join(dir, "old.nps")}, nil)
+	if code != exitParse || !strings.Contains(stderr, "export the call tree as XML or CSV") {
+		t.Errorf(".nps: exit %d, stderr:\n%s", code, stderr)
+	}
+	bad := filepath.Join(dir, "bad.xml")
+	os.WriteFile(bad, []byte("<?xml version=\"1.0\"?><Node><Name>main</Name><Time>soon</Time></Node>"), 0o644)
+	if code, _, stderr := runCLIForTest(t, []string{"hot", bad}, nil); code != exitParse || !strings.Contains(stderr, `invalid <Time> "soon"`) {
+		t.Errorf("bad time: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestFlameJSONImport(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "p.txt")
+	os.WriteFile(src, []byte("[w1];Main.run;Map.put;Map.hash 3\n[w1];Main.run 2\n[w2];GC.work 4\n"), 0o644)
+
+	// tree --format json --by-thread reads back into the same stacks.
+	_, flame, _ := runCLIForTest(t, []string{"tree", src, "--format", "json", "--by-thread", "--depth", "64", "--min-pct", "0"}, nil)
+	d3 := filepath.Join(dir, "flame.json")
+	os.WriteFile(d3, []byte(flame), 0o644)
+	code, stdout, stderr := runCLIForTest(t, []string{"collapse", d3}, nil)
+	if code != 0 {
+		t.Fatalf("d3: exit %d, stderr:\n%s", code, stderr)
+	}
+	want := []string{"[w1];Main.run;Map.put;Map.hash 3", "[w1];Main.run 2", "[w2];GC.work 4"}
+	got := strings.Split(strings.TrimSpace(stdout), "\n")
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("d3 round trip:\n got %q\nwant %q", got, want)
+	}
+
+	speedscope := filepath.Join(dir, "profile.speedscope.json")
+	os.WriteFile(speedscope, []byte(`{"$schema":"https://www.speedscope.app/file-format-schema.json",
+ "shared":{"frames":[{"name":"main"},{"name":"App.run","line":12},{"name":"Codec.encode","line":40}]},
+ "profiles":[
+  {"type":"sampled","name":"worker","unit":"none","samples":[[0,1],[0,1,2],[0,1,2]],"weights":[1,2,1]},
+  {"type":"evented","name":"io","unit":"nanoseconds","events":[
+   {"type":"O","frame":0,"at":0},{"type":"O","frame":2,"at":2000},{"type":"C","frame":2,"at":5000},{"type":"C","frame":0,"at":10000}]}
+ ]}`), 0o644)
+	code, stdout, stderr = runCLIForTest(t, []string{"collapse", speedscope}, nil)
+	if code != 0 {
+		t.Fatalf("speedscope: exit %d, stderr:\n%s", code, stderr)
+	}
+	// The evented profile is in ns and is counted in µs.
+	want = []string{"[io];main 7", "[io];main;Codec.encode 3", "[worker];main;App.run;Codec.encode 3", "[worker];main;App.run 1"}
+	if got := strings.Split(strings.TrimSpace(stdout), "\n"); !slices.Equal(got, want) {
+		t.Errorf("speedscope:\n got %q\nwant %q", got, want)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"lines", speedscope, "-m", "Codec.encode", "-t", "worker"}, nil)
+	if !strings.Contains(stdout, "Codec.encode:40") {
+		t.Errorf("speedscope line numbers lost:\n%s", stdout)
+	}
+
+	for _, tc := range []struct{ body, want string }{
+		{`{"profiles":[]}`, "missing shared.frames"},
+		{`{"shared":{"frames":[]},"profiles":[{"type":"sampled","name":"t","samples":[[3]]}]}`, "frame index 3 out of range"},
+		{`{"version":1}`, "neither d3-flamegraph"},
+		{`{"name":"all","value":3,"children":[{"name":"Main.run","value":-2}]}`, `node "Main.run" has negative value -2`},
+		{`{"name":"all","value":5,"children":[{"name":"Main.run","value":5,"children":[{"name":"A.a","value":4},{"name":"B.b","value":3}]}]}`, `children of "Main.run" sum to 7, more than its value 5`},
+		{`{"name":"all","value":2,"children":[{"name":"A.a","value":2},{"name":"B.b","value":1}]}`, `children of "all" sum to 3, more than its value 2`},
+		{`{"name":`, "flame graph JSON"},
+	} {
+		bad := filepath.Join(dir, "bad.json")
+		os.WriteFile(bad, []byte(tc.body), 0o644)
+		if code, _, stderr := runCLIForTest(t, []string{"hot", bad}, nil); code != exitParse || !strings.Contains(stderr, tc.want) {
+			t.Errorf("%s: exit %d, stderr:\n%s\nwant %q", tc.body, code, stderr, tc.want)
+		}
+	}
+}
+
+func TestOwnersCLI(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "src")
+	for _, f := range []string{"core/src/main/java/com/app/Codec.java", "web/src/main/java/com/app/web/Handler.kt", "core/src/main/java/com/app/Main.java"} {
+		os.MkdirAll(filepath.Join(root, filepath.Dir(f)), 0o755)
+		os.WriteFile(filepath.Join(root, f), nil, 0o644)
+	}
+	os.MkdirAll(filepath.Join(root, ".github"), 0o755)
+	os.WriteFile(filepath.Join(root, ".github", "CODEOWNERS"), []byte("# teams\n* @org/platform\n/web/ @org/web\n**/Codec.java @org/codec @alice\n"), 0o644)
+
+	for _, tc := range []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"*.java", "a/b/C.java", true},
+		{"/web/", "web/x/Y.kt", true},
+		{"/web/", "core/web/Y.kt", false},
+		{"docs", "core/docs/x.md", true},
+		{"src/*.go", "src/a/b.go", false},
+		{"**/Codec.java", "Codec.java", true},
+		{"core/**/App.java", "core/a/b/App.java", true},
+		{"docs/*", "docs/App.java", true},
+		{"docs/*", "docs/a/b/App.java", false},
+		{"docs/*/", "docs/a/b/App.java", true},
+		{"docs/**", "docs/a/b/App.java", true},
+	} {
+		if got := codeownersRegexp(tc.pattern).MatchString(tc.path); got != tc.want {
+			t.Errorf("pattern %q on %q: got %v, want %v", tc.pattern, tc.path, got, tc.want)
+		}
+	}
+
+	path := filepath.Join(dir, "p.txt")
+	os.WriteFile(path, []byte("com/app/Main.main;com/app/web/Handler.handle;com/app/Codec.encode 3\n"+
+		"com/app/Main.main;com/app/web/Handler.handle 2\n"+
+		"com/app/Main.main;java/util/HashMap.put 1\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", path, "--source-root", root, "--group-by", "owner"}, nil)
+	if code != 0 {
+		t.Fatalf("hot --group-by owner: exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{"[owner=@org/codec @alice]", "[owner=@org/web]", "[owner=@org/platform]", "[owner=none]"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("hot --group-by owner missing %q:\n%s", want, stdout)
+		}
+	}
+
+	_, stdout, _ = runCLIForTest(t, []string{"hot", path, "--source-root", root}, nil)
+	for _, want := range []string{"  OWNER\n", "Codec.encode", "  @org/codec @alice\n", "HashMap.put"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("hot OWNER column missing %q:\n%s", want, stdout)
+		}
+	}
+	if !strings.Contains(stdout, "         1  -\n") {
+		t.Errorf("unowned HashMap.put should show -:\n%s", stdout)
+	}
+
+	// .github/CODEOWNERS is read before one at the root, as on GitHub.
+	os.WriteFile(filepath.Join(root, "CODEOWNERS"), []byte("* @org/ignored\n"), 0o644)
+	_, stdout, _ = runCLIForTest(t, []string{"hot", path, "--source-root", root}, nil)
+	if strings.Contains(stdout, "@org/ignored") || !strings.Contains(stdout, "@org/web") {
+		t.Errorf("root CODEOWNERS should lose to .github/CODEOWNERS:\n%s", stdout)
+	}
+
+	code, _, stderr = runCLIForTest(t, []string{"tree", path, "--group-by", "owner"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "--group-by owner requires --source-root") {
+		t.Errorf("owner without root: exit %d, stderr:\n%s", code, stderr)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"hot", path, "--source-root", filepath.Join(dir, "missing")}, nil)
+	if code == 0 || !strings.Contains(stderr, "--source-root") {
+		t.Errorf("missing root: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestOwnersFromGitHistory(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	repo := t.TempDir()
+	root := filepath.Join(repo, "svc")
+	os.MkdirAll(filepath.Join(root, "com", "app"), 0o755)
+	git := func(author string, args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=" + author, "-c", "user.email=x@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("", "init", "-q")
+	for i, author := range []string{"alice", "bob", "bob"} {
+		os.WriteFile(filepath.Join(root, "com", "app", "Codec.java"), []byte(strconv.Itoa(i)), 0o644)
+		os.WriteFile(filepath.Join(root, "com", "app", "Main.java"), []byte("main"), 0o644)
+		git(author, "add", "-A")
+		git(author, "commit", "-q", "--allow-empty", "-m", "change")
+	}
+
+	idx, err := loadOwnerIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := idx.owner("com/app/Codec.encode"); got != "bob" {
+		t.Errorf("Codec owner = %q, want bob", got)
+	}
+	if got := idx.owner("com/app/Main.main"); got != "alice" {
+		t.Errorf("Main owner = %q, want alice", got)
+	}
+}
+
+func TestRepoURLCLI(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "p.txt")
+	os.WriteFile(path, []byte("com/app/Main.main:3;com/app/Codec.encode:40 3\n"+
+		"com/app/Main.main:3;com/app/Codec.encode:41 1\n"+
+		"com/app/Main.main;libc.so.6.write 1\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"tree", path, "--format", "json", "--repo-url", "https://example.com/src/main/java/{path}#L{line}"}, nil)
+	if code != 0 {
+		t.Fatalf("tree --repo-url: exit %d, stderr:\n%s", code, stderr)
+	}
+	for _, want := range []string{
+		`{"name":"Main.main","value":5,"url":"https://example.com/src/main/java/com/app/Main.java#L3"`,
+		`{"name":"Codec.encode","value":4,"url":"https://example.com/src/main/java/com/app/Codec.java#L40"`,
+		`{"name":"write","value":1,"children":[]}`,
+	} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("tree JSON missing %q:\n%s", want, stdout)
+		}
+	}
+
+	const tmpl = "https://example.com/src/main/java/{path}#L{line}"
+	code, stdout, stderr = runCLIForTest(t, []string{"hot", path, "--repo-url", tmpl}, nil)
+	if code != 0 || !strings.Contains(stdout, "  URL\n") ||
+		!regexp.MustCompile(`Codec\.encode .*  https://example\.com/src/main/java/com/app/Codec\.java#L40\n`).MatchString(stdout) ||
+		!regexp.MustCompile(`write .*  -\n`).MatchString(stdout) {
+		t.Errorf("hot --repo-url: exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	code, stdout, stderr = runCLIForTest(t, []string{"lines", path, "-m", "Codec.encode", "--repo-url", tmpl}, nil)
+	if code != 0 || !regexp.MustCompile(`Codec\.encode:41 .*  https://example\.com/src/main/java/com/app/Codec\.java#L41\n`).MatchString(stdout) {
+		t.Errorf("lines --repo-url: exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	code, stdout, stderr = runCLIForTest(t, []string{"lines", path, "-m", "Codec.encode", "--ranges", "--repo-url", tmpl}, nil)
+	if code != 0 || !regexp.MustCompile(`Codec\.encode:40-41 .*  https://example\.com/src/main/java/com/app/Codec\.java#L40\n`).MatchString(stdout) {
+		t.Errorf("lines --ranges --repo-url: exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+	before := filepath.Join(dir, "before.txt")
+	os.WriteFile(before, []byte("com/app/Main.main:3;com/app/Old.run:7 5\n"), 0o644)
+	code, stdout, stderr = runCLIForTest(t, []string{"diff", before, path, "--format", "html", "--repo-url", tmpl}, nil)
+	if code != 0 ||
+		!strings.Contains(stdout, `<td><a href="https://example.com/src/main/java/com/app/Old.java#L7" target="_blank">Old.run</a></td>`) ||
+		!strings.Contains(stdout, `<a href="https://example.com/src/main/java/com/app/Codec.java#L40" target="_blank"><g>`) {
+		t.Errorf("diff html --repo-url: exit %d, stdout:\n%s\nstderr:\n%s", code, stdout, stderr)
+	}
+
+	root := filepath.Join(dir, "src")
+	os.MkdirAll(filepath.Join(root, "core", "com", "app"), 0o755)
+	os.WriteFile(filepath.Join(root, "core", "com", "app", "Codec.java"), []byte("class Codec {}\n"), 0o644)
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", root, "-c", "user.name=t", "-c", "user.email=t@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Skipf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-qm", "init")
+	sha, _ := exec.Command("git", "-C", root, "rev-parse", "HEAD").Output()
+
+	html := filepath.Join(dir, "t.html")
+	code, _, stderr = runCLIForTest(t, []string{"treemap", path, "--html", html, "--source-root", root, "--repo-url", "https://example.com/blob/{sha}/{path}#L{line}"}, nil)
+	if code != 0 {
+		t.Fatalf("treemap --repo-url: exit %d, stderr:\n%s", code, stderr)
+	}
+	page, _ := os.ReadFile(html)
+	if want := `<a href="https://example.com/blob/` + strings.TrimSpace(string(sha)) + `/core/com/app/Codec.java#L40"`; !strings.Contains(string(page), want) {
+		t.Errorf("treemap missing %s:\n%s", want, page)
+	}
+	if strings.Count(string(page), "<a href") != 1 {
+		t.Errorf("only Codec.encode is in the checkout:\n%s", page)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"tree", path, "--repo-url", "https://x/{path}"}, "--repo-url applies to --format json only"},
+		{[]string{"callers", path, "-m", "write", "--format", "json", "--repo-url", "https://x/{sha}/{path}"}, "--repo-url {sha} requires --source-root"},
+		{[]string{"tree", path, "--format", "json", "--repo-url", "https://x/"}, "has no {path}"},
+		{[]string{"hot", path, "--group-by", "package", "--repo-url", "https://x/{path}"}, "cannot be combined with --group-by package"},
+		{[]string{"diff", path, path, "--repo-url", "https://x/{path}"}, "--repo-url requires --format html"},
+		{[]string{"diff", path, path, "--format", "html", "--source-root", dir}, "--source-root requires --repo-url"},
+	} {
+		if code, _, stderr := runCLIForTest(t, tc.args, nil); code != exitUsage || !strings.Contains(stderr, tc.want) {
+			t.Errorf("%v: exit %d, stderr:\n%s\nwant %q", tc.args, code, stderr, tc.want)
+		}
+	}
+}
+
+func TestCPULimit(t *testing.T) {
+	const (
+		container = 100 + iota
+		cpuInfo
+	)
+	classes := []testJFRClass{
+		{container, "jdk.ContainerConfiguration", []string{"startTime:1", "containerType:6", "cpuSlicePeriod:1", "cpuQuota:1", "cpuShares:1", "effectiveCpuCount:1"}},
+		{cpuInfo, "jdk.CPUInformation", []string{"startTime:1", "cpu:6", "description:6", "sockets:2", "cores:2", "hwThreads:2"}},
+	}
+	hostOnly := testJFRValues(cpuInfo, testJFRStartTicks, "x86_64", "", 1, 8, 16)
+	for _, tc := range []struct {
+		events [][]byte
+		want   string
+	}{
+		{[][]byte{hostOnly, testJFRValues(container, testJFRStartTicks, "cgroupv2", 100000, 150000, 1024, 2)}, "profile captured under a 1.5-CPU cgroup limit"},
+		{[][]byte{hostOnly, testJFRValues(container, testJFRStartTicks, "cgroupv2", 100000, 0, 1024, 4)}, "profile captured under a 4-CPU cgroup limit"},
+		{[][]byte{hostOnly, testJFRValues(container, testJFRStartTicks, "cgroupv2", 100000, 0, 1024, 16)}, "profile captured on a host with 16 hardware threads"},
+	} {
+		limit, err := readCPULimit(writeTestJFR(t, classes, nil, tc.events))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := limit.describe(); got != tc.want {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+	if limit, err := readCPULimit(writeTestJFR(t, classes, nil, nil)); err != nil || limit.cpus != 0 {
+		t.Errorf("no events: got %+v, %v", limit, err)
+	}
+
+	limit := cpuLimit{cpus: 2, source: cpuLimitFlag}
+	if w := limit.exceededBy(2.1); w != "" {
+		t.Errorf("within slack: %q", w)
+	}
+	if w := limit.exceededBy(3.5); !strings.Contains(w, "3.50 busy cores but only 2 CPUs") {
+		t.Errorf("exceeded: %q", w)
+	}
+}
+
+func TestCPULimitCLI(t *testing.T) {
+	code, stdout, stderr := runCLIForTest(t, []string{"hot", "testdata/cpu.jfr", "--cpus", "2", "--rate", "--top", "1"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "CPU limit: profile captured under a 2-CPU limit (--cpus)\n") ||
+		!strings.Contains(stderr, "warning: cpu samples imply 3.92 busy cores but only 2 CPUs were available") {
+		t.Errorf("stderr:\n%s", stderr)
+	}
+	if !strings.Contains(stdout, "CPU: 3.92 cores of 2\n") {
+		t.Errorf("stdout:\n%s", stdout)
+	}
+
+	_, _, stderr = runCLIForTest(t, []string{"hot", "testdata/cpu.jfr", "--cpus", "8"}, nil)
+	if strings.Contains(stderr, "warning: cpu samples") {
+		t.Errorf("8 CPUs are enough:\n%s", stderr)
+	}
+	_, stdout, _ = runCLIForTest(t, []string{"info", "testdata/cpu.jfr", "--expand", "0"}, nil)
+	if !strings.Contains(stdout, "CPUs: profile captured on a host with 20 hardware threads\n") {
+		t.Errorf("info header:\n%s", stdout)
+	}
+	_, _, stderr = runCLIForTest(t, []string{"hot", "testdata/wall.jfr", "--cpus", "1"}, nil)
+	if strings.Contains(stderr, "CPU limit") {
+		t.Errorf("wall profile annotated:\n%s", stderr)
+	}
+	if code, _, stderr := runCLIForTest(t, []string{"threads", "testdata/cpu.jfr", "--cpus", "-1"}, nil); code != exitUsage || !strings.Contains(stderr, "--cpus must not be negative") {
+		t.Errorf("negative: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestHotCallers(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"Main.run", "Codec.encode", "Buf.grow"}, lines: []uint32{0, 0, 0}, count: 6, thread: "main"},
+		{frames: []string{"Job.run", "Codec.encode", "Codec.encode", "Buf.grow"}, lines: []uint32{0, 0, 0, 0}, count: 3, thread: "main"},
+		{frames: []string{"Main.run", "Buf.grow"}, lines: []uint32{0, 0}, count: 1, thread: "main"},
+	})
+	out := captureOutput(func() {
+		cmdHotWith(sf, 2, false, false, 0, nil, nil, nil, nil, computeHotCallers(sf, false, 1))
+	})
+	want := "=== RANK BY SELF TIME ===\n" +
+		"METHOD                                               SELF%  TOTAL%   SAMPLES\n" +
+		"Buf.grow                                            100.0%  100.0%        10\n" +
+		"    ←  90.0% Codec.encode\n" +
+		"Codec.encode                                          0.0%   90.0%         0\n" +
+		"\n" +
+		"=== RANK BY TOTAL TIME ===\n" +
+		"METHOD                                               SELF%  TOTAL%   SAMPLES\n" +
+		"Buf.grow                                            100.0%  100.0%        10\n" +
+		"    ←  90.0% Codec.encode\n" +
+		"Codec.encode                                          0.0%   90.0%         9\n" +
+		"    ←  66.7% Main.run\n"
+	if out != want {
+		t.Errorf("hot --callers 1:\n%s\nwant:\n%s", out, want)
+	}
+
+	hc := computeHotCallers(sf, false, 2)
+	// The recursive Codec.encode frame is not its own caller.
+	if got := fmt.Sprint(hc.total["Codec.encode"]); got != "map[Job.run:3 Main.run:6]" {
+		t.Errorf("Codec.encode callers: %s", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "p.txt")
+	os.WriteFile(path, []byte("Main.run;Codec.encode 3\n"), 0o644)
+	if code, _, stderr := runCLIForTest(t, []string{"hot", path, "--callers", "-1"}, nil); code != exitUsage || !strings.Contains(stderr, "--callers must not be negative") {
+		t.Errorf("negative: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestEventsTextInputCLI(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "p.txt")
+	os.WriteFile(plain, []byte("[main tid=1];A;B 3\n[worker];A;C 2\nA;D 1\n"), 0o644)
+	code, stdout, stderr := runCLIForTest(t, []string{"events", plain}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if want := "Input: collapsed text (no event types; analyzed as a single event)\nStacks: 3  Samples: 6  Threads: 2\n"; stdout != want {
+		t.Errorf("unlabeled:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	labeled := filepath.Join(dir, "l.txt")
+	os.WriteFile(labeled, []byte("[event=cpu];A;B 3\n[event=wall];A;C 5\n"), 0o644)
+	_, stdout, _ = runCLIForTest(t, []string{"events", labeled}, nil)
+	if want := "Input: collapsed text with [event=...] labels\n\nEVENT        SAMPLES\nwall               5\ncpu                3\ntotal              8\n"; stdout != want {
+		t.Errorf("labeled:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	flame := filepath.Join(dir, "f.json")
+	os.WriteFile(flame, []byte(`{"name":"all","value":4,"children":[{"name":"A.a","value":4,"children":[]}]}`), 0o644)
+	_, stdout, _ = runCLIForTest(t, []string{"events", flame}, nil)
+	if !strings.HasPrefix(stdout, "Input: flame graph JSON (no event types") {
+		t.Errorf("flame JSON:\n%s", stdout)
+	}
+}
+
+func TestAssertStarlark(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{`a < 1 && b > 2`, `a < 1  and  b > 2`},
+		{`!(x) || y != 3`, ` not (x)  or  y != 3`},
+		{`self_pct("a&&b|!c") < 1`, `self_pct("a&&b|!c") < 1`},
+		{`thread_pct('it\'s!') < 1`, `thread_pct('it\'s!') < 1`},
+	} {
+		if got := assertStarlark(tc.in); got != tc.want {
+			t.Errorf("assertStarlark(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestAssertCLI(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "p.txt")
+	os.WriteFile(path, []byte("[main];Main.run;HashMap.resize 2\n[main];Main.run;Codec.encode 6\n[GC Thread#0];GC.work 2\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"assert", path, "--expr", `self_pct("HashMap.resize") < 25 && thread_pct("GC*") < 25`, "--expr", "samples() == 10"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	want := `PASS  self_pct("HashMap.resize") < 25 && thread_pct("GC*") < 25` + "\n" +
+		`      self_pct("HashMap.resize") = 20.0` + "\n" +
+		`      thread_pct("GC*") = 20.0` + "\n" +
+		"PASS  samples() == 10\n" +
+		"      samples() = 10\n"
+	if stdout != want {
+		t.Errorf("pass:\n%s\nwant:\n%s", stdout, want)
+	}
+
+	gates := filepath.Join(dir, "gates.txt")
+	os.WriteFile(gates, []byte("# CI gates\ntop_self_pct() < 70\ntotal_pct(\"Main.run\") < 50\n"), 0o644)
+	code, stdout, stderr = runCLIForTest(t, []string{"assert", path, "--expr", "@" + gates}, nil)
+	if code != exitAssertion || !strings.Contains(stdout, "PASS  top_self_pct() < 70\n      top_self_pct() = 60.0 (Codec.encode)\n") ||
+		!strings.Contains(stdout, "FAIL  total_pct(\"Main.run\") < 50\n      total_pct(\"Main.run\") = 80.0\n") ||
+		!strings.Contains(stderr, `ASSERT FAILED: total_pct("Main.run") < 50`) {
+		t.Errorf("fail: exit %d\n%s%s", code, stdout, stderr)
+	}
+
+	// A pattern that matches nothing reads 0, so absence gates pass...
+	code, stdout, stderr = runCLIForTest(t, []string{"assert", path, "--expr", `total_pct("LegacyCodec") == 0`, "--expr", `thread_pct("Missing*") < 5`}, nil)
+	if code != 0 ||
+		!strings.Contains(stdout, "PASS  total_pct(\"LegacyCodec\") == 0\n      total_pct(\"LegacyCodec\") = 0.0 (matched no frames)\n") ||
+		!strings.Contains(stdout, "PASS  thread_pct(\"Missing*\") < 5\n") {
+		t.Errorf("absence gate: exit %d\n%s%s", code, stdout, stderr)
+	}
+	// ...and --strict makes a misspelled method fail instead of reading 0%.
+	code, stdout, stderr = runCLIForTest(t, []string{"assert", path, "--strict", "--expr", `self_pct("HashMpa.resize") < 5`, "--expr", `thread_pct("Missing*") < 5`}, nil)
+	if code != exitAssertion ||
+		!strings.Contains(stdout, "FAIL  self_pct(\"HashMpa.resize\") < 5\n      self_pct(\"HashMpa.resize\") = 0.0 (matched no frames)\n") ||
+		!strings.Contains(stdout, "PASS  thread_pct(\"Missing*\") < 5\n") ||
+		!strings.Contains(stderr, `ASSERT FAILED: self_pct("HashMpa.resize") < 5 (self_pct("HashMpa.resize") matched no frames)`) {
+		t.Errorf("strict: exit %d\n%s%s", code, stdout, stderr)
+	}
+
+	for _, tc := range []struct {
+		expr, want string
+	}{
+		{"samples() +", "--expr"},
+		{"samples()", "got int, want a boolean"},
+		{"nope() < 1", "undefined: nope"},
+	} {
+		if code, _, stderr := runCLIForTest(t, []string{"assert", path, "--expr", tc.expr}, nil); code != exitUsage || !strings.Contains(stderr, tc.want) {
+			t.Errorf("%q: exit %d, stderr:\n%s", tc.expr, code, stderr)
+		}
+	}
+	if code, _, stderr := runCLIForTest(t, []string{"assert", path}, nil); code != exitUsage || !strings.Contains(stderr, "--expr required") {
+		t.Errorf("no expr: exit %d, stderr:\n%s", code, stderr)
+	}
+}
+
+func TestGenCLI(t *testing.T) {
+	args := []string{"gen", "--stacks", "500", "--depth", "12", "--threads", "6", "--seed", "7"}
+	code, out1, stderr := runCLIForTest(t, args, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if _, out2, _ := runCLIForTest(t, args, nil); out2 != out1 {
+		t.Error("same seed produced different output")
+	}
+	if _, out3, _ := runCLIForTest(t, []string{"gen", "--stacks", "500", "--depth", "12", "--threads", "6", "--seed", "8"}, nil); out3 == out1 {
+		t.Error("different seeds produced the same output")
+	}
+
+	sf, err := parseCollapsed(strings.NewReader(out1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(out1, "\n"); n != 500 {
+		t.Errorf("got %d lines, want 500", n)
+	}
+	threads := map[string]bool{}
+	for _, st := range sf.stacks {
+		threads[st.thread] = true
+		if len(st.frames) > 12 {
+			t.Errorf("stack of %d frames exceeds --depth 12", len(st.frames))
+		}
+	}
+	if len(threads) != 6 || !threads["http-nio-8080-exec-1"] || !threads["pool-1-thread-2"] {
+		t.Errorf("threads = %v", threads)
+	}
+	// Zipf: the hottest method holds far more than a uniform share.
+	if ranked := computeHot(sf, false); pctOf(ranked[0].selfCount, sf.totalSamples) < 5 {
+		t.Errorf("hottest method has only %.1f%% self", pctOf(ranked[0].selfCount, sf.totalSamples))
+	}
+
+	path := filepath.Join(t.TempDir(), "synth.collapsed")
+	code, stdout, stderr := runCLIForTest(t, append(args, "-o", path), nil)
+	if code != 0 || stdout != "" || !strings.Contains(stderr, "Generated 500 stacks") {
+		t.Fatalf("exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if data, _ := os.ReadFile(path); string(data) != out1 {
+		t.Error("-o output differs from stdout")
+	}
+
+	if code, _, stderr := runCLIForTest(t, []string{"gen", "--depth", "1"}, nil); code != exitUsage || !strings.Contains(stderr, "--depth") {
+		t.Errorf("--depth 1: exit %d, stderr %q", code, stderr)
+	}
+}
+
+func TestParseLimitsCLI(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "deep.txt")
+	os.WriteFile(path, []byte("[main];Main.run;Deep.call;VeryLongMethodName.run 3\n[main];Main.run 1\n"), 0o644)
+
+	code, stdout, stderr := runCLIForTest(t, []string{"tree", path, "--max-frames", "2", "--max-symbol-bytes", "12"}, nil)
+	if code != 0 {
+		t.Fatalf("exit %d, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stderr, "warning: 1 stacks deeper than 2 frames kept only their leaf-most frames") {
+		t.Errorf("missing --max-frames warning:\n%s", stderr)
+	}
+	if strings.Contains(stdout, "Main.run  ← self=0") || !strings.Contains(stdout, "Deep.call") || !strings.Contains(stdout, "VeryLongMeth…") {
+		t.Errorf("stacks not cut:\n%s", stdout)
+	}
+
+	// JFR stacks past --max-frames count as truncated.
+	_, _, stderr = runCLIForTest(t, []string{"hot", jfrFixture("cpu.jfr"), "--max-frames", "3"}, nil)
+	if !strings.Contains(stderr, "truncated") {
+		t.Errorf("--max-frames on JFR: no truncation warning:\n%s", stderr)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"hot", jfrFixture("cpu.jfr"), "--max-events", "10"}, nil)
+	if code != exitParse || !strings.Contains(stderr, "raise --max-events") {
+		t.Errorf("--max-events 10: exit %d, stderr:\n%s", code, stderr)
+	}
+	code, _, stderr = runCLIForTest(t, []string{"hot", path, "--max-frames", "0"}, nil)
+	if code != exitUsage || !strings.Contains(stderr, "must be positive") {
+		t.Errorf("--max-frames 0: exit %d, stderr:\n%s", code, stderr)
+	}
+
+	// A metadata string table claiming ~4G entries must fail up front
+	// rather than make the parser allocate for them.
+	meta := []byte{0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0x0f}
+	meta[0] = byte(len(meta))
+	chunk := make([]byte, jfrChunkHeaderSize, jfrChunkHeaderSize+len(meta))
+	binary.BigEndian.PutUint32(chunk[0:], jfrChunkMagic)
+	binary.BigEndian.PutUint32(chunk[4:], 0x20000)
+	binary.BigEndian.PutUint64(chunk[8:], uint64(jfrChunkHeaderSize+len(meta)))
+	binary.BigEndian.PutUint64(chunk[16:], jfrChunkHeaderSize)
+	binary.BigEndian.PutUint64(chunk[24:], jfrChunkHeaderSize)
+	chunk = append(chunk, meta...)
+	evil := filepath.Join(dir, "evil.jfr")
+	os.WriteFile(evil, chunk, 0o644)
+	code, _, stderr = runCLIForTest(t, []string{"hot", evil}, nil)
+	if code != exitParse || !strings.Contains(stderr, "metadata: count 4294967295 exceeds") {
+		t.Errorf("huge metadata count: exit %d, stderr:\n%s", code, stderr)
+	}
+}
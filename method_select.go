@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// methodFlags holds -m and its disambiguation flags for commands that
+// target a method.
+type methodFlags struct {
+	method     string
+	exact      bool
+	ignoreCase bool
+	index      int
+	pick       bool
+}
+
+func (f *methodFlags) register(cmd *cobra.Command, usage string) {
+	cmd.Flags().StringVarP(&f.method, "method", "m", "", usage)
+	f.registerModes(cmd)
+	cmd.Flags().IntVar(&f.index, "index", 0, "When -m matches several methods, select the Nth (as listed by 'methods')")
+	cmd.Flags().BoolVar(&f.pick, "pick", false, "When -m matches several methods, prompt for one")
+}
+
+// registerModes registers only the matching-mode flags, for commands that
+// take the pattern from elsewhere.
+func (f *methodFlags) registerModes(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&f.exact, "exact", false, "Match the whole method name (Class.method or fully-qualified) instead of a substring")
+	cmd.Flags().BoolVar(&f.ignoreCase, "ignore-case", false, "Case-insensitive method matching")
+}
+
+func (f *methodFlags) matcher() methodMatcher {
+	return methodMatcher{pattern: f.method, exact: f.exact, ignoreCase: f.ignoreCase}
+}
+
+// validate checks flag combinations that can be rejected before parsing.
+func (f *methodFlags) validate() error {
+	if f.index < 0 {
+		return fmt.Errorf("--index must be positive (got %d)", f.index)
+	}
+	if f.index > 0 && f.pick {
+		return fmt.Errorf("--index and --pick are mutually exclusive")
+	}
+	if (f.index > 0 || f.pick) && f.method == "" {
+		if f.pick {
+			return fmt.Errorf("--pick requires -m/--method")
+		}
+		return fmt.Errorf("--index requires -m/--method")
+	}
+	return nil
+}
+
+// resolve returns the matcher for -m. Without --index/--pick it merges
+// every matching method. With either flag and several candidates, one
+// method is selected and matched exactly.
+func (f *methodFlags) resolve(sf *stackFile, path string) (methodMatcher, error) {
+	m := f.matcher()
+	if f.index == 0 && !f.pick {
+		return m, nil
+	}
+	candidates := computeMethods(sf, m)
+	if len(candidates) == 0 {
+		// Let the command print its no-match message and suggestions.
+		return m, nil
+	}
+
+	idx := f.index
+	switch {
+	case f.pick && len(candidates) == 1:
+		idx = 1
+	case f.pick:
+		if path == "-" {
+			return m, fmt.Errorf("--pick cannot read a selection when the profile is read from stdin; use --index N")
+		}
+		if !isTerminal(os.Stdin) {
+			return m, fmt.Errorf("--pick requires an interactive terminal; use --index N")
+		}
+		var err error
+		idx, err = promptMethodPick(os.Stdin, os.Stderr, f.method, candidates, sf.totalSamples)
+		if err != nil {
+			return m, err
+		}
+	case idx > len(candidates):
+		var buf strings.Builder
+		writeMethodCandidates(&buf, f.method, candidates, sf.totalSamples)
+		return m, fmt.Errorf("--index %d out of range\n%s", idx, strings.TrimRight(buf.String(), "\n"))
+	}
+
+	chosen := candidates[idx-1].name
+	if len(candidates) > 1 {
+		fmt.Fprintf(os.Stderr, "Method: %s (%d of %d matching '%s')\n", chosen, idx, len(candidates), f.method)
+	}
+	return methodMatcher{pattern: chosen, exact: true}, nil
+}
+
+func writeMethodCandidates(w io.Writer, pattern string, candidates []hotEntry, totalSamples int) {
+	fmt.Fprintf(w, "'%s' matches %d methods:\n", pattern, len(candidates))
+	for i, c := range candidates {
+		fmt.Fprintf(w, "  %2d) %-60s total=%.*f%% self=%.*f%%\n", i+1, c.name,
+			pctDigits, pctOf(c.totalCount, totalSamples), pctDigits, pctOf(c.selfCount, totalSamples))
+	}
+}
+
+// promptMethodPick lists candidates on w and reads a 1-based selection from r.
+func promptMethodPick(r io.Reader, w io.Writer, pattern string, candidates []hotEntry, totalSamples int) (int, error) {
+	writeMethodCandidates(w, pattern, candidates, totalSamples)
+	fmt.Fprintf(w, "Select [1-%d]: ", len(candidates))
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return 0, fmt.Errorf("no method selected")
+	}
+	answer := strings.TrimSpace(scanner.Text())
+	n, err := strconv.Atoi(answer)
+	if err != nil || n < 1 || n > len(candidates) {
+		return 0, fmt.Errorf("invalid selection %q (expected 1-%d)", answer, len(candidates))
+	}
+	return n, nil
+}
+
+// isTerminal reports whether f is a character device, i.e. an interactive
+// terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
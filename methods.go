@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newMethodsCmd() *cobra.Command {
+	var shared sharedFlags
+	var mf methodFlags
+	var top int
+	var ids bool
+	cmd := &cobra.Command{
+		Use:   "methods <file> [PATTERN]",
+		Short: "List distinct methods matching a pattern with self/total samples",
+		Example: strings.Join([]string{
+			"  ap-query methods profile.jfr HashMap",
+			"  ap-query methods profile.jfr process --top 20",
+		}, "\n"),
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 2 {
+				mf.method = args[1]
+			}
+			pctx, err := preprocessProfile(shared.toOpts(args[0], "methods"))
+			if err != nil {
+				return err
+			}
+			cmdMethods(pctx.sf, mf.matcher(), top, ids)
+			return nil
+		},
+	}
+	shared.register(cmd)
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	shared.registerGroupBy(cmd)
+	mf.registerModes(cmd)
+	cmd.Flags().IntVar(&top, "top", 0, "Limit output rows (default: unlimited)")
+	cmd.Flags().BoolVar(&ids, "ids", false, "Prefix rows with a stable method ID (hash of the fully-qualified name)")
+	return cmd
+}
+
+// computeMethods returns every distinct fully-qualified method selected by
+// m, sorted by total samples descending with ties broken by name. An empty
+// pattern matches all methods.
+func computeMethods(sf *stackFile, m methodMatcher) []hotEntry {
+	var matched []hotEntry
+	for _, e := range computeHot(sf, true) {
+		if m.pattern == "" || m.matches(e.name) {
+			matched = append(matched, e)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].totalCount != matched[j].totalCount {
+			return matched[i].totalCount > matched[j].totalCount
+		}
+		return matched[i].name < matched[j].name
+	})
+	return matched
+}
+
+func cmdMethods(sf *stackFile, m methodMatcher, top int, ids bool) {
+	if sf.totalSamples == 0 {
+		fmt.Fprintln(os.Stdout, "no samples (empty profile or all filtered out)")
+		return
+	}
+	matched := computeMethods(sf, m)
+	if len(matched) == 0 {
+		noMatchMessage(os.Stdout, sf, m.pattern)
+		return
+	}
+
+	shown := matched[:truncate(len(matched), top)]
+	idCol := func(name string) string {
+		if !ids {
+			return ""
+		}
+		return fmt.Sprintf("%-12s ", name)
+	}
+	fmt.Printf("%s%-60s %7s %7s %9s %9s\n", idCol("ID"), "METHOD", "SELF%", "TOTAL%", "SELF", "TOTAL")
+	for _, e := range shown {
+		sp := pctOf(e.selfCount, sf.totalSamples)
+		tp := pctOf(e.totalCount, sf.totalSamples)
+		fmt.Printf("%s%-60s %6.*f%% %6.*f%% %9d %9d\n", idCol(methodID(e.name)), e.name, pctDigits, sp, pctDigits, tp, e.selfCount, e.totalCount)
+	}
+	if len(shown) < len(matched) {
+		fmt.Printf("(%d of %d methods shown)\n", len(shown), len(matched))
+	}
+}
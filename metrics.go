@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newMetricsCmd() *cobra.Command {
+	var shared sharedFlags
+	var top int
+	var topThreads int
+	var group bool
+	var labels []string
+	cmd := &cobra.Command{
+		Use:   "metrics <file>",
+		Short: "Print top methods and thread shares as Prometheus gauges",
+		Long: `Print the profile summary in the Prometheus text exposition format: total
+samples, self% and total% of the top methods, and the share of each thread.
+Write it where the node_exporter textfile collector (or any scraper of
+.prom files) picks it up, so alerting can compare recurring recordings.
+
+Methods are labeled by fully-qualified name and by the stable id that hot
+--ids prints. Add constant labels such as service or env with --label.`,
+		Example: strings.Join([]string{
+			"  ap-query metrics profile.jfr --label service=checkout > /var/lib/node_exporter/textfile/checkout.prom",
+			"  ap-query metrics profile.jfr --event wall --group --thread-normalize forkjoin",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			constLabels, err := parseMetricLabels(labels)
+			if err != nil {
+				return err
+			}
+			pctx, err := preprocessProfile(shared.toOpts(args[0], "metrics"))
+			if err != nil {
+				return err
+			}
+			event := pctx.eventType
+			if !pctx.hasMetadata {
+				event = ""
+			}
+			writeMetrics(os.Stdout, pctx.sf, event, constLabels, top, topThreads, group)
+			return nil
+		},
+	}
+	shared.register(cmd)
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	shared.registerGroupBy(cmd)
+	shared.registerThreadNormalize(cmd)
+	cmd.Flags().IntVar(&top, "top", 20, "Methods exported (0=all)")
+	cmd.Flags().IntVar(&topThreads, "top-threads", 20, "Threads exported (0=all)")
+	cmd.Flags().BoolVar(&group, "group", false, "Export thread groups (pools) instead of individual threads")
+	cmd.Flags().StringArrayVar(&labels, "label", nil, "Constant label NAME=VALUE added to every series; repeatable")
+	return cmd
+}
+
+var metricLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// metricLabel is one name="value" pair.
+type metricLabel struct{ name, value string }
+
+// parseMetricLabels parses NAME=VALUE pairs, rejecting names Prometheus
+// does not accept and the ones writeMetrics sets itself.
+func parseMetricLabels(raw []string) ([]metricLabel, error) {
+	var out []metricLabel
+	for _, r := range raw {
+		name, value, ok := strings.Cut(r, "=")
+		if !ok || !metricLabelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid --label %q (want NAME=VALUE, NAME matching [a-zA-Z_][a-zA-Z0-9_]*)", r)
+		}
+		switch name {
+		case "event", "method", "id", "thread":
+			return nil, fmt.Errorf("--label %s is set by metrics itself", name)
+		}
+		out = append(out, metricLabel{name, value})
+	}
+	return out, nil
+}
+
+// formatMetricLabels renders {a="x",b="y"}, escaping values as the
+// exposition format requires.
+func formatMetricLabels(labels []metricLabel) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	esc := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		parts[i] = l.name + `="` + esc.Replace(l.value) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func writeMetrics(w io.Writer, sf *stackFile, event string, constLabels []metricLabel, top, topThreads int, group bool) {
+	base := constLabels
+	if event != "" {
+		base = append([]metricLabel{{"event", event}}, constLabels...)
+	}
+	with := func(extra ...metricLabel) string {
+		return formatMetricLabels(append(append([]metricLabel(nil), base...), extra...))
+	}
+	header := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	header("ap_query_samples", "Samples in the profile after filters.")
+	fmt.Fprintf(w, "ap_query_samples%s %d\n", with(), sf.totalSamples)
+
+	ranked := computeHot(sf, true)
+	if top > 0 && len(ranked) > top {
+		ranked = ranked[:top]
+	}
+	if len(ranked) > 0 {
+		header("ap_query_method_self_percent", "Share of samples with the method as the leaf frame.")
+		for _, e := range ranked {
+			fmt.Fprintf(w, "ap_query_method_self_percent%s %.2f\n",
+				with(metricLabel{"method", e.name}, metricLabel{"id", methodID(e.name)}), pctOf(e.selfCount, sf.totalSamples))
+		}
+		header("ap_query_method_total_percent", "Share of samples with the method anywhere on the stack.")
+		for _, e := range ranked {
+			fmt.Fprintf(w, "ap_query_method_total_percent%s %.2f\n",
+				with(metricLabel{"method", e.name}, metricLabel{"id", methodID(e.name)}), pctOf(e.totalCount, sf.totalSamples))
+		}
+	}
+
+	threads, _, hasThread := computeThreads(sf)
+	if !hasThread {
+		return
+	}
+	if group {
+		groups := groupThreads(threads)
+		threads = threads[:0]
+		for _, g := range groups {
+			threads = append(threads, threadEntry{g.name, g.samples})
+		}
+		sort.SliceStable(threads, func(i, j int) bool { return threads[i].samples > threads[j].samples })
+	}
+	if topThreads > 0 && len(threads) > topThreads {
+		threads = threads[:topThreads]
+	}
+	header("ap_query_thread_percent", "Share of samples on the thread.")
+	for _, t := range threads {
+		fmt.Fprintf(w, "ap_query_thread_percent%s %.2f\n", with(metricLabel{"thread", t.name}), pctOf(t.samples, sf.totalSamples))
+	}
+}
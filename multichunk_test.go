@@ -121,3 +121,31 @@ func TestJFRMultiChunkAlternatingPhasesPresent(t *testing.T) {
 		t.Fatalf("expected both phases to contribute >=10%%, got phaseA=%.1f%% phaseB=%.1f%%", aPct, bPct)
 	}
 }
+
+func TestChunkStartOffsetsMultiChunk(t *testing.T) {
+	path := jfrFixture("multichunk.jfr")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := countJFRChunks(path)
+	if err != nil {
+		t.Fatalf("countJFRChunks: %v", err)
+	}
+	offsets := chunkStartOffsets(data)
+	if len(offsets) != chunks {
+		t.Fatalf("got %d chunk offsets, want %d", len(offsets), chunks)
+	}
+	seenZero := false
+	for _, off := range offsets {
+		if off == 0 {
+			seenZero = true
+		}
+		if off < 0 || off >= int64(len(data)) {
+			t.Errorf("offset %d out of range [0, %d)", off, len(data))
+		}
+	}
+	if !seenZero {
+		t.Error("expected first chunk at offset 0")
+	}
+}
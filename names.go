@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"regexp"
@@ -9,6 +11,10 @@ import (
 )
 
 func shortName(frame string) string {
+	if pkg, ok := strings.CutSuffix(frame, packageKeySuffix); ok {
+		// --group-by package keys are shown in full: "java/util/*" → "java.util.*"
+		return strings.ReplaceAll(pkg, "/", ".") + ".*"
+	}
 	base := strings.ReplaceAll(frame, "/", ".")
 
 	// Native frames from shared libraries: "libc.so.6.__sched_yield" → "__sched_yield"
@@ -45,19 +51,106 @@ func shortName(frame string) string {
 	return base
 }
 
+// methodID returns a stable identifier for a method: the first 12 hex digits
+// of the SHA-256 of its dotted fully-qualified name. It does not depend on
+// the profile, so IDs join across runs and with external data.
+func methodID(name string) string {
+	sum := sha256.Sum256([]byte(strings.ReplaceAll(name, "/", ".")))
+	return hex.EncodeToString(sum[:6])
+}
+
 func displayName(frame string, fqn bool) string {
-	if fqn {
+	if strings.HasPrefix(frame, ownerFramePrefix) {
+		// Team handles and author names are not Java names: "@org/web".
+		return frame
+	}
+	if fqn && !strings.HasSuffix(frame, packageKeySuffix) {
 		return strings.ReplaceAll(frame, "/", ".")
 	}
 	return shortName(frame)
 }
 
+// frameNamer interns display names. Each distinct raw frame is converted
+// with displayName once, and each distinct display name gets a dense ID so
+// callers can aggregate into slices instead of string-keyed maps. Several raw
+// frames can share an ID (e.g. overloads, or packages under short names).
+type frameNamer struct {
+	fqn   bool
+	ids   map[string]int32 // raw frame → display-name ID
+	byKey map[string]int32 // display name → ID
+	names []string         // ID → display name
+}
+
+func newFrameNamer(fqn bool) *frameNamer {
+	return &frameNamer{
+		fqn:   fqn,
+		ids:   make(map[string]int32),
+		byKey: make(map[string]int32),
+	}
+}
+
+func (n *frameNamer) id(frame string) int32 {
+	if id, ok := n.ids[frame]; ok {
+		return id
+	}
+	name := displayName(frame, n.fqn)
+	id, ok := n.byKey[name]
+	if !ok {
+		id = int32(len(n.names))
+		n.names = append(n.names, name)
+		n.byKey[name] = id
+	}
+	n.ids[frame] = id
+	return id
+}
+
+func (n *frameNamer) name(id int32) string {
+	return n.names[id]
+}
+
+func (n *frameNamer) len() int {
+	return len(n.names)
+}
+
 func matchesMethod(frame, pattern string) bool {
 	normalized := strings.ReplaceAll(frame, "/", ".")
 	pattern = strings.ReplaceAll(pattern, "/", ".")
 	return strings.Contains(normalized, pattern) || strings.Contains(shortName(frame), pattern)
 }
 
+// methodMatcher selects the frames targeted by -m. The default is the
+// substring match of matchesMethod; exact requires the fully-qualified or
+// short Class.method name to equal the pattern, and ignoreCase folds case
+// in either mode.
+type methodMatcher struct {
+	pattern    string
+	exact      bool
+	ignoreCase bool
+}
+
+// substringMatcher returns the default -m matcher for pattern.
+func substringMatcher(pattern string) methodMatcher {
+	return methodMatcher{pattern: pattern}
+}
+
+func (m methodMatcher) matches(frame string) bool {
+	if !m.exact && !m.ignoreCase {
+		return matchesMethod(frame, m.pattern)
+	}
+	pattern := strings.ReplaceAll(m.pattern, "/", ".")
+	normalized := strings.ReplaceAll(frame, "/", ".")
+	short := shortName(frame)
+	if m.ignoreCase {
+		pattern = strings.ToLower(pattern)
+		normalized = strings.ToLower(normalized)
+		short = strings.ToLower(short)
+	}
+	if m.exact {
+		return normalized == pattern || short == pattern
+	}
+	return strings.Contains(normalized, pattern) || strings.Contains(short, pattern)
+}
+
 func matchesHide(frame string, re *regexp.Regexp) bool {
 	normalized := strings.ReplaceAll(frame, "/", ".")
 	return re.MatchString(normalized) || re.MatchString(shortName(frame))
@@ -77,8 +170,32 @@ var idleNativePatterns = []string{
 	"__futex",
 	"__sched_yield",
 	"epoll_wait",
+	"epoll_pwait",
 	"pthread_cond_wait",
 	"pthread_cond_timedwait",
+	"clock_nanosleep",
+	"__clock_nanosleep",
+	"__nanosleep",
+}
+
+// idleWrapperPrefixes match the native frames a blocking call passes
+// through below its idle frame (glibc's cancellable syscall path), so
+// "__futex_abstimed_wait_cancelable64;__internal_syscall_cancel;
+// __syscall_cancel_arch_end" is idle although its leaf is not.
+var idleWrapperPrefixes = []string{
+	"__syscall_cancel",
+	"__internal_syscall_cancel",
+}
+
+// isIdleStack reports whether a root-first stack is idle: its leaf, or the
+// first frame above the leaf's syscall wrappers, is an idle frame.
+func isIdleStack(frames []string) bool {
+	for i := len(frames) - 1; i >= 0; i-- {
+		if !hasAnyPrefix(shortName(frames[i]), idleWrapperPrefixes) {
+			return isIdleLeaf(frames[i])
+		}
+	}
+	return false
 }
 
 func isIdleLeaf(frame string) bool {
@@ -112,6 +229,10 @@ func truncate(n, top int) int {
 	return n
 }
 
+// pctDigits is the number of decimals in printed percentages (--precision).
+// fmt ignores the locale, so the separator is always '.'.
+var pctDigits = 1
+
 func pctOf(n, total int) float64 {
 	if total == 0 {
 		return 0
@@ -126,6 +247,24 @@ func threadPrefix(thread string) string {
 	return fmt.Sprintf("[%s];", thread)
 }
 
+// threadMarkerPrefix is threadPrefix keeping a collapsed-input tid, so
+// "[name tid=N]" markers survive a collapse round trip.
+func threadMarkerPrefix(thread, tid string) string {
+	if tid == "" || thread == "tid="+tid {
+		return threadPrefix(thread)
+	}
+	return fmt.Sprintf("[%s tid=%s];", thread, tid)
+}
+
+// matchesThread reports whether a -t/--thread pattern selects a thread: a
+// substring of its name, or its tid given as "N" or "tid=N".
+func matchesThread(name, tid, pattern string) bool {
+	if strings.Contains(name, pattern) {
+		return true
+	}
+	return tid != "" && (pattern == tid || pattern == "tid="+tid)
+}
+
 // uniqueFrames returns deduplicated raw frames and whether any contains '$'.
 func uniqueFrames(sf *stackFile) (frames []string, hasDollar bool) {
 	seen := make(map[string]bool)
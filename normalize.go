@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// threadNormalizer rewrites thread names so the members of a pool
+// aggregate under one name. Rules apply in order, each to the result of
+// the previous one.
+type threadNormalizer struct {
+	rules []func(string) string
+}
+
+var (
+	trailingDigitsRe = regexp.MustCompile(`[-_#\s]*\d+$`)
+	dashNumberRe     = regexp.MustCompile(`(-\d+)+$`)
+	forkJoinRe       = regexp.MustCompile(`^ForkJoinPool(-\d+|\.commonPool)-worker-\d+$`)
+)
+
+// builtinThreadRules are the named --thread-normalize rules.
+var builtinThreadRules = map[string]func(string) string{
+	// "CompilerThread0" → "CompilerThread", "GC Thread#54" → "GC Thread"
+	"digits": func(name string) string { return trailingDigitsRe.ReplaceAllString(name, "") },
+	// "pool-1-thread-2" → "pool-1-thread", "worker-3-7" → "worker"
+	"suffix": func(name string) string { return dashNumberRe.ReplaceAllString(name, "") },
+	// "ForkJoinPool-1-worker-3" → "ForkJoinPool-worker",
+	// "ForkJoinPool.commonPool-worker-7" → "ForkJoinPool.commonPool-worker"
+	"forkjoin": func(name string) string {
+		m := forkJoinRe.FindStringSubmatch(name)
+		if m == nil {
+			return name
+		}
+		if m[1] == ".commonPool" {
+			return "ForkJoinPool.commonPool-worker"
+		}
+		return "ForkJoinPool-worker"
+	},
+}
+
+// newThreadNormalizer compiles --thread-normalize rules: a built-in rule
+// name (digits, suffix, forkjoin) or REGEX=REPLACEMENT, where the
+// replacement may use $1-style group references. No rules returns nil.
+func newThreadNormalizer(specs []string) (*threadNormalizer, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	n := &threadNormalizer{}
+	for _, spec := range specs {
+		if rule, ok := builtinThreadRules[spec]; ok {
+			n.rules = append(n.rules, rule)
+			continue
+		}
+		i := strings.LastIndexByte(spec, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("--thread-normalize: unknown rule %q (built-in: digits, suffix, forkjoin; or REGEX=REPLACEMENT)", spec)
+		}
+		re, err := regexp.Compile(spec[:i])
+		if err != nil {
+			return nil, fmt.Errorf("--thread-normalize: invalid regex %q: %v", spec[:i], err)
+		}
+		repl := spec[i+1:]
+		n.rules = append(n.rules, func(name string) string { return re.ReplaceAllString(name, repl) })
+	}
+	return n, nil
+}
+
+// name returns the normalized thread name. Empty names stay empty so
+// stacks without thread info are not given one.
+func (n *threadNormalizer) name(thread string) string {
+	if n == nil || thread == "" {
+		return thread
+	}
+	for _, rule := range n.rules {
+		thread = rule(thread)
+	}
+	if thread == "" {
+		return "(unnamed)"
+	}
+	return thread
+}
+
+// apply returns a copy of sf with every thread name normalized. A nil
+// normalizer returns sf.
+func (n *threadNormalizer) apply(sf *stackFile) *stackFile {
+	if n == nil {
+		return sf
+	}
+	out := &stackFile{totalSamples: sf.totalSamples, stacks: make([]stack, len(sf.stacks))}
+	names := make(map[string]string)
+	for i, st := range sf.stacks {
+		nn, ok := names[st.thread]
+		if !ok {
+			nn = n.name(st.thread)
+			names[st.thread] = nn
+		}
+		st.thread = nn
+		out.stacks[i] = st
+	}
+	return out
+}
+
+// windowsModuleExts are the file extensions of Windows executable modules.
+var windowsModuleExts = []string{".dll", ".exe", ".sys"}
+
+// foldNativeCase lower-cases the module part of a Windows native frame:
+// "C:\Windows\SYSTEM32\ntdll.dll" or "KERNELBASE.dll!WaitForSingleObjectEx".
+// Windows paths are case-insensitive, so the same module can be reported
+// with different case; the symbol after "!" is case-sensitive and kept.
+// Other frames are returned as they are.
+func foldNativeCase(frame string) string {
+	module, symbol, hasSymbol := strings.Cut(frame, "!")
+	lower := strings.ToLower(module)
+	windows := len(lower) > 2 && lower[1] == ':' && lower[2] == '\\'
+	for _, ext := range windowsModuleExts {
+		windows = windows || strings.HasSuffix(lower, ext) || strings.Contains(lower, ext+"+0x")
+	}
+	if !windows || lower == module {
+		return frame
+	}
+	if hasSymbol {
+		return lower + "!" + symbol
+	}
+	return lower
+}
+
+// mapFrames returns a copy of sf with every frame rewritten by fn, and the
+// number of frames changed. Stacks that become identical are not merged;
+// every consumer aggregates by frame anyway.
+func (sf *stackFile) mapFrames(fn func(string) string) (*stackFile, int) {
+	out := &stackFile{totalSamples: sf.totalSamples, stacks: make([]stack, len(sf.stacks))}
+	mapped := make(map[string]string)
+	changed := 0
+	for i, st := range sf.stacks {
+		var frames []string
+		for j, fr := range st.frames {
+			f, ok := mapped[fr]
+			if !ok {
+				f = fn(fr)
+				mapped[fr] = f
+			}
+			if f == fr {
+				continue
+			}
+			if frames == nil {
+				frames = append([]string(nil), st.frames...)
+			}
+			frames[j] = f
+			changed++
+		}
+		if frames != nil {
+			st.frames = frames
+		}
+		out.stacks[i] = st
+	}
+	return out, changed
+}
+
+var (
+	lambdaClassRe    = regexp.MustCompile(`\$\$Lambda(\$\d+)?([./]0x[0-9a-fA-F]+)?`)
+	hiddenClassRe    = regexp.MustCompile(`[./]0x[0-9a-fA-F]{8,}`)
+	numberedSuffixRe = regexp.MustCompile(`(GeneratedMethodAccessor|GeneratedConstructorAccessor|GeneratedSerializationConstructorAccessor|\$Proxy)\d+`)
+)
+
+// canonicalSyntheticName strips the run-specific parts of JVM-generated
+// class names: lambda indices and hidden class addresses
+// (Foo$$Lambda$87.0x0000000800c0b6e8.run → Foo$$Lambda.run) and the
+// counters of reflection accessors and proxies (GeneratedMethodAccessor12,
+// $Proxy7). The same code then has the same name in every recording.
+func canonicalSyntheticName(frame string) string {
+	if !strings.Contains(frame, "$") && !strings.Contains(frame, "0x") && !strings.Contains(frame, "Generated") {
+		return frame
+	}
+	frame = lambdaClassRe.ReplaceAllString(frame, "$$$$Lambda")
+	frame = hiddenClassRe.ReplaceAllString(frame, "")
+	return numberedSuffixRe.ReplaceAllString(frame, "$1")
+}
+
+// foldTimedNativeCase applies foldNativeCase to per-sample events in place,
+// for commands such as timeline that read them instead of the stack file.
+// Frame slices are shared with the parse cache, so changed ones are copied.
+func foldTimedNativeCase(events []timedEvent) {
+	folded := make(map[string]string)
+	for i := range events {
+		e := &events[i]
+		var frames []string
+		for j, fr := range e.frames {
+			f, ok := folded[fr]
+			if !ok {
+				f = foldNativeCase(fr)
+				folded[fr] = f
+			}
+			if f == fr {
+				continue
+			}
+			if frames == nil {
+				frames = append([]string(nil), e.frames...)
+			}
+			frames[j] = f
+		}
+		if frames != nil {
+			e.frames = frames
+			e.stackKey = buildStackKeyWithLines(frames, e.lines)
+		}
+	}
+}
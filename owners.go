@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// groupByOwner rolls frames up by the owner of their source file (see
+// ownerIndex); it needs --source-root.
+const groupByOwner = "owner"
+
+// unownedFrame groups frames with no source file under --source-root or no
+// owner for it (the JDK, libraries, files no CODEOWNERS rule covers).
+const unownedFrame = ownerFramePrefix + "none]"
+
+// ownerFramePrefix starts every --group-by owner frame.
+const ownerFramePrefix = "[owner="
+
+// sourceExts are the JVM languages whose classes are looked up as files.
+var sourceExts = []string{".java", ".kt", ".scala", ".groovy"}
+
+// ownerIndex maps frames to the owners of their source files in a checkout.
+// Owners come from CODEOWNERS when the checkout has one (the last matching
+// rule wins, as on GitHub and GitLab), otherwise from git history: the
+// author with the most commits to the file.
+type ownerIndex struct {
+	root   string
+	files  map[string][]string // simple class name → slash paths under root
+	rules  []ownerRule         // nil = use git history
+	owners map[string]string   // memo: file → owner, "" = none
+
+	authors map[string]map[string]int // file → author → commits; nil until first read
+}
+
+type ownerRule struct {
+	re     *regexp.Regexp
+	owners string // space-separated, "" for a rule that removes ownership
+}
+
+// loadOwnerIndex indexes the source files under root and reads its
+// CODEOWNERS, if any.
+func loadOwnerIndex(root string) (*ownerIndex, error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("--source-root: %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("--source-root %s: not a directory", root)
+	}
+	idx := &ownerIndex{root: root, files: make(map[string][]string), owners: make(map[string]string)}
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && (strings.HasPrefix(d.Name(), ".") || d.Name() == "node_modules") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := path.Ext(d.Name())
+		for _, e := range sourceExts {
+			if ext == e {
+				rel, _ := filepath.Rel(root, p)
+				simple := strings.TrimSuffix(d.Name(), ext)
+				idx.files[simple] = append(idx.files[simple], filepath.ToSlash(rel))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("--source-root: %v", err)
+	}
+	// GitHub's lookup order, then GitLab's extra location.
+	for _, name := range []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS", ".gitlab/CODEOWNERS"} {
+		rules, err := readCodeowners(filepath.Join(root, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		idx.rules = rules
+		break
+	}
+	return idx, nil
+}
+
+// readCodeowners parses "PATTERN OWNER..." lines. GitLab [Section] headers
+// are skipped.
+func readCodeowners(file string) ([]ownerRule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules := []ownerRule{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") || strings.HasPrefix(line, "^[") {
+			continue
+		}
+		fields := strings.Fields(line)
+		rules = append(rules, ownerRule{re: codeownersRegexp(fields[0]), owners: strings.Join(fields[1:], " ")})
+	}
+	return rules, scanner.Err()
+}
+
+// codeownersRegexp turns a CODEOWNERS (gitignore-style) pattern into a
+// regexp over slash paths: a leading or inner / anchors it at the root, a
+// trailing / or a name ending without a glob also covers everything below,
+// * stays within one directory and ** spans any, so docs/* matches
+// docs/a.md but not docs/a/b.md.
+func codeownersRegexp(pattern string) *regexp.Regexp {
+	dir := strings.HasSuffix(pattern, "/")
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	var b strings.Builder
+	if anchored {
+		b.WriteString("^")
+	} else {
+		b.WriteString("(^|/)")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					b.WriteString("/?")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if dir || pattern == "" || !strings.ContainsAny(pattern[len(pattern)-1:], "*?") {
+		b.WriteString("(/|$)")
+	} else {
+		b.WriteString("$")
+	}
+	return regexp.MustCompile(b.String())
+}
+
+// sourcePath returns the file under the root that holds frame's class, or
+// "". Among files of the same name the one whose path ends in the package
+// directories wins.
+func (idx *ownerIndex) sourcePath(frame string) string {
+	file := sourceFileOf(frame, true)
+	if file == nativeFile {
+		return ""
+	}
+	pkgPath := strings.TrimSuffix(file, ".java")
+	simple := path.Base(pkgPath)
+	for _, rel := range idx.files[simple] {
+		noExt := strings.TrimSuffix(rel, path.Ext(rel))
+		if noExt == pkgPath || strings.HasSuffix(noExt, "/"+pkgPath) {
+			return rel
+		}
+	}
+	return ""
+}
+
+// owner returns the owner of frame's source file, or "".
+func (idx *ownerIndex) owner(frame string) string {
+	rel := idx.sourcePath(frame)
+	if rel == "" {
+		return ""
+	}
+	if o, ok := idx.owners[rel]; ok {
+		return o
+	}
+	o := ""
+	if idx.rules != nil {
+		for _, r := range idx.rules {
+			if r.re.MatchString(rel) {
+				o = r.owners
+			}
+		}
+	} else {
+		o = idx.topAuthor(rel)
+	}
+	idx.owners[rel] = o
+	return o
+}
+
+// topAuthor returns the author with the most commits to rel, or "" when
+// the root is not a git checkout or the file has no history.
+func (idx *ownerIndex) topAuthor(rel string) string {
+	if idx.authors == nil {
+		idx.authors = readCommitAuthors(idx.root)
+	}
+	commits := idx.authors[rel]
+	authors := make([]string, 0, len(commits))
+	for a := range commits {
+		authors = append(authors, a)
+	}
+	sort.Slice(authors, func(i, j int) bool {
+		if commits[authors[i]] != commits[authors[j]] {
+			return commits[authors[i]] > commits[authors[j]]
+		}
+		return authors[i] < authors[j]
+	})
+	if len(authors) == 0 {
+		return ""
+	}
+	return authors[0]
+}
+
+// readCommitAuthors counts the commits of each author per file under root
+// in one git log pass; paths are relative to root. It returns an empty map
+// when root is not a git checkout.
+func readCommitAuthors(root string) map[string]map[string]int {
+	counts := make(map[string]map[string]int)
+	out, err := exec.Command("git", "-C", root, "-c", "core.quotePath=false", "log", "--format=%x00%an", "--name-only", "--relative", "--", ".").Output()
+	if err != nil {
+		return counts
+	}
+	author := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(line, "\x00"):
+			author = line[1:]
+		case line != "" && author != "":
+			if counts[line] == nil {
+				counts[line] = make(map[string]int)
+			}
+			counts[line][author]++
+		}
+	}
+	return counts
+}
+
+// ownerFrame is the --group-by owner key of a frame. Native frames are
+// kept, like under --group-by package.
+func (idx *ownerIndex) ownerFrame(frame string) string {
+	if sourceFileOf(frame, false) == nativeFile {
+		return frame
+	}
+	if o := idx.owner(frame); o != "" {
+		return ownerFramePrefix + o + "]"
+	}
+	return unownedFrame
+}
+
+// groupByOwner rewrites every frame to its owner and merges consecutive
+// frames of one owner, so each stack reads as the teams it passes through.
+func (sf *stackFile) groupByOwner(idx *ownerIndex) *stackFile {
+	out, _ := sf.mapFrames(idx.ownerFrame)
+	return out.mergeRecursive()
+}
+
+// ownersByName returns the owner of each display name in sf, for an OWNER
+// column; a name whose frames have several owners lists them all.
+func (idx *ownerIndex) ownersByName(sf *stackFile, fqn bool) map[string]string {
+	sets := make(map[string][]string)
+	for _, fr := range sf.index().frames {
+		o := idx.owner(fr)
+		if o == "" {
+			continue
+		}
+		name := displayName(fr, fqn)
+		if !slices.Contains(sets[name], o) {
+			sets[name] = append(sets[name], o)
+		}
+	}
+	res := make(map[string]string, len(sets))
+	for name, owners := range sets {
+		sort.Strings(owners)
+		res[name] = strings.Join(owners, ", ")
+	}
+	return res
+}
+
+// ownerColumn is the OWNER cell of a row, "-" when nobody owns it.
+func ownerColumn(owner string) string {
+	if owner == "" {
+		return "-"
+	}
+	return owner
+}
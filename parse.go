@@ -9,8 +9,10 @@ import (
 	"io"
 	"os"
 	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/grafana/jfr-parser/parser"
@@ -27,11 +29,44 @@ type stack struct {
 	lines  []uint32 // parallel to frames, 0 = unknown
 	count  int
 	thread string // "" if unknown
+	tid    string // OS thread id from a collapsed "[name tid=N]" marker; "" if unknown
+
+	details []frameDetail // parallel to frames; nil unless requested (JFR only)
 }
 
 type stackFile struct {
 	stacks       []stack
 	totalSamples int
+	relative     bool // totalSamples was rebased to the -m samples by relativeTo
+
+	namers [2]*frameNamer // built lazily by namer; index 1 is the fqn namer
+	idx    *stackIndex    // built lazily by index
+}
+
+// namer returns the display-name interner for this stack file, building it
+// on first use so repeated analyses (e.g. info drill-down) share it.
+func (sf *stackFile) namer(fqn bool) *frameNamer {
+	i := 0
+	if fqn {
+		i = 1
+	}
+	if sf.namers[i] == nil {
+		sf.namers[i] = newFrameNamer(fqn)
+	}
+	return sf.namers[i]
+}
+
+// addStack appends a stack of count samples with copies of frames and
+// lines; nil lines means none are known.
+func (sf *stackFile) addStack(thread string, frames []string, lines []uint32, count int) {
+	st := stack{frames: append([]string(nil), frames...), count: count, thread: thread}
+	if lines != nil {
+		st.lines = append([]uint32(nil), lines...)
+	} else {
+		st.lines = make([]uint32, len(frames))
+	}
+	sf.stacks = append(sf.stacks, st)
+	sf.totalSamples += count
 }
 
 func (sf *stackFile) filterByThread(thread string) *stackFile {
@@ -40,7 +75,7 @@ func (sf *stackFile) filterByThread(thread string) *stackFile {
 	}
 	out := &stackFile{}
 	for i := range sf.stacks {
-		if strings.Contains(sf.stacks[i].thread, thread) {
+		if matchesThread(sf.stacks[i].thread, sf.stacks[i].tid, thread) {
 			out.stacks = append(out.stacks, sf.stacks[i])
 			out.totalSamples += sf.stacks[i].count
 		}
@@ -52,7 +87,7 @@ func (sf *stackFile) filterIdle() *stackFile {
 	out := &stackFile{}
 	for i := range sf.stacks {
 		st := &sf.stacks[i]
-		if len(st.frames) > 0 && isIdleLeaf(st.frames[len(st.frames)-1]) {
+		if isIdleStack(st.frames) {
 			continue
 		}
 		out.stacks = append(out.stacks, *st)
@@ -67,20 +102,62 @@ func (sf *stackFile) hideFrames(re *regexp.Regexp) *stackFile {
 		st := &sf.stacks[i]
 		var frames []string
 		var lines []uint32
+		var details []frameDetail
 		for j, fr := range st.frames {
 			if !matchesHide(fr, re) {
 				frames = append(frames, fr)
 				lines = append(lines, st.lines[j])
+				if st.details != nil {
+					details = append(details, st.details[j])
+				}
 			}
 		}
 		if len(frames) == 0 {
 			continue
 		}
 		out.stacks = append(out.stacks, stack{
-			frames: frames,
-			lines:  lines,
-			count:  st.count,
-			thread: st.thread,
+			frames:  frames,
+			lines:   lines,
+			count:   st.count,
+			thread:  st.thread,
+			tid:     st.tid,
+			details: details,
+		})
+	}
+	return out
+}
+
+// mergeRecursive collapses runs of consecutive identical frames (direct
+// recursion) into one frame, keeping the innermost frame's line and detail
+// so it still points at the call into the rest of the stack.
+func (sf *stackFile) mergeRecursive() *stackFile {
+	out := &stackFile{totalSamples: sf.totalSamples}
+	for i := range sf.stacks {
+		st := &sf.stacks[i]
+		var frames []string
+		var lines []uint32
+		var details []frameDetail
+		for j, fr := range st.frames {
+			if n := len(frames); n > 0 && frames[n-1] == fr {
+				lines[n-1] = st.lines[j]
+				if st.details != nil {
+					details[n-1] = st.details[j]
+				}
+				continue
+			}
+			frames = append(frames, fr)
+			lines = append(lines, st.lines[j])
+			if st.details != nil {
+				details = append(details, st.details[j])
+			}
+		}
+		out.stacks = append(out.stacks, stack{
+			frames:  frames,
+			lines:   lines,
+			count:   st.count,
+			thread:  st.thread,
+			tid:     st.tid,
+			details: details,
 		})
 	}
 	return out
@@ -125,9 +202,9 @@ func resolveFrame(p *parser.Parser, sf types.StackFrame) string {
 	}
 	methodName := p.GetSymbolString(method.Name)
 	if className == "" {
-		return methodName
+		return limitSymbol(methodName)
 	}
-	return className + "." + methodName
+	return limitSymbol(className + "." + methodName)
 }
 
 func resolveThread(p *parser.Parser, ref types.ThreadRef) string {
@@ -146,7 +223,21 @@ func resolveThread(p *parser.Parser, ref types.ThreadRef) string {
 // JFR → stackFile
 // ---------------------------------------------------------------------------
 
+// readJFRBytes reads a JFR file, warning once per file when its chunk
+// headers are cut off.
 func readJFRBytes(path string) ([]byte, error) {
+	buf, err := readJFRFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, at, err := walkChunkHeaders(buf); err == nil && at >= 0 && !truncatedScanWarned[path] {
+		truncatedScanWarned[path] = true
+		fmt.Fprintf(os.Stderr, "warning: %s: truncated chunk header scan at offset %d; timeline span may be incomplete\n", path, at)
+	}
+	return buf, nil
+}
+
+func readJFRFile(path string) ([]byte, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -156,15 +247,15 @@ func readJFRBytes(path string) ([]byte, error) {
 	if strings.HasSuffix(path, ".gz") {
 		gr, err := gzip.NewReader(f)
 		if err != nil {
-			return nil, fmt.Errorf("gzip: %w", err)
+			return nil, readError(fmt.Errorf("gzip: %w", err))
 		}
 		data, readErr := io.ReadAll(gr)
 		closeErr := gr.Close()
 		if readErr != nil {
-			return nil, readErr
+			return nil, readError(readErr)
 		}
 		if closeErr != nil {
-			return nil, closeErr
+			return nil, readError(closeErr)
 		}
 		return data, nil
 	}
@@ -191,25 +282,40 @@ type stackKey struct {
 
 // aggValue holds the frame/line data for an aggregated stack key.
 type aggValue struct {
-	frames []string
-	lines  []uint32
-	count  int
+	frames  []string
+	lines   []uint32
+	details []frameDetail
+	count   int
+
+	// Used only by a bounded stackAgg.
+	key     stackKey
+	heapIdx int
 }
 
 type timedEvent struct {
-	offsetNanos int64    // nanoseconds since recording start (originNanos)
-	stackKey    string   // prebuilt key from cachedStackTrace
-	frames      []string // resolved frame names (shared with cache)
-	lines       []uint32 // resolved line numbers (shared with cache)
-	thread      string   // resolved thread name
-	weight      int      // sample count (>1 for wall batch samples)
+	offsetNanos int64         // nanoseconds since recording start (originNanos)
+	stackKey    string        // prebuilt key from cachedStackTrace
+	frames      []string      // resolved frame names (shared with cache)
+	lines       []uint32      // resolved line numbers (shared with cache)
+	details     []frameDetail // resolved frame details (shared with cache); nil unless requested
+	thread      string        // resolved thread name
+	tid         string        // OS thread id, as in stack.tid; "" if unknown
+	weight      int           // sample count (>1 for wall batch samples)
+	durNanos    int64         // event duration (lock contention); 0 for sampled events
 }
 
 type parseOpts struct {
 	collectTimestamps bool
-	fromNanos         int64 // -1 = no filter
-	toNanos           int64 // -1 = no filter
-	warnLargeCount    bool  // when true, warn if >10M events
+	fromNanos         int64  // -1 = no filter
+	toNanos           int64  // -1 = no filter
+	warnLargeCount    bool   // when true, warn if >10M events
+	maxStacks         int    // >0 = keep at most this many distinct stacks per event (approximate)
+	progress          bool   // show a stderr progress line for large files on a terminal
+	frameDetails      bool   // decode per-frame bytecode index and frame type
+	minDurNanos       int64  // >0 = drop lock events shorter than this
+	contextFilter     bool   // keep only samples tagged with contextID
+	contextID         uint64 // with contextFilter; 0 = untagged samples
+	contextFrames     bool   // add a "[context=ID]" root frame to every stack
 }
 
 type parsedProfile struct {
@@ -219,14 +325,35 @@ type parsedProfile struct {
 	originNanos   int64                   // first chunk's StartNanos
 	spanNanos     int64                   // total recording span from chunk header scan
 	execEventName string                  // resolved name for ExecutionSample (e.g. "cpu", "branch-misses")
+	cpuInterval   int64                   // ns of CPU time per unit of cpu weight; 0 = unknown
+	settings      map[string]string       // async-profiler settings by name; nil when none recorded
+	// truncated is the weight of collected samples whose stack the JFR
+	// recording marks as truncated, per event; nil for other formats.
+	truncated map[string]int
+	// approxWallPeriod is the jdk.ExecutionSample period of a plain JDK
+	// recording whose wall samples were approximated from execution samples
+	// and blocking events; 0 when wall samples are real or absent.
+	approxWallPeriod int64
+	// contextTagged is the weight of samples carrying a context id; only
+	// counted when context ids were asked for.
+	contextTagged int
 }
 
+// defaultCPUInterval is async-profiler's cpu sampling interval when the
+// recording was started without one (interval=0).
+const defaultCPUInterval = 10_000_000 // 10ms
+
 // cachedStackTrace stores a resolved stacktrace in root->leaf order plus
-// the prebuilt aggregation key including line numbers.
+// the prebuilt aggregation key including line numbers (and frame details,
+// when decoded).
 type cachedStackTrace struct {
-	frames []string
-	lines  []uint32
-	key    string
+	frames  []string
+	lines   []uint32
+	details []frameDetail
+	key     string
+	// truncated is the JFR flag set when the profiler cut the stack off at
+	// its depth limit.
+	truncated bool
 }
 
 func digitsUint32(n uint32) int {
@@ -293,7 +420,9 @@ func buildStackKeyWithLines(frames []string, lines []uint32) string {
 	return b.String()
 }
 
-func resolveStackTraceCached(p *parser.Parser, cache map[types.StackTraceRef]*cachedStackTrace, stRef types.StackTraceRef) *cachedStackTrace {
+// resolveStackTraceCached resolves stRef through cache. fd is nil unless
+// frame details were requested.
+func resolveStackTraceCached(p *parser.Parser, cache map[types.StackTraceRef]*cachedStackTrace, fd *frameDetailDecoder, stRef types.StackTraceRef) *cachedStackTrace {
 	if cached, ok := cache[stRef]; ok {
 		return cached
 	}
@@ -306,18 +435,32 @@ func resolveStackTraceCached(p *parser.Parser, cache map[types.StackTraceRef]*ca
 	}
 
 	// JFR frames are leaf-first; reverse to root-first for collapsed format.
-	n := len(st.Frames)
+	// Past --max-frames only the leaf-most frames are kept.
+	leafFirst := st.Frames
+	cut := len(leafFirst) > maxStackFrames
+	if cut {
+		leafFirst = leafFirst[:maxStackFrames]
+	}
+	n := len(leafFirst)
 	frames := make([]string, n)
 	lines := make([]uint32, n)
-	for i, f := range st.Frames {
+	for i, f := range leafFirst {
 		frames[n-1-i] = resolveFrame(p, f)
 		lines[n-1-i] = f.LineNumber
 	}
 
 	cached := &cachedStackTrace{
-		frames: frames,
-		lines:  lines,
-		key:    buildStackKeyWithLines(frames, lines),
+		frames:    frames,
+		lines:     lines,
+		key:       buildStackKeyWithLines(frames, lines),
+		truncated: st.Truncated || cut,
+	}
+	if fd != nil {
+		if details := fd.lookup(stRef); len(details) == len(st.Frames) {
+			details, _ = limitFrames(details)
+			cached.details = details
+			cached.key += frameDetailSuffix(details)
+		}
 	}
 	cache[stRef] = cached
 	return cached
@@ -335,6 +478,14 @@ func singleEventType(eventType string) map[string]struct{} {
 	return map[string]struct{}{eventType: {}}
 }
 
+// ticksDurationNanos converts a tick-based Duration to nanoseconds.
+func ticksDurationNanos(ticks, tps uint64) int64 {
+	if tps == 0 {
+		return 0
+	}
+	return int64(ticks/tps)*1_000_000_000 + int64(ticks%tps)*1_000_000_000/int64(tps)
+}
+
 // ticksToNanos converts a tick-based StartTime to nanosecond offset from originNanos.
 // Overflow-safe: uses quotient/remainder to avoid intermediate overflow.
 func ticksToNanos(startTicks, hdrStartTicks, hdrStartNanos, originNanos, tps uint64) int64 {
@@ -352,9 +503,22 @@ func ticksToNanos(startTicks, hdrStartTicks, hdrStartNanos, originNanos, tps uin
 const jfrChunkHeaderSize = 68
 const jfrChunkMagic = 0x464c5200
 
+// truncatedScanWarned holds the files whose truncated chunk header warning
+// was printed. Commands read a file once per pass (the parse, the JDK event
+// scans), which should not each repeat it; every damaged file still warns.
+var truncatedScanWarned = make(map[string]bool)
+
 // scanChunkHeaders reads 68-byte chunk headers linked by Size to determine
 // originNanos (first chunk StartNanos) and spanNanos (max end - origin).
 func scanChunkHeaders(buf []byte) (originNanos int64, spanNanos int64, err error) {
+	originNanos, spanNanos, _, err = walkChunkHeaders(buf)
+	return originNanos, spanNanos, err
+}
+
+// walkChunkHeaders is scanChunkHeaders that also returns the offset where
+// the header chain breaks off, or -1 when it covers the whole buffer.
+func walkChunkHeaders(buf []byte) (originNanos int64, spanNanos int64, truncatedAt int, err error) {
+	truncatedAt = -1
 	pos := 0
 	chunks := 0
 	var origin uint64
@@ -364,9 +528,9 @@ func scanChunkHeaders(buf []byte) (originNanos int64, spanNanos int64, err error
 		magic := binary.BigEndian.Uint32(buf[pos:])
 		if magic != jfrChunkMagic {
 			if chunks == 0 {
-				return 0, 0, fmt.Errorf("no valid JFR chunk header found")
+				return 0, 0, -1, fmt.Errorf("no valid JFR chunk header found")
 			}
-			fmt.Fprintf(os.Stderr, "warning: truncated chunk header scan at offset %d; timeline span may be incomplete\n", pos)
+			truncatedAt = pos
 			break
 		}
 		size64 := int64(binary.BigEndian.Uint64(buf[pos+8:]))
@@ -383,46 +547,42 @@ func scanChunkHeaders(buf []byte) (originNanos int64, spanNanos int64, err error
 		chunks++
 
 		if size64 <= 0 || size64 > int64(len(buf))-int64(pos) {
-			fmt.Fprintf(os.Stderr, "warning: truncated chunk header scan at offset %d; timeline span may be incomplete\n", pos)
+			truncatedAt = pos
 			break
 		}
 		pos += int(size64)
 	}
 
 	if chunks == 0 {
-		return 0, 0, fmt.Errorf("no valid JFR chunk header found")
+		return 0, 0, -1, fmt.Errorf("no valid JFR chunk header found")
 	}
 
 	originNanos = int64(origin)
 	if maxEnd > origin {
 		spanNanos = int64(maxEnd - origin)
 	}
-	return originNanos, spanNanos, nil
+	return originNanos, spanNanos, truncatedAt, nil
 }
 
-func appendJFRStackSample(p *parser.Parser, stackCache map[types.StackTraceRef]*cachedStackTrace, agg map[stackKey]*aggValue, stRef types.StackTraceRef, thRef types.ThreadRef, weight int) {
-	cached := resolveStackTraceCached(p, stackCache, stRef)
+func appendJFRStackSample(p *parser.Parser, stackCache map[types.StackTraceRef]*cachedStackTrace, fd *frameDetailDecoder, roots contextRoots, contextID uint64, agg *stackAgg, stRef types.StackTraceRef, thRef types.ThreadRef, weight int) {
+	cached := roots.apply(resolveStackTraceCached(p, stackCache, fd, stRef), contextID)
 	if len(cached.frames) == 0 {
 		return
 	}
 
 	thread := resolveThread(p, thRef)
-	key := stackKey{frames: cached.key, thread: thread}
-	if v, ok := agg[key]; ok {
-		v.count += weight
-	} else {
-		agg[key] = &aggValue{frames: cached.frames, lines: cached.lines, count: weight}
-	}
+	agg.add(stackKey{frames: cached.key, thread: thread}, cached.frames, cached.lines, cached.details, weight)
 }
 
 func buildStackFile(agg map[stackKey]*aggValue) *stackFile {
 	sf := &stackFile{}
 	for k, v := range agg {
 		sf.stacks = append(sf.stacks, stack{
-			frames: v.frames,
-			lines:  v.lines,
-			count:  v.count,
-			thread: k.thread,
+			frames:  v.frames,
+			lines:   v.lines,
+			count:   v.count,
+			thread:  k.thread,
+			details: v.details,
 		})
 		sf.totalSamples += v.count
 	}
@@ -437,7 +597,7 @@ func buildStackFileFromTimed(events []timedEvent) *stackFile {
 		if v, ok := agg[key]; ok {
 			v.count += e.weight
 		} else {
-			agg[key] = &aggValue{frames: e.frames, lines: e.lines, count: e.weight}
+			agg[key] = &aggValue{frames: e.frames, lines: e.lines, details: e.details, count: e.weight}
 		}
 	}
 	return buildStackFile(agg)
@@ -448,9 +608,26 @@ type jfrEventInfo struct {
 	stRef      types.StackTraceRef
 	thRef      types.ThreadRef
 	startTicks uint64
+	durTicks   uint64
 	weight     int
 }
 
+// profilerSettingNames are the jdk.ActiveSetting names async-profiler
+// writes for its own options. JDK settings recorded with --jfrsync reuse
+// generic names (enabled, threshold, period) and are not kept.
+var profilerSettingNames = map[string]bool{
+	"version":     true,
+	"engine":      true,
+	"event":       true,
+	"cstack":      true,
+	"interval":    true,
+	"wall":        true,
+	"alloc":       true,
+	"live":        true,
+	"lock":        true,
+	"jstackdepth": true,
+}
+
 // normalizeExecEvent maps the raw async-profiler event name from
 // jdk.ActiveSetting to the canonical ap-query event name.
 func normalizeExecEvent(raw string) string {
@@ -463,31 +640,94 @@ func normalizeExecEvent(raw string) string {
 func classifyEvent(p *parser.Parser, typ def.TypeID, execEventName string) (jfrEventInfo, bool) {
 	switch typ {
 	case p.TypeMap.T_EXECUTION_SAMPLE:
-		return jfrEventInfo{execEventName, p.ExecutionSample.StackTrace, p.ExecutionSample.SampledThread, p.ExecutionSample.StartTime, 1}, true
+		return jfrEventInfo{execEventName, p.ExecutionSample.StackTrace, p.ExecutionSample.SampledThread, p.ExecutionSample.StartTime, 0, 1}, true
 	case p.TypeMap.T_WALL_CLOCK_SAMPLE:
 		weight := int(p.WallClockSample.Samples)
 		if weight < 1 {
 			weight = 1
 		}
-		return jfrEventInfo{"wall", p.WallClockSample.StackTrace, p.WallClockSample.SampledThread, p.WallClockSample.StartTime, weight}, true
+		return jfrEventInfo{"wall", p.WallClockSample.StackTrace, p.WallClockSample.SampledThread, p.WallClockSample.StartTime, 0, weight}, true
 	case p.TypeMap.T_ALLOC_IN_NEW_TLAB:
-		return jfrEventInfo{"alloc", p.ObjectAllocationInNewTLAB.StackTrace, p.ObjectAllocationInNewTLAB.EventThread, p.ObjectAllocationInNewTLAB.StartTime, 1}, true
+		return jfrEventInfo{"alloc", p.ObjectAllocationInNewTLAB.StackTrace, p.ObjectAllocationInNewTLAB.EventThread, p.ObjectAllocationInNewTLAB.StartTime, 0, 1}, true
 	case p.TypeMap.T_ALLOC_OUTSIDE_TLAB:
-		return jfrEventInfo{"alloc", p.ObjectAllocationOutsideTLAB.StackTrace, p.ObjectAllocationOutsideTLAB.EventThread, p.ObjectAllocationOutsideTLAB.StartTime, 1}, true
+		return jfrEventInfo{"alloc", p.ObjectAllocationOutsideTLAB.StackTrace, p.ObjectAllocationOutsideTLAB.EventThread, p.ObjectAllocationOutsideTLAB.StartTime, 0, 1}, true
 	case p.TypeMap.T_ALLOC_SAMPLE:
-		return jfrEventInfo{"alloc", p.ObjectAllocationSample.StackTrace, p.ObjectAllocationSample.EventThread, p.ObjectAllocationSample.StartTime, 1}, true
+		return jfrEventInfo{"alloc", p.ObjectAllocationSample.StackTrace, p.ObjectAllocationSample.EventThread, p.ObjectAllocationSample.StartTime, 0, 1}, true
 	case p.TypeMap.T_MONITOR_ENTER:
-		return jfrEventInfo{"lock", p.JavaMonitorEnter.StackTrace, p.JavaMonitorEnter.EventThread, p.JavaMonitorEnter.StartTime, 1}, true
+		return jfrEventInfo{"lock", p.JavaMonitorEnter.StackTrace, p.JavaMonitorEnter.EventThread, p.JavaMonitorEnter.StartTime, p.JavaMonitorEnter.Duration, 1}, true
+	case p.TypeMap.T_LIVE_OBJECT:
+		// Sampled allocations still reachable when the recording ended
+		// (asprof -e alloc --live).
+		return jfrEventInfo{"live", p.LiveObject.StackTrace, p.LiveObject.EventThread, p.LiveObject.StartTime, 0, 1}, true
 	default:
 		return jfrEventInfo{}, false
 	}
 }
 
+// defaultJDKSamplePeriod is the jdk.ExecutionSample period of JFR's
+// default settings, used when a recording does not state it.
+const defaultJDKSamplePeriod = 20_000_000 // 20ms
+
+// parseJFRPeriod parses a JFR period setting such as "20 ms" or "10ms".
+func parseJFRPeriod(v string) (int64, bool) {
+	d, err := time.ParseDuration(strings.ReplaceAll(v, " ", ""))
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return int64(d), true
+}
+
+// approxWallEvent maps an event of a plain JDK recording onto an
+// approximate wall sample: an execution sample counts once, and monitor
+// enter and park events count once per sampling period spent blocked.
+// Threads sleeping, waiting in Object.wait or running native code are
+// invisible to both, so the result undercounts off-CPU time.
+func approxWallEvent(p *parser.Parser, typ def.TypeID, period int64) (jfrEventInfo, bool) {
+	var info jfrEventInfo
+	switch typ {
+	case p.TypeMap.T_EXECUTION_SAMPLE:
+		return jfrEventInfo{"wall", p.ExecutionSample.StackTrace, p.ExecutionSample.SampledThread, p.ExecutionSample.StartTime, 0, 1}, true
+	case p.TypeMap.T_MONITOR_ENTER:
+		info = jfrEventInfo{"wall", p.JavaMonitorEnter.StackTrace, p.JavaMonitorEnter.EventThread, p.JavaMonitorEnter.StartTime, p.JavaMonitorEnter.Duration, 1}
+	case p.TypeMap.T_THREAD_PARK:
+		info = jfrEventInfo{"wall", p.ThreadPark.StackTrace, p.ThreadPark.EventThread, p.ThreadPark.StartTime, p.ThreadPark.Duration, 1}
+	default:
+		return jfrEventInfo{}, false
+	}
+	dur := ticksDurationNanos(info.durTicks, p.ChunkHeader().TicksPerSecond)
+	info.weight = max(1, int((dur+period/2)/period))
+	return info, true
+}
+
 func parseJFRData(path string, stackEvents map[string]struct{}, opts parseOpts) (*parsedProfile, error) {
 	buf, err := readJFRBytes(path)
 	if err != nil {
 		return nil, err
 	}
+	parsed, err := parseJFRBuffer(buf, stackEvents, opts)
+	if err == nil {
+		return parsed, nil
+	}
+	// A JVM that crashed or was killed leaves a cut-off last chunk, and a
+	// damaged disk a corrupt one. Chunks are self-contained, so analyze the
+	// ones that still parse.
+	salvaged, sum := salvageJFRChunks(buf)
+	if sum.kept == 0 || len(salvaged) == len(buf) {
+		return nil, err
+	}
+	fmt.Fprintf(os.Stderr, "warning: %s is damaged (%v); skipped %d of %d chunks, analyzing the remaining %s of %s\n",
+		path, err, sum.total-sum.kept, sum.total, formatBytes(int64(len(salvaged))), formatBytes(int64(len(buf))))
+	return parseJFRBuffer(salvaged, stackEvents, opts)
+}
+
+// parseJFRBuffer parses the chunks in buf. The JFR parser can panic on
+// corrupt input; that is reported as a parse error.
+func parseJFRBuffer(buf []byte, stackEvents map[string]struct{}, opts parseOpts) (parsed *parsedProfile, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			parsed, err = nil, parseErrorf("parse event: %v", r)
+		}
+	}()
 
 	// Scan chunk headers for origin/span before event parsing.
 	originNanos, spanNanos, scanErr := scanChunkHeaders(buf)
@@ -495,7 +735,10 @@ func parseJFRData(path string, stackEvents map[string]struct{}, opts parseOpts)
 		fmt.Fprintf(os.Stderr, "warning: %v; timeline data may be unavailable\n", scanErr)
 	}
 
-	p := parser.NewParser(buf, parser.Options{})
+	p, err := newJFRParser(buf)
+	if err != nil {
+		return nil, err
+	}
 	counts := make(map[string]int)
 	// Copy stackEvents to avoid mutating the caller's map when ActiveSetting
 	// discovers dynamic event names (e.g. "branch-misses").
@@ -508,14 +751,25 @@ func parseJFRData(path string, stackEvents map[string]struct{}, opts parseOpts)
 			wantEvents[e] = struct{}{}
 		}
 	}
-	aggByEvent := make(map[string]map[stackKey]*aggValue, len(wantEvents))
+	aggByEvent := make(map[string]*stackAgg, len(wantEvents))
 	for eventType := range wantEvents {
-		aggByEvent[eventType] = make(map[stackKey]*aggValue)
+		aggByEvent[eventType] = newStackAgg(opts.maxStacks)
 	}
 	// async-profiler call_trace_id values are stable across JFR chunks, so stack
 	// decoding can be memoized globally for the file. Thread refs are chunk-local
 	// (raw OS tids) and therefore resolved directly per event.
 	stackCache := make(map[types.StackTraceRef]*cachedStackTrace)
+	// Timed parsing ignores --max-stacks, so only the aggregating path
+	// needs its cache bounded.
+	var cachePruner, approxPruner *stackCachePruner
+	if !opts.collectTimestamps {
+		cachePruner = newStackCachePruner(opts.maxStacks)
+		approxPruner = newStackCachePruner(opts.maxStacks)
+	}
+	var frameDetails *frameDetailDecoder
+	if opts.frameDetails {
+		frameDetails = newFrameDetailDecoder(buf)
+	}
 
 	var timedByEvent map[string][]timedEvent
 	if opts.collectTimestamps {
@@ -523,6 +777,36 @@ func parseJFRData(path string, stackEvents map[string]struct{}, opts parseOpts)
 	}
 
 	execEventName := "cpu"
+	rawInterval := int64(-1) // -1 = no interval setting seen
+	var settings map[string]string
+	truncated := make(map[string]int)
+	var roots contextRoots
+	if opts.contextFrames {
+		roots = make(contextRoots)
+	}
+	tagged := 0 // weight of samples with a context id
+
+	// A plain JDK recording has no wall samples. When wall is requested,
+	// an approximation is collected on the side and used only if the file
+	// turns out to have no async-profiler settings and no real wall samples.
+	_, wantWall := wantEvents["wall"]
+	approxWall := strictFilter && wantWall && !opts.contextFilter
+	approxPeriod := int64(defaultJDKSamplePeriod)
+	approxAgg := newStackAgg(opts.maxStacks)
+	approxAggs := map[string]*stackAgg{"wall": approxAgg}
+	var approxTimed []timedEvent
+	approxCount := 0
+	// JDK stack trace ids are chunk-local, unlike async-profiler's.
+	var approxCache map[types.StackTraceRef]*cachedStackTrace
+	var approxChunk uint64
+
+	prog := newParseProgress(opts.progress, int64(len(buf)))
+	var chunkOffsets map[uint64]int64
+	if prog != nil {
+		chunkOffsets = chunkStartOffsets(buf)
+	}
+	defer prog.done()
+	parsedEvents := 0
 
 	for {
 		typ, err := p.ParseEvent()
@@ -530,11 +814,36 @@ func parseJFRData(path string, stackEvents map[string]struct{}, opts parseOpts)
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("parse event: %w", err)
+			return nil, parseErrorf("parse event: %w", err)
+		}
+		parsedEvents++
+		if prog != nil && parsedEvents%progressCheckEvery == 0 {
+			hdr := p.ChunkHeader()
+			done := chunkOffsets[hdr.StartNanos]
+			if info, ok := classifyEvent(p, typ, execEventName); ok {
+				done = chunkBytesDone(done, hdr, info.startTicks)
+			}
+			prog.update(done, parsedEvents)
 		}
 
 		if typ == p.TypeMap.T_ACTIVE_SETTING {
 			s := p.ActiveSetting
+			if profilerSettingNames[s.Name] {
+				if settings == nil {
+					settings = make(map[string]string)
+				}
+				settings[s.Name] = s.Value
+			}
+			if s.Name == "period" && s.Id == uint64(p.TypeMap.T_EXECUTION_SAMPLE) {
+				if v, ok := parseJFRPeriod(s.Value); ok {
+					approxPeriod = v
+				}
+			}
+			if s.Name == "interval" {
+				if v, err := strconv.ParseInt(s.Value, 10, 64); err == nil {
+					rawInterval = v
+				}
+			}
 			if s.Name == "event" {
 				execEventName = normalizeExecEvent(s.Value)
 				// In parse-all mode, dynamically add the discovered event
@@ -544,17 +853,71 @@ func parseJFRData(path string, stackEvents map[string]struct{}, opts parseOpts)
 				if wantEvents != nil && !strictFilter {
 					wantEvents[execEventName] = struct{}{}
 					if _, exists := aggByEvent[execEventName]; !exists {
-						aggByEvent[execEventName] = make(map[stackKey]*aggValue)
+						aggByEvent[execEventName] = newStackAgg(opts.maxStacks)
 					}
 				}
 			}
 			continue
 		}
 
+		if approxWall {
+			if info, ok := approxWallEvent(p, typ, approxPeriod); ok {
+				hdr := p.ChunkHeader()
+				if approxCache == nil || approxChunk != hdr.StartNanos {
+					approxCache = make(map[types.StackTraceRef]*cachedStackTrace)
+					approxChunk = hdr.StartNanos
+				}
+				if frameDetails != nil {
+					if err := frameDetails.update(p); err != nil {
+						return nil, err
+					}
+				}
+				approxCount += info.weight
+				if !opts.collectTimestamps {
+					appendJFRStackSample(p, approxCache, frameDetails, nil, 0, approxAgg, info.stRef, info.thRef, info.weight)
+					approxPruner.maybePrune(approxCache, nil, approxAggs)
+				} else if offsetNanos := ticksToNanos(info.startTicks, hdr.StartTicks, hdr.StartNanos, uint64(originNanos), hdr.TicksPerSecond); (opts.fromNanos < 0 || offsetNanos >= opts.fromNanos) && (opts.toNanos < 0 || offsetNanos < opts.toNanos) {
+					cached := resolveStackTraceCached(p, approxCache, frameDetails, info.stRef)
+					if len(cached.frames) > 0 {
+						approxTimed = append(approxTimed, timedEvent{
+							offsetNanos: offsetNanos,
+							stackKey:    cached.key,
+							frames:      cached.frames,
+							lines:       cached.lines,
+							details:     cached.details,
+							thread:      resolveThread(p, info.thRef),
+							weight:      info.weight,
+							durNanos:    ticksDurationNanos(info.durTicks, hdr.TicksPerSecond),
+						})
+					}
+				}
+			}
+		}
+
 		info, ok := classifyEvent(p, typ, execEventName)
 		if !ok {
 			continue
 		}
+		var contextID uint64
+		if opts.contextFilter || opts.contextFrames {
+			contextID = eventContextID(p, typ)
+			if contextID != 0 {
+				tagged += info.weight
+			}
+			if opts.contextFilter && contextID != opts.contextID {
+				continue
+			}
+		}
+		// Lock events are the ones with a duration; sampled events have none.
+		if opts.minDurNanos > 0 && info.eventType == "lock" &&
+			ticksDurationNanos(info.durTicks, p.ChunkHeader().TicksPerSecond) < opts.minDurNanos {
+			continue
+		}
+		if frameDetails != nil {
+			if err := frameDetails.update(p); err != nil {
+				return nil, err
+			}
+		}
 
 		counts[info.eventType] += info.weight
 
@@ -573,25 +936,45 @@ func parseJFRData(path string, stackEvents map[string]struct{}, opts parseOpts)
 				continue
 			}
 
-			cached := resolveStackTraceCached(p, stackCache, info.stRef)
+			cached := roots.apply(resolveStackTraceCached(p, stackCache, frameDetails, info.stRef), contextID)
 			if len(cached.frames) == 0 {
 				continue
 			}
+			if cached.truncated {
+				truncated[info.eventType] += info.weight
+			}
 			thread := resolveThread(p, info.thRef)
 			timedByEvent[info.eventType] = append(timedByEvent[info.eventType], timedEvent{
 				offsetNanos: offsetNanos,
 				stackKey:    cached.key,
 				frames:      cached.frames,
 				lines:       cached.lines,
+				details:     cached.details,
 				thread:      thread,
 				weight:      info.weight,
+				durNanos:    ticksDurationNanos(info.durTicks, hdr.TicksPerSecond),
 			})
 		} else {
 			agg, ok := aggByEvent[info.eventType]
 			if !ok {
 				continue
 			}
-			appendJFRStackSample(p, stackCache, agg, info.stRef, info.thRef, info.weight)
+			if resolveStackTraceCached(p, stackCache, frameDetails, info.stRef).truncated {
+				truncated[info.eventType] += info.weight
+			}
+			appendJFRStackSample(p, stackCache, frameDetails, roots, contextID, agg, info.stRef, info.thRef, info.weight)
+			cachePruner.maybePrune(stackCache, roots, aggByEvent)
+		}
+	}
+
+	var approxWallPeriod int64
+	if approxWall && counts["wall"] == 0 && settings == nil && approxCount > 0 {
+		approxWallPeriod = approxPeriod
+		counts["wall"] = approxCount
+		if opts.collectTimestamps {
+			timedByEvent["wall"] = approxTimed
+		} else {
+			aggByEvent["wall"] = approxAgg
 		}
 	}
 
@@ -607,7 +990,11 @@ func parseJFRData(path string, stackEvents map[string]struct{}, opts parseOpts)
 		}
 	} else {
 		for eventType, agg := range aggByEvent {
-			stacksByEvent[eventType] = buildStackFile(agg)
+			stacksByEvent[eventType] = agg.stackFile()
+			if agg.approximate() {
+				fmt.Fprintf(os.Stderr, "warning: %s: more than %d distinct stacks; kept the heaviest %d (approximate, per-stack counts may be overestimated by up to %d samples)\n",
+					eventType, opts.maxStacks, opts.maxStacks, agg.maxError)
+			}
 		}
 	}
 	if opts.collectTimestamps && opts.warnLargeCount {
@@ -620,6 +1007,16 @@ func parseJFRData(path string, stackEvents map[string]struct{}, opts parseOpts)
 		}
 	}
 
+	// The interval is in ns for time-based engines only; hardware counters
+	// count events.
+	var cpuInterval int64
+	if execEventName == "cpu" && rawInterval >= 0 {
+		cpuInterval = rawInterval
+		if cpuInterval == 0 {
+			cpuInterval = defaultCPUInterval
+		}
+	}
+
 	return &parsedProfile{
 		eventCounts:   counts,
 		stacksByEvent: stacksByEvent,
@@ -627,6 +1024,12 @@ func parseJFRData(path string, stackEvents map[string]struct{}, opts parseOpts)
 		originNanos:   originNanos,
 		spanNanos:     spanNanos,
 		execEventName: execEventName,
+		cpuInterval:   cpuInterval,
+		settings:      settings,
+		truncated:     truncated,
+
+		approxWallPeriod: approxWallPeriod,
+		contextTagged:    tagged,
 	}, nil
 }
 
@@ -638,7 +1041,7 @@ func filterIdleEvents(events []timedEvent) []timedEvent {
 	}
 	var out []timedEvent
 	for i := range events {
-		if len(events[i].frames) > 0 && isIdleLeaf(events[i].frames[len(events[i].frames)-1]) {
+		if isIdleStack(events[i].frames) {
 			continue
 		}
 		out = append(out, events[i])
@@ -660,7 +1063,7 @@ func openReader(path string) (io.ReadCloser, error) {
 		gr, err := gzip.NewReader(f)
 		if err != nil {
 			f.Close()
-			return nil, fmt.Errorf("gzip: %w", err)
+			return nil, readError(fmt.Errorf("gzip: %w", err))
 		}
 		return &gzipReadCloser{gz: gr, f: f}, nil
 	}
@@ -696,18 +1099,24 @@ func splitCollapsedLine(line string) (string, int) {
 	return line[:i], count
 }
 
-// parseThreadFrame checks if frame is "[name]" or "[name tid=N]" and returns
-// the thread name, or "" if not a thread marker.
-func parseThreadFrame(frame string) string {
+// parseThreadFrame checks if frame is "[name]", "[name tid=N]" or the
+// tid-only "[tid=N]" and returns the thread name and tid, or "" if not a
+// thread marker. A tid-only marker keeps "tid=N" as the name.
+func parseThreadFrame(frame string) (name, tid string) {
 	if len(frame) < 3 || frame[0] != '[' || frame[len(frame)-1] != ']' {
-		return ""
+		return "", ""
 	}
 	inner := frame[1 : len(frame)-1]
-	// Strip optional " tid=N" suffix.
+	if rest, ok := strings.CutPrefix(inner, "tid="); ok && isAllDigits(rest) {
+		return inner, rest
+	}
 	if idx := strings.Index(inner, " tid="); idx >= 0 {
+		if rest := inner[idx+len(" tid="):]; isAllDigits(rest) {
+			tid = rest
+		}
 		inner = inner[:idx]
 	}
-	return inner
+	return inner, tid
 }
 
 // parseAnnotatedFrame strips jfrconv annotations from "Method:line_[type]".
@@ -730,12 +1139,218 @@ func parseAnnotatedFrame(frame string) (string, uint32) {
 	return base[:colon], uint32(ln)
 }
 
+// eventLabelPrefix starts the optional leading "[event=NAME]" frame of a
+// collapsed line (written by `collapse --event all`), placed before the
+// thread marker.
+const eventLabelPrefix = "[event="
+
+// parseEventLabel returns the event name of an "[event=NAME]" frame, or "".
+func parseEventLabel(frame string) string {
+	if !strings.HasPrefix(frame, eventLabelPrefix) || !strings.HasSuffix(frame, "]") {
+		return ""
+	}
+	return frame[len(eventLabelPrefix) : len(frame)-1]
+}
+
+// parseCollapsed reads collapsed text. Event labels are stripped, so a
+// labeled file yields the stacks of all its events; use
+// parseCollapsedByEvent to keep them apart.
 func parseCollapsed(r io.Reader) (*stackFile, error) {
-	sf := &stackFile{}
-	scanner := bufio.NewScanner(r)
-	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
-	for scanner.Scan() {
-		line := scanner.Text()
+	sf, _, _, err := parseCollapsedByEvent(r)
+	return sf, err
+}
+
+// Collapsed text is parsed in batches of lines on every CPU: files merged
+// from a fleet run to tens of millions of lines.
+const collapsedBatchLines = 4096
+
+// maxLineBytes bounds one collapsed line (--max-line-bytes). Deep stacks
+// of long fully-qualified frames run to megabytes; longer lines are skipped
+// with a warning rather than failing the whole file.
+var maxLineBytes = defaultMaxLineBytes
+
+const defaultMaxLineBytes = 64 << 20
+
+// collapsedBatch is the parse of consecutive lines with identical lines
+// merged; labels holds each stack's event label, "" when unlabeled, and
+// keys the line text identifying it.
+type collapsedBatch struct {
+	stacks []stack
+	labels []string
+	keys   []string
+	cut    []bool // stacks cut at maxStackFrames
+	read   int    // samples before --sample thinned them
+}
+
+// parseCollapsedByEvent reads collapsed text and also splits the stacks by
+// event label. byEvent is nil when no line carries a label; unlabeled
+// counts the samples of unlabeled lines in a labeled file. Every goroutine
+// aggregates its batch by stack; the batches are merged in line order, so
+// stacks appear in the order of their first line however many goroutines
+// parse them.
+func parseCollapsedByEvent(r io.Reader) (sf *stackFile, byEvent map[string]*stackFile, unlabeled int, err error) {
+	type job struct {
+		lines []string
+		seq   int // batch number, seeding --sample
+		out   chan collapsedBatch
+	}
+	workers := runtime.GOMAXPROCS(0)
+	jobs := make(chan job, workers)
+	pending := make(chan chan collapsedBatch, 2*workers) // batches in line order
+	for range workers {
+		go func() {
+			for j := range jobs {
+				j.out <- parseCollapsedLines(j.lines, j.seq)
+			}
+		}()
+	}
+	var scanErr error
+	var skipped, firstSkipped int // lines over maxLineBytes
+	cut := 0                      // stacks over maxStackFrames
+	read := 0                     // samples before --sample
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		seq := 0
+		submit := func(lines []string) {
+			out := make(chan collapsedBatch, 1)
+			pending <- out
+			jobs <- job{lines, seq, out}
+			seq++
+		}
+		br := bufio.NewReaderSize(r, 64*1024)
+		batch := make([]string, 0, collapsedBatchLines)
+		for n := 1; ; n++ {
+			line, tooLong, err := readCollapsedLine(br, maxLineBytes)
+			if err != nil {
+				if err != io.EOF {
+					scanErr = err
+				}
+				break
+			}
+			if tooLong {
+				if skipped == 0 {
+					firstSkipped = n
+				}
+				skipped++
+				continue
+			}
+			batch = append(batch, line)
+			if len(batch) == collapsedBatchLines {
+				submit(batch)
+				batch = make([]string, 0, collapsedBatchLines)
+			}
+		}
+		if len(batch) > 0 {
+			submit(batch)
+		}
+	}()
+
+	sf = &stackFile{}
+	var labels []string // parallel to sf.stacks
+	index := make(map[string]int)
+	for out := range pending {
+		b := <-out
+		for i, key := range b.keys {
+			if j, ok := index[key]; ok {
+				sf.stacks[j].count += b.stacks[i].count
+				continue
+			}
+			index[key] = len(sf.stacks)
+			sf.stacks = append(sf.stacks, b.stacks[i])
+			labels = append(labels, b.labels[i])
+			if b.cut[i] {
+				cut++
+			}
+		}
+		read += b.read
+	}
+	if scanErr != nil {
+		return nil, nil, 0, readError(scanErr)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "warning: skipped %d collapsed lines longer than %d bytes (first at line %d); raise --max-line-bytes to keep them\n",
+			skipped, maxLineBytes, firstSkipped)
+	}
+	if cut > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d stacks deeper than %d frames kept only their leaf-most frames; raise --max-frames to keep them\n",
+			cut, maxStackFrames)
+	}
+	for i := range sf.stacks {
+		sf.totalSamples += sf.stacks[i].count
+	}
+	if sampleFraction > 0 {
+		reportSampling(sf.totalSamples, read)
+	}
+
+	for i, event := range labels {
+		if event == "" {
+			continue
+		}
+		if byEvent == nil {
+			byEvent = make(map[string]*stackFile)
+		}
+		ev := byEvent[event]
+		if ev == nil {
+			ev = &stackFile{}
+			byEvent[event] = ev
+		}
+		ev.stacks = append(ev.stacks, sf.stacks[i])
+		ev.totalSamples += sf.stacks[i].count
+	}
+	if byEvent != nil {
+		for i, event := range labels {
+			if event == "" {
+				unlabeled += sf.stacks[i].count
+			}
+		}
+	}
+	return sf, byEvent, unlabeled, nil
+}
+
+// readCollapsedLine returns the next line without its line ending, or
+// io.EOF after the last one. A line longer than limit bytes is consumed
+// without being kept and reported as tooLong.
+func readCollapsedLine(br *bufio.Reader, limit int) (line string, tooLong bool, err error) {
+	var buf []byte
+	for {
+		chunk, err := br.ReadSlice('\n')
+		if !tooLong {
+			buf = append(buf, chunk...)
+			if len(buf) > limit+len("\r\n") {
+				tooLong, buf = true, nil
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err == io.EOF && (len(buf) > 0 || tooLong) {
+			break // last line without a newline
+		}
+		if err != nil {
+			return "", false, err
+		}
+		break
+	}
+	if tooLong {
+		return "", true, nil
+	}
+	buf = bytes.TrimSuffix(buf, []byte("\n"))
+	buf = bytes.TrimSuffix(buf, []byte("\r"))
+	if len(buf) > limit {
+		return "", true, nil
+	}
+	return string(buf), false, nil
+}
+
+// parseCollapsedLines parses one batch of collapsed lines, skipping blank
+// lines and lines without a count or frames, and sums the counts of lines
+// with the same frames. seq numbers the batch, so --sample draws
+// differently for every line of the input.
+func parseCollapsedLines(lines []string, seq int) collapsedBatch {
+	var b collapsedBatch
+	index := make(map[string]int)
+	for i, line := range lines {
 		if line == "" {
 			continue
 		}
@@ -743,43 +1358,122 @@ func parseCollapsed(r io.Reader) (*stackFile, error) {
 		if count == 0 {
 			continue
 		}
+		if sampleFraction > 0 {
+			b.read += count
+			if count = thinSamples(count, sampleFraction, uint64(seq)*collapsedBatchLines+uint64(i)); count == 0 {
+				continue
+			}
+		}
+		if j, ok := index[framesStr]; ok {
+			b.stacks[j].count += count
+			continue
+		}
 
 		parts := strings.Split(framesStr, ";")
-		thread := ""
+		event := parseEventLabel(parts[0])
+		if event != "" {
+			parts = parts[1:]
+		}
+		thread, tid := "", ""
 		startIdx := 0
 
 		if len(parts) > 0 {
-			if t := parseThreadFrame(parts[0]); t != "" {
-				thread = t
+			if t, id := parseThreadFrame(parts[0]); t != "" {
+				thread, tid = t, id
 				startIdx = 1
 			}
 		}
 
-		frames := make([]string, 0, len(parts)-startIdx)
-		lines := make([]uint32, 0, len(parts)-startIdx)
+		parts, cut := limitFrames(parts[startIdx:])
+		frames := make([]string, 0, len(parts))
+		frameLines := make([]uint32, 0, len(parts))
 
-		for _, part := range parts[startIdx:] {
+		for _, part := range parts {
 			name, ln := parseAnnotatedFrame(part)
-			frames = append(frames, name)
-			lines = append(lines, ln)
+			frames = append(frames, limitSymbol(name))
+			frameLines = append(frameLines, ln)
 		}
 
 		if len(frames) == 0 {
 			continue
 		}
 
-		sf.stacks = append(sf.stacks, stack{
+		b.stacks = append(b.stacks, stack{
 			frames: frames,
-			lines:  lines,
+			lines:  frameLines,
 			count:  count,
 			thread: thread,
+			tid:    tid,
 		})
-		sf.totalSamples += count
+		b.labels = append(b.labels, event)
+		b.keys = append(b.keys, framesStr)
+		b.cut = append(b.cut, cut)
+		index[framesStr] = len(b.stacks) - 1
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	return b
+}
+
+// collapsedResult parses collapsed text. Event-labeled input yields a
+// parsedProfile, so --event selects among the labels as it does for JFR.
+// Text exports of other profilers (see textImporter) are recognized by
+// their first character and converted instead.
+func collapsedResult(r io.Reader) (stdinResult, error) {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(apqMagic)); isAPQ(head) {
+		warnSampleIgnored("the ap-query model (.apq)")
+		parsed, err := readAPQ(br)
+		return stdinResult{parsed: parsed, source: "ap-query model (.apq)"}, err
+	}
+	if source, parse := textImporter(br); parse != nil {
+		warnSampleIgnored("the " + source)
+		sf, err := parse(br)
+		return stdinResult{sf: sf, source: source}, err
+	}
+	sf, byEvent, unlabeled, err := parseCollapsedByEvent(br)
+	if err != nil {
+		return stdinResult{}, err
+	}
+	if byEvent == nil {
+		return stdinResult{sf: sf, source: "collapsed text"}, nil
+	}
+	if unlabeled > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d samples on lines without an [event=...] label ignored\n", unlabeled)
 	}
-	return sf, nil
+	counts := make(map[string]int, len(byEvent))
+	for event, ev := range byEvent {
+		counts[event] = ev.totalSamples
+	}
+	return stdinResult{parsed: &parsedProfile{eventCounts: counts, stacksByEvent: byEvent}, source: "collapsed text with [event=...] labels"}, nil
+}
+
+// textImporter returns the name of and parser for another tool's text
+// export at the start of br (VisualVM XML or CSV, d3-flamegraph or
+// speedscope JSON), or a nil parser for collapsed text.
+func textImporter(br *bufio.Reader) (string, func(io.Reader) (*stackFile, error)) {
+	head, _ := br.Peek(512)
+	head = bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")), " \t\r\n")
+	switch {
+	case bytes.HasPrefix(head, []byte("<?xml")), bytes.HasPrefix(head, []byte("<ExportedView")), bytes.HasPrefix(head, []byte("<Node")):
+		return "VisualVM XML export", parseVisualVMXML
+	case bytes.HasPrefix(head, []byte(`"`)):
+		return "VisualVM CSV export", parseVisualVMCSV
+	case bytes.HasPrefix(head, []byte("{")):
+		return "flame graph JSON", parseFlameJSON
+	}
+	return "", nil
+}
+
+// parseCollapsedFile reads a collapsed text file; see collapsedResult.
+func parseCollapsedFile(path string) (stdinResult, error) {
+	if strings.HasSuffix(strings.ToLower(path), ".nps") {
+		return stdinResult{}, parseErrorf("%s: VisualVM .nps snapshots are binary; open it in VisualVM and export the call tree as XML or CSV, then pass that file", path)
+	}
+	rc, err := openReader(path)
+	if err != nil {
+		return stdinResult{}, err
+	}
+	defer rc.Close()
+	return collapsedResult(rc)
 }
 
 // ---------------------------------------------------------------------------
@@ -847,24 +1541,31 @@ func openInput(path, eventType string) (sf *stackFile, hasMetadata bool, err err
 		}
 		return sf, true, nil
 	default:
-		rc, err := openReader(path)
+		res, err := parseCollapsedFile(path)
 		if err != nil {
 			return nil, false, err
 		}
-		defer rc.Close()
-		sf, err = parseCollapsed(rc)
-		return sf, false, err
+		if res.parsed != nil {
+			sf = res.parsed.stacksByEvent[eventType]
+			if sf == nil {
+				sf = &stackFile{}
+			}
+			return sf, true, nil
+		}
+		return res.sf, false, nil
 	}
 }
 
 // stdinResult holds the result of parsing stdin with format auto-detection.
 type stdinResult struct {
-	parsed *parsedProfile // non-nil when stdin contained pprof (gzipped or raw)
-	sf     *stackFile     // non-nil when stdin contained collapsed text
+	parsed *parsedProfile // non-nil when stdin contained pprof (gzipped or raw), event-labeled collapsed text or .apq
+	sf     *stackFile     // non-nil when stdin contained unlabeled collapsed text
+	source string         // the text input read, e.g. "collapsed text"; "" for pprof
 }
 
 // parseStdin reads all of stdin and auto-detects the format.
-// Binary content (gzip or raw protobuf) → pprof; printable text → collapsed.
+// Binary content (gzip or raw protobuf) → pprof; printable text → collapsed;
+// an .apq model is recognized by its magic line.
 // When data looks binary but pprof parsing fails AND the data is valid UTF-8,
 // we fall back to collapsed (handles non-ASCII method names like café).
 // Invalid UTF-8 that also fails pprof is genuinely corrupt — we surface the error.
@@ -873,24 +1574,21 @@ func parseStdin(stackEvents map[string]struct{}) (stdinResult, error) {
 	if err != nil {
 		return stdinResult{}, err
 	}
-	if stdinLooksBinary(data) {
+	if stdinLooksBinary(data) && !isAPQ(data) {
 		// Binary data — try pprof (profile.Parse handles gzip and raw protobuf).
 		parsed, pprofErr := parsePprofFromReader(bytes.NewReader(data), stackEvents)
 		if pprofErr == nil {
+			warnSampleIgnored("the pprof profile on stdin")
 			return stdinResult{parsed: parsed}, nil
 		}
 		// pprof failed. If the data is valid UTF-8, it's likely text with
 		// non-ASCII characters (e.g. accented method names) — fall back to
 		// collapsed. Otherwise it's corrupt binary — surface the error.
 		if !utf8.Valid(data) {
-			return stdinResult{}, fmt.Errorf("stdin: not valid pprof: %w", pprofErr)
+			return stdinResult{}, parseErrorf("stdin: not valid pprof: %w", pprofErr)
 		}
 	}
-	sf, err := parseCollapsed(bytes.NewReader(data))
-	if err != nil {
-		return stdinResult{}, err
-	}
-	return stdinResult{sf: sf}, nil
+	return collapsedResult(bytes.NewReader(data))
 }
 
 // stdinLooksBinary returns true if data contains non-text bytes, indicating
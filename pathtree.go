@@ -1,6 +1,7 @@
 package main
 
 import (
+	"container/heap"
 	"fmt"
 	"io"
 	"sort"
@@ -13,6 +14,50 @@ type pathTree struct {
 	selfSamples  map[string]int
 	matchedNames map[string]bool
 	totalSamples int
+	highlight    map[string]bool // node names to mark as -m matches; nil = none
+	maxNodes     int             // cap on printed nodes; 0 = unlimited
+	inlined      map[string]int  // per-node samples where the frame was inlined; nil = no frame details
+	matchSelf    map[string]int  // per-node samples where the matched frame was the leaf
+	rootTotals   bool            // print a total and self line after each root's subtree
+	totalsHeader bool            // print the samples under all roots before the tree
+}
+
+// Chains of more than elideAbove nodes (recursion thousands of frames deep)
+// print their first elideHead and last elideTail nodes around a marker of
+// what was left out, unless --full-stacks is set.
+var fullStacks bool
+
+const (
+	elideAbove = 128
+	elideHead  = 40
+	elideTail  = 40
+)
+
+// fprintElided prints the marker standing for n elided chain nodes.
+func fprintElided(w io.Writer, pad string, n int) {
+	fmt.Fprintf(w, "%s… %d frames elided (--full-stacks shows all)\n", pad, n)
+}
+
+// highlightMarker prefixes node names selected by -m when --highlight is set.
+const highlightMarker = "» "
+
+// markMatches records the display names (as produced by display) of every
+// frame selected by m, so printing marks each matched node, including
+// matches nested below the anchoring frame (recursion, or several methods
+// matching one pattern).
+func (pt *pathTree) markMatches(sf *stackFile, m methodMatcher, display func(string) string) {
+	pt.highlight = make(map[string]bool)
+	for fr := range sf.match(m).frames {
+		pt.highlight[display(fr)] = true
+	}
+}
+
+// displayNode returns name, prefixed with highlightMarker if it is marked.
+func (pt *pathTree) displayNode(name string) string {
+	if pt.highlight[name] {
+		return highlightMarker + name
+	}
+	return name
 }
 
 // aggregateFromRoot builds a path tree starting from the root of all stacks.
@@ -49,26 +94,32 @@ func aggregateFromRoot(sf *stackFile) *pathTree {
 	return pt
 }
 
-// aggregatePaths walks every stack in sf, finds frames matching method,
+// aggregatePaths walks the stacks in sf that contain a frame selected by m
 // and calls extract to get the path to aggregate. extract receives the
-// stack's frames slice and the index of the matched frame.
-func aggregatePaths(sf *stackFile, method string, extract func(frames []string, matchIdx int) []string) *pathTree {
+// stack's frames slice and the index of the first matched frame.
+func aggregatePaths(sf *stackFile, m methodMatcher, extract func(frames []string, matchIdx int) []string) *pathTree {
 	pt := &pathTree{
 		samples:      make(map[string]int),
 		selfSamples:  make(map[string]int),
 		matchedNames: make(map[string]bool),
+		matchSelf:    make(map[string]int),
 		totalSamples: sf.totalSamples,
 	}
 
-	for i := range sf.stacks {
+	fm := sf.match(m)
+	for _, i := range fm.stacks {
 		st := &sf.stacks[i]
 		for j, fr := range st.frames {
-			if matchesMethod(fr, method) {
+			if fm.frames[fr] {
 				pt.matchedNames[shortName(fr)] = true
 				path := extract(st.frames, j)
+				leaf := j == len(st.frames)-1
 				for depth := 1; depth <= len(path); depth++ {
 					key := strings.Join(path[:depth], ";")
 					pt.samples[key] += st.count
+					if leaf {
+						pt.matchSelf[key] += st.count
+					}
 				}
 				leafKey := strings.Join(path, ";")
 				pt.selfSamples[leafKey] += st.count
@@ -97,18 +148,24 @@ func (pt *pathTree) fprintTree(w io.Writer, sf *stackFile, method string, maxDep
 		fmt.Fprintf(w, "# matched %d methods: %s\n", len(pt.matchedNames), strings.Join(names, ", "))
 	}
 
-	roots := make(map[string]bool)
-	for key := range pt.samples {
-		roots[strings.SplitN(key, ";", 2)[0]] = true
+	sortedRoots := pt.roots()
+	if pt.totalsHeader {
+		matched := 0
+		for _, r := range sortedRoots {
+			matched += pt.samples[r]
+		}
+		fmt.Fprintf(w, "# %d samples in '%s' (%.*f%% of %d total)\n",
+			matched, method, pctDigits, pctOf(matched, pt.totalSamples), pt.totalSamples)
+	}
+
+	var shown map[string]bool
+	printable := 0
+	if pt.maxNodes > 0 {
+		shown, printable = pt.selectNodes(sortedRoots, maxDepth, minPct)
 	}
 
-	var walk func(prefix string, depth, indent int)
-	walk = func(prefix string, depth, indent int) {
+	line := func(prefix string, indent int) {
 		samples := pt.samples[prefix]
-		pct := pctOf(samples, pt.totalSamples)
-		if pct < minPct {
-			return
-		}
 		parts := strings.Split(prefix, ";")
 		name := parts[len(parts)-1]
 		pad := strings.Repeat("  ", indent)
@@ -117,59 +174,310 @@ func (pt *pathTree) fprintTree(w io.Writer, sf *stackFile, method string, maxDep
 			if selfCt := pt.selfSamples[prefix]; selfCt > 0 {
 				selfPct := pctOf(selfCt, pt.totalSamples)
 				if selfPct >= minPct {
-					selfSuffix = fmt.Sprintf("  ← self=%.1f%%", selfPct)
+					selfSuffix = fmt.Sprintf("  ← self=%.*f%%", pctDigits, selfPct)
 				}
 			}
 		}
-		fmt.Fprintf(w, "%s[%.1f%%] %s%s\n", pad, pct, name, selfSuffix)
+		inlinedSuffix := ""
+		if n := pt.inlined[prefix]; n > 0 {
+			inlinedSuffix = fmt.Sprintf(" [inlined %.0f%%]", pctOf(n, samples))
+		}
+		fmt.Fprintf(w, "%s[%.*f%%] %s%s%s\n", pad, pctDigits, pctOf(samples, pt.totalSamples), pt.displayNode(name), inlinedSuffix, selfSuffix)
+	}
+	// chainChild returns the only child walk would print under prefix, or
+	// "" when it would print none or several.
+	chainChild := func(prefix string, depth int) string {
 		if depth >= maxDepth {
+			return ""
+		}
+		only := ""
+		for _, c := range pt.treeChildren(prefix) {
+			if pctOf(pt.samples[c], pt.totalSamples) < minPct {
+				continue
+			}
+			if only != "" || (shown != nil && !shown[c]) {
+				return ""
+			}
+			only = c
+		}
+		return only
+	}
+
+	// inChain is set when prefix continues a chain an ancestor already
+	// measured for elision.
+	var walk func(prefix string, depth, indent int, inChain bool)
+	walk = func(prefix string, depth, indent int, inChain bool) {
+		if pctOf(pt.samples[prefix], pt.totalSamples) < minPct {
 			return
 		}
-		type child struct {
-			key     string
-			samples int
+		if !fullStacks && !inChain {
+			chain := []string{prefix}
+			for c := chainChild(prefix, depth); c != ""; c = chainChild(c, depth+len(chain)-1) {
+				chain = append(chain, c)
+			}
+			if len(chain) > elideAbove {
+				for i, c := range chain[:elideHead] {
+					line(c, indent+i)
+				}
+				fprintElided(w, strings.Repeat("  ", indent+elideHead), len(chain)-elideHead-elideTail)
+				tail := len(chain) - elideTail
+				walk(chain[tail], depth+tail, indent+elideHead+1, true)
+				return
+			}
+		}
+		line(prefix, indent)
+		if depth >= maxDepth {
+			return
 		}
-		var children []child
-		pfx := prefix + ";"
-		for key, cnt := range pt.samples {
-			if strings.HasPrefix(key, pfx) && strings.Count(key, ";") == strings.Count(prefix, ";")+1 {
-				children = append(children, child{key, cnt})
+		var printed []string
+		elided, elidedSamples := 0, 0
+		for _, c := range pt.treeChildren(prefix) {
+			if pctOf(pt.samples[c], pt.totalSamples) < minPct {
+				continue
 			}
+			if shown != nil && !shown[c] {
+				elided++
+				elidedSamples += pt.samples[c]
+				continue
+			}
+			printed = append(printed, c)
+		}
+		for _, c := range printed {
+			walk(c, depth+1, indent+1, len(printed) == 1 && elided == 0)
 		}
-		sort.Slice(children, func(i, j int) bool { return children[i].samples > children[j].samples })
-		for _, c := range children {
-			walk(c.key, depth+1, indent+1)
+		if elided > 0 {
+			fmt.Fprintf(w, "%s  … %d more (%.*f%%)\n", strings.Repeat("  ", indent), elided, pctDigits, pctOf(elidedSamples, pt.totalSamples))
 		}
 	}
 
-	sortedRoots := make([]string, 0, len(roots))
-	for r := range roots {
-		sortedRoots = append(sortedRoots, r)
-	}
-	sort.Strings(sortedRoots)
 	for _, root := range sortedRoots {
-		walk(root, 1, 0)
+		if shown == nil || shown[root] {
+			walk(root, 1, 0, false)
+		}
+		if pt.rootTotals {
+			total, self := pt.samples[root], pt.matchSelf[root]
+			fmt.Fprintf(w, "# %s total: %d samples (%.*f%%), self %d (%.*f%%)\n", root,
+				total, pctDigits, pctOf(total, pt.totalSamples), self, pctDigits, pctOf(self, pt.totalSamples))
+		}
+	}
+	if shown != nil && len(shown) < printable {
+		fmt.Fprintf(w, "(%d of %d nodes shown; raise --max-nodes for more)\n", len(shown), printable)
 	}
 }
 
-// buildTreePT aggregates a downward call tree for the given method.
-// If method is empty, builds a root tree of all stacks.
-func buildTreePT(sf *stackFile, method string) *pathTree {
-	if method == "" {
-		return aggregateFromRoot(sf)
+// roots returns the top-level node keys, sorted by name.
+func (pt *pathTree) roots() []string {
+	seen := make(map[string]bool)
+	var roots []string
+	for key := range pt.samples {
+		root, _, _ := strings.Cut(key, ";")
+		if !seen[root] {
+			seen[root] = true
+			roots = append(roots, root)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// flameNode is one node of the nested {name, value, children} JSON read by
+// d3-flamegraph, speedscope and similar front-ends. value includes the
+// children, so a node's self time is what its children leave uncovered.
+type flameNode struct {
+	Name     string       `json:"name"`
+	Value    int          `json:"value"`
+	URL      string       `json:"url,omitempty"` // --repo-url
+	Children []*flameNode `json:"children"`
+}
+
+// flameTree converts the tree under a single root named rootName, keeping
+// the nodes the text output would print (maxDepth, minPct, maxNodes).
+func (pt *pathTree) flameTree(rootName string, maxDepth int, minPct float64) *flameNode {
+	roots := pt.roots()
+	var shown map[string]bool
+	if pt.maxNodes > 0 {
+		shown, _ = pt.selectNodes(roots, maxDepth, minPct)
+	}
+	keep := func(key string) bool {
+		return (shown == nil || shown[key]) && pctOf(pt.samples[key], pt.totalSamples) >= minPct
 	}
-	return aggregatePaths(sf, method, func(frames []string, j int) []string {
-		path := make([]string, len(frames)-j)
-		for k := j; k < len(frames); k++ {
-			path[k-j] = shortName(frames[k])
+	var build func(key string, depth int) *flameNode
+	build = func(key string, depth int) *flameNode {
+		n := &flameNode{Name: key[strings.LastIndexByte(key, ';')+1:], Value: pt.samples[key], Children: []*flameNode{}}
+		if depth < maxDepth {
+			for _, c := range pt.treeChildren(key) {
+				if keep(c) {
+					n.Children = append(n.Children, build(c, depth+1))
+				}
+			}
 		}
-		return path
+		return n
+	}
+	top := &flameNode{Name: rootName, Children: []*flameNode{}}
+	for _, r := range roots {
+		top.Value += pt.samples[r]
+		if keep(r) {
+			top.Children = append(top.Children, build(r, 1))
+		}
+	}
+	return top
+}
+
+// treeChildren returns the keys of the direct children of prefix, heaviest
+// first, with ties broken by key.
+func (pt *pathTree) treeChildren(prefix string) []string {
+	var children []string
+	pfx := prefix + ";"
+	depth := strings.Count(prefix, ";") + 1
+	for key := range pt.samples {
+		if strings.HasPrefix(key, pfx) && strings.Count(key, ";") == depth {
+			children = append(children, key)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if pt.samples[children[i]] != pt.samples[children[j]] {
+			return pt.samples[children[i]] > pt.samples[children[j]]
+		}
+		return children[i] < children[j]
 	})
+	return children
+}
+
+// selectNodes picks at most pt.maxNodes of the printable nodes (within
+// maxDepth and at or above minPct) by always expanding the heaviest node
+// on the frontier, so the kept nodes form a connected tree of the hottest
+// paths. It returns the kept keys and the number of printable nodes.
+func (pt *pathTree) selectNodes(roots []string, maxDepth int, minPct float64) (map[string]bool, int) {
+	printable := func(key string) bool { return pctOf(pt.samples[key], pt.totalSamples) >= minPct }
+
+	var count func(key string, depth int) int
+	count = func(key string, depth int) int {
+		n := 1
+		if depth < maxDepth {
+			for _, c := range pt.treeChildren(key) {
+				if printable(c) {
+					n += count(c, depth+1)
+				}
+			}
+		}
+		return n
+	}
+	total := 0
+	frontier := &treeNodeHeap{samples: pt.samples}
+	for _, r := range roots {
+		if printable(r) {
+			total += count(r, 1)
+			heap.Push(frontier, treeNode{key: r, depth: 1})
+		}
+	}
+
+	shown := make(map[string]bool)
+	for frontier.Len() > 0 && len(shown) < pt.maxNodes {
+		n := heap.Pop(frontier).(treeNode)
+		shown[n.key] = true
+		if n.depth >= maxDepth {
+			continue
+		}
+		for _, c := range pt.treeChildren(n.key) {
+			if printable(c) {
+				heap.Push(frontier, treeNode{key: c, depth: n.depth + 1})
+			}
+		}
+	}
+	return shown, total
+}
+
+type treeNode struct {
+	key   string
+	depth int
+}
+
+// treeNodeHeap is a max-heap of tree nodes by samples, ties broken by key.
+type treeNodeHeap struct {
+	nodes   []treeNode
+	samples map[string]int
 }
 
-// buildCallersPT aggregates an upward callers tree for the given method.
-func buildCallersPT(sf *stackFile, method string) *pathTree {
-	return aggregatePaths(sf, method, func(frames []string, j int) []string {
+func (h *treeNodeHeap) Len() int { return len(h.nodes) }
+func (h *treeNodeHeap) Less(i, j int) bool {
+	si, sj := h.samples[h.nodes[i].key], h.samples[h.nodes[j].key]
+	if si != sj {
+		return si > sj
+	}
+	return h.nodes[i].key < h.nodes[j].key
+}
+func (h *treeNodeHeap) Swap(i, j int) { h.nodes[i], h.nodes[j] = h.nodes[j], h.nodes[i] }
+func (h *treeNodeHeap) Push(x any)    { h.nodes = append(h.nodes, x.(treeNode)) }
+func (h *treeNodeHeap) Pop() any {
+	n := h.nodes[len(h.nodes)-1]
+	h.nodes = h.nodes[:len(h.nodes)-1]
+	return n
+}
+
+// buildTreePT aggregates a downward call tree for the methods selected by m.
+// If the pattern is empty, builds a root tree of all stacks.
+// Stacks carrying frame details (tree --inlined) also get per-node inlined
+// counts.
+func buildTreePT(sf *stackFile, m methodMatcher) *pathTree {
+	var pt *pathTree
+	if m.pattern == "" {
+		pt = aggregateFromRoot(sf)
+	} else {
+		pt = aggregatePaths(sf, m, func(frames []string, j int) []string {
+			path := make([]string, len(frames)-j)
+			for k := j; k < len(frames); k++ {
+				path[k-j] = shortName(frames[k])
+			}
+			return path
+		})
+	}
+	pt.countInlined(sf, m)
+	return pt
+}
+
+// countInlined counts, for every node of the downward tree built by
+// buildTreePT, the samples in which the node's frame was inlined into its
+// caller by the JIT. It does nothing for stacks without frame details.
+func (pt *pathTree) countInlined(sf *stackFile, m methodMatcher) {
+	var fm frameMatch
+	if m.pattern != "" {
+		fm = sf.match(m)
+	}
+	for i := range sf.stacks {
+		st := &sf.stacks[i]
+		if st.details == nil {
+			continue
+		}
+		start := 0
+		if m.pattern != "" {
+			start = -1
+			for j, fr := range st.frames {
+				if fm.frames[fr] {
+					start = j
+					break
+				}
+			}
+			if start < 0 {
+				continue
+			}
+		}
+		path := make([]string, 0, len(st.frames)-start)
+		for k := start; k < len(st.frames); k++ {
+			path = append(path, shortName(st.frames[k]))
+			if st.details[k].kind != frameKindInlined {
+				continue
+			}
+			if pt.inlined == nil {
+				pt.inlined = make(map[string]int)
+			}
+			pt.inlined[strings.Join(path, ";")] += st.count
+		}
+	}
+}
+
+// buildCallersPT aggregates an upward callers tree for the methods selected by m.
+func buildCallersPT(sf *stackFile, m methodMatcher) *pathTree {
+	return aggregatePaths(sf, m, func(frames []string, j int) []string {
 		path := make([]string, j+1)
 		for k := 0; k <= j; k++ {
 			path[j-k] = shortName(frames[k])
@@ -178,6 +486,23 @@ func buildCallersPT(sf *stackFile, method string) *pathTree {
 	})
 }
 
+// relativeTo returns sf with its total set to the samples of the stacks m
+// selects, so tree and callers percentages read the matched method as 100%.
+// sf is returned unchanged when m selects everything or nothing.
+func (sf *stackFile) relativeTo(m methodMatcher) *stackFile {
+	if m.pattern == "" {
+		return sf
+	}
+	matched := 0
+	for _, i := range sf.match(m).stacks {
+		matched += sf.stacks[i].count
+	}
+	if matched == 0 {
+		return sf
+	}
+	return &stackFile{stacks: sf.stacks, totalSamples: matched, relative: true}
+}
+
 // treeDisplayMethod returns the display string for tree headers.
 func treeDisplayMethod(method string) string {
 	if method == "" {
@@ -192,7 +517,7 @@ func computeTreeString(sf *stackFile, method string, maxDepth int, minPct float6
 	if sf.totalSamples == 0 {
 		return ""
 	}
-	pt := buildTreePT(sf, method)
+	pt := buildTreePT(sf, substringMatcher(method))
 	var buf strings.Builder
 	pt.fprintTree(&buf, sf, treeDisplayMethod(method), maxDepth, minPct, true)
 	return strings.TrimRight(buf.String(), "\n")
@@ -203,8 +528,63 @@ func computeCallersString(sf *stackFile, method string, maxDepth int, minPct flo
 	if sf.totalSamples == 0 {
 		return ""
 	}
-	pt := buildCallersPT(sf, method)
+	pt := buildCallersPT(sf, substringMatcher(method))
 	var buf strings.Builder
 	pt.fprintTree(&buf, sf, method, maxDepth, minPct, false)
 	return strings.TrimRight(buf.String(), "\n")
 }
+
+// buildTreePTByThread builds the tree for m separately for each thread group
+// (the same grouping as `threads --group`) and nests each one under a
+// "[group]" root, so a method running in several pools shows each pool's
+// share. Stacks without thread info go under "[(no thread info)]".
+func buildTreePTByThread(sf *stackFile, m methodMatcher) *pathTree {
+	ranked, _, _ := computeThreads(sf)
+	assignments := assignGroups(ranked)
+
+	byGroup := make(map[string]*stackFile)
+	for i := range sf.stacks {
+		st := &sf.stacks[i]
+		group := "(no thread info)"
+		if st.thread != "" {
+			group = assignments[st.thread]
+		}
+		g := byGroup[group]
+		if g == nil {
+			// Keep the overall total so percentages stay relative to the profile.
+			g = &stackFile{totalSamples: sf.totalSamples}
+			byGroup[group] = g
+		}
+		g.stacks = append(g.stacks, *st)
+	}
+
+	pt := &pathTree{
+		samples:      make(map[string]int),
+		selfSamples:  make(map[string]int),
+		matchedNames: make(map[string]bool),
+		totalSamples: sf.totalSamples,
+	}
+	for group, g := range byGroup {
+		sub := buildTreePT(g, m)
+		root := "[" + group + "]"
+		for key, n := range sub.samples {
+			pt.samples[root+";"+key] += n
+			if !strings.Contains(key, ";") {
+				pt.samples[root] += n
+			}
+		}
+		for key, n := range sub.selfSamples {
+			pt.selfSamples[root+";"+key] += n
+		}
+		for key, n := range sub.inlined {
+			if pt.inlined == nil {
+				pt.inlined = make(map[string]int)
+			}
+			pt.inlined[root+";"+key] += n
+		}
+		for name := range sub.matchedNames {
+			pt.matchedNames[name] = true
+		}
+	}
+	return pt
+}
@@ -81,7 +81,7 @@ func parsePprofData(path string, stackEvents map[string]struct{}) (*parsedProfil
 	// profile.Parse handles gzip detection internally.
 	prof, err := profile.Parse(f)
 	if err != nil {
-		return nil, fmt.Errorf("pprof parse: %w", err)
+		return nil, readError(fmt.Errorf("pprof parse: %w", err))
 	}
 
 	return buildParsedProfile(prof, stackEvents)
@@ -90,7 +90,7 @@ func parsePprofData(path string, stackEvents map[string]struct{}) (*parsedProfil
 func parsePprofFromReader(r io.Reader, stackEvents map[string]struct{}) (*parsedProfile, error) {
 	prof, err := profile.Parse(r)
 	if err != nil {
-		return nil, fmt.Errorf("pprof parse: %w", err)
+		return nil, readError(fmt.Errorf("pprof parse: %w", err))
 	}
 	return buildParsedProfile(prof, stackEvents)
 }
@@ -168,11 +168,25 @@ func buildParsedProfile(prof *profile.Profile, stackEvents map[string]struct{})
 		spanNanos = prof.DurationNanos
 	}
 
+	// cpu weights are either nanoseconds already or sample counts taken
+	// every Period nanoseconds.
+	var cpuInterval int64
+	if m, ok := mappings["cpu"]; ok && m.valueIdx < len(prof.SampleType) {
+		pt := prof.PeriodType
+		switch {
+		case strings.ToLower(prof.SampleType[m.valueIdx].Unit) == "nanoseconds":
+			cpuInterval = 1
+		case pt != nil && strings.ToLower(pt.Unit) == "nanoseconds" && prof.Period > 0:
+			cpuInterval = prof.Period
+		}
+	}
+
 	return &parsedProfile{
 		eventCounts:   eventCounts,
 		stacksByEvent: stacksByEvent,
 		timedEvents:   nil, // pprof has no per-sample timestamps
 		spanNanos:     spanNanos,
+		cpuInterval:   cpuInterval,
 	}, nil
 }
 
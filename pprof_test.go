@@ -69,7 +69,7 @@ func TestPprofHot(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdHot(sf, 20, false, 0)
+		cmdHot(sf, 20, false, false, 0)
 	})
 
 	// Verify output has some content.
@@ -95,7 +95,7 @@ func TestPprofTree(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdTree(sf, "", 6, 0.1)
+		cmdTree(sf, substringMatcher(""), 6, 0.1, false, 0)
 	})
 
 	if len(strings.TrimSpace(out)) == 0 {
@@ -123,7 +123,7 @@ func TestPprofCallers(t *testing.T) {
 	method := ranked[0].name
 
 	out := captureOutput(func() {
-		cmdCallers(sf, method, 4, 0.1)
+		cmdCallers(sf, substringMatcher(method), 4, 0.1, false, 0, false)
 	})
 
 	if len(strings.TrimSpace(out)) == 0 {
@@ -150,7 +150,7 @@ func TestPprofTrace(t *testing.T) {
 	method := ranked[0].name
 
 	out := captureOutput(func() {
-		cmdTrace(sf, method, 0.1, true)
+		cmdTrace(sf, substringMatcher(method), 0.1, true, false)
 	})
 
 	if len(strings.TrimSpace(out)) == 0 {
@@ -180,7 +180,7 @@ func TestPprofAllocProfile(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdHot(sf, 10, false, 0)
+		cmdHot(sf, 10, false, false, 0)
 	})
 
 	if len(strings.TrimSpace(out)) == 0 {
@@ -232,7 +232,7 @@ func TestPprofLockProfile(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdHot(sf, 10, false, 0)
+		cmdHot(sf, 10, false, false, 0)
 	})
 
 	if len(strings.TrimSpace(out)) == 0 {
@@ -261,7 +261,7 @@ func TestPprofDiff(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdDiff(bSF, aSF, 0.1, 0, false)
+		cmdDiff(bSF, aSF, 0.1, 0, false, nil)
 	})
 
 	// Output should be non-empty (either changes or "no significant changes").
@@ -287,7 +287,7 @@ func TestPprofMixedDiff(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdDiff(bSF, aSF, 0.1, 0, false)
+		cmdDiff(bSF, aSF, 0.1, 0, false, nil)
 	})
 
 	if len(strings.TrimSpace(out)) == 0 {
@@ -319,7 +319,7 @@ func TestPprofLines(t *testing.T) {
 	method := ranked[0].name
 
 	out := captureOutput(func() {
-		cmdLines(sf, method, 10, true)
+		cmdLines(sf, substringMatcher(method), 10, true)
 	})
 
 	// pprof profiles from Go include line numbers, so output should have them.
@@ -345,7 +345,7 @@ func TestPprofFilter(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdFilter(sf, "pprofBusy", false)
+		cmdFilter(sf, substringMatcher("pprofBusy"), false, filterThreadsAsIs)
 	})
 
 	if len(strings.TrimSpace(out)) == 0 {
@@ -407,10 +407,16 @@ func TestPprofEvents(t *testing.T) {
 	}
 }
 
-func TestPprofEventsCollapsedRejected(t *testing.T) {
-	err := cmdEvents("stacks.txt")
-	if err == nil || !strings.Contains(err.Error(), "requires a JFR or pprof file") {
-		t.Errorf("expected rejection for collapsed text, got: %v", err)
+func TestPprofEventsCollapsed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stacks.txt")
+	os.WriteFile(path, []byte("A;B 2\n"), 0o644)
+	out := captureOutput(func() {
+		if err := cmdEvents(path); err != nil {
+			t.Error(err)
+		}
+	})
+	if !strings.Contains(out, "Input: collapsed text (no event types") {
+		t.Errorf("expected collapsed text summary, got:\n%s", out)
 	}
 }
 
@@ -1185,6 +1191,33 @@ func TestBuildParsedProfileDurationNanos(t *testing.T) {
 	}
 }
 
+func TestBuildParsedProfileCPUInterval(t *testing.T) {
+	tests := []struct {
+		name        string
+		sampleTypes []*pprofProfile.ValueType
+		periodType  *pprofProfile.ValueType
+		period      int64
+		want        int64
+	}{
+		{"cpu nanoseconds", []*pprofProfile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}}, &pprofProfile.ValueType{Type: "cpu", Unit: "nanoseconds"}, 10_000_000, 1},
+		{"sample count with period", []*pprofProfile.ValueType{{Type: "samples", Unit: "count"}}, &pprofProfile.ValueType{Type: "cpu", Unit: "nanoseconds"}, 10_000_000, 10_000_000},
+		{"sample count without period", []*pprofProfile.ValueType{{Type: "samples", Unit: "count"}}, nil, 0, 0},
+		{"no cpu", []*pprofProfile.ValueType{{Type: "alloc_space", Unit: "bytes"}}, &pprofProfile.ValueType{Type: "space", Unit: "bytes"}, 512, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prof := &pprofProfile.Profile{SampleType: tt.sampleTypes, PeriodType: tt.periodType, Period: tt.period}
+			parsed, err := buildParsedProfile(prof, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.cpuInterval != tt.want {
+				t.Errorf("cpuInterval=%d, want %d", parsed.cpuInterval, tt.want)
+			}
+		})
+	}
+}
+
 func TestBuildParsedProfileZeroNegativeValues(t *testing.T) {
 	// Samples with zero or negative values should be skipped.
 	fn := &pprofProfile.Function{ID: 1, Name: "f"}
@@ -1473,7 +1506,7 @@ func TestPprofThreadFilter(t *testing.T) {
 		t.Errorf("expected 100 samples for worker thread, got %d", filtered.totalSamples)
 	}
 	out := captureOutput(func() {
-		cmdHot(filtered, 10, false, 0)
+		cmdHot(filtered, 10, false, false, 0)
 	})
 	if !strings.Contains(out, "worker.run") {
 		t.Errorf("expected worker.run in filtered output, got:\n%s", out)
@@ -1500,7 +1533,7 @@ func TestPprofHotFQN(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdHot(sf, 20, true, 0)
+		cmdHot(sf, 20, true, false, 0)
 	})
 
 	if len(strings.TrimSpace(out)) == 0 {
@@ -1637,7 +1670,7 @@ func TestPprofDiffAlloc(t *testing.T) {
 	}
 
 	out := captureOutput(func() {
-		cmdDiff(bSF, aSF, 0.1, 0, false)
+		cmdDiff(bSF, aSF, 0.1, 0, false, nil)
 	})
 
 	if len(strings.TrimSpace(out)) == 0 {
@@ -1667,15 +1700,15 @@ func TestPprofLargeProfile(t *testing.T) {
 	t.Logf("large profile: %d samples, %d unique stacks", sf.totalSamples, len(sf.stacks))
 
 	// All commands should handle large data without panicking.
-	captureOutput(func() { cmdHot(sf, 50, false, 0) })
-	captureOutput(func() { cmdTree(sf, "", 10, 0.01) })
+	captureOutput(func() { cmdHot(sf, 50, false, false, 0) })
+	captureOutput(func() { cmdTree(sf, substringMatcher(""), 10, 0.01, false, 0) })
 	captureOutput(func() { cmdCollapse(sf) })
 
 	ranked := computeHot(sf, true)
 	if len(ranked) > 0 {
-		captureOutput(func() { cmdCallers(sf, ranked[0].name, 10, 0.01) })
-		captureOutput(func() { cmdTrace(sf, ranked[0].name, 0.01, true) })
-		captureOutput(func() { cmdLines(sf, ranked[0].name, 20, true) })
+		captureOutput(func() { cmdCallers(sf, substringMatcher(ranked[0].name), 10, 0.01, false, 0, false) })
+		captureOutput(func() { cmdTrace(sf, substringMatcher(ranked[0].name), 0.01, true, false) })
+		captureOutput(func() { cmdLines(sf, substringMatcher(ranked[0].name), 20, true) })
 	}
 }
 
@@ -2162,14 +2195,14 @@ func TestPprofCLIEventsStdin(t *testing.T) {
 	}
 }
 
-func TestPprofCLIEventsStdinCollapsedRejected(t *testing.T) {
+func TestPprofCLIEventsStdinCollapsed(t *testing.T) {
 	input := "A;B;C 10\n"
-	exitCode, _, stderr := runCLIForTest(t, []string{"events", "-"}, strings.NewReader(input))
-	if exitCode == 0 {
-		t.Fatal("expected non-zero exit for collapsed stdin with events command")
+	exitCode, stdout, stderr := runCLIForTest(t, []string{"events", "-"}, strings.NewReader(input))
+	if exitCode != 0 {
+		t.Fatalf("exit %d; stderr: %s", exitCode, stderr)
 	}
-	if !strings.Contains(stderr, "collapsed") {
-		t.Errorf("expected error mentioning collapsed text, got:\n%s", stderr)
+	if want := "Input: collapsed text (no event types; analyzed as a single event)\nStacks: 1  Samples: 10\n"; stdout != want {
+		t.Errorf("events on collapsed stdin:\n%s\nwant:\n%s", stdout, want)
 	}
 }
 
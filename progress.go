@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/grafana/jfr-parser/parser"
+)
+
+// progressMinBytes is the smallest (decompressed) JFR size that gets a
+// progress line; smaller files parse fast enough not to need one.
+const progressMinBytes = 256 << 20
+
+// progressCheckEvery is how many events pass between clock checks, keeping
+// time.Now out of the per-event hot path.
+const progressCheckEvery = 1 << 16
+
+// parseProgress prints a self-overwriting status line on stderr while a
+// large JFR is parsed. A nil *parseProgress is valid and does nothing.
+type parseProgress struct {
+	w          io.Writer
+	totalBytes int64
+	start      time.Time
+	last       time.Time
+	interval   time.Duration
+	printed    bool
+}
+
+// newParseProgress returns a reporter when enabled is set, stderr is a
+// terminal and the input is at least progressMinBytes, otherwise nil.
+func newParseProgress(enabled bool, totalBytes int64) *parseProgress {
+	if !enabled || totalBytes < progressMinBytes || !isTerminal(os.Stderr) {
+		return nil
+	}
+	now := time.Now()
+	return &parseProgress{
+		w:          os.Stderr,
+		totalBytes: totalBytes,
+		start:      now,
+		last:       now,
+		interval:   500 * time.Millisecond,
+	}
+}
+
+// chunkStartOffsets maps each chunk's StartNanos to its byte offset in buf,
+// so the parser's current chunk header can be turned into bytes parsed.
+func chunkStartOffsets(buf []byte) map[uint64]int64 {
+	offsets := make(map[uint64]int64)
+	pos := 0
+	for pos+jfrChunkHeaderSize <= len(buf) && binary.BigEndian.Uint32(buf[pos:]) == jfrChunkMagic {
+		offsets[binary.BigEndian.Uint64(buf[pos+32:])] = int64(pos)
+		size64 := int64(binary.BigEndian.Uint64(buf[pos+8:]))
+		if size64 <= 0 || size64 > int64(len(buf))-int64(pos) {
+			break
+		}
+		pos += int(size64)
+	}
+	return offsets
+}
+
+// chunkBytesDone estimates the bytes parsed when the parser is at an event
+// recorded at eventTicks in the chunk hdr starting at offset start. The
+// parser does not expose its position, so the chunk's bytes are taken to
+// fill up evenly over its duration; events are written roughly in time
+// order, which keeps a single large chunk from sitting at 0% until the end.
+func chunkBytesDone(start int64, hdr parser.ChunkHeader, eventTicks uint64) int64 {
+	if eventTicks <= hdr.StartTicks || hdr.DurationNanos == 0 {
+		return start
+	}
+	frac := float64(ticksDurationNanos(eventTicks-hdr.StartTicks, hdr.TicksPerSecond)) / float64(hdr.DurationNanos)
+	return start + int64(min(frac, 1)*float64(hdr.Size))
+}
+
+// update reports bytes parsed so far and events seen.
+func (p *parseProgress) update(bytesDone int64, events int) {
+	if p == nil {
+		return
+	}
+	now := time.Now()
+	if now.Sub(p.last) < p.interval {
+		return
+	}
+	p.last = now
+	p.printed = true
+	fmt.Fprintf(p.w, "\r\x1b[Kparsing: %s / %s (%.0f%%), %d events, %s",
+		formatBytes(bytesDone), formatBytes(p.totalBytes),
+		100*float64(bytesDone)/float64(p.totalBytes), events,
+		now.Sub(p.start).Round(100*time.Millisecond))
+}
+
+// done clears the status line so regular output starts on a clean line.
+func (p *parseProgress) done() {
+	if p == nil || !p.printed {
+		return
+	}
+	fmt.Fprint(p.w, "\r\x1b[K")
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
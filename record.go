@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newRecordCmd() *cobra.Command {
+	var opts recordOpts
+	cmd := &cobra.Command{
+		Use:   "record [flags] -- <command> [args...] | record --k8s <pod> | --ssh <host> [flags]",
+		Short: "Record a profile (launch a JVM, or attach in a Kubernetes pod or over SSH), then run info",
+		Long: `Launch a command with the async-profiler agent loaded from JVM startup,
+so the recording covers the whole lifetime: class loading, JIT warmup and
+shutdown. When the command exits, the recording is analyzed with info.
+
+If the command is java itself, -agentpath is inserted as its first JVM
+option. Anything else (mvn, gradle, a launcher script) gets the agent via
+JAVA_TOOL_OPTIONS, which every JVM it starts picks up; use a %p in -o to
+give each JVM its own file.
+
+Ctrl-C is passed to the command; ap-query waits for it to exit and still
+analyzes the recording.
+
+With --k8s or --ssh, ap-query instead attaches to a running JVM: it runs
+asprof inside the container (kubectl exec) or on the host (ssh, optionally
+through --jump) for --duration seconds, copies the JFR back, removes the
+remote copy and analyzes it locally. asprof must already be on the target
+(--remote-asprof names it) unless --push uploads the Linux release matching
+the target's architecture to ` + remoteAsprofDir + `. The JVM is PID 1
+unless --pid says otherwise.
+
+Examples:
+  ap-query record -- java -jar app.jar
+  ap-query record -e wall -o startup.jfr -- java -cp app.jar com.example.Main
+  ap-query record --no-analyze -o build.jfr -- ./gradlew test
+  ap-query record --k8s pod/my-app-7c9f --container app -d 30
+  ap-query record --k8s deploy/my-app -n prod -e wall --remote-asprof /opt/async-profiler/bin/asprof
+  ap-query record --ssh app-01 --jump bastion --push --pid 4242 -d 60`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.k8s != "" || opts.ssh != "" {
+				if opts.k8s != "" && opts.ssh != "" {
+					return fmt.Errorf("--k8s and --ssh are mutually exclusive")
+				}
+				if len(args) > 0 {
+					return fmt.Errorf("--k8s and --ssh attach to a running JVM and take no command")
+				}
+				if opts.k8s != "" {
+					return cmdRecordRemote(k8sTarget{resource: opts.k8s, container: opts.container, namespace: opts.namespace}, opts)
+				}
+				if strings.HasPrefix(opts.ssh, "-") || strings.HasPrefix(opts.jump, "-") {
+					return fmt.Errorf("--ssh and --jump take a host, not ssh options")
+				}
+				return cmdRecordRemote(sshTarget{host: opts.ssh, jump: opts.jump}, opts)
+			}
+			if len(args) == 0 || cmd.ArgsLenAtDash() != 0 {
+				return fmt.Errorf("separate the command with --, e.g. ap-query record -- java -jar app.jar")
+			}
+			return cmdRecord(args, opts)
+		},
+	}
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "", "Recording file (default ap-query-<timestamp>.jfr)")
+	cmd.Flags().StringVarP(&opts.event, "event", "e", "cpu", "Profiling event: cpu, wall, alloc, lock, itimer, or a Java method")
+	cmd.Flags().StringVarP(&opts.interval, "interval", "i", "", "Sampling interval, e.g. 1ms (default: async-profiler's)")
+	cmd.Flags().StringVar(&opts.lib, "lib", "", "Path to libasyncProfiler (default: next to asprof)")
+	cmd.Flags().StringVar(&opts.asprof, "asprof", "", "Path to asprof binary, used to locate the agent library")
+	cmd.Flags().BoolVar(&opts.noAnalyze, "no-analyze", false, "Only record; do not run info on exit")
+	cmd.Flags().StringVar(&opts.k8s, "k8s", "", "Attach to a JVM in a Kubernetes pod (pod/NAME, deploy/NAME, ...)")
+	cmd.Flags().StringVarP(&opts.container, "container", "c", "", "Container in the pod (--k8s; default: kubectl's)")
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", "", "Namespace of the pod (--k8s; default: kubectl's)")
+	cmd.Flags().StringVar(&opts.ssh, "ssh", "", "Attach to a JVM on a host reachable with ssh ([user@]host)")
+	cmd.Flags().StringVar(&opts.jump, "jump", "", "Bastion to reach the --ssh host through (ssh -J)")
+	cmd.Flags().BoolVar(&opts.push, "push", false, "Upload async-profiler to the target instead of using --remote-asprof")
+	cmd.Flags().IntVarP(&opts.duration, "duration", "d", 30, "Seconds to record when attaching")
+	cmd.Flags().StringVar(&opts.pid, "pid", "1", "PID of the JVM on the target when attaching")
+	cmd.Flags().StringVar(&opts.remoteAsprof, "remote-asprof", "asprof", "asprof command on the target when attaching")
+	return cmd
+}
+
+type recordOpts struct {
+	output    string
+	event     string
+	interval  string
+	lib       string
+	asprof    string
+	noAnalyze bool
+
+	// Attach mode.
+	k8s          string
+	container    string
+	namespace    string
+	ssh          string
+	jump         string
+	push         bool
+	duration     int
+	pid          string
+	remoteAsprof string
+}
+
+func cmdRecord(command []string, opts recordOpts) error {
+	lib, err := resolveAgentLib(opts.lib, opts.asprof)
+	if err != nil {
+		return err
+	}
+	output, err := recordOutput(opts.output)
+	if err != nil {
+		return err
+	}
+	agent, err := agentOptions(output, opts.event, opts.interval)
+	if err != nil {
+		return err
+	}
+	agentPath := "-agentpath:" + lib + "=" + agent
+
+	argv, env := launchWithAgent(command, agentPath, os.Environ())
+	child := exec.Command(argv[0], argv[1:]...)
+	child.Env = env
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+
+	// The terminal delivers Ctrl-C to the child as well; catching it here
+	// keeps ap-query alive to analyze whatever the child recorded.
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	fmt.Fprintf(os.Stderr, "Recording %s to %s\n", opts.event, output)
+	runErr := child.Run()
+	var exitErr *exec.ExitError
+	if runErr != nil && !errors.As(runErr, &exitErr) {
+		return ioErrorf("cannot start %s: %v", argv[0], runErr)
+	}
+	if exitErr != nil {
+		fmt.Fprintf(os.Stderr, "%s exited with status %d\n", argv[0], exitErr.ExitCode())
+	}
+
+	if strings.Contains(output, "%") {
+		// Per-process file names: nothing single to analyze.
+		fmt.Fprintf(os.Stderr, "Recordings: %s\n", output)
+		return nil
+	}
+	if _, err := os.Stat(output); err != nil {
+		return ioErrorf("no recording at %s: the JVM did not start or did not load the agent", output)
+	}
+	return analyzeRecording(output, opts.noAnalyze)
+}
+
+// analyzeRecording reports where the recording went and runs info on it.
+func analyzeRecording(output string, noAnalyze bool) error {
+	fmt.Fprintf(os.Stderr, "Recording: %s\n", output)
+	if noAnalyze {
+		return nil
+	}
+	fmt.Println()
+	info := newInfoCmd()
+	info.SetArgs([]string{output})
+	info.SilenceUsage = true
+	info.SilenceErrors = true
+	return info.Execute()
+}
+
+// recordOutput returns the absolute recording path, defaulting to a
+// timestamped file in the working directory.
+func recordOutput(output string) (string, error) {
+	if output == "" {
+		output = "ap-query-" + time.Now().Format("20060102-150405") + ".jfr"
+	}
+	abs, err := filepath.Abs(output)
+	if err != nil {
+		return "", ioErrorf("%v", err)
+	}
+	return abs, nil
+}
+
+// agentOptions builds the async-profiler agent argument string. The agent
+// splits its options on commas, so the file name cannot contain one.
+func agentOptions(output, event, interval string) (string, error) {
+	if strings.Contains(output, ",") {
+		return "", fmt.Errorf("output path %q must not contain a comma", output)
+	}
+	if event == "" {
+		return "", fmt.Errorf("--event must not be empty")
+	}
+	agent := "start,event=" + event
+	if interval != "" {
+		agent += ",interval=" + interval
+	}
+	return agent + ",file=" + output, nil
+}
+
+// launchWithAgent returns the command line and environment that load the
+// agent: a java command gets -agentpath as its first option, anything else
+// inherits it through JAVA_TOOL_OPTIONS.
+func launchWithAgent(command []string, agentPath string, env []string) ([]string, []string) {
+	name := strings.TrimSuffix(filepath.Base(command[0]), ".exe")
+	if name == "java" {
+		argv := append([]string{command[0], agentPath}, command[1:]...)
+		return argv, env
+	}
+	const key = "JAVA_TOOL_OPTIONS="
+	out := make([]string, 0, len(env)+1)
+	toolOpts := agentPath
+	for _, kv := range env {
+		if v, ok := strings.CutPrefix(kv, key); ok {
+			if v != "" {
+				toolOpts = v + " " + agentPath
+			}
+			continue
+		}
+		out = append(out, kv)
+	}
+	return command, append(out, key+toolOpts)
+}
+
+// resolveAgentLib finds libasyncProfiler: an explicit --lib, else the lib
+// directory beside asprof (--asprof, PATH or the usual install dirs).
+func resolveAgentLib(lib, asprof string) (string, error) {
+	if lib != "" {
+		lib = expandPath(lib)
+		if _, err := os.Stat(lib); err != nil {
+			return "", usageErrorf("agent library not found at %s", lib)
+		}
+		return lib, nil
+	}
+	if asprof != "" {
+		asprof = expandPath(asprof)
+	} else {
+		asprof = findAsprof()
+	}
+	if asprof != "" {
+		if resolved, err := filepath.EvalSymlinks(asprof); err == nil {
+			asprof = resolved
+		}
+		candidate := filepath.Join(filepath.Dir(filepath.Dir(asprof)), "lib", agentLibName())
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", usageErrorf("async-profiler agent library (%s) not found\n  pass --lib PATH or --asprof PATH; ap-query init can download async-profiler", agentLibName())
+}
+
+func agentLibName() string {
+	if runtime.GOOS == "darwin" {
+		return "libasyncProfiler.dylib"
+	}
+	return "libasyncProfiler.so"
+}
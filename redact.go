@@ -0,0 +1,614 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/grafana/jfr-parser/parser/types/def"
+)
+
+const redactUsage = "Replace frames matching GLOB (e.g. 'com.mycorp.*') with consistent pkgA.ClassB.method3 aliases and thread names with thread1, thread2, ...; repeatable, @FILE reads one glob per line"
+
+// redactor replaces frames matching --redact globs with consistent aliases:
+// the package becomes pkgA, pkgB, ..., the class ClassA, ClassB, ... and
+// the method method1, method2, ..., so "com.mycorp.svc.UserService.login"
+// turns into e.g. "pkgA.ClassB.method3" everywhere it appears. Methods are
+// numbered per class, so UserService.login and Repo.login get different
+// aliases. Thread names, which often carry customer or service names, all
+// become thread1, thread2, .... Aliases are assigned in sorted order of the
+// dotted original names, so the same profile is always redacted the same
+// way.
+type redactor struct {
+	globs   []*regexp.Regexp
+	aliases map[string]string // raw frame → redacted frame
+	pkgs    map[string]string // dotted package → pkgA
+	classes map[string]string // dotted package + "." + class → ClassA
+	methods map[string]string // dotted frame → method1
+	threads map[string]string // thread name → thread1
+}
+
+// newRedactor assigns aliases to the frames that match any of globs and to
+// every thread name. Globs match the dotted name (com.mycorp.*), whichever
+// separator the profile uses.
+func newRedactor(globs []*regexp.Regexp, frames, threads []string) *redactor {
+	r := &redactor{
+		globs:   globs,
+		aliases: make(map[string]string),
+		pkgs:    make(map[string]string),
+		classes: make(map[string]string),
+		methods: make(map[string]string),
+		threads: make(map[string]string),
+	}
+	type parts struct{ raw, dotted, pkg, class, method string }
+	var matched []parts
+	for _, fr := range frames {
+		dotted := strings.ReplaceAll(fr, "/", ".")
+		if _, seen := r.aliases[fr]; seen || !matchesAnyGlob(globs, dotted) {
+			continue
+		}
+		r.aliases[fr] = ""
+		p := parts{raw: fr, dotted: dotted, method: dotted}
+		if i := strings.LastIndexByte(dotted, '.'); i >= 0 {
+			p.class, p.method = dotted[:i], dotted[i+1:]
+			if j := strings.LastIndexByte(p.class, '.'); j >= 0 {
+				p.pkg, p.class = p.class[:j], p.class[j+1:]
+			}
+		}
+		matched = append(matched, p)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].dotted != matched[j].dotted {
+			return matched[i].dotted < matched[j].dotted
+		}
+		return matched[i].raw < matched[j].raw
+	})
+	for _, p := range matched {
+		name := nextAlias(r.methods, p.dotted, "method", false)
+		if p.class != "" {
+			name = r.class(p.pkg, p.class, ".") + "." + name
+		}
+		r.aliases[p.raw] = name
+	}
+
+	threads = slices.Clone(threads)
+	slices.Sort(threads)
+	for _, t := range slices.Compact(threads) {
+		if t != "" {
+			nextAlias(r.threads, t, "thread", false)
+		}
+	}
+	return r
+}
+
+// nextAlias returns the alias of key in m, assigning the next free one:
+// prefix followed by a number, or by letters (A, B, ..., AA) if letters.
+func nextAlias(m map[string]string, key, prefix string, letters bool) string {
+	if a, ok := m[key]; ok {
+		return a
+	}
+	n := len(m) + 1
+	a := fmt.Sprintf("%s%d", prefix, n)
+	if letters {
+		a = prefix + aliasLetters(n)
+	}
+	m[key] = a
+	return a
+}
+
+// class returns the alias of a class, after its package alias and sep.
+func (r *redactor) class(pkg, class, sep string) string {
+	name := nextAlias(r.classes, pkg+"."+class, "Class", true)
+	if pkg != "" {
+		name = nextAlias(r.pkgs, pkg, "pkg", true) + sep + name
+	}
+	return name
+}
+
+func matchesAnyGlob(globs []*regexp.Regexp, s string) bool {
+	for _, g := range globs {
+		if g.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// aliasLetters returns the spreadsheet-style column name of n (1 → A,
+// 26 → Z, 27 → AA).
+func aliasLetters(n int) string {
+	var b []byte
+	for ; n > 0; n = (n - 1) / 26 {
+		b = append([]byte{byte('A' + (n-1)%26)}, b...)
+	}
+	return string(b)
+}
+
+// summary describes what was redacted, for stderr.
+func (r *redactor) summary() string {
+	return fmt.Sprintf("Redacted %d frames (%d packages, %d classes, %d methods) and %d threads",
+		len(r.aliases), len(r.pkgs), len(r.classes), len(r.methods), len(r.threads))
+}
+
+func (r *redactor) frame(fr string) string {
+	if a, ok := r.aliases[fr]; ok {
+		return a
+	}
+	return fr
+}
+
+func (r *redactor) frames(frames []string) []string {
+	out := make([]string, len(frames))
+	for i, fr := range frames {
+		out[i] = r.frame(fr)
+	}
+	return out
+}
+
+func (r *redactor) thread(name string) string {
+	if a, ok := r.threads[name]; ok {
+		return a
+	}
+	return name
+}
+
+// newProfileRedactor builds the redactor for the frames and threads of sfs
+// and events.
+func newProfileRedactor(globs []*regexp.Regexp, sfs []*stackFile, events []timedEvent) *redactor {
+	var frames, threads []string
+	for _, sf := range sfs {
+		frames = append(frames, sf.index().frames...)
+		for _, st := range sf.stacks {
+			threads = append(threads, st.thread)
+		}
+	}
+	for _, e := range events {
+		frames = append(frames, e.frames...)
+		threads = append(threads, e.thread)
+	}
+	return newRedactor(globs, frames, threads)
+}
+
+// stackFile returns a copy of sf with redacted frames and threads.
+func (r *redactor) stackFile(sf *stackFile) *stackFile {
+	out := &stackFile{stacks: make([]stack, len(sf.stacks)), totalSamples: sf.totalSamples}
+	for i, st := range sf.stacks {
+		st.frames = r.frames(st.frames)
+		st.thread = r.thread(st.thread)
+		out.stacks[i] = st
+	}
+	return out
+}
+
+// events returns a copy of events with redacted frames and threads.
+func (r *redactor) events(events []timedEvent) []timedEvent {
+	out := make([]timedEvent, len(events))
+	for i, e := range events {
+		e.frames = r.frames(e.frames)
+		e.thread = r.thread(e.thread)
+		e.stackKey = buildStackKeyWithLines(e.frames, e.lines) + frameDetailSuffix(e.details)
+		out[i] = e
+	}
+	return out
+}
+
+// ---------------------------------------------------------------------------
+// JFR constant pools
+// ---------------------------------------------------------------------------
+
+// A recording names classes, packages and methods through the symbols of
+// each chunk's constant pools, and threads through the strings of their
+// pool entries. export --redact rewrites those: a class, package or
+// descriptor symbol is replaced by one with the aliased text, and each
+// redacted method gets a symbol of its own, since a name symbol is shared
+// by every method of that name. Symbols left unreferenced are blanked so
+// no original name remains in the pools. Strings outside the pools, such
+// as JVM arguments, are kept.
+
+// jfrSymbolRoles are the symbol fields export --redact rewrites.
+var jfrSymbolRoles = map[string]string{
+	"java.lang.Class.name":        "class",
+	"jdk.types.Package.name":      "package",
+	"jdk.types.Module.name":       "package",
+	"jdk.types.Method.name":       "method",
+	"jdk.types.Method.descriptor": "descriptor",
+}
+
+var descriptorClassRe = regexp.MustCompile(`L([^;]+);`)
+
+// jfrSpan is a byte range in checkpoint cp of a chunk.
+type jfrSpan struct{ cp, start, end int }
+
+// jfrSymbolRef is a pool field referring to a symbol.
+type jfrSymbolRef struct {
+	jfrSpan
+	id    uint64
+	role  string // from jfrSymbolRoles; "" for other fields
+	class uint64 // the class of a "method" ref
+}
+
+// jfrString is an inline string value.
+type jfrString struct {
+	jfrSpan
+	s  string
+	ok bool // the field was present
+}
+
+type jfrThread struct{ javaName, osName jfrString }
+
+// name is the thread name as the parser resolves it.
+func (t jfrThread) name() string {
+	if t.javaName.s != "" {
+		return t.javaName.s
+	}
+	return t.osName.s
+}
+
+// jfrCheckpoint is a checkpoint event: body is the offset past its size,
+// count the span of its pool count.
+type jfrCheckpoint struct {
+	start, end, body int
+	count            jfrSpan
+	pools            uint64
+}
+
+// jfrChunkPools is what redaction reads from the constant pools of a chunk.
+type jfrChunkPools struct {
+	tm          *def.TypeMap
+	checkpoints []jfrCheckpoint
+	symbols     map[uint64]jfrString
+	refs        []jfrSymbolRef
+	classes     map[uint64]uint64 // class → name symbol
+	threads     []jfrThread
+}
+
+// redactJFR returns the redactor for the frames and threads of a recording
+// and its checkpoint events rewritten with the aliases, keyed by offset.
+func redactJFR(buf []byte, globs []*regexp.Regexp) (*redactor, map[int][]byte, error) {
+	chunks, starts, err := splitJFRChunks(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	pools := make([]*jfrChunkPools, len(chunks))
+	var frames, threads []string
+	for i, events := range chunks {
+		end := starts[i] + int(binary.BigEndian.Uint64(buf[starts[i]+8:]))
+		// A parser of the chunk alone reads its metadata before any event.
+		p, err := newJFRParser(buf[starts[i]:end])
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := p.ParseEvent(); err != nil && err != io.EOF {
+			return nil, nil, parseErrorf("parse event: %w", err)
+		}
+		if pools[i], err = readJFRChunkPools(buf, events, &p.TypeMap); err != nil {
+			return nil, nil, parseErrorf("redact: %w", err)
+		}
+		for _, ref := range pools[i].refs {
+			if ref.role == "method" {
+				frames = append(frames, pools[i].frame(ref))
+			}
+		}
+		for _, t := range pools[i].threads {
+			threads = append(threads, t.name())
+		}
+	}
+	r := newRedactor(globs, frames, threads)
+
+	// Classes no frame names, such as allocated types and descriptor
+	// parameters, and their packages are aliased after the frames', also in
+	// sorted order.
+	var classes, pkgs []string
+	for _, c := range pools {
+		for _, ref := range c.refs {
+			s := c.symbols[ref.id].s
+			switch ref.role {
+			case "class":
+				classes = append(classes, s)
+			case "package":
+				pkgs = append(pkgs, s)
+			case "descriptor":
+				for _, m := range descriptorClassRe.FindAllStringSubmatch(s, -1) {
+					classes = append(classes, m[1])
+				}
+			}
+		}
+	}
+	slices.Sort(classes)
+	for _, name := range slices.Compact(classes) {
+		r.jfrClass(name)
+	}
+	slices.Sort(pkgs)
+	for _, name := range slices.Compact(pkgs) {
+		r.jfrPackage(name)
+	}
+
+	rewrite := make(map[int][]byte)
+	for _, c := range pools {
+		if err := c.rewrite(buf, r, rewrite); err != nil {
+			return nil, nil, parseErrorf("redact: %w", err)
+		}
+	}
+	return r, rewrite, nil
+}
+
+// jfrClass returns the alias of a class by its JVM name (com/mycorp/Foo,
+// or [Lcom/mycorp/Foo; for an array), or name if it is not redacted.
+func (r *redactor) jfrClass(name string) string {
+	if strings.HasPrefix(name, "[") {
+		return r.jfrDescriptor(name)
+	}
+	dotted := strings.ReplaceAll(name, "/", ".")
+	pkg, class := "", dotted
+	if i := strings.LastIndexByte(dotted, '.'); i >= 0 {
+		pkg, class = dotted[:i], dotted[i+1:]
+	}
+	if _, ok := r.classes[pkg+"."+class]; !ok && !matchesAnyGlob(r.globs, dotted) {
+		return name
+	}
+	return r.class(pkg, class, "/")
+}
+
+// jfrPackage returns the alias of a package by its JVM name (com/mycorp),
+// or name if it is not redacted.
+func (r *redactor) jfrPackage(name string) string {
+	dotted := strings.ReplaceAll(name, "/", ".")
+	if _, ok := r.pkgs[dotted]; !ok && !matchesAnyGlob(r.globs, dotted) {
+		return name
+	}
+	return nextAlias(r.pkgs, dotted, "pkg", true)
+}
+
+// jfrDescriptor returns a method or array descriptor with the classes it
+// names aliased.
+func (r *redactor) jfrDescriptor(desc string) string {
+	return descriptorClassRe.ReplaceAllStringFunc(desc, func(m string) string {
+		return "L" + r.jfrClass(m[1:len(m)-1]) + ";"
+	})
+}
+
+func readJFRChunkPools(buf []byte, events []rawJFREvent, tm *def.TypeMap) (*jfrChunkPools, error) {
+	c := &jfrChunkPools{tm: tm, symbols: make(map[uint64]jfrString), classes: make(map[uint64]uint64)}
+	rd := &jfrPoolReader{buf: buf, tm: tm}
+	for _, ev := range events {
+		if ev.typ != jfrCheckpointEventType {
+			continue
+		}
+		cp := jfrCheckpoint{start: ev.start, end: ev.end}
+		rd.pos = ev.start
+		for i := 0; i < 6; i++ { // size, type, start, duration, delta, type mask
+			if _, err := rd.varLong(); err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				cp.body = rd.pos
+			}
+		}
+		cp.count.cp, cp.count.start = len(c.checkpoints), rd.pos
+		n, err := rd.count()
+		if err != nil {
+			return nil, err
+		}
+		cp.count.end, cp.pools = rd.pos, n
+		c.checkpoints = append(c.checkpoints, cp)
+		for i := uint64(0); i < n; i++ {
+			id, err := rd.varLong()
+			if err != nil {
+				return nil, err
+			}
+			cls := tm.IDMap[def.TypeID(id)]
+			if cls == nil {
+				return nil, fmt.Errorf("unknown type %d", id)
+			}
+			if cls.Name == "jdk.types.ChunkHeader" {
+				err = rd.skip(jfrChunkHeaderSize)
+			} else {
+				err = c.readPool(rd, cls)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+func (c *jfrChunkPools) readPool(rd *jfrPoolReader, cls *def.Class) error {
+	if len(cls.Fields) == 0 { // strings and other simple values
+		return rd.skipPool(cls)
+	}
+	n, err := rd.count()
+	if err != nil {
+		return err
+	}
+	cp := len(c.checkpoints) - 1
+	for i := uint64(0); i < n; i++ {
+		id, err := rd.varLong()
+		if err != nil {
+			return err
+		}
+		first := len(c.refs)
+		var class uint64
+		var thread jfrThread
+		for fi := range cls.Fields {
+			f := &cls.Fields[fi]
+			ft := c.tm.IDMap[f.Type]
+			start := rd.pos
+			switch {
+			case f.Array || ft == nil:
+				err = rd.skipField(f)
+			case f.ConstantPool:
+				var v uint64
+				v, err = rd.varLong()
+				if ft.Name == "jdk.types.Symbol" {
+					role := jfrSymbolRoles[cls.Name+"."+f.Name]
+					c.refs = append(c.refs, jfrSymbolRef{jfrSpan: jfrSpan{cp, start, rd.pos}, id: v, role: role})
+					if role == "class" {
+						c.classes[id] = v
+					}
+				} else if cls.Name == "jdk.types.Method" && f.Name == "type" {
+					class = v
+				}
+			case ft.Name == "java.lang.String" && (cls.Name == "jdk.types.Symbol" || cls.Name == "java.lang.Thread"):
+				var s string
+				s, err = rd.readString()
+				str := jfrString{jfrSpan{cp, start, rd.pos}, s, true}
+				switch {
+				case cls.Name == "jdk.types.Symbol":
+					c.symbols[id] = str
+				case f.Name == "javaName":
+					thread.javaName = str
+				case f.Name == "osName":
+					thread.osName = str
+				}
+			default:
+				err = rd.skipField(f)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for j := first; j < len(c.refs); j++ {
+			c.refs[j].class = class
+		}
+		if cls.Name == "java.lang.Thread" {
+			c.threads = append(c.threads, thread)
+		}
+	}
+	return nil
+}
+
+// frame returns the frame of a "method" ref, named as the parser does.
+func (c *jfrChunkPools) frame(ref jfrSymbolRef) string {
+	method := c.symbols[ref.id].s
+	class := c.symbols[c.classes[ref.class]].s
+	if class == "" {
+		return limitSymbol(method)
+	}
+	return limitSymbol(class + "." + method)
+}
+
+// redacted returns the aliased text of the symbol ref refers to.
+func (c *jfrChunkPools) redacted(r *redactor, ref jfrSymbolRef) string {
+	s := c.symbols[ref.id].s
+	switch ref.role {
+	case "class":
+		return r.jfrClass(s)
+	case "package":
+		return r.jfrPackage(s)
+	case "descriptor":
+		return r.jfrDescriptor(s)
+	case "method":
+		if a := r.aliases[c.frame(ref)]; a != "" {
+			return a[strings.LastIndexByte(a, '.')+1:]
+		}
+	}
+	return s
+}
+
+// rewrite adds the redacted checkpoint events of the chunk to out.
+func (c *jfrChunkPools) rewrite(buf []byte, r *redactor, out map[int][]byte) error {
+	type edit struct {
+		jfrSpan
+		b []byte
+	}
+	edits := make([][]edit, len(c.checkpoints))
+	replace := func(sp jfrSpan, b []byte) {
+		edits[sp.cp] = append(edits[sp.cp], edit{sp, b})
+	}
+
+	var nextID uint64
+	for id := range c.symbols {
+		nextID = max(nextID, id+1)
+	}
+	added := make(map[string]uint64)
+	var newSymbols []byte
+	kept, moved := make(map[uint64]bool), make(map[uint64]bool)
+	for _, ref := range c.refs {
+		if _, ok := c.symbols[ref.id]; !ok {
+			continue
+		}
+		want := c.redacted(r, ref)
+		if want == c.symbols[ref.id].s {
+			kept[ref.id] = true
+			continue
+		}
+		id, ok := added[want]
+		if !ok {
+			id, nextID = nextID, nextID+1
+			added[want] = id
+			newSymbols = appendJFRString(appendVarLong(newSymbols, id), want)
+		}
+		moved[ref.id] = true
+		replace(ref.jfrSpan, appendVarLong(nil, id))
+	}
+	for id := range moved {
+		if !kept[id] {
+			replace(c.symbols[id].jfrSpan, appendJFRString(nil, ""))
+		}
+	}
+	for _, t := range c.threads {
+		alias := r.thread(t.name())
+		for _, s := range []jfrString{t.javaName, t.osName} {
+			if s.ok && s.s != "" && s.s != alias {
+				replace(s.jfrSpan, appendJFRString(nil, alias))
+			}
+		}
+	}
+
+	// New symbols go in a pool of their own at the end of the first
+	// checkpoint; the parser reads every pool of a chunk before its events.
+	var extra []byte
+	if len(added) > 0 {
+		sym := c.tm.NameMap["jdk.types.Symbol"]
+		if sym == nil || len(sym.Fields) != 1 {
+			return fmt.Errorf("unexpected jdk.types.Symbol layout")
+		}
+		first := c.checkpoints[0]
+		replace(first.count, appendVarLong(nil, first.pools+1))
+		extra = appendVarLong(appendVarLong(nil, uint64(sym.ID)), uint64(len(added)))
+		extra = append(extra, newSymbols...)
+	}
+
+	for i, cp := range c.checkpoints {
+		if len(edits[i]) == 0 {
+			continue
+		}
+		slices.SortFunc(edits[i], func(a, b edit) int { return a.start - b.start })
+		var body []byte
+		pos := cp.body
+		for _, e := range edits[i] {
+			body = append(body, buf[pos:e.start]...)
+			body = append(body, e.b...)
+			pos = e.end
+		}
+		body = append(body, buf[pos:cp.end]...)
+		if i == 0 {
+			body = append(body, extra...)
+		}
+		out[cp.start] = jfrEventBytes(body)
+	}
+	return nil
+}
+
+// appendJFRString appends s as an inline UTF-8 string.
+func appendJFRString(b []byte, s string) []byte {
+	if s == "" {
+		return append(b, 1)
+	}
+	b = appendVarLong(append(b, 3), uint64(len(s)))
+	return append(b, s...)
+}
+
+// jfrEventBytes prefixes an event body with its size, which counts itself.
+func jfrEventBytes(body []byte) []byte {
+	size := len(body) + 1
+	for n := len(appendVarLong(nil, uint64(size))); n+len(body) != size; n = len(appendVarLong(nil, uint64(size))) {
+		size = n + len(body)
+	}
+	return append(appendVarLong(make([]byte, 0, size), uint64(size)), body...)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// remoteTarget is a place a JVM runs that ap-query reaches through another
+// tool rather than directly: a Kubernetes container or an SSH host.
+type remoteTarget interface {
+	// command returns the local command line that runs argv on the target,
+	// with stdin connected.
+	command(argv ...string) []string
+	String() string
+}
+
+// k8sTarget runs commands in a container with kubectl exec.
+type k8sTarget struct {
+	resource  string // pod/NAME, deploy/NAME or a bare pod name
+	container string
+	namespace string
+}
+
+func (k k8sTarget) command(argv ...string) []string {
+	cmd := []string{"kubectl", "exec", "-i"}
+	if k.namespace != "" {
+		cmd = append(cmd, "-n", k.namespace)
+	}
+	cmd = append(cmd, k.resource)
+	if k.container != "" {
+		cmd = append(cmd, "-c", k.container)
+	}
+	return append(append(cmd, "--"), argv...)
+}
+
+func (k k8sTarget) String() string {
+	s := k.resource
+	if k.container != "" {
+		s += " (container " + k.container + ")"
+	}
+	if k.namespace != "" {
+		s += " in namespace " + k.namespace
+	}
+	return s
+}
+
+// sshTarget runs commands on a host with ssh, optionally through a jump
+// host. Keys, users and ports come from the ssh config as usual.
+type sshTarget struct {
+	host string
+	jump string
+}
+
+func (s sshTarget) command(argv ...string) []string {
+	cmd := []string{"ssh"}
+	if s.jump != "" {
+		cmd = append(cmd, "-J", s.jump)
+	}
+	// ssh hands the command to the remote shell as one string.
+	return append(cmd, s.host, shellJoin(argv))
+}
+
+func (s sshTarget) String() string {
+	if s.jump != "" {
+		return s.host + " via " + s.jump
+	}
+	return s.host
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_./=:,@%+-]+$`)
+
+// shellJoin quotes argv for a POSIX shell.
+func shellJoin(argv []string) string {
+	quoted := make([]string, len(argv))
+	for i, a := range argv {
+		if shellSafe.MatchString(a) {
+			quoted[i] = a
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// asprofArgs is the asprof command line that records opts.duration seconds of
+// the target JVM into remoteFile.
+func asprofArgs(asprof string, opts recordOpts, remoteFile string) []string {
+	argv := []string{asprof, "-d", strconv.Itoa(opts.duration), "-e", opts.event}
+	if opts.interval != "" {
+		argv = append(argv, "-i", opts.interval)
+	}
+	return append(argv, "-o", "jfr", "-f", remoteFile, opts.pid)
+}
+
+// cmdRecordRemote runs asprof on the target, streams the JFR back with cat
+// (no tar or scp needed on the target), removes the remote copy and analyzes
+// the local one.
+func cmdRecordRemote(target remoteTarget, opts recordOpts) error {
+	if opts.duration <= 0 {
+		return fmt.Errorf("--duration must be positive")
+	}
+	if opts.event == "" {
+		return fmt.Errorf("--event must not be empty")
+	}
+	output, err := recordOutput(opts.output)
+	if err != nil {
+		return err
+	}
+	asprof := opts.remoteAsprof
+	if opts.push {
+		if asprof, err = pushAsprof(target); err != nil {
+			return err
+		}
+	}
+	remoteFile := "/tmp/ap-query-" + time.Now().Format("20060102-150405") + ".jfr"
+
+	fmt.Fprintf(os.Stderr, "Recording %s for %ds in %s\n", opts.event, opts.duration, target)
+	if err := runRemote(target, nil, os.Stderr, asprofArgs(asprof, opts, remoteFile)...); err != nil {
+		return ioErrorf("asprof on %s failed: %v", target, err)
+	}
+	defer runRemote(target, nil, nil, "rm", "-f", remoteFile)
+
+	f, err := os.Create(output)
+	if err != nil {
+		return ioErrorf("%v", err)
+	}
+	err = runRemote(target, nil, f, "cat", remoteFile)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(output)
+		return ioErrorf("copying %s from %s failed: %v", remoteFile, target, err)
+	}
+	return analyzeRecording(output, opts.noAnalyze)
+}
+
+// runRemote runs argv on the target with stdin from in and stdout going to
+// out (both may be nil) and stderr passed through.
+func runRemote(target remoteTarget, in io.Reader, out io.Writer, argv ...string) error {
+	local := target.command(argv...)
+	cmd := exec.Command(local[0], local[1:]...)
+	cmd.Stdin = in
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %v", local[0], err)
+	}
+	return nil
+}
+
+// remoteAsprofDir is where --push unpacks async-profiler on the target.
+const remoteAsprofDir = "/tmp/ap-query-async-profiler"
+
+// pushAsprof unpacks the async-profiler release matching the target's
+// architecture into remoteAsprofDir and returns the asprof path there.
+func pushAsprof(target remoteTarget) (string, error) {
+	var uname bytes.Buffer
+	if err := runRemote(target, nil, &uname, "uname", "-sm"); err != nil {
+		return "", ioErrorf("cannot detect the platform of %s: %v", target, err)
+	}
+	arch, err := asprofArch(uname.String())
+	if err != nil {
+		return "", err
+	}
+	data, err := asprofTarball(arch)
+	if err != nil {
+		return "", ioErrorf("%v", err)
+	}
+	fmt.Fprintf(os.Stderr, "Pushing async-profiler (linux-%s) to %s\n", arch, target)
+	script := "mkdir -p " + remoteAsprofDir + " && tar xzf - -C " + remoteAsprofDir + " --strip-components=1"
+	if err := runRemote(target, bytes.NewReader(data), nil, "sh", "-c", script); err != nil {
+		return "", ioErrorf("pushing async-profiler to %s failed: %v", target, err)
+	}
+	return remoteAsprofDir + "/bin/asprof", nil
+}
+
+// asprofArch maps `uname -sm` output to an async-profiler release arch.
+func asprofArch(uname string) (string, error) {
+	fields := strings.Fields(uname)
+	if len(fields) != 2 || fields[0] != "Linux" {
+		return "", usageErrorf("--push supports Linux targets only, target reports %q", strings.TrimSpace(uname))
+	}
+	switch fields[1] {
+	case "x86_64", "amd64":
+		return "x64", nil
+	case "aarch64", "arm64":
+		return "arm64", nil
+	}
+	return "", usageErrorf("no async-profiler build for %s", fields[1])
+}
+
+// asprofTarball returns the Linux release archive for arch. Archives are
+// checked against the SHA-256 GitHub publishes for the release asset before
+// they are cached in ~/.ap-query/cache; the newest cached version is reused,
+// so delete the cache to pick up a new release.
+func asprofTarball(arch string) ([]byte, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("cannot determine home directory: %v", err)
+	}
+	cacheDir := filepath.Join(home, ".ap-query", "cache")
+	cached, _ := filepath.Glob(filepath.Join(cacheDir, "async-profiler-*-linux-"+arch+".tar.gz"))
+	if newest := newestAsprofArchive(cached); newest != "" {
+		return os.ReadFile(newest)
+	}
+
+	release, err := asprofLatestRelease()
+	if err != nil {
+		return nil, fmt.Errorf("cannot check latest async-profiler version: %v", err)
+	}
+	ver := strings.TrimPrefix(release.TagName, "v")
+	name := "async-profiler-" + ver + "-linux-" + arch + ".tar.gz"
+	hash := release.sha256(name)
+	if hash == "" {
+		return nil, fmt.Errorf("no checksum published for %s", name)
+	}
+	client := &http.Client{Timeout: 60 * time.Second}
+	data, err := downloadAndVerify(asprofLinuxURL(release.TagName, ver, arch), hash, client)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(cacheDir, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(cacheDir, name), data, 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: cannot cache async-profiler: %v\n", err)
+	}
+	return data, nil
+}
+
+// newestAsprofArchive returns the cached archive with the highest version,
+// comparing dotted versions numerically so 4.10 sorts after 4.9.
+func newestAsprofArchive(paths []string) string {
+	newest := ""
+	var newestVer []int
+	for _, path := range paths {
+		ver := asprofArchiveVersion(filepath.Base(path))
+		if newest == "" || compareVersions(ver, newestVer) > 0 {
+			newest, newestVer = path, ver
+		}
+	}
+	return newest
+}
+
+// asprofArchiveVersion parses the version out of an
+// async-profiler-<ver>-linux-<arch>.tar.gz name. Non-numeric parts count
+// as zero.
+func asprofArchiveVersion(name string) []int {
+	ver := strings.TrimPrefix(name, "async-profiler-")
+	if i := strings.Index(ver, "-linux-"); i >= 0 {
+		ver = ver[:i]
+	}
+	var parts []int
+	for _, f := range strings.Split(ver, ".") {
+		n, _ := strconv.Atoi(f)
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return cmp.Compare(x, y)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// renameMap rewrites frames so diff can track methods across refactors.
+// Each entry maps an old fully-qualified name to a new one. An entry
+// matches a whole method ("com.a.Foo.run"), or a class or package prefix
+// at a "." boundary ("com.a.Foo", "com.a"); the longest match wins.
+type renameMap struct {
+	entries []renameEntry // longest old first
+}
+
+type renameEntry struct {
+	old, new string
+	used     bool
+}
+
+// loadRenameMap reads a file of "old=new" lines. Blank lines and lines
+// starting with # are ignored.
+func loadRenameMap(path string) (*renameMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseRenameMap(f, path)
+}
+
+func parseRenameMap(r io.Reader, name string) (*renameMap, error) {
+	rm := &renameMap{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		oldName, newName, ok := strings.Cut(line, "=")
+		oldName = strings.ReplaceAll(strings.TrimSpace(oldName), "/", ".")
+		newName = strings.ReplaceAll(strings.TrimSpace(newName), "/", ".")
+		if !ok || oldName == "" || newName == "" {
+			return nil, usageErrorf("--rename-map %s:%d: expected old=new, got %q", name, lineNo, line)
+		}
+		rm.entries = append(rm.entries, renameEntry{old: oldName, new: newName})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(rm.entries, func(i, j int) bool {
+		return len(rm.entries[i].old) > len(rm.entries[j].old)
+	})
+	return rm, nil
+}
+
+// rename returns the renamed frame, or the frame unchanged if no entry
+// matches.
+func (rm *renameMap) rename(frame string) string {
+	dotted := strings.ReplaceAll(frame, "/", ".")
+	for i := range rm.entries {
+		e := &rm.entries[i]
+		if dotted == e.old || (strings.HasPrefix(dotted, e.old) && dotted[len(e.old)] == '.') {
+			e.used = true
+			return e.new + dotted[len(e.old):]
+		}
+	}
+	return frame
+}
+
+// apply returns a copy of sf with every frame renamed. A nil map returns sf.
+func (rm *renameMap) apply(sf *stackFile) *stackFile {
+	if rm == nil {
+		return sf
+	}
+	out := &stackFile{totalSamples: sf.totalSamples, stacks: make([]stack, len(sf.stacks))}
+	renamed := make(map[string]string)
+	for i, st := range sf.stacks {
+		frames := make([]string, len(st.frames))
+		for j, fr := range st.frames {
+			nf, ok := renamed[fr]
+			if !ok {
+				nf = rm.rename(fr)
+				renamed[fr] = nf
+			}
+			frames[j] = nf
+		}
+		st.frames = frames
+		out.stacks[i] = st
+	}
+	return out
+}
+
+// warnUnused reports entries that matched no frame in any input, which
+// usually means a typo in the map.
+func (rm *renameMap) warnUnused() {
+	if rm == nil {
+		return
+	}
+	for _, e := range rm.entries {
+		if !e.used {
+			fmt.Fprintf(os.Stderr, "warning: --rename-map entry %s=%s matched no frames\n", e.old, e.new)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const repoURLUsage = "Link methods and lines to source hosting, e.g. https://github.com/org/app/blob/{sha}/{path}#L{line}; {sha} needs --source-root"
+
+// repoLinker fills the --repo-url template for a frame. {path} is the file
+// in the --source-root checkout, or without one the package path of the
+// class (com/app/Main.java), so the template supplies the source directory.
+// {sha} is the checkout's HEAD. Without a line the #fragment holding {line}
+// is dropped.
+type repoLinker struct {
+	template string
+	noLine   string // template for frames without a line
+	sha      string
+	owners   *ownerIndex // resolves paths in the checkout; nil = package paths
+}
+
+func newRepoLinker(template, sourceRoot string, owners *ownerIndex) (*repoLinker, error) {
+	if !strings.Contains(template, "{path}") {
+		return nil, fmt.Errorf("--repo-url %q has no {path}", template)
+	}
+	l := &repoLinker{template: template, noLine: template, owners: owners}
+	if i := strings.Index(template, "{line}"); i >= 0 {
+		if h := strings.LastIndexByte(template[:i], '#'); h >= 0 {
+			l.noLine = template[:h]
+		} else {
+			l.noLine = strings.ReplaceAll(template, "{line}", "1")
+		}
+	}
+	if strings.Contains(template, "{sha}") {
+		if sourceRoot == "" {
+			return nil, fmt.Errorf("--repo-url {sha} requires --source-root")
+		}
+		out, err := exec.Command("git", "-C", sourceRoot, "rev-parse", "HEAD").Output()
+		if err != nil {
+			return nil, fmt.Errorf("--repo-url {sha}: %s is not a git checkout", sourceRoot)
+		}
+		l.sha = strings.TrimSpace(string(out))
+	}
+	return l, nil
+}
+
+// url returns the link to line of frame's source file (0 = unknown), or ""
+// for native frames and, with a checkout, classes not found in it.
+func (l *repoLinker) url(frame string, line uint32) string {
+	var path string
+	if l.owners != nil {
+		path = l.owners.sourcePath(frame)
+	} else if path = sourceFileOf(frame, true); path == nativeFile {
+		path = ""
+	}
+	if path == "" {
+		return ""
+	}
+	t := l.template
+	if line == 0 {
+		t = l.noLine
+	}
+	return strings.NewReplacer("{path}", path, "{line}", strconv.FormatUint(uint64(line), 10), "{sha}", l.sha).Replace(t)
+}
+
+// urlsByName links each display name in sf to its hottest line: the line
+// of the name's first frame seen in the most samples.
+func (l *repoLinker) urlsByName(sf *stackFile, fqn bool) map[string]string {
+	type hotLines struct {
+		frame string
+		lines map[uint32]int
+	}
+	byName := make(map[string]*hotLines)
+	for i := range sf.stacks {
+		st := &sf.stacks[i]
+		for j, fr := range st.frames {
+			name := displayName(fr, fqn)
+			h := byName[name]
+			if h == nil {
+				h = &hotLines{frame: fr, lines: make(map[uint32]int)}
+				byName[name] = h
+			}
+			if fr == h.frame && st.lines[j] > 0 {
+				h.lines[st.lines[j]] += st.count
+			}
+		}
+	}
+	res := make(map[string]string, len(byName))
+	for name, h := range byName {
+		var best uint32
+		for line, n := range h.lines {
+			if n > h.lines[best] || n == h.lines[best] && line < best {
+				best = line
+			}
+		}
+		if u := l.url(h.frame, best); u != "" {
+			res[name] = u
+		}
+	}
+	return res
+}
+
+// lineURLs returns a function linking a line of a display name in sf, for
+// the tables of hot lines. Names not in sf link nowhere.
+func (l *repoLinker) lineURLs(sf *stackFile, fqn bool) func(name string, line uint32) string {
+	frames := make(map[string]string)
+	for i := range sf.stacks {
+		for _, fr := range sf.stacks[i].frames {
+			if name := displayName(fr, fqn); frames[name] == "" {
+				frames[name] = fr
+			}
+		}
+	}
+	return func(name string, line uint32) string {
+		if fr := frames[name]; fr != "" {
+			return l.url(fr, line)
+		}
+		return ""
+	}
+}
+
+// urlColumn is the URL cell of a text table: "-" when there is no link.
+func urlColumn(url string) string {
+	if url == "" {
+		return "-"
+	}
+	return url
+}
+
+// link sets the URL of n and its descendants from urls (by node name).
+func (n *flameNode) link(urls map[string]string) {
+	n.URL = urls[n.Name]
+	for _, c := range n.Children {
+		c.link(urls)
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/jfr-parser/parser"
+	"github.com/spf13/cobra"
+)
+
+func newSafepointsCmd() *cobra.Command {
+	var from, to string
+	var top int
+	cmd := &cobra.Command{
+		Use:   "safepoints <file>",
+		Short: "Safepoint pauses and time-to-safepoint by VM operation (JFR only)",
+		Long: `Safepoints summarizes the JVM's stop-the-world pauses: how many, the total
+and longest pause, the time threads took to reach the safepoint, and the VM
+operations that requested them. Long safepoints stall every thread at once
+and do not show up as hot methods.
+
+The pause and time-to-safepoint come from jdk.SafepointBegin/End and
+jdk.SafepointStateSynchronization; the operation from jdk.ExecuteVMOperation.
+These are JDK events: record with asprof --jfrsync, or JFR's profile
+settings. With only jdk.ExecuteVMOperation, the operation time stands in for
+the pause and the time-to-safepoint is unknown.`,
+		Example: strings.Join([]string{
+			"  ap-query safepoints profile.jfr",
+			"  ap-query safepoints profile.jfr --from 10s --to 20s",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if detectFormat(args[0]) != formatJFR {
+				return fmt.Errorf("safepoints requires a JFR file")
+			}
+			window, err := parseDurationWindow("--from", from, "--to", to)
+			if err != nil {
+				return err
+			}
+			sps, err := parseSafepoints(args[0], window.fromNanos, window.toNanos)
+			if err != nil {
+				return err
+			}
+			cmdSafepoints(sps, top)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&from, "from", "", "Start of time window")
+	cmd.Flags().StringVar(&to, "to", "", "End of time window")
+	cmd.Flags().IntVar(&top, "top", 20, "Limit operation rows")
+	return cmd
+}
+
+// safepoint is one stop-the-world pause, joined by safepoint id.
+type safepoint struct {
+	id         uint64
+	startNanos int64 // offset from the recording start
+	pauseNanos int64 // SafepointBegin start → SafepointEnd end
+	ttspNanos  int64 // time to reach the safepoint; -1 = unknown
+	operation  string
+}
+
+var safepointEventNames = []string{
+	"jdk.SafepointBegin",
+	"jdk.SafepointStateSynchronization",
+	"jdk.SafepointEnd",
+	"jdk.ExecuteVMOperation",
+}
+
+// parseSafepoints joins the safepoint and VM operation events of a JFR
+// recording by safepoint id. fromNanos/toNanos bound safepoint start times
+// (-1 = open).
+func parseSafepoints(path string, fromNanos, toNanos int64) ([]safepoint, error) {
+	buf, err := readJFRBytes(path)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint64]*safepoint)
+	hasBegin := make(map[uint64]bool)
+	get := func(id uint64) *safepoint {
+		sp := byID[id]
+		if sp == nil {
+			sp = &safepoint{id: id, ttspNanos: -1}
+			byID[id] = sp
+		}
+		return sp
+	}
+	err = scanJDKEvents(buf, safepointEventNames, func(_ *parser.Parser, ev *jdkEvent) {
+		id := ev.nums["safepointId"]
+		switch ev.name {
+		case "jdk.SafepointBegin":
+			sp := get(id)
+			sp.startNanos = ev.startNanos
+			if !hasBegin[id] {
+				sp.pauseNanos = ev.durNanos
+			}
+			hasBegin[id] = true
+		case "jdk.SafepointStateSynchronization":
+			get(id).ttspNanos = ev.durNanos
+		case "jdk.SafepointEnd":
+			if sp := byID[id]; sp != nil && hasBegin[id] {
+				sp.pauseNanos = max(sp.pauseNanos, ev.startNanos+ev.durNanos-sp.startNanos)
+			}
+		case "jdk.ExecuteVMOperation":
+			if ev.nums["safepoint"] == 0 || id == 0 {
+				return
+			}
+			sp := get(id)
+			if sp.operation == "" {
+				sp.operation = ev.strs["operation"]
+			}
+			if !hasBegin[id] {
+				sp.startNanos = ev.startNanos
+				sp.pauseNanos = ev.durNanos
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sps := make([]safepoint, 0, len(byID))
+	for _, sp := range byID {
+		if (fromNanos >= 0 && sp.startNanos < fromNanos) || (toNanos >= 0 && sp.startNanos >= toNanos) {
+			continue
+		}
+		if sp.operation == "" {
+			sp.operation = "(unknown)"
+		}
+		sps = append(sps, *sp)
+	}
+	sort.Slice(sps, func(i, j int) bool {
+		if sps[i].startNanos != sps[j].startNanos {
+			return sps[i].startNanos < sps[j].startNanos
+		}
+		return sps[i].id < sps[j].id
+	})
+	return sps, nil
+}
+
+// safepointStats aggregates the pauses of one operation (or of all).
+type safepointStats struct {
+	name      string
+	count     int
+	pause     int64
+	maxPause  int64
+	ttsp      int64
+	maxTTSP   int64
+	ttspCount int // safepoints with a known time-to-safepoint
+}
+
+func (s *safepointStats) add(sp safepoint) {
+	s.count++
+	s.pause += sp.pauseNanos
+	s.maxPause = max(s.maxPause, sp.pauseNanos)
+	if sp.ttspNanos >= 0 {
+		s.ttspCount++
+		s.ttsp += sp.ttspNanos
+		s.maxTTSP = max(s.maxTTSP, sp.ttspNanos)
+	}
+}
+
+// safepointsByOperation returns per-operation stats sorted by total pause,
+// then count, then name.
+func safepointsByOperation(sps []safepoint) []safepointStats {
+	byOp := make(map[string]*safepointStats)
+	for _, sp := range sps {
+		s := byOp[sp.operation]
+		if s == nil {
+			s = &safepointStats{name: sp.operation}
+			byOp[sp.operation] = s
+		}
+		s.add(sp)
+	}
+	ops := make([]safepointStats, 0, len(byOp))
+	for _, s := range byOp {
+		ops = append(ops, *s)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i].pause != ops[j].pause {
+			return ops[i].pause > ops[j].pause
+		}
+		if ops[i].count != ops[j].count {
+			return ops[i].count > ops[j].count
+		}
+		return ops[i].name < ops[j].name
+	})
+	return ops
+}
+
+// formatPause formats a pause with sub-millisecond precision.
+func formatPause(nanos int64) string {
+	switch {
+	case nanos >= int64(time.Second):
+		return fmt.Sprintf("%.2fs", float64(nanos)/float64(time.Second))
+	case nanos >= int64(time.Millisecond):
+		return fmt.Sprintf("%.2fms", float64(nanos)/float64(time.Millisecond))
+	}
+	return fmt.Sprintf("%dus", nanos/int64(time.Microsecond))
+}
+
+// longestSafepoints is the number of individual pauses listed.
+const longestSafepoints = 5
+
+func cmdSafepoints(sps []safepoint, top int) {
+	if len(sps) == 0 {
+		fmt.Fprintln(os.Stdout, "no safepoint events (record with asprof --jfrsync profile, or JFR's profile settings)")
+		return
+	}
+	var all safepointStats
+	for _, sp := range sps {
+		all.add(sp)
+	}
+	fmt.Printf("Safepoints: %d\n", all.count)
+	fmt.Printf("Pause:              total %s  max %s  avg %s\n",
+		formatPause(all.pause), formatPause(all.maxPause), formatPause(all.pause/int64(all.count)))
+	if all.ttspCount > 0 {
+		fmt.Printf("Time to safepoint:  total %s  max %s  avg %s\n",
+			formatPause(all.ttsp), formatPause(all.maxTTSP), formatPause(all.ttsp/int64(all.ttspCount)))
+	} else {
+		fmt.Println("Time to safepoint:  unknown (no jdk.SafepointStateSynchronization events)")
+	}
+	fmt.Println()
+
+	ops := safepointsByOperation(sps)
+	shown := ops[:truncate(len(ops), top)]
+	fmt.Printf("%-40s %6s %10s %10s %10s\n", "OPERATION", "COUNT", "TOTAL", "MAX", "MAX-TTSP")
+	for _, s := range shown {
+		maxTTSP := "-"
+		if s.ttspCount > 0 {
+			maxTTSP = formatPause(s.maxTTSP)
+		}
+		fmt.Printf("%-40s %6d %10s %10s %10s\n", s.name, s.count, formatPause(s.pause), formatPause(s.maxPause), maxTTSP)
+	}
+	if len(shown) < len(ops) {
+		fmt.Printf("(%d of %d operations shown)\n", len(shown), len(ops))
+	}
+
+	longest := append([]safepoint(nil), sps...)
+	sort.SliceStable(longest, func(i, j int) bool { return longest[i].pauseNanos > longest[j].pauseNanos })
+	fmt.Println()
+	fmt.Println("Longest:")
+	for _, sp := range longest[:truncate(len(longest), longestSafepoints)] {
+		ttsp := ""
+		if sp.ttspNanos >= 0 {
+			ttsp = fmt.Sprintf(" (ttsp %s)", formatPause(sp.ttspNanos))
+		}
+		fmt.Printf("  %8s  at %s  %s%s\n", formatPause(sp.pauseNanos), formatDuration(sp.startNanos), sp.operation, ttsp)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// salvageSummary counts the chunks found in a damaged recording and the
+// ones kept.
+type salvageSummary struct {
+	total, kept int
+}
+
+// salvageJFRChunks returns the chunks of buf that parse on their own,
+// concatenated. It follows the header size links and, where a header is
+// damaged, resynchronizes on the next chunk magic. A chunk cut off by the
+// end of the file is counted and dropped.
+func salvageJFRChunks(buf []byte) ([]byte, salvageSummary) {
+	magic := binary.BigEndian.AppendUint32(nil, jfrChunkMagic)
+	var out []byte
+	var sum salvageSummary
+	pos := bytes.Index(buf, magic)
+	for pos >= 0 && pos < len(buf) {
+		sum.total++
+		end := len(buf)
+		if pos+jfrChunkHeaderSize <= len(buf) {
+			size := int64(binary.BigEndian.Uint64(buf[pos+8:]))
+			if size >= jfrChunkHeaderSize && size <= int64(len(buf)-pos) {
+				end = pos + int(size)
+			}
+		}
+		if end == len(buf) || !bytes.HasPrefix(buf[end:], magic) {
+			// Unknown or untrusted size: the chunk runs to the next magic.
+			next := bytes.Index(buf[pos+len(magic):], magic)
+			if next >= 0 {
+				end = pos + len(magic) + next
+			} else {
+				end = len(buf)
+			}
+		}
+		if chunkParses(buf[pos:end]) {
+			out = append(out, buf[pos:end]...)
+			sum.kept++
+		}
+		pos = end
+	}
+	return out, sum
+}
+
+// chunkParses reports whether every event of a single chunk decodes.
+func chunkParses(chunk []byte) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	p, err := newJFRParser(chunk)
+	if err != nil {
+		return false
+	}
+	for {
+		if _, err := p.ParseEvent(); err != nil {
+			return err == io.EOF
+		}
+	}
+}
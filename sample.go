@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// sampleFraction (--sample) keeps each sample of collapsed text with this
+// probability, for quick approximate answers on inputs too large to read
+// in full; 0 = keep everything. Lines losing all their samples are skipped
+// before their frames are split, which is where the time goes.
+var sampleFraction float64
+
+// samplePct is the --sample value, parsed into sampleFraction before the
+// command runs.
+var samplePct string
+
+// registerSample adds --sample to commands that read profiles.
+func registerSample(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&samplePct, "sample", "", "Read only this share of the samples of collapsed text (e.g. 10%), drawn at random by weight, for quick approximate answers on huge inputs")
+}
+
+// warnSampleIgnored notes that --sample does not thin the named input,
+// which is not plain collapsed text.
+func warnSampleIgnored(what string) {
+	if sampleFraction > 0 {
+		fmt.Fprintf(os.Stderr, "warning: --sample applies to collapsed text only; reading all of %s\n", what)
+	}
+}
+
+// sampleSeed fixes the draws, so sampling the same input twice keeps the
+// same samples.
+const sampleSeed = 0x61702d7175657279
+
+// parseSamplePct parses a --sample value, a percentage with or without
+// "%": "10%" or "10" keep a tenth.
+func parseSamplePct(s string) (float64, error) {
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || !(pct > 0 && pct <= 100) {
+		return 0, fmt.Errorf("--sample must be a percentage above 0 and at most 100, e.g. 10%% (got %q)", s)
+	}
+	return pct / 100, nil
+}
+
+// thinSamples keeps each of count samples with probability p (binomial
+// thinning, so a stack's weight decides its chance to survive). Draws come
+// from a generator seeded by seed, the line's position in the input.
+func thinSamples(count int, p float64, seed uint64) int {
+	r := rand.New(rand.NewPCG(seed, sampleSeed))
+	if count <= 64 {
+		kept := 0
+		for range count {
+			if r.Float64() < p {
+				kept++
+			}
+		}
+		return kept
+	}
+	// The normal approximation is skewed when few samples are expected on
+	// either side; draw exactly there.
+	mean := float64(count) * p
+	switch {
+	case mean < 10:
+		return binomialInversion(r, count, p)
+	case float64(count)-mean < 10:
+		return count - binomialInversion(r, count, 1-p)
+	}
+	// Normal approximation of the binomial for large counts.
+	kept := int(math.Round(mean + r.NormFloat64()*math.Sqrt(mean*(1-p))))
+	return min(max(kept, 0), count)
+}
+
+// binomialInversion draws from Binomial(count, p) by walking its
+// cumulative distribution; it takes about count·p steps, so it suits a
+// small expected count.
+func binomialInversion(r *rand.Rand, count int, p float64) int {
+	u := r.Float64()
+	prob := math.Pow(1-p, float64(count))
+	cdf := prob
+	k := 0
+	for u > cdf && k < count {
+		prob *= float64(count-k) / float64(k+1) * p / (1 - p)
+		k++
+		cdf += prob
+	}
+	return k
+}
+
+// sampleMargin is the worst-case half-width, in percentage points, of the
+// 95% confidence interval of a share estimated from kept of the samples
+// kept at fraction p (the share at 50%, with the finite population
+// correction for sampling without replacement).
+func sampleMargin(kept int, p float64) float64 {
+	if kept == 0 {
+		return 100
+	}
+	return 100 * 1.96 * 0.5 * math.Sqrt((1-p)/float64(kept))
+}
+
+// reportSampling tells on stderr how much of the input --sample kept and
+// how far the percentages may be off.
+func reportSampling(kept, total int) {
+	fmt.Fprintf(os.Stderr, "note: --sample %g%% kept %d of %d samples; percentages are approximate, within ±%.*f points at 95%% confidence (drop --sample for exact results)\n",
+		sampleFraction*100, kept, total, pctDigits, sampleMargin(kept, sampleFraction))
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/grafana/jfr-parser/parser/types"
+)
+
+// saturatedPct is the share of a pool's threads that must be busy for a
+// bucket to count as saturated in `threads --saturation`.
+const saturatedPct = 90.0
+
+// wallStateSample is one wall sample reduced to what pool saturation needs.
+type wallStateSample struct {
+	offsetNanos int64
+	thread      string
+	state       int // stateRunning, stateLock, ...
+	weight      int
+}
+
+// busyState reports whether a thread in state s is occupied by work: running,
+// blocked on a lock or in I/O. A parked or otherwise waiting pool thread is
+// free to take the next task.
+func busyState(s int) bool {
+	return s == stateRunning || s == stateLock || s == stateIO
+}
+
+// parseWallStates returns the wall samples of a JFR recording with their
+// thread state, and the recording span. thread is a substring filter;
+// fromNanos/toNanos bound sample times (-1 = open).
+func parseWallStates(path, thread string, fromNanos, toNanos int64) ([]wallStateSample, int64, error) {
+	buf, err := readJFRBytes(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	originNanos, spanNanos, err := scanChunkHeaders(buf)
+	if err != nil {
+		return nil, 0, parseErrorf("%v", err)
+	}
+	p, err := newJFRParser(buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	stackCache := make(map[types.StackTraceRef]*cachedStackTrace)
+	var samples []wallStateSample
+	for {
+		typ, err := p.ParseEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, parseErrorf("parse event: %w", err)
+		}
+		if typ != p.TypeMap.T_WALL_CLOCK_SAMPLE {
+			continue
+		}
+		hdr := p.ChunkHeader()
+		offset := ticksToNanos(p.WallClockSample.StartTime, hdr.StartTicks, hdr.StartNanos, uint64(originNanos), hdr.TicksPerSecond)
+		if (fromNanos >= 0 && offset < fromNanos) || (toNanos >= 0 && offset >= toNanos) {
+			continue
+		}
+		name := resolveThread(p, p.WallClockSample.SampledThread)
+		if name == "" || (thread != "" && !strings.Contains(name, thread)) {
+			continue
+		}
+		weight := int(p.WallClockSample.Samples)
+		if weight < 1 {
+			weight = 1
+		}
+		jvmState := ""
+		if s := p.GetThreadState(p.WallClockSample.State); s != nil {
+			jvmState = s.Name
+		}
+		cached := resolveStackTraceCached(p, stackCache, nil, p.WallClockSample.StackTrace)
+		samples = append(samples, wallStateSample{offset, name, classifyThreadState(cached.frames, jvmState), weight})
+	}
+	return samples, spanNanos, nil
+}
+
+// poolSaturation is the busy-thread profile of one thread pool over time.
+type poolSaturation struct {
+	name    string
+	threads int       // distinct threads of the pool
+	busy    []float64 // estimated busy threads per bucket
+	seen    []int     // threads sampled per bucket
+	samples int       // wall samples
+	busyN   int       // samples in a busy state
+	running int       // samples running
+}
+
+// avgBusy is the mean busy threads over the buckets the pool was sampled in.
+func (p *poolSaturation) avgBusy() float64 {
+	sum, n := 0.0, 0
+	for i, b := range p.busy {
+		if p.seen[i] > 0 {
+			sum += b
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+func (p *poolSaturation) peakBusy() float64 {
+	peak := 0.0
+	for _, b := range p.busy {
+		peak = max(peak, b)
+	}
+	return peak
+}
+
+// saturatedBuckets returns the buckets where at least saturatedPct of the
+// sampled threads were busy, and the buckets the pool was sampled in.
+func (p *poolSaturation) saturatedBuckets() (saturated, sampled int) {
+	for i, b := range p.busy {
+		if p.seen[i] == 0 {
+			continue
+		}
+		sampled++
+		if b/float64(p.seen[i])*100 >= saturatedPct-1e-9 {
+			saturated++
+		}
+	}
+	return
+}
+
+// computePoolSaturation estimates, per thread pool and time bucket, how
+// many of the pool's threads were busy: each thread sampled in a bucket
+// counts as busy for the share of its samples there in a busy state. name
+// maps a thread to its normalized name; pools are the thread groups of two
+// or more threads (as `threads --group`), busiest first.
+func computePoolSaturation(samples []wallStateSample, origin, span int64, numBuckets int, name func(string) string) []poolSaturation {
+	perThread := make(map[string]int)
+	for _, s := range samples {
+		perThread[name(s.thread)] += s.weight
+	}
+	entries := make([]threadEntry, 0, len(perThread))
+	for t, n := range perThread {
+		entries = append(entries, threadEntry{t, n})
+	}
+	assignments := assignGroups(entries)
+	groupSize := make(map[string]int)
+	for _, g := range assignments {
+		groupSize[g]++
+	}
+
+	type cell struct {
+		thread string
+		bucket int
+	}
+	type counts struct{ samples, busy int }
+	cells := make(map[cell]*counts)
+	pools := make(map[string]*poolSaturation)
+	for _, s := range samples {
+		t := name(s.thread)
+		g := assignments[t]
+		if groupSize[g] < 2 {
+			continue // a thread of its own is not a pool
+		}
+		p := pools[g]
+		if p == nil {
+			p = &poolSaturation{name: g, busy: make([]float64, numBuckets), seen: make([]int, numBuckets)}
+			pools[g] = p
+		}
+		p.samples += s.weight
+		if busyState(s.state) {
+			p.busyN += s.weight
+		}
+		if s.state == stateRunning {
+			p.running += s.weight
+		}
+		idx := 0
+		if span > 0 {
+			idx = int((s.offsetNanos - origin) * int64(numBuckets) / span)
+		}
+		k := cell{t, min(max(idx, 0), numBuckets-1)}
+		c := cells[k]
+		if c == nil {
+			c = &counts{}
+			cells[k] = c
+		}
+		c.samples += s.weight
+		if busyState(s.state) {
+			c.busy += s.weight
+		}
+	}
+	for k, c := range cells {
+		p := pools[assignments[k.thread]]
+		p.seen[k.bucket]++
+		p.busy[k.bucket] += float64(c.busy) / float64(c.samples)
+	}
+
+	res := make([]poolSaturation, 0, len(pools))
+	for g, p := range pools {
+		p.threads = groupSize[g]
+		res = append(res, *p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].busyN != res[j].busyN {
+			return res[i].busyN > res[j].busyN
+		}
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
+func runThreadSaturation(path string, shared sharedFlags, top int) error {
+	if detectFormat(path) != formatJFR {
+		return fmt.Errorf("--saturation requires a JFR file (timed wall samples with thread states)")
+	}
+	if shared.event != "" && shared.event != "wall" {
+		return fmt.Errorf("--saturation reads wall samples; --event %s does not apply", shared.event)
+	}
+	window, err := parseDurationWindow("--from", shared.from, "--to", shared.to)
+	if err != nil {
+		return err
+	}
+	normalizer, err := newThreadNormalizer(shared.threadNormalize)
+	if err != nil {
+		return err
+	}
+	samples, spanNanos, err := parseWallStates(path, shared.thread, window.fromNanos, window.toNanos)
+	if err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		fmt.Println("no wall samples (record with -e wall)")
+		return nil
+	}
+	events := make([]timedEvent, len(samples))
+	for i, s := range samples {
+		events[i].offsetNanos = s.offsetNanos
+	}
+	origin, span := resolveBucketRange(window.fromNanos, window.toNanos, spanNanos, events)
+	numBuckets, width, err := computeBucketWidth(span, 0, "")
+	if err != nil {
+		return err
+	}
+	cmdThreadSaturation(computePoolSaturation(samples, origin, span, numBuckets, normalizer.name), width, top)
+	return nil
+}
+
+// cmdThreadSaturation prints one row per pool and, for pools saturated in
+// some bucket, whether their busy time runs or waits, which decides if
+// more threads would help.
+func cmdThreadSaturation(pools []poolSaturation, width int64, top int) {
+	if len(pools) == 0 {
+		fmt.Println("no thread pools in the wall samples (a pool is a group of 2+ threads, see threads --group)")
+		return
+	}
+	pools = pools[:truncate(len(pools), top)]
+	fmt.Printf("%-30s %7s %6s %6s %7s %8s %9s  %s\n", "POOL", "THREADS", "BUSY", "PEAK", "SAT", "RUNNING", "SATURATED", "BUSY OVER TIME")
+	var notes []string
+	for _, p := range pools {
+		saturated, sampled := p.saturatedBuckets()
+		line := []rune(sparklineScaled(p.busy, float64(p.threads)))
+		for i, n := range p.seen {
+			if n == 0 {
+				line[i] = ' '
+			}
+		}
+		running := "-"
+		if p.busyN > 0 {
+			running = fmt.Sprintf("%.*f%%", pctDigits, pctOf(p.running, p.busyN))
+		}
+		fmt.Printf("%-30s %7d %6.1f %6.1f %6.*f%% %8s %9s  %s\n", p.name, p.threads, p.avgBusy(), p.peakBusy(),
+			pctDigits, pctOf(p.busyN, p.samples), running, fmt.Sprintf("%d/%d", saturated, sampled), string(line))
+		if saturated == 0 {
+			continue
+		}
+		note := fmt.Sprintf("%s: saturated in %d of %d buckets", p.name, saturated, sampled)
+		if pctOf(p.running, p.busyN) < 50 {
+			note += fmt.Sprintf(", but only %.*f%% of busy time runs; the rest waits on locks or I/O, so more threads mostly add contention", pctDigits, pctOf(p.running, p.busyN))
+		} else {
+			note += "; busy time mostly runs, so more threads help only if CPUs are idle"
+		}
+		notes = append(notes, note)
+	}
+	fmt.Printf("\nBUSY/PEAK = threads running, blocked on a lock or in I/O per %s bucket (average, maximum); SAT = busy share of wall samples; SATURATED = buckets with >= %.0f%% of threads busy\n",
+		formatDuration(width), saturatedPct)
+	for _, n := range notes {
+		fmt.Println(n)
+	}
+}
@@ -22,8 +22,8 @@ func newScriptCmd() *cobra.Command {
 		Use:                "script [flags] <file>",
 		Short:              "Starlark scripting for custom analysis",
 		DisableFlagParsing: true,
-		Run: func(cmd *cobra.Command, args []string) {
-			cmdScript(args)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdScript(args)
 		},
 	}
 }
@@ -40,11 +40,11 @@ func (e *scriptExitCode) Error() string {
 	return fmt.Sprintf("exit code %d", e.code)
 }
 
-func cmdScript(args []string) {
+func cmdScript(args []string) error {
 	for _, a := range args {
 		if a == "-h" || a == "--help" {
 			fmt.Print(scriptHelpText)
-			return
+			return nil
 		}
 		if a == "--" {
 			break
@@ -66,20 +66,17 @@ func cmdScript(args []string) {
 		switch a {
 		case "-c":
 			if i+1 >= len(args) {
-				fmt.Fprintln(os.Stderr, "error: -c requires a script string")
-				os.Exit(2)
+				return usageErrorf("-c requires a script string")
 			}
 			inline = args[i+1]
 			i += 2
 		case "--timeout":
 			if i+1 >= len(args) {
-				fmt.Fprintln(os.Stderr, "error: --timeout requires a duration value")
-				os.Exit(2)
+				return usageErrorf("--timeout requires a duration value")
 			}
 			d, err := time.ParseDuration(args[i+1])
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "error: invalid --timeout value %q: %v\n", args[i+1], err)
-				os.Exit(2)
+				return usageErrorf("invalid --timeout value %q: %v", args[i+1], err)
 			}
 			timeout = d
 			i += 2
@@ -88,21 +85,20 @@ func cmdScript(args []string) {
 				scriptFile = a
 				i++
 			} else {
-				fmt.Fprintf(os.Stderr, "error: unexpected argument %q\n", a)
-				os.Exit(2)
+				return usageErrorf("unexpected argument %q", a)
 			}
 		}
 	}
 
 	if inline == "" && scriptFile == "" {
-		fmt.Fprintln(os.Stderr, "error: script command requires -c '<code>' or a script file")
-		os.Exit(2)
+		return usageErrorf("script command requires -c '<code>' or a script file")
 	}
 
-	code := runScript(inline, scriptFile, scriptArgs, timeout)
-	if code != 0 {
-		os.Exit(code)
+	// runScript has already reported any error on stderr.
+	if code := runScript(inline, scriptFile, scriptArgs, timeout); code != 0 {
+		return &exitError{code: code}
 	}
+	return nil
 }
 
 type extraPredeclared struct {
@@ -4590,3 +4590,23 @@ func TestScriptOpenCollapsedStdinRejectsUnknownEvent(t *testing.T) {
 		t.Errorf("expected 'unknown event type' error, got: %s", stderr)
 	}
 }
+
+func TestMethodAndFrameIDs(t *testing.T) {
+	sf := makeStackFile([]stack{
+		{frames: []string{"com/example/App.main", "com/example/Svc.work"}, count: 7},
+	})
+	p := newStarlarkProfile(sf, nil, "cpu", "test")
+	out := captureOutput(func() {
+		code := runScript(`
+m = p.hot(fqn=True)[0]
+print(m.id)
+print(p.stacks[0].leaf.id == m.id)
+`, "", nil, testTimeout, withPredeclared("p", p))
+		if code != 0 {
+			t.Fatalf("expected exit 0, got %d", code)
+		}
+	})
+	if want := "aaf9657570a7\nTrue"; strings.TrimSpace(out) != want {
+		t.Fatalf("got %q, want %q", out, want)
+	}
+}
@@ -964,12 +964,14 @@ func (f *starlarkFrame) Attr(name string) (starlark.Value, error) {
 		return starlark.String(f.method), nil
 	case "line":
 		return starlark.MakeInt(int(f.line)), nil
+	case "id":
+		return starlark.String(methodID(f.raw)), nil
 	}
 	return nil, starlark.NoSuchAttrError(fmt.Sprintf("Frame has no .%s attribute", name))
 }
 
 func (f *starlarkFrame) AttrNames() []string {
-	return []string{"name", "fqn", "pkg", "cls", "method", "line"}
+	return []string{"name", "fqn", "pkg", "cls", "method", "line", "id"}
 }
 
 // ---------------------------------------------------------------------------
@@ -1006,12 +1008,14 @@ func (m *starlarkMethod) Attr(name string) (starlark.Value, error) {
 		return starlark.MakeInt(m.entry.totalCount), nil
 	case "total_pct":
 		return starlark.Float(pctOf(m.entry.totalCount, m.totalSamples)), nil
+	case "id":
+		return starlark.String(methodID(m.entry.name)), nil
 	}
 	return nil, starlark.NoSuchAttrError(fmt.Sprintf("Method has no .%s attribute", name))
 }
 
 func (m *starlarkMethod) AttrNames() []string {
-	return []string{"name", "fqn", "self", "self_pct", "total", "total_pct"}
+	return []string{"name", "fqn", "self", "self_pct", "total", "total_pct", "id"}
 }
 
 // ---------------------------------------------------------------------------
@@ -0,0 +1,164 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+//go:embed testdata/cpu.jfr
+var selftestJFR []byte
+
+// What the embedded recording must yield: 5 s of cpu samples from a
+// workload whose hottest method is Workload.computeStep.
+const (
+	selftestCPUSamples = 1980
+	selftestHotMethod  = "Workload.computeStep"
+)
+
+func newSelftestCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "selftest",
+		Short: "Check this installation against an embedded sample profile",
+		Long: `Selftest writes a small embedded JFR recording to a temporary file and runs
+it through the pipeline: parsing, hot methods, call trees, timeline events,
+the collapsed text round trip and a Starlark script. Each check prints ok
+or FAIL with the reason; any failure exits with status 1. Use it to tell a
+broken download or an incompatible system from a problem with a profile.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmdSelftest()
+		},
+	}
+}
+
+type selftestCheck struct {
+	name     string
+	run      func() (string, error) // detail on success
+	required bool                   // later checks cannot run without it
+}
+
+func cmdSelftest() error {
+	info := currentBuildInfo()
+	fmt.Printf("ap-query %s (%s %s)\n", info.Version, info.GoVersion, info.Platform)
+
+	dir, err := os.MkdirTemp("", "ap-query-selftest-")
+	if err != nil {
+		fmt.Printf("  FAIL  temporary directory: %v\n", err)
+		return assertionErrorf("selftest failed: cannot create a temporary directory")
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "selftest.jfr")
+
+	var parsed *parsedProfile
+	var sf *stackFile
+	checks := []selftestCheck{
+		{"write sample profile", func() (string, error) {
+			if err := os.WriteFile(path, selftestJFR, 0o644); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d bytes", len(selftestJFR)), nil
+		}, true},
+		{"parse JFR", func() (string, error) {
+			p, err := parseJFRData(path, allEventTypes(), parseOpts{collectTimestamps: true, fromNanos: -1, toNanos: -1})
+			if err != nil {
+				return "", err
+			}
+			if p.stacksByEvent["cpu"] == nil || p.stacksByEvent["cpu"].totalSamples != selftestCPUSamples {
+				return "", fmt.Errorf("got %d cpu samples, want %d", p.eventCounts["cpu"], selftestCPUSamples)
+			}
+			parsed, sf = p, p.stacksByEvent["cpu"]
+			return fmt.Sprintf("%d cpu samples in %d stacks", sf.totalSamples, len(sf.stacks)), nil
+		}, true},
+		{"hot methods", func() (string, error) {
+			hot := computeHot(sf, false)
+			if len(hot) == 0 || hot[0].name != selftestHotMethod {
+				return "", fmt.Errorf("hottest method is not %s", selftestHotMethod)
+			}
+			return fmt.Sprintf("%s %.*f%% self", hot[0].name, pctDigits, pctOf(hot[0].selfCount, sf.totalSamples)), nil
+		}, false},
+		{"call tree", func() (string, error) {
+			tree := computeTreeString(sf, selftestHotMethod, 4, 0)
+			callers := computeCallersString(sf, selftestHotMethod, 4, 0)
+			if !strings.Contains(tree, selftestHotMethod) || !strings.Contains(callers, "Thread.run") {
+				return "", fmt.Errorf("tree or callers of %s is incomplete", selftestHotMethod)
+			}
+			return fmt.Sprintf("%d tree and %d callers lines", strings.Count(tree, "\n")+1, strings.Count(callers, "\n")+1), nil
+		}, false},
+		{"timeline events", func() (string, error) {
+			events := parsed.timedEvents["cpu"]
+			total := 0
+			for _, e := range events {
+				total += e.weight
+			}
+			if total != selftestCPUSamples {
+				return "", fmt.Errorf("got %d timed cpu samples, want %d", total, selftestCPUSamples)
+			}
+			return fmt.Sprintf("%d events over %s", len(events), formatDuration(parsed.spanNanos)), nil
+		}, false},
+		{"collapsed round trip", func() (string, error) {
+			var b strings.Builder
+			for _, c := range computeCollapsed(sf) {
+				fmt.Fprintf(&b, "%s %d\n", c.key, c.count)
+			}
+			back, err := parseCollapsed(strings.NewReader(b.String()))
+			if err != nil {
+				return "", err
+			}
+			if back.totalSamples != sf.totalSamples {
+				return "", fmt.Errorf("read back %d samples, want %d", back.totalSamples, sf.totalSamples)
+			}
+			return fmt.Sprintf("%d lines", len(back.stacks)), nil
+		}, false},
+		{"starlark script", func() (string, error) {
+			script := fmt.Sprintf(`p = open(ARGS[0], event="cpu")
+if p.samples != %d or p.hot(1)[0].name != %q:
+    fail("unexpected profile")
+`, selftestCPUSamples, selftestHotMethod)
+			if code := runScript(script, "", []string{path}, 30*time.Second); code != 0 {
+				return "", fmt.Errorf("script exited with status %d", code)
+			}
+			return "open, samples, hot", nil
+		}, false},
+	}
+
+	failed := 0
+	blocker := "" // the failed required check
+	for _, c := range checks {
+		if blocker != "" {
+			fmt.Printf("  skip  %-22s needs %s\n", c.name, blocker)
+			continue
+		}
+		detail, err := runSelftestCheck(c)
+		if err != nil {
+			failed++
+			fmt.Printf("  FAIL  %-22s %v\n", c.name, err)
+			if c.required {
+				blocker = c.name
+			}
+			continue
+		}
+		fmt.Printf("  ok    %-22s %s\n", c.name, detail)
+	}
+	if failed > 0 {
+		return assertionErrorf("selftest failed: %d of %d checks", failed, len(checks))
+	}
+	fmt.Printf("selftest passed (%d checks)\n", len(checks))
+	return nil
+}
+
+// runSelftestCheck runs one check, turning a panic into its failure so the
+// remaining checks still run.
+func runSelftestCheck(c selftestCheck) (detail string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.run()
+}
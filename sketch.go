@@ -0,0 +1,144 @@
+package main
+
+import (
+	"container/heap"
+
+	"github.com/grafana/jfr-parser/parser/types"
+)
+
+// stackAgg aggregates identical stacks. With maxStacks > 0 it keeps at most
+// maxStacks distinct stacks using the space-saving heavy-hitters algorithm:
+// when full, a new stack replaces the one with the lowest count and inherits
+// that count as its starting value. The total sample count is preserved
+// exactly; per-stack counts may be overestimated by at most maxError, and
+// any stack whose true count exceeds maxError is guaranteed to be kept.
+type stackAgg struct {
+	entries   map[stackKey]*aggValue
+	maxStacks int
+	byCount   aggHeap // min-heap on count; only maintained when maxStacks > 0
+	evictions int
+	maxError  int
+}
+
+func newStackAgg(maxStacks int) *stackAgg {
+	return &stackAgg{
+		entries:   make(map[stackKey]*aggValue),
+		maxStacks: maxStacks,
+	}
+}
+
+func (a *stackAgg) add(key stackKey, frames []string, lines []uint32, details []frameDetail, weight int) {
+	if v, ok := a.entries[key]; ok {
+		v.count += weight
+		if a.maxStacks > 0 {
+			heap.Fix(&a.byCount, v.heapIdx)
+		}
+		return
+	}
+	if a.maxStacks <= 0 {
+		a.entries[key] = &aggValue{frames: frames, lines: lines, details: details, count: weight}
+		return
+	}
+	if len(a.entries) < a.maxStacks {
+		v := &aggValue{frames: frames, lines: lines, details: details, count: weight, key: key}
+		a.entries[key] = v
+		heap.Push(&a.byCount, v)
+		return
+	}
+
+	// Full: reuse the minimum entry's slot for the new stack.
+	v := a.byCount[0]
+	delete(a.entries, v.key)
+	a.evictions++
+	if v.count > a.maxError {
+		a.maxError = v.count
+	}
+	v.key = key
+	v.frames = frames
+	v.lines = lines
+	v.details = details
+	v.count += weight
+	a.entries[key] = v
+	heap.Fix(&a.byCount, 0)
+}
+
+// approximate reports whether any stack was evicted.
+func (a *stackAgg) approximate() bool {
+	return a.evictions > 0
+}
+
+func (a *stackAgg) stackFile() *stackFile {
+	return buildStackFile(a.entries)
+}
+
+// stackCachePruner keeps the resolved-stack cache in step with bounded
+// stackAggs. Without it the cache would hold every distinct stack of the
+// recording and --max-stacks would not bound memory. Entries whose key no
+// sketch holds any more are dropped once the cache outgrows its limit; a
+// stack seen again after that is simply resolved again.
+type stackCachePruner struct {
+	base  int
+	limit int
+}
+
+// newStackCachePruner returns nil when maxStacks is unbounded.
+func newStackCachePruner(maxStacks int) *stackCachePruner {
+	if maxStacks <= 0 {
+		return nil
+	}
+	return &stackCachePruner{base: 2 * maxStacks, limit: 2 * maxStacks}
+}
+
+// maybePrune drops cache and roots entries not held by any of aggs once the
+// cache exceeds the limit. The limit then grows with what is still live, so
+// the sweeps stay amortized even when many refs resolve to held stacks.
+func (pr *stackCachePruner) maybePrune(cache map[types.StackTraceRef]*cachedStackTrace, roots contextRoots, aggs map[string]*stackAgg) {
+	if pr == nil || len(cache) <= pr.limit {
+		return
+	}
+	live := make(map[string]struct{})
+	for _, agg := range aggs {
+		for k := range agg.entries {
+			live[k.frames] = struct{}{}
+		}
+	}
+	// A context root entry keeps its source stack alive: the sketch holds
+	// the rooted key, not the cached one.
+	rooted := make(map[*cachedStackTrace]bool)
+	for k, out := range roots {
+		if _, ok := live[out.key]; ok {
+			rooted[k.stack] = true
+		} else {
+			delete(roots, k)
+		}
+	}
+	for ref, cached := range cache {
+		if _, ok := live[cached.key]; !ok && !rooted[cached] {
+			delete(cache, ref)
+		}
+	}
+	pr.limit = max(pr.base, 2*len(cache))
+}
+
+type aggHeap []*aggValue
+
+func (h aggHeap) Len() int           { return len(h) }
+func (h aggHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h aggHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIdx = i
+	h[j].heapIdx = j
+}
+
+func (h *aggHeap) Push(x any) {
+	v := x.(*aggValue)
+	v.heapIdx = len(*h)
+	*h = append(*h, v)
+}
+
+func (h *aggHeap) Pop() any {
+	old := *h
+	v := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return v
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -11,22 +15,94 @@ func newThreadsCmd() *cobra.Command {
 	var shared sharedFlags
 	var top int
 	var group bool
+	var assertArgs []string
+	var states bool
+	var saturation bool
+	var spark bool
+	var columnArgs []string
 	cmd := &cobra.Command{
 		Use:   "threads <file>",
 		Short: "Thread sample distribution",
-		Args:  cobra.ExactArgs(1),
+		Example: strings.Join([]string{
+			"  ap-query threads profile.jfr --group",
+			"  ap-query threads profile.jfr --assert 'GC Thread*<5' --assert 'pool-1-thread-*<40'",
+			"  ap-query threads profile.jfr --states --group",
+			"  ap-query threads profile.jfr --group --sparkline",
+			"  ap-query threads profile.jfr --saturation",
+			"  ap-query threads profile.jfr --group --column 'per_request = samples / $REQUESTS'",
+			"  ap-query threads profile.jfr --thread-normalize forkjoin --thread-normalize 'grpc-(\\w+)-\\d+=grpc-$1'",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			pctx, err := preprocessProfile(shared.toOpts(args[0], "threads"))
+			rules := make([]threadAssert, 0, len(assertArgs))
+			for _, a := range assertArgs {
+				rule, err := parseThreadAssert(a)
+				if err != nil {
+					return err
+				}
+				rules = append(rules, rule)
+			}
+			columns, err := parseColumnArgs(columnArgs)
+			if err != nil {
+				return err
+			}
+			if saturation {
+				switch {
+				case states:
+					return fmt.Errorf("--saturation cannot be combined with --states")
+				case spark:
+					return fmt.Errorf("--saturation cannot be combined with --sparkline")
+				case len(columns) > 0:
+					return fmt.Errorf("--column cannot be combined with --saturation")
+				case len(rules) > 0:
+					return fmt.Errorf("--saturation cannot be combined with --assert")
+				}
+				return runThreadSaturation(args[0], shared, top)
+			}
+			if states {
+				if spark {
+					return fmt.Errorf("--sparkline cannot be combined with --states")
+				}
+				if len(columns) > 0 {
+					return fmt.Errorf("--column cannot be combined with --states")
+				}
+				return runThreadStates(args[0], shared, top, group, len(rules) > 0)
+			}
+			if spark && detectFormat(args[0]) != formatJFR {
+				return fmt.Errorf("--sparkline requires a JFR file (pprof and collapsed text lack per-sample timestamps)")
+			}
+			opts := shared.toOpts(args[0], "threads")
+			opts.timestamps = spark
+			pctx, err := preprocessProfile(opts)
+			if err != nil {
+				return err
+			}
+			var activity *threadSparklines
+			if spark {
+				if activity, err = newThreadSparklines(pctx, shared); err != nil {
+					return err
+				}
+			}
+			cols, err := newCustomColumns(columns, pctx)
 			if err != nil {
 				return err
 			}
-			cmdThreads(pctx.sf, top, group)
-			return nil
+			cmdThreadsWith(pctx.sf, top, group, activity, cols)
+			return checkThreadAsserts(pctx.sf, rules)
 		},
 	}
 	shared.register(cmd)
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	shared.registerThreadNormalize(cmd)
+	shared.registerCPUs(cmd)
 	cmd.Flags().IntVar(&top, "top", 0, "Limit output rows (default: unlimited)")
 	cmd.Flags().BoolVar(&group, "group", false, "Group threads by normalized name")
+	cmd.Flags().BoolVar(&states, "states", false, "Per-thread share of wall samples running, blocked on a lock, parked, in I/O or otherwise waiting, plus lock/park event time (JFR only)")
+	cmd.Flags().BoolVar(&saturation, "saturation", false, "Per thread pool, how many threads were busy (running, blocked on a lock or in I/O) over time and how often all were, from wall samples (JFR only)")
+	cmd.Flags().BoolVar(&spark, "sparkline", false, "Add each thread's activity over time as a sparkline, all rows on one scale (JFR only)")
+	cmd.Flags().StringArrayVar(&columnArgs, "column", nil, columnUsage)
+	cmd.Flags().StringArrayVar(&assertArgs, "assert", nil, "Exit 1 unless threads matching GLOB stay below (GLOB<PCT) or above (GLOB>PCT) a share of samples; repeatable (for CI gates)")
 	return cmd
 }
 
@@ -58,6 +134,38 @@ func computeThreads(sf *stackFile) (ranked []threadEntry, noThread int, hasThrea
 	return
 }
 
+// threadTids returns the distinct tids seen per thread name, in numeric
+// order, for collapsed input with "[name tid=N]" markers. It is empty when
+// no stack carries a tid.
+func threadTids(sf *stackFile) map[string][]string {
+	seen := make(map[string]map[string]bool)
+	for i := range sf.stacks {
+		st := &sf.stacks[i]
+		if st.tid == "" {
+			continue
+		}
+		if seen[st.thread] == nil {
+			seen[st.thread] = make(map[string]bool)
+		}
+		seen[st.thread][st.tid] = true
+	}
+	tids := make(map[string][]string, len(seen))
+	for name, set := range seen {
+		list := make([]string, 0, len(set))
+		for tid := range set {
+			list = append(list, tid)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if len(list[i]) != len(list[j]) {
+				return len(list[i]) < len(list[j])
+			}
+			return list[i] < list[j]
+		})
+		tids[name] = list
+	}
+	return tids
+}
+
 // threadGroupName normalises a thread name for grouping by splitting on
 // separators (-, _, #), dropping purely-numeric segments and trimming
 // trailing digits from the remaining segments.
@@ -197,7 +305,142 @@ func groupThreads(entries []threadEntry) []threadGroupEntry {
 	return groupThreadsWith(entries, assignGroups(entries))
 }
 
+// threadSparklines holds the activity over time of every thread, by the
+// same name the thread has in sf, for the threads --sparkline column.
+type threadSparklines struct {
+	activity map[string][]int
+	scale    int
+}
+
+func newThreadSparklines(pctx *profileContext, shared sharedFlags) (*threadSparklines, error) {
+	normalizer, err := newThreadNormalizer(shared.threadNormalize)
+	if err != nil {
+		return nil, err
+	}
+	var events []timedEvent
+	for _, e := range pctx.parsed.timedEvents[pctx.eventType] {
+		if shared.thread != "" && !matchesThread(e.thread, "", shared.thread) {
+			continue
+		}
+		if shared.noIdle && isIdleStack(e.frames) {
+			continue
+		}
+		events = append(events, e)
+	}
+	origin, span := resolveBucketRange(pctx.fromNanos, pctx.toNanos, pctx.spanNanos, events)
+	numBuckets, _, err := computeBucketWidth(span, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	activity := threadActivity(events, origin, span, numBuckets, normalizer.name)
+	return &threadSparklines{activity: activity, scale: maxActivity(activity)}, nil
+}
+
+// regroup returns the sparklines summed per thread group.
+func (s *threadSparklines) regroup(assignments map[string]string) *threadSparklines {
+	grouped := make(map[string][]int)
+	for name, counts := range s.activity {
+		g := assignments[name]
+		sum := grouped[g]
+		if sum == nil {
+			sum = make([]int, len(counts))
+			grouped[g] = sum
+		}
+		for i, c := range counts {
+			sum[i] += c
+		}
+	}
+	return &threadSparklines{activity: grouped, scale: maxActivity(grouped)}
+}
+
+// column returns the sparkline of one row, or "" without --sparkline.
+func (s *threadSparklines) column(name string) string {
+	if s == nil {
+		return ""
+	}
+	return "  " + activitySparkline(s.activity[name], s.scale)
+}
+
+// activitySparkline renders bucket counts on a scale shared with other
+// rows. Empty buckets print as a space, so idle stretches stand out from
+// light load.
+func activitySparkline(counts []int, scale int) string {
+	values := make([]float64, len(counts))
+	for i, c := range counts {
+		values[i] = float64(c)
+	}
+	line := []rune(sparklineScaled(values, float64(scale)))
+	for i, c := range counts {
+		if c == 0 {
+			line[i] = ' '
+		}
+	}
+	return string(line)
+}
+
+// threadActivity returns the weight of events per thread and time bucket.
+// name maps a thread to its row (normalized or group name); events without
+// a thread are left out.
+func threadActivity(events []timedEvent, origin, span int64, numBuckets int, name func(string) string) map[string][]int {
+	activity := make(map[string][]int)
+	for i := range events {
+		e := &events[i]
+		if e.thread == "" {
+			continue
+		}
+		row := name(e.thread)
+		counts := activity[row]
+		if counts == nil {
+			counts = make([]int, numBuckets)
+			activity[row] = counts
+		}
+		idx := 0
+		if span > 0 {
+			idx = int((e.offsetNanos - origin) * int64(numBuckets) / span)
+		}
+		counts[min(max(idx, 0), numBuckets-1)] += e.weight
+	}
+	return activity
+}
+
+// maxActivity returns the largest bucket over all rows, the common scale.
+func maxActivity(activity map[string][]int) int {
+	m := 0
+	for _, counts := range activity {
+		for _, c := range counts {
+			m = max(m, c)
+		}
+	}
+	return m
+}
+
+// sortedActivityRows returns the rows of activity with their totals,
+// busiest first.
+func sortedActivityRows(activity map[string][]int) []threadEntry {
+	rows := make([]threadEntry, 0, len(activity))
+	for name, counts := range activity {
+		total := 0
+		for _, c := range counts {
+			total += c
+		}
+		rows = append(rows, threadEntry{name, total})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].samples != rows[j].samples {
+			return rows[i].samples > rows[j].samples
+		}
+		return rows[i].name < rows[j].name
+	})
+	return rows
+}
+
 func cmdThreads(sf *stackFile, top int, group bool) {
+	cmdThreadsWith(sf, top, group, nil, nil)
+}
+
+// cmdThreadsWith prints the thread table, with the --column values after
+// PCT when cols is not nil and a sparkline column when activity is not nil.
+func cmdThreadsWith(sf *stackFile, top int, group bool, activity *threadSparklines, cols *customColumns) {
 	ranked, noThread, hasThread := computeThreads(sf)
 	if !hasThread {
 		if sf.totalSamples > 0 {
@@ -207,33 +450,300 @@ func cmdThreads(sf *stackFile, top int, group bool) {
 	}
 
 	if group {
-		groups := groupThreads(ranked)
+		assignments := assignGroups(ranked)
+		groups := groupThreadsWith(ranked, assignments)
 		groups = groups[:truncate(len(groups), top)]
-		fmt.Printf("%-30s %9s %7s\n", "GROUP", "SAMPLES", "PCT")
+		if activity != nil {
+			activity = activity.regroup(assignments)
+			fmt.Printf("%-30s %9s %7s%s  %s\n", "GROUP", "SAMPLES", "PCT", cols.header(), "ACTIVITY")
+		} else {
+			fmt.Printf("%-30s %9s %7s%s\n", "GROUP", "SAMPLES", "PCT", cols.header())
+		}
 		for _, g := range groups {
 			label := g.name
 			if g.threads > 1 {
 				label = fmt.Sprintf("%s (%d threads)", g.name, g.threads)
 			}
 			pct := pctOf(g.samples, sf.totalSamples)
-			fmt.Printf("%-30s %9d %6.1f%%\n", label, g.samples, pct)
+			fmt.Printf("%-30s %9d %6.*f%%%s%s\n", label, g.samples, pctDigits, pct, cols.values(g.samples), activity.column(g.name))
 		}
 		if noThread > 0 {
 			pct := pctOf(noThread, sf.totalSamples)
-			fmt.Printf("%-30s %9d %6.1f%%\n", "(no thread info)", noThread, pct)
+			fmt.Printf("%-30s %9d %6.*f%%%s\n", "(no thread info)", noThread, pctDigits, pct, cols.values(noThread))
 		}
 		return
 	}
 
 	ranked = ranked[:truncate(len(ranked), top)]
 
-	fmt.Printf("%-30s %9s %7s\n", "THREAD", "SAMPLES", "PCT")
+	tids := threadTids(sf)
+	switch {
+	case len(tids) > 0:
+		fmt.Printf("%-30s %9s %7s%s  %s\n", "THREAD", "SAMPLES", "PCT", cols.header(), "TID")
+	case activity != nil:
+		fmt.Printf("%-30s %9s %7s%s  %s\n", "THREAD", "SAMPLES", "PCT", cols.header(), "ACTIVITY")
+	default:
+		fmt.Printf("%-30s %9s %7s%s\n", "THREAD", "SAMPLES", "PCT", cols.header())
+	}
 	for _, e := range ranked {
 		pct := pctOf(e.samples, sf.totalSamples)
-		fmt.Printf("%-30s %9d %6.1f%%\n", e.name, e.samples, pct)
+		if len(tids) > 0 {
+			line := fmt.Sprintf("%-30s %9d %6.*f%%%s  %s", e.name, e.samples, pctDigits, pct, cols.values(e.samples), strings.Join(tids[e.name], ","))
+			fmt.Println(strings.TrimRight(line, " "))
+			continue
+		}
+		fmt.Printf("%-30s %9d %6.*f%%%s%s\n", e.name, e.samples, pctDigits, pct, cols.values(e.samples), activity.column(e.name))
 	}
 	if noThread > 0 {
 		pct := pctOf(noThread, sf.totalSamples)
-		fmt.Printf("%-30s %9d %6.1f%%\n", "(no thread info)", noThread, pct)
+		fmt.Printf("%-30s %9d %6.*f%%%s\n", "(no thread info)", noThread, pctDigits, pct, cols.values(noThread))
+	}
+}
+
+type threadShift struct {
+	name    string
+	threads int // distinct threads in the group across both sides
+	before  float64
+	after   float64
+	delta   float64
+}
+
+// computeThreadShift compares each thread group's share of samples between
+// two stack files. Groups are assigned over the threads of both sides, so a
+// pool is recognised even when each recording has only one of its threads.
+func computeThreadShift(before, after *stackFile) (shifts []threadShift, hasThread bool) {
+	beforeRanked, beforeNoThread, beforeHas := computeThreads(before)
+	afterRanked, afterNoThread, afterHas := computeThreads(after)
+	if !beforeHas && !afterHas {
+		return nil, false
+	}
+	assignments := assignGroups(append(append([]threadEntry(nil), beforeRanked...), afterRanked...))
+
+	byName := make(map[string]*threadShift)
+	get := func(name string) *threadShift {
+		s := byName[name]
+		if s == nil {
+			s = &threadShift{name: name}
+			byName[name] = s
+		}
+		return s
+	}
+	for _, g := range assignments {
+		get(g).threads++
+	}
+	for _, g := range groupThreadsWith(beforeRanked, assignments) {
+		get(g.name).before = pctOf(g.samples, before.totalSamples)
+	}
+	for _, g := range groupThreadsWith(afterRanked, assignments) {
+		get(g.name).after = pctOf(g.samples, after.totalSamples)
+	}
+	if beforeNoThread > 0 || afterNoThread > 0 {
+		s := get("(no thread info)")
+		s.before = pctOf(beforeNoThread, before.totalSamples)
+		s.after = pctOf(afterNoThread, after.totalSamples)
+	}
+
+	for _, s := range byName {
+		s.delta = s.after - s.before
+		shifts = append(shifts, *s)
+	}
+	sort.Slice(shifts, func(i, j int) bool {
+		if shifts[i].delta != shifts[j].delta {
+			return shifts[i].delta > shifts[j].delta
+		}
+		return shifts[i].name < shifts[j].name
+	})
+	return shifts, true
+}
+
+// cmdDiffThreads prints thread groups whose share of samples moved by at
+// least minDelta, gains first.
+func cmdDiffThreads(before, after *stackFile, minDelta float64, top int) {
+	shifts, hasThread := computeThreadShift(before, after)
+	if !hasThread {
+		fmt.Println("no thread info in these files")
+		return
+	}
+	var changed []threadShift
+	for _, s := range shifts {
+		if math.Abs(s.delta) >= minDelta {
+			changed = append(changed, s)
+		}
+	}
+	if len(changed) == 0 {
+		fmt.Println("no significant changes")
+		return
+	}
+	shown := changed[:truncate(len(changed), top)]
+
+	fmt.Printf("%-40s %7s %7s %8s\n", "THREAD GROUP", "BEFORE", "AFTER", "DELTA")
+	for _, s := range shown {
+		label := s.name
+		if s.threads > 1 {
+			label = fmt.Sprintf("%s (%d threads)", s.name, s.threads)
+		}
+		fmt.Printf("%-40s %6.*f%% %6.*f%% %+7.*f%%\n", label, pctDigits, s.before, pctDigits, s.after, pctDigits, s.delta)
+	}
+	if len(shown) < len(changed) {
+		fmt.Printf("(%d of %d thread groups shown)\n", len(shown), len(changed))
+	}
+}
+
+// splitThreadGroups returns the stacks of sf per thread group of
+// assignments; stacks without a thread go to "(no thread info)".
+func splitThreadGroups(sf *stackFile, assignments map[string]string) map[string]*stackFile {
+	groups := make(map[string]*stackFile)
+	for i := range sf.stacks {
+		st := &sf.stacks[i]
+		g := "(no thread info)"
+		if st.thread != "" {
+			g = assignments[st.thread]
+		}
+		out := groups[g]
+		if out == nil {
+			out = &stackFile{}
+			groups[g] = out
+		}
+		out.stacks = append(out.stacks, *st)
+		out.totalSamples += st.count
+	}
+	return groups
+}
+
+// cmdDiffByThread prints the method diff of each thread group, groups
+// matched across the two profiles as in computeThreadShift, so pools line
+// up even when their threads are numbered differently in each recording.
+// Percentages are of the group's own samples; groups without significant
+// changes are only counted.
+func cmdDiffByThread(before, after *stackFile, minDelta float64, top int, fqn bool, ignore *diffIgnore) {
+	beforeRanked, _, beforeHas := computeThreads(before)
+	afterRanked, _, afterHas := computeThreads(after)
+	if !beforeHas && !afterHas {
+		fmt.Println("no thread info in these files")
+		return
+	}
+	assignments := assignGroups(append(append([]threadEntry(nil), beforeRanked...), afterRanked...))
+	threads := make(map[string]int)
+	for _, g := range assignments {
+		threads[g]++
+	}
+	beforeGroups := splitThreadGroups(before, assignments)
+	afterGroups := splitThreadGroups(after, assignments)
+
+	type groupShare struct {
+		name          string
+		before, after float64
+	}
+	byName := make(map[string]*groupShare)
+	get := func(name string) *groupShare {
+		g := byName[name]
+		if g == nil {
+			g = &groupShare{name: name}
+			byName[name] = g
+		}
+		return g
+	}
+	for g, sf := range beforeGroups {
+		get(g).before = pctOf(sf.totalSamples, before.totalSamples)
+	}
+	for g, sf := range afterGroups {
+		get(g).after = pctOf(sf.totalSamples, after.totalSamples)
+	}
+	groups := make([]groupShare, 0, len(byName))
+	for _, g := range byName {
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		wi, wj := max(groups[i].before, groups[i].after), max(groups[j].before, groups[j].after)
+		if wi != wj {
+			return wi > wj
+		}
+		return groups[i].name < groups[j].name
+	})
+
+	empty := &stackFile{}
+	shown, quiet := 0, 0
+	for _, g := range groups {
+		b, a := beforeGroups[g.name], afterGroups[g.name]
+		if b == nil {
+			b = empty
+		}
+		if a == nil {
+			a = empty
+		}
+		changes := computeDiff(b, a, minDelta, top, fqn, ignore)
+		if changes.empty() {
+			quiet++
+			continue
+		}
+		if shown > 0 {
+			fmt.Println()
+		}
+		label := g.name
+		if threads[g.name] > 1 {
+			label = fmt.Sprintf("%s (%d threads)", g.name, threads[g.name])
+		}
+		fmt.Printf("=== %s: %.*f%% -> %.*f%% of samples ===\n", label, pctDigits, g.before, pctDigits, g.after)
+		printDiffChanges(changes)
+		shown++
+	}
+	switch {
+	case shown == 0:
+		fmt.Println("no significant changes")
+	case quiet > 0:
+		fmt.Printf("\n(%d thread groups without significant changes)\n", quiet)
+	}
+}
+
+// threadAssert is one --assert rule: the combined share of samples of all
+// threads matching a glob must stay below (or above) a threshold.
+type threadAssert struct {
+	rule  string
+	glob  *regexp.Regexp
+	below bool
+	pct   float64
+}
+
+// parseThreadAssert parses "GLOB<PCT" or "GLOB>PCT". The last < or > is the
+// operator, so thread names may contain either.
+func parseThreadAssert(s string) (threadAssert, error) {
+	i := strings.LastIndexAny(s, "<>")
+	if i <= 0 {
+		return threadAssert{}, fmt.Errorf("--assert %q: expected GLOB<PCT or GLOB>PCT", s)
+	}
+	glob := strings.TrimSpace(s[:i])
+	pct, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s[i+1:]), "%"), 64)
+	if glob == "" || err != nil || pct < 0 || pct > 100 {
+		return threadAssert{}, fmt.Errorf("--assert %q: expected GLOB<PCT or GLOB>PCT with PCT between 0 and 100", s)
+	}
+	return threadAssert{rule: s, glob: globRegexp(glob), below: s[i] == '<', pct: pct}, nil
+}
+
+// checkThreadAsserts evaluates rules against the thread distribution and
+// returns an assertion error listing every rule that failed.
+func checkThreadAsserts(sf *stackFile, rules []threadAssert) error {
+	if len(rules) == 0 {
+		return nil
+	}
+	ranked, _, _ := computeThreads(sf)
+	var failed []string
+	for _, r := range rules {
+		samples, threads := 0, 0
+		for _, e := range ranked {
+			if r.glob.MatchString(e.name) {
+				samples += e.samples
+				threads++
+			}
+		}
+		pct := pctOf(samples, sf.totalSamples)
+		if r.below && pct >= r.pct {
+			failed = append(failed, fmt.Sprintf("ASSERT FAILED: %s — %d threads at %.*f%% >= threshold %.*f%%", r.rule, threads, pctDigits, pct, pctDigits, r.pct))
+		} else if !r.below && pct <= r.pct {
+			failed = append(failed, fmt.Sprintf("ASSERT FAILED: %s — %d threads at %.*f%% <= threshold %.*f%%", r.rule, threads, pctDigits, pct, pctDigits, r.pct))
+		}
+	}
+	if len(failed) > 0 {
+		return assertionErrorf("%s", strings.Join(failed, "\n"))
 	}
+	return nil
 }
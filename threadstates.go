@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/grafana/jfr-parser/parser/types"
+)
+
+// Thread states of `threads --states`, in column order.
+const (
+	stateRunning = iota
+	stateLock
+	stateParked
+	stateIO
+	stateOther
+	numThreadStates
+)
+
+var threadStateNames = [numThreadStates]string{"RUNNING", "LOCK", "PARKED", "IO", "OTHER"}
+
+// Frames that identify what a wall sample is waiting on, by short name
+// prefix. The frame closest to the leaf decides.
+var (
+	parkFramePrefixes = []string{"Unsafe.park", "LockSupport.park"}
+	lockFramePrefixes = []string{
+		"ObjectMonitor::enter", "ObjectMonitor::EnterI", "ObjectSynchronizer::enter",
+		"_complete_monitor_locking_Java", "SharedRuntime::complete_monitor_locking",
+	}
+	ioFramePrefixes = []string{
+		"SocketDispatcher.", "FileDispatcherImpl.", "NioSocketImpl.", "SocketInputStream.",
+		"SocketOutputStream.", "FileInputStream.", "FileOutputStream.", "RandomAccessFile.",
+		"IOUtil.read", "IOUtil.write", "EPoll.wait", "KQueue.poll", "Net.poll", "Net.accept",
+		"Net.connect", "epoll_wait",
+	}
+)
+
+// classifyThreadState maps a wall sample to a thread state from its stack
+// (root → leaf) and the sampled JVM state (e.g. STATE_RUNNABLE).
+func classifyThreadState(frames []string, jvmState string) int {
+	for i := len(frames) - 1; i >= 0; i-- {
+		short := shortName(frames[i])
+		switch {
+		case hasAnyPrefix(short, parkFramePrefixes):
+			return stateParked
+		case hasAnyPrefix(short, lockFramePrefixes):
+			return stateLock
+		case hasAnyPrefix(short, ioFramePrefixes):
+			return stateIO
+		}
+	}
+	if strings.Contains(jvmState, "RUNNABLE") {
+		return stateRunning
+	}
+	return stateOther
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+type threadStateEntry struct {
+	name      string
+	samples   int // wall samples
+	states    [numThreadStates]int
+	lockNanos int64 // summed MonitorEnter durations
+	parkNanos int64 // summed ThreadPark durations
+}
+
+// parseThreadStates builds a per-thread state profile from the wall samples,
+// MonitorEnter and ThreadPark events of a JFR recording. thread is a
+// substring filter; fromNanos/toNanos bound event start times (-1 = open).
+func parseThreadStates(path, thread string, fromNanos, toNanos int64) ([]threadStateEntry, error) {
+	buf, err := readJFRBytes(path)
+	if err != nil {
+		return nil, err
+	}
+	originNanos, _, err := scanChunkHeaders(buf)
+	if err != nil {
+		return nil, parseErrorf("%v", err)
+	}
+
+	byThread := make(map[string]*threadStateEntry)
+	entry := func(name string) *threadStateEntry {
+		e := byThread[name]
+		if e == nil {
+			e = &threadStateEntry{name: name}
+			byThread[name] = e
+		}
+		return e
+	}
+	stackCache := make(map[types.StackTraceRef]*cachedStackTrace)
+	p, err := newJFRParser(buf)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		typ, err := p.ParseEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, parseErrorf("parse event: %w", err)
+		}
+
+		var startTicks, durTicks uint64
+		var thRef types.ThreadRef
+		switch typ {
+		case p.TypeMap.T_WALL_CLOCK_SAMPLE:
+			startTicks, thRef = p.WallClockSample.StartTime, p.WallClockSample.SampledThread
+		case p.TypeMap.T_MONITOR_ENTER:
+			startTicks, durTicks, thRef = p.JavaMonitorEnter.StartTime, p.JavaMonitorEnter.Duration, p.JavaMonitorEnter.EventThread
+		case p.TypeMap.T_THREAD_PARK:
+			startTicks, durTicks, thRef = p.ThreadPark.StartTime, p.ThreadPark.Duration, p.ThreadPark.EventThread
+		default:
+			continue
+		}
+		hdr := p.ChunkHeader()
+		if fromNanos >= 0 || toNanos >= 0 {
+			offset := ticksToNanos(startTicks, hdr.StartTicks, hdr.StartNanos, uint64(originNanos), hdr.TicksPerSecond)
+			if (fromNanos >= 0 && offset < fromNanos) || (toNanos >= 0 && offset >= toNanos) {
+				continue
+			}
+		}
+		name := resolveThread(p, thRef)
+		if thread != "" && !strings.Contains(name, thread) {
+			continue
+		}
+
+		e := entry(name)
+		switch typ {
+		case p.TypeMap.T_WALL_CLOCK_SAMPLE:
+			weight := int(p.WallClockSample.Samples)
+			if weight < 1 {
+				weight = 1
+			}
+			jvmState := ""
+			if s := p.GetThreadState(p.WallClockSample.State); s != nil {
+				jvmState = s.Name
+			}
+			cached := resolveStackTraceCached(p, stackCache, nil, p.WallClockSample.StackTrace)
+			e.samples += weight
+			e.states[classifyThreadState(cached.frames, jvmState)] += weight
+		case p.TypeMap.T_MONITOR_ENTER:
+			e.lockNanos += ticksDurationNanos(durTicks, hdr.TicksPerSecond)
+		case p.TypeMap.T_THREAD_PARK:
+			e.parkNanos += ticksDurationNanos(durTicks, hdr.TicksPerSecond)
+		}
+	}
+
+	entries := make([]threadStateEntry, 0, len(byThread))
+	for _, e := range byThread {
+		entries = append(entries, *e)
+	}
+	sortThreadStates(entries)
+	return entries, nil
+}
+
+// sortThreadStates orders entries by wall samples, then blocked time, then name.
+func sortThreadStates(entries []threadStateEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].samples != entries[j].samples {
+			return entries[i].samples > entries[j].samples
+		}
+		bi, bj := entries[i].lockNanos+entries[i].parkNanos, entries[j].lockNanos+entries[j].parkNanos
+		if bi != bj {
+			return bi > bj
+		}
+		return entries[i].name < entries[j].name
+	})
+}
+
+// groupThreadStates merges entries by thread group (as `threads --group`).
+func groupThreadStates(entries []threadStateEntry) []threadStateEntry {
+	names := make([]threadEntry, len(entries))
+	for i, e := range entries {
+		names[i] = threadEntry{e.name, e.samples}
+	}
+	return mergeThreadStates(entries, assignGroups(names), true)
+}
+
+// mergeThreadStates sums entries that map to the same name in assignments.
+// With countThreads, merged names get a " (N threads)" suffix.
+func mergeThreadStates(entries []threadStateEntry, assignments map[string]string, countThreads bool) []threadStateEntry {
+	byGroup := make(map[string]*threadStateEntry)
+	threads := make(map[string]int)
+	for _, e := range entries {
+		g := assignments[e.name]
+		acc := byGroup[g]
+		if acc == nil {
+			acc = &threadStateEntry{}
+			byGroup[g] = acc
+		}
+		threads[g]++
+		acc.samples += e.samples
+		for s := range e.states {
+			acc.states[s] += e.states[s]
+		}
+		acc.lockNanos += e.lockNanos
+		acc.parkNanos += e.parkNanos
+	}
+	groups := make([]threadStateEntry, 0, len(byGroup))
+	for g, acc := range byGroup {
+		acc.name = g
+		if countThreads && threads[g] > 1 {
+			acc.name = fmt.Sprintf("%s (%d threads)", g, threads[g])
+		}
+		groups = append(groups, *acc)
+	}
+	sortThreadStates(groups)
+	return groups
+}
+
+func runThreadStates(path string, shared sharedFlags, top int, group, asserts bool) error {
+	if detectFormat(path) != formatJFR {
+		return fmt.Errorf("--states requires a JFR file (wall samples, lock and park events)")
+	}
+	if shared.event != "" {
+		return fmt.Errorf("--states combines wall, lock and park events; --event does not apply")
+	}
+	if asserts {
+		return fmt.Errorf("--states cannot be combined with --assert")
+	}
+	window, err := parseDurationWindow("--from", shared.from, "--to", shared.to)
+	if err != nil {
+		return err
+	}
+	normalizer, err := newThreadNormalizer(shared.threadNormalize)
+	if err != nil {
+		return err
+	}
+	entries, err := parseThreadStates(path, shared.thread, window.fromNanos, window.toNanos)
+	if err != nil {
+		return err
+	}
+	if normalizer != nil {
+		assignments := make(map[string]string, len(entries))
+		for _, e := range entries {
+			assignments[e.name] = normalizer.name(e.name)
+		}
+		entries = mergeThreadStates(entries, assignments, false)
+	}
+	cmdThreadStates(entries, top, group)
+	return nil
+}
+
+func cmdThreadStates(entries []threadStateEntry, top int, group bool) {
+	if len(entries) == 0 {
+		fmt.Println("no wall, lock or park events (record with -e wall and/or --lock)")
+		return
+	}
+	label := "THREAD"
+	if group {
+		entries = groupThreadStates(entries)
+		label = "GROUP"
+	}
+	hasLock, hasPark := false, false
+	for _, e := range entries {
+		hasLock = hasLock || e.lockNanos > 0
+		hasPark = hasPark || e.parkNanos > 0
+	}
+	entries = entries[:truncate(len(entries), top)]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-30s %9s", label, "SAMPLES")
+	for _, s := range threadStateNames {
+		fmt.Fprintf(&b, " %8s", s)
+	}
+	if hasLock {
+		fmt.Fprintf(&b, " %10s", "LOCK-TIME")
+	}
+	if hasPark {
+		fmt.Fprintf(&b, " %10s", "PARK-TIME")
+	}
+	fmt.Println(b.String())
+	for _, e := range entries {
+		b.Reset()
+		fmt.Fprintf(&b, "%-30s %9d", e.name, e.samples)
+		for _, n := range e.states {
+			if e.samples == 0 {
+				fmt.Fprintf(&b, " %8s", "-")
+			} else {
+				fmt.Fprintf(&b, " %7.*f%%", pctDigits, pctOf(n, e.samples))
+			}
+		}
+		if hasLock {
+			fmt.Fprintf(&b, " %10s", formatDuration(e.lockNanos))
+		}
+		if hasPark {
+			fmt.Fprintf(&b, " %10s", formatDuration(e.parkNanos))
+		}
+		fmt.Println(b.String())
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,11 +18,12 @@ func newTimelineCmd() *cobra.Command {
 	var buckets int
 	var resolution string
 	var compare string
-	var method string
+	var mf methodFlags
 	var noTopMethod bool
 	var topN int
 	var pctFlag bool
 	var hide string
+	var rows timelineThreadRows
 	cmd := &cobra.Command{
 		Use:   "timeline <file>",
 		Short: "Sample distribution over time (JFR only)",
@@ -29,6 +31,8 @@ func newTimelineCmd() *cobra.Command {
 			"  ap-query timeline profile.jfr --buckets 20",
 			"  ap-query timeline profile.jfr --method HashMap.get --pct",
 			"  ap-query timeline profile.jfr --compare cpu,wall --thread worker",
+			"  ap-query timeline profile.jfr --thread pool- --sparkline",
+			"  ap-query timeline profile.jfr --thread pool- --thread-buckets --resolution 1s",
 		}, "\n"),
 		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -40,7 +44,7 @@ func newTimelineCmd() *cobra.Command {
 				if shared.event != "" {
 					return fmt.Errorf("--event cannot be used with --compare")
 				}
-				if method != "" {
+				if mf.method != "" {
 					return fmt.Errorf("--method cannot be used with --compare")
 				}
 				if pctFlag {
@@ -55,6 +59,16 @@ func newTimelineCmd() *cobra.Command {
 				if noTopMethod {
 					return fmt.Errorf("--no-top-method cannot be used with --compare")
 				}
+				if rows.spark {
+					return fmt.Errorf("--sparkline cannot be used with --compare")
+				}
+				if rows.counts {
+					return fmt.Errorf("--thread-buckets cannot be used with --compare")
+				}
+			}
+
+			if err := mf.validate(); err != nil {
+				return err
 			}
 
 			pctx, err := preprocessProfile(shared.toOpts(args[0], "timeline"))
@@ -73,20 +87,28 @@ func newTimelineCmd() *cobra.Command {
 				}
 				hideRe = re
 			}
-			return cmdTimeline(pctx.parsed, pctx.eventType, buckets, resolution, method,
+			m, err := mf.resolve(pctx.sf, args[0])
+			if err != nil {
+				return err
+			}
+			return cmdTimelineWith(pctx.parsed, pctx.eventType, buckets, resolution, m,
 				!noTopMethod, shared.noIdle, hideRe, shared.thread,
-				pctx.fromNanos, pctx.toNanos, topN, pctFlag)
+				pctx.fromNanos, pctx.toNanos, topN, pctFlag, rows)
 		},
 	}
 	shared.register(cmd)
+	shared.registerExplain(cmd)
+	registerSample(cmd)
 	cmd.Flags().IntVar(&buckets, "buckets", 0, "Number of time buckets (default: auto ~20)")
 	cmd.Flags().StringVar(&resolution, "resolution", "", "Fixed bucket width (e.g. 1s, 500ms)")
 	cmd.Flags().StringVar(&compare, "compare", "", "Compare events as a ratio (cpu,wall or wall,cpu; incompatible with --event/--method/--pct/--hide/--top/--no-top-method)")
-	cmd.Flags().StringVarP(&method, "method", "m", "", "Only count samples containing METHOD")
+	mf.register(cmd, "Only count samples containing METHOD")
 	cmd.Flags().BoolVar(&noTopMethod, "no-top-method", false, "Omit per-bucket hot method annotation")
 	cmd.Flags().IntVar(&topN, "top", 0, "Show only the N highest-sample buckets")
 	cmd.Flags().BoolVar(&pctFlag, "pct", false, "Show method percentage per bucket")
 	cmd.Flags().StringVar(&hide, "hide", "", "Remove matching frames before analysis (regex)")
+	cmd.Flags().BoolVar(&rows.spark, "sparkline", false, "Follow the buckets with one sparkline per thread over the same buckets, all on one scale")
+	cmd.Flags().BoolVar(&rows.counts, "thread-buckets", false, "Follow the buckets with each thread's sample count in every bucket, first bucket first")
 	return cmd
 }
 
@@ -178,9 +200,30 @@ func computeBucketWidth(bucketSpan int64, buckets int, resolution string) (numBu
 }
 
 func cmdTimeline(parsed *parsedProfile, eventType string,
-	buckets int, resolution string, method string, topMethod bool,
+	buckets int, resolution string, m methodMatcher, topMethod bool,
 	noIdle bool, hide *regexp.Regexp, thread string, fromNanos, toNanos int64,
 	topN int, pct bool) error {
+	return cmdTimelineWith(parsed, eventType, buckets, resolution, m, topMethod,
+		noIdle, hide, thread, fromNanos, toNanos, topN, pct, timelineThreadRows{})
+}
+
+// timelineSparklineThreads caps the per-thread rows of timeline --sparkline
+// and --thread-buckets.
+const timelineSparklineThreads = 20
+
+// timelineThreadRows selects what the per-thread rows after the timeline
+// show; with neither there are none.
+type timelineThreadRows struct {
+	spark  bool // --sparkline: activity as a sparkline
+	counts bool // --thread-buckets: the sample count of each bucket
+}
+
+// cmdTimelineWith prints the timeline and, as rows asks, one row per
+// thread over the same buckets.
+func cmdTimelineWith(parsed *parsedProfile, eventType string,
+	buckets int, resolution string, m methodMatcher, topMethod bool,
+	noIdle bool, hide *regexp.Regexp, thread string, fromNanos, toNanos int64,
+	topN int, pct bool, rows timelineThreadRows) error {
 
 	events := parsed.timedEvents[eventType]
 	bucketOrigin, bucketSpan := resolveBucketRange(fromNanos, toNanos, parsed.spanNanos, events)
@@ -193,7 +236,7 @@ func cmdTimeline(parsed *parsedProfile, eventType string,
 		}
 		var filtered []timedEvent
 		for i := range events {
-			if len(events[i].frames) == 0 || !isIdleLeaf(events[i].frames[len(events[i].frames)-1]) {
+			if !isIdleStack(events[i].frames) {
 				filtered = append(filtered, events[i])
 			}
 		}
@@ -203,8 +246,8 @@ func cmdTimeline(parsed *parsedProfile, eventType string,
 			filteredWeight += events[i].weight
 		}
 		if totalBefore > 0 {
-			fmt.Fprintf(os.Stderr, "Idle filter: %d/%d samples remain (%.1f%% idle removed)\n",
-				filteredWeight, totalBefore, pctOf(totalBefore-filteredWeight, totalBefore))
+			fmt.Fprintf(os.Stderr, "Idle filter: %d/%d samples remain (%.*f%% idle removed)\n",
+				filteredWeight, totalBefore, pctDigits, pctOf(totalBefore-filteredWeight, totalBefore))
 		}
 	}
 
@@ -239,8 +282,8 @@ func cmdTimeline(parsed *parsedProfile, eventType string,
 			filteredWeight += events[i].weight
 		}
 		if totalBefore > 0 {
-			fmt.Fprintf(os.Stderr, "Thread filter: %s — %d/%d samples (%.1f%%)\n",
-				thread, filteredWeight, totalBefore, pctOf(filteredWeight, totalBefore))
+			fmt.Fprintf(os.Stderr, "Thread filter: %s — %d/%d samples (%.*f%%)\n",
+				thread, filteredWeight, totalBefore, pctDigits, pctOf(filteredWeight, totalBefore))
 		}
 	}
 
@@ -253,11 +296,11 @@ func cmdTimeline(parsed *parsedProfile, eventType string,
 	// Method filtering.
 	preMethodEvents := events
 	var matchedWeight int
-	if method != "" {
+	if m.pattern != "" {
 		var methodFiltered []timedEvent
 		for i := range events {
 			for _, fr := range events[i].frames {
-				if matchesMethod(fr, method) {
+				if m.matches(fr) {
 					methodFiltered = append(methodFiltered, events[i])
 					matchedWeight += events[i].weight
 					break
@@ -269,12 +312,12 @@ func cmdTimeline(parsed *parsedProfile, eventType string,
 		matchedWeight = totalWeight
 	}
 
-	if pct && method == "" {
+	if pct && m.pattern == "" {
 		return fmt.Errorf("--pct requires --method")
 	}
 
-	if method != "" && matchedWeight == 0 {
-		noMatchMessage(os.Stdout, stackFileFromEvents(preMethodEvents), method)
+	if m.pattern != "" && matchedWeight == 0 {
+		noMatchMessage(os.Stdout, stackFileFromEvents(preMethodEvents), m.pattern)
 		return nil
 	}
 
@@ -363,12 +406,13 @@ func cmdTimeline(parsed *parsedProfile, eventType string,
 	if topN > 0 {
 		header += fmt.Sprintf("  Top: %d", topN)
 	}
-	if method != "" {
+	if m.pattern != "" {
 		header += fmt.Sprintf("  Matched: %d/%d", matchedWeight, totalWeight)
 	} else {
 		header += fmt.Sprintf("  Total: %d", matchedWeight)
 	}
 	fmt.Println(header)
+	printTimelineWindow(parsed, bucketOrigin, bucketSpan)
 	fmt.Println()
 
 	// Compute peak threshold (>2x median) from ALL buckets.
@@ -449,7 +493,7 @@ func cmdTimeline(parsed *parsedProfile, eventType string,
 			if totalBucketCounts[i] > 0 {
 				pctVal = 100.0 * float64(count) / float64(totalBucketCounts[i])
 			}
-			valueStr = fmt.Sprintf("%5.1f%%", pctVal)
+			valueStr = fmt.Sprintf("%5.*f%%", pctDigits, pctVal)
 			if maxPct > 0 {
 				barFraction = pctVal / maxPct
 			}
@@ -504,9 +548,51 @@ func cmdTimeline(parsed *parsedProfile, eventType string,
 			fmt.Printf("%-17s %s  %s%s\n", timeLabel, valueStr, bar, peak)
 		}
 	}
+	if rows.spark || rows.counts {
+		printTimelineThreads(events, bucketOrigin, bucketSpan, numBuckets, rows)
+	}
 	return nil
 }
 
+// printTimelineThreads prints the busiest threads' activity across the
+// timeline buckets, first bucket leftmost: as a sparkline, and as the
+// samples of each bucket for reading exact numbers.
+func printTimelineThreads(events []timedEvent, origin, span int64, numBuckets int, show timelineThreadRows) {
+	activity := threadActivity(events, origin, span, numBuckets, func(t string) string { return t })
+	if len(activity) == 0 {
+		return
+	}
+	rows := sortedActivityRows(activity)
+	shown := rows[:truncate(len(rows), timelineSparklineThreads)]
+	scale := maxActivity(activity)
+	fmt.Println()
+	header := fmt.Sprintf("%-30s %9s", "Thread", "Samples")
+	if show.spark {
+		header += fmt.Sprintf("  %-*s", numBuckets, "Activity")
+	}
+	if show.counts {
+		header += "  Per bucket"
+	}
+	fmt.Println(strings.TrimRight(header, " "))
+	for _, r := range shown {
+		line := fmt.Sprintf("%-30s %9d", r.name, r.samples)
+		if show.spark {
+			line += "  " + activitySparkline(activity[r.name], scale)
+		}
+		if show.counts {
+			counts := make([]string, len(activity[r.name]))
+			for i, c := range activity[r.name] {
+				counts[i] = strconv.Itoa(c)
+			}
+			line += "  " + strings.Join(counts, " ")
+		}
+		fmt.Println(line)
+	}
+	if len(shown) < len(rows) {
+		fmt.Printf("(%d of %d threads shown)\n", len(shown), len(rows))
+	}
+}
+
 func cmdTimelineCompare(parsed *parsedProfile, leftEvent, rightEvent string,
 	buckets int, resolution string, noIdle bool, thread string, fromNanos, toNanos int64) error {
 
@@ -545,8 +631,8 @@ func cmdTimelineCompare(parsed *parsedProfile, leftEvent, rightEvent string,
 			filteredWeight += leftEvents[i].weight
 		}
 		if totalBefore > 0 {
-			fmt.Fprintf(os.Stderr, "Idle filter (cpu): %d/%d samples remain (%.1f%% idle removed)\n",
-				filteredWeight, totalBefore, pctOf(totalBefore-filteredWeight, totalBefore))
+			fmt.Fprintf(os.Stderr, "Idle filter (cpu): %d/%d samples remain (%.*f%% idle removed)\n",
+				filteredWeight, totalBefore, pctDigits, pctOf(totalBefore-filteredWeight, totalBefore))
 		}
 
 		totalBefore = 0
@@ -559,8 +645,8 @@ func cmdTimelineCompare(parsed *parsedProfile, leftEvent, rightEvent string,
 			filteredWeight += rightEvents[i].weight
 		}
 		if totalBefore > 0 {
-			fmt.Fprintf(os.Stderr, "Idle filter (wall): %d/%d samples remain (%.1f%% idle removed)\n",
-				filteredWeight, totalBefore, pctOf(totalBefore-filteredWeight, totalBefore))
+			fmt.Fprintf(os.Stderr, "Idle filter (wall): %d/%d samples remain (%.*f%% idle removed)\n",
+				filteredWeight, totalBefore, pctDigits, pctOf(totalBefore-filteredWeight, totalBefore))
 		}
 	}
 
@@ -581,8 +667,8 @@ func cmdTimelineCompare(parsed *parsedProfile, leftEvent, rightEvent string,
 			filteredWeight += leftEvents[i].weight
 		}
 		if totalBefore > 0 {
-			fmt.Fprintf(os.Stderr, "Thread filter (cpu): %s — %d/%d samples (%.1f%%)\n",
-				thread, filteredWeight, totalBefore, pctOf(filteredWeight, totalBefore))
+			fmt.Fprintf(os.Stderr, "Thread filter (cpu): %s — %d/%d samples (%.*f%%)\n",
+				thread, filteredWeight, totalBefore, pctDigits, pctOf(filteredWeight, totalBefore))
 		}
 
 		totalBefore = 0
@@ -601,8 +687,8 @@ func cmdTimelineCompare(parsed *parsedProfile, leftEvent, rightEvent string,
 			filteredWeight += rightEvents[i].weight
 		}
 		if totalBefore > 0 {
-			fmt.Fprintf(os.Stderr, "Thread filter (wall): %s — %d/%d samples (%.1f%%)\n",
-				thread, filteredWeight, totalBefore, pctOf(filteredWeight, totalBefore))
+			fmt.Fprintf(os.Stderr, "Thread filter (wall): %s — %d/%d samples (%.*f%%)\n",
+				thread, filteredWeight, totalBefore, pctDigits, pctOf(filteredWeight, totalBefore))
 		}
 	}
 
@@ -647,8 +733,10 @@ func cmdTimelineCompare(parsed *parsedProfile, leftEvent, rightEvent string,
 
 	durationStr := formatDuration(bucketSpan)
 	widthStr := formatBucketWidth(bucketWidth)
-	fmt.Printf("Duration: %s  Buckets: %d (%s each)  Compare: cpu/wall  Total: cpu=%d wall=%d\n\n",
+	fmt.Printf("Duration: %s  Buckets: %d (%s each)  Compare: cpu/wall  Total: cpu=%d wall=%d\n",
 		durationStr, numBuckets, widthStr, leftTotal, rightTotal)
+	printTimelineWindow(parsed, bucketOrigin, bucketSpan)
+	fmt.Println()
 	fmt.Printf("%-17s %8s %8s %9s\n", "Time", "CPU", "WALL", "CPU/WALL")
 
 	for i := 0; i < numBuckets; i++ {
@@ -747,3 +835,46 @@ func formatDuration(nanos int64) string {
 	sec := totalSec - float64(minutes*60)
 	return fmt.Sprintf("%dm%.1fs", minutes, sec)
 }
+
+// printTimelineWindow prints the wall-clock range of the bucketed window,
+// when the recording start is known.
+func printTimelineWindow(parsed *parsedProfile, bucketOrigin, bucketSpan int64) {
+	if parsed.originNanos > 0 {
+		fmt.Printf("Span: %s\n", formatRecordingRange(parsed.originNanos+bucketOrigin, bucketSpan))
+	}
+}
+
+// utcTimes (--utc) prints recording times in UTC only.
+var utcTimes bool
+
+// formatRecordingRange renders the wall-clock span of a recording as
+// ISO-8601 UTC, followed unless --utc by the same range in local time with
+// its zone, so readers in other time zones cannot misread either form.
+func formatRecordingRange(originNanos, spanNanos int64) string {
+	loc := time.Local
+	if utcTimes {
+		loc = nil
+	}
+	return formatTimeRange(time.Unix(0, originNanos), time.Duration(spanNanos), loc)
+}
+
+// formatTimeRange formats start..start+span in UTC and, when loc is non-nil
+// and not UTC itself, in loc; the local end repeats the date only when it
+// falls on another day.
+func formatTimeRange(start time.Time, span time.Duration, loc *time.Location) string {
+	start = start.UTC()
+	end := start.Add(span)
+	s := start.Format(time.RFC3339) + " to " + end.Format(time.RFC3339)
+	if loc == nil {
+		return s
+	}
+	ls, le := start.In(loc), end.In(loc)
+	if name, offset := ls.Zone(); offset == 0 && name == "UTC" {
+		return s
+	}
+	endLayout := "15:04:05 MST"
+	if ls.YearDay() != le.YearDay() || ls.Year() != le.Year() {
+		endLayout = "Mon 2 Jan 2006 15:04:05 MST"
+	}
+	return s + " (local: " + ls.Format("Mon 2 Jan 2006 15:04:05") + " to " + le.Format(endLayout) + ")"
+}
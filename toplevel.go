@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newTopLevelCmd() *cobra.Command {
+	var shared sharedFlags
+	var entryArgs, plumbingArgs, endpointFiles []string
+	var top int
+	var fqn bool
+	cmd := &cobra.Command{
+		Use:   "top-level <file>",
+		Short: "Attribute samples to their entry point (endpoint, job, handler)",
+		Long: `Top-level charges every sample to one entry point: the first frame, from
+the thread's root down, that is not plumbing. Plumbing is the JDK, native
+code, generated classes (lambdas, proxies) and the servers and frameworks
+between a thread's start and the application (Tomcat, Jetty, Netty,
+Spring, ...). The result answers which endpoint or job consumed the time
+rather than which method.
+
+--entry marks frames that are entry points whatever their package (for
+example your controllers, or a framework method when its argument is the
+job); the first frame matching one wins. --plumbing adds packages to skip.
+Both take globs on the short or fully-qualified name, are repeatable, and
+read one glob per line from @FILE. Stacks with nothing but plumbing are
+counted as (no entry point).
+
+Frameworks are recognized by their dispatcher, the frame through which they
+call application code (Spring MVC, WebFlux, JAX-RS, gRPC, Kafka, JMS,
+RabbitMQ, Micronaut, @Scheduled, Quartz, servlets): the application frame
+below the innermost dispatcher is the endpoint, so a controller wins over
+the servlet filters around it, and the VIA column names the framework.
+--endpoints FILE adds dispatchers, one "FRAMEWORK GLOB" line each, e.g.
+"jobs com.acme.jobs.Runner.dispatch".`,
+		Example: strings.Join([]string{
+			"  ap-query top-level profile.jfr",
+			"  ap-query top-level profile.jfr --entry '*Controller.*' --plumbing 'com.acme.framework.*'",
+			"  ap-query top-level profile.jfr --entry @entries.txt -t http-nio",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			attr, err := newEntryAttributor(entryArgs, plumbingArgs, endpointFiles)
+			if err != nil {
+				return err
+			}
+			pctx, err := preprocessProfile(shared.toOpts(args[0], "top-level"))
+			if err != nil {
+				return err
+			}
+			cmdTopLevel(pctx.sf, attr, top, fqn)
+			return nil
+		},
+	}
+	shared.register(cmd)
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	cmd.Flags().StringArrayVar(&entryArgs, "entry", nil, "Treat frames matching this glob as entry points; repeatable, @FILE reads one glob per line")
+	cmd.Flags().StringArrayVar(&plumbingArgs, "plumbing", nil, "Skip frames matching this glob as plumbing, in addition to the built-in list; repeatable, @FILE reads one glob per line")
+	cmd.Flags().StringArrayVar(&endpointFiles, "endpoints", nil, "Read framework dispatchers from FILE, one \"FRAMEWORK GLOB\" line each; repeatable")
+	cmd.Flags().IntVar(&top, "top", 20, "Limit output rows")
+	cmd.Flags().BoolVar(&fqn, "fqn", false, "Show fully-qualified names")
+	return cmd
+}
+
+// defaultPlumbing are the packages of the runtime, servers and frameworks
+// that sit between a thread's start and the application's own code.
+var defaultPlumbing = []string{
+	"java.*", "javax.*", "jakarta.*", "jdk.*", "sun.*", "com.sun.*",
+	"kotlin.*", "kotlinx.*", "scala.*",
+	"org.springframework.*", "org.apache.catalina.*", "org.apache.coyote.*", "org.apache.tomcat.*",
+	"org.eclipse.jetty.*", "io.undertow.*", "io.netty.*", "reactor.*", "io.reactivex.*",
+	"io.grpc.*", "io.vertx.*", "akka.*", "org.glassfish.*", "org.jboss.*",
+	"io.micronaut.*", "io.quarkus.*", "io.dropwizard.*",
+	"org.apache.kafka.*", "org.quartz.*", "com.google.common.*",
+	// Code generated into application packages.
+	"*Grpc$MethodHandlers.*", "*$$*CGLIB$$*", "*$Definition$Exec.*",
+}
+
+// noEntryPoint labels samples whose stack is plumbing from root to leaf.
+const noEntryPoint = "(no entry point)"
+
+// entryAttributor finds the entry point frame of a stack.
+type entryAttributor struct {
+	entries   []*regexp.Regexp
+	plumbing  []*regexp.Regexp
+	endpoints []endpointPattern
+	kind      map[string]frameKind // memoized per raw frame
+}
+
+type frameKind struct {
+	role      frameRole
+	framework string // set on framework dispatcher frames
+}
+
+type frameRole int
+
+const (
+	roleOther frameRole = iota
+	roleEntry
+	rolePlumbing
+)
+
+func newEntryAttributor(entryArgs, plumbingArgs, endpointFiles []string) (*entryAttributor, error) {
+	entries, err := compileGlobArgs("--entry", entryArgs)
+	if err != nil {
+		return nil, err
+	}
+	plumbing, err := compileGlobArgs("--plumbing", append(append([]string(nil), defaultPlumbing...), plumbingArgs...))
+	if err != nil {
+		return nil, err
+	}
+	endpoints, err := loadEndpointPatterns(endpointFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &entryAttributor{entries: entries, plumbing: plumbing, endpoints: endpoints, kind: make(map[string]frameKind)}, nil
+}
+
+func (a *entryAttributor) classify(frame string) frameKind {
+	if k, ok := a.kind[frame]; ok {
+		return k
+	}
+	short, full := shortName(frame), displayName(frame, true)
+	r := roleOther
+	switch {
+	case matchesAny(a.entries, short) || matchesAny(a.entries, full):
+		r = roleEntry
+	case sourceFileOf(frame, false) == nativeFile || isGeneratedFrame(frame) ||
+		matchesAny(a.plumbing, short) || matchesAny(a.plumbing, full):
+		r = rolePlumbing
+	}
+	k := frameKind{role: r}
+	if r != roleEntry {
+		k.framework = matchEndpoint(a.endpoints, frame)
+	}
+	a.kind[frame] = k
+	return k
+}
+
+// isGeneratedFrame reports whether frame belongs to a class the JVM
+// generated (lambdas, reflection accessors, proxies): glue, never an entry.
+func isGeneratedFrame(frame string) bool {
+	return strings.Contains(frame, "$$Lambda") || canonicalSyntheticName(frame) != frame
+}
+
+// entryIndex returns the index of the entry point frame in root-first
+// frames and the framework that dispatched to it: the first --entry match,
+// else the first application frame below the innermost dispatcher, else
+// the first frame that is not plumbing or generated, else -1.
+func (a *entryAttributor) entryIndex(frames []string) (int, string) {
+	first, endpoint := -1, -1
+	var framework, pending string
+	for i, fr := range frames {
+		k := a.classify(fr)
+		switch {
+		case k.role == roleEntry:
+			return i, ""
+		case k.framework != "":
+			pending = k.framework
+		case k.role == roleOther:
+			if first < 0 {
+				first = i
+			}
+			if pending != "" {
+				endpoint, framework, pending = i, pending, ""
+			}
+		}
+	}
+	if endpoint >= 0 {
+		return endpoint, framework
+	}
+	return first, ""
+}
+
+type topLevelEntry struct {
+	name      string
+	framework string // "" unless a framework dispatcher led to it
+	samples   int
+}
+
+// computeTopLevel returns the samples per entry point, largest first.
+func computeTopLevel(sf *stackFile, a *entryAttributor, fqn bool) []topLevelEntry {
+	type key struct{ name, framework string }
+	counts := make(map[key]int)
+	for i := range sf.stacks {
+		st := &sf.stacks[i]
+		k := key{name: noEntryPoint}
+		if j, framework := a.entryIndex(st.frames); j >= 0 {
+			k = key{displayName(st.frames[j], fqn), framework}
+		}
+		counts[k] += st.count
+	}
+	ranked := make([]topLevelEntry, 0, len(counts))
+	for k, n := range counts {
+		ranked = append(ranked, topLevelEntry{k.name, k.framework, n})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].samples != ranked[j].samples {
+			return ranked[i].samples > ranked[j].samples
+		}
+		if ranked[i].name != ranked[j].name {
+			return ranked[i].name < ranked[j].name
+		}
+		return ranked[i].framework < ranked[j].framework
+	})
+	return ranked
+}
+
+func cmdTopLevel(sf *stackFile, a *entryAttributor, top int, fqn bool) {
+	if sf.totalSamples == 0 {
+		fmt.Fprintln(os.Stdout, "no samples (empty profile or all filtered out)")
+		return
+	}
+	ranked := computeTopLevel(sf, a, fqn)
+	shown := ranked[:truncate(len(ranked), top)]
+	fmt.Printf("%-60s %-14s %9s %7s\n", "ENTRY POINT", "VIA", "SAMPLES", "%")
+	for _, e := range shown {
+		via := e.framework
+		if via == "" {
+			via = "-"
+		}
+		fmt.Printf("%-60s %-14s %9d %6.*f%%\n", e.name, via, e.samples, pctDigits, pctOf(e.samples, sf.totalSamples))
+	}
+	if len(shown) < len(ranked) {
+		fmt.Printf("(%d of %d entry points shown)\n", len(shown), len(ranked))
+	}
+}
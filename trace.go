@@ -13,18 +13,22 @@ import (
 
 func newTraceCmd() *cobra.Command {
 	var shared sharedFlags
-	var method string
+	var mf methodFlags
 	var minPct float64
 	var fqn bool
 	var hide string
+	var highlight bool
 	cmd := &cobra.Command{
 		Use:   "trace <file>",
 		Short: "Hottest path from a method to leaf (-m required)",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if method == "" {
+			if mf.method == "" {
 				return fmt.Errorf("-m/--method required")
 			}
+			if err := mf.validate(); err != nil {
+				return err
+			}
 			pctx, err := preprocessProfile(shared.toOpts(args[0], "trace"))
 			if err != nil {
 				return err
@@ -37,41 +41,51 @@ func newTraceCmd() *cobra.Command {
 				}
 				sf = sf.hideFrames(re)
 			}
-			cmdTrace(sf, method, minPct, fqn)
+			m, err := mf.resolve(sf, args[0])
+			if err != nil {
+				return err
+			}
+			cmdTrace(sf, m, minPct, fqn, highlight)
 			return nil
 		},
 	}
 	shared.register(cmd)
-	cmd.Flags().StringVarP(&method, "method", "m", "", "Substring match on method name (required)")
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	mf.register(cmd, "Substring match on method name (required)")
 	cmd.Flags().Float64Var(&minPct, "min-pct", 0.5, "Hide nodes below this %")
 	cmd.Flags().BoolVar(&fqn, "fqn", false, "Show fully-qualified names")
 	cmd.Flags().StringVar(&hide, "hide", "", "Remove matching frames before analysis (regex)")
+	cmd.Flags().BoolVar(&highlight, "highlight", false, "Mark frames matched by -m with \""+highlightMarker+"\"")
 	return cmd
 }
 
-func cmdTrace(sf *stackFile, method string, minPct float64, fqn bool) {
+func cmdTrace(sf *stackFile, m methodMatcher, minPct float64, fqn, highlight bool) {
 	if sf.totalSamples == 0 {
 		fmt.Fprintln(os.Stdout, "no samples (empty profile or all filtered out)")
 		return
 	}
-	writeTrace(os.Stdout, sf, method, minPct, fqn)
+	writeTrace(os.Stdout, sf, m, minPct, fqn, highlight)
 }
 
-func writeTrace(w io.Writer, sf *stackFile, method string, minPct float64, fqn bool) {
-	pt := aggregatePaths(sf, method, func(frames []string, j int) []string {
+func writeTrace(w io.Writer, sf *stackFile, m methodMatcher, minPct float64, fqn, highlight bool) {
+	name := shortName
+	if fqn {
+		name = func(fr string) string { return displayName(fr, true) }
+	}
+	pt := aggregatePaths(sf, m, func(frames []string, j int) []string {
 		path := make([]string, len(frames)-j)
 		for k := j; k < len(frames); k++ {
-			if fqn {
-				path[k-j] = displayName(frames[k], true)
-			} else {
-				path[k-j] = shortName(frames[k])
-			}
+			path[k-j] = name(frames[k])
 		}
 		return path
 	})
+	if highlight {
+		pt.markMatches(sf, m, name)
+	}
 
 	if len(pt.samples) == 0 {
-		noMatchMessage(w, sf, method)
+		noMatchMessage(w, sf, m.pattern)
 		return
 	}
 
@@ -139,13 +153,16 @@ func childrenAboveMinPct(pt *pathTree, prefix string, minPct float64) []traceChi
 	return children
 }
 
-// ftraceHottestPath walks from root following the hottest child at each level.
+// ftraceHottestPath walks from root following the hottest child at each
+// level. A path deeper than elideAbove prints its first elideHead and last
+// elideTail frames unless --full-stacks is set.
 func ftraceHottestPath(w io.Writer, pt *pathTree, rootKey string, minPct float64) {
 	prefix := rootKey
-	indent := 0
 	// siblingAnnotation is computed when we pick a child, then printed
 	// on that child's line (the next iteration).
 	siblingAnnotation := ""
+	var lines []string // path lines, unindented
+	leaf := ""
 
 	for {
 		samples := pt.samples[prefix]
@@ -156,13 +173,12 @@ func ftraceHottestPath(w io.Writer, pt *pathTree, rootKey string, minPct float64
 
 		parts := strings.Split(prefix, ";")
 		name := parts[len(parts)-1]
-		pad := strings.Repeat("  ", indent)
 
 		children := childrenAboveMinPct(pt, prefix, minPct)
 		isLeaf := len(children) == 0
 
 		// Build line.
-		line := fmt.Sprintf("%s[%.1f%%] %s", pad, pct, name)
+		line := fmt.Sprintf("[%.*f%%] %s", pctDigits, pct, pt.displayNode(name))
 
 		// Append sibling annotation (carried from previous iteration).
 		line += siblingAnnotation
@@ -172,14 +188,14 @@ func ftraceHottestPath(w io.Writer, pt *pathTree, rootKey string, minPct float64
 			selfCt := pt.selfSamples[prefix]
 			selfPct := pctOf(selfCt, pt.totalSamples)
 			if selfCt > 0 && selfPct >= minPct {
-				line += fmt.Sprintf("  ← self=%.1f%%", selfPct)
+				line += fmt.Sprintf("  ← self=%.*f%%", pctDigits, selfPct)
 			}
-			fmt.Fprintln(w, line)
-			fmt.Fprintf(w, "Hottest leaf: %s (self=%.1f%%)\n", name, selfPct)
+			lines = append(lines, line)
+			leaf = fmt.Sprintf("Hottest leaf: %s (self=%.*f%%)", name, pctDigits, selfPct)
 			break
 		}
 
-		fmt.Fprintln(w, line)
+		lines = append(lines, line)
 
 		// Pick hottest child, compute sibling annotation for next iteration.
 		hottest := children[0]
@@ -192,11 +208,22 @@ func ftraceHottestPath(w io.Writer, pt *pathTree, rootKey string, minPct float64
 			if n == 1 {
 				word = "sibling"
 			}
-			siblingAnnotation = fmt.Sprintf("  (+%d %s, next: %.1f%% %s)", n, word, nextPct, next.name)
+			siblingAnnotation = fmt.Sprintf("  (+%d %s, next: %.*f%% %s)", n, word, pctDigits, nextPct, next.name)
 		}
 
 		prefix = hottest.key
-		indent++
+	}
+
+	for i, indent := 0, 0; i < len(lines); i, indent = i+1, indent+1 {
+		if !fullStacks && len(lines) > elideAbove && i == elideHead {
+			fprintElided(w, strings.Repeat("  ", indent), len(lines)-elideHead-elideTail)
+			i = len(lines) - elideTail
+			indent++
+		}
+		fmt.Fprintf(w, "%s%s\n", strings.Repeat("  ", indent), lines[i])
+	}
+	if leaf != "" {
+		fmt.Fprintln(w, leaf)
 	}
 }
 
@@ -206,6 +233,6 @@ func computeTraceString(sf *stackFile, method string, minPct float64, fqn bool)
 		return ""
 	}
 	var buf strings.Builder
-	writeTrace(&buf, sf, method, minPct, fqn)
+	writeTrace(&buf, sf, substringMatcher(method), minPct, fqn, false)
 	return strings.TrimRight(buf.String(), "\n")
 }
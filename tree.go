@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"regexp"
@@ -10,16 +11,47 @@ import (
 
 func newTreeCmd() *cobra.Command {
 	var shared sharedFlags
-	var method string
+	var mf methodFlags
 	var depth int
 	var minPct float64
 	var hide string
+	var byThread bool
+	var highlight bool
+	var maxNodes int
+	var relative bool
+	var inlined bool
+	var format string
 	cmd := &cobra.Command{
 		Use:   "tree <file>",
 		Short: "Call tree descending from a method (optional -m; shows all if omitted)",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			pctx, err := preprocessProfile(shared.toOpts(args[0], "tree"))
+			if err := mf.validate(); err != nil {
+				return err
+			}
+			if maxNodes < 0 {
+				return fmt.Errorf("--max-nodes must be non-negative (got %d)", maxNodes)
+			}
+			if highlight && mf.method == "" {
+				return fmt.Errorf("--highlight requires -m/--method")
+			}
+			switch format {
+			case "text":
+				if shared.repoURL != "" {
+					return fmt.Errorf("--repo-url applies to --format json only")
+				}
+			case "json":
+				if highlight || shared.explain {
+					return fmt.Errorf("--highlight and --explain apply to --format text only")
+				}
+			default:
+				return fmt.Errorf("invalid --format %q (want text or json)", format)
+			}
+			opts := shared.toOpts(args[0], "tree")
+			if inlined {
+				opts.frameDetails = "--inlined"
+			}
+			pctx, err := preprocessProfile(opts)
 			if err != nil {
 				return err
 			}
@@ -31,23 +63,101 @@ func newTreeCmd() *cobra.Command {
 				}
 				sf = sf.hideFrames(re)
 			}
-			cmdTree(sf, method, depth, minPct)
+			m, err := mf.resolve(sf, args[0])
+			if err != nil {
+				return err
+			}
+			if relative {
+				if rel := sf.relativeTo(m); rel != sf {
+					sf = rel
+					if format != "json" {
+						fmt.Printf("# percentages of the %d samples matching '%s' (--relative)\n", sf.totalSamples, m.pattern)
+					}
+				}
+			}
+			if format == "json" {
+				return cmdTreeJSON(sf, m, depth, minPct, maxNodes, byThread, pctx.links)
+			}
+			if byThread {
+				cmdTreeByThread(sf, m, depth, minPct, highlight, maxNodes)
+				return nil
+			}
+			cmdTree(sf, m, depth, minPct, highlight, maxNodes)
 			return nil
 		},
 	}
 	shared.register(cmd)
-	cmd.Flags().StringVarP(&method, "method", "m", "", "Substring match on method name")
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	shared.registerGroupBy(cmd)
+	shared.registerThreadNormalize(cmd)
+	shared.registerRepoURL(cmd)
+	mf.register(cmd, "Substring match on method name")
 	cmd.Flags().IntVar(&depth, "depth", 4, "Max depth")
 	cmd.Flags().Float64Var(&minPct, "min-pct", 1.0, "Hide nodes below this %")
 	cmd.Flags().StringVar(&hide, "hide", "", "Remove matching frames before analysis (regex)")
+	cmd.Flags().BoolVar(&byThread, "by-thread", false, "Split the tree under one root per thread group")
+	cmd.Flags().IntVar(&maxNodes, "max-nodes", 0, "Print at most N nodes, expanding the heaviest first and summarizing the rest (default: unlimited)")
+	cmd.Flags().BoolVar(&relative, "relative", false, "Show percentages of the samples matching -m instead of all samples (--min-pct too)")
+	cmd.Flags().BoolVar(&inlined, "inlined", false, "Annotate nodes with the share of samples where the JIT inlined the frame (JFR only)")
+	cmd.Flags().StringVar(&format, "format", "text", "Output format: text, or json (nested {name, value, children} as read by d3-flamegraph and speedscope)")
+	cmd.Flags().BoolVar(&highlight, "highlight", false, "Mark frames matched by -m with \""+highlightMarker+"\"")
 	return cmd
 }
 
-func cmdTree(sf *stackFile, method string, maxDepth int, minPct float64) {
+func cmdTree(sf *stackFile, m methodMatcher, maxDepth int, minPct float64, highlight bool, maxNodes int) {
 	if sf.totalSamples == 0 {
 		fmt.Fprintln(os.Stdout, "no samples (empty profile or all filtered out)")
 		return
 	}
-	pt := buildTreePT(sf, method)
-	pt.fprintTree(os.Stdout, sf, treeDisplayMethod(method), maxDepth, minPct, true)
+	pt := buildTreePT(sf, m)
+	if highlight {
+		pt.markMatches(sf, m, shortName)
+	}
+	pt.maxNodes = maxNodes
+	pt.totalsHeader = m.pattern != "" && !sf.relative
+	pt.fprintTree(os.Stdout, sf, treeDisplayMethod(m.pattern), maxDepth, minPct, true)
+}
+
+// cmdTreeByThread prints the tree split per thread group. The group root
+// does not count towards maxDepth.
+func cmdTreeByThread(sf *stackFile, m methodMatcher, maxDepth int, minPct float64, highlight bool, maxNodes int) {
+	if sf.totalSamples == 0 {
+		fmt.Fprintln(os.Stdout, "no samples (empty profile or all filtered out)")
+		return
+	}
+	pt := buildTreePTByThread(sf, m)
+	if highlight {
+		pt.markMatches(sf, m, shortName)
+	}
+	pt.maxNodes = maxNodes
+	pt.totalsHeader = m.pattern != "" && !sf.relative
+	pt.fprintTree(os.Stdout, sf, treeDisplayMethod(m.pattern), maxDepth+1, minPct, true)
+}
+
+// cmdTreeJSON writes the tree as one flamegraph JSON object. The root is
+// "all" and its value counts the samples in the tree. With no match, the
+// usual message goes to stderr and nothing is written. With links, nodes
+// carry the URL of their source.
+func cmdTreeJSON(sf *stackFile, m methodMatcher, maxDepth int, minPct float64, maxNodes int, byThread bool, links *repoLinker) error {
+	var pt *pathTree
+	if byThread {
+		pt = buildTreePTByThread(sf, m)
+		maxDepth++
+	} else {
+		pt = buildTreePT(sf, m)
+	}
+	if sf.totalSamples > 0 && len(pt.samples) == 0 {
+		noMatchMessage(os.Stderr, sf, treeDisplayMethod(m.pattern))
+		return nil
+	}
+	pt.maxNodes = maxNodes
+	root := pt.flameTree("all", maxDepth, minPct)
+	if links != nil {
+		root.link(links.urlsByName(sf, false))
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(root); err != nil {
+		return ioErrorf("%v", err)
+	}
+	return nil
 }
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newTreemapCmd() *cobra.Command {
+	var shared sharedFlags
+	var htmlOut string
+	var minPct float64
+	opts := treemapOptions{}
+	cmd := &cobra.Command{
+		Use:   "treemap <file>",
+		Short: "Write an HTML treemap of samples by package, class and method",
+		Long: `Treemap writes a self-contained HTML page (no scripts or external assets)
+showing where the samples live: one box per package, containing one box per
+class, containing one box per method. A method's area is its self samples,
+so the boxes add up to the whole profile and a package's area is the time
+spent in its own code. Hover a box for its name and share.
+
+--title, --subtitle, --count-name, --width and --palette make the page
+presentation-ready; --count-name names the unit of the values (for example
+bytes for an allocation profile read from collapsed text).
+
+Methods below --min-pct are merged into one "(other)" box per class.
+Frames without a package go under "(default)", native frames under
+"(native)". With --repo-url, method boxes link to their source.`,
+		Example: strings.Join([]string{
+			"  ap-query treemap profile.jfr --html treemap.html",
+			"  ap-query treemap profile.jfr --html wall.html --event wall --no-idle",
+			"  ap-query treemap alloc.jfr --html alloc.html --palette mem --title \"Allocations\" --subtitle \"build 1234\"",
+			"  ap-query treemap profile.jfr --html treemap.html --source-root . --repo-url 'https://github.com/org/app/blob/{sha}/{path}#L{line}'",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if htmlOut == "" {
+				return fmt.Errorf("--html OUTPUT required")
+			}
+			if minPct < 0 {
+				return fmt.Errorf("--min-pct must not be negative (got %g)", minPct)
+			}
+			if opts.width < 100 {
+				return fmt.Errorf("--width must be at least 100 (got %d)", opts.width)
+			}
+			if !slices.Contains(treemapPalettes, opts.palette) {
+				return fmt.Errorf("unknown --palette %q (valid: %s)", opts.palette, strings.Join(treemapPalettes, ", "))
+			}
+			pctx, err := preprocessProfile(shared.toOpts(args[0], "treemap"))
+			if err != nil {
+				return err
+			}
+			if pctx.sf.totalSamples == 0 {
+				fmt.Fprintln(os.Stdout, "no samples (empty profile or all filtered out)")
+				return nil
+			}
+			root := buildTreemap(pctx.sf, minPct)
+			if pctx.links != nil {
+				opts.links = pctx.links.urlsByName(pctx.sf, true)
+			}
+			if opts.title == "" {
+				opts.title = filepath.Base(args[0])
+				if pctx.hasMetadata {
+					opts.title += " (" + pctx.eventType + ")"
+				}
+			}
+			f, err := os.Create(htmlOut)
+			if err != nil {
+				return ioErrorf("writing %s: %v", htmlOut, err)
+			}
+			writeTreemapHTML(f, root, opts)
+			if err := f.Close(); err != nil {
+				return ioErrorf("writing %s: %v", htmlOut, err)
+			}
+			fmt.Fprintf(os.Stderr, "Wrote treemap of %d %s (%d packages) to %s\n", root.value, opts.countName, len(root.children), htmlOut)
+			return nil
+		},
+	}
+	shared.register(cmd)
+	shared.registerExplain(cmd)
+	registerSample(cmd)
+	shared.registerSourceRoot(cmd)
+	shared.registerRepoURL(cmd)
+	cmd.Flags().StringVar(&htmlOut, "html", "", "Output HTML file")
+	cmd.Flags().Float64Var(&minPct, "min-pct", 0.1, "Merge methods below this % of samples into one box per class")
+	cmd.Flags().StringVar(&opts.title, "title", "", "Page title (default: file name and event)")
+	cmd.Flags().StringVar(&opts.subtitle, "subtitle", "", "Line of text under the title")
+	cmd.Flags().StringVar(&opts.countName, "count-name", "samples", "Unit of the values, e.g. samples, bytes, ns")
+	cmd.Flags().IntVar(&opts.width, "width", 1200, "Treemap width in pixels; the height is two thirds of it")
+	cmd.Flags().StringVar(&opts.palette, "palette", "package", "Colors: package, java (green Java, yellow C++, red native) or mem")
+	return cmd
+}
+
+// treemapNode is a package, class or method; value is self samples summed
+// over the subtree. Children are sorted by value, largest first.
+type treemapNode struct {
+	name     string
+	value    int
+	children []*treemapNode
+}
+
+func (n *treemapNode) child(name string) *treemapNode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	c := &treemapNode{name: name}
+	n.children = append(n.children, c)
+	return c
+}
+
+// treemapPath splits a leaf frame into package, class and method; native
+// frames (as classified by files) get no class level.
+func treemapPath(frame string) []string {
+	if sourceFileOf(frame, false) == nativeFile {
+		return []string{nativeFile, shortName(frame)}
+	}
+	parts := strings.Split(strings.ReplaceAll(frame, "/", "."), ".")
+	n := len(parts)
+	if n == 2 {
+		return []string{"(default)", parts[0], parts[1]}
+	}
+	return []string{strings.Join(parts[:n-2], "."), parts[n-2], parts[n-1]}
+}
+
+// buildTreemap groups self samples by package, class and method. Methods
+// under minPct of the total are merged into "(other)" per class.
+func buildTreemap(sf *stackFile, minPct float64) *treemapNode {
+	root := &treemapNode{name: "all"}
+	for i := range sf.stacks {
+		st := &sf.stacks[i]
+		if len(st.frames) == 0 {
+			continue
+		}
+		n := root
+		for _, name := range treemapPath(st.frames[len(st.frames)-1]) {
+			n = n.child(name)
+			n.value += st.count
+		}
+		root.value += st.count
+	}
+	minValue := minPct / 100 * float64(root.value)
+	var merge func(n *treemapNode)
+	merge = func(n *treemapNode) {
+		var kept []*treemapNode
+		other := &treemapNode{name: "(other)"}
+		for _, c := range n.children {
+			if len(c.children) > 0 {
+				merge(c)
+				kept = append(kept, c)
+			} else if float64(c.value) < minValue && len(n.children) > 1 {
+				other.value += c.value
+			} else {
+				kept = append(kept, c)
+			}
+		}
+		if other.value > 0 {
+			kept = append(kept, other)
+		}
+		sort.SliceStable(kept, func(i, j int) bool {
+			if kept[i].value != kept[j].value {
+				return kept[i].value > kept[j].value
+			}
+			return kept[i].name < kept[j].name
+		})
+		n.children = kept
+	}
+	merge(root)
+	return root
+}
+
+type treemapRect struct {
+	x, y, w, h float64
+}
+
+// squarify lays out values (sorted descending, all positive) in r with the
+// squarified treemap algorithm: boxes are added to a row along the shorter
+// side while that keeps their aspect ratios closer to square.
+func squarify(values []int, r treemapRect) []treemapRect {
+	out := make([]treemapRect, 0, len(values))
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	if total == 0 || r.w <= 0 || r.h <= 0 {
+		for range values {
+			out = append(out, treemapRect{x: r.x, y: r.y})
+		}
+		return out
+	}
+	scale := r.w * r.h / float64(total)
+	areas := make([]float64, len(values))
+	for i, v := range values {
+		areas[i] = float64(v) * scale
+	}
+	worst := func(row []float64, side float64) float64 {
+		sum, hi, lo := 0.0, row[0], row[0]
+		for _, a := range row {
+			sum += a
+			hi, lo = max(hi, a), min(lo, a)
+		}
+		return max(side*side*hi/(sum*sum), sum*sum/(side*side*lo))
+	}
+	for i := 0; i < len(areas); {
+		side := min(r.w, r.h)
+		j := i + 1
+		for j < len(areas) && worst(areas[i:j+1], side) <= worst(areas[i:j], side) {
+			j++
+		}
+		sum := 0.0
+		for _, a := range areas[i:j] {
+			sum += a
+		}
+		thick := sum / side
+		off := 0.0
+		for _, a := range areas[i:j] {
+			l := a / thick
+			if r.w >= r.h { // column on the left
+				out = append(out, treemapRect{r.x, r.y + off, thick, l})
+			} else { // row on top
+				out = append(out, treemapRect{r.x + off, r.y, l, thick})
+			}
+			off += l
+		}
+		if r.w >= r.h {
+			r.x, r.w = r.x+thick, r.w-thick
+		} else {
+			r.y, r.h = r.y+thick, r.h-thick
+		}
+		i = j
+	}
+	return out
+}
+
+const (
+	treemapHeader = 14 // label band of package and class boxes
+	treemapPad    = 2
+)
+
+// treemapOptions are the presentation settings of the HTML page.
+type treemapOptions struct {
+	title     string
+	subtitle  string            // "" = none
+	countName string            // unit of the values, e.g. "samples" or "bytes"
+	width     int               // pixels; the height is two thirds of it
+	palette   string            // one of treemapPalettes
+	links     map[string]string // method label → source URL (--repo-url); nil = none
+}
+
+// treemapPalettes are the --palette values: "package" gives each package its
+// own hue; "java" colors like flame graphs (green Java, yellow C++, red
+// other native code); "mem" uses blue-greens, as for allocation profiles.
+var treemapPalettes = []string{"package", "java", "mem"}
+
+// treemapHue returns the hue and saturation of a box under the package
+// pkg; leaf is the method name for method boxes.
+func treemapHue(palette, pkg, leaf string) (hue, sat int) {
+	h := fnv.New32a()
+	h.Write([]byte(pkg))
+	sum := int(h.Sum32())
+	switch palette {
+	case "java":
+		switch {
+		case pkg != nativeFile:
+			return 100 + sum%40, 55
+		case strings.Contains(leaf, "::"):
+			return 45 + sum%10, 70
+		}
+		return sum % 15, 65
+	case "mem":
+		return 170 + sum%60, 45
+	}
+	return sum % 360, 55
+}
+
+func writeTreemapHTML(w io.Writer, root *treemapNode, opts treemapOptions) {
+	height := opts.width * 2 / 3
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>%s</title>
+<style>
+body { font: 12px sans-serif; margin: 16px; }
+svg text { pointer-events: none; }
+rect { stroke: #fff; }
+</style></head><body>
+<h3>%s: %d %s</h3>
+`, html.EscapeString(opts.title), html.EscapeString(opts.title), root.value, html.EscapeString(opts.countName))
+	if opts.subtitle != "" {
+		fmt.Fprintf(w, "<p>%s</p>\n", html.EscapeString(opts.subtitle))
+	}
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-size="11">
+`, opts.width, height)
+	r := treemapRect{0, 0, float64(opts.width), float64(height)}
+	writeTreemapLevel(w, root, r, root.value, nil, opts)
+	fmt.Fprintln(w, "</svg>\n</body></html>")
+}
+
+// writeTreemapLevel draws the children of n inside r. path holds the names
+// of the enclosing boxes.
+func writeTreemapLevel(w io.Writer, n *treemapNode, r treemapRect, total int, path []string, opts treemapOptions) {
+	values := make([]int, len(n.children))
+	for i, c := range n.children {
+		values[i] = c.value
+	}
+	for i, cr := range squarify(values, r) {
+		c := n.children[i]
+		if cr.w < 1 || cr.h < 1 {
+			continue
+		}
+		cpath := append(path[:len(path):len(path)], c.name)
+		light := 90 - 15*len(path)
+		leaf := ""
+		if len(c.children) == 0 {
+			light = 65
+			leaf = c.name
+		}
+		hue, sat := treemapHue(opts.palette, cpath[0], leaf)
+		href := ""
+		if leaf != "" {
+			href = opts.links[treemapLabel(cpath)]
+		}
+		if href != "" {
+			fmt.Fprintf(w, "<a href=\"%s\" target=\"_blank\">\n", html.EscapeString(href))
+		}
+		fmt.Fprintf(w, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="hsl(%d,%d%%,%d%%)"><title>%s
+%d %s (%.*f%%)</title></rect>
+`, cr.x, cr.y, cr.w, cr.h, hue, sat, light, html.EscapeString(treemapLabel(cpath)), c.value, html.EscapeString(opts.countName), pctDigits, pctOf(c.value, total))
+		if label := treemapFit(c.name, cr.w); label != "" && cr.h >= treemapHeader {
+			fmt.Fprintf(w, `<text x="%.1f" y="%.1f">%s</text>
+`, cr.x+3, cr.y+11, html.EscapeString(label))
+		}
+		if href != "" {
+			fmt.Fprintln(w, "</a>")
+		}
+		if len(c.children) > 0 {
+			inner := treemapRect{cr.x + treemapPad, cr.y + treemapHeader, cr.w - 2*treemapPad, cr.h - treemapHeader - treemapPad}
+			writeTreemapLevel(w, c, inner, total, cpath, opts)
+		}
+	}
+}
+
+// treemapLabel joins a node path into a dotted name, skipping the
+// placeholder packages.
+func treemapLabel(path []string) string {
+	if len(path) > 0 && (path[0] == "(default)" || path[0] == nativeFile) && len(path) > 1 {
+		path = path[1:]
+	}
+	return strings.Join(path, ".")
+}
+
+// treemapFit returns name cut to fit width pixels (about 6.5px per
+// character), or "" when not even a few characters fit.
+func treemapFit(name string, width float64) string {
+	n := int((width - 6) / 6.5)
+	if n < 3 {
+		return ""
+	}
+	if r := []rune(name); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return name
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newTrendCmd() *cobra.Command {
+	var event string
+	var thread string
+	var minPct float64
+	var minGrowth float64
+	var growingOnly bool
+	var top int
+	var fqn bool
+	cmd := &cobra.Command{
+		Use:   "trend <file1> <file2> [<file>...]",
+		Short: "Per-method self% across an ordered series of profiles; flags steady growth",
+		Long: `Trend reads profiles in the given (chronological) order and prints each
+method's self% as a series and sparkline. A method is flagged GROWING when its
+self% never decreases from one profile to the next and rises by at least
+--min-growth overall, catching slow regressions too small for a single diff.`,
+		Example: strings.Join([]string{
+			"  ap-query trend nightly-01.jfr nightly-02.jfr nightly-03.jfr nightly-04.jfr",
+			"  ap-query trend runs/*.jfr --growing --min-growth 1",
+		}, "\n"),
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sfs, err := loadProfileSeries(args, event, thread)
+			if err != nil {
+				return err
+			}
+			cmdTrend(args, sfs, minPct, minGrowth, growingOnly, top, fqn)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&event, "event", "e", "", "Event type: cpu, wall, alloc, lock, live, or hardware counter name (default: cpu)")
+	cmd.Flags().StringVarP(&thread, "thread", "t", "", "Filter to threads matching substring")
+	cmd.Flags().Float64Var(&minPct, "min-pct", 1.0, "Hide methods whose peak self% is below this")
+	cmd.Flags().Float64Var(&minGrowth, "min-growth", 0.5, "Minimum overall self% increase to flag a method as GROWING")
+	cmd.Flags().BoolVar(&growingOnly, "growing", false, "Show only methods flagged as GROWING")
+	cmd.Flags().IntVar(&top, "top", 0, "Limit output rows (default: unlimited)")
+	cmd.Flags().BoolVar(&fqn, "fqn", false, "Show fully-qualified names")
+	registerSample(cmd)
+	return cmd
+}
+
+type trendRow struct {
+	name    string
+	series  []float64 // self% per profile, 0 where the method is absent
+	change  float64   // last - first
+	peak    float64
+	growing bool
+}
+
+// computeTrend builds one row per method whose peak self% reaches minPct (or
+// that is growing). Growing rows come first, then by overall change.
+func computeTrend(sfs []*stackFile, minPct, minGrowth float64, fqn bool) []trendRow {
+	pcts := make([]map[string]float64, len(sfs))
+	allMethods := make(map[string]bool)
+	for i, sf := range sfs {
+		pcts[i] = selfPcts(sf, fqn)
+		for m := range pcts[i] {
+			allMethods[m] = true
+		}
+	}
+
+	var rows []trendRow
+	for m := range allMethods {
+		r := trendRow{name: m, series: make([]float64, len(sfs))}
+		monotonic := true
+		for i, p := range pcts {
+			r.series[i] = p[m]
+			if r.series[i] > r.peak {
+				r.peak = r.series[i]
+			}
+			if i > 0 && r.series[i] < r.series[i-1] {
+				monotonic = false
+			}
+		}
+		r.change = r.series[len(r.series)-1] - r.series[0]
+		r.growing = monotonic && r.change >= minGrowth
+		if r.peak >= minPct || r.growing {
+			rows = append(rows, r)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].growing != rows[j].growing {
+			return rows[i].growing
+		}
+		if rows[i].change != rows[j].change {
+			return rows[i].change > rows[j].change
+		}
+		return rows[i].name < rows[j].name
+	})
+	return rows
+}
+
+func cmdTrend(paths []string, sfs []*stackFile, minPct, minGrowth float64, growingOnly bool, top int, fqn bool) {
+	rows := computeTrend(sfs, minPct, minGrowth, fqn)
+	if growingOnly {
+		var growing []trendRow
+		for _, r := range rows {
+			if r.growing {
+				growing = append(growing, r)
+			}
+		}
+		rows = growing
+	}
+	if len(rows) == 0 {
+		if growingOnly {
+			fmt.Println("no growing methods")
+		} else {
+			fmt.Println("no methods above --min-pct")
+		}
+		return
+	}
+	shown := rows[:truncate(len(rows), top)]
+
+	for i, p := range paths {
+		fmt.Printf("#%-4d %s\n", i+1, p)
+	}
+	fmt.Println()
+
+	trendWidth := max(len(paths), len("TREND"))
+	fmt.Printf("%-50s %7s %7s %7s  %-*s  %-7s  %s\n", "METHOD", "FIRST", "LAST", "CHANGE", trendWidth, "TREND", "FLAG", "SERIES")
+	for _, r := range shown {
+		flag := ""
+		if r.growing {
+			flag = "GROWING"
+		}
+		series := make([]string, len(r.series))
+		for i, v := range r.series {
+			series[i] = fmt.Sprintf("%.*f", pctDigits, v)
+		}
+		fmt.Printf("%-50s %6.*f%% %6.*f%% %+7.*f  %-*s  %-7s  %s\n",
+			r.name, pctDigits, r.series[0], pctDigits, r.series[len(r.series)-1], pctDigits, r.change,
+			trendWidth, sparkline(r.series), flag, strings.Join(series, " "))
+	}
+	if len(shown) < len(rows) {
+		fmt.Printf("(%d of %d methods shown)\n", len(shown), len(rows))
+	}
+}
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders values as block characters scaled from 0 to the
+// largest value, so a flat series stays flat instead of amplifying noise.
+func sparkline(values []float64) string {
+	peak := 0.0
+	for _, v := range values {
+		if v > peak {
+			peak = v
+		}
+	}
+	return sparklineScaled(values, peak)
+}
+
+// sparklineScaled renders values scaled from 0 to peak, so several series
+// can share one scale.
+func sparklineScaled(values []float64, peak float64) string {
+	var b strings.Builder
+	for _, v := range values {
+		level := 0
+		if peak > 0 {
+			level = int(v/peak*float64(len(sparkBlocks)-1) + 0.5)
+		}
+		b.WriteRune(sparkBlocks[level])
+	}
+	return b.String()
+}
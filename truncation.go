@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stackTruncation counts samples whose stacks were cut off at the
+// profiler's stack depth limit. Truncated stacks lose their root frames, so
+// callers and tree attribute them to whatever frame happens to be deepest.
+type stackTruncation struct {
+	samples int
+	total   int
+	// depth is the common stack length when truncation is inferred from
+	// the stacks themselves; 0 when the JFR recording flags it.
+	depth int
+}
+
+const (
+	// truncationMinDepth and truncationMinStacks guard the inference for
+	// formats without a truncation flag: a handful of distinct stacks that
+	// all stop at the same, deepest length look like a depth limit, a
+	// single deep recursion does not.
+	truncationMinDepth  = 16
+	truncationMinStacks = 3
+	// truncationWarnPct is the share of truncated samples that triggers
+	// a warning on every command.
+	truncationWarnPct = 1.0
+)
+
+// detectTruncation reports truncated samples of eventType. JFR recordings
+// carry a per-stack flag (counted over the recording or --from/--to window);
+// other formats fall back to inferTruncation on sf.
+func detectTruncation(parsed *parsedProfile, eventType string, eventCounts map[string]int, sf *stackFile) stackTruncation {
+	if parsed != nil && parsed.truncated != nil {
+		return stackTruncation{samples: parsed.truncated[eventType], total: eventCounts[eventType]}
+	}
+	return inferTruncation(sf)
+}
+
+// inferTruncation treats the samples whose stacks have the maximum depth as
+// truncated when enough distinct stacks share that depth.
+func inferTruncation(sf *stackFile) stackTruncation {
+	depth := 0
+	for i := range sf.stacks {
+		depth = max(depth, len(sf.stacks[i].frames))
+	}
+	if depth < truncationMinDepth {
+		return stackTruncation{}
+	}
+	distinct := make(map[string]bool)
+	samples := 0
+	for i := range sf.stacks {
+		st := &sf.stacks[i]
+		if len(st.frames) == depth {
+			distinct[strings.Join(st.frames, ";")] = true
+			samples += st.count
+		}
+	}
+	if len(distinct) < truncationMinStacks {
+		return stackTruncation{}
+	}
+	return stackTruncation{samples: samples, total: sf.totalSamples, depth: depth}
+}
+
+func (t stackTruncation) pct() float64 {
+	return pctOf(t.samples, t.total)
+}
+
+// describe summarizes the truncation, e.g.
+// "12.5% of cpu samples (25/200) have truncated stacks".
+func (t stackTruncation) describe(eventType string) string {
+	what := "samples"
+	if eventType != "" {
+		what = eventType + " samples"
+	}
+	if t.depth > 0 {
+		return fmt.Sprintf("%.*f%% of %s (%d/%d) stop at the same depth of %d frames, likely truncated",
+			pctDigits, t.pct(), what, t.samples, t.total, t.depth)
+	}
+	return fmt.Sprintf("%.*f%% of %s (%d/%d) have truncated stacks", pctDigits, t.pct(), what, t.samples, t.total)
+}
+
+// truncationAdvice explains the impact and, when the limit is known from the
+// recording settings, how to raise it.
+func truncationAdvice(stackDepth string) string {
+	advice := "callers and tree miss their root frames"
+	if stackDepth != "" {
+		advice += fmt.Sprintf(" (stack depth limit %s; raise with asprof -j)", stackDepth)
+	}
+	return advice
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Thresholds for the info verdict. They flag what an analyst would check
+// first, not hard limits.
+const (
+	verdictMinSamples  = 1000 // fewer samples make small shares noise
+	verdictLeafPct     = 10.0 // top self% worth optimizing directly
+	verdictFlatPct     = 5.0  // top self% below this means a flat profile
+	verdictEntryPct    = 20.0 // total% of a method whose cost is in its callees
+	verdictIdlePct     = 50.0
+	verdictRuntimePct  = 10.0 // share of GC or JIT compiler threads
+	verdictWaitingMult = 5    // wall samples per cpu sample when threads mostly wait
+)
+
+// infoVerdict returns heuristic observations with a suggested next step,
+// most important first. hot is ranked by self samples.
+func infoVerdict(sf *stackFile, opts infoOpts, hot []hotEntry) []string {
+	if sf.totalSamples == 0 {
+		return nil
+	}
+	var out []string
+	total := sf.totalSamples
+	// Method and thread advice reads samples as time spent computing, which
+	// wall, lock and allocation profiles are not.
+	onCPU := !opts.hasMetadata || opts.eventType == "cpu" || opts.eventType == "itimer"
+
+	if total < verdictMinSamples {
+		out = append(out, fmt.Sprintf("Only %d samples: record longer or sample more often before trusting shares below ~5%%.", total))
+	}
+
+	if opts.eventType == "wall" {
+		idle := 0
+		for i := range sf.stacks {
+			st := &sf.stacks[i]
+			if isIdleStack(st.frames) {
+				idle += st.count
+			}
+		}
+		if pct := pctOf(idle, total); pct >= verdictIdlePct {
+			out = append(out, fmt.Sprintf("%.0f%% of wall samples are idle (park, sleep, wait): re-run with --no-idle to see active work.", pct))
+		}
+	}
+	if opts.eventType == "cpu" && opts.eventCounts["wall"] >= verdictWaitingMult*total {
+		out = append(out, fmt.Sprintf("Threads mostly wait (%d wall vs %d cpu samples): latency is off-CPU, analyze --event wall --no-idle.",
+			opts.eventCounts["wall"], total))
+	}
+
+	if onCPU && len(hot) > 0 {
+		top := hot[0]
+		selfPct := pctOf(top.selfCount, total)
+		switch {
+		case selfPct >= verdictLeafPct && top.totalCount*10 <= top.selfCount*11:
+			out = append(out, fmt.Sprintf("Top method %s is a leaf (%.*f%% self): optimize it directly; lines -m %s shows the hot lines.",
+				top.name, pctDigits, selfPct, top.name))
+		case selfPct < verdictFlatPct:
+			out = append(out, fmt.Sprintf("Flat profile (top self %.*f%%): no single hotspot; look for the heaviest subtree with tree --max-nodes 30.", pctDigits, selfPct))
+		}
+		if e, ok := verdictEntryPoint(sf, hot); ok {
+			out = append(out, fmt.Sprintf("%s covers %.*f%% in total but %.*f%% self: the cost is in its callees, drill in with tree -m %s.",
+				e.name, pctDigits, pctOf(e.totalCount, total), pctDigits, pctOf(e.selfCount, total), e.name))
+		}
+	}
+
+	threads, _, hasThread := computeThreads(sf)
+	if onCPU && hasThread {
+		gc, jit := 0, 0
+		for _, t := range threads {
+			switch group := threadGroupName(t.name); {
+			case strings.Contains(group, "GC") || strings.HasPrefix(group, "G1 ") || strings.Contains(group, "ZGC"):
+				gc += t.samples
+			case strings.Contains(group, "CompilerThread"):
+				jit += t.samples
+			}
+		}
+		if pct := pctOf(gc, total); pct >= verdictRuntimePct {
+			out = append(out, fmt.Sprintf("GC threads take %.*f%% of samples: check allocation pressure with --event alloc and heap.", pctDigits, pct))
+		}
+		if pct := pctOf(jit, total); pct >= verdictRuntimePct {
+			out = append(out, fmt.Sprintf("JIT compiler threads take %.*f%% of samples: the recording includes warmup, skip it with --from.", pctDigits, pct))
+		}
+	}
+
+	if opts.hasMetadata && opts.eventType != "lock" && opts.eventCounts["lock"] > 0 {
+		out = append(out, "Lock events were recorded: hot --event lock shows the contended monitors.")
+	}
+	return out
+}
+
+// verdictEntryPoint picks the method with the most samples in its callees
+// among those that cover a large share without covering everything (thread
+// roots like Thread.run do). Methods on the same call chain tie; the deepest
+// one is the useful place to start drilling.
+func verdictEntryPoint(sf *stackFile, hot []hotEntry) (hotEntry, bool) {
+	callees := func(h hotEntry) int { return h.totalCount - h.selfCount }
+	var candidates []hotEntry
+	most := 0
+	for _, h := range hot {
+		totalPct := pctOf(h.totalCount, sf.totalSamples)
+		if totalPct < verdictEntryPct || totalPct >= 95 || h.selfCount*4 > h.totalCount {
+			continue
+		}
+		candidates = append(candidates, h)
+		most = max(most, callees(h))
+	}
+	if len(candidates) == 0 {
+		return hotEntry{}, false
+	}
+	tied := make(map[string]bool)
+	for _, h := range candidates {
+		if callees(h)*20 >= most*19 {
+			tied[h.name] = true
+		}
+	}
+	// Sample-weighted mean depth of each tied method.
+	depthSum := make(map[string]int)
+	weight := make(map[string]int)
+	for i := range sf.stacks {
+		st := &sf.stacks[i]
+		for j, fr := range st.frames {
+			if name := displayName(fr, false); tied[name] {
+				depthSum[name] += j * st.count
+				weight[name] += st.count
+			}
+		}
+	}
+	var best hotEntry
+	bestDepth := -1.0
+	for _, h := range candidates {
+		if !tied[h.name] || weight[h.name] == 0 {
+			continue
+		}
+		if d := float64(depthSum[h.name]) / float64(weight[h.name]); d > bestDepth {
+			best, bestDepth = h, d
+		}
+	}
+	return best, bestDepth >= 0
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"html"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// VisualVM and the NetBeans profiler save snapshots as .nps, a private
+// binary format, but export the call tree of a CPU snapshot as XML (nested
+// <Node> elements with <Name> and <Time>) or CSV (one quoted row per node,
+// the name indented one space per level). Both carry total time per node in
+// milliseconds; the top-level nodes are threads. They are read into stacks
+// whose count is the self time in ms, so percentages match VisualVM's.
+
+// vvNode is one call tree node of a VisualVM export.
+type vvNode struct {
+	name     string
+	ms       float64 // total time
+	children []*vvNode
+}
+
+var vvTagRe = regexp.MustCompile(`<(/?)([A-Za-z][\w.-]*)[^>]*>`)
+
+// parseVisualVMXML reads an XML call tree export. Tags are matched
+// leniently: some NetBeans versions close <Name> with a second <Name>.
+func parseVisualVMXML(r io.Reader) (*stackFile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, readError(err)
+	}
+	text := string(data)
+	var roots []*vvNode
+	var open []*vvNode
+	tags := vvTagRe.FindAllStringSubmatchIndex(text, -1)
+	for i, m := range tags {
+		closing, tag := m[3] > m[2], text[m[4]:m[5]]
+		end := len(text)
+		if i+1 < len(tags) {
+			end = tags[i+1][0]
+		}
+		content := vvText(text[m[1]:end])
+		switch {
+		case tag == "Node" && !closing:
+			n := &vvNode{}
+			if len(open) > 0 {
+				parent := open[len(open)-1]
+				parent.children = append(parent.children, n)
+			} else {
+				roots = append(roots, n)
+			}
+			open = append(open, n)
+		case tag == "Node" && closing:
+			if len(open) == 0 {
+				return nil, parseErrorf("VisualVM XML: unbalanced </Node>")
+			}
+			open = open[:len(open)-1]
+		case len(open) == 0 || closing:
+		case tag == "Name" && content != "" && open[len(open)-1].name == "":
+			open[len(open)-1].name = content
+		case tag == "Time":
+			ms, err := vvMillis(content)
+			if err != nil {
+				return nil, parseErrorf("VisualVM XML: node %q: invalid <Time> %q", open[len(open)-1].name, content)
+			}
+			open[len(open)-1].ms = ms
+		}
+	}
+	if len(roots) == 0 {
+		return nil, parseErrorf("not a VisualVM call tree export: no <Node> elements")
+	}
+	return vvStacks(roots), nil
+}
+
+// vvText returns the text of an element: entities decoded, CDATA unwrapped.
+func vvText(s string) string {
+	s = strings.TrimSpace(s)
+	if inner, ok := strings.CutPrefix(s, "<![CDATA["); ok {
+		return strings.TrimSpace(strings.TrimSuffix(inner, "]]>"))
+	}
+	return html.UnescapeString(s)
+}
+
+// parseVisualVMCSV reads a CSV call tree export: "Name","Time [%]","Time",
+// ... with the separator VisualVM was configured for (, ; or tab).
+func parseVisualVMCSV(r io.Reader) (*stackFile, error) {
+	br := bufio.NewReader(r)
+	first, _ := br.Peek(4096)
+	cr := csv.NewReader(br)
+	cr.Comma = vvSeparator(first)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	var roots []*vvNode
+	var path []*vvNode // open nodes by depth
+	for row := 1; ; row++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, parseErrorf("VisualVM CSV: %v", err)
+		}
+		if len(rec) < 3 || strings.TrimSpace(rec[0]) == "" {
+			continue
+		}
+		ms, err := vvMillis(rec[2])
+		if err != nil {
+			if row == 1 {
+				continue // header
+			}
+			return nil, parseErrorf("VisualVM CSV: row %d: invalid time %q", row, rec[2])
+		}
+		name := strings.TrimLeft(rec[0], " ")
+		depth := min(len(rec[0])-len(name), len(path))
+		n := &vvNode{name: strings.TrimSpace(name), ms: ms}
+		path = path[:depth]
+		if depth == 0 {
+			roots = append(roots, n)
+		} else {
+			parent := path[depth-1]
+			parent.children = append(parent.children, n)
+		}
+		path = append(path, n)
+	}
+	if len(roots) == 0 {
+		return nil, parseErrorf("not a VisualVM call tree export: no rows with a time column")
+	}
+	return vvStacks(roots), nil
+}
+
+// vvSeparator returns the field separator: the character after the first
+// quoted field.
+func vvSeparator(head []byte) rune {
+	if i := bytes.IndexByte(head[1:], '"'); i >= 0 && i+2 < len(head) {
+		switch c := rune(head[i+2]); c {
+		case ',', ';', '\t':
+			return c
+		}
+	}
+	return ','
+}
+
+// vvMillis parses a time cell; thousands separators are dropped.
+func vvMillis(s string) (float64, error) {
+	s = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "ms"))
+	s = strings.NewReplacer(",", "", " ", "", "\u00a0", "").Replace(s)
+	return strconv.ParseFloat(s, 64)
+}
+
+// vvFrame turns a node name into a frame: the method without its
+// signature, e.g. "com.example.App.run (String[])" → "com.example.App.run".
+func vvFrame(name string) string {
+	if i := strings.IndexByte(name, '('); i > 0 {
+		name = name[:i]
+	}
+	return strings.TrimSpace(name)
+}
+
+// vvStacks flattens thread roots into one stack per node with self time:
+// its time less its children's ("Self time" nodes are that difference and
+// are dropped).
+func vvStacks(roots []*vvNode) *stackFile {
+	sf := &stackFile{}
+	var walk func(n *vvNode, thread string, frames []string)
+	walk = func(n *vvNode, thread string, frames []string) {
+		self := n.ms
+		for _, c := range n.children {
+			if c.name != "Self time" {
+				self -= c.ms
+			}
+		}
+		if count := int(math.Round(self)); count > 0 && len(frames) > 0 {
+			sf.addStack(thread, frames, nil, count)
+		}
+		for _, c := range n.children {
+			if c.name != "Self time" {
+				walk(c, thread, append(frames, vvFrame(c.name)))
+			}
+		}
+	}
+	for _, r := range roots {
+		walk(r, r.name, nil)
+	}
+	return sf
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func newWatchCmd() *cobra.Command {
+	var opts watchOpts
+	cmd := &cobra.Command{
+		Use:   "watch <dir>",
+		Short: "Diff each new recording in a directory against its predecessor",
+		Long: `Watch polls a directory where a looping profiler drops recordings
+(asprof --loop 1m -f 'dir/profile-%t.jfr') and, whenever a new JFR or pprof
+file is complete, prints its diff against the previous recording: the same
+REGRESSION / IMPROVEMENT / NEW / GONE report as diff.
+
+A file counts as complete once its size and modification time stay the same
+for one polling interval. Recordings are ordered by modification time; the
+newest one present at start is the first baseline. --once diffs every
+consecutive pair already in the directory and exits.
+
+--log appends one line per comparison to a file (time, the two files, number
+of regressions and new methods, the largest regression), a rolling regression
+log that survives restarts.`,
+		Example: strings.Join([]string{
+			"  ap-query watch /var/profiles",
+			"  ap-query watch /var/profiles --event wall --min-delta 1 --log regressions.log",
+			"  ap-query watch /var/profiles --once",
+		}, "\n"),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.interval <= 0 {
+				return fmt.Errorf("--interval must be positive (got %s)", opts.interval)
+			}
+			info, err := os.Stat(args[0])
+			if err != nil {
+				return ioErrorf("%v", err)
+			}
+			if !info.IsDir() {
+				return fmt.Errorf("watch requires a directory (got file %s)", args[0])
+			}
+			return runWatch(args[0], opts)
+		},
+	}
+	cmd.Flags().StringVarP(&opts.event, "event", "e", "", "Event type: cpu, wall, alloc, lock, live, or hardware counter name (default: cpu)")
+	cmd.Flags().StringVarP(&opts.thread, "thread", "t", "", "Filter to threads matching substring")
+	cmd.Flags().Float64Var(&opts.minDelta, "min-delta", 0.5, "Hide entries below this % change")
+	cmd.Flags().IntVar(&opts.top, "top", 0, "Limit output rows (default: unlimited)")
+	cmd.Flags().BoolVar(&opts.fqn, "fqn", false, "Show fully-qualified names")
+	registerSample(cmd)
+	cmd.Flags().DurationVar(&opts.interval, "interval", 5*time.Second, "How often to look for new recordings")
+	cmd.Flags().StringVar(&opts.logPath, "log", "", "Append a one-line summary of every comparison to this file")
+	cmd.Flags().BoolVar(&opts.once, "once", false, "Diff the consecutive recordings already in the directory, then exit")
+	return cmd
+}
+
+type watchOpts struct {
+	event, thread string
+	minDelta      float64
+	top           int
+	fqn           bool
+	interval      time.Duration
+	logPath       string
+	once          bool
+}
+
+// watchedFile is a recording's size and modification time when last seen.
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// listRecordings returns the JFR and pprof files in dir, oldest first.
+func listRecordings(dir string) ([]string, map[string]watchedFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, ioErrorf("%v", err)
+	}
+	states := make(map[string]watchedFile)
+	var paths []string
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if e.IsDir() || detectFormat(path) == formatCollapsed {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue // removed since ReadDir
+		}
+		states[path] = watchedFile{info.Size(), info.ModTime()}
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		ti, tj := states[paths[i]].modTime, states[paths[j]].modTime
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return paths[i] < paths[j]
+	})
+	return paths, states, nil
+}
+
+func runWatch(dir string, opts watchOpts) error {
+	paths, states, err := listRecordings(dir)
+	if err != nil {
+		return err
+	}
+	if opts.once {
+		if len(paths) < 2 {
+			fmt.Printf("fewer than two recordings in %s; nothing to diff\n", dir)
+			return nil
+		}
+		// As in the polling loop, a broken recording is skipped and the
+		// next one is compared with the last good one.
+		prev := paths[0]
+		for _, p := range paths[1:] {
+			if err := watchDiff(prev, p, states[p].modTime, opts); err != nil {
+				fmt.Fprintf(os.Stderr, "warning: %s: %v\n", filepath.Base(p), err)
+				continue
+			}
+			prev = p
+		}
+		return nil
+	}
+
+	done := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		done[p] = true
+	}
+	var prev string
+	if len(paths) > 0 {
+		prev = paths[len(paths)-1]
+		fmt.Fprintf(os.Stderr, "watching %s; baseline %s\n", dir, filepath.Base(prev))
+	} else {
+		fmt.Fprintf(os.Stderr, "watching %s; waiting for the first recording\n", dir)
+	}
+	pending := make(map[string]watchedFile) // new files, as seen on the last poll
+	for {
+		time.Sleep(opts.interval)
+		paths, states, err := listRecordings(dir)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			if done[p] {
+				continue
+			}
+			last, seen := pending[p]
+			pending[p] = states[p]
+			if !seen || last != states[p] {
+				continue // still being written
+			}
+			delete(pending, p)
+			done[p] = true
+			if prev != "" {
+				if err := watchDiff(prev, p, states[p].modTime, opts); err != nil {
+					// A broken recording must not stop the watch; the next
+					// one is compared with the last good one.
+					fmt.Fprintf(os.Stderr, "warning: %s: %v\n", filepath.Base(p), err)
+					continue
+				}
+			}
+			prev = p
+		}
+	}
+}
+
+// watchDiff prints the diff of after against before and logs its summary.
+func watchDiff(before, after string, recorded time.Time, opts watchOpts) error {
+	sfs, err := loadProfileSeries([]string{before, after}, opts.event, opts.thread)
+	if err != nil {
+		return err
+	}
+	c := computeDiff(sfs[0], sfs[1], opts.minDelta, opts.top, opts.fqn, nil)
+	fmt.Printf("=== %s -> %s (%s) ===\n", filepath.Base(before), filepath.Base(after), recorded.Format(time.DateTime))
+	printDiffChanges(c)
+	fmt.Println()
+	if opts.logPath == "" {
+		return nil
+	}
+	f, err := os.OpenFile(opts.logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return ioErrorf("%v", err)
+	}
+	fmt.Fprintf(f, "%s %s -> %s: %s\n", recorded.Format(time.RFC3339), filepath.Base(before), filepath.Base(after), summarizeDiff(c))
+	if err := f.Close(); err != nil {
+		return ioErrorf("%v", err)
+	}
+	return nil
+}
+
+// summarizeDiff is the regression log entry of one comparison.
+func summarizeDiff(c diffChanges) string {
+	if len(c.regressions) == 0 && len(c.newMethods) == 0 {
+		return "no regressions"
+	}
+	s := fmt.Sprintf("%d regressions, %d new", len(c.regressions), len(c.newMethods))
+	if len(c.regressions) > 0 {
+		worst := c.regressions[0]
+		s += fmt.Sprintf("; worst %s +%.*f%%", worst.name, pctDigits, worst.delta)
+	}
+	return s
+}
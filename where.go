@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newWhereCmd() *cobra.Command {
+	var mf methodFlags
+	var event string
+	var thread string
+	var fqn bool
+	cmd := &cobra.Command{
+		Use:   "where -m METHOD <file> [<file>...]",
+		Short: "Show which profiles contain a method and its self/total% in each",
+		Long: `Where looks a method up in every given profile and prints its self% and
+total% per file, in the given order, then the first file it appears in. Use
+it to find the environment or version in which a hot spot first showed up.`,
+		Example: strings.Join([]string{
+			"  ap-query where -m HashMap.resize nightly-*.jfr",
+			"  ap-query where -m com.example.Codec.encode --exact --event alloc staging.jfr prod.jfr",
+		}, "\n"),
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if mf.method == "" {
+				return fmt.Errorf("where requires -m/--method")
+			}
+			sfs, err := loadProfileSeries(args, event, thread)
+			if err != nil {
+				return err
+			}
+			cmdWhere(args, sfs, mf.matcher(), fqn)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&mf.method, "method", "m", "", "Substring match on method name")
+	mf.registerModes(cmd)
+	cmd.Flags().StringVarP(&event, "event", "e", "", "Event type: cpu, wall, alloc, lock, live, or hardware counter name (default: cpu)")
+	cmd.Flags().StringVarP(&thread, "thread", "t", "", "Filter to threads matching substring")
+	cmd.Flags().BoolVar(&fqn, "fqn", false, "Show fully-qualified names")
+	registerSample(cmd)
+	return cmd
+}
+
+type whereRow struct {
+	self, total, samples int // samples: all samples in the profile
+	methods              []string
+}
+
+// computeWhere counts, per profile, the samples with a matching leaf (self)
+// and with a matching frame anywhere on the stack (total, once per stack),
+// and the distinct methods the pattern matched.
+func computeWhere(sf *stackFile, m methodMatcher, fqn bool) whereRow {
+	row := whereRow{samples: sf.totalSamples}
+	fm := sf.match(m)
+	names := make(map[string]bool)
+	for fr := range fm.frames {
+		names[displayName(fr, fqn)] = true
+	}
+	for _, i := range fm.stacks {
+		st := &sf.stacks[i]
+		row.total += st.count
+		if fm.frames[st.frames[len(st.frames)-1]] {
+			row.self += st.count
+		}
+	}
+	for name := range names {
+		row.methods = append(row.methods, name)
+	}
+	sort.Strings(row.methods)
+	return row
+}
+
+func cmdWhere(paths []string, sfs []*stackFile, m methodMatcher, fqn bool) {
+	rows := make([]whereRow, len(sfs))
+	matched := make(map[string]bool)
+	first := -1
+	for i, sf := range sfs {
+		rows[i] = computeWhere(sf, m, fqn)
+		for _, name := range rows[i].methods {
+			matched[name] = true
+		}
+		if first < 0 && rows[i].total > 0 {
+			first = i
+		}
+	}
+	if first < 0 {
+		fmt.Printf("no stacks matching '%s' in any of %d profiles\n", m.pattern, len(paths))
+		return
+	}
+
+	names := make([]string, 0, len(matched))
+	for name := range matched {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	const maxNames = 5
+	if len(names) > maxNames {
+		names = append(names[:maxNames], fmt.Sprintf("(+%d more)", len(names)-maxNames))
+	}
+	fmt.Printf("Method: %s (matches %s)\n\n", m.pattern, strings.Join(names, ", "))
+
+	fileW := len("FILE")
+	for _, p := range paths {
+		fileW = max(fileW, len(p))
+	}
+	fmt.Printf("%-*s %7s %7s %9s\n", fileW, "FILE", "SELF%", "TOTAL%", "SAMPLES")
+	for i, r := range rows {
+		if r.total == 0 {
+			fmt.Printf("%-*s %7s %7s %9d  absent\n", fileW, paths[i], "-", "-", 0)
+			continue
+		}
+		fmt.Printf("%-*s %6.*f%% %6.*f%% %9d\n", fileW, paths[i],
+			pctDigits, pctOf(r.self, r.samples), pctDigits, pctOf(r.total, r.samples), r.total)
+	}
+	fmt.Printf("\nFirst seen in: %s (#%d of %d)\n", paths[first], first+1, len(paths))
+}